@@ -0,0 +1,147 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/notjuliet/grove/crypto"
+	"github.com/notjuliet/grove/identity"
+)
+
+type staticDirectory map[string]*identity.Identity
+
+func (d staticDirectory) LookupDID(ctx context.Context, did string) (*identity.Identity, error) {
+	ident, ok := d[did]
+	if !ok {
+		return nil, identity.ErrDIDNotFound
+	}
+	return ident, nil
+}
+
+func testIdentity(did string, key crypto.PrivateKey) *identity.Identity {
+	return &identity.Identity{
+		DID:  did,
+		Keys: map[string]identity.VerificationMethod{"atproto": {Type: "Multikey", PublicKeyMultibase: key.PublicKey().Multibase()}},
+	}
+}
+
+func TestServiceAuth(t *testing.T) {
+	ctx := context.Background()
+	key, err := crypto.GenerateK256()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := staticDirectory{"did:plc:alice": testIdentity("did:plc:alice", key)}
+	v := ServiceAuthValidator{Audience: "did:web:appview.example", Dir: dir}
+
+	t.Run("valid", func(t *testing.T) {
+		token, err := CreateServiceAuth(ctx, key, "did:plc:alice", "did:web:appview.example", "app.bsky.feed.getFeed", 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		claims, err := v.Validate(ctx, token, "app.bsky.feed.getFeed")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if claims.Iss != "did:plc:alice" {
+			t.Fatal("invalid issuer")
+		}
+	})
+
+	t.Run("wrong method", func(t *testing.T) {
+		token, err := CreateServiceAuth(ctx, key, "did:plc:alice", "did:web:appview.example", "app.bsky.feed.getFeed", 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := v.Validate(ctx, token, "com.atproto.repo.createRecord"); !errors.Is(err, ErrInvalidToken) {
+			t.Fatal("expected invalid token error")
+		}
+	})
+
+	t.Run("unbound", func(t *testing.T) {
+		token, err := CreateServiceAuth(ctx, key, "did:plc:alice", "did:web:appview.example", "", 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := v.Validate(ctx, token, "app.bsky.feed.getFeed"); !errors.Is(err, ErrInvalidToken) {
+			t.Fatal("expected invalid token error")
+		}
+		if _, err := v.Validate(ctx, token, ""); err != nil {
+			t.Fatal(err)
+		}
+		lax := v
+		lax.AllowUnbound = true
+		if _, err := lax.Validate(ctx, token, "app.bsky.feed.getFeed"); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("issuer fragment", func(t *testing.T) {
+		labelKey, err := crypto.GenerateP256()
+		if err != nil {
+			t.Fatal(err)
+		}
+		ident := testIdentity("did:plc:labeler", key)
+		ident.Keys["atproto_label"] = identity.VerificationMethod{Type: "Multikey",
+			PublicKeyMultibase: labelKey.PublicKey().Multibase()}
+		v := ServiceAuthValidator{Audience: "did:web:appview.example", Dir: staticDirectory{"did:plc:labeler": ident}}
+
+		for _, tc := range []struct {
+			iss   string
+			key   crypto.PrivateKey
+			valid bool
+		}{
+			{"did:plc:labeler", key, true},
+			{"did:plc:labeler", labelKey, false},
+			{"did:plc:labeler#atproto_labeler", labelKey, true},
+			{"did:plc:labeler#atproto_labeler", key, false},
+			{"did:plc:labeler#atproto_label", labelKey, true},
+			{"did:plc:labeler#other", key, false},
+			{"did:plc:labeler#", key, false},
+		} {
+			token, err := CreateServiceAuth(ctx, tc.key, tc.iss, "did:web:appview.example", "", 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := v.Validate(ctx, token, ""); (err == nil) != tc.valid {
+				t.Errorf("%s: unexpected result %v", tc.iss, err)
+			}
+		}
+	})
+
+	t.Run("wrong audience", func(t *testing.T) {
+		token, err := CreateServiceAuth(ctx, key, "did:plc:alice", "did:web:other.example", "", 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := v.Validate(ctx, token, ""); !errors.Is(err, ErrInvalidToken) {
+			t.Fatal("expected invalid token error")
+		}
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		token, err := CreateServiceAuth(ctx, key, "did:plc:alice", "did:web:appview.example", "", -time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := v.Validate(ctx, token, ""); !errors.Is(err, ErrTokenExpired) {
+			t.Fatal("expected expired token error")
+		}
+	})
+
+	t.Run("wrong key", func(t *testing.T) {
+		other, err := crypto.GenerateP256()
+		if err != nil {
+			t.Fatal(err)
+		}
+		token, err := CreateServiceAuth(ctx, other, "did:plc:alice", "did:web:appview.example", "", 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := v.Validate(ctx, token, ""); !errors.Is(err, ErrInvalidToken) {
+			t.Fatal("expected invalid token error")
+		}
+	})
+}
@@ -0,0 +1,81 @@
+// Package blob stores the media files referenced by records, such as images and videos, which unlike
+// repository blocks are streamed rather than held in memory.
+package blob
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/notjuliet/grove/cid"
+	"github.com/notjuliet/grove/errdefs"
+)
+
+var (
+	ErrNotFound = errors.New("blob not found")
+	// Matches errdefs.ErrLimit.
+	ErrTooLarge = errdefs.New(errdefs.ErrLimit, "blob exceeds maximum size")
+)
+
+// Metadata of a stored blob.
+type Blob struct {
+	// CID of the blob content, with the raw codec.
+	Cid      cid.Cid
+	MimeType string
+	Size     int64
+}
+
+type PutOptions struct {
+	// MIME type of the blob, detected from its first bytes if empty.
+	MimeType string
+	// Maximum size in bytes, beyond which Put fails with ErrTooLarge. Zero means no limit.
+	MaxSize int64
+}
+
+// Blob storage keyed by the CID of the content. Implementations must be safe for concurrent use.
+type BlobStore interface {
+	// Reads r to its end and stores its content, hashing it on the way. Storing a blob which is already
+	// present is not an error.
+	Put(ctx context.Context, r io.Reader, opts PutOptions) (Blob, error)
+	// Opens a stored blob for reading, or returns ErrNotFound. The reader must be closed.
+	Get(ctx context.Context, c cid.Cid) (io.ReadCloser, Blob, error)
+	// Removes a blob. Removing a blob which is not present is not an error.
+	Delete(ctx context.Context, c cid.Cid) error
+}
+
+// Streams a blob into w while hashing it, enforcing the options and detecting its MIME type. Used by
+// BlobStore implementations.
+func copyBlob(w io.Writer, r io.Reader, opts PutOptions) (Blob, error) {
+	br := bufio.NewReaderSize(r, 512)
+	mimeType := opts.MimeType
+	if mimeType == "" {
+		// sniffing looks at most at 512 bytes
+		head, err := br.Peek(512)
+		if err != nil && err != io.EOF {
+			return Blob{}, err
+		}
+		mimeType = http.DetectContentType(head)
+	}
+
+	h := sha256.New()
+	src := io.Reader(br)
+	if opts.MaxSize > 0 {
+		src = io.LimitReader(br, opts.MaxSize+1)
+	}
+	size, err := io.Copy(io.MultiWriter(w, h), src)
+	if err != nil {
+		return Blob{}, err
+	}
+	if opts.MaxSize > 0 && size > opts.MaxSize {
+		return Blob{}, fmt.Errorf("%w: more than %d bytes", ErrTooLarge, opts.MaxSize)
+	}
+	c, err := cid.FromDigest(cid.CodecRaw, h.Sum(nil))
+	if err != nil {
+		return Blob{}, err
+	}
+	return Blob{Cid: c, MimeType: mimeType, Size: size}, nil
+}
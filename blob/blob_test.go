@@ -0,0 +1,143 @@
+package blob
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/notjuliet/grove/cbor"
+	"github.com/notjuliet/grove/cid"
+)
+
+func TestFile(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	bs, err := OpenFileBlobStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	png := append([]byte("\x89PNG\r\n\x1a\n"), bytes.Repeat([]byte{1}, 4096)...)
+	b, err := bs.Put(ctx, bytes.NewReader(png), PutOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, _ := cid.Create(cid.CodecRaw, png)
+	if b.Cid.String() != expected.String() || b.Size != int64(len(png)) || b.MimeType != "image/png" {
+		t.Fatalf("unexpected blob %+v", b)
+	}
+
+	r, got, err := bs.Get(ctx, b.Cid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(r)
+	r.Close()
+	if err != nil || !bytes.Equal(data, png) || got.MimeType != "image/png" || got.Size != b.Size {
+		t.Fatal("unexpected blob content")
+	}
+
+	text, err := bs.Put(ctx, strings.NewReader("hello"), PutOptions{MimeType: "text/markdown", MaxSize: 5})
+	if err != nil || text.MimeType != "text/markdown" {
+		t.Fatalf("unexpected blob %+v: %v", text, err)
+	}
+	// storing a blob again keeps its metadata
+	again, err := bs.Put(ctx, strings.NewReader("hello"), PutOptions{MimeType: "text/plain"})
+	if err != nil || again.MimeType != "text/markdown" {
+		t.Fatalf("unexpected blob %+v: %v", again, err)
+	}
+	if r, got, err := bs.Get(ctx, text.Cid); err != nil || got.MimeType != "text/markdown" {
+		t.Fatalf("unexpected blob %+v: %v", got, err)
+	} else {
+		r.Close()
+	}
+	if temps, _ := filepath.Glob(filepath.Join(dir, tempPrefix+"*")); len(temps) != 0 {
+		t.Fatalf("temporary files left behind: %v", temps)
+	}
+	if _, err := bs.Put(ctx, strings.NewReader("hello!"), PutOptions{MaxSize: 5}); !errors.Is(err, ErrTooLarge) {
+		t.Fatal("expected too large error")
+	}
+
+	if err := bs.Delete(ctx, b.Cid); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := bs.Get(ctx, b.Cid); !errors.Is(err, ErrNotFound) {
+		t.Fatal("expected not found error")
+	}
+	if err := bs.Delete(ctx, b.Cid); err != nil {
+		t.Fatal(err)
+	}
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := bs.Put(canceled, bytes.NewReader(png), PutOptions{MimeType: "image/png"}); !errors.Is(err, context.Canceled) {
+		t.Fatal("expected canceled error")
+	}
+}
+
+func TestBlobRef(t *testing.T) {
+	c, _ := cid.Create(cid.CodecRaw, []byte("hello"))
+	current := Blob{Cid: c, MimeType: "text/plain", Size: 5}.Ref()
+	legacy := NewLegacyBlobRef(c, "text/plain")
+
+	for _, tc := range []struct {
+		ref  BlobRef
+		json string
+	}{
+		{current, `{"$type":"blob","mimeType":"text/plain","ref":{"$link":"` + c.String() + `"},"size":5}`},
+		{legacy, `{"cid":"` + c.String() + `","mimeType":"text/plain"}`},
+	} {
+		b, err := json.Marshal(tc.ref)
+		if err != nil || string(b) != tc.json {
+			t.Fatalf("unexpected JSON %s: %v", b, err)
+		}
+		var fromJSON BlobRef
+		if err := json.Unmarshal(b, &fromJSON); err != nil {
+			t.Fatal(err)
+		}
+		enc, err := cbor.Encode(map[string]any{"blob": tc.ref})
+		if err != nil {
+			t.Fatal(err)
+		}
+		v, err := cbor.Decode(enc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var fromCBOR BlobRef
+		if err := fromCBOR.FromData(v.(map[string]any)["blob"].(map[string]any)); err != nil {
+			t.Fatal(err)
+		}
+		for _, r := range []BlobRef{fromJSON, fromCBOR} {
+			if r.Cid().String() != c.String() || r.MimeType() != "text/plain" || r.IsLegacy() != tc.ref.IsLegacy() {
+				t.Fatalf("unexpected ref %+v", r)
+			}
+			if size, ok := r.Size(); ok == r.IsLegacy() || ok && size != 5 {
+				t.Fatalf("unexpected size %d, %t", size, ok)
+			}
+		}
+		b, err = fromCBOR.MarshalCBOR()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var again BlobRef
+		if err := again.UnmarshalCBOR(b); err != nil || again.IsLegacy() != tc.ref.IsLegacy() {
+			t.Fatalf("unexpected ref %+v: %v", again, err)
+		}
+	}
+
+	for _, s := range []string{`{}`, `"blob"`, `{"$type":"blob","mimeType":"text/plain","size":5}`,
+		`{"$type":"blob","ref":{"$link":"` + c.String() + `"},"size":5}`, `{"cid":"nope","mimeType":"text/plain"}`,
+		`{"$type":"blob","mimeType":"text/plain","ref":{"$link":"` + c.String() + `"},"size":-1}`} {
+		if err := json.Unmarshal([]byte(s), new(BlobRef)); err == nil {
+			t.Errorf("expected error for %s", s)
+		}
+	}
+	if _, err := json.Marshal(BlobRef{}); err == nil {
+		t.Fatal("expected error for empty ref")
+	}
+}
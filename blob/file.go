@@ -0,0 +1,166 @@
+package blob
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/notjuliet/grove/cid"
+)
+
+const tempPrefix = ".tmp-"
+
+// Filesystem blob store keeping each blob in its own file next to a JSON file holding its metadata, sharded
+// into directories by the first byte of the CID digest. Blobs and their metadata are written to temporary files
+// and renamed into place, so readers never observe partial files. A blob stored again keeps its first metadata.
+type FileBlobStore struct {
+	dir string
+}
+
+// metadata file contents
+type fileMeta struct {
+	MimeType string `json:"mimeType"`
+}
+
+// Opens a file blob store rooted at dir, creating the directory if needed.
+func OpenFileBlobStore(dir string) (*FileBlobStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &FileBlobStore{dir: dir}, nil
+}
+
+func (f *FileBlobStore) path(c cid.Cid) string {
+	shard := "00"
+	if len(c.Digest) > 0 {
+		shard = hex.EncodeToString(c.Digest[:1])
+	}
+	return filepath.Join(f.dir, shard, c.String())
+}
+
+func (f *FileBlobStore) Put(ctx context.Context, r io.Reader, opts PutOptions) (Blob, error) {
+	tmp, err := os.CreateTemp(f.dir, tempPrefix)
+	if err != nil {
+		return Blob{}, err
+	}
+	defer os.Remove(tmp.Name())
+	b, err := copyBlob(tmp, &contextReader{ctx: ctx, r: r}, opts)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return Blob{}, err
+	}
+
+	p := f.path(b.Cid)
+	if _, err := os.Stat(p); err == nil {
+		// already stored, with the metadata of the first upload
+		return f.stored(b)
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return Blob{}, err
+	}
+	meta, err := json.Marshal(fileMeta{MimeType: b.MimeType})
+	if err != nil {
+		return Blob{}, err
+	}
+	// the metadata goes first so that a blob file never lacks it
+	if err := f.writeFile(p+".json", meta); err != nil {
+		return Blob{}, err
+	}
+	if err := os.Rename(tmp.Name(), p); err != nil {
+		return Blob{}, err
+	}
+	return b, nil
+}
+
+// returns a blob already in the store with its stored MIME type
+func (f *FileBlobStore) stored(b Blob) (Blob, error) {
+	data, err := os.ReadFile(f.path(b.Cid) + ".json")
+	if err != nil {
+		return Blob{}, err
+	}
+	var meta fileMeta
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return Blob{}, err
+	}
+	b.MimeType = meta.MimeType
+	return b, nil
+}
+
+// atomically replaces the file at p, so that a crash leaves either the old or the new contents
+func (f *FileBlobStore) writeFile(p string, data []byte) error {
+	tmp, err := os.CreateTemp(f.dir, tempPrefix)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), p)
+}
+
+func (f *FileBlobStore) Get(ctx context.Context, c cid.Cid) (io.ReadCloser, Blob, error) {
+	p := f.path(c)
+	file, err := os.Open(p)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, Blob{}, ErrNotFound
+	}
+	if err != nil {
+		return nil, Blob{}, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, Blob{}, err
+	}
+	var meta fileMeta
+	data, err := os.ReadFile(p + ".json")
+	if err == nil {
+		err = json.Unmarshal(data, &meta)
+	}
+	if err != nil {
+		file.Close()
+		return nil, Blob{}, err
+	}
+	return file, Blob{Cid: c, MimeType: meta.MimeType, Size: info.Size()}, nil
+}
+
+func (f *FileBlobStore) Delete(ctx context.Context, c cid.Cid) error {
+	p := f.path(c)
+	for _, name := range []string{p, p + ".json"} {
+		if err := os.Remove(name); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// reader failing once its context is done, to abort long uploads
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (cr *contextReader) Read(p []byte) (int, error) {
+	if err := cr.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return cr.r.Read(p)
+}
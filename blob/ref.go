@@ -0,0 +1,148 @@
+package blob
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/notjuliet/grove/cbor"
+	"github.com/notjuliet/grove/cid"
+	"github.com/notjuliet/grove/data"
+)
+
+// Reference from a record to a blob, in either of its wire forms: the current one,
+// {"$type": "blob", "ref": link, "mimeType": ..., "size": ...}, or the legacy one written by early clients,
+// {"cid": string, "mimeType": ...}, which has no size. A reference encodes to lexicon JSON and CBOR in the form
+// it was created or decoded in.
+type BlobRef struct {
+	cid      cid.Cid
+	mimeType string
+	size     int64
+	legacy   bool
+}
+
+// Returns a reference to a blob in the current form.
+func NewBlobRef(c cid.Cid, mimeType string, size int64) BlobRef {
+	return BlobRef{cid: c, mimeType: mimeType, size: size}
+}
+
+// Returns a reference to a blob in the legacy form, without its size.
+func NewLegacyBlobRef(c cid.Cid, mimeType string) BlobRef {
+	return BlobRef{cid: c, mimeType: mimeType, legacy: true}
+}
+
+// Returns a reference to a stored blob.
+func (b Blob) Ref() BlobRef {
+	return NewBlobRef(b.Cid, b.MimeType, b.Size)
+}
+
+// Returns the CID of the blob content.
+func (r BlobRef) Cid() cid.Cid {
+	return r.cid
+}
+
+func (r BlobRef) MimeType() string {
+	return r.mimeType
+}
+
+// Returns the size of the blob in bytes, or false for legacy references, which do not record it.
+func (r BlobRef) Size() (int64, bool) {
+	return r.size, !r.legacy
+}
+
+// Reports whether the reference is in the legacy form.
+func (r BlobRef) IsLegacy() bool {
+	return r.legacy
+}
+
+// Returns the reference in the data model, as decoded by the cbor package.
+func (r BlobRef) ToData() (map[string]any, error) {
+	if len(r.cid.Bytes) == 0 {
+		return nil, errors.New("blob reference without a CID")
+	}
+	if r.legacy {
+		return map[string]any{"cid": r.cid.String(), "mimeType": r.mimeType}, nil
+	}
+	return map[string]any{"$type": "blob", "ref": r.cid.Link(), "mimeType": r.mimeType, "size": r.size}, nil
+}
+
+// Sets the reference from the data model, in either form.
+func (r *BlobRef) FromData(m map[string]any) error {
+	mimeType, _ := m["mimeType"].(string)
+	if mimeType == "" {
+		return errors.New("blob reference without a MIME type")
+	}
+	if typ, _ := m["$type"].(string); typ == "blob" {
+		link, ok := m["ref"].(cid.CidLink)
+		if !ok {
+			return errors.New("blob reference without a ref link")
+		}
+		c, err := link.Cid()
+		if err != nil {
+			return err
+		}
+		var size int64
+		switch n := m["size"].(type) {
+		case int64:
+			size = n
+		case uint64:
+			size = int64(min(n, 1<<63-1))
+		default:
+			return fmt.Errorf("blob reference with an invalid size %v", m["size"])
+		}
+		if size < 0 {
+			return fmt.Errorf("blob reference with a negative size %d", size)
+		}
+		*r = NewBlobRef(c, mimeType, size)
+		return nil
+	}
+	s, ok := m["cid"].(string)
+	if !ok {
+		return errors.New("not a blob reference")
+	}
+	c, err := cid.Parse(s)
+	if err != nil {
+		return fmt.Errorf("legacy blob reference with an invalid CID: %w", err)
+	}
+	*r = NewLegacyBlobRef(c, mimeType)
+	return nil
+}
+
+func (r BlobRef) MarshalJSON() ([]byte, error) {
+	m, err := r.ToData()
+	if err != nil {
+		return nil, err
+	}
+	return data.MarshalJSON(m)
+}
+
+func (r *BlobRef) UnmarshalJSON(b []byte) error {
+	v, err := data.UnmarshalJSON(b)
+	if err != nil {
+		return err
+	}
+	m, ok := v.(map[string]any)
+	if !ok {
+		return errors.New("blob reference is not an object")
+	}
+	return r.FromData(m)
+}
+
+func (r BlobRef) MarshalCBOR() ([]byte, error) {
+	m, err := r.ToData()
+	if err != nil {
+		return nil, err
+	}
+	return cbor.Encode(m)
+}
+
+func (r *BlobRef) UnmarshalCBOR(b []byte) error {
+	v, err := cbor.Decode(b)
+	if err != nil {
+		return err
+	}
+	m, ok := v.(map[string]any)
+	if !ok {
+		return errors.New("blob reference is not an object")
+	}
+	return r.FromData(m)
+}
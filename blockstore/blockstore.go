@@ -0,0 +1,52 @@
+package blockstore
+
+import (
+	"context"
+	"errors"
+	"iter"
+
+	"github.com/notjuliet/grove/cid"
+)
+
+var ErrNotFound = errors.New("block not found")
+
+type Block struct {
+	Cid  cid.Cid
+	Data []byte
+}
+
+// Content-addressed block storage. Implementations must be safe for concurrent use, and do not verify that
+// block data matches its CID; callers storing untrusted blocks are expected to check them first. Methods fail
+// with the error of ctx once it is done, so that long operations over many blocks can be aborted.
+type Blockstore interface {
+	// Returns the data of a block, or ErrNotFound if it is not stored.
+	Get(ctx context.Context, c cid.Cid) ([]byte, error)
+	// Stores a block. Storing a block which is already present is not an error.
+	Put(ctx context.Context, c cid.Cid, data []byte) error
+	// Stores several blocks at once, amortizing locking, syncing, or transaction costs where the backend
+	// allows it. Blocks may be partially stored if an error is returned.
+	PutMany(ctx context.Context, blocks []Block) error
+	// Reports whether a block is stored.
+	Has(ctx context.Context, c cid.Cid) (bool, error)
+	// Removes a block. Removing a block which is not present is not an error.
+	Delete(ctx context.Context, c cid.Cid) error
+	// Iterates over the CIDs of all stored blocks, in no particular order. Iteration stops after the first
+	// non-nil error.
+	Keys(ctx context.Context) iter.Seq2[cid.Cid, error]
+}
+
+// Implemented by blockstores which can report the size of a block without reading its data, such as
+// MemoryBlockstore, FileBlockstore and SQLBlockstore. GC uses it to account for deleted blocks.
+type BlockSizer interface {
+	// Returns the size in bytes of the data of a block, or ErrNotFound if it is not stored.
+	BlockSize(ctx context.Context, c cid.Cid) (int64, error)
+}
+
+// returns the size of a block, reading it unless the blockstore is a BlockSizer
+func blockSize(ctx context.Context, bs Blockstore, c cid.Cid) (int64, error) {
+	if s, ok := bs.(BlockSizer); ok {
+		return s.BlockSize(ctx, c)
+	}
+	data, err := bs.Get(ctx, c)
+	return int64(len(data)), err
+}
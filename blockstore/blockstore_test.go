@@ -0,0 +1,430 @@
+package blockstore
+
+import (
+	"bytes"
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/notjuliet/grove/cbor"
+	"github.com/notjuliet/grove/cid"
+	"github.com/notjuliet/grove/internal/sqltest"
+	"github.com/notjuliet/grove/metrics"
+)
+
+func testBlocks(t *testing.T, n int) ([]cid.Cid, [][]byte) {
+	var cids []cid.Cid
+	var data [][]byte
+	for i := range n {
+		d := []byte(fmt.Sprintf("block %d", i))
+		c, err := cid.Create(cid.CodecRaw, d)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cids = append(cids, c)
+		data = append(data, d)
+	}
+	return cids, data
+}
+
+// exercises the behaviour shared by every Blockstore implementation
+func testBlockstore(t *testing.T, bs Blockstore) {
+	ctx := context.Background()
+	cids, data := testBlocks(t, 3)
+
+	if _, err := bs.Get(ctx, cids[0]); err != ErrNotFound {
+		t.Fatalf("expected not found error, got %v", err)
+	}
+	for i := range cids {
+		if err := bs.Put(ctx, cids[i], data[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// duplicate puts are ignored
+	if err := bs.Put(ctx, cids[0], data[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, c := range cids {
+		got, err := bs.Get(ctx, c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, data[i]) {
+			t.Fatal("invalid block data")
+		}
+		if ok, err := bs.Has(ctx, c); err != nil || !ok {
+			t.Fatal("expected block to be present")
+		}
+		if size, err := blockSize(ctx, bs, c); err != nil || size != int64(len(data[i])) {
+			t.Fatalf("unexpected size %d, %v", size, err)
+		}
+	}
+
+	keys := map[string]bool{}
+	for c, err := range bs.Keys(ctx) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys[c.String()] = true
+	}
+	if len(keys) != len(cids) {
+		t.Fatalf("expected %d keys, got %d", len(cids), len(keys))
+	}
+
+	more, moreData := testBlocks(t, 5)
+	var batch []Block
+	for i := range more {
+		batch = append(batch, Block{more[i], moreData[i]})
+	}
+	if err := bs.PutMany(ctx, batch[2:]); err != nil {
+		t.Fatal(err)
+	}
+	for _, blk := range batch {
+		if ok, err := bs.Has(ctx, blk.Cid); err != nil || !ok {
+			t.Fatal("expected batched block to be present")
+		}
+	}
+	for _, blk := range batch[3:] {
+		if err := bs.Delete(ctx, blk.Cid); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := bs.Delete(ctx, cids[1]); err != nil {
+		t.Fatal(err)
+	}
+	if err := bs.Delete(ctx, cids[1]); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := bs.Has(ctx, cids[1]); err != nil || ok {
+		t.Fatal("expected block to be deleted")
+	}
+	if _, err := bs.Get(ctx, cids[1]); err != ErrNotFound {
+		t.Fatalf("expected not found error, got %v", err)
+	}
+	if _, err := blockSize(ctx, bs, cids[1]); err != ErrNotFound {
+		t.Fatalf("expected not found error, got %v", err)
+	}
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := bs.Get(canceled, cids[0]); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if err := bs.Put(canceled, cids[1], data[1]); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+}
+
+func TestMemory(t *testing.T) {
+	bs := NewMemoryBlockstore()
+	testBlockstore(t, bs)
+	if bs.Len() != 2 || bs.Size() != int64(len("block 0")+len("block 2")) {
+		t.Fatal("invalid size accounting")
+	}
+}
+
+func TestInstrument(t *testing.T) {
+	counts := map[string]int64{}
+	var observed int
+	bs := Instrument(NewMemoryBlockstore(), metrics.Funcs{
+		OnCount:   func(name string, delta int64) { counts[name] += delta },
+		OnObserve: func(name string, value float64) { observed++ },
+	})
+	testBlockstore(t, bs)
+	want := map[string]int64{
+		"blockstore.get.hits":      3,
+		"blockstore.get.misses":    2,
+		"blockstore.get.bytes":     3 * int64(len("block 0")),
+		"blockstore.has.hits":      8,
+		"blockstore.has.misses":    1,
+		"blockstore.put.blocks":    7,
+		"blockstore.put.bytes":     7 * int64(len("block 0")),
+		"blockstore.delete.blocks": 4,
+		// calls with a canceled context
+		"blockstore.errors": 2,
+	}
+	if !reflect.DeepEqual(counts, want) || observed != 5+5+2 {
+		t.Fatalf("unexpected counts %v and %d observations", counts, observed)
+	}
+}
+
+func TestGC(t *testing.T) {
+	ctx := context.Background()
+	bs := NewMemoryBlockstore()
+	put := func(v any) cid.Cid {
+		data, err := cbor.Encode(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c, err := cid.Create(cid.CodecCbor, data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := bs.Put(ctx, c, data); err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+
+	cids, data := testBlocks(t, 3)
+	for i := range cids {
+		if err := bs.Put(ctx, cids[i], data[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	missing, _ := cid.Create(cid.CodecCbor, []byte("missing"))
+	leaf := put(map[string]any{"raw": cids[0].Link(), "missing": missing.Link()})
+	root := put(map[string]any{"list": []any{leaf.Link(), cids[1].Link()}})
+	unreachable := put(map[string]any{"leaf": leaf.Link()})
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := GC(canceled, bs, []cid.Cid{root}); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+
+	// only the reachable DAG-CBOR blocks, and the missing link, are read
+	gets := map[string]int64{}
+	counted := Instrument(bs, metrics.Funcs{OnCount: func(name string, delta int64) { gets[name] += delta }})
+	size := bs.Size()
+	res, err := GC(ctx, counted, []cid.Cid{root})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Kept != 4 || res.Deleted != 2 || res.Bytes != size-bs.Size() {
+		t.Fatalf("unexpected result %+v", res)
+	}
+	if gets["blockstore.get.hits"] != 2 || gets["blockstore.get.misses"] != 1 {
+		t.Fatalf("unexpected reads %v", gets)
+	}
+	for _, c := range []cid.Cid{root, leaf, cids[0], cids[1]} {
+		if ok, _ := bs.Has(ctx, c); !ok {
+			t.Fatalf("reachable block %s was deleted", c)
+		}
+	}
+	if ok, _ := bs.Has(ctx, unreachable); ok {
+		t.Fatal("unreachable block was kept")
+	}
+
+	if _, err := GC(ctx, bs, []cid.Cid{missing}); !errors.Is(err, ErrNotFound) {
+		t.Fatal("expected missing root error")
+	}
+}
+
+func TestFile(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	bs, err := OpenFileBlockstore(ctx, dir, FileOptions{Sync: SyncAll})
+	if err != nil {
+		t.Fatal(err)
+	}
+	testBlockstore(t, bs)
+
+	t.Run("scan", func(t *testing.T) {
+		cids, _ := testBlocks(t, 3)
+		if err := os.WriteFile(filepath.Join(dir, shard(cids[0]), tempPrefix+"1"), []byte("partial"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(bs.path(cids[2]), []byte("corrupted"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		bs, err := OpenFileBlockstore(ctx, dir, FileOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := bs.Scan(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Blocks != 1 || res.TempFiles != 1 || len(res.Corrupt) != 1 || !bytes.Equal(res.Corrupt[0].Bytes, cids[2].Bytes) {
+			t.Fatalf("unexpected scan result %+v", res)
+		}
+		if ok, _ := bs.Has(ctx, cids[2]); ok {
+			t.Fatal("expected corrupt block to be removed")
+		}
+	})
+}
+
+// serves the statements of a SQLBlockstore from a map, staging the writes of transactions until they commit
+type sqlBlocks struct {
+	// expected statements, by query
+	stmts map[string]string
+	// fails the statements for which it returns an error, if set
+	fault func(s sqltest.Stmt) error
+
+	mtx    sync.Mutex
+	blocks map[string][]byte
+	staged map[int][]func()
+}
+
+func newSQLBlocks(dialect SQLDialect) *sqlBlocks {
+	stmts := map[string]string{
+		"CREATE TABLE IF NOT EXISTS blocks (cid BLOB PRIMARY KEY, data BLOB NOT NULL)": "create",
+		"SELECT data FROM blocks WHERE cid = ?":                                        "get",
+		"SELECT LENGTH(data) FROM blocks WHERE cid = ?":                                "size",
+		"INSERT INTO blocks (cid, data) VALUES (?, ?) ON CONFLICT (cid) DO NOTHING":    "put",
+		"SELECT 1 FROM blocks WHERE cid = ?":                                           "has",
+		"DELETE FROM blocks WHERE cid = ?":                                             "delete",
+		"SELECT cid FROM blocks":                                                       "keys",
+	}
+	if dialect == Postgres {
+		pg := map[string]string{}
+		for q, name := range stmts {
+			pg[sqltest.Numbered(strings.ReplaceAll(q, "BLOB", "BYTEA"))] = name
+		}
+		stmts = pg
+	}
+	return &sqlBlocks{stmts: stmts, blocks: map[string][]byte{}, staged: map[int][]func(){}}
+}
+
+func (b *sqlBlocks) handle(s sqltest.Stmt) ([][]driver.Value, error) {
+	name, ok := b.stmts[s.Query]
+	if !ok {
+		return nil, fmt.Errorf("unexpected statement %q", s.Query)
+	}
+	if b.fault != nil {
+		if err := b.fault(s); err != nil {
+			return nil, err
+		}
+	}
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	write := func(fn func()) {
+		if s.Tx == 0 {
+			fn()
+		} else {
+			b.staged[s.Tx] = append(b.staged[s.Tx], fn)
+		}
+	}
+	switch name {
+	case "get", "size", "has":
+		data, ok := b.blocks[string(s.Args[0].([]byte))]
+		switch {
+		case !ok:
+			return nil, nil
+		case name == "size":
+			return [][]driver.Value{{int64(len(data))}}, nil
+		case name == "has":
+			return [][]driver.Value{{int64(1)}}, nil
+		}
+		return [][]driver.Value{{data}}, nil
+	case "put":
+		key, data := string(s.Args[0].([]byte)), s.Args[1].([]byte)
+		write(func() {
+			if _, ok := b.blocks[key]; !ok {
+				b.blocks[key] = data
+			}
+		})
+	case "delete":
+		key := string(s.Args[0].([]byte))
+		write(func() { delete(b.blocks, key) })
+	case "keys":
+		var rows [][]driver.Value
+		for k := range b.blocks {
+			rows = append(rows, []driver.Value{[]byte(k)})
+		}
+		return rows, nil
+	}
+	return nil, nil
+}
+
+func (b *sqlBlocks) endTx(tx int, commit bool) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	if commit {
+		for _, fn := range b.staged[tx] {
+			fn()
+		}
+	}
+	delete(b.staged, tx)
+}
+
+func TestSQL(t *testing.T) {
+	ctx := context.Background()
+	for _, dialect := range []SQLDialect{SQLite, Postgres} {
+		blocks := newSQLBlocks(dialect)
+		rec := sqltest.NewRecorder(blocks.handle)
+		rec.EndTx = blocks.endTx
+		db := rec.Open()
+		defer db.Close()
+
+		if _, err := OpenSQLBlockstore(ctx, db, dialect, "blocks; DROP TABLE x"); err == nil {
+			t.Fatal("expected invalid table name error")
+		}
+		if len(rec.Statements()) != 0 {
+			t.Fatal("statements sent for an invalid table name")
+		}
+		bs, err := OpenSQLBlockstore(ctx, db, dialect, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer bs.Close()
+		testBlockstore(t, bs)
+		if len(blocks.staged) != 0 {
+			t.Fatal("transactions left open")
+		}
+	}
+
+	t.Run("atomic batch", func(t *testing.T) {
+		blocks := newSQLBlocks(SQLite)
+		rec := sqltest.NewRecorder(blocks.handle)
+		rec.EndTx = blocks.endTx
+		db := rec.Open()
+		defer db.Close()
+		bs, err := OpenSQLBlockstore(ctx, db, SQLite, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer bs.Close()
+
+		cids, data := testBlocks(t, 5)
+		var batch []Block
+		for i := range cids {
+			batch = append(batch, Block{Cid: cids[i], Data: data[i]})
+		}
+		// the third put of the batch fails
+		var puts int
+		blocks.fault = func(s sqltest.Stmt) error {
+			if blocks.stmts[s.Query] != "put" {
+				return nil
+			}
+			if s.Tx == 0 {
+				t.Error("put outside the batch transaction")
+			}
+			if puts++; puts == 3 {
+				return errors.New("injected failure")
+			}
+			return nil
+		}
+		if err := bs.PutMany(ctx, batch); err == nil {
+			t.Fatal("expected the batch to fail")
+		}
+		blocks.fault = nil
+		if puts != 3 {
+			t.Fatalf("expected the batch to stop at the failing put, got %d puts", puts)
+		}
+		for _, blk := range batch {
+			if ok, err := bs.Has(ctx, blk.Cid); err != nil || ok {
+				t.Fatalf("block %s of the failed batch is visible", blk.Cid)
+			}
+		}
+		if err := bs.PutMany(ctx, batch); err != nil {
+			t.Fatal(err)
+		}
+		for _, blk := range batch {
+			if ok, err := bs.Has(ctx, blk.Cid); err != nil || !ok {
+				t.Fatalf("block %s of the retried batch is missing", blk.Cid)
+			}
+		}
+	})
+}
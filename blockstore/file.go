@@ -0,0 +1,303 @@
+package blockstore
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"iter"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/notjuliet/grove/cid"
+)
+
+// Durability of writes to a FileBlockstore.
+type SyncPolicy int
+
+const (
+	// Leave flushing to the operating system. Blocks written shortly before a crash may be lost, but are
+	// never left partially written.
+	SyncNone SyncPolicy = iota
+	// Flush each block file before it is renamed into place.
+	SyncFile
+	// Flush each block file and its shard directory, so a block is durable once Put returns.
+	SyncAll
+)
+
+const tempPrefix = ".tmp-"
+
+type FileOptions struct {
+	Sync SyncPolicy
+	// Run Scan when opening the store, failing if it returns an error.
+	ScanOnOpen bool
+}
+
+// Filesystem blockstore storing each block in its own file, sharded into directories by the first byte of
+// the CID digest. Writes go to a temporary file which is renamed into place, so readers never observe
+// partial blocks.
+type FileBlockstore struct {
+	dir  string
+	opts FileOptions
+}
+
+// Opens a file blockstore rooted at dir, creating the directory if needed.
+func OpenFileBlockstore(ctx context.Context, dir string, opts FileOptions) (*FileBlockstore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	f := &FileBlockstore{dir: dir, opts: opts}
+	if opts.ScanOnOpen {
+		if _, err := f.Scan(ctx); err != nil {
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+func shard(c cid.Cid) string {
+	if len(c.Digest) == 0 {
+		return "00"
+	}
+	return hex.EncodeToString(c.Digest[:1])
+}
+
+func (f *FileBlockstore) path(c cid.Cid) string {
+	return filepath.Join(f.dir, shard(c), c.String())
+}
+
+func (f *FileBlockstore) Get(ctx context.Context, c cid.Cid) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(f.path(c))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	return data, err
+}
+
+func (f *FileBlockstore) BlockSize(ctx context.Context, c cid.Cid) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	info, err := os.Stat(f.path(c))
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, ErrNotFound
+	}
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+func (f *FileBlockstore) Put(ctx context.Context, c cid.Cid, data []byte) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	written, err := f.write(c, data)
+	if err != nil || !written || f.opts.Sync < SyncAll {
+		return err
+	}
+	return syncDir(filepath.Dir(f.path(c)))
+}
+
+// Writes every block before syncing the affected shard directories once each.
+func (f *FileBlockstore) PutMany(ctx context.Context, blocks []Block) error {
+	dirs := map[string]bool{}
+	for _, blk := range blocks {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		written, err := f.write(blk.Cid, blk.Data)
+		if err != nil {
+			return err
+		}
+		if written {
+			dirs[filepath.Dir(f.path(blk.Cid))] = true
+		}
+	}
+	if f.opts.Sync < SyncAll {
+		return nil
+	}
+	for dir := range dirs {
+		if err := syncDir(dir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// atomically writes a block file, reporting false if it already existed
+func (f *FileBlockstore) write(c cid.Cid, data []byte) (bool, error) {
+	p := f.path(c)
+	if _, err := os.Stat(p); err == nil {
+		return false, nil
+	}
+	dir := filepath.Dir(p)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return false, err
+	}
+
+	tmp, err := os.CreateTemp(dir, tempPrefix)
+	if err != nil {
+		return false, err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return false, err
+	}
+	if f.opts.Sync >= SyncFile {
+		if err := tmp.Sync(); err != nil {
+			tmp.Close()
+			return false, err
+		}
+	}
+	if err := tmp.Close(); err != nil {
+		return false, err
+	}
+	if err := os.Rename(tmp.Name(), p); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+func (f *FileBlockstore) Has(ctx context.Context, c cid.Cid) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	_, err := os.Stat(f.path(c))
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (f *FileBlockstore) Delete(ctx context.Context, c cid.Cid) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	err := os.Remove(f.path(c))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// walks the shard directories, yielding the path of every file including temporary ones
+func (f *FileBlockstore) files(ctx context.Context) iter.Seq2[string, error] {
+	return func(yield func(string, error) bool) {
+		shards, err := os.ReadDir(f.dir)
+		if err != nil {
+			yield("", err)
+			return
+		}
+		for _, s := range shards {
+			if !s.IsDir() {
+				continue
+			}
+			entries, err := os.ReadDir(filepath.Join(f.dir, s.Name()))
+			if err != nil {
+				yield("", err)
+				return
+			}
+			for _, e := range entries {
+				if err := ctx.Err(); err != nil {
+					yield("", err)
+					return
+				}
+				if !yield(filepath.Join(f.dir, s.Name(), e.Name()), nil) {
+					return
+				}
+			}
+		}
+	}
+}
+
+func (f *FileBlockstore) Keys(ctx context.Context) iter.Seq2[cid.Cid, error] {
+	return func(yield func(cid.Cid, error) bool) {
+		for p, err := range f.files(ctx) {
+			if err != nil {
+				yield(cid.Cid{}, err)
+				return
+			}
+			c, err := cid.Parse(filepath.Base(p))
+			if err != nil {
+				continue
+			}
+			if !yield(c, nil) {
+				return
+			}
+		}
+	}
+}
+
+// Outcome of a FileBlockstore integrity scan.
+type ScanResult struct {
+	// Number of intact blocks.
+	Blocks int
+	// Leftover temporary files from interrupted writes, which were removed.
+	TempFiles int
+	// Blocks whose contents did not match their CID, which were removed.
+	Corrupt []cid.Cid
+	// Files which are neither blocks nor temporary files, left untouched.
+	Unknown []string
+}
+
+// Checks every stored file: removes temporary files left by interrupted writes, and removes blocks whose
+// contents no longer hash to their CID, so they can be fetched again.
+func (f *FileBlockstore) Scan(ctx context.Context) (ScanResult, error) {
+	var res ScanResult
+	for p, err := range f.files(ctx) {
+		if err != nil {
+			return res, err
+		}
+		name := filepath.Base(p)
+		if strings.HasPrefix(name, tempPrefix) {
+			if err := os.Remove(p); err != nil {
+				return res, err
+			}
+			res.TempFiles++
+			continue
+		}
+		c, err := cid.Parse(name)
+		if err != nil || filepath.Base(filepath.Dir(p)) != shard(c) {
+			res.Unknown = append(res.Unknown, p)
+			continue
+		}
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return res, err
+		}
+		if len(c.Digest) == 0 {
+			res.Blocks++
+			continue
+		}
+		expected, err := cid.Create(c.Codec, data)
+		if err != nil {
+			return res, fmt.Errorf("hashing %s: %w", c, err)
+		}
+		if !bytes.Equal(expected.Bytes, c.Bytes) {
+			if err := os.Remove(p); err != nil {
+				return res, err
+			}
+			res.Corrupt = append(res.Corrupt, c)
+			continue
+		}
+		res.Blocks++
+	}
+	return res, nil
+}
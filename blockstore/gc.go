@@ -0,0 +1,98 @@
+package blockstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/notjuliet/grove/cbor"
+	"github.com/notjuliet/grove/cid"
+)
+
+// Outcome of a garbage collection.
+type GCResult struct {
+	// Number of blocks reachable from the roots.
+	Kept int
+	// Number and total size of the deleted blocks.
+	Deleted int
+	Bytes   int64
+}
+
+// Deletes every block which cannot be reached from the given roots by following the links of DAG-CBOR
+// blocks. Links to blocks absent from the blockstore, such as blobs, are ignored, but the roots must be
+// present.
+//
+// The size of each deleted block is taken from BlockSize if bs is a BlockSizer, and by reading it otherwise.
+// Blocks written while the collection runs may be deleted, so writers must be paused.
+func GC(ctx context.Context, bs Blockstore, roots []cid.Cid) (GCResult, error) {
+	for _, c := range roots {
+		ok, err := bs.Has(ctx, c)
+		if err != nil {
+			return GCResult{}, err
+		}
+		if !ok {
+			return GCResult{}, fmt.Errorf("root %s: %w", c, ErrNotFound)
+		}
+	}
+
+	reachable := map[string]bool{}
+	queue := append([]cid.Cid(nil), roots...)
+	for len(queue) > 0 {
+		if err := ctx.Err(); err != nil {
+			return GCResult{}, err
+		}
+		c := queue[len(queue)-1]
+		queue = queue[:len(queue)-1]
+		if reachable[string(c.Bytes)] {
+			continue
+		}
+		if c.Codec != cid.CodecCbor {
+			reachable[string(c.Bytes)] = true
+			continue
+		}
+		data, err := bs.Get(ctx, c)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return GCResult{}, fmt.Errorf("fetching block %s: %w", c, err)
+		}
+		reachable[string(c.Bytes)] = true
+		links, err := cbor.Links(data)
+		if err != nil {
+			return GCResult{}, fmt.Errorf("decoding block %s: %w", c, err)
+		}
+		queue = append(queue, links...)
+	}
+
+	var res GCResult
+	var garbage []cid.Cid
+	for c, err := range bs.Keys(ctx) {
+		if err != nil {
+			return GCResult{}, err
+		}
+		if reachable[string(c.Bytes)] {
+			res.Kept++
+		} else {
+			garbage = append(garbage, c)
+		}
+	}
+	for _, c := range garbage {
+		if err := ctx.Err(); err != nil {
+			return res, err
+		}
+		size, err := blockSize(ctx, bs, c)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return res, err
+		}
+		if err := bs.Delete(ctx, c); err != nil {
+			return res, err
+		}
+		res.Deleted++
+		res.Bytes += size
+	}
+	return res, nil
+}
@@ -0,0 +1,143 @@
+package blockstore
+
+import (
+	"context"
+	"iter"
+	"sync"
+
+	"github.com/notjuliet/grove/cid"
+)
+
+type memoryBlock struct {
+	cid  cid.Cid
+	data []byte
+}
+
+// In-memory blockstore, used for tests, small tools, and as the overlay of read-through caches.
+type MemoryBlockstore struct {
+	mtx sync.RWMutex
+	// keyed by the raw CID bytes, as Cid itself is not comparable
+	blocks map[string]memoryBlock
+	size   int64
+}
+
+func NewMemoryBlockstore() *MemoryBlockstore {
+	return &MemoryBlockstore{blocks: map[string]memoryBlock{}}
+}
+
+// Returns the stored data of a block. The returned slice is shared and must not be modified.
+func (m *MemoryBlockstore) Get(ctx context.Context, c cid.Cid) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mtx.RLock()
+	defer m.mtx.RUnlock()
+	blk, ok := m.blocks[string(c.Bytes)]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return blk.data, nil
+}
+
+func (m *MemoryBlockstore) BlockSize(ctx context.Context, c cid.Cid) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	m.mtx.RLock()
+	defer m.mtx.RUnlock()
+	blk, ok := m.blocks[string(c.Bytes)]
+	if !ok {
+		return 0, ErrNotFound
+	}
+	return int64(len(blk.data)), nil
+}
+
+func (m *MemoryBlockstore) Put(ctx context.Context, c cid.Cid, data []byte) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	m.put(c, data)
+	return nil
+}
+
+// must be called with the write lock held
+func (m *MemoryBlockstore) put(c cid.Cid, data []byte) {
+	if _, ok := m.blocks[string(c.Bytes)]; ok {
+		return
+	}
+	m.blocks[string(c.Bytes)] = memoryBlock{cid: c, data: append([]byte(nil), data...)}
+	m.size += int64(len(data))
+}
+
+func (m *MemoryBlockstore) PutMany(ctx context.Context, blocks []Block) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	for _, blk := range blocks {
+		m.put(blk.Cid, blk.Data)
+	}
+	return nil
+}
+
+func (m *MemoryBlockstore) Has(ctx context.Context, c cid.Cid) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	m.mtx.RLock()
+	defer m.mtx.RUnlock()
+	_, ok := m.blocks[string(c.Bytes)]
+	return ok, nil
+}
+
+func (m *MemoryBlockstore) Delete(ctx context.Context, c cid.Cid) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	if blk, ok := m.blocks[string(c.Bytes)]; ok {
+		m.size -= int64(len(blk.data))
+		delete(m.blocks, string(c.Bytes))
+	}
+	return nil
+}
+
+// Iterates over a snapshot of the stored CIDs, so the store may be modified during iteration.
+func (m *MemoryBlockstore) Keys(ctx context.Context) iter.Seq2[cid.Cid, error] {
+	return func(yield func(cid.Cid, error) bool) {
+		m.mtx.RLock()
+		keys := make([]cid.Cid, 0, len(m.blocks))
+		for _, blk := range m.blocks {
+			keys = append(keys, blk.cid)
+		}
+		m.mtx.RUnlock()
+
+		for _, c := range keys {
+			if err := ctx.Err(); err != nil {
+				yield(cid.Cid{}, err)
+				return
+			}
+			if !yield(c, nil) {
+				return
+			}
+		}
+	}
+}
+
+// Returns the number of stored blocks.
+func (m *MemoryBlockstore) Len() int {
+	m.mtx.RLock()
+	defer m.mtx.RUnlock()
+	return len(m.blocks)
+}
+
+// Returns the total size of the stored block data in bytes.
+func (m *MemoryBlockstore) Size() int64 {
+	m.mtx.RLock()
+	defer m.mtx.RUnlock()
+	return m.size
+}
@@ -0,0 +1,105 @@
+package blockstore
+
+import (
+	"context"
+	"errors"
+	"iter"
+	"time"
+
+	"github.com/notjuliet/grove/cid"
+	"github.com/notjuliet/grove/metrics"
+)
+
+// Returns a blockstore reporting the operations on bs to r, so that hit rates, throughput and latencies can
+// be monitored:
+//
+//   - blockstore.get.hits and blockstore.get.misses, counting Get calls finding the block or not, and
+//     blockstore.has.hits and blockstore.has.misses likewise for Has;
+//   - blockstore.get.bytes and blockstore.put.bytes, counting the data read and written;
+//   - blockstore.put.blocks and blockstore.delete.blocks, counting the blocks written and removed;
+//   - blockstore.get.seconds and blockstore.put.seconds, observing the latency of Get, Put and PutMany;
+//   - blockstore.errors, counting failed operations other than misses.
+//
+// Only the methods of the Blockstore and BlockSizer interfaces are exposed; BlockSize reads the block if bs is not
+// a BlockSizer, and is not recorded.
+func Instrument(bs Blockstore, r metrics.Recorder) Blockstore {
+	return &instrumented{bs: bs, r: r}
+}
+
+type instrumented struct {
+	bs Blockstore
+	r  metrics.Recorder
+}
+
+func (s *instrumented) Get(ctx context.Context, c cid.Cid) ([]byte, error) {
+	start := time.Now()
+	data, err := s.bs.Get(ctx, c)
+	s.r.Observe("blockstore.get.seconds", time.Since(start).Seconds())
+	switch {
+	case errors.Is(err, ErrNotFound):
+		s.r.Count("blockstore.get.misses", 1)
+	case err != nil:
+		s.r.Count("blockstore.errors", 1)
+	default:
+		s.r.Count("blockstore.get.hits", 1)
+		s.r.Count("blockstore.get.bytes", int64(len(data)))
+	}
+	return data, err
+}
+
+func (s *instrumented) Put(ctx context.Context, c cid.Cid, data []byte) error {
+	return s.PutMany(ctx, []Block{{Cid: c, Data: data}})
+}
+
+func (s *instrumented) PutMany(ctx context.Context, blocks []Block) error {
+	start := time.Now()
+	var err error
+	if len(blocks) == 1 {
+		err = s.bs.Put(ctx, blocks[0].Cid, blocks[0].Data)
+	} else {
+		err = s.bs.PutMany(ctx, blocks)
+	}
+	s.r.Observe("blockstore.put.seconds", time.Since(start).Seconds())
+	if err != nil {
+		s.r.Count("blockstore.errors", 1)
+		return err
+	}
+	var size int64
+	for _, b := range blocks {
+		size += int64(len(b.Data))
+	}
+	s.r.Count("blockstore.put.blocks", int64(len(blocks)))
+	s.r.Count("blockstore.put.bytes", size)
+	return nil
+}
+
+func (s *instrumented) Has(ctx context.Context, c cid.Cid) (bool, error) {
+	ok, err := s.bs.Has(ctx, c)
+	switch {
+	case err != nil:
+		s.r.Count("blockstore.errors", 1)
+	case ok:
+		s.r.Count("blockstore.has.hits", 1)
+	default:
+		s.r.Count("blockstore.has.misses", 1)
+	}
+	return ok, err
+}
+
+func (s *instrumented) Delete(ctx context.Context, c cid.Cid) error {
+	err := s.bs.Delete(ctx, c)
+	if err != nil {
+		s.r.Count("blockstore.errors", 1)
+	} else {
+		s.r.Count("blockstore.delete.blocks", 1)
+	}
+	return err
+}
+
+func (s *instrumented) BlockSize(ctx context.Context, c cid.Cid) (int64, error) {
+	return blockSize(ctx, s.bs, c)
+}
+
+func (s *instrumented) Keys(ctx context.Context) iter.Seq2[cid.Cid, error] {
+	return s.bs.Keys(ctx)
+}
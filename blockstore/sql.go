@@ -0,0 +1,217 @@
+package blockstore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"iter"
+
+	"github.com/notjuliet/grove/cid"
+	"github.com/notjuliet/grove/internal/sqlname"
+)
+
+// SQL dialect of the database behind a SQLBlockstore.
+type SQLDialect int
+
+const (
+	SQLite SQLDialect = iota
+	Postgres
+)
+
+// Returns the column type for binary data.
+func (d SQLDialect) BlobType() string {
+	if d == Postgres {
+		return "BYTEA"
+	}
+	return "BLOB"
+}
+
+// Returns the placeholder for the i-th statement parameter, counting from 1.
+func (d SQLDialect) Placeholder(i int) string {
+	if d == Postgres {
+		return fmt.Sprintf("$%d", i)
+	}
+	return "?"
+}
+
+// Blockstore backed by a database/sql table with a CID primary key. Any driver works as long as the
+// database understands the chosen dialect.
+type SQLBlockstore struct {
+	db      *sql.DB
+	dialect SQLDialect
+	table   string
+
+	get  *sql.Stmt
+	size *sql.Stmt
+	put  *sql.Stmt
+	has  *sql.Stmt
+	del  *sql.Stmt
+	keys *sql.Stmt
+}
+
+// Creates the block table if it does not exist and prepares the store's statements. An empty table name
+// defaults to "blocks".
+func OpenSQLBlockstore(ctx context.Context, db *sql.DB, dialect SQLDialect, table string) (*SQLBlockstore, error) {
+	if table == "" {
+		table = "blocks"
+	}
+	if err := sqlname.CheckTable(table); err != nil {
+		return nil, err
+	}
+	s := &SQLBlockstore{db: db, dialect: dialect, table: table}
+
+	schema := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (cid %s PRIMARY KEY, data %s NOT NULL)",
+		table, dialect.BlobType(), dialect.BlobType())
+	if _, err := db.ExecContext(ctx, schema); err != nil {
+		return nil, fmt.Errorf("creating block table: %w", err)
+	}
+
+	p1, p2 := dialect.Placeholder(1), dialect.Placeholder(2)
+	queries := []struct {
+		stmt  **sql.Stmt
+		query string
+	}{
+		{&s.get, fmt.Sprintf("SELECT data FROM %s WHERE cid = %s", table, p1)},
+		{&s.size, fmt.Sprintf("SELECT LENGTH(data) FROM %s WHERE cid = %s", table, p1)},
+		{&s.put, fmt.Sprintf("INSERT INTO %s (cid, data) VALUES (%s, %s) ON CONFLICT (cid) DO NOTHING", table, p1, p2)},
+		{&s.has, fmt.Sprintf("SELECT 1 FROM %s WHERE cid = %s", table, p1)},
+		{&s.del, fmt.Sprintf("DELETE FROM %s WHERE cid = %s", table, p1)},
+		{&s.keys, fmt.Sprintf("SELECT cid FROM %s", table)},
+	}
+	for _, q := range queries {
+		stmt, err := db.PrepareContext(ctx, q.query)
+		if err != nil {
+			s.Close()
+			return nil, fmt.Errorf("preparing statement: %w", err)
+		}
+		*q.stmt = stmt
+	}
+	return s, nil
+}
+
+// Closes the prepared statements. The database handle is left open.
+func (s *SQLBlockstore) Close() error {
+	var errs []error
+	for _, stmt := range []*sql.Stmt{s.get, s.size, s.put, s.has, s.del, s.keys} {
+		if stmt != nil {
+			errs = append(errs, stmt.Close())
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (s *SQLBlockstore) Get(ctx context.Context, c cid.Cid) ([]byte, error) {
+	var data []byte
+	err := s.get.QueryRowContext(ctx, c.Bytes).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	return data, err
+}
+
+func (s *SQLBlockstore) BlockSize(ctx context.Context, c cid.Cid) (int64, error) {
+	var size int64
+	err := s.size.QueryRowContext(ctx, c.Bytes).Scan(&size)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, ErrNotFound
+	}
+	return size, err
+}
+
+func (s *SQLBlockstore) Put(ctx context.Context, c cid.Cid, data []byte) error {
+	_, err := s.put.ExecContext(ctx, c.Bytes, data)
+	return err
+}
+
+// Stores all blocks in a single transaction, so either every block is stored or none are.
+func (s *SQLBlockstore) PutMany(ctx context.Context, blocks []Block) error {
+	b, err := s.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	for _, blk := range blocks {
+		if err := b.Put(ctx, blk.Cid, blk.Data); err != nil {
+			b.Rollback()
+			return err
+		}
+	}
+	return b.Commit()
+}
+
+func (s *SQLBlockstore) Has(ctx context.Context, c cid.Cid) (bool, error) {
+	var one int
+	err := s.has.QueryRowContext(ctx, c.Bytes).Scan(&one)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (s *SQLBlockstore) Delete(ctx context.Context, c cid.Cid) error {
+	_, err := s.del.ExecContext(ctx, c.Bytes)
+	return err
+}
+
+// Transactional batch of writes to a SQLBlockstore. Writes are not visible to other readers until Commit.
+type SQLBatch struct {
+	tx  *sql.Tx
+	put *sql.Stmt
+	del *sql.Stmt
+}
+
+// Starts a batch. It must be finished with Commit or Rollback.
+func (s *SQLBlockstore) Begin(ctx context.Context) (*SQLBatch, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &SQLBatch{tx: tx, put: tx.StmtContext(ctx, s.put), del: tx.StmtContext(ctx, s.del)}, nil
+}
+
+func (b *SQLBatch) Put(ctx context.Context, c cid.Cid, data []byte) error {
+	_, err := b.put.ExecContext(ctx, c.Bytes, data)
+	return err
+}
+
+func (b *SQLBatch) Delete(ctx context.Context, c cid.Cid) error {
+	_, err := b.del.ExecContext(ctx, c.Bytes)
+	return err
+}
+
+func (b *SQLBatch) Commit() error {
+	return b.tx.Commit()
+}
+
+func (b *SQLBatch) Rollback() error {
+	return b.tx.Rollback()
+}
+
+func (s *SQLBlockstore) Keys(ctx context.Context) iter.Seq2[cid.Cid, error] {
+	return func(yield func(cid.Cid, error) bool) {
+		rows, err := s.keys.QueryContext(ctx)
+		if err != nil {
+			yield(cid.Cid{}, err)
+			return
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var raw []byte
+			if err := rows.Scan(&raw); err != nil {
+				yield(cid.Cid{}, err)
+				return
+			}
+			c, err := cid.FromBytes(append([]byte{0}, raw...))
+			if err != nil {
+				yield(cid.Cid{}, fmt.Errorf("invalid stored CID: %w", err))
+				return
+			}
+			if !yield(c, nil) {
+				return
+			}
+		}
+		if err := rows.Err(); err != nil {
+			yield(cid.Cid{}, err)
+		}
+	}
+}
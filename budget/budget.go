@@ -0,0 +1,73 @@
+// Package budget bounds the memory materialized while decoding untrusted input. A budget is charged an
+// approximation of the bytes allocated for each decoded value, such as strings, byte strings, map entries and
+// CAR blocks, and fails once its limit would be exceeded, before the allocation is made. A single budget may
+// be shared by the decoders of one message, such as the CBOR decoder and the CAR reader of a firehose event.
+package budget
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	"github.com/notjuliet/grove/errdefs"
+)
+
+// Memory ceiling shared by decoders. It is safe for concurrent use. A nil budget is unlimited.
+type Budget struct {
+	limit int64
+	used  atomic.Int64
+}
+
+// Returns a budget of limit bytes.
+func New(limit int64) *Budget {
+	return &Budget{limit: limit}
+}
+
+// Returned by Charge when a budget is exhausted; match with errors.As. It matches errdefs.ErrLimit.
+type ExceededError struct {
+	// Limit of the budget, and bytes already charged to it.
+	Limit int64
+	Used  int64
+	// Bytes of the rejected charge.
+	Requested int64
+}
+
+func (e *ExceededError) Error() string {
+	return fmt.Sprintf("memory budget of %d bytes exceeded: %d bytes used, %d more requested", e.Limit, e.Used,
+		e.Requested)
+}
+
+func (e *ExceededError) Is(target error) bool {
+	return target == errdefs.ErrLimit
+}
+
+// Charges n bytes to the budget, or returns an *ExceededError, charging nothing, if that would exceed its
+// limit.
+func (b *Budget) Charge(n int64) error {
+	if b == nil {
+		return nil
+	}
+	for {
+		used := b.used.Load()
+		if n > b.limit-used {
+			return &ExceededError{Limit: b.limit, Used: used, Requested: n}
+		}
+		if b.used.CompareAndSwap(used, used+n) {
+			return nil
+		}
+	}
+}
+
+// Returns n bytes to the budget, once memory charged to it has been released.
+func (b *Budget) Release(n int64) {
+	if b != nil {
+		b.used.Add(-n)
+	}
+}
+
+// Returns the bytes charged to the budget.
+func (b *Budget) Used() int64 {
+	if b == nil {
+		return 0
+	}
+	return b.used.Load()
+}
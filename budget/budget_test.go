@@ -0,0 +1,53 @@
+package budget
+
+import (
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/notjuliet/grove/errdefs"
+)
+
+func TestBudget(t *testing.T) {
+	b := New(100)
+	if err := b.Charge(60); err != nil {
+		t.Fatal(err)
+	}
+	err := b.Charge(50)
+	var exceeded *ExceededError
+	if !errors.As(err, &exceeded) || exceeded.Limit != 100 || exceeded.Used != 60 || exceeded.Requested != 50 {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if !errors.Is(err, errdefs.ErrLimit) {
+		t.Fatal("expected error to match errdefs.ErrLimit")
+	}
+	if b.Used() != 60 {
+		t.Fatalf("failed charge was counted, %d bytes used", b.Used())
+	}
+	b.Release(20)
+	if err := b.Charge(60); err != nil || b.Used() != 100 {
+		t.Fatalf("unexpected usage %d, %v", b.Used(), err)
+	}
+
+	var unlimited *Budget
+	if err := unlimited.Charge(1 << 62); err != nil || unlimited.Used() != 0 {
+		t.Fatal("nil budget is not unlimited")
+	}
+
+	// concurrent charges never exceed the limit
+	b = New(1000)
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 200 {
+				b.Charge(1)
+			}
+		}()
+	}
+	wg.Wait()
+	if b.Used() != 1000 {
+		t.Fatalf("unexpected usage %d", b.Used())
+	}
+}
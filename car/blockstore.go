@@ -0,0 +1,242 @@
+package car
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"runtime"
+	"sync"
+
+	"github.com/notjuliet/grove/blockstore"
+	"github.com/notjuliet/grove/cbor"
+	"github.com/notjuliet/grove/cid"
+)
+
+// number of blocks imported per blockstore batch
+const importBatchSize = 256
+
+type TransferOptions struct {
+	// Called after every block transferred, with running totals.
+	Progress func(blocks int, bytes int64)
+	// Called by ImportInto after each batch of blocks is written to the blockstore. Returning an error aborts
+	// the import. Checkpoints can be persisted, for instance as JSON, to resume an interrupted import.
+	Checkpoint func(Checkpoint) error
+	// Checkpoint of an earlier import of the same CAR to resume from.
+	Resume *Checkpoint
+	// Number of goroutines verifying blocks against their CIDs during ImportInto. Zero means GOMAXPROCS.
+	Workers int
+}
+
+// Position of an import, covering every block before Offset.
+type Checkpoint struct {
+	// Input offset following the last imported block.
+	Offset int64
+	// Running totals, as passed to the progress callback.
+	Blocks int
+	Bytes  int64
+}
+
+// Imports every block of a CAR into a blockstore, returning the roots listed in its header. Blocks must match
+// their CID; blocks repeated within the CAR are only written once, except across resumed imports.
+//
+// Import runs as a pipeline: the input is read and split into batches by one goroutine, batches are verified
+// against their CIDs by opts.Workers goroutines, and written to the blockstore in input order by the calling
+// goroutine, which also runs the callbacks. Channels between stages are bounded, so that at most a few
+// batches per worker are held in memory.
+//
+// When resuming, the input must be the same CAR read from its start; inputs implementing io.Seeker skip the
+// imported part without reading it.
+func ImportInto(ctx context.Context, bs blockstore.Blockstore, r io.Reader, opts TransferOptions) ([]cid.Cid, error) {
+	cr, err := NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var progress Checkpoint
+	if opts.Resume != nil {
+		if err := cr.skipTo(opts.Resume.Offset); err != nil {
+			return nil, fmt.Errorf("resuming import: %w", err)
+		}
+		progress = *opts.Resume
+	}
+
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// batches are verified in any order, but written in input order
+	toVerify := make(chan *importBatch, workers)
+	toWrite := make(chan *importBatch, 2*workers)
+	var readErr error
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(toWrite)
+		defer close(toVerify)
+		readErr = readBatches(ctx, cr, toVerify, toWrite)
+	}()
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for b := range toVerify {
+				b.err = verifyBlocks(b.blocks)
+				close(b.verified)
+			}
+		}()
+	}
+
+	for b := range toWrite {
+		select {
+		case <-b.verified:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		if b.err != nil {
+			return nil, b.err
+		}
+		if err := bs.PutMany(ctx, b.blocks); err != nil {
+			return nil, err
+		}
+		for _, blk := range b.blocks {
+			progress.Blocks++
+			progress.Bytes += int64(len(blk.Data))
+			if opts.Progress != nil {
+				opts.Progress(progress.Blocks, progress.Bytes)
+			}
+		}
+		progress.Offset = b.offset
+		if opts.Checkpoint != nil {
+			if err := opts.Checkpoint(progress); err != nil {
+				return nil, err
+			}
+		}
+	}
+	// toWrite is closed once readBatches returned
+	if readErr != nil {
+		return nil, readErr
+	}
+	return cr.Roots, nil
+}
+
+// blocks of an import, written to the blockstore at once
+type importBatch struct {
+	blocks []blockstore.Block
+	// input offset following the last block read into the batch
+	offset int64
+	// closed once the blocks are verified, err being set
+	verified chan struct{}
+	err      error
+}
+
+// reads the blocks of a CAR into batches, skipping repeated blocks, and sends each batch to both channels. The
+// last batch, sent after the end of the input, may be empty.
+func readBatches(ctx context.Context, cr *Reader, toVerify, toWrite chan<- *importBatch) error {
+	var seen cid.Set
+	for done := false; !done; {
+		b := &importBatch{blocks: make([]blockstore.Block, 0, importBatchSize), verified: make(chan struct{})}
+		for len(b.blocks) < importBatchSize {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			blk, err := cr.Next()
+			if err == io.EOF {
+				done = true
+				break
+			}
+			if err != nil {
+				return fmt.Errorf("reading CAR: %w", err)
+			}
+			if seen.Add(blk.Cid) {
+				b.blocks = append(b.blocks, blockstore.Block{Cid: blk.Cid, Data: blk.Data})
+			}
+		}
+		b.offset = cr.r.n
+		for _, ch := range []chan<- *importBatch{toVerify, toWrite} {
+			select {
+			case ch <- b:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+	return nil
+}
+
+// checks that the data of each block hashes to its CID
+func verifyBlocks(blocks []blockstore.Block) error {
+	for _, blk := range blocks {
+		computed, err := cid.Create(blk.Cid.Codec, blk.Data)
+		if err != nil {
+			return fmt.Errorf("block %s: %w", blk.Cid, err)
+		}
+		if !bytes.Equal(computed.Bytes, blk.Cid.Bytes) {
+			return fmt.Errorf("block data does not match CID %s", blk.Cid)
+		}
+	}
+	return nil
+}
+
+// Writes a CAR holding every block reachable from the roots, following the links of DAG-CBOR blocks, each
+// block once. Links to blocks absent from the blockstore, such as blobs, are skipped, but the roots must be
+// present.
+func ExportFrom(ctx context.Context, bs blockstore.Blockstore, roots []cid.Cid, w io.Writer, opts TransferOptions) error {
+	cw, err := NewWriter(w, roots)
+	if err != nil {
+		return err
+	}
+
+	var seen cid.Set
+	blocks, size := 0, int64(0)
+	var visit func(c cid.Cid, root bool) error
+	visit = func(c cid.Cid, root bool) error {
+		if !seen.Add(c) {
+			return nil
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		data, err := bs.Get(ctx, c)
+		if errors.Is(err, blockstore.ErrNotFound) && !root {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("fetching block %s: %w", c, err)
+		}
+		if err := cw.Put(c, data); err != nil {
+			return err
+		}
+		blocks++
+		size += int64(len(data))
+		if opts.Progress != nil {
+			opts.Progress(blocks, size)
+		}
+
+		if c.Codec != cid.CodecCbor {
+			return nil
+		}
+		links, err := cbor.Links(data)
+		if err != nil {
+			return fmt.Errorf("decoding block %s: %w", c, err)
+		}
+		for _, l := range links {
+			if err := visit(l, false); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for _, c := range roots {
+		if err := visit(c, true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,585 @@
+package car
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/notjuliet/grove/blockstore"
+	"github.com/notjuliet/grove/budget"
+	"github.com/notjuliet/grove/cbor"
+	"github.com/notjuliet/grove/cid"
+	"github.com/notjuliet/grove/errdefs"
+	"github.com/notjuliet/grove/limits"
+)
+
+func TestWriter(t *testing.T) {
+	data := []byte("lorem ipsum")
+	c, err := cid.Create(cid.CodecRaw, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, []cid.Cid{c})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Dedupe = true
+	for range 2 {
+		if err := w.Put(c, data); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	header, err := cbor.Encode(map[string]any{"roots": []any{c.Link()}, "version": uint64(1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []byte{byte(len(header))}
+	expected = append(expected, header...)
+	expected = append(expected, byte(len(c.Bytes)+len(data)))
+	expected = append(expected, c.Bytes...)
+	expected = append(expected, data...)
+
+	if !bytes.Equal(buf.Bytes(), expected) {
+		t.Fatal("unexpected CAR bytes")
+	}
+}
+
+// getRepo output for a small repository with two records
+var greenground, _ = base64.StdEncoding.DecodeString("" +
+	"OqJlcm9vdHOB2CpYJQABcRIgcSdnUpxZDtxj/YJUFsI8Pof7Oqj6TWQRfqyuJXKB+0tndmVyc2lvbgGPAQFxEiBguab71/aHcoWX" +
+	"LvOgD+RWgzyanyPZWoDuDg1Eqsu1LKNlJHR5cGV1YXBwLmJza3kuZ3JhcGguZm9sbG93Z3N1YmplY3R4IGRpZDpwbGM6NmZrdGFh" +
+	"bWhoeGRxYjJ5cHVtMzNrYmtqaWNyZWF0ZWRBdHgYMjAyMy0wOC0yNVQwODoyNTozOS45NjFaiAIBcRIgcSdnUpxZDtxj/YJUFsI8" +
+	"Pof7Oqj6TWQRfqyuJXKB+0umY2RpZHggZGlkOnBsYzpremNxeWMzdW5iMzNlaDVzeHpzZnMyNXpjcmV2bTNrNjd1cDNqN2hmMnhj" +
+	"c2lnWEDs8YrDS4NVQt262QySHItmroy++sDmH8GspFw2tSUa5UCgvyPzxRretF0he5XyCZEB/Nw3oed618LBOU7Hz0NIZGRhdGHY" +
+	"KlglAAFxEiCJgze9NaJisyZmZVLJ3mJI/RAVRMF2sSwcE45hcbT3DmRwcmV22CpYJQABcRIgdcRqsNaX8TvSt4RxHA8aZQuullbV" +
+	"ut6SBQmUyjsb1dlndmVyc2lvbgPEAQFxEiCJgze9NaJisyZmZVLJ3mJI/RAVRMF2sSwcE45hcbT3DqJhZYKkYWtYI2FwcC5ic2t5" +
+	"LmdyYXBoLmZvbGxvdy8zazVyZ2ZpaWZzNDJ1YXAAYXT2YXbYKlglAAFxEiBguab71/aHcoWXLvOgD+RWgzyanyPZWoDuDg1Eqsu1" +
+	"LKRha0p2emUyNXY1YTI2YXAYGWF09mF22CpYJQABcRIg8nlhZqGh+JEPNrExwHZyWdPgFzkx0Zq3b4pDyGMLMARhbPaPAQFxEiDy" +
+	"eWFmoaH4kQ82sTHAdnJZ0+AXOTHRmrdvikPIYwswBKNlJHR5cGV1YXBwLmJza3kuZ3JhcGguZm9sbG93Z3N1YmplY3R4IGRpZDpw" +
+	"bGM6Y3o3M3I3aXlpcW4yNnVwb3Q0anRqZGhraWNyZWF0ZWRBdHgYMjAyMy0wOC0yN1QwNDoxNToyOS40NDVa")
+
+func readAll(t *testing.T, r io.Reader) ([]cid.Cid, []Block) {
+	cr, err := NewReader(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var blocks []Block
+	for {
+		blk, err := cr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		blocks = append(blocks, blk)
+	}
+	return cr.Roots, blocks
+}
+
+func TestReader(t *testing.T) {
+	roots, blocks := readAll(t, bytes.NewReader(greenground))
+	if len(roots) != 1 || roots[0].String() != "bafyreidre5tvfhczb3ogh7mckqlmepb6q75tvkh2jvsbc7vmvysxfap3jm" {
+		t.Fatal("invalid roots")
+	}
+	if len(blocks) != 4 {
+		t.Fatalf("expected 4 blocks, got %d", len(blocks))
+	}
+
+	t.Run("round trip", func(t *testing.T) {
+		var buf bytes.Buffer
+		w, err := NewWriter(&buf, roots)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, blk := range blocks {
+			if err := w.Put(blk.Cid, blk.Data); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if !bytes.Equal(buf.Bytes(), greenground) {
+			t.Fatal("re-encoded CAR does not match")
+		}
+	})
+
+	t.Run("truncated", func(t *testing.T) {
+		cr, err := NewReader(bytes.NewReader(greenground[:len(greenground)-10]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for {
+			_, err := cr.Next()
+			if err == io.EOF {
+				t.Fatal("expected error")
+			}
+			if err != nil {
+				break
+			}
+		}
+	})
+
+	t.Run("error categories", func(t *testing.T) {
+		failing := io.MultiReader(bytes.NewReader(greenground[:200]), iotest.ErrReader(errors.New("connection reset")))
+		for r, category := range map[io.Reader]string{
+			failing: "io",
+			bytes.NewReader(greenground[:len(greenground)-10]): "malformed",
+			bytes.NewReader(greenground[:20]):                  "malformed",
+		} {
+			cr, err := NewReader(r)
+			for err == nil {
+				_, err = cr.Next()
+			}
+			if got := errdefs.Category(err); got != category {
+				t.Errorf("expected %s error, got %s: %v", category, got, err)
+			}
+		}
+	})
+
+	t.Run("max block size", func(t *testing.T) {
+		largest := 0
+		for _, blk := range blocks {
+			largest = max(largest, len(blk.Data))
+		}
+		for _, tc := range []struct {
+			max int
+			ok  bool
+		}{{0, true}, {largest, true}, {largest - 1, false}} {
+			cr, err := NewReaderWith(bytes.NewReader(greenground), ReaderOptions{MaxBlockSize: tc.max})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for {
+				_, err = cr.Next()
+				if err != nil {
+					break
+				}
+			}
+			if (err == io.EOF) != tc.ok || !tc.ok && !errors.Is(err, limits.ErrExceeded) {
+				t.Fatalf("unexpected error with limit %d: %v", tc.max, err)
+			}
+		}
+	})
+
+	t.Run("pool", func(t *testing.T) {
+		cr, err := NewReaderWith(bytes.NewReader(greenground), ReaderOptions{Pool: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; ; i++ {
+			blk, err := cr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			kept := blk.Clone()
+			blk.Release()
+			if i >= len(blocks) || !reflect.DeepEqual(kept.Cid, blocks[i].Cid) || !bytes.Equal(kept.Data, blocks[i].Data) {
+				t.Fatalf("unexpected pooled block %d", i)
+			}
+		}
+		// unpooled blocks can be released too
+		blocks[0].Release()
+	})
+
+	t.Run("budget", func(t *testing.T) {
+		cr, err := NewReaderBudget(bytes.NewReader(greenground), budget.New(512))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var exceeded *budget.ExceededError
+		for {
+			_, err := cr.Next()
+			if err == io.EOF {
+				t.Fatal("expected budget to be exceeded")
+			}
+			if err != nil {
+				if !errors.As(err, &exceeded) {
+					t.Fatal(err)
+				}
+				break
+			}
+		}
+		b := budget.New(4096)
+		cr, err = NewReaderBudget(bytes.NewReader(greenground), b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for {
+			if _, err := cr.Next(); err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatal(err)
+			}
+		}
+	})
+}
+
+func TestV2(t *testing.T) {
+	ctx := context.Background()
+	roots, blocks := readAll(t, bytes.NewReader(greenground))
+
+	f, err := os.Create(filepath.Join(t.TempDir(), "repo.car"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	w, err := NewV2Writer(f, roots)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, blk := range blocks {
+		if err := w.Put(blk.Cid, blk.Data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("sequential", func(t *testing.T) {
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			t.Fatal(err)
+		}
+		v2roots, v2blocks := readAll(t, f)
+		if !reflect.DeepEqual(roots, v2roots) || !reflect.DeepEqual(blocks, v2blocks) {
+			t.Fatal("CARv2 payload does not match")
+		}
+	})
+
+	t.Run("offset index", func(t *testing.T) {
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			t.Fatal(err)
+		}
+		idx, err := BuildIndex(ctx, f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		testIndex(t, f, idx, roots, blocks)
+	})
+
+	t.Run("indexed", func(t *testing.T) {
+		info, err := f.Stat()
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, err := OpenIndexed(f, info.Size())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(roots, r.Roots) {
+			t.Fatal("invalid roots")
+		}
+		for _, blk := range blocks {
+			data, err := r.Get(blk.Cid)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(data, blk.Data) {
+				t.Fatal("invalid block data")
+			}
+		}
+		missing, _ := cid.Create(cid.CodecRaw, []byte("missing"))
+		if _, err := r.Get(missing); err != ErrNotFound {
+			t.Fatal("expected not found error")
+		}
+	})
+
+	t.Run("invalid header", func(t *testing.T) {
+		file, err := os.ReadFile(f.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		h := parseV2Header(file[len(v2Pragma):])
+		size := uint64(len(file))
+		for name, bad := range map[string]v2Header{
+			"payload overflow":    {dataOffset: math.MaxUint64 - 10, dataSize: 20, indexOffset: h.indexOffset},
+			"payload past end":    {dataOffset: h.dataOffset, dataSize: size, indexOffset: h.indexOffset},
+			"index overflow":      {dataOffset: h.dataOffset, dataSize: h.dataSize, indexOffset: math.MaxUint64},
+			"index past end":      {dataOffset: h.dataOffset, dataSize: h.dataSize, indexOffset: size + 1},
+			"index in payload":    {dataOffset: h.dataOffset, dataSize: h.dataSize, indexOffset: h.dataOffset + 1},
+			"index at file start": {dataOffset: h.dataOffset, dataSize: h.dataSize, indexOffset: 1},
+		} {
+			b := slices.Concat(file[:len(v2Pragma)], bad.bytes(), file[len(v2Pragma)+v2HeaderSize:])
+			if _, err := OpenIndexed(bytes.NewReader(b), int64(len(b))); err == nil {
+				t.Errorf("%s: expected invalid header error", name)
+			}
+		}
+		if _, err := OpenIndexed(bytes.NewReader(file), -1); err == nil {
+			t.Fatal("expected error for a negative size")
+		}
+	})
+}
+
+func TestBlockstoreTransfer(t *testing.T) {
+	ctx := context.Background()
+	src := blockstore.NewMemoryBlockstore()
+	if _, err := ImportInto(ctx, src, bytes.NewReader(greenground), TransferOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	cr, err := NewReader(bytes.NewReader(greenground))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// unreachable blocks are left out of exports
+	junk := []byte("junk")
+	junkCid, _ := cid.Create(cid.CodecRaw, junk)
+	if err := src.Put(ctx, junkCid, junk); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	exported := 0
+	err = ExportFrom(ctx, src, cr.Roots, &buf, TransferOptions{Progress: func(blocks int, _ int64) { exported = blocks }})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exported != src.Len()-1 {
+		t.Fatalf("exported %d blocks, expected %d", exported, src.Len()-1)
+	}
+
+	// a CAR repeating every block imports each once
+	dup := append([]byte(nil), buf.Bytes()...)
+	dup = append(dup, buf.Bytes()[1+int(buf.Bytes()[0]):]...)
+	dst := blockstore.NewMemoryBlockstore()
+	imported := 0
+	roots, err := ImportInto(ctx, dst, bytes.NewReader(dup), TransferOptions{Progress: func(blocks int, _ int64) { imported = blocks }})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(roots) != 1 || roots[0].String() != cr.Roots[0].String() || imported != exported || dst.Len() != exported {
+		t.Fatal("unexpected import")
+	}
+
+	corrupt := append([]byte(nil), greenground...)
+	corrupt[len(corrupt)-1] ^= 1
+	if _, err := ImportInto(ctx, blockstore.NewMemoryBlockstore(), bytes.NewReader(corrupt), TransferOptions{}); err == nil {
+		t.Fatal("expected CID mismatch error")
+	}
+	if err := ExportFrom(ctx, dst, []cid.Cid{junkCid}, io.Discard, TransferOptions{}); !errors.Is(err, blockstore.ErrNotFound) {
+		t.Fatal("expected missing root error")
+	}
+}
+
+func TestIndex(t *testing.T) {
+	ctx := context.Background()
+	roots, blocks := readAll(t, bytes.NewReader(greenground))
+	idx, err := BuildIndex(ctx, bytes.NewReader(greenground))
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := DecodeIndex(idx.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(idx, decoded) {
+		t.Fatal("index does not round-trip")
+	}
+	testIndex(t, bytes.NewReader(greenground), decoded, roots, blocks)
+
+	b := idx.Bytes()
+	if _, err := DecodeIndex(b[:len(b)-1]); err == nil {
+		t.Fatal("expected truncated index error")
+	}
+}
+
+func testIndex(t *testing.T, r io.ReaderAt, idx *Index, roots []cid.Cid, blocks []Block) {
+	t.Helper()
+	ctx := context.Background()
+	if !reflect.DeepEqual(idx.Roots, roots) || idx.Len() != len(blocks) {
+		t.Fatal("unexpected index contents")
+	}
+	bs := NewIndexedBlockstore(r, idx)
+	for _, blk := range blocks {
+		data, err := bs.Get(ctx, blk.Cid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data, blk.Data) {
+			t.Fatalf("unexpected data for block %s", blk.Cid)
+		}
+	}
+	missing, _ := cid.Create(cid.CodecRaw, []byte("missing"))
+	if _, err := bs.Get(ctx, missing); !errors.Is(err, blockstore.ErrNotFound) {
+		t.Fatal("expected not found error")
+	}
+	if err := bs.Put(ctx, missing, nil); !errors.Is(err, ErrReadOnly) {
+		t.Fatal("expected read-only error")
+	}
+}
+
+func TestFilter(t *testing.T) {
+	ctx := context.Background()
+	roots, blocks := readAll(t, bytes.NewReader(greenground))
+
+	var buf bytes.Buffer
+	// blocks[1] is the commit
+	n, err := Filter(ctx, bytes.NewReader(greenground), &buf, cid.NewSet(roots[0], blocks[0].Cid))
+	if err != nil {
+		t.Fatal(err)
+	}
+	filteredRoots, filtered := readAll(t, &buf)
+	if n != 2 || len(filtered) != 2 || !reflect.DeepEqual(filteredRoots, roots) {
+		t.Fatal("unexpected filtered CAR")
+	}
+
+	buf.Reset()
+	n, err = Exclude(ctx, bytes.NewReader(greenground), &buf, cid.NewSet(roots[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	excludedRoots, excluded := readAll(t, &buf)
+	if n != len(blocks)-1 || len(excluded) != n || len(excludedRoots) != 0 {
+		t.Fatal("unexpected CAR after exclusion")
+	}
+	for _, blk := range excluded {
+		if blk.Cid.String() == roots[0].String() {
+			t.Fatal("excluded block was written")
+		}
+	}
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := Filter(canceled, bytes.NewReader(greenground), io.Discard, cid.NewSet()); !errors.Is(err,
+		context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	ctx := context.Background()
+	roots, blocks := readAll(t, bytes.NewReader(greenground))
+	extra := []byte("extra")
+	extraCid, _ := cid.Create(cid.CodecRaw, extra)
+	var second bytes.Buffer
+	w, err := NewWriter(&second, []cid.Cid{extraCid, roots[0]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, blk := range []Block{blocks[0], {Cid: extraCid, Data: extra}} {
+		if err := w.Put(blk.Cid, blk.Data); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var buf bytes.Buffer
+	n, err := Merge(ctx, &buf, bytes.NewReader(greenground), &second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	merged, mergedBlocks := readAll(t, &buf)
+	if n != len(blocks)+1 || len(mergedBlocks) != n {
+		t.Fatalf("expected %d blocks, got %d", len(blocks)+1, n)
+	}
+	if !reflect.DeepEqual(merged, []cid.Cid{roots[0], extraCid}) {
+		t.Fatal("unexpected merged roots")
+	}
+}
+
+func TestResumeImport(t *testing.T) {
+	ctx := context.Background()
+	var buf bytes.Buffer
+	data := []byte("root")
+	root, _ := cid.Create(cid.CodecRaw, data)
+	w, err := NewWriter(&buf, []cid.Cid{root})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range 600 {
+		data := fmt.Appendf(nil, "block %d", i)
+		c, _ := cid.Create(cid.CodecRaw, data)
+		if err := w.Put(c, data); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, seekable := range []bool{true, false} {
+		bs := blockstore.NewMemoryBlockstore()
+		errInterrupted := errors.New("interrupted")
+		var saved Checkpoint
+		_, err := ImportInto(ctx, bs, bytes.NewReader(buf.Bytes()), TransferOptions{Checkpoint: func(cp Checkpoint) error {
+			saved = cp
+			return errInterrupted
+		}})
+		if !errors.Is(err, errInterrupted) || saved.Blocks != importBatchSize || bs.Len() != importBatchSize {
+			t.Fatalf("unexpected checkpoint %+v", saved)
+		}
+
+		var r io.Reader = bytes.NewReader(buf.Bytes())
+		if !seekable {
+			r = io.MultiReader(r)
+		}
+		var last Checkpoint
+		roots, err := ImportInto(ctx, bs, r, TransferOptions{Resume: &saved, Checkpoint: func(cp Checkpoint) error {
+			last = cp
+			return nil
+		}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(roots) != 1 || bs.Len() != 600 || last.Blocks != 600 || last.Offset != int64(buf.Len()) {
+			t.Fatalf("unexpected resumed import, checkpoint %+v", last)
+		}
+	}
+}
+
+func TestParallelImport(t *testing.T) {
+	ctx := context.Background()
+	var buf bytes.Buffer
+	data := []byte("root")
+	root, _ := cid.Create(cid.CodecRaw, data)
+	w, err := NewWriter(&buf, []cid.Cid{root})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range 2000 {
+		data := fmt.Appendf(nil, "block %d", i)
+		c, _ := cid.Create(cid.CodecRaw, data)
+		if i == 1500 {
+			data = []byte("corrupt")
+		}
+		if err := w.Put(c, data); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	bs := blockstore.NewMemoryBlockstore()
+	var offsets []int64
+	blocks := 0
+	_, err = ImportInto(ctx, bs, bytes.NewReader(buf.Bytes()), TransferOptions{
+		Workers:  4,
+		Progress: func(n int, _ int64) { blocks = n },
+		Checkpoint: func(cp Checkpoint) error {
+			offsets = append(offsets, cp.Offset)
+			return nil
+		},
+	})
+	if err == nil || !strings.Contains(err.Error(), "does not match") {
+		t.Fatalf("expected a CID mismatch, got %v", err)
+	}
+	// batches before the corrupt block are written in order, and none after it
+	if blocks != 1280 || bs.Len() != 1280 || len(offsets) != 5 || !slices.IsSorted(offsets) {
+		t.Fatalf("unexpected import of %d blocks, %d stored, checkpoints at %v", blocks, bs.Len(), offsets)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := ImportInto(ctx, bs, bytes.NewReader(buf.Bytes()), TransferOptions{}); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected a cancellation error, got %v", err)
+	}
+}
@@ -0,0 +1,116 @@
+package car
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/notjuliet/grove/cid"
+)
+
+// Copies the blocks of a CAR whose CID is in keep to a new CARv1, returning the number of blocks written.
+// Roots which are not kept are dropped from the header. Duplicate blocks are written once. Copying stops with
+// the error of ctx once it is done.
+func Filter(ctx context.Context, r io.Reader, w io.Writer, keep *cid.Set) (int, error) {
+	return filter(ctx, r, w, keep.Has)
+}
+
+// Like Filter, but copies the blocks whose CID is not in exclude.
+func Exclude(ctx context.Context, r io.Reader, w io.Writer, exclude *cid.Set) (int, error) {
+	return filter(ctx, r, w, func(c cid.Cid) bool { return !exclude.Has(c) })
+}
+
+func filter(ctx context.Context, r io.Reader, w io.Writer, match func(cid.Cid) bool) (int, error) {
+	cr, err := NewReaderWith(r, ReaderOptions{Pool: true})
+	if err != nil {
+		return 0, err
+	}
+	var roots []cid.Cid
+	for _, c := range cr.Roots {
+		if match(c) {
+			roots = append(roots, c)
+		}
+	}
+	cw, err := NewWriter(w, roots)
+	if err != nil {
+		return 0, err
+	}
+	cw.Dedupe = true
+
+	written := 0
+	for {
+		if err := ctx.Err(); err != nil {
+			return written, err
+		}
+		blk, err := cr.Next()
+		if err == io.EOF {
+			return written, nil
+		}
+		if err != nil {
+			return written, fmt.Errorf("reading CAR: %w", err)
+		}
+		if _, ok := cw.seen[string(blk.Cid.Bytes)]; ok || !match(blk.Cid) {
+			blk.Release()
+			continue
+		}
+		err = cw.Put(blk.Cid, blk.Data)
+		blk.Release()
+		if err != nil {
+			return written, err
+		}
+		written++
+	}
+}
+
+// Concatenates CARs into a single CARv1, returning the number of blocks written. Its roots are the roots of
+// every input in order, and each block is written once, the first time it appears. Copying stops with the error
+// of ctx once it is done.
+func Merge(ctx context.Context, w io.Writer, inputs ...io.Reader) (int, error) {
+	readers := make([]*Reader, len(inputs))
+	var seenRoots cid.Set
+	var roots []cid.Cid
+	for i, r := range inputs {
+		cr, err := NewReaderWith(r, ReaderOptions{Pool: true})
+		if err != nil {
+			return 0, fmt.Errorf("CAR %d: %w", i, err)
+		}
+		readers[i] = cr
+		for _, c := range cr.Roots {
+			if seenRoots.Add(c) {
+				roots = append(roots, c)
+			}
+		}
+	}
+	cw, err := NewWriter(w, roots)
+	if err != nil {
+		return 0, err
+	}
+	cw.Dedupe = true
+
+	written := 0
+	for i, cr := range readers {
+		for {
+			if err := ctx.Err(); err != nil {
+				return written, err
+			}
+			blk, err := cr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return written, fmt.Errorf("reading CAR %d: %w", i, err)
+			}
+			if _, ok := cw.seen[string(blk.Cid.Bytes)]; ok {
+				blk.Release()
+				continue
+			}
+			err = cw.Put(blk.Cid, blk.Data)
+			blk.Release()
+			if err != nil {
+				return written, err
+			}
+			written++
+		}
+	}
+	return written, nil
+}
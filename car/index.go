@@ -0,0 +1,231 @@
+package car
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"slices"
+	"sort"
+
+	"github.com/notjuliet/grove/blockstore"
+	"github.com/notjuliet/grove/cid"
+	"github.com/notjuliet/grove/varint"
+)
+
+// Index of the blocks of a CAR file, mapping each CID to the position of its data, for random access to CAR
+// files without an embedded index.
+type Index struct {
+	// Root CIDs listed in the CAR header.
+	Roots []cid.Cid
+	// sorted by CID
+	entries []indexEntry
+}
+
+type indexEntry struct {
+	cid cid.Cid
+	// offset of the block data in the file, past the section length and CID
+	offset uint64
+	length uint64
+}
+
+// Builds an index by scanning a CARv1 or CARv2 file from its start. When a CID appears more than once, the
+// first occurrence is indexed. Scanning stops with the error of ctx once it is done.
+func BuildIndex(ctx context.Context, r io.Reader) (*Index, error) {
+	cr, err := NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	idx := &Index{Roots: cr.Roots}
+	var seen cid.Set
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		blk, err := cr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading CAR: %w", err)
+		}
+		if !seen.Add(blk.Cid) {
+			continue
+		}
+		offset := uint64(cr.r.n) - uint64(len(blk.Data))
+		idx.entries = append(idx.entries, indexEntry{cid: blk.Cid, offset: offset, length: uint64(len(blk.Data))})
+	}
+	slices.SortFunc(idx.entries, func(a, b indexEntry) int { return bytes.Compare(a.cid.Bytes, b.cid.Bytes) })
+	return idx, nil
+}
+
+// Returns the number of indexed blocks.
+func (idx *Index) Len() int {
+	return len(idx.entries)
+}
+
+func (idx *Index) lookup(c cid.Cid) (indexEntry, bool) {
+	i := sort.Search(len(idx.entries), func(i int) bool {
+		return bytes.Compare(idx.entries[i].cid.Bytes, c.Bytes) >= 0
+	})
+	if i == len(idx.entries) || !bytes.Equal(idx.entries[i].cid.Bytes, c.Bytes) {
+		return indexEntry{}, false
+	}
+	return idx.entries[i], true
+}
+
+// Encodes the index for storage next to its CAR file: the roots, then every entry as its CID, offset and
+// length. CIDs are prefixed with their length; all integers are varints.
+func (idx *Index) Bytes() []byte {
+	b := varint.Append(nil, uint64(len(idx.Roots)))
+	for _, c := range idx.Roots {
+		b = varint.Append(b, uint64(len(c.Bytes)))
+		b = append(b, c.Bytes...)
+	}
+	b = varint.Append(b, uint64(len(idx.entries)))
+	for _, e := range idx.entries {
+		b = varint.Append(b, uint64(len(e.cid.Bytes)))
+		b = append(b, e.cid.Bytes...)
+		b = varint.Append(b, e.offset)
+		b = varint.Append(b, e.length)
+	}
+	return b
+}
+
+// Decodes an index encoded by Index.Bytes.
+func DecodeIndex(b []byte) (*Index, error) {
+	errTruncated := errors.New("truncated CAR index")
+	readUint := func() (uint64, error) {
+		v, n, err := varint.Read(b)
+		if err != nil {
+			return 0, errTruncated
+		}
+		b = b[n:]
+		return v, nil
+	}
+	readCid := func() (cid.Cid, error) {
+		length, err := readUint()
+		if err != nil {
+			return cid.Cid{}, err
+		}
+		if length > uint64(len(b)) {
+			return cid.Cid{}, errTruncated
+		}
+		c, err := cid.FromBytes(append([]byte{0}, b[:length]...))
+		if err != nil {
+			return cid.Cid{}, fmt.Errorf("invalid CID in CAR index: %w", err)
+		}
+		b = b[length:]
+		return c, nil
+	}
+
+	idx := &Index{}
+	roots, err := readUint()
+	if err != nil {
+		return nil, err
+	}
+	for range roots {
+		c, err := readCid()
+		if err != nil {
+			return nil, err
+		}
+		idx.Roots = append(idx.Roots, c)
+	}
+	count, err := readUint()
+	if err != nil {
+		return nil, err
+	}
+	// every entry takes at least 3 bytes, which bounds allocations for corrupt input
+	if count > uint64(len(b)/3) {
+		return nil, errTruncated
+	}
+	idx.entries = make([]indexEntry, 0, count)
+	for range count {
+		var e indexEntry
+		if e.cid, err = readCid(); err != nil {
+			return nil, err
+		}
+		if e.offset, err = readUint(); err != nil {
+			return nil, err
+		}
+		if e.length, err = readUint(); err != nil {
+			return nil, err
+		}
+		if n := len(idx.entries); n > 0 && bytes.Compare(idx.entries[n-1].cid.Bytes, e.cid.Bytes) >= 0 {
+			return nil, errors.New("CAR index entries are not sorted")
+		}
+		idx.entries = append(idx.entries, e)
+	}
+	if len(b) != 0 {
+		return nil, fmt.Errorf("CAR index has %d trailing bytes", len(b))
+	}
+	return idx, nil
+}
+
+var ErrReadOnly = errors.New("CAR blockstore is read-only")
+
+// Read-only blockstore serving the blocks of an indexed CAR file through random reads.
+type IndexedBlockstore struct {
+	r   io.ReaderAt
+	idx *Index
+}
+
+// Creates a blockstore over a CAR file and an index built from that file.
+func NewIndexedBlockstore(r io.ReaderAt, idx *Index) *IndexedBlockstore {
+	return &IndexedBlockstore{r: r, idx: idx}
+}
+
+func (bs *IndexedBlockstore) Get(ctx context.Context, c cid.Cid) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	e, ok := bs.idx.lookup(c)
+	if !ok {
+		return nil, blockstore.ErrNotFound
+	}
+	if e.length > MaxSectionSize {
+		return nil, errors.New("CAR index entry exceeds maximum block size")
+	}
+	data := make([]byte, e.length)
+	if n, err := bs.r.ReadAt(data, int64(e.offset)); n < len(data) {
+		return nil, fmt.Errorf("reading block %s: %w", c, cmp.Or(err, io.ErrUnexpectedEOF))
+	}
+	return data, nil
+}
+
+func (bs *IndexedBlockstore) Has(ctx context.Context, c cid.Cid) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	_, ok := bs.idx.lookup(c)
+	return ok, nil
+}
+
+func (bs *IndexedBlockstore) Put(ctx context.Context, c cid.Cid, data []byte) error {
+	return ErrReadOnly
+}
+
+func (bs *IndexedBlockstore) PutMany(ctx context.Context, blocks []blockstore.Block) error {
+	return ErrReadOnly
+}
+
+func (bs *IndexedBlockstore) Delete(ctx context.Context, c cid.Cid) error {
+	return ErrReadOnly
+}
+
+func (bs *IndexedBlockstore) Keys(ctx context.Context) iter.Seq2[cid.Cid, error] {
+	return func(yield func(cid.Cid, error) bool) {
+		for _, e := range bs.idx.entries {
+			if err := ctx.Err(); err != nil {
+				yield(cid.Cid{}, err)
+				return
+			}
+			if !yield(e.cid, nil) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,337 @@
+package car
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/notjuliet/grove/budget"
+	"github.com/notjuliet/grove/cbor"
+	"github.com/notjuliet/grove/cid"
+	"github.com/notjuliet/grove/errdefs"
+	"github.com/notjuliet/grove/limits"
+	"github.com/notjuliet/grove/varint"
+)
+
+// Maximum size of a header or block section. atproto blocks are far smaller, this only bounds allocations
+// when reading untrusted input.
+const MaxSectionSize = 4 << 20
+
+type Block struct {
+	Cid  cid.Cid
+	Data []byte
+	// pooled buffer holding Data, if read in pooled mode
+	buf *[]byte
+}
+
+// buffers of blocks read in pooled mode
+var blockBuffers = sync.Pool{New: func() any { return new([]byte) }}
+
+// Returns the data of a block read in pooled mode to the pool, for reuse by a later block of any reader. Data
+// must not be used afterwards, and a block must be released at most once, including through its copies. It
+// does nothing for other blocks.
+func (b Block) Release() {
+	if b.buf != nil {
+		blockBuffers.Put(b.buf)
+	}
+}
+
+// Returns a copy of the block with its own data, which stays valid once the block is released.
+func (b Block) Clone() Block {
+	return Block{Cid: b.Cid, Data: bytes.Clone(b.Data)}
+}
+
+// Streaming CAR reader, accepting CARv1 and CARv2 input. CARv2 payloads are read sequentially, ignoring the
+// index; see IndexedReader for random access.
+type Reader struct {
+	r *countingReader
+	// CAR format version of the input, either 1 or 2.
+	Version int
+	// Root CIDs listed in the header.
+	Roots []cid.Cid
+	// remaining bytes of a CARv2 data payload, or -1 when unbounded
+	remaining    int64
+	budget       *budget.Budget
+	maxBlockSize int
+	pool         bool
+}
+
+// source of a countingReader, marking its errors as I/O failures rather than malformed input
+type sourceReader struct {
+	r io.Reader
+}
+
+func (s sourceReader) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	if err != nil && err != io.EOF {
+		err = errdefs.Wrap(errdefs.ErrIO, err)
+	}
+	return n, err
+}
+
+// buffered reader keeping track of the input offset
+type countingReader struct {
+	src io.Reader
+	r   *bufio.Reader
+	n   int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func (c *countingReader) ReadByte() (byte, error) {
+	b, err := c.r.ReadByte()
+	if err == nil {
+		c.n++
+	}
+	return b, err
+}
+
+func (c *countingReader) Discard(n int) (int, error) {
+	n, err := c.r.Discard(n)
+	c.n += int64(n)
+	return n, err
+}
+
+// Creates a reader and parses the CAR header.
+func NewReader(r io.Reader) (*Reader, error) {
+	return NewReaderWith(r, ReaderOptions{})
+}
+
+// Creates a reader like NewReader, charging the header and every block read to b. Once b is exhausted, reading
+// fails with a *budget.ExceededError before the next block is allocated.
+func NewReaderBudget(r io.Reader, b *budget.Budget) (*Reader, error) {
+	return NewReaderWith(r, ReaderOptions{Budget: b})
+}
+
+type ReaderOptions struct {
+	// Budget charged with the header and every block read, if set.
+	Budget *budget.Budget
+	// Maximum size of the data of a block, such as limits.MaxBlockSize; Next fails on larger blocks with an
+	// error matching limits.ErrExceeded. Zero means no limit below MaxSectionSize.
+	MaxBlockSize int
+	// Reads block data into pooled buffers rather than allocating a slice per block, for jobs streaming through
+	// many blocks. Callers call Release on each block once done with it, or Clone to keep it; blocks which are
+	// not released are garbage collected like others.
+	Pool bool
+}
+
+// Creates a reader like NewReader, with options.
+func NewReaderWith(r io.Reader, opts ReaderOptions) (*Reader, error) {
+	cr := &Reader{r: &countingReader{src: r, r: bufio.NewReader(sourceReader{r})}, remaining: -1,
+		budget: opts.Budget, maxBlockSize: opts.MaxBlockSize, pool: opts.Pool}
+	if err := cr.readHeader(); err != nil {
+		return nil, errdefs.Wrap(errdefs.ErrMalformed, err)
+	}
+	return cr, nil
+}
+
+// parses the CARv1 header, or the CARv2 header and the CARv1 header of its payload
+func (r *Reader) readHeader() error {
+	header, err := r.readSection(nil)
+	if err != nil {
+		return fmt.Errorf("reading CAR header: %w", err)
+	}
+
+	if bytes.Equal(header, v2Pragma[1:]) {
+		r.Version = 2
+		var h [v2HeaderSize]byte
+		if _, err := io.ReadFull(r.r, h[:]); err != nil {
+			return fmt.Errorf("reading CARv2 header: %w", err)
+		}
+		v2 := parseV2Header(h[:])
+		skip := int64(v2.dataOffset) - int64(len(v2Pragma)+v2HeaderSize)
+		if skip < 0 {
+			return errors.New("invalid CARv2 data offset")
+		}
+		if _, err := r.r.Discard(int(skip)); err != nil {
+			return fmt.Errorf("seeking to CARv2 payload: %w", err)
+		}
+		r.remaining = int64(v2.dataSize)
+		header, err = r.readSection(nil)
+		if err != nil {
+			return fmt.Errorf("reading CARv2 inner header: %w", err)
+		}
+	} else {
+		r.Version = 1
+	}
+
+	r.Roots, err = parseV1Header(header, r.budget)
+	return err
+}
+
+func parseV1Header(b []byte, bud *budget.Budget) ([]cid.Cid, error) {
+	v, err := cbor.DecodeBudget(b, bud)
+	if err != nil {
+		return nil, fmt.Errorf("decoding CAR header: %w", err)
+	}
+	m, ok := v.(map[string]any)
+	if !ok {
+		return nil, errors.New("CAR header is not a map")
+	}
+	if version, _ := m["version"].(uint64); version != 1 {
+		return nil, fmt.Errorf("unsupported CAR version %v", m["version"])
+	}
+	list, ok := m["roots"].([]any)
+	if !ok {
+		return nil, errors.New("CAR header has no roots list")
+	}
+	roots := make([]cid.Cid, 0, len(list))
+	for _, r := range list {
+		link, ok := r.(cid.CidLink)
+		if !ok {
+			return nil, errors.New("CAR root is not a link")
+		}
+		c, err := link.Cid()
+		if err != nil {
+			return nil, fmt.Errorf("invalid CAR root: %w", err)
+		}
+		roots = append(roots, c)
+	}
+	return roots, nil
+}
+
+// moves forward to an input offset, which must be the start of a section, seeking if the input supports it
+func (r *Reader) skipTo(offset int64) error {
+	skip := offset - r.r.n
+	if skip < 0 {
+		return errors.New("cannot move back in CAR")
+	}
+	if r.remaining >= 0 {
+		if skip > r.remaining {
+			return errors.New("offset exceeds CARv2 data size")
+		}
+		r.remaining -= skip
+	}
+	if s, ok := r.r.src.(io.Seeker); ok && skip > int64(r.r.r.Buffered()) {
+		if _, err := s.Seek(offset-r.r.n-int64(r.r.r.Buffered()), io.SeekCurrent); err != nil {
+			return errdefs.Wrap(errdefs.ErrIO, err)
+		}
+		r.r.r.Reset(sourceReader{r.r.src})
+		r.r.n = offset
+		return nil
+	}
+	if _, err := r.r.Discard(int(skip)); err != nil {
+		return err
+	}
+	return nil
+}
+
+// reads a varint length-prefixed section into a new slice, or into buf if set, growing it as needed
+func (r *Reader) readSection(buf *[]byte) ([]byte, error) {
+	if r.remaining == 0 {
+		return nil, io.EOF
+	}
+	length, err := varint.ReadFrom(r.r)
+	if err != nil {
+		return nil, err
+	}
+	if length == 0 || length > MaxSectionSize {
+		return nil, fmt.Errorf("invalid section length %d", length)
+	}
+	if r.remaining > 0 {
+		r.remaining -= int64(varint.Len(length)) + int64(length)
+		if r.remaining < 0 {
+			return nil, errors.New("section exceeds CARv2 data size")
+		}
+	}
+	if err := r.budget.Charge(int64(length)); err != nil {
+		return nil, err
+	}
+	var b []byte
+	if buf == nil {
+		b = make([]byte, length)
+	} else {
+		if uint64(cap(*buf)) < length {
+			*buf = make([]byte, length)
+		}
+		b = (*buf)[:length]
+	}
+	if _, err := io.ReadFull(r.r, b); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return b, nil
+}
+
+// Returns the next block, or io.EOF after the last block. Errors match errdefs.ErrIO if reading the input
+// failed, errdefs.ErrLimit if a limit or budget was exceeded, and errdefs.ErrMalformed otherwise.
+func (r *Reader) Next() (Block, error) {
+	blk, err := r.next()
+	if err != nil && err != io.EOF {
+		return Block{}, errdefs.Wrap(errdefs.ErrMalformed, err)
+	}
+	return blk, err
+}
+
+func (r *Reader) next() (Block, error) {
+	var buf *[]byte
+	if r.pool {
+		buf = blockBuffers.Get().(*[]byte)
+	}
+	blk, err := r.readBlock(buf)
+	if err != nil {
+		if buf != nil {
+			blockBuffers.Put(buf)
+		}
+		return Block{}, err
+	}
+	blk.buf = buf
+	return blk, nil
+}
+
+func (r *Reader) readBlock(buf *[]byte) (Block, error) {
+	section, err := r.readSection(buf)
+	if err != nil {
+		return Block{}, err
+	}
+	blk, err := parseBlock(section)
+	if err != nil {
+		return Block{}, err
+	}
+	if err := limits.Check(len(blk.Data), r.maxBlockSize, "bytes"); err != nil {
+		return Block{}, fmt.Errorf("block %s: %w", blk.Cid, err)
+	}
+	return blk, nil
+}
+
+func parseBlock(section []byte) (Block, error) {
+	n, err := cidLength(section)
+	if err != nil {
+		return Block{}, err
+	}
+	c, err := cid.FromBytes(append([]byte{0}, section[:n]...))
+	if err != nil {
+		return Block{}, fmt.Errorf("invalid block CID: %w", err)
+	}
+	return Block{Cid: c, Data: section[n:]}, nil
+}
+
+// returns the length of the binary CIDv1 at the start of b
+func cidLength(b []byte) (int, error) {
+	p := 0
+	var digestLen uint64
+	// version, codec, hash type, digest length
+	for i := range 4 {
+		v, n, err := varint.Read(b[p:])
+		if err != nil {
+			return 0, fmt.Errorf("invalid CID varint: %w", err)
+		}
+		p += n
+		if i == 3 {
+			digestLen = v
+		}
+	}
+	if digestLen > uint64(len(b)-p) {
+		return 0, errors.New("CID digest exceeds section")
+	}
+	return p + int(digestLen), nil
+}
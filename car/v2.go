@@ -0,0 +1,312 @@
+package car
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"sort"
+
+	"github.com/notjuliet/grove/cid"
+	"github.com/notjuliet/grove/varint"
+)
+
+// fixed prefix of every CARv2 file: a CARv1-style header declaring version 2
+var v2Pragma = []byte{0x0a, 0xa1, 0x67, 0x76, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x02}
+
+const (
+	v2HeaderSize = 40
+	// multicodec of the only index format written and read by this package
+	multihashIndexSorted = 0x0401
+)
+
+var ErrNotFound = errors.New("block not found")
+
+type v2Header struct {
+	characteristics [16]byte
+	dataOffset      uint64
+	dataSize        uint64
+	indexOffset     uint64
+}
+
+func parseV2Header(b []byte) v2Header {
+	var h v2Header
+	copy(h.characteristics[:], b[:16])
+	h.dataOffset = binary.LittleEndian.Uint64(b[16:])
+	h.dataSize = binary.LittleEndian.Uint64(b[24:])
+	h.indexOffset = binary.LittleEndian.Uint64(b[32:])
+	return h
+}
+
+func (h v2Header) bytes() []byte {
+	b := make([]byte, v2HeaderSize)
+	copy(b, h.characteristics[:])
+	binary.LittleEndian.PutUint64(b[16:], h.dataOffset)
+	binary.LittleEndian.PutUint64(b[24:], h.dataSize)
+	binary.LittleEndian.PutUint64(b[32:], h.indexOffset)
+	return b
+}
+
+type countingWriter struct {
+	w io.Writer
+	n uint64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += uint64(n)
+	return n, err
+}
+
+// CARv2 writer which appends a multihash-sorted index after the CARv1 payload. Duplicate blocks are always
+// skipped, so every indexed CID points at exactly one section.
+type V2Writer struct {
+	w     io.WriteSeeker
+	start int64
+	cw    *countingWriter
+	v1    *Writer
+	// hash type → digest width → entries of digest and payload offset
+	index map[uint64]map[uint32][][]byte
+}
+
+// Creates a writer and writes the CARv2 pragma, a placeholder header, and the inner CARv1 header.
+func NewV2Writer(w io.WriteSeeker, roots []cid.Cid) (*V2Writer, error) {
+	start, err := w.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(v2Pragma); err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(make([]byte, v2HeaderSize)); err != nil {
+		return nil, err
+	}
+	cw := &countingWriter{w: w}
+	v1, err := NewWriter(cw, roots)
+	if err != nil {
+		return nil, err
+	}
+	v1.Dedupe = true
+	return &V2Writer{w: w, start: start, cw: cw, v1: v1, index: map[uint64]map[uint32][][]byte{}}, nil
+}
+
+// Appends a block to the CAR payload and records it in the index.
+func (w *V2Writer) Put(c cid.Cid, data []byte) error {
+	if _, ok := w.v1.seen[string(c.Bytes)]; ok {
+		return nil
+	}
+	offset := w.cw.n
+	if err := w.v1.Put(c, data); err != nil {
+		return err
+	}
+
+	entry := make([]byte, len(c.Digest)+8)
+	copy(entry, c.Digest)
+	binary.LittleEndian.PutUint64(entry[len(c.Digest):], offset)
+
+	widths, ok := w.index[uint64(c.HashType)]
+	if !ok {
+		widths = map[uint32][][]byte{}
+		w.index[uint64(c.HashType)] = widths
+	}
+	width := uint32(len(entry))
+	widths[width] = append(widths[width], entry)
+	return nil
+}
+
+// Writes the index and finalizes the header. The underlying writer is left positioned at the end of the file.
+func (w *V2Writer) Close() error {
+	dataSize := w.cw.n
+	if _, err := w.w.Write(encodeIndex(w.index)); err != nil {
+		return err
+	}
+	end, err := w.w.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+
+	dataOffset := uint64(len(v2Pragma) + v2HeaderSize)
+	h := v2Header{dataOffset: dataOffset, dataSize: dataSize, indexOffset: dataOffset + dataSize}
+	if _, err := w.w.Seek(w.start+int64(len(v2Pragma)), io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := w.w.Write(h.bytes()); err != nil {
+		return err
+	}
+	_, err = w.w.Seek(end, io.SeekStart)
+	return err
+}
+
+func encodeIndex(index map[uint64]map[uint32][][]byte) []byte {
+	b := varint.Append(nil, multihashIndexSorted)
+	b = binary.LittleEndian.AppendUint32(b, uint32(len(index)))
+	for _, code := range slices.Sorted(maps.Keys(index)) {
+		widths := index[code]
+		b = binary.LittleEndian.AppendUint64(b, code)
+		b = binary.LittleEndian.AppendUint32(b, uint32(len(widths)))
+		for _, width := range slices.Sorted(maps.Keys(widths)) {
+			entries := widths[width]
+			slices.SortFunc(entries, func(a, b []byte) int {
+				return bytes.Compare(a[:width-8], b[:width-8])
+			})
+			b = binary.LittleEndian.AppendUint32(b, width)
+			b = binary.LittleEndian.AppendUint64(b, uint64(len(entries))*uint64(width))
+			for _, e := range entries {
+				b = append(b, e...)
+			}
+		}
+	}
+	return b
+}
+
+// Random-access reader over an indexed CARv2 file.
+type IndexedReader struct {
+	r          io.ReaderAt
+	dataOffset uint64
+	dataSize   uint64
+	// hash type → digest width → concatenated sorted entries
+	index map[uint64]map[uint32][]byte
+	// Root CIDs listed in the inner CARv1 header.
+	Roots []cid.Cid
+}
+
+// Opens a CARv2 file and loads its index, which runs from its offset to the end of the file. size is the total
+// length of the file.
+func OpenIndexed(r io.ReaderAt, size int64) (*IndexedReader, error) {
+	head := make([]byte, len(v2Pragma)+v2HeaderSize)
+	if _, err := r.ReadAt(head, 0); err != nil {
+		return nil, fmt.Errorf("reading CARv2 header: %w", err)
+	}
+	if !bytes.Equal(head[:len(v2Pragma)], v2Pragma) {
+		return nil, errors.New("not a CARv2 file")
+	}
+	h := parseV2Header(head[len(v2Pragma):])
+	if h.indexOffset == 0 {
+		return nil, errors.New("CARv2 file has no index")
+	}
+	// the payload and then the index must fit in the file, compared without overflowing
+	if size < 0 || h.dataOffset > uint64(size) || h.dataSize > uint64(size)-h.dataOffset {
+		return nil, errors.New("CARv2 payload exceeds file size")
+	}
+	if h.indexOffset < h.dataOffset+h.dataSize || h.indexOffset > uint64(size) {
+		return nil, errors.New("CARv2 index offset is outside the file after the payload")
+	}
+
+	inner, err := NewReader(io.NewSectionReader(r, int64(h.dataOffset), int64(h.dataSize)))
+	if err != nil {
+		return nil, err
+	}
+
+	raw := make([]byte, uint64(size)-h.indexOffset)
+	if _, err := r.ReadAt(raw, int64(h.indexOffset)); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("reading CARv2 index: %w", err)
+	}
+	index, err := decodeIndex(raw)
+	if err != nil {
+		return nil, err
+	}
+	return &IndexedReader{r: r, dataOffset: h.dataOffset, dataSize: h.dataSize, index: index, Roots: inner.Roots}, nil
+}
+
+func decodeIndex(b []byte) (map[uint64]map[uint32][]byte, error) {
+	errTruncated := errors.New("truncated CARv2 index")
+	codec, n, err := varint.Read(b)
+	if err != nil {
+		return nil, errTruncated
+	}
+	if codec != multihashIndexSorted {
+		return nil, fmt.Errorf("unsupported CARv2 index type 0x%x", codec)
+	}
+	b = b[n:]
+
+	if len(b) < 4 {
+		return nil, errTruncated
+	}
+	codes := binary.LittleEndian.Uint32(b)
+	b = b[4:]
+	index := map[uint64]map[uint32][]byte{}
+	for range codes {
+		if len(b) < 12 {
+			return nil, errTruncated
+		}
+		code := binary.LittleEndian.Uint64(b)
+		buckets := binary.LittleEndian.Uint32(b[8:])
+		b = b[12:]
+		widths := map[uint32][]byte{}
+		for range buckets {
+			if len(b) < 12 {
+				return nil, errTruncated
+			}
+			width := binary.LittleEndian.Uint32(b)
+			length := binary.LittleEndian.Uint64(b[4:])
+			b = b[12:]
+			if width < 8 || length%uint64(width) != 0 || length > uint64(len(b)) {
+				return nil, errors.New("invalid CARv2 index bucket")
+			}
+			widths[width] = b[:length]
+			b = b[length:]
+		}
+		index[code] = widths
+	}
+	return index, nil
+}
+
+// Returns the payload offset of a block's section, or false if the index does not contain it.
+func (r *IndexedReader) lookup(c cid.Cid) (uint64, bool) {
+	width := uint32(len(c.Digest) + 8)
+	entries := r.index[uint64(c.HashType)][width]
+	count := len(entries) / int(width)
+	i := sort.Search(count, func(i int) bool {
+		return bytes.Compare(entries[i*int(width):i*int(width)+len(c.Digest)], c.Digest) >= 0
+	})
+	if i == count {
+		return 0, false
+	}
+	entry := entries[i*int(width) : (i+1)*int(width)]
+	if !bytes.Equal(entry[:len(c.Digest)], c.Digest) {
+		return 0, false
+	}
+	return binary.LittleEndian.Uint64(entry[len(c.Digest):]), true
+}
+
+// Reports whether the index contains a block.
+func (r *IndexedReader) Has(c cid.Cid) bool {
+	_, ok := r.lookup(c)
+	return ok
+}
+
+// Reads a single block, returning ErrNotFound if it is not in the index.
+func (r *IndexedReader) Get(c cid.Cid) ([]byte, error) {
+	offset, ok := r.lookup(c)
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if offset >= r.dataSize {
+		return nil, errors.New("CARv2 index offset exceeds payload")
+	}
+
+	var prefix [varint.MaxLen]byte
+	n, err := r.r.ReadAt(prefix[:], int64(r.dataOffset+offset))
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	length, vn, err := varint.Read(prefix[:n])
+	if err != nil || length == 0 || length > MaxSectionSize || offset+uint64(vn)+length > r.dataSize {
+		return nil, errors.New("invalid section at CARv2 index offset")
+	}
+	section := make([]byte, length)
+	if _, err := r.r.ReadAt(section, int64(r.dataOffset+offset+uint64(vn))); err != nil && err != io.EOF {
+		return nil, err
+	}
+	blk, err := parseBlock(section)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(blk.Cid.Bytes, c.Bytes) {
+		return nil, errors.New("CARv2 index points at a different block")
+	}
+	return blk.Data, nil
+}
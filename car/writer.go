@@ -0,0 +1,62 @@
+package car
+
+import (
+	"io"
+
+	"github.com/notjuliet/grove/cbor"
+	"github.com/notjuliet/grove/cid"
+	"github.com/notjuliet/grove/varint"
+)
+
+// Streaming CARv1 writer.
+//
+// https://ipld.io/specs/transport/car/carv1/
+type Writer struct {
+	w    io.Writer
+	buf  []byte
+	seen map[string]struct{}
+	// Skip blocks whose CID has already been written.
+	Dedupe bool
+}
+
+// Creates a writer and writes the CAR header listing the given roots.
+func NewWriter(w io.Writer, roots []cid.Cid) (*Writer, error) {
+	header, err := encodeHeader(roots)
+	if err != nil {
+		return nil, err
+	}
+	cw := &Writer{w: w, seen: map[string]struct{}{}}
+	cw.buf = varint.Append(cw.buf[:0], uint64(len(header)))
+	cw.buf = append(cw.buf, header...)
+	if _, err := w.Write(cw.buf); err != nil {
+		return nil, err
+	}
+	return cw, nil
+}
+
+func encodeHeader(roots []cid.Cid) ([]byte, error) {
+	links := make([]any, len(roots))
+	for i, r := range roots {
+		links[i] = r.Link()
+	}
+	return cbor.Encode(map[string]any{
+		"roots":   links,
+		"version": uint64(1),
+	})
+}
+
+// Appends a block to the CAR.
+func (w *Writer) Put(c cid.Cid, data []byte) error {
+	if w.Dedupe {
+		key := string(c.Bytes)
+		if _, ok := w.seen[key]; ok {
+			return nil
+		}
+		w.seen[key] = struct{}{}
+	}
+	w.buf = varint.Append(w.buf[:0], uint64(len(c.Bytes)+len(data)))
+	w.buf = append(w.buf, c.Bytes...)
+	w.buf = append(w.buf, data...)
+	_, err := w.w.Write(w.buf)
+	return err
+}
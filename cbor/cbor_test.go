@@ -1,12 +1,18 @@
 package cbor
 
 import (
+	"bytes"
 	"encoding/base64"
+	"errors"
 	"math"
 	"reflect"
+	"strings"
 	"testing"
 
+	"github.com/notjuliet/grove/budget"
 	"github.com/notjuliet/grove/cid"
+	"github.com/notjuliet/grove/errdefs"
+	"github.com/notjuliet/grove/limits"
 )
 
 var object = map[string]any{
@@ -197,3 +203,111 @@ func BenchmarkEncode(b *testing.B) {
 		}
 	}
 }
+
+func TestLinks(t *testing.T) {
+	a, _ := cid.Create(cid.CodecRaw, []byte("a"))
+	b, _ := cid.Create(cid.CodecCbor, []byte("b"))
+	buf, err := Encode(map[string]any{"a": a.Link(), "nested": []any{"text", map[string]any{"b": b.Link()}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	links, err := Links(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(links) != 2 || links[0].String() != a.String() || links[1].String() != b.String() {
+		t.Fatalf("unexpected links %v", links)
+	}
+}
+
+type point struct{ x, y int64 }
+
+func (p point) MarshalCBOR() ([]byte, error) {
+	return Encode([]any{p.x, p.y})
+}
+
+func TestMarshaler(t *testing.T) {
+	buf, err := Encode(map[string]any{"a": point{1, -2}, "b": []any{&point{3, 4}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, _ := Encode(map[string]any{"a": []any{1, -2}, "b": []any{[]any{3, 4}}})
+	if !bytes.Equal(buf, expected) {
+		t.Fatalf("unexpected encoding %x", buf)
+	}
+}
+
+func TestDecodeLimits(t *testing.T) {
+	enc, err := Encode(object)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := budget.New(4096)
+	v, err := DecodeBudget(enc, b)
+	if err != nil || !reflect.DeepEqual(v, object) {
+		t.Fatalf("unexpected value %v: %v", v, err)
+	}
+	if b.Used() == 0 {
+		t.Fatal("expected budget to be charged")
+	}
+
+	var exceeded *budget.ExceededError
+	if _, err := DecodeBudget(buffer, budget.New(64)); !errors.As(err, &exceeded) || exceeded.Limit != 64 {
+		t.Fatalf("expected exceeded error, got %v", err)
+	}
+	// An array claiming 2^32 elements over a few bytes must fail without sizing a slice from its header.
+	if _, err := DecodeBudget([]byte{0x9b, 0, 0, 0, 1, 0, 0, 0, 0, 1}, budget.New(1<<20)); err == nil {
+		t.Fatal("expected error for truncated array")
+	}
+	if _, err := DecodeBudget(bytes.Repeat([]byte{0x81}, 1000), budget.New(1024)); !errors.As(err, &exceeded) {
+		t.Fatalf("expected exceeded error for nested arrays, got %v", err)
+	}
+
+	if _, err := DecodeWith(enc, DecodeOptions{MaxSize: len(enc)}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := DecodeWith(enc, DecodeOptions{MaxSize: len(enc) - 1}); !errors.Is(err, limits.ErrExceeded) {
+		t.Fatalf("expected size limit error, got %v", err)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	for buf, offset := range map[string]int{
+		"\xa2\x61a\x01\x61a\x02":           6, // duplicate key
+		"\x82\x01":                         2, // truncated array
+		"\x01\x02":                         1, // trailing data
+		"\xd8\x2a\x45\x00\x01\x71\x12\x20": 3, // bad CID
+	} {
+		_, err := Decode([]byte(buf))
+		var decodeErr *DecodeError
+		if !errors.As(err, &decodeErr) || decodeErr.Offset != offset || errdefs.Category(err) != "malformed" {
+			t.Errorf("unexpected error for % x: %v", buf, err)
+		}
+	}
+	if _, err := DecodeBudget(buffer, budget.New(16)); errdefs.Category(err) != "limit" {
+		t.Fatalf("expected limit error, got %v", err)
+	}
+	if _, err := Encode(map[string]any{"f": math.NaN()}); !errors.Is(err, errdefs.ErrInvalid) {
+		t.Fatalf("expected invalid error, got %v", err)
+	}
+}
+
+func TestDebug(t *testing.T) {
+	bad := []byte("\xa1\x61a\x82\x01\x1c")
+	_, err := Decode(bad)
+	var decodeErr *DecodeError
+	if !errors.As(err, &decodeErr) || decodeErr.Path != "" || decodeErr.Dump != "" {
+		t.Fatalf("unexpected error without debug mode: %v", err)
+	}
+	_, err = DecodeWith(bad, DecodeOptions{Debug: true})
+	if !errors.As(err, &decodeErr) || decodeErr.Path != "a/1" || !strings.Contains(decodeErr.Dump, "a1 61 61 82 01 1c") {
+		t.Fatalf("unexpected error in debug mode: %v", err)
+	}
+
+	SetDebug(true)
+	defer SetDebug(false)
+	_, err = Decode([]byte("\x01\x02"))
+	if !errors.As(err, &decodeErr) || !strings.Contains(decodeErr.Dump, "^^") {
+		t.Fatalf("unexpected trailing data error in debug mode: %v", err)
+	}
+}
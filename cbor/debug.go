@@ -0,0 +1,76 @@
+package cbor
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// bytes shown on each side of the failing offset in debug dumps
+const dumpWindow = 32
+
+var debug atomic.Bool
+
+// Enables or disables debug mode for every decode in the process, including those made by other packages such
+// as car and repo, to investigate malformed blocks: decode errors then record the path of the value being
+// decoded and a hex dump of the input around the failing offset. Dumps expose input bytes in error messages,
+// so debug mode is not meant for production services.
+func SetDebug(enabled bool) {
+	debug.Store(enabled)
+}
+
+// returns the path of the value being decoded, from the outermost container to the innermost, with map values
+// named by their key and array elements by their index
+func (c *container) path() string {
+	var elems []string
+	for ; c != nil; c = c.next {
+		switch {
+		case !c.isMap:
+			elems = append(elems, strconv.Itoa(len(*c.elements.(*[]any))))
+		case c.currMapKey != nil:
+			elems = append(elems, *c.currMapKey)
+		case c.prevMapKeyBytes == nil:
+			elems = append(elems, "(first key)")
+		default:
+			elems = append(elems, "(key after "+string(c.prevMapKeyBytes)+")")
+		}
+	}
+	slices.Reverse(elems)
+	return strings.Join(elems, "/")
+}
+
+// returns a hex dump of buf around offset, in lines of 16 bytes prefixed with their offset, marking the byte at
+// offset with a caret line below it
+func dump(buf []byte, offset int) string {
+	start := max(offset-dumpWindow, 0) &^ 15
+	end := min(offset+dumpWindow, len(buf))
+	var b strings.Builder
+	for line := start; line < end; line += 16 {
+		chunk := buf[line:min(line+16, end)]
+		fmt.Fprintf(&b, "%08x ", line)
+		for i := range 16 {
+			if i < len(chunk) {
+				fmt.Fprintf(&b, " %02x", chunk[i])
+			} else {
+				b.WriteString("   ")
+			}
+		}
+		b.WriteString("  |")
+		for _, c := range chunk {
+			if c < 0x20 || c > 0x7e {
+				c = '.'
+			}
+			b.WriteByte(c)
+		}
+		b.WriteString("|\n")
+		if offset >= line && offset < line+16 {
+			fmt.Fprintf(&b, "%*s^^\n", 10+(offset-line)*3, "")
+		}
+	}
+	if offset >= len(buf) {
+		fmt.Fprintf(&b, "end of input at offset %08x\n", offset)
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
@@ -6,15 +6,65 @@ import (
 	"errors"
 	"fmt"
 	"math"
-	"reflect"
 	"unicode/utf8"
 
+	"github.com/notjuliet/grove/budget"
 	"github.com/notjuliet/grove/cid"
+	"github.com/notjuliet/grove/errdefs"
+	"github.com/notjuliet/grove/limits"
 )
 
+// Approximate sizes charged to a budget, on 64-bit platforms: every decoded value is held in an interface,
+// and map entries add a string header, an interface and bucket overhead.
+const (
+	valueCost    = 16
+	mapEntryCost = 48
+)
+
+// Returned when decoding fails on malformed input. It matches errdefs.ErrMalformed; decoding stopped by a
+// budget or size limit fails with their errors instead.
+type DecodeError struct {
+	// Offset in the input at which decoding failed.
+	Offset int
+	Err    error
+	// In debug mode, see SetDebug: path of the value being decoded, made of map keys and array indices joined
+	// by "/", and a hex dump of the input around Offset.
+	Path string
+	Dump string
+}
+
+func (e *DecodeError) Error() string {
+	msg := fmt.Sprintf("%v at offset %d", e.Err, e.Offset)
+	if e.Path != "" {
+		msg += " in " + e.Path
+	}
+	if e.Dump != "" {
+		msg += "\n" + e.Dump
+	}
+	return msg
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+func (e *DecodeError) Is(target error) bool {
+	return target == errdefs.ErrMalformed
+}
+
+func trailingError(buf, rmd []byte, opts DecodeOptions) error {
+	err := fmt.Errorf("decoding finished with %d remaining bytes", len(rmd))
+	e := &DecodeError{Offset: len(buf) - len(rmd), Err: err}
+	if opts.Debug || debug.Load() {
+		e.Dump = dump(buf, e.Offset)
+	}
+	return e
+}
+
 type state struct {
-	b []byte
-	p int // position
+	b      []byte
+	p      int // position
+	budget *budget.Budget
 }
 
 func (s *state) ensureRead(n int) error {
@@ -114,6 +164,9 @@ func (s *state) readBytes(length uint64) ([]byte, error) {
 	if length > uint64(len(s.b)-s.p) {
 		return nil, fmt.Errorf("unexpected end of input reading bytes: need %d, have %d", length, len(s.b)-s.p)
 	}
+	if err := s.budget.Charge(int64(length)); err != nil {
+		return nil, err
+	}
 	slice := make([]byte, length)
 	copy(slice, s.b[s.p:s.p+int(length)])
 	s.p += int(length)
@@ -157,11 +210,14 @@ func (s *state) readCid(length uint64) (cid.CidLink, error) {
 	if cidLen <= 0 {
 		return cid.CidLink{}, fmt.Errorf("invalid CID length")
 	}
+	if err := s.budget.Charge(int64(cidLen)); err != nil {
+		return cid.CidLink{}, err
+	}
 	cidBytes := make([]byte, cidLen)
 	copy(cidBytes, s.b[s.p+1:s.p+int(length)])
 	c := cid.CidLink{Bytes: cidBytes}
 	if _, err := cid.Parse(c.String()); err != nil {
-		return cid.CidLink{}, fmt.Errorf("invalid CID: %w", err)
+		return cid.CidLink{}, err
 	}
 	s.p += int(length)
 	return c, nil
@@ -177,12 +233,56 @@ type container struct {
 }
 
 func DecodeFirst(buf []byte) (value any, remainder []byte, err error) {
+	return decodeFirst(buf, DecodeOptions{})
+}
+
+// Decodes a single value like Decode, charging the memory it materializes to b. Once b is exhausted, decoding
+// fails with a *budget.ExceededError before allocating more.
+func DecodeBudget(buf []byte, b *budget.Budget) (any, error) {
+	return DecodeWith(buf, DecodeOptions{Budget: b})
+}
+
+type DecodeOptions struct {
+	// Budget charged with the memory the value materializes, if set.
+	Budget *budget.Budget
+	// Maximum size of the encoded value, such as limits.MaxRecordSize; larger input fails with an error
+	// matching limits.ErrExceeded. Zero means no limit.
+	MaxSize int
+	// Records the path and a hex dump of the input in decode errors, like SetDebug for every decode.
+	Debug bool
+}
+
+// Decodes a single value like Decode, with options.
+func DecodeWith(buf []byte, opts DecodeOptions) (any, error) {
+	if err := limits.Check(len(buf), opts.MaxSize, "bytes"); err != nil {
+		return nil, err
+	}
+	val, rmd, err := decodeFirst(buf, opts)
+	if err != nil {
+		return nil, err
+	}
+	if len(rmd) != 0 {
+		return val, trailingError(buf, rmd, opts)
+	}
+	return val, nil
+}
+
+func decodeFirst(buf []byte, opts DecodeOptions) (value any, remainder []byte, err error) {
+	s := &state{b: buf, p: 0, budget: opts.Budget}
+	var stack *container = nil
+	defer func() {
+		if err != nil && !errors.Is(err, errdefs.ErrLimit) {
+			e := &DecodeError{Offset: s.p, Err: err}
+			if opts.Debug || debug.Load() {
+				e.Path, e.Dump = stack.path(), dump(buf, s.p)
+			}
+			err = e
+		}
+	}()
 	if len(buf) == 0 {
 		return nil, nil, errors.New("input buffer is empty")
 	}
 
-	s := &state{b: buf, p: 0}
-	var stack *container = nil
 	var currVal any
 
 	for s.p < len(s.b) {
@@ -198,6 +298,9 @@ func DecodeFirst(buf []byte) (value any, remainder []byte, err error) {
 				return nil, s.b[s.p:], fmt.Errorf("reading argument for type %d: %w", majorType, err)
 			}
 		}
+		if err := s.budget.Charge(valueCost); err != nil {
+			return nil, s.b[s.p:], err
+		}
 
 		switch majorType {
 		case 0: // Unsigned Integer
@@ -215,7 +318,12 @@ func DecodeFirst(buf []byte) (value any, remainder []byte, err error) {
 				return nil, s.b[s.p:], err
 			}
 		case 4: // Array
-			arr := make([]any, 0, int(arg))
+			// every item takes at least a byte, which bounds the capacity of untrusted lengths
+			size := min(arg, uint64(len(s.b)-s.p))
+			if err := s.budget.Charge(int64(size) * valueCost); err != nil {
+				return nil, s.b[s.p:], err
+			}
+			arr := make([]any, 0, int(size))
 			if arg > 0 {
 				currVal = &arr
 				stack = &container{
@@ -228,7 +336,11 @@ func DecodeFirst(buf []byte) (value any, remainder []byte, err error) {
 			}
 			currVal = arr
 		case 5: // Map
-			m := make(map[string]any, int(arg))
+			size := min(arg, uint64(len(s.b)-s.p)/2)
+			if err := s.budget.Charge(int64(size) * mapEntryCost); err != nil {
+				return nil, s.b[s.p:], err
+			}
+			m := make(map[string]any, int(size))
 			if arg > 0 {
 				currVal = &m
 				stack = &container{
@@ -323,7 +435,12 @@ func DecodeFirst(buf []byte) (value any, remainder []byte, err error) {
 
 			stack.remaining--
 			if stack.remaining == 0 {
-				currVal = reflect.ValueOf(stack.elements).Elem().Interface()
+				switch elements := stack.elements.(type) {
+				case *[]any:
+					currVal = *elements
+				case *map[string]any:
+					currVal = *elements
+				}
 				stack = stack.next
 			} else {
 				goto nextItem
@@ -332,6 +449,9 @@ func DecodeFirst(buf []byte) (value any, remainder []byte, err error) {
 		break
 	nextItem:
 	}
+	if stack != nil {
+		return nil, nil, errors.New("unexpected end of input: unterminated array or map")
+	}
 
 	return currVal, s.b[s.p:], nil
 }
@@ -342,7 +462,41 @@ func Decode(buf []byte) (any, error) {
 		return nil, err
 	}
 	if len(rmd) != 0 {
-		return val, fmt.Errorf("decoding finished with %d remaining bytes", len(rmd))
+		return val, trailingError(buf, rmd, DecodeOptions{})
 	}
 	return val, nil
 }
+
+// Returns every CID link in a DAG-CBOR value, in encoding order.
+func Links(buf []byte) ([]cid.Cid, error) {
+	val, err := Decode(buf)
+	if err != nil {
+		return nil, err
+	}
+	var links []cid.Cid
+	var visit func(v any) error
+	visit = func(v any) error {
+		switch v := v.(type) {
+		case cid.CidLink:
+			c, err := v.Cid()
+			if err != nil {
+				return err
+			}
+			links = append(links, c)
+		case []any:
+			for _, item := range v {
+				if err := visit(item); err != nil {
+					return err
+				}
+			}
+		case map[string]any:
+			for _, k := range SortedKeys(v) {
+				if err := visit(v[k]); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	return links, visit(val)
+}
@@ -9,8 +9,15 @@ import (
 	"strings"
 
 	"github.com/notjuliet/grove/cid"
+	"github.com/notjuliet/grove/errdefs"
 )
 
+// Implemented by types encoding themselves, such as the wrappers of lexicon unions. The encoding must be a
+// single canonical DAG-CBOR value, such as one returned by Encode.
+type Marshaler interface {
+	MarshalCBOR() ([]byte, error)
+}
+
 type encState struct {
 	b         []byte
 	p         int // position
@@ -112,6 +119,14 @@ func (s *encState) writeCid(link cid.CidLink) {
 	s.p += len(val)
 }
 
+func (s *encState) writeInt(v int64) {
+	if v >= 0 {
+		s.writeTypeArgument(0, uint64(v))
+	} else {
+		s.writeTypeArgument(1, uint64(-1-v))
+	}
+}
+
 func (s *encState) writeAny(value any) error {
 	switch v := value.(type) {
 	case nil:
@@ -129,19 +144,36 @@ func (s *encState) writeAny(value any) error {
 	case []byte:
 		s.writeBytes(v, 2)
 
-	case int, int8, int16, int32, int64:
-		if v.(int64) >= 0 {
-			s.writeTypeArgument(0, uint64(v.(int64)))
-		} else {
-			s.writeTypeArgument(1, uint64(-1-v.(int64)))
-		}
+	case int:
+		s.writeInt(int64(v))
+	case int8:
+		s.writeInt(int64(v))
+	case int16:
+		s.writeInt(int64(v))
+	case int32:
+		s.writeInt(int64(v))
+	case int64:
+		s.writeInt(v)
 
-	case uint, uint8, uint16, uint32, uint64:
-		s.writeTypeArgument(0, v.(uint64))
+	case uint:
+		s.writeTypeArgument(0, uint64(v))
+	case uint8:
+		s.writeTypeArgument(0, uint64(v))
+	case uint16:
+		s.writeTypeArgument(0, uint64(v))
+	case uint32:
+		s.writeTypeArgument(0, uint64(v))
+	case uint64:
+		s.writeTypeArgument(0, v)
 
-	case float32, float64:
-		if err := s.writeFloat64(v.(float64)); err != nil {
-			s.currValue = &v
+	case float32:
+		if err := s.writeFloat64(float64(v)); err != nil {
+			s.currValue = &value
+			return err
+		}
+	case float64:
+		if err := s.writeFloat64(v); err != nil {
+			s.currValue = &value
 			return err
 		}
 
@@ -155,22 +187,7 @@ func (s *encState) writeAny(value any) error {
 		}
 
 	case map[string]any:
-		keys := make([]string, 0, len(v))
-		for k := range v {
-			keys = append(keys, k)
-		}
-
-		slices.SortFunc(keys, func(a, b string) int {
-			lenA := len(a)
-			lenB := len(b)
-			if lenA != lenB {
-				if lenA < lenB {
-					return -1
-				}
-				return 1
-			}
-			return strings.Compare(a, b)
-		})
+		keys := SortedKeys(v)
 
 		s.writeTypeArgument(5, uint64(len(v)))
 		for _, key := range keys {
@@ -184,6 +201,14 @@ func (s *encState) writeAny(value any) error {
 	case cid.CidLink:
 		s.writeCid(v)
 
+	case Marshaler:
+		b, err := v.MarshalCBOR()
+		if err != nil {
+			return err
+		}
+		s.ensureWrite(len(b))
+		s.p += copy(s.b[s.p:], b)
+
 	default:
 		s.currValue = &v
 		return errors.New("Error while encoding CBOR")
@@ -192,6 +217,26 @@ func (s *encState) writeAny(value any) error {
 	return nil
 }
 
+// Returns the keys of a map in DAG-CBOR order: shorter keys first, then bytewise.
+func SortedKeys(m map[string]any) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.SortFunc(keys, func(a, b string) int {
+		lenA := len(a)
+		lenB := len(b)
+		if lenA != lenB {
+			if lenA < lenB {
+				return -1
+			}
+			return 1
+		}
+		return strings.Compare(a, b)
+	})
+	return keys
+}
+
 func Encode(value any) ([]byte, error) {
 	s := &encState{b: make([]byte, 1024)}
 
@@ -205,7 +250,7 @@ func Encode(value any) ([]byte, error) {
 		if s.currValue != nil {
 			err = errors.Join(err, fmt.Errorf("unsupported type for CBOR encoding: %T", *s.currValue))
 		}
-		return nil, err
+		return nil, errdefs.Wrap(errdefs.ErrInvalid, err)
 	}
 
 	return s.b[:s.p], nil
@@ -3,9 +3,15 @@ package cid
 import (
 	"crypto/sha256"
 	"encoding/base32"
-	"errors"
+	"fmt"
+
+	"github.com/notjuliet/grove/errdefs"
+	"github.com/notjuliet/grove/varint"
 )
 
+// Returned for malformed or unsupported CIDs, matching errdefs.ErrMalformed.
+var ErrInvalid = errdefs.New(errdefs.ErrMalformed, "invalid cid")
+
 const (
 	Version   = 1
 	SHA256    = 0x12
@@ -32,13 +38,17 @@ type Cid struct {
 }
 
 func Create(codec int, value []byte) (Cid, error) {
+	digest := sha256.Sum256(value)
+	return FromDigest(codec, digest[:])
+}
+
+// Creates a CID from the SHA-256 digest of the content, for content hashed while being streamed.
+func FromDigest(codec int, digest []byte) (Cid, error) {
 	if codec != CodecRaw && codec != CodecCbor {
-		return Cid{}, errors.New("invalid codec")
+		return Cid{}, fmt.Errorf("%w: unsupported codec", ErrInvalid)
 	}
-
-	digest := sha256.Sum256(value)
-	if len(digest) != 32 {
-		return Cid{}, errors.New("invalid digest length")
+	if len(digest) != sha256.Size {
+		return Cid{}, fmt.Errorf("%w: digest is not %d bytes", ErrInvalid, sha256.Size)
 	}
 
 	// a SHA-256 CIDv1 is 36 bytes long, 4 bytes for the header, 32 bytes for the digest.
@@ -48,14 +58,14 @@ func Create(codec int, value []byte) (Cid, error) {
 	bytes[2] = SHA256
 	bytes[3] = 32
 
-	copy(bytes[4:], digest[:])
+	copy(bytes[4:], digest)
 
-	return Cid{Version, codec, SHA256, digest[:], bytes}, nil
+	return Cid{Version, codec, SHA256, bytes[4:], bytes}, nil
 }
 
 func CreateEmpty(codec int) (Cid, error) {
 	if codec != CodecRaw && codec != CodecCbor {
-		return Cid{}, errors.New("invalid codec")
+		return Cid{}, fmt.Errorf("%w: unsupported codec", ErrInvalid)
 	}
 
 	bytes := make([]byte, 4)
@@ -68,61 +78,68 @@ func CreateEmpty(codec int) (Cid, error) {
 }
 
 func decode(bytes []byte) (Cid, error) {
-	length := len(bytes)
-
-	if length < 4 {
-		return Cid{}, errors.New("cid too short")
-	}
-
-	version := bytes[0]
-	codec := bytes[1]
-	hashType := bytes[2]
-	digestSize := bytes[3]
+	// version, codec, hash type, digest size
+	var header [4]uint64
+	p := 0
+	for i := range header {
+		v, n, err := varint.Read(bytes[p:])
+		if err != nil {
+			return Cid{}, fmt.Errorf("%w: too short", ErrInvalid)
+		}
+		header[i] = v
+		p += n
+	}
+
+	version := header[0]
+	codec := header[1]
+	hashType := header[2]
+	digestSize := header[3]
 
 	if version != Version {
-		return Cid{}, errors.New("invalid version")
+		return Cid{}, fmt.Errorf("%w: unsupported version", ErrInvalid)
 	}
 
 	if codec != CodecRaw && codec != CodecCbor {
-		return Cid{}, errors.New("invalid codec")
+		return Cid{}, fmt.Errorf("%w: unsupported codec", ErrInvalid)
 	}
 
 	if hashType != SHA256 {
-		return Cid{}, errors.New("invalid hash type")
+		return Cid{}, fmt.Errorf("%w: unsupported hash type", ErrInvalid)
 	}
 
 	if digestSize != 32 && digestSize != 0 {
-		return Cid{}, errors.New("invalid digest size")
+		return Cid{}, fmt.Errorf("%w: unsupported digest size", ErrInvalid)
 	}
 
-	if length < 4+int(digestSize) {
-		return Cid{}, errors.New("cid too short")
+	end := p + int(digestSize)
+	if len(bytes) < end {
+		return Cid{}, fmt.Errorf("%w: too short", ErrInvalid)
 	}
 
-	digest := bytes[4 : 4+digestSize]
-	remainder := bytes[4+digestSize:]
+	digest := bytes[p:end]
+	remainder := bytes[end:]
 
 	if len(remainder) != 0 {
-		return Cid{}, errors.New("cid bytes includes remainder")
+		return Cid{}, fmt.Errorf("%w: trailing bytes", ErrInvalid)
 	}
 
-	return Cid{Version, int(codec), int(hashType), digest, bytes[0 : 4+digestSize]}, nil
+	return Cid{Version, int(codec), int(hashType), digest, bytes[0:end]}, nil
 }
 
 func Parse(s string) (Cid, error) {
 	if len(s) < 2 || s[0] != 'b' {
-		return Cid{}, errors.New("invalid cid format")
+		return Cid{}, fmt.Errorf("%w: not a base32 CID", ErrInvalid)
 	}
 
 	// 4 bytes in base32 = 8 characters
 	// 36 bytes in base32 = 59 characters
 	if len(s) != 59 && len(s) != 8 {
-		return Cid{}, errors.New("invalid cid length")
+		return Cid{}, fmt.Errorf("%w: unexpected length", ErrInvalid)
 	}
 
 	bytes, err := b32Encoding.DecodeString(s[1:])
 	if err != nil {
-		return Cid{}, err
+		return Cid{}, fmt.Errorf("%w: %w", ErrInvalid, err)
 	}
 
 	cid, err := decode(bytes)
@@ -141,11 +158,11 @@ func FromBytes(bytes []byte) (Cid, error) {
 	// 4 bytes + 1 byte for the 0x00 prefix
 	// 36 bytes + 1 byte for the 0x00 prefix
 	if len(bytes) != 37 && len(bytes) != 5 {
-		return Cid{}, errors.New("invalid cid length")
+		return Cid{}, fmt.Errorf("%w: unexpected length", ErrInvalid)
 	}
 
 	if bytes[0] != 0 {
-		return Cid{}, errors.New("incorrect binary cid")
+		return Cid{}, fmt.Errorf("%w: missing binary prefix", ErrInvalid)
 	}
 
 	return decode(bytes[1:])
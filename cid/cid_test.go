@@ -2,7 +2,10 @@ package cid
 
 import (
 	"bytes"
+	"errors"
 	"testing"
+
+	"github.com/notjuliet/grove/errdefs"
 )
 
 func TestCreate(t *testing.T) {
@@ -130,9 +133,56 @@ func TestParse(t *testing.T) {
 	})
 
 	t.Run("invalid", func(t *testing.T) {
-		_, err := Parse("QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n")
-		if err == nil {
-			t.Fatal("expected error")
+		for _, s := range []string{"QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n",
+			"bafyreihffx5a2e7k5uwrmmgofbvzujc5cmw5h4espouwuxt3liqoflx3e!", "bafkqaaa"} {
+			_, err := Parse(s)
+			if !errors.Is(err, ErrInvalid) || !errors.Is(err, errdefs.ErrMalformed) {
+				t.Fatalf("expected invalid cid error for %s, got %v", s, err)
+			}
 		}
 	})
 }
+
+func TestSet(t *testing.T) {
+	a, _ := Create(CodecRaw, []byte("a"))
+	b, _ := Create(CodecRaw, []byte("b"))
+	var s Set
+	if !s.Add(a) || s.Add(a) || !s.Has(a) || s.Has(b) {
+		t.Fatal("unexpected set membership")
+	}
+	s.Add(b)
+	s.Remove(a)
+	if s.Has(a) || s.Len() != 1 {
+		t.Fatal("remove failed")
+	}
+	for c := range s.All() {
+		if c.String() != b.String() {
+			t.Fatal("unexpected set element")
+		}
+	}
+	if NewSet(a, b, a).Len() != 2 {
+		t.Fatal("expected duplicates to be ignored")
+	}
+}
+
+func TestLinkJSON(t *testing.T) {
+	c, err := Parse("bafyreidfayvfuwqa7qlnopdjiqrxzs6blmoeu4rujcjtnci5beludirz2a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := c.Link().MarshalJSON()
+	if err != nil || string(b) != `{"$link":"bafyreidfayvfuwqa7qlnopdjiqrxzs6blmoeu4rujcjtnci5beludirz2a"}` {
+		t.Fatalf("unexpected JSON %s, %v", b, err)
+	}
+	var l CidLink
+	if err := l.UnmarshalJSON([]byte(" {\n\t\"$link\" : \"" + c.String() + "\" } ")); err != nil ||
+		!bytes.Equal(l.Bytes, c.Bytes) {
+		t.Fatalf("unexpected link %v, %v", l, err)
+	}
+	for _, s := range []string{`{}`, `{"$link": 1}`, `{"$link": "` + c.String() + `", "a": "b"}`, `"` + c.String() + `"`,
+		`{"$link": "bafy"}`, `{"$link": "bafy`} {
+		if err := l.UnmarshalJSON([]byte(s)); err == nil {
+			t.Errorf("expected an error for %s", s)
+		}
+	}
+}
@@ -1,8 +1,11 @@
 package cid
 
 import (
-	"encoding/json"
+	"errors"
 	"fmt"
+	"strings"
+
+	"github.com/notjuliet/grove/errdefs"
 )
 
 // NOTE: unsure how i want to represent this, might change it
@@ -11,31 +14,71 @@ type CidLink struct {
 	Bytes []byte
 }
 
-type jsonLink struct {
-	Link string `json:"$link"`
-}
-
 func (ll CidLink) String() string {
 	return "b" + b32Encoding.EncodeToString(ll.Bytes)
 }
 
+// Encodes the link as a {"$link": cid} object. The JSON is written by hand, like in UnmarshalJSON, to keep
+// encoding/json and its reflection out of the package.
 func (ll CidLink) MarshalJSON() ([]byte, error) {
-	jl := jsonLink{
-		Link: ll.String(),
-	}
-	return json.Marshal(jl)
+	// base32 CIDs need no escaping
+	return []byte(`{"$link":"` + ll.String() + `"}`), nil
 }
 
 func (ll *CidLink) UnmarshalJSON(raw []byte) error {
-	var jl jsonLink
-	if err := json.Unmarshal(raw, &jl); err != nil {
-		return fmt.Errorf("parsing cid-link JSON: %v", err)
+	s, err := parseJSONLink(string(raw))
+	if err != nil {
+		return fmt.Errorf("parsing cid-link JSON: %w", errdefs.Wrap(errdefs.ErrMalformed, err))
 	}
-
-	c, err := Parse(jl.Link)
+	c, err := Parse(s)
 	if err != nil {
-		return fmt.Errorf("parsing cid-link CID: %v", err)
+		return fmt.Errorf("parsing cid-link CID: %w", err)
 	}
 	*ll = CidLink{Bytes: []byte(c.Bytes)}
 	return nil
 }
+
+// Returns the CID string of a {"$link": cid} object. String escapes are not supported, as neither the key nor
+// the characters of CIDs need them.
+func parseJSONLink(s string) (string, error) {
+	var tokens []string
+	for s = skipSpace(s); s != ""; s = skipSpace(s) {
+		switch s[0] {
+		case '{', '}', ':':
+			tokens = append(tokens, s[:1])
+			s = s[1:]
+		case '"':
+			end := strings.IndexByte(s[1:], '"')
+			if end < 0 {
+				return "", errors.New("unterminated string")
+			}
+			str := s[1 : end+1]
+			if strings.IndexByte(str, '\\') >= 0 {
+				return "", errors.New("unsupported string escape")
+			}
+			tokens = append(tokens, `"`+str)
+			s = s[end+2:]
+		default:
+			return "", fmt.Errorf("unexpected character %q", s[0])
+		}
+	}
+	if len(tokens) != 5 || tokens[0] != "{" || tokens[1] != `"$link` || tokens[2] != ":" ||
+		!strings.HasPrefix(tokens[3], `"`) || tokens[4] != "}" {
+		return "", errors.New(`expected a {"$link": string} object`)
+	}
+	return tokens[3][1:], nil
+}
+
+func skipSpace(s string) string {
+	return strings.TrimLeft(s, " \t\r\n")
+}
+
+// Parses the link into a CID.
+func (ll CidLink) Cid() (Cid, error) {
+	return decode(ll.Bytes)
+}
+
+// Returns a link pointing to this CID.
+func (c Cid) Link() CidLink {
+	return CidLink{Bytes: c.Bytes}
+}
@@ -0,0 +1,53 @@
+package cid
+
+import "iter"
+
+// Set of CIDs. The zero value is an empty set ready to use.
+type Set struct {
+	m map[string]Cid
+}
+
+// Creates a set holding the given CIDs.
+func NewSet(cids ...Cid) *Set {
+	s := &Set{m: make(map[string]Cid, len(cids))}
+	for _, c := range cids {
+		s.Add(c)
+	}
+	return s
+}
+
+// Adds a CID, reporting whether it was not already present.
+func (s *Set) Add(c Cid) bool {
+	if s.m == nil {
+		s.m = map[string]Cid{}
+	}
+	if _, ok := s.m[string(c.Bytes)]; ok {
+		return false
+	}
+	s.m[string(c.Bytes)] = c
+	return true
+}
+
+func (s *Set) Has(c Cid) bool {
+	_, ok := s.m[string(c.Bytes)]
+	return ok
+}
+
+func (s *Set) Remove(c Cid) {
+	delete(s.m, string(c.Bytes))
+}
+
+func (s *Set) Len() int {
+	return len(s.m)
+}
+
+// Iterates over the CIDs of the set, in no particular order.
+func (s *Set) All() iter.Seq[Cid] {
+	return func(yield func(Cid) bool) {
+		for _, c := range s.m {
+			if !yield(c) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/notjuliet/grove/data"
+	"github.com/notjuliet/grove/events"
+)
+
+const firehoseTailUsage = "tail [-host url] [-cursor seq] [-collection nsid,...] [-did did,...] [-records]"
+
+// flag holding a list of comma-separated values, which may be repeated
+type listFlag []string
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *listFlag) Set(s string) error {
+	for v := range strings.SplitSeq(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			*l = append(*l, v)
+		}
+	}
+	return nil
+}
+
+func runFirehose(ctx context.Context, args []string) error {
+	if len(args) == 0 || args[0] != "tail" {
+		return fmt.Errorf("unknown subcommand, usage: grove firehose %s", firehoseTailUsage)
+	}
+	fs := flags("firehose tail", firehoseTailUsage[len("tail "):])
+	host := fs.String("host", "wss://bsky.network", "URL of the relay or PDS")
+	cursor := fs.Int64("cursor", -1, "sequence number to resume after, live events if negative")
+	records := fs.Bool("records", false, "print the records of commit operations as JSON")
+	var collections, dids listFlag
+	fs.Var(&collections, "collection", "only print the commit operations on these collections")
+	fs.Var(&dids, "did", "only print the events of these accounts")
+	fs.Parse(args[1:])
+
+	c := &events.Client{Host: *host, OnDisconnect: func(err error) {
+		fmt.Fprintln(os.Stderr, "disconnected:", err)
+	}}
+	var start *int64
+	if *cursor >= 0 {
+		start = cursor
+	}
+	err := c.Run(ctx, start, func(f events.Frame) error {
+		e, err := f.Event()
+		if errors.Is(err, events.ErrUnknownType) {
+			return nil
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return nil
+		}
+		if len(dids) > 0 && !slices.Contains(dids, eventDID(e)) {
+			return nil
+		}
+		switch e := e.(type) {
+		case *events.Commit:
+			return printCommit(ctx, e, collections, *records)
+		case *events.Sync:
+			fmt.Printf("%d %s sync rev=%s\n", e.Seq, e.DID, e.Rev)
+		case *events.Identity:
+			handle := ""
+			if e.Handle != nil {
+				handle = *e.Handle
+			}
+			fmt.Printf("%d %s identity handle=%s\n", e.Seq, e.DID, handle)
+		case *events.Account:
+			fmt.Printf("%d %s account active=%t status=%s\n", e.Seq, e.DID, e.Active, e.Status)
+		case *events.Info:
+			fmt.Printf("info %s %s\n", e.Name, e.Message)
+		}
+		return nil
+	})
+	if ctx.Err() != nil {
+		return nil
+	}
+	return err
+}
+
+// Returns the DID of the account an event is about, empty for informational messages.
+func eventDID(e events.Event) string {
+	switch e := e.(type) {
+	case *events.Commit:
+		return e.Repo
+	case *events.Sync:
+		return e.DID
+	case *events.Identity:
+		return e.DID
+	case *events.Account:
+		return e.DID
+	}
+	return ""
+}
+
+func printCommit(ctx context.Context, e *events.Commit, collections []string, records bool) error {
+	for op, err := range e.RecordOps(ctx) {
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%d %s: %v\n", e.Seq, e.Repo, err)
+			return nil
+		}
+		if len(collections) > 0 && !slices.Contains(collections, op.Collection) {
+			continue
+		}
+		fmt.Printf("%d %s %s %s/%s\n", e.Seq, e.Repo, op.Action, op.Collection, op.RKey)
+		if records && op.Record != nil {
+			b, err := json.Marshal(data.ToJSON(op.Record))
+			if err != nil {
+				return err
+			}
+			fmt.Printf("%s\n", b)
+		}
+	}
+	return nil
+}
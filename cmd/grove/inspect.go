@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/notjuliet/grove/blockstore"
+	"github.com/notjuliet/grove/car"
+	"github.com/notjuliet/grove/cbor"
+	"github.com/notjuliet/grove/cid"
+	"github.com/notjuliet/grove/mst"
+	"github.com/notjuliet/grove/repo"
+	"github.com/notjuliet/grove/tid"
+)
+
+func runCBOR(ctx context.Context, args []string) error {
+	fs := flags("cbor", "[-hex] [file]")
+	isHex := fs.Bool("hex", false, "read the block as hexadecimal text")
+	fs.Parse(args)
+	f, err := open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	b, err := io.ReadAll(f)
+	if err != nil {
+		return err
+	}
+	if *isHex {
+		if b, err = hex.DecodeString(strings.Join(strings.Fields(string(b)), "")); err != nil {
+			return err
+		}
+	}
+	v, err := cbor.Decode(b)
+	if err != nil {
+		return err
+	}
+	c, _ := cid.Create(cid.CodecCbor, b)
+	fmt.Fprintf(os.Stderr, "%d bytes, CID %s\n", len(b), c)
+	return printData(v)
+}
+
+// names of the multicodecs of atproto CIDs
+var codecs = map[int]string{cid.CodecRaw: "raw", cid.CodecCbor: "dag-cbor", cid.SHA256: "sha2-256"}
+
+func runCID(ctx context.Context, args []string) error {
+	fs := flags("cid", "<cid>...")
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	for i, s := range fs.Args() {
+		c, err := cid.Parse(s)
+		if err != nil {
+			return fmt.Errorf("%s: %w", s, err)
+		}
+		if i > 0 {
+			fmt.Println()
+		}
+		fmt.Printf("cid      %s\n", c)
+		fmt.Printf("version  %d\n", c.Version)
+		fmt.Printf("codec    %s (0x%x)\n", codecs[c.Codec], c.Codec)
+		fmt.Printf("hash     %s (0x%x)\n", codecs[c.HashType], c.HashType)
+		fmt.Printf("digest   %x\n", c.Digest)
+		fmt.Printf("bytes    %x\n", c.Bytes)
+	}
+	return nil
+}
+
+func runTID(ctx context.Context, args []string) error {
+	fs := flags("tid", "<tid>...")
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	for _, s := range fs.Args() {
+		micros, clock, err := tid.Parse(s)
+		if err != nil {
+			return fmt.Errorf("%s: %w", s, err)
+		}
+		t := time.UnixMicro(int64(micros)).UTC()
+		fmt.Printf("%s  %s  clock %d\n", s, t.Format(time.RFC3339Nano), clock)
+	}
+	return nil
+}
+
+func runCAR(ctx context.Context, args []string) error {
+	fs := flags("car", "[-blocks] [-records] <file>")
+	blocks := fs.Bool("blocks", false, "list every block with its size")
+	records := fs.Bool("records", false, "load the file as a repository and list its records")
+	fs.Parse(args)
+	f, err := open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if *records {
+		return listRecords(ctx, f)
+	}
+
+	r, err := car.NewReader(f)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("version  %d\n", r.Version)
+	for _, root := range r.Roots {
+		fmt.Printf("root     %s\n", root)
+	}
+	var count, size int
+	perCodec := map[int]int{}
+	for {
+		blk, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		count++
+		size += len(blk.Data)
+		perCodec[blk.Cid.Codec]++
+		if *blocks {
+			fmt.Printf("block    %s  %s  %d bytes\n", blk.Cid, codecs[blk.Cid.Codec], len(blk.Data))
+		}
+	}
+	fmt.Printf("blocks   %d (%d dag-cbor, %d raw), %d bytes\n", count, perCodec[cid.CodecCbor], perCodec[cid.CodecRaw],
+		size)
+	return nil
+}
+
+// lists the records of a repository export, as collection/rkey and CID
+func listRecords(ctx context.Context, r io.Reader) error {
+	bs := blockstore.NewMemoryBlockstore()
+	rp, err := repo.LoadFromCAR(ctx, r, bs)
+	if err != nil {
+		return err
+	}
+	head, commit, ok := rp.Head()
+	if !ok {
+		return errors.New("repository has no commit")
+	}
+	fmt.Printf("did      %s\n", commit.DID)
+	fmt.Printf("commit   %s\n", head)
+	fmt.Printf("rev      %s\n", commit.Rev)
+	seq, walkErr := mst.Walk(ctx, bs, commit.Data)
+	for key, c := range seq {
+		fmt.Printf("record   %s  %s\n", key, c)
+	}
+	return walkErr()
+}
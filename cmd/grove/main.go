@@ -0,0 +1,96 @@
+// Command grove inspects atproto data for debugging:
+//
+//	grove cbor [-hex] [file]     decodes a DAG-CBOR block and prints it as lexicon JSON
+//	grove cid <cid>...           explains the parts of CIDs
+//	grove tid <tid>...           parses TIDs into their timestamp and clock ID
+//	grove car [-blocks] [-records] <file>
+//	                             lists the roots, blocks and records of a CAR file
+//	grove repo get [-out file] [-export dir] <did>
+//	                             fetches and verifies the repository of an account, optionally saving it
+//	grove firehose tail [-host url] [-collection nsid,...] [-did did,...] [-records]
+//	                             prints the events of a repository event stream
+//
+// Files named "-" or omitted are read from standard input.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+
+	"github.com/notjuliet/grove/data"
+)
+
+type command struct {
+	usage string
+	run   func(ctx context.Context, args []string) error
+}
+
+var commands = map[string]command{
+	"cbor":     {"[-hex] [file]", runCBOR},
+	"cid":      {"<cid>...", runCID},
+	"tid":      {"<tid>...", runTID},
+	"car":      {"[-blocks] [-records] <file>", runCAR},
+	"repo":     {repoGetUsage, runRepo},
+	"firehose": {firehoseTailUsage, runFirehose},
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		usage()
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	err := cmd.run(ctx, os.Args[2:])
+	stop()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "grove %s: %v\n", os.Args[1], err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	var b strings.Builder
+	b.WriteString("usage:\n")
+	for _, name := range []string{"cbor", "cid", "tid", "car", "repo", "firehose"} {
+		fmt.Fprintf(&b, "\tgrove %s %s\n", name, commands[name].usage)
+	}
+	fmt.Fprint(os.Stderr, b.String())
+	os.Exit(2)
+}
+
+// Returns the flag set of a command, printing its usage on errors.
+func flags(name, usage string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: grove %s %s\n", name, usage)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// Opens a named file, or standard input for "-" or no name.
+func open(name string) (io.ReadCloser, error) {
+	if name == "" || name == "-" {
+		return io.NopCloser(os.Stdin), nil
+	}
+	return os.Open(name)
+}
+
+// Prints a value of the data model as indented lexicon JSON.
+func printData(v any) error {
+	b, err := json.MarshalIndent(data.ToJSON(v), "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Printf("%s\n", b)
+	return err
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/notjuliet/grove/blockstore"
+	"github.com/notjuliet/grove/data"
+	"github.com/notjuliet/grove/identity"
+	"github.com/notjuliet/grove/mst"
+	"github.com/notjuliet/grove/repo"
+	"github.com/notjuliet/grove/syntax"
+	"github.com/notjuliet/grove/xrpc"
+)
+
+const repoGetUsage = "get [-plc url] [-out file] [-export dir] [-collection nsid] <did>"
+
+func runRepo(ctx context.Context, args []string) error {
+	if len(args) == 0 || args[0] != "get" {
+		return fmt.Errorf("unknown subcommand, usage: grove repo %s", repoGetUsage)
+	}
+	fs := flags("repo get", repoGetUsage[len("get "):])
+	plc := fs.String("plc", identity.DefaultPLCURL, "URL of the PLC directory")
+	out := fs.String("out", "", "file the CAR export is written to")
+	export := fs.String("export", "", "directory the records are written to as JSON, one file per record")
+	collection := fs.String("collection", "", "only export the records of this collection")
+	fs.Parse(args[1:])
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	did := fs.Arg(0)
+	if err := syntax.ValidateDID(did); err != nil {
+		return err
+	}
+
+	dir := &identity.BaseDirectory{PLCURL: *plc}
+	ident, err := dir.LookupDID(ctx, did)
+	if err != nil {
+		return err
+	}
+	pds := ident.PDSEndpoint()
+	if pds == "" {
+		return errors.New("identity has no PDS")
+	}
+	pub, err := ident.PublicKey()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("did      %s\n", did)
+	fmt.Printf("handle   %s\n", ident.Handle)
+	fmt.Printf("pds      %s\n", pds)
+
+	body, err := (&xrpc.Client{Host: pds}).GetRepo(ctx, did, "")
+	if err != nil {
+		return err
+	}
+	b, err := io.ReadAll(body)
+	body.Close()
+	if err != nil {
+		return err
+	}
+	report, err := repo.VerifyFullCAR(ctx, bytes.NewReader(b), did, pub, repo.VerifyOptions{})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("commit   %s\n", report.Commit)
+	fmt.Printf("rev      %s\n", report.Rev)
+	fmt.Printf("size     %d bytes, %d nodes, %d records\n", len(b), report.Nodes, report.Records)
+	for _, p := range report.Problems {
+		fmt.Printf("problem  %v\n", p)
+	}
+
+	if *out != "" {
+		if err := os.WriteFile(*out, b, 0o644); err != nil {
+			return err
+		}
+	}
+	if !report.OK() {
+		return fmt.Errorf("repository has %d problems", len(report.Problems))
+	}
+	if *export != "" {
+		n, err := exportRecords(ctx, b, *export, *collection)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("exported %d records to %s\n", n, *export)
+	}
+	return nil
+}
+
+// Writes the records of a repository export to dir/<collection>/<rkey>.json, returning their number. Files are
+// created through an os.Root, so that keys such as "../x" cannot escape dir.
+func exportRecords(ctx context.Context, b []byte, dir, collection string) (int, error) {
+	bs := blockstore.NewMemoryBlockstore()
+	r, err := repo.LoadFromCAR(ctx, bytes.NewReader(b), bs)
+	if err != nil {
+		return 0, err
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return 0, err
+	}
+	root, err := os.OpenRoot(dir)
+	if err != nil {
+		return 0, err
+	}
+	defer root.Close()
+	_, commit, _ := r.Head()
+	var n int
+	seq, walkErr := mst.Walk(ctx, bs, commit.Data)
+	for key, c := range seq {
+		coll, rkey, _ := strings.Cut(key, "/")
+		if collection != "" && coll != collection {
+			continue
+		}
+		block, err := bs.Get(ctx, c)
+		if err != nil {
+			return n, err
+		}
+		rec, err := repo.DecodeRecord(c, block)
+		if err != nil {
+			return n, fmt.Errorf("%s: %w", key, err)
+		}
+		j, err := json.MarshalIndent(data.ToJSON(rec), "", "  ")
+		if err != nil {
+			return n, err
+		}
+		if coll == "." || coll == ".." {
+			return n, fmt.Errorf("%s: collection is not a valid directory name", key)
+		}
+		if err := root.Mkdir(coll, 0o755); err != nil && !errors.Is(err, os.ErrExist) {
+			return n, err
+		}
+		// record keys may hold characters which are not portable in file names
+		path := filepath.Join(coll, strings.ReplaceAll(rkey, ":", "_")+".json")
+		if err := writeRootFile(root, path, append(j, '\n')); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, walkErr()
+}
+
+func writeRootFile(root *os.Root, name string, b []byte) error {
+	f, err := root.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(b)
+	return errors.Join(err, f.Close())
+}
@@ -0,0 +1,52 @@
+// Command lexgen generates Go types and XRPC client functions from a directory of lexicon schemas, to be run
+// with go:generate:
+//
+//	//go:generate go run github.com/notjuliet/grove/cmd/lexgen -dir ../lexicons -package example
+//
+// It writes a file per schema to the output directory, the current one by default.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/notjuliet/grove/lexicon"
+	"github.com/notjuliet/grove/lexicon/lexgen"
+)
+
+func main() {
+	dir := flag.String("dir", "lexicons", "directory of the lexicon schemas, searched recursively")
+	out := flag.String("out", ".", "directory of the generated files")
+	pkg := flag.String("package", os.Getenv("GOPACKAGE"), "name of the generated package")
+	trim := flag.String("trim-prefix", "", "prefix trimmed from NSIDs before naming types, such as com.example.")
+	flag.Parse()
+
+	if err := run(*dir, *out, lexgen.Config{Package: *pkg, TrimPrefix: *trim}); err != nil {
+		fmt.Fprintln(os.Stderr, "lexgen:", err)
+		os.Exit(1)
+	}
+}
+
+func run(dir, out string, cfg lexgen.Config) error {
+	c := lexicon.NewCatalog()
+	if err := c.LoadFS(os.DirFS(dir), "."); err != nil {
+		return err
+	}
+	files, err := lexgen.Generate(c, cfg)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(out, 0o755); err != nil {
+		return err
+	}
+	for _, name := range slices.Sorted(maps.Keys(files)) {
+		if err := os.WriteFile(filepath.Join(out, name), files[name], 0o644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
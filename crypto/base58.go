@@ -0,0 +1,52 @@
+package crypto
+
+import (
+	"errors"
+	"math/big"
+	"strings"
+)
+
+const b58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+var bigRadix58 = big.NewInt(58)
+
+func b58Encode(b []byte) string {
+	x := new(big.Int).SetBytes(b)
+	mod := new(big.Int)
+	out := make([]byte, 0, len(b)*138/100+1)
+	for x.Sign() > 0 {
+		x.DivMod(x, bigRadix58, mod)
+		out = append(out, b58Alphabet[mod.Int64()])
+	}
+	// leading zero bytes are encoded as leading '1' characters
+	for _, c := range b {
+		if c != 0 {
+			break
+		}
+		out = append(out, b58Alphabet[0])
+	}
+	for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+		out[i], out[j] = out[j], out[i]
+	}
+	return string(out)
+}
+
+func b58Decode(s string) ([]byte, error) {
+	x := new(big.Int)
+	for i := range len(s) {
+		c := strings.IndexByte(b58Alphabet, s[i])
+		if c < 0 {
+			return nil, errors.New("invalid base58 character")
+		}
+		x.Mul(x, bigRadix58)
+		x.Add(x, big.NewInt(int64(c)))
+	}
+	zeros := 0
+	for zeros < len(s) && s[zeros] == b58Alphabet[0] {
+		zeros++
+	}
+	body := x.Bytes()
+	out := make([]byte, zeros+len(body))
+	copy(out[zeros:], body)
+	return out, nil
+}
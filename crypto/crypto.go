@@ -0,0 +1,115 @@
+package crypto
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/notjuliet/grove/varint"
+)
+
+const (
+	// JWT algorithm name for P-256 (NIST secp256r1) signatures.
+	AlgES256 = "ES256"
+	// JWT algorithm name for K-256 (secp256k1) signatures.
+	AlgES256K = "ES256K"
+)
+
+// multicodecs for compressed public keys
+const (
+	p256Codec = 0x1200
+	k256Codec = 0xe7
+)
+
+const didKeyPrefix = "did:key:"
+
+// Public half of a signing key pair.
+type PublicKey interface {
+	// Returns the JWT "alg" name for this key type.
+	Algorithm() string
+	// Returns the 33-byte compressed point encoding of the key.
+	Bytes() []byte
+	// Returns the multibase (base58btc) multikey encoding, as used in DID documents.
+	Multibase() string
+	// Returns the did:key representation of the key.
+	DIDKey() string
+	// Verifies a 64-byte compact signature over a SHA-256 digest.
+	VerifyDigest(digest, sig []byte) error
+}
+
+// Private half of an in-memory signing key pair.
+type PrivateKey interface {
+	Signer
+	// Returns the public key for this private key.
+	PublicKey() PublicKey
+	// Returns the raw 32-byte private scalar.
+	Bytes() []byte
+}
+
+// Signer produces atproto signatures without exposing private key material, so implementations can be
+// backed by a cloud KMS or HSM as well as by in-memory keys.
+type Signer interface {
+	// Returns the did:key representation of the signing public key.
+	DIDKey() string
+	// Returns the JWT "alg" name for signatures produced by this signer.
+	Algorithm() string
+	// Signs a SHA-256 digest, returning a 64-byte compact (r || s) low-S signature.
+	Sign(ctx context.Context, digest []byte) ([]byte, error)
+}
+
+// Hashes content with SHA-256 and signs the digest.
+func Sign(ctx context.Context, s Signer, content []byte) ([]byte, error) {
+	digest := sha256.Sum256(content)
+	return s.Sign(ctx, digest[:])
+}
+
+// Hashes content with SHA-256 and verifies the signature against the digest.
+func Verify(pub PublicKey, content, sig []byte) error {
+	digest := sha256.Sum256(content)
+	return pub.VerifyDigest(digest[:], sig)
+}
+
+// Returns the public key described by a signer's did:key.
+func SignerPublicKey(s Signer) (PublicKey, error) {
+	return ParsePublicDIDKey(s.DIDKey())
+}
+
+// Parses a did:key string into a public key.
+func ParsePublicDIDKey(s string) (PublicKey, error) {
+	if !strings.HasPrefix(s, didKeyPrefix) {
+		return nil, errors.New("invalid did:key prefix")
+	}
+	return ParsePublicMultibase(s[len(didKeyPrefix):])
+}
+
+// Parses a multibase multikey string (as found in DID document verification methods) into a public key.
+func ParsePublicMultibase(s string) (PublicKey, error) {
+	if len(s) < 2 || s[0] != 'z' {
+		return nil, errors.New("invalid multibase encoding, expected base58btc")
+	}
+	raw, err := b58Decode(s[1:])
+	if err != nil {
+		return nil, err
+	}
+	codec, n, err := varint.Read(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid multikey codec: %w", err)
+	}
+	switch codec {
+	case p256Codec:
+		return ParseP256PublicKey(raw[n:])
+	case k256Codec:
+		return ParseK256PublicKey(raw[n:])
+	default:
+		return nil, errors.New("unsupported multikey type")
+	}
+}
+
+func encodeMultibase(codec uint64, key []byte) string {
+	buf := make([]byte, 0, varint.Len(codec)+len(key))
+	buf = varint.Append(buf, codec)
+	buf = append(buf, key...)
+	return "z" + b58Encode(buf)
+}
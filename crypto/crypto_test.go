@@ -0,0 +1,242 @@
+package crypto
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"math/big"
+	"testing"
+)
+
+var k256Vectors = []struct {
+	priv   string
+	didKey string
+}{
+	{"9085d2bef69286a6cbb51623c8fa258629945cd55ca705cc4e66700396894e0c", "did:key:zQ3shokFTS3brHcDQrn82RUDfCZESWL1ZdCEJwekUDPQiYBme"},
+	{"f0f4df55a2b3ff13051ea814a8f24ad00f2e469af73c363ac7e9fb999a9072ed", "did:key:zQ3shtxV1FrJfhqE1dvxYRcCknWNjHc3c5X1y3ZSoPDi2aur2"},
+	{"6b0b91287ae3348f8c2f2552d766f30e3604867e34adc37ccbb74a8e6b893e02", "did:key:zQ3shZc2QzApp2oymGvQbzP8eKheVshBHbU4ZYjeXqwSKEn6N"},
+}
+
+var signatureVectors = []struct {
+	name   string
+	didKey string
+	sig    string
+	valid  bool
+}{
+	{"p256 low-s", "did:key:zDnaembgSGUhZULN2Caob4HLJPaxBh92N7rtH21TErzqf8HQo", "2vZNsG3UKvvO/CDlrdvyZRISOFylinBh0Jupc6KcWoJWExHptCfduPleDbG3rko3YZnn9Lw0IjpixVmexJDegg", true},
+	{"k256 low-s", "did:key:zQ3shqwJEJyMBsBXCWyCBpUBMqxcon9oHB7mCvx4sSpMdLJwc", "5WpdIuEUUfVUYaozsi8G0B3cWO09cgZbIIwg1t2YKdUn/FEznOndsz/qgiYb89zwxYCbB71f7yQK5Lr7NasfoA", true},
+	{"p256 high-s", "did:key:zDnaembgSGUhZULN2Caob4HLJPaxBh92N7rtH21TErzqf8HQo", "2vZNsG3UKvvO/CDlrdvyZRISOFylinBh0Jupc6KcWoKp7O4VS9giSAah8k5IUbXIW00SuOrjfEqQ9HEkN9JGzw", false},
+	{"k256 high-s", "did:key:zQ3shqwJEJyMBsBXCWyCBpUBMqxcon9oHB7mCvx4sSpMdLJwc", "5WpdIuEUUfVUYaozsi8G0B3cWO09cgZbIIwg1t2YKdXYA67MYxYiTMAVfdnkDCMN9S5B3vHosRe07aORmoshoQ", false},
+	{"p256 der", "did:key:zDnaeT6hL2RnTdUhAPLij1QBkhYZnmuKyM7puQLW1tkF4Zkt8", "MEQCIFxYelWJ9lNcAVt+jK0y/T+DC/X4ohFZ+m8f9SEItkY1AiACX7eXz5sgtaRrz/SdPR8kprnbHMQVde0T2R8yOTBweA", false},
+	{"k256 der", "did:key:zQ3shnriYMXc8wvkbJqfNWh5GXn2bVAeqTC92YuNbek4npqGF", "MEUCIQCWumUqJqOCqInXF7AzhIRg2MhwRz2rWZcOEsOjPmNItgIgXJH7RnqfYY6M0eg33wU0sFYDlprwdOcpRn78Sz5ePgk", false},
+}
+
+var signatureMessage, _ = base64.RawStdEncoding.DecodeString("oWVoZWxsb2V3b3JsZA")
+
+func TestDIDKey(t *testing.T) {
+	t.Run("k256", func(t *testing.T) {
+		for _, v := range k256Vectors {
+			raw, _ := hex.DecodeString(v.priv)
+			k, err := ParseK256PrivateKey(raw)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if k.DIDKey() != v.didKey {
+				t.Fatalf("invalid did:key %s", k.DIDKey())
+			}
+			pub, err := ParsePublicDIDKey(v.didKey)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if pub.DIDKey() != v.didKey {
+				t.Fatal("did:key does not round-trip")
+			}
+		}
+	})
+
+	t.Run("p256", func(t *testing.T) {
+		raw, err := b58Decode("9p4VRzdmhsnq869vQjVCTrRry7u4TtfRxhvBFJTGU2Cp")
+		if err != nil {
+			t.Fatal(err)
+		}
+		k, err := ParseP256PrivateKey(raw)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if k.DIDKey() != "did:key:zDnaeTiq1PdzvZXUaMdezchcMJQpBdH2VN4pgrrEhMCCbmwSb" {
+			t.Fatalf("invalid did:key %s", k.DIDKey())
+		}
+	})
+}
+
+func TestVerify(t *testing.T) {
+	for _, v := range signatureVectors {
+		t.Run(v.name, func(t *testing.T) {
+			pub, err := ParsePublicDIDKey(v.didKey)
+			if err != nil {
+				t.Fatal(err)
+			}
+			sig, _ := base64.RawStdEncoding.DecodeString(v.sig)
+			err = Verify(pub, signatureMessage, sig)
+			if v.valid && err != nil {
+				t.Fatal(err)
+			}
+			if !v.valid && err == nil {
+				t.Fatal("expected error")
+			}
+		})
+	}
+}
+
+func TestSigner(t *testing.T) {
+	k256, err := GenerateK256()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p256, err := GenerateP256()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, s := range []Signer{k256, p256} {
+		t.Run(s.Algorithm(), func(t *testing.T) {
+			sig, err := Sign(context.Background(), s, []byte("hello world"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			pub, err := SignerPublicKey(s)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := Verify(pub, []byte("hello world"), sig); err != nil {
+				t.Fatal(err)
+			}
+			if err := Verify(pub, []byte("hello world!"), sig); err == nil {
+				t.Fatal("expected error")
+			}
+		})
+	}
+}
+
+func TestK256Sign(t *testing.T) {
+	// signatures of "Satoshi Nakamoto" by the key 1, a common RFC 6979 vector, and of the messages "msg0" to
+	// "msg2" as produced by the earlier big.Int implementation
+	vectors := []struct {
+		priv string
+		msg  string
+		sig  string
+	}{
+		{"0000000000000000000000000000000000000000000000000000000000000001", "Satoshi Nakamoto",
+			"934b1ea10a4b3c1757e2b0c017d0b6143ce3c9a7e6a4a49860d7a6ab210ee3d8" +
+				"2442ce9d2b916064108014783e923ec36b49743e2ffa1c4496f01a512aafd9e5"},
+		{"9085d2bef69286a6cbb51623c8fa258629945cd55ca705cc4e66700396894e0c", "msg0",
+			"580c4df1cf6d5c9040e9822d54f5cc3fb84bcc6cab29136abaa9f96b2db5697a" +
+				"10e5e0555a3b2b171d0ec2a0369ecf547d9d9a2b8cfebcdf71169c53fae8ec3a"},
+		{"9085d2bef69286a6cbb51623c8fa258629945cd55ca705cc4e66700396894e0c", "msg1",
+			"cfab1550b781803077e5b20a7b47507f22de2ae487308a886df0286d702af793" +
+				"1a4c9611ea53f943eaf608dfabce58a6fb4b20681d1cd114277bc9cecec6b487"},
+		{"9085d2bef69286a6cbb51623c8fa258629945cd55ca705cc4e66700396894e0c", "msg2",
+			"2ff22c65b20fc987bb0726152de4e71778d5ce64fb1bc6bfeddb25c515819696" +
+				"13be53c0a18d59f0833126ec28fc58580af3ea885bca8a28f95e158798012642"},
+		{"f0f4df55a2b3ff13051ea814a8f24ad00f2e469af73c363ac7e9fb999a9072ed", "msg0",
+			"82385f4f386e3b93115b948b1c95a8d1eab4aa85114ec930b378849ee5650959" +
+				"549fe3de380211517262704fc71dd973d99236a4b6b5af36f9af35cd79b5489a"},
+		{"f0f4df55a2b3ff13051ea814a8f24ad00f2e469af73c363ac7e9fb999a9072ed", "msg1",
+			"465a550308dd351a492d222dccd70b80a262c39352583789848ff48607deff42" +
+				"558fa25aa457df41a4e29e8301124c2d95f63d1cef1a38e99f3e6b7acde231c6"},
+		{"f0f4df55a2b3ff13051ea814a8f24ad00f2e469af73c363ac7e9fb999a9072ed", "msg2",
+			"f33224c172f51c327506db62b0801244bc2316b5bf92816f52cac2e77538126f" +
+				"258d632b4b382deb07bd4c954e45b6901319ad575ba947e119c88571b6ab8843"},
+		{"6b0b91287ae3348f8c2f2552d766f30e3604867e34adc37ccbb74a8e6b893e02", "msg0",
+			"961a6e272fd79fb3b0cf2c987398dc3956784ba018b0cd6d1bc627b758131f18" +
+				"24feed14da11cb446ac75283b9d2292809571b41736b4f01112c17dc8d6edca3"},
+		{"6b0b91287ae3348f8c2f2552d766f30e3604867e34adc37ccbb74a8e6b893e02", "msg1",
+			"5227ba9d67d06005391576b2924e06269ae45a50a244634da12ea46ae1815cf5" +
+				"3c20ae5985f663c28460853cdfbdb8e5e170cc547ee9a47489f78c7f3bb92a07"},
+		{"6b0b91287ae3348f8c2f2552d766f30e3604867e34adc37ccbb74a8e6b893e02", "msg2",
+			"bb4253c3a9805286f1f92314b904aa85479aa42a3e230d6e8a16218a8f311a34" +
+				"1993a010ae7b680c5e8a7584c6d8225ed01409a4f966a71d7e3a01f8c67be007"},
+	}
+	for _, v := range vectors {
+		raw, _ := hex.DecodeString(v.priv)
+		k, err := ParseK256PrivateKey(raw)
+		if err != nil {
+			t.Fatal(err)
+		}
+		digest := sha256.Sum256([]byte(v.msg))
+		sig, err := k.Sign(context.Background(), digest[:])
+		if err != nil || hex.EncodeToString(sig) != v.sig {
+			t.Fatalf("%s: unexpected signature %x, %v", v.msg, sig, err)
+		}
+	}
+
+	// constant-time multiples of G match the variable-time implementation
+	nMinus1 := new(big.Int).Sub(k256N, big.NewInt(1))
+	scalars := []*big.Int{big.NewInt(1), big.NewInt(2), big.NewInt(15), big.NewInt(16), nMinus1}
+	for range 20 {
+		k, _ := rand.Int(rand.Reader, nMinus1)
+		scalars = append(scalars, k.Add(k, big.NewInt(1)))
+	}
+	for _, k := range scalars {
+		ct, vt := k256BaseMultCT(limbsFromBig(k)), k256BaseMult(k)
+		if ct.x.Cmp(vt.x) != 0 || ct.y.Cmp(vt.y) != 0 {
+			t.Fatalf("k256BaseMultCT(%x) does not match", k)
+		}
+	}
+	for _, k := range []*big.Int{big.NewInt(0), k256N, new(big.Int).Lsh(big.NewInt(1), 255)} {
+		if k256ValidScalar(limbsFromBig(k)) != (k.Sign() > 0 && k.Cmp(k256N) < 0) {
+			t.Fatalf("unexpected validity of scalar %x", k)
+		}
+	}
+
+	// Montgomery arithmetic matches big.Int arithmetic
+	for _, mm := range []*montModulus{k256Field, k256Order} {
+		m := new(big.Int).SetBytes(mm.m.bytes())
+		for range 100 {
+			a, _ := rand.Int(rand.Reader, m)
+			b, _ := rand.Int(rand.Reader, m)
+			am, bm := mm.toMont(limbsFromBig(a)), mm.toMont(limbsFromBig(b))
+			value := func(x limbs) *big.Int { return new(big.Int).SetBytes(mm.fromMont(x).bytes()) }
+			mod := func(x *big.Int) *big.Int { return x.Mod(x, m) }
+			if value(mm.mul(am, bm)).Cmp(mod(new(big.Int).Mul(a, b))) != 0 ||
+				value(mm.add(am, bm)).Cmp(mod(new(big.Int).Add(a, b))) != 0 ||
+				value(mm.sub(am, bm)).Cmp(mod(new(big.Int).Sub(a, b))) != 0 {
+				t.Fatalf("arithmetic on %x and %x does not match", a, b)
+			}
+			if a.Sign() > 0 && value(mm.inverse(am)).Cmp(new(big.Int).ModInverse(a, m)) != 0 {
+				t.Fatalf("inverse of %x does not match", a)
+			}
+		}
+	}
+}
+
+func TestVerifyPolicy(t *testing.T) {
+	t.Run("legacy", func(t *testing.T) {
+		for _, v := range signatureVectors {
+			pub, err := ParsePublicDIDKey(v.didKey)
+			if err != nil {
+				t.Fatal(err)
+			}
+			sig, _ := base64.RawStdEncoding.DecodeString(v.sig)
+			if err := LegacyPolicy.Verify(pub, signatureMessage, sig); err != nil {
+				t.Fatalf("%s: %v", v.name, err)
+			}
+		}
+	})
+
+	t.Run("algorithms", func(t *testing.T) {
+		v := signatureVectors[0]
+		pub, err := ParsePublicDIDKey(v.didKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sig, _ := base64.RawStdEncoding.DecodeString(v.sig)
+		policy := VerifyPolicy{Algorithms: []string{AlgES256K}}
+		if err := policy.Verify(pub, signatureMessage, sig); !errors.Is(err, ErrDisallowedKeyType) {
+			t.Fatal("expected disallowed key type error")
+		}
+	})
+}
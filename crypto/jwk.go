@@ -0,0 +1,77 @@
+package crypto
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+)
+
+// Public JSON Web Key for an elliptic curve key.
+type JWK struct {
+	Kty string `json:"kty"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+	Kid string `json:"kid,omitempty"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+}
+
+// Returns the JWK representation of a public key.
+func PublicJWK(pub PublicKey) (JWK, error) {
+	var x, y *big.Int
+	var crv string
+	switch k := pub.(type) {
+	case *P256PublicKey:
+		x, y, crv = k.pub.X, k.pub.Y, "P-256"
+	case *K256PublicKey:
+		x, y, crv = k.point.x, k.point.y, "secp256k1"
+	default:
+		return JWK{}, errUnsupportedKeyType
+	}
+	return JWK{
+		Kty: "EC",
+		Crv: crv,
+		X:   base64.RawURLEncoding.EncodeToString(x.FillBytes(make([]byte, 32))),
+		Y:   base64.RawURLEncoding.EncodeToString(y.FillBytes(make([]byte, 32))),
+	}, nil
+}
+
+// Parses an EC JWK into a public key.
+func ParsePublicJWK(j JWK) (PublicKey, error) {
+	if j.Kty != "EC" {
+		return nil, errors.New("unsupported JWK key type")
+	}
+	x, err := base64.RawURLEncoding.DecodeString(j.X)
+	if err != nil || len(x) != 32 {
+		return nil, errors.New("invalid JWK x coordinate")
+	}
+	y, err := base64.RawURLEncoding.DecodeString(j.Y)
+	if err != nil || len(y) != 32 {
+		return nil, errors.New("invalid JWK y coordinate")
+	}
+	raw := append(append([]byte{0x04}, x...), y...)
+	switch j.Crv {
+	case "P-256":
+		return ParseP256PublicKey(raw)
+	case "secp256k1":
+		return ParseK256PublicKey(raw)
+	default:
+		return nil, errors.New("unsupported JWK curve")
+	}
+}
+
+// Returns the RFC 7638 SHA-256 thumbprint of the key, base64url encoded.
+func (j JWK) Thumbprint() string {
+	// required members only, in lexicographic order
+	b, _ := json.Marshal(struct {
+		Crv string `json:"crv"`
+		Kty string `json:"kty"`
+		X   string `json:"x"`
+		Y   string `json:"y"`
+	}{j.Crv, j.Kty, j.X, j.Y})
+	sum := sha256.Sum256(b)
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
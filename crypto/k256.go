@@ -0,0 +1,293 @@
+package crypto
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"math/big"
+)
+
+// secp256k1 curve parameters, y² = x³ + 7 over GF(p)
+var (
+	k256P, _  = new(big.Int).SetString("FFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFEFFFFFC2F", 16)
+	k256N, _  = new(big.Int).SetString("FFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFEBAAEDCE6AF48A03BBFD25E8CD0364141", 16)
+	k256Gx, _ = new(big.Int).SetString("79BE667EF9DCBBAC55A06295CE870B07029BFCDB2DCE28D959F2815B16F81798", 16)
+	k256Gy, _ = new(big.Int).SetString("483ADA7726A3C4655DA4FBFC0E1108A8FD17B448A68554199C47D08FFB10D4B8", 16)
+	k256B     = big.NewInt(7)
+	k256HalfN = new(big.Int).Rsh(k256N, 1)
+	// exponent for modular square roots, valid since p ≡ 3 (mod 4)
+	k256SqrtExp = new(big.Int).Rsh(new(big.Int).Add(k256P, big.NewInt(1)), 2)
+)
+
+// affine point, with a nil x representing the point at infinity
+type k256Point struct {
+	x, y *big.Int
+}
+
+func (p k256Point) isInfinity() bool {
+	return p.x == nil
+}
+
+func k256Add(a, b k256Point) k256Point {
+	if a.isInfinity() {
+		return b
+	}
+	if b.isInfinity() {
+		return a
+	}
+	lambda := new(big.Int)
+	if a.x.Cmp(b.x) == 0 {
+		if a.y.Cmp(b.y) != 0 || a.y.Sign() == 0 {
+			return k256Point{}
+		}
+		// tangent: λ = 3x² / 2y
+		num := new(big.Int).Mul(a.x, a.x)
+		num.Mul(num, big.NewInt(3))
+		den := new(big.Int).Lsh(a.y, 1)
+		den.ModInverse(den, k256P)
+		lambda.Mul(num, den)
+	} else {
+		// chord: λ = (y2 - y1) / (x2 - x1)
+		num := new(big.Int).Sub(b.y, a.y)
+		den := new(big.Int).Sub(b.x, a.x)
+		den.Mod(den, k256P)
+		den.ModInverse(den, k256P)
+		lambda.Mul(num, den)
+	}
+	lambda.Mod(lambda, k256P)
+
+	x := new(big.Int).Mul(lambda, lambda)
+	x.Sub(x, a.x)
+	x.Sub(x, b.x)
+	x.Mod(x, k256P)
+
+	y := new(big.Int).Sub(a.x, x)
+	y.Mul(y, lambda)
+	y.Sub(y, a.y)
+	y.Mod(y, k256P)
+
+	return k256Point{x, y}
+}
+
+// Variable-time double-and-add, only for public scalars and points when verifying signatures. Signing uses the
+// constant-time k256BaseMultCT.
+func k256ScalarMult(p k256Point, k *big.Int) k256Point {
+	var r k256Point
+	for i := k.BitLen() - 1; i >= 0; i-- {
+		r = k256Add(r, r)
+		if k.Bit(i) == 1 {
+			r = k256Add(r, p)
+		}
+	}
+	return r
+}
+
+func k256BaseMult(k *big.Int) k256Point {
+	return k256ScalarMult(k256Point{k256Gx, k256Gy}, k)
+}
+
+func k256OnCurve(p k256Point) bool {
+	lhs := new(big.Int).Mul(p.y, p.y)
+	lhs.Mod(lhs, k256P)
+	rhs := new(big.Int).Exp(p.x, big.NewInt(3), k256P)
+	rhs.Add(rhs, k256B)
+	rhs.Mod(rhs, k256P)
+	return lhs.Cmp(rhs) == 0
+}
+
+// K-256 (secp256k1) public key.
+type K256PublicKey struct {
+	point k256Point
+}
+
+// Parses a 33-byte compressed or 65-byte uncompressed K-256 public key.
+func ParseK256PublicKey(b []byte) (*K256PublicKey, error) {
+	var p k256Point
+	switch {
+	case len(b) == 33 && (b[0] == 0x02 || b[0] == 0x03):
+		x := new(big.Int).SetBytes(b[1:])
+		if x.Cmp(k256P) >= 0 {
+			return nil, errors.New("invalid k256 public key")
+		}
+		y := new(big.Int).Exp(x, big.NewInt(3), k256P)
+		y.Add(y, k256B)
+		y.Exp(y, k256SqrtExp, k256P)
+		if y.Bit(0) != uint(b[0]&1) {
+			y.Sub(k256P, y)
+		}
+		p = k256Point{x, y}
+	case len(b) == 65 && b[0] == 0x04:
+		p = k256Point{new(big.Int).SetBytes(b[1:33]), new(big.Int).SetBytes(b[33:])}
+		if p.x.Cmp(k256P) >= 0 || p.y.Cmp(k256P) >= 0 {
+			return nil, errors.New("invalid k256 public key")
+		}
+	default:
+		return nil, errors.New("invalid k256 public key encoding")
+	}
+	if !k256OnCurve(p) {
+		return nil, errors.New("k256 public key is not on curve")
+	}
+	return &K256PublicKey{p}, nil
+}
+
+func (k *K256PublicKey) Algorithm() string {
+	return AlgES256K
+}
+
+func (k *K256PublicKey) Bytes() []byte {
+	b := make([]byte, 33)
+	b[0] = 0x02 | byte(k.point.y.Bit(0))
+	k.point.x.FillBytes(b[1:])
+	return b
+}
+
+func (k *K256PublicKey) Multibase() string {
+	return encodeMultibase(k256Codec, k.Bytes())
+}
+
+func (k *K256PublicKey) DIDKey() string {
+	return didKeyPrefix + k.Multibase()
+}
+
+func (k *K256PublicKey) VerifyDigest(digest, sig []byte) error {
+	return StrictPolicy.VerifyDigest(k, digest, sig)
+}
+
+func (k *K256PublicKey) verify(digest []byte, r, s *big.Int) bool {
+	if r.Sign() <= 0 || s.Sign() <= 0 || r.Cmp(k256N) >= 0 || s.Cmp(k256N) >= 0 {
+		return false
+	}
+	z := new(big.Int).SetBytes(digest)
+	w := new(big.Int).ModInverse(s, k256N)
+	u1 := new(big.Int).Mul(z, w)
+	u1.Mod(u1, k256N)
+	u2 := new(big.Int).Mul(r, w)
+	u2.Mod(u2, k256N)
+
+	p := k256Add(k256BaseMult(u1), k256ScalarMult(k.point, u2))
+	if p.isInfinity() {
+		return false
+	}
+	return new(big.Int).Mod(p.x, k256N).Cmp(r) == 0
+}
+
+// In-memory K-256 (secp256k1) private key.
+type K256PrivateKey struct {
+	d   limbs
+	pub *K256PublicKey
+}
+
+// Generates a new random K-256 private key.
+func GenerateK256() (*K256PrivateKey, error) {
+	b := make([]byte, 32)
+	for {
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		if k, err := ParseK256PrivateKey(b); err == nil {
+			return k, nil
+		}
+	}
+}
+
+// Parses a raw 32-byte K-256 private key.
+func ParseK256PrivateKey(b []byte) (*K256PrivateKey, error) {
+	if len(b) != 32 {
+		return nil, errors.New("invalid k256 private key length")
+	}
+	d := limbsFromBytes(b)
+	if !k256ValidScalar(d) {
+		return nil, errors.New("invalid k256 private key")
+	}
+	return &K256PrivateKey{d, &K256PublicKey{k256BaseMultCT(d)}}, nil
+}
+
+func (k *K256PrivateKey) PublicKey() PublicKey {
+	return k.pub
+}
+
+func (k *K256PrivateKey) Bytes() []byte {
+	return k.d.bytes()
+}
+
+func (k *K256PrivateKey) Algorithm() string {
+	return AlgES256K
+}
+
+func (k *K256PrivateKey) DIDKey() string {
+	return k.pub.DIDKey()
+}
+
+// Signs a digest using a deterministic RFC 6979 nonce. Computations involving the private key or the nonce
+// run in constant time.
+func (k *K256PrivateKey) Sign(ctx context.Context, digest []byte) ([]byte, error) {
+	if len(digest) != sha256.Size {
+		return nil, errors.New("invalid digest length")
+	}
+	o := k256Order
+	// the digest is below 2²⁵⁶ < 2n, so one conditional subtraction reduces it
+	z := o.reduce(0, limbsFromBytes(digest))
+	nonces := newRFC6979(k.Bytes(), z.bytes())
+	for {
+		nonce := limbsFromBytes(nonces.next())
+		if !k256ValidScalar(nonce) {
+			continue
+		}
+		// r is public, as part of the signature
+		r := new(big.Int).Mod(k256BaseMultCT(nonce).x, k256N)
+		if r.Sign() == 0 {
+			continue
+		}
+		// s = (z + r·d) / nonce mod n, in Montgomery form
+		sum := o.add(o.toMont(z), o.mul(o.toMont(limbsFromBig(r)), o.toMont(k.d)))
+		s := new(big.Int).SetBytes(o.fromMont(o.mul(sum, o.inverse(o.toMont(nonce)))).bytes())
+		if s.Sign() == 0 {
+			continue
+		}
+		if s.Cmp(k256HalfN) > 0 {
+			s.Sub(k256N, s)
+		}
+		sig := make([]byte, 64)
+		r.FillBytes(sig[:32])
+		s.FillBytes(sig[32:])
+		return sig, nil
+	}
+}
+
+// deterministic nonce generator per RFC 6979 section 3.2, using HMAC-SHA256
+type rfc6979 struct {
+	k, v  []byte
+	first bool
+}
+
+func newRFC6979(x, h []byte) *rfc6979 {
+	g := &rfc6979{k: make([]byte, 32), v: make([]byte, 32), first: true}
+	for i := range g.v {
+		g.v[i] = 0x01
+	}
+	g.k = g.mac(g.v, []byte{0x00}, x, h)
+	g.v = g.mac(g.v)
+	g.k = g.mac(g.v, []byte{0x01}, x, h)
+	g.v = g.mac(g.v)
+	return g
+}
+
+func (g *rfc6979) mac(parts ...[]byte) []byte {
+	m := hmac.New(sha256.New, g.k)
+	for _, p := range parts {
+		m.Write(p)
+	}
+	return m.Sum(nil)
+}
+
+func (g *rfc6979) next() []byte {
+	if !g.first {
+		g.k = g.mac(g.v, []byte{0x00})
+		g.v = g.mac(g.v)
+	}
+	g.first = false
+	g.v = g.mac(g.v)
+	return g.v
+}
@@ -0,0 +1,261 @@
+package crypto
+
+import (
+	"math/big"
+	"math/bits"
+)
+
+// Constant-time arithmetic for K-256 signing, where timing must not depend on the private key or the nonce.
+// Integers are fixed-width, with no branches or memory accesses depending on secret values; math/bits
+// multiplications and additions compile to constant-time instructions on the supported platforms. The big.Int
+// arithmetic of k256.go is only used on public values, to verify signatures.
+
+// 256-bit integer as little-endian 64-bit limbs
+type limbs [4]uint64
+
+func limbsFromBytes(b []byte) limbs {
+	var l limbs
+	for i := range l {
+		l[i] = uint64(b[31-8*i]) | uint64(b[30-8*i])<<8 | uint64(b[29-8*i])<<16 | uint64(b[28-8*i])<<24 |
+			uint64(b[27-8*i])<<32 | uint64(b[26-8*i])<<40 | uint64(b[25-8*i])<<48 | uint64(b[24-8*i])<<56
+	}
+	return l
+}
+
+func (l limbs) bytes() []byte {
+	b := make([]byte, 32)
+	for i, w := range l {
+		for j := range 8 {
+			b[31-8*i-j] = byte(w >> (8 * j))
+		}
+	}
+	return b
+}
+
+func limbsFromBig(x *big.Int) limbs {
+	return limbsFromBytes(x.FillBytes(make([]byte, 32)))
+}
+
+// returns b if cond is 1 and a if it is 0
+func selectLimbs(cond uint64, a, b limbs) limbs {
+	mask := -cond
+	for i := range a {
+		a[i] = a[i]&^mask | b[i]&mask
+	}
+	return a
+}
+
+// returns 1 if a == b and 0 otherwise
+func eqWord(a, b uint64) uint64 {
+	x := a ^ b
+	return 1 ^ (x|-x)>>63
+}
+
+// Odd 256-bit modulus with arithmetic in Montgomery form, aR mod m with R = 2²⁵⁶.
+type montModulus struct {
+	m limbs
+	// -m⁻¹ mod 2⁶⁴
+	m0inv uint64
+	// R² mod m, to convert into Montgomery form
+	r2 limbs
+	// R mod m, 1 in Montgomery form
+	one limbs
+	// m - 2, the exponent of inverses
+	inverseExp *big.Int
+}
+
+func newMontModulus(m *big.Int) *montModulus {
+	mm := &montModulus{m: limbsFromBig(m), inverseExp: new(big.Int).Sub(m, big.NewInt(2))}
+	// Newton iteration doubling the correct low bits of the inverse each step
+	inv := mm.m[0]
+	for range 5 {
+		inv *= 2 - mm.m[0]*inv
+	}
+	mm.m0inv = -inv
+	r := new(big.Int).Lsh(big.NewInt(1), 256)
+	mm.one = limbsFromBig(new(big.Int).Mod(r, m))
+	mm.r2 = limbsFromBig(new(big.Int).Mod(new(big.Int).Mul(r, r), m))
+	return mm
+}
+
+var (
+	k256Field = newMontModulus(k256P)
+	k256Order = newMontModulus(k256N)
+)
+
+// returns a - m if hi:a >= m, and a otherwise, for hi:a < 2m
+func (mm *montModulus) reduce(hi uint64, a limbs) limbs {
+	var d limbs
+	var borrow uint64
+	for i := range a {
+		d[i], borrow = bits.Sub64(a[i], mm.m[i], borrow)
+	}
+	// the subtraction is kept if it did not borrow, or if the borrow came out of hi
+	return selectLimbs(hi|(borrow^1), a, d)
+}
+
+// Returns a·b·R⁻¹ mod m, by coarsely integrated operand scanning.
+func (mm *montModulus) mul(a, b limbs) limbs {
+	var t [6]uint64
+	for i := range 4 {
+		var c uint64
+		for j := range 4 {
+			t[j], c = mulAdd(a[j], b[i], t[j], c)
+		}
+		var carry uint64
+		t[4], carry = bits.Add64(t[4], c, 0)
+		t[5] = carry
+
+		m := t[0] * mm.m0inv
+		_, c = mulAdd(m, mm.m[0], t[0], 0)
+		for j := 1; j < 4; j++ {
+			t[j-1], c = mulAdd(m, mm.m[j], t[j], c)
+		}
+		t[3], carry = bits.Add64(t[4], c, 0)
+		t[4] = t[5] + carry
+	}
+	return mm.reduce(t[4], limbs{t[0], t[1], t[2], t[3]})
+}
+
+// returns the low and high words of x·y + a + c
+func mulAdd(x, y, a, c uint64) (lo, hi uint64) {
+	hi, lo = bits.Mul64(x, y)
+	var carry uint64
+	lo, carry = bits.Add64(lo, a, 0)
+	hi += carry
+	lo, carry = bits.Add64(lo, c, 0)
+	hi += carry
+	return lo, hi
+}
+
+func (mm *montModulus) add(a, b limbs) limbs {
+	var s limbs
+	var carry uint64
+	for i := range a {
+		s[i], carry = bits.Add64(a[i], b[i], carry)
+	}
+	return mm.reduce(carry, s)
+}
+
+func (mm *montModulus) sub(a, b limbs) limbs {
+	var d limbs
+	var borrow uint64
+	for i := range a {
+		d[i], borrow = bits.Sub64(a[i], b[i], borrow)
+	}
+	// adds m back if the subtraction borrowed
+	mask := -borrow
+	var carry uint64
+	for i := range d {
+		d[i], carry = bits.Add64(d[i], mm.m[i]&mask, carry)
+	}
+	return d
+}
+
+// converts a < m into Montgomery form
+func (mm *montModulus) toMont(a limbs) limbs {
+	return mm.mul(a, mm.r2)
+}
+
+func (mm *montModulus) fromMont(a limbs) limbs {
+	return mm.mul(a, limbs{1})
+}
+
+// Returns a⁻¹ in Montgomery form, as a^(m-2) by Fermat's little theorem, m being prime. The exponent is public,
+// so only the value of a is secret.
+func (mm *montModulus) inverse(a limbs) limbs {
+	r := mm.one
+	for i := mm.inverseExp.BitLen() - 1; i >= 0; i-- {
+		r = mm.mul(r, r)
+		if mm.inverseExp.Bit(i) == 1 {
+			r = mm.mul(r, a)
+		}
+	}
+	return r
+}
+
+// K-256 point in projective coordinates (X:Y:Z), x = X/Z and y = Y/Z, in Montgomery form. The point at
+// infinity is (0:1:0).
+type k256Proj struct {
+	x, y, z limbs
+}
+
+// 3b in Montgomery form, for the complete addition formulas
+var k256B3 = k256Field.toMont(limbs{21})
+
+// Returns p + q with the complete addition formulas for a = 0 of Renes, Costello and Batina (2016, algorithm
+// 7), which are correct for every input, including doublings and the point at infinity, so that no branch
+// depends on the points.
+func k256ProjAdd(p, q k256Proj) k256Proj {
+	f := k256Field
+	t0 := f.mul(p.x, q.x)
+	t1 := f.mul(p.y, q.y)
+	t2 := f.mul(p.z, q.z)
+	t3 := f.mul(f.add(p.x, p.y), f.add(q.x, q.y))
+	t4 := f.add(t0, t1)
+	t3 = f.sub(t3, t4)
+	t4 = f.mul(f.add(p.y, p.z), f.add(q.y, q.z))
+	x3 := f.add(t1, t2)
+	t4 = f.sub(t4, x3)
+	x3 = f.mul(f.add(p.x, p.z), f.add(q.x, q.z))
+	y3 := f.add(t0, t2)
+	y3 = f.sub(x3, y3)
+	x3 = f.add(t0, t0)
+	t0 = f.add(x3, t0)
+	t2 = f.mul(k256B3, t2)
+	z3 := f.add(t1, t2)
+	t1 = f.sub(t1, t2)
+	y3 = f.mul(k256B3, y3)
+	x3 = f.mul(t4, y3)
+	t2 = f.mul(t3, t1)
+	x3 = f.sub(t2, x3)
+	y3 = f.mul(y3, t0)
+	t1 = f.mul(t1, z3)
+	y3 = f.add(t1, y3)
+	t0 = f.mul(t0, t3)
+	z3 = f.mul(z3, t4)
+	z3 = f.add(z3, t0)
+	return k256Proj{x3, y3, z3}
+}
+
+// Returns k·G for a secret scalar k < n as an affine point, in constant time: the scalar is consumed in 4-bit
+// windows over all 256 bits, each window adding a multiple of G selected from a table by scanning every entry.
+func k256BaseMultCT(k limbs) k256Point {
+	f := k256Field
+	var table [16]k256Proj
+	table[0] = k256Proj{y: f.one}
+	table[1] = k256Proj{f.toMont(limbsFromBig(k256Gx)), f.toMont(limbsFromBig(k256Gy)), f.one}
+	for i := 2; i < len(table); i++ {
+		table[i] = k256ProjAdd(table[i-1], table[1])
+	}
+
+	r := table[0]
+	for w := 63; w >= 0; w-- {
+		for range 4 {
+			r = k256ProjAdd(r, r)
+		}
+		window := k[w/16] >> (4 * (w % 16)) & 0xf
+		sel := table[0]
+		for i := range table {
+			c := eqWord(uint64(i), window)
+			sel = k256Proj{selectLimbs(c, sel.x, table[i].x), selectLimbs(c, sel.y, table[i].y),
+				selectLimbs(c, sel.z, table[i].z)}
+		}
+		r = k256ProjAdd(r, sel)
+	}
+
+	zinv := f.inverse(r.z)
+	x := f.fromMont(f.mul(r.x, zinv))
+	y := f.fromMont(f.mul(r.y, zinv))
+	return k256Point{new(big.Int).SetBytes(x.bytes()), new(big.Int).SetBytes(y.bytes())}
+}
+
+// Reports whether 0 < k < n, without branching on k.
+func k256ValidScalar(k limbs) bool {
+	var borrow uint64
+	for i := range k {
+		_, borrow = bits.Sub64(k[i], k256Order.m[i], borrow)
+	}
+	nonZero := k[0] | k[1] | k[2] | k[3]
+	return borrow&((nonZero|-nonZero)>>63) == 1
+}
@@ -0,0 +1,135 @@
+package crypto
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"math/big"
+)
+
+var (
+	p256N     = elliptic.P256().Params().N
+	p256HalfN = new(big.Int).Rsh(p256N, 1)
+)
+
+// P-256 (NIST secp256r1) public key.
+type P256PublicKey struct {
+	pub *ecdsa.PublicKey
+}
+
+// Parses a 33-byte compressed or 65-byte uncompressed P-256 public key.
+func ParseP256PublicKey(b []byte) (*P256PublicKey, error) {
+	curve := elliptic.P256()
+	var x, y *big.Int
+	switch {
+	case len(b) == 33:
+		x, y = elliptic.UnmarshalCompressed(curve, b)
+	case len(b) == 65:
+		x, y = elliptic.Unmarshal(curve, b)
+	}
+	if x == nil {
+		return nil, errors.New("invalid p256 public key")
+	}
+	return &P256PublicKey{&ecdsa.PublicKey{Curve: curve, X: x, Y: y}}, nil
+}
+
+func (k *P256PublicKey) Algorithm() string {
+	return AlgES256
+}
+
+func (k *P256PublicKey) Bytes() []byte {
+	return elliptic.MarshalCompressed(k.pub.Curve, k.pub.X, k.pub.Y)
+}
+
+func (k *P256PublicKey) Multibase() string {
+	return encodeMultibase(p256Codec, k.Bytes())
+}
+
+func (k *P256PublicKey) DIDKey() string {
+	return didKeyPrefix + k.Multibase()
+}
+
+// Returns the standard library representation of the key.
+func (k *P256PublicKey) ECDSA() *ecdsa.PublicKey {
+	return k.pub
+}
+
+func (k *P256PublicKey) VerifyDigest(digest, sig []byte) error {
+	return StrictPolicy.VerifyDigest(k, digest, sig)
+}
+
+func (k *P256PublicKey) verify(digest []byte, r, s *big.Int) bool {
+	return ecdsa.Verify(k.pub, digest, r, s)
+}
+
+// In-memory P-256 (NIST secp256r1) private key.
+type P256PrivateKey struct {
+	priv *ecdsa.PrivateKey
+	pub  *P256PublicKey
+}
+
+// Generates a new random P-256 private key.
+func GenerateP256() (*P256PrivateKey, error) {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return &P256PrivateKey{priv, &P256PublicKey{&priv.PublicKey}}, nil
+}
+
+// Parses a raw 32-byte P-256 private key.
+func ParseP256PrivateKey(b []byte) (*P256PrivateKey, error) {
+	if len(b) != 32 {
+		return nil, errors.New("invalid p256 private key length")
+	}
+	d := new(big.Int).SetBytes(b)
+	if d.Sign() == 0 || d.Cmp(p256N) >= 0 {
+		return nil, errors.New("invalid p256 private key")
+	}
+	curve := elliptic.P256()
+	priv := &ecdsa.PrivateKey{D: d}
+	priv.Curve = curve
+	priv.X, priv.Y = curve.ScalarBaseMult(b)
+	return &P256PrivateKey{priv, &P256PublicKey{&priv.PublicKey}}, nil
+}
+
+func (k *P256PrivateKey) PublicKey() PublicKey {
+	return k.pub
+}
+
+func (k *P256PrivateKey) Bytes() []byte {
+	return k.priv.D.FillBytes(make([]byte, 32))
+}
+
+func (k *P256PrivateKey) Algorithm() string {
+	return AlgES256
+}
+
+func (k *P256PrivateKey) DIDKey() string {
+	return k.pub.DIDKey()
+}
+
+// Returns the standard library representation of the key.
+func (k *P256PrivateKey) ECDSA() *ecdsa.PrivateKey {
+	return k.priv
+}
+
+func (k *P256PrivateKey) Sign(ctx context.Context, digest []byte) ([]byte, error) {
+	if len(digest) != sha256.Size {
+		return nil, errors.New("invalid digest length")
+	}
+	r, s, err := ecdsa.Sign(rand.Reader, k.priv, digest)
+	if err != nil {
+		return nil, err
+	}
+	if s.Cmp(p256HalfN) > 0 {
+		s.Sub(p256N, s)
+	}
+	sig := make([]byte, 64)
+	r.FillBytes(sig[:32])
+	s.FillBytes(sig[32:])
+	return sig, nil
+}
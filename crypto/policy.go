@@ -0,0 +1,101 @@
+package crypto
+
+import (
+	"crypto/sha256"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"math/big"
+	"slices"
+)
+
+// Signature verification rules. The zero value is the strict atproto policy: compact 64-byte low-S
+// signatures on either supported curve.
+//
+// Relaxed policies exist to check historical data signed by older implementations, and should not be used
+// when accepting new writes.
+type VerifyPolicy struct {
+	// Accept signatures where s is in the upper half of the curve order.
+	AllowHighS bool
+	// Accept ASN.1 DER-encoded signatures in addition to the compact form.
+	AllowDER bool
+	// JWT algorithm names of the accepted key types. An empty list accepts all supported types.
+	Algorithms []string
+}
+
+// Strict atproto verification policy, used by PublicKey.VerifyDigest.
+var StrictPolicy = VerifyPolicy{}
+
+// Lenient policy accepting high-S and DER-encoded signatures on any supported curve.
+var LegacyPolicy = VerifyPolicy{AllowHighS: true, AllowDER: true}
+
+var (
+	ErrInvalidSignature   = errors.New("signature verification failed")
+	ErrHighS              = errors.New("signature is not in low-S form")
+	ErrDisallowedKeyType  = errors.New("key type not allowed by verification policy")
+	ErrSignatureEncoding  = errors.New("invalid signature encoding")
+	errUnsupportedKeyType = errors.New("unsupported public key implementation")
+)
+
+type ecdsaKey interface {
+	verify(digest []byte, r, s *big.Int) bool
+}
+
+// Hashes content with SHA-256 and verifies the signature against the digest under this policy.
+func (p VerifyPolicy) Verify(pub PublicKey, content, sig []byte) error {
+	digest := sha256.Sum256(content)
+	return p.VerifyDigest(pub, digest[:], sig)
+}
+
+// Verifies a signature over a SHA-256 digest under this policy.
+func (p VerifyPolicy) VerifyDigest(pub PublicKey, digest, sig []byte) error {
+	if len(p.Algorithms) > 0 && !slices.Contains(p.Algorithms, pub.Algorithm()) {
+		return fmt.Errorf("%w: %s", ErrDisallowedKeyType, pub.Algorithm())
+	}
+	k, ok := pub.(ecdsaKey)
+	if !ok {
+		return errUnsupportedKeyType
+	}
+
+	r, s, err := p.parseSig(sig)
+	if err != nil {
+		return err
+	}
+
+	var halfN *big.Int
+	switch pub.Algorithm() {
+	case AlgES256K:
+		halfN = k256HalfN
+	default:
+		halfN = p256HalfN
+	}
+	if !p.AllowHighS && s.Cmp(halfN) > 0 {
+		return ErrHighS
+	}
+
+	if !k.verify(digest, r, s) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+func (p VerifyPolicy) parseSig(sig []byte) (r, s *big.Int, err error) {
+	if len(sig) == 64 {
+		return new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:]), nil
+	}
+	if !p.AllowDER {
+		return nil, nil, fmt.Errorf("%w: expected 64-byte compact form", ErrSignatureEncoding)
+	}
+
+	var der struct {
+		R, S *big.Int
+	}
+	rest, err := asn1.Unmarshal(sig, &der)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %w", ErrSignatureEncoding, err)
+	}
+	if len(rest) != 0 {
+		return nil, nil, fmt.Errorf("%w: trailing bytes after DER signature", ErrSignatureEncoding)
+	}
+	return der.R, der.S, nil
+}
@@ -0,0 +1,118 @@
+// Package dag traverses the blocks reachable from a root CID in a blockstore, such as the commit, tree nodes
+// and records of a repository, as needed for garbage collection marking, export selection and analytics over
+// repository graphs.
+package dag
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/notjuliet/grove/blockstore"
+	"github.com/notjuliet/grove/cbor"
+	"github.com/notjuliet/grove/cid"
+)
+
+// Returned by a visit function to skip the links of the visited block. The walk continues with other blocks.
+var SkipLinks = errors.New("skip links")
+
+type WalkOptions struct {
+	// Maximum number of links followed from the root; blocks deeper are not visited. The root is at depth 0,
+	// and 0 means no limit.
+	MaxDepth int
+	// Reports whether to follow a link, given its path within the block containing it: map keys and array
+	// indices joined by "/", such as "e/0/v" for the value of the first entry of an MST node. Nil follows
+	// every link.
+	Follow func(path string, link cid.Cid) bool
+	// Skips blocks missing from the blockstore, other than the root, instead of failing.
+	SkipMissing bool
+}
+
+// Calls visit for each block reachable from root, with its decoded value: the DAG-CBOR value for DAG-CBOR
+// blocks, or the data of raw blocks, which have no links. Blocks are visited once each, in breadth-first
+// order with links in encoding order, so that every block is visited at the least depth it is reachable
+// at. Walking stops at the first error returned by visit, other than SkipLinks.
+func Walk(ctx context.Context, bs blockstore.Blockstore, root cid.Cid, visit func(c cid.Cid, decoded any) error) error {
+	return WalkWith(ctx, bs, root, visit, WalkOptions{})
+}
+
+// Walks the blocks reachable from root like Walk, with options.
+func WalkWith(ctx context.Context, bs blockstore.Blockstore, root cid.Cid, visit func(c cid.Cid, decoded any) error,
+	opts WalkOptions) error {
+	seen := cid.NewSet(root)
+	level := []cid.Cid{root}
+	for depth := 0; len(level) > 0; depth++ {
+		var next []cid.Cid
+		for _, c := range level {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			data, err := bs.Get(ctx, c)
+			if errors.Is(err, blockstore.ErrNotFound) && opts.SkipMissing && depth > 0 {
+				continue
+			}
+			if err != nil {
+				return fmt.Errorf("fetching block %s: %w", c, err)
+			}
+
+			var decoded any = data
+			if c.Codec == cid.CodecCbor {
+				if decoded, err = cbor.Decode(data); err != nil {
+					return fmt.Errorf("decoding block %s: %w", c, err)
+				}
+			}
+			if err := visit(c, decoded); err == SkipLinks {
+				continue
+			} else if err != nil {
+				return err
+			}
+			if opts.MaxDepth > 0 && depth >= opts.MaxDepth {
+				continue
+			}
+
+			err = links(decoded, "", func(path string, l cid.Cid) {
+				if (opts.Follow == nil || opts.Follow(path, l)) && seen.Add(l) {
+					next = append(next, l)
+				}
+			})
+			if err != nil {
+				return fmt.Errorf("decoding block %s: %w", c, err)
+			}
+		}
+		level = next
+	}
+	return nil
+}
+
+// calls fn with the path and CID of every link in a decoded value, in encoding order
+func links(v any, path string, fn func(path string, l cid.Cid)) error {
+	switch v := v.(type) {
+	case cid.CidLink:
+		c, err := v.Cid()
+		if err != nil {
+			return err
+		}
+		fn(path, c)
+	case []any:
+		for i, elem := range v {
+			if err := links(elem, join(path, strconv.Itoa(i)), fn); err != nil {
+				return err
+			}
+		}
+	case map[string]any:
+		for _, k := range cbor.SortedKeys(v) {
+			if err := links(v[k], join(path, k), fn); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func join(path, elem string) string {
+	if path == "" {
+		return elem
+	}
+	return path + "/" + elem
+}
@@ -0,0 +1,110 @@
+package dag
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/notjuliet/grove/blockstore"
+	"github.com/notjuliet/grove/cbor"
+	"github.com/notjuliet/grove/cid"
+)
+
+func put(t *testing.T, bs blockstore.Blockstore, v any) cid.Cid {
+	t.Helper()
+	codec, data := cid.CodecCbor, []byte(nil)
+	if b, ok := v.([]byte); ok {
+		codec, data = cid.CodecRaw, b
+	} else {
+		var err error
+		if data, err = cbor.Encode(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c, err := cid.Create(codec, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bs.Put(context.Background(), c, data); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestWalk(t *testing.T) {
+	ctx := context.Background()
+	bs := blockstore.NewMemoryBlockstore()
+	leaf := put(t, bs, map[string]any{"leaf": true})
+	raw := put(t, bs, []byte("raw"))
+	mid := put(t, bs, map[string]any{"x": leaf.Link()})
+	root := put(t, bs, map[string]any{"a": mid.Link(), "b": []any{raw.Link(), mid.Link(), leaf.Link()}})
+
+	walk := func(opts WalkOptions) ([]cid.Cid, error) {
+		var visited []cid.Cid
+		err := WalkWith(ctx, bs, root, func(c cid.Cid, decoded any) error {
+			if c.Codec == cid.CodecRaw && string(decoded.([]byte)) != "raw" {
+				t.Fatalf("unexpected raw block %q", decoded)
+			}
+			visited = append(visited, c)
+			return nil
+		}, opts)
+		return visited, err
+	}
+	names := map[string]string{root.String(): "root", mid.String(): "mid", leaf.String(): "leaf", raw.String(): "raw"}
+	check := func(opts WalkOptions, expected ...string) {
+		t.Helper()
+		visited, err := walk(opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := []string{}
+		for _, c := range visited {
+			got = append(got, names[c.String()])
+		}
+		if !reflect.DeepEqual(got, expected) {
+			t.Fatalf("expected %v, got %v", expected, got)
+		}
+	}
+
+	check(WalkOptions{}, "root", "mid", "raw", "leaf")
+	check(WalkOptions{MaxDepth: 1}, "root", "mid", "raw", "leaf")
+	chain := func(path string, _ cid.Cid) bool { return path == "a" || path == "x" }
+	check(WalkOptions{Follow: chain}, "root", "mid", "leaf")
+	check(WalkOptions{Follow: chain, MaxDepth: 1}, "root", "mid")
+	check(WalkOptions{Follow: func(path string, _ cid.Cid) bool { return path == "a" }}, "root", "mid")
+	check(WalkOptions{Follow: func(path string, _ cid.Cid) bool { return path != "b/2" }}, "root", "mid", "raw", "leaf")
+	check(WalkOptions{Follow: func(path string, _ cid.Cid) bool { return path == "b/0" }}, "root", "raw")
+
+	var visited int
+	err := Walk(ctx, bs, root, func(c cid.Cid, decoded any) error {
+		visited++
+		if c.String() == root.String() {
+			return SkipLinks
+		}
+		return nil
+	})
+	if err != nil || visited != 1 {
+		t.Fatalf("expected links of root to be skipped, visited %d: %v", visited, err)
+	}
+
+	if err := bs.Delete(ctx, leaf); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := walk(WalkOptions{}); !errors.Is(err, blockstore.ErrNotFound) {
+		t.Fatalf("expected not found error, got %v", err)
+	}
+	check(WalkOptions{SkipMissing: true}, "root", "mid", "raw")
+	if err := bs.Delete(ctx, root); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := walk(WalkOptions{SkipMissing: true}); !errors.Is(err, blockstore.ErrNotFound) {
+		t.Fatalf("expected not found error for root, got %v", err)
+	}
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := Walk(canceled, bs, mid, func(cid.Cid, any) error { return nil }); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected canceled error, got %v", err)
+	}
+}
@@ -0,0 +1,70 @@
+package data
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/notjuliet/grove/cbor"
+)
+
+// Bytes of the data model, for byte fields of struct types. Unlike []byte, which encoding/json writes as a
+// base64 string, Bytes encodes to lexicon JSON as a {"$bytes": base64} object, and to CBOR as a byte string.
+type Bytes []byte
+
+type jsonBytes struct {
+	Bytes *string `json:"$bytes"`
+}
+
+func (b Bytes) MarshalJSON() ([]byte, error) {
+	if b == nil {
+		return []byte("null"), nil
+	}
+	s := base64.RawStdEncoding.EncodeToString(b)
+	return json.Marshal(jsonBytes{Bytes: &s})
+}
+
+func (b *Bytes) UnmarshalJSON(raw []byte) error {
+	if string(raw) == "null" {
+		*b = nil
+		return nil
+	}
+	var jb jsonBytes
+	if err := json.Unmarshal(raw, &jb); err != nil {
+		return err
+	}
+	if jb.Bytes == nil {
+		return errors.New("bytes object without $bytes")
+	}
+	v, err := base64.RawStdEncoding.DecodeString(strings.TrimRight(*jb.Bytes, "="))
+	if err != nil {
+		return fmt.Errorf("invalid $bytes: %w", err)
+	}
+	*b = v
+	return nil
+}
+
+func (b Bytes) MarshalCBOR() ([]byte, error) {
+	if b == nil {
+		return cbor.Encode(nil)
+	}
+	return cbor.Encode([]byte(b))
+}
+
+func (b *Bytes) UnmarshalCBOR(raw []byte) error {
+	v, err := cbor.Decode(raw)
+	if err != nil {
+		return err
+	}
+	switch v := v.(type) {
+	case nil:
+		*b = nil
+	case []byte:
+		*b = v
+	default:
+		return errors.New("expected a CBOR byte string")
+	}
+	return nil
+}
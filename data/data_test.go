@@ -0,0 +1,259 @@
+package data
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/notjuliet/grove/cbor"
+	"github.com/notjuliet/grove/cid"
+)
+
+func TestFixtures(t *testing.T) {
+	b, err := os.ReadFile("testdata/data-model-fixtures.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fixtures []struct {
+		JSON       json.RawMessage `json:"json"`
+		CBORBase64 string          `json:"cbor_base64"`
+		Cid        string          `json:"cid"`
+	}
+	if err := json.Unmarshal(b, &fixtures); err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range fixtures {
+		expected, err := base64.RawStdEncoding.DecodeString(f.CBORBase64)
+		if err != nil {
+			t.Fatal(err)
+		}
+		v, err := UnmarshalJSON(f.JSON)
+		if err != nil {
+			t.Fatalf("%s: %v", f.Cid, err)
+		}
+		enc, err := cbor.Encode(v)
+		if err != nil {
+			t.Fatalf("%s: %v", f.Cid, err)
+		}
+		if !bytes.Equal(enc, expected) {
+			t.Fatalf("%s: unexpected CBOR %x", f.Cid, enc)
+		}
+		if c, _ := cid.Create(cid.CodecCbor, enc); c.String() != f.Cid {
+			t.Fatalf("unexpected CID %s, expected %s", c, f.Cid)
+		}
+
+		// and back to the same JSON
+		dec, err := cbor.Decode(expected)
+		if err != nil {
+			t.Fatal(err)
+		}
+		j, err := MarshalJSON(dec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got, want any
+		if err := json.Unmarshal(j, &got); err != nil {
+			t.Fatal(err)
+		}
+		if err := json.Unmarshal(f.JSON, &want); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("%s: unexpected JSON %s", f.Cid, j)
+		}
+	}
+}
+
+func TestValidity(t *testing.T) {
+	for _, name := range []string{"valid", "invalid"} {
+		b, err := os.ReadFile("testdata/data-model-" + name + ".json")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var fixtures []struct {
+			Note string          `json:"note"`
+			JSON json.RawMessage `json:"json"`
+		}
+		if err := json.Unmarshal(b, &fixtures); err != nil {
+			t.Fatal(err)
+		}
+		for _, f := range fixtures {
+			// any value converts, records are required to be objects by the lexicon package
+			if f.Note == "top-level not an object" {
+				continue
+			}
+			_, err := UnmarshalJSON(f.JSON)
+			if name == "valid" && err != nil {
+				t.Errorf("%s: %v", f.Note, err)
+			}
+			if name == "invalid" && err == nil {
+				t.Errorf("%s: expected an error", f.Note)
+			}
+		}
+	}
+}
+
+func TestConvert(t *testing.T) {
+	c, _ := cid.Create(cid.CodecRaw, []byte("hello"))
+	v := map[string]any{"link": c.Link(), "bytes": []byte{1, 2, 3}, "list": []any{int64(1), "a", nil}}
+	j := ToJSON(v)
+	expected := map[string]any{"link": map[string]any{"$link": c.String()}, "bytes": map[string]any{"$bytes": "AQID"},
+		"list": []any{int64(1), "a", nil}}
+	if !reflect.DeepEqual(j, expected) {
+		t.Fatalf("unexpected JSON form %v", j)
+	}
+	back, err := FromJSON(j)
+	if err != nil || !reflect.DeepEqual(back, v) {
+		t.Fatalf("unexpected data %v, %v", back, err)
+	}
+
+	// values decoded with float64 numbers
+	var decoded any
+	if err := json.Unmarshal([]byte(`{"n": 12, "big": 1e3}`), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := FromJSON(decoded); err != nil || !reflect.DeepEqual(v, map[string]any{"n": int64(12),
+		"big": int64(1000)}) {
+		t.Fatalf("unexpected data %v, %v", v, err)
+	}
+	for _, s := range []string{`1.5`, `{"$type": ""}`, `{"$bytes": "AQID", "x": 1}`, `{} {}`} {
+		if _, err := UnmarshalJSON([]byte(s)); err == nil {
+			t.Errorf("%s: expected an error", s)
+		}
+	}
+}
+
+func TestBytes(t *testing.T) {
+	type record struct {
+		Data  Bytes `json:"data"`
+		Empty Bytes `json:"empty,omitempty"`
+	}
+	j, err := json.Marshal(record{Data: Bytes{1, 2, 3}})
+	if err != nil || string(j) != `{"data":{"$bytes":"AQID"}}` {
+		t.Fatalf("unexpected JSON %s, %v", j, err)
+	}
+	var r record
+	if err := json.Unmarshal([]byte(`{"data":{"$bytes":"AQID"}}`), &r); err != nil ||
+		!bytes.Equal(r.Data, []byte{1, 2, 3}) {
+		t.Fatalf("unexpected record %+v, %v", r, err)
+	}
+	if err := json.Unmarshal([]byte(`{"data":"AQID"}`), &r); err == nil {
+		t.Fatal("expected an error for a base64 string")
+	}
+
+	// CBOR byte string, also inside data model values
+	b, err := cbor.Encode(map[string]any{"data": Bytes{1, 2, 3}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, _ := cbor.Encode(map[string]any{"data": []byte{1, 2, 3}})
+	if !bytes.Equal(b, expected) {
+		t.Fatalf("unexpected CBOR %x", b)
+	}
+	var v Bytes
+	if err := v.UnmarshalCBOR(expected[6:]); err != nil || !bytes.Equal(v, []byte{1, 2, 3}) {
+		t.Fatalf("unexpected bytes %v, %v", v, err)
+	}
+	if j, err := MarshalJSON(map[string]any{"data": Bytes{1, 2, 3}}); err != nil ||
+		string(j) != `{"data":{"$bytes":"AQID"}}` {
+		t.Fatalf("unexpected JSON %s, %v", j, err)
+	}
+}
+
+func TestEqual(t *testing.T) {
+	c, _ := cid.Create(cid.CodecRaw, []byte("hello"))
+	v := map[string]any{"link": c.Link(), "bytes": []byte{1, 2, 3}, "list": []any{int64(1), "a", nil, int64(-2)}}
+	clone := Clone(v).(map[string]any)
+	if !Equal(v, clone) {
+		t.Fatalf("clone %v is not equal to %v", clone, v)
+	}
+	clone["bytes"].([]byte)[0] = 9
+	clone["list"].([]any)[1] = "b"
+	clone["link"].(cid.CidLink).Bytes[0] = 0
+	if v["bytes"].([]byte)[0] != 1 || v["list"].([]any)[1] != "a" || v["link"].(cid.CidLink).Bytes[0] == 0 {
+		t.Fatalf("modifying the clone modified %v", v)
+	}
+
+	// values decoded from CBOR and JSON
+	b, err := cbor.Encode(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fromCBOR, err := cbor.Decode(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	j, err := MarshalJSON(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fromJSON, err := UnmarshalJSON(j)
+	if err != nil || !Equal(fromCBOR, fromJSON) || !Equal(fromJSON, v) {
+		t.Fatalf("decoded values %v and %v are not equal, %v", fromCBOR, fromJSON, err)
+	}
+
+	for _, pair := range [][2]any{
+		{int64(1), uint64(2)},
+		{int64(-1), uint64(math.MaxUint64)},
+		{"1", int64(1)},
+		{[]any{}, map[string]any{}},
+		{map[string]any{"a": nil}, map[string]any{"b": nil}},
+		{[]byte{1}, []any{int64(1)}},
+	} {
+		if Equal(pair[0], pair[1]) {
+			t.Errorf("%v and %v are equal", pair[0], pair[1])
+		}
+	}
+	if !Equal(Bytes{1}, []byte{1}) || !Equal(1, uint64(1)) {
+		t.Error("expected equal values")
+	}
+}
+
+func TestMap(t *testing.T) {
+	c, _ := cid.Create(cid.CodecRaw, []byte("hello"))
+	b, _ := cbor.Encode(map[string]any{
+		"text":  "hi",
+		"embed": map[string]any{"images": []any{map[string]any{"alt": "cat", "image": c.Link()}}},
+		"count": int64(3),
+	})
+	v, err := cbor.Decode(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := Map(v.(map[string]any))
+	if s, err := m.GetString("embed.images.0.alt"); err != nil || s != "cat" {
+		t.Fatalf("unexpected string %q, %v", s, err)
+	}
+	if n, err := m.GetInt("count"); err != nil || n != 3 {
+		t.Fatalf("unexpected integer %d, %v", n, err)
+	}
+	if l, err := m.GetLink("embed.images.0.image"); err != nil || l.String() != c.String() {
+		t.Fatalf("unexpected link %v, %v", l, err)
+	}
+	if a, err := m.GetArray("embed.images"); err != nil || len(a) != 1 {
+		t.Fatalf("unexpected array %v, %v", a, err)
+	}
+	if o, err := m.GetMap("embed.images.0"); err != nil || o["alt"] != "cat" {
+		t.Fatalf("unexpected object %v, %v", o, err)
+	}
+
+	for _, path := range []string{"missing", "embed.images.1", "embed.missing.alt"} {
+		if _, err := m.Get(path); !errors.Is(err, ErrNotFound) {
+			t.Errorf("%s: expected ErrNotFound, got %v", path, err)
+		}
+	}
+	if _, err := m.GetString("count"); err == nil || errors.Is(err, ErrNotFound) {
+		t.Errorf("expected a type error, got %v", err)
+	}
+	if _, err := m.Get("text.x"); err == nil {
+		t.Error("expected an error indexing a string")
+	}
+	if _, err := m.Get("embed.images.x"); err == nil {
+		t.Error("expected an error for an invalid index")
+	}
+}
@@ -0,0 +1,121 @@
+package data
+
+import (
+	"bytes"
+	"math"
+	"reflect"
+
+	"github.com/notjuliet/grove/cid"
+)
+
+// Reports whether a and b are the same value of the data model, that is, whether they encode to the same CBOR.
+// Integers are compared by value whatever their Go type, so that int64(1) from lexicon JSON equals uint64(1)
+// from CBOR, and []byte equals Bytes. Values which are not of the data model are compared with
+// reflect.DeepEqual.
+func Equal(a, b any) bool {
+	if x, ok := toInt(a); ok {
+		y, ok := toInt(b)
+		return ok && x == y
+	}
+	if x, ok := toBytes(a); ok {
+		y, ok := toBytes(b)
+		return ok && bytes.Equal(x, y)
+	}
+	switch a := a.(type) {
+	case nil, bool, string:
+		return a == b
+	case cid.CidLink:
+		b, ok := b.(cid.CidLink)
+		return ok && bytes.Equal(a.Bytes, b.Bytes)
+	case map[string]any:
+		b, ok := b.(map[string]any)
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for k, e := range a {
+			f, ok := b[k]
+			if !ok || !Equal(e, f) {
+				return false
+			}
+		}
+		return true
+	case []any:
+		b, ok := b.([]any)
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for i := range a {
+			if !Equal(a[i], b[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// integer with its sign, as int64 and uint64 overlap only on non-negative values
+type integer struct {
+	neg bool
+	abs uint64
+}
+
+func toInt(v any) (integer, bool) {
+	switch v := v.(type) {
+	case int:
+		return toInt(int64(v))
+	case int64:
+		if v < 0 {
+			if v == math.MinInt64 {
+				return integer{neg: true, abs: 1 << 63}, true
+			}
+			return integer{neg: true, abs: uint64(-v)}, true
+		}
+		return integer{abs: uint64(v)}, true
+	case uint64:
+		return integer{abs: v}, true
+	}
+	return integer{}, false
+}
+
+func toBytes(v any) ([]byte, bool) {
+	switch v := v.(type) {
+	case []byte:
+		return v, true
+	case Bytes:
+		return v, true
+	}
+	return nil, false
+}
+
+// Returns a deep copy of a value of the data model, sharing no maps, slices or bytes with v, so that either may
+// be modified without affecting the other. Values which are not of the data model are copied shallowly.
+func Clone(v any) any {
+	switch v := v.(type) {
+	case []byte:
+		return bytes.Clone(v)
+	case Bytes:
+		return Bytes(bytes.Clone(v))
+	case cid.CidLink:
+		return cid.CidLink{Bytes: bytes.Clone(v.Bytes)}
+	case map[string]any:
+		if v == nil {
+			return v
+		}
+		m := make(map[string]any, len(v))
+		for k, e := range v {
+			m[k] = Clone(e)
+		}
+		return m
+	case []any:
+		if v == nil {
+			return v
+		}
+		s := make([]any, len(v))
+		for i, e := range v {
+			s[i] = Clone(e)
+		}
+		return s
+	}
+	return v
+}
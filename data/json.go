@@ -0,0 +1,157 @@
+// Package data converts between the atproto data model, as decoded by the cbor package, and lexicon JSON, its
+// JSON representation where links are {"$link": cid} objects and bytes are {"$bytes": base64} objects.
+//
+// In the data model, objects are map[string]any, arrays are []any, integers are int64 or uint64, links are
+// cid.CidLink and bytes are []byte, so that a value received as JSON encodes to canonical CBOR, and back.
+package data
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+
+	"github.com/notjuliet/grove/cid"
+)
+
+// Converts a value of the data model to its lexicon JSON form, made of maps, slices, strings, numbers, booleans
+// and nil, with {"$link": cid} objects for links and {"$bytes": base64} objects for bytes. Values which are not
+// of the data model are returned unchanged.
+func ToJSON(v any) any {
+	switch v := v.(type) {
+	case []byte:
+		return map[string]any{"$bytes": base64.RawStdEncoding.EncodeToString(v)}
+	case Bytes:
+		return ToJSON([]byte(v))
+	case cid.CidLink:
+		return map[string]any{"$link": v.String()}
+	case map[string]any:
+		m := make(map[string]any, len(v))
+		for k, e := range v {
+			m[k] = ToJSON(e)
+		}
+		return m
+	case []any:
+		s := make([]any, len(v))
+		for i, e := range v {
+			s[i] = ToJSON(e)
+		}
+		return s
+	}
+	return v
+}
+
+// Converts a value decoded from lexicon JSON by encoding/json, with float64 or json.Number numbers, to the data
+// model. {"$link": cid} objects become cid.CidLink and {"$bytes": base64} objects become []byte. Fails on
+// numbers which are not integers, as the data model has no floats, on malformed links, bytes and blobs, and on
+// $type properties which are not non-empty strings. v is not modified.
+func FromJSON(v any) (any, error) {
+	switch v := v.(type) {
+	case nil, bool, string, int64, uint64:
+		return v, nil
+	case int:
+		return int64(v), nil
+	case float64:
+		return fromFloat(v)
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return n, nil
+		}
+		f, err := v.Float64()
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %s", v)
+		}
+		return fromFloat(f)
+	case map[string]any:
+		return fromJSONObject(v)
+	case []any:
+		s := make([]any, len(v))
+		for i, e := range v {
+			e, err := FromJSON(e)
+			if err != nil {
+				return nil, fmt.Errorf("[%d]: %w", i, err)
+			}
+			s[i] = e
+		}
+		return s, nil
+	}
+	return nil, fmt.Errorf("unexpected JSON value of type %T", v)
+}
+
+// accepts floats with an integer value, such as 123.0, which JSON encoders may write
+func fromFloat(f float64) (any, error) {
+	if f != math.Trunc(f) || math.Abs(f) > 1<<53 {
+		return nil, fmt.Errorf("number %v is not an integer", f)
+	}
+	return int64(f), nil
+}
+
+func fromJSONObject(v map[string]any) (any, error) {
+	if l, ok := v["$link"]; ok {
+		s, ok := l.(string)
+		if !ok || len(v) != 1 {
+			return nil, errors.New("$link must be the only property, with a string value")
+		}
+		c, err := cid.Parse(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid $link: %w", err)
+		}
+		return c.Link(), nil
+	}
+	if b, ok := v["$bytes"]; ok {
+		s, ok := b.(string)
+		if !ok || len(v) != 1 {
+			return nil, errors.New("$bytes must be the only property, with a string value")
+		}
+		b, err := base64.RawStdEncoding.DecodeString(strings.TrimRight(s, "="))
+		if err != nil {
+			return nil, fmt.Errorf("invalid $bytes: %w", err)
+		}
+		return b, nil
+	}
+	m := make(map[string]any, len(v))
+	for k, e := range v {
+		e, err := FromJSON(e)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", k, err)
+		}
+		m[k] = e
+	}
+	if typ, ok := m["$type"]; ok {
+		if s, _ := typ.(string); s == "" {
+			return nil, errors.New("$type must be a non-empty string")
+		}
+	}
+	if m["$type"] == "blob" {
+		_, link := m["ref"].(cid.CidLink)
+		_, mimeType := m["mimeType"].(string)
+		_, size := m["size"].(int64)
+		if !link || !mimeType || !size {
+			return nil, errors.New("blob must have a ref link, a string mimeType and an integer size")
+		}
+	}
+	return m, nil
+}
+
+// Marshals a value of the data model to lexicon JSON.
+func MarshalJSON(v any) ([]byte, error) {
+	return json.Marshal(ToJSON(v))
+}
+
+// Parses lexicon JSON into the data model.
+func UnmarshalJSON(b []byte) (any, error) {
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+	var v any
+	if err := d.Decode(&v); err != nil {
+		return nil, err
+	}
+	if _, err := d.Token(); err != io.EOF {
+		return nil, errors.New("unexpected data after JSON value")
+	}
+	return FromJSON(v)
+}
@@ -0,0 +1,153 @@
+package data
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/notjuliet/grove/cid"
+)
+
+// The field is missing from the map.
+var ErrNotFound = errors.New("field not found")
+
+// Object of the data model with accessors reading fields by path, such as "embed.images.0.alt", where each
+// element is a map key or an array index. Keys containing dots cannot be addressed by path.
+type Map map[string]any
+
+// Returns the value at path. Fails with ErrNotFound if a map has no such key or an array no such index.
+func (m Map) Get(path string) (any, error) {
+	var v any = map[string]any(m)
+	for i, key := range strings.Split(path, ".") {
+		switch c := v.(type) {
+		case map[string]any:
+			e, ok := c[key]
+			if !ok {
+				return nil, fmt.Errorf("%s: %w", path, ErrNotFound)
+			}
+			v = e
+		case Map:
+			e, ok := c[key]
+			if !ok {
+				return nil, fmt.Errorf("%s: %w", path, ErrNotFound)
+			}
+			v = e
+		case []any:
+			n, err := strconv.Atoi(key)
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("%s: invalid array index %q", path, key)
+			}
+			if n >= len(c) {
+				return nil, fmt.Errorf("%s: %w", path, ErrNotFound)
+			}
+			v = c[n]
+		default:
+			return nil, fmt.Errorf("%s: %s is a %s, not an object or array", path,
+				strings.Join(strings.Split(path, ".")[:i], "."), kind(v))
+		}
+	}
+	return v, nil
+}
+
+// Returns the string at path.
+func (m Map) GetString(path string) (string, error) {
+	v, err := m.Get(path)
+	if err != nil {
+		return "", err
+	}
+	s, ok := v.(string)
+	if !ok {
+		return "", fmt.Errorf("%s: expected a string, got a %s", path, kind(v))
+	}
+	return s, nil
+}
+
+// Returns the integer at path, failing on integers beyond the range of int64.
+func (m Map) GetInt(path string) (int64, error) {
+	v, err := m.Get(path)
+	if err != nil {
+		return 0, err
+	}
+	switch n := v.(type) {
+	case int64:
+		return n, nil
+	case int:
+		return int64(n), nil
+	case uint64:
+		if n > math.MaxInt64 {
+			return 0, fmt.Errorf("%s: integer %d overflows int64", path, n)
+		}
+		return int64(n), nil
+	}
+	return 0, fmt.Errorf("%s: expected an integer, got a %s", path, kind(v))
+}
+
+// Returns the link at path.
+func (m Map) GetLink(path string) (cid.Cid, error) {
+	v, err := m.Get(path)
+	if err != nil {
+		return cid.Cid{}, err
+	}
+	l, ok := v.(cid.CidLink)
+	if !ok {
+		return cid.Cid{}, fmt.Errorf("%s: expected a link, got a %s", path, kind(v))
+	}
+	c, err := l.Cid()
+	if err != nil {
+		return cid.Cid{}, fmt.Errorf("%s: %w", path, err)
+	}
+	return c, nil
+}
+
+// Returns the array at path.
+func (m Map) GetArray(path string) ([]any, error) {
+	v, err := m.Get(path)
+	if err != nil {
+		return nil, err
+	}
+	s, ok := v.([]any)
+	if !ok {
+		return nil, fmt.Errorf("%s: expected an array, got a %s", path, kind(v))
+	}
+	return s, nil
+}
+
+// Returns the object at path.
+func (m Map) GetMap(path string) (Map, error) {
+	v, err := m.Get(path)
+	if err != nil {
+		return nil, err
+	}
+	switch o := v.(type) {
+	case map[string]any:
+		return o, nil
+	case Map:
+		return o, nil
+	}
+	return nil, fmt.Errorf("%s: expected an object, got a %s", path, kind(v))
+}
+
+// names the data model kind of v in errors
+func kind(v any) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case int, int64, uint64:
+		return "integer"
+	case []byte, Bytes:
+		return "bytes"
+	case cid.CidLink:
+		return "link"
+	case map[string]any, Map:
+		return "object"
+	case []any:
+		return "array"
+	}
+	return fmt.Sprintf("%T", v)
+}
@@ -0,0 +1,64 @@
+// Package errdefs defines the categories of grove errors, so that services can map failures to HTTP statuses
+// and metric labels without matching messages. Errors of the cbor, cid, tid, car, limits, budget and lexicon
+// packages match one of the categories with errors.Is, while keeping their own types and sentinels for
+// errors.As and errors.Is.
+package errdefs
+
+import "errors"
+
+var (
+	// The input does not decode, such as truncated or non-canonical DAG-CBOR, a CAR file cut short, or a CID
+	// or TID with a bad encoding. Usually mapped to 400 Bad Request.
+	ErrMalformed = errors.New("malformed input")
+	// The input decodes, but does not satisfy a schema or protocol rule, such as lexicon validation or a value
+	// which cannot be encoded. Usually mapped to 400 Bad Request.
+	ErrInvalid = errors.New("invalid input")
+	// The input exceeds a size limit or memory budget. Usually mapped to 413 Content Too Large.
+	ErrLimit = errors.New("resource limit exceeded")
+	// Reading or writing the input failed, independently of its content. Usually mapped to 502 Bad Gateway or
+	// 500 Internal Server Error.
+	ErrIO = errors.New("i/o failure")
+)
+
+// error matching a category, keeping the message of the error it wraps
+type kindError struct {
+	kind error
+	err  error
+}
+
+func (e *kindError) Error() string   { return e.err.Error() }
+func (e *kindError) Unwrap() []error { return []error{e.err, e.kind} }
+
+// Returns an error with message msg matching the category kind, for packages to declare sentinels of a
+// category.
+func New(kind error, msg string) error {
+	return &kindError{kind: kind, err: errors.New(msg)}
+}
+
+// Returns err, also matching the category kind, or nil if err is nil. Errors already in a category are returned
+// unchanged.
+func Wrap(kind, err error) error {
+	if err == nil || Category(err) != "" {
+		return err
+	}
+	return &kindError{kind: kind, err: err}
+}
+
+// Returns the name of the category of err, "malformed", "invalid", "limit" or "io", for use as a metric label,
+// or "" if it is in none. Limits take precedence, as a decoder stopped by a limit may report the input as
+// malformed too.
+func Category(err error) string {
+	switch {
+	case err == nil:
+		return ""
+	case errors.Is(err, ErrLimit):
+		return "limit"
+	case errors.Is(err, ErrIO):
+		return "io"
+	case errors.Is(err, ErrMalformed):
+		return "malformed"
+	case errors.Is(err, ErrInvalid):
+		return "invalid"
+	}
+	return ""
+}
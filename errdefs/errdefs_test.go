@@ -0,0 +1,39 @@
+package errdefs
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+)
+
+func TestCategory(t *testing.T) {
+	sentinel := New(ErrMalformed, "bad thing")
+	wrapped := fmt.Errorf("reading: %w", sentinel)
+	if !errors.Is(wrapped, sentinel) || !errors.Is(wrapped, ErrMalformed) || errors.Is(wrapped, ErrInvalid) {
+		t.Fatal("unexpected matches")
+	}
+	if wrapped.Error() != "reading: bad thing" {
+		t.Fatalf("unexpected message %q", wrapped)
+	}
+
+	eof := Wrap(ErrIO, io.ErrUnexpectedEOF)
+	if !errors.Is(eof, io.ErrUnexpectedEOF) || Category(eof) != "io" || eof.Error() != io.ErrUnexpectedEOF.Error() {
+		t.Fatalf("unexpected wrapped error %v", eof)
+	}
+	if Wrap(ErrMalformed, eof) != eof || Wrap(ErrIO, nil) != nil {
+		t.Fatal("expected categorized and nil errors to be returned unchanged")
+	}
+
+	for err, category := range map[error]string{
+		nil:                             "",
+		io.EOF:                          "",
+		Wrap(ErrInvalid, io.EOF):        "invalid",
+		New(ErrLimit, "too big"):        "limit",
+		errors.Join(sentinel, ErrLimit): "limit",
+	} {
+		if got := Category(err); got != category {
+			t.Errorf("expected category %q for %v, got %q", category, err, got)
+		}
+	}
+}
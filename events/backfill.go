@@ -0,0 +1,204 @@
+package events
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+
+	"github.com/notjuliet/grove/blockstore"
+	"github.com/notjuliet/grove/identity"
+	"github.com/notjuliet/grove/limits"
+	"github.com/notjuliet/grove/repo"
+	"github.com/notjuliet/grove/xrpc"
+)
+
+// NSID of the method exporting a whole repository.
+const GetRepo = xrpc.SyncGetRepo
+
+// Backfills repositories from their PDS while consuming the live stream, handing each repository over to the
+// live stream once its export is processed, without gaps or duplicates.
+//
+// Live frames about a repository are buffered while it is being backfilled. Once its export is processed, the
+// buffered frames are replayed, skipping the #commit and #sync messages whose rev is not newer than the
+// export, and the repository switches to live consumption. Frames about other repositories are handled as
+// they arrive.
+//
+// Exports are checked like the events of a Validator: the commit must be signed by the current key of the
+// account, resolved with Directory.
+type Backfill struct {
+	// Live stream, such as a Client, which must not be used elsewhere while the backfill runs.
+	Source Source
+	// Resolves the signing key of each repository, and its PDS for the default Fetch.
+	Directory identity.Directory
+	// Client for getRepo requests of the default Fetch. Nil uses http.DefaultClient.
+	HTTPClient *http.Client
+	// Fetches the export of a repository, if set, instead of calling getRepo on its PDS.
+	Fetch func(ctx context.Context, did string) (io.ReadCloser, error)
+	// Number of repositories fetched concurrently. Zero means 1.
+	Workers int
+	// Maximum number of live frames buffered for a repository while it is being backfilled, beyond which Run
+	// fails with an error matching limits.ErrExceeded. Zero means DefaultMaxBuffered.
+	MaxBuffered int
+	// Called with each backfilled repository, loaded in a memory blockstore.
+	HandleRepo func(ctx context.Context, r *repo.Repo) error
+	// Called with each live frame. Frames about a repository are handled in order, but frames about different
+	// repositories may be handled concurrently.
+	HandleFrame func(ctx context.Context, f Frame) error
+
+	mtx sync.Mutex
+	// live frames buffered for the repositories being backfilled
+	pending map[string][]Frame
+}
+
+// Default limit on the frames buffered for a repository, see Backfill.MaxBuffered.
+const DefaultMaxBuffered = 10000
+
+// Backfills the repositories of dids while consuming the live stream from cursor, then keeps consuming it.
+// Fetching starts once the stream delivered its first frame, so that no commit made after an export is
+// missed. Returns like the Run method of the source, or with the first error of a backfill.
+//
+// Buffered frames are lost if Run returns before their repository is handed over, so consumers persisting the
+// cursor must also persist which repositories were backfilled, and backfill the others again.
+func (b *Backfill) Run(ctx context.Context, cursor *int64, dids []string) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	queue := make(chan string, len(dids))
+	b.mtx.Lock()
+	b.pending = make(map[string][]Frame, len(dids))
+	for _, did := range dids {
+		if _, ok := b.pending[did]; !ok {
+			b.pending[did] = nil
+			queue <- did
+		}
+	}
+	b.mtx.Unlock()
+	close(queue)
+
+	var wg sync.WaitGroup
+	var startOnce, failOnce sync.Once
+	var backfillErr error
+	start := func() {
+		for range max(b.Workers, 1) {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for did := range queue {
+					if err := b.backfill(ctx, did); err != nil {
+						if ctx.Err() == nil {
+							failOnce.Do(func() { backfillErr = fmt.Errorf("backfilling %s: %w", did, err) })
+							cancel()
+						}
+						return
+					}
+				}
+			}()
+		}
+	}
+
+	maxBuffered := cmp.Or(b.MaxBuffered, DefaultMaxBuffered)
+	err := b.Source.Run(ctx, cursor, func(f Frame) error {
+		startOnce.Do(start)
+		account := frameAccount(f)
+		b.mtx.Lock()
+		if buf, ok := b.pending[account]; ok {
+			defer b.mtx.Unlock()
+			if err := limits.Check(len(buf)+1, maxBuffered, "buffered frames"); err != nil {
+				return fmt.Errorf("backfilling %s: %w", account, err)
+			}
+			b.pending[account] = append(buf, f)
+			return nil
+		}
+		b.mtx.Unlock()
+		return b.HandleFrame(ctx, f)
+	})
+	cancel()
+	wg.Wait()
+	if backfillErr != nil {
+		// the failed backfill interrupted the stream
+		return backfillErr
+	}
+	return err
+}
+
+// Returns the number of repositories not handed over to the live stream yet.
+func (b *Backfill) Pending() int {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	return len(b.pending)
+}
+
+// processes the export of a repository, then replays its buffered frames until none is left
+func (b *Backfill) backfill(ctx context.Context, did string) error {
+	if b.Directory == nil {
+		return errors.New("no directory to resolve the signing key")
+	}
+	ident, err := b.Directory.LookupDID(ctx, did)
+	if err != nil {
+		return err
+	}
+	pub, err := ident.PublicKey()
+	if err != nil {
+		return fmt.Errorf("resolving the signing key: %w", err)
+	}
+	var body io.ReadCloser
+	if b.Fetch != nil {
+		body, err = b.Fetch(ctx, did)
+	} else {
+		body, err = b.getRepo(ctx, ident)
+	}
+	if err != nil {
+		return err
+	}
+	r, err := repo.LoadFromCAR(ctx, body, blockstore.NewMemoryBlockstore())
+	body.Close()
+	if err != nil {
+		return err
+	}
+	_, commit, _ := r.Head()
+	if r.DID() != did {
+		return fmt.Errorf("export is of repository %s", r.DID())
+	}
+	if err := repo.VerifyCommitSignature(commit, pub); err != nil {
+		return fmt.Errorf("invalid commit signature: %w", err)
+	}
+	if err := b.HandleRepo(ctx, r); err != nil {
+		return err
+	}
+
+	for {
+		b.mtx.Lock()
+		buf := b.pending[did]
+		if len(buf) == 0 {
+			delete(b.pending, did)
+			b.mtx.Unlock()
+			return nil
+		}
+		b.pending[did] = buf[:0:0]
+		b.mtx.Unlock()
+
+		for _, f := range buf {
+			if rev, ok := f.Body["rev"].(string); ok && (f.Header.Type == TypeCommit || f.Header.Type == TypeSync) &&
+				rev <= commit.Rev {
+				continue
+			}
+			if err := b.HandleFrame(ctx, f); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// fetches the export of a repository from its PDS
+func (b *Backfill) getRepo(ctx context.Context, ident *identity.Identity) (io.ReadCloser, error) {
+	pds := ident.PDSEndpoint()
+	if pds == "" {
+		return nil, errors.New("account has no PDS")
+	}
+	c := &xrpc.Client{Host: pds, HTTPClient: b.HTTPClient}
+	return c.GetRepo(ctx, ident.DID, "")
+}
@@ -0,0 +1,274 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/notjuliet/grove/internal/websocket"
+	"github.com/notjuliet/grove/metrics"
+)
+
+// NSID of the repository event stream of PDSes and relays.
+const SubscribeRepos = "com.atproto.sync.subscribeRepos"
+
+// Consumer of an event stream, such as the repository firehose of a relay. It reconnects when the connection
+// drops, resuming after the last sequence number received.
+type Client struct {
+	// Base URL of the service, such as "wss://bsky.network". HTTP URLs are accepted too.
+	Host string
+	// NSID of the subscription, SubscribeRepos if empty.
+	Method string
+	// Client for the WebSocket handshake, which must not use HTTP/2. Nil uses a default client.
+	HTTPClient *http.Client
+	// Whether to negotiate the permessage-deflate WebSocket extension, letting the server compress messages
+	// when it supports it. This trades CPU for bandwidth, which pays off for high-volume consumers.
+	Compress bool
+	// Delay before reconnecting, doubled after every failed attempt up to MaxBackoff, and reset once messages
+	// flow again. Zero means 1 second and 1 minute.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// Called with the error which ended each connection before reconnecting, if set.
+	OnDisconnect func(err error)
+	// Called before reading each message, if set. Blocking in it pauses reading from the socket, for instance
+	// while downstream queues are full, leaving unread events buffered by the server and the network rather
+	// than in memory. Servers drop connections paused for too long, after which Run resumes from the cursor.
+	// An error ends Run.
+	Pause func(ctx context.Context) error
+	// Limits the rate at which messages are delivered to the handler, if set.
+	Limiter Limiter
+	// Store of the cursor, if set: Run resumes from its saved cursor when called with a nil one, and saves the
+	// sequence number of each message once handled, flushing the store before returning if it buffers saves.
+	Cursors CursorStore
+	// Called with each discontinuity in the sequence numbers received, if set, before the message after it is
+	// handled. Gaps are also counted in Stats.
+	OnGap func(Gap)
+	// Logger for connections, reconnections, gaps and undecodable frames, if set.
+	Logger *slog.Logger
+	// Recorder of the stream measurements, if set: events.messages and events.bytes counting the messages
+	// received, events.lag.seconds, the delay between the time of each event and its reception, and
+	// events.handle.seconds, the duration of each call to the handler. Gaps are counted as events.reconnects,
+	// events.gaps, events.missing and events.regressions, like in Stats.
+	Metrics metrics.Recorder
+
+	counters streamCounters
+}
+
+// Returns the sequence number of a message frame, if its body has one.
+func (f *Frame) Seq() (int64, bool) {
+	seq, ok := f.Body["seq"].(uint64)
+	return int64(seq), ok
+}
+
+// Consumes the stream, calling handle for each message frame in order, starting after cursor, or with live
+// events if cursor is nil.
+//
+// Run only returns when ctx is done, handle returns an error, which is returned as is, or the server fails in
+// a way retrying cannot fix: a FutureCursor error frame, returned as a *StreamError, or a handshake rejected
+// with a client error other than 429.
+func (c *Client) Run(ctx context.Context, cursor *int64, handle func(Frame) error) (err error) {
+	if c.Cursors != nil {
+		if cursor == nil {
+			if cursor, err = c.Cursors.Load(ctx); err != nil {
+				return fmt.Errorf("loading cursor: %w", err)
+			}
+		}
+		if flusher, ok := c.Cursors.(CursorFlusher); ok {
+			defer func() {
+				if flushErr := flusher.Flush(context.WithoutCancel(ctx)); flushErr != nil && err == nil {
+					err = fmt.Errorf("saving cursor: %w", flushErr)
+				}
+			}()
+		}
+	}
+	minBackoff := c.MinBackoff
+	if minBackoff <= 0 {
+		minBackoff = time.Second
+	}
+	maxBackoff := c.MaxBackoff
+	if maxBackoff <= 0 {
+		maxBackoff = time.Minute
+	}
+
+	tracker := &seqTracker{c: c, last: cursor, reconnected: cursor != nil}
+	backoff := minBackoff
+	for {
+		received, err := c.connect(ctx, cursor, func(f Frame) error {
+			tracker.observe(&f)
+			seq, ok := f.Seq()
+			if ok {
+				cursor = &seq
+			}
+			if err := handle(f); err != nil {
+				return err
+			}
+			if ok && c.Cursors != nil {
+				if err := c.Cursors.Save(ctx, seq); err != nil {
+					return fmt.Errorf("saving cursor: %w", err)
+				}
+			}
+			return nil
+		})
+		var handlerErr *handlerError
+		if errors.As(err, &handlerErr) {
+			return handlerErr.err
+		}
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		var streamErr *StreamError
+		if errors.As(err, &streamErr) && streamErr.Name == "FutureCursor" {
+			c.logger().Error("event stream cursor is in the future", "host", c.Host, "cursor", logCursor(cursor),
+				"error", err)
+			return err
+		}
+		var handshakeErr *websocket.HandshakeError
+		if errors.As(err, &handshakeErr) && handshakeErr.StatusCode >= 400 && handshakeErr.StatusCode < 500 &&
+			handshakeErr.StatusCode != http.StatusTooManyRequests {
+			c.logger().Error("event stream handshake rejected", "host", c.Host, "status", handshakeErr.StatusCode)
+			return err
+		}
+		if c.OnDisconnect != nil {
+			c.OnDisconnect(err)
+		}
+
+		if received {
+			backoff = minBackoff
+		}
+		c.logger().Warn("event stream disconnected", "host", c.Host, "cursor", logCursor(cursor), "error", err,
+			"retry_in", backoff)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, maxBackoff)
+		tracker.reconnect()
+	}
+}
+
+// wraps errors of the frame handler, which end Run
+type handlerError struct{ err error }
+
+func (e *handlerError) Error() string { return e.err.Error() }
+
+var discardLogger = slog.New(slog.DiscardHandler)
+
+func (c *Client) logger() *slog.Logger {
+	if c.Logger != nil {
+		return c.Logger
+	}
+	return discardLogger
+}
+
+func (c *Client) recorder() metrics.Recorder {
+	if c.Metrics != nil {
+		return c.Metrics
+	}
+	return metrics.Discard
+}
+
+// formats an optional cursor for logging
+func logCursor(cursor *int64) any {
+	if cursor == nil {
+		return nil
+	}
+	return *cursor
+}
+
+// runs a single connection until it fails, reporting whether any message was received
+func (c *Client) connect(ctx context.Context, cursor *int64, handle func(Frame) error) (bool, error) {
+	method := c.Method
+	if method == "" {
+		method = SubscribeRepos
+	}
+	u := strings.TrimSuffix(c.Host, "/") + "/xrpc/" + method
+	if cursor != nil {
+		u += "?" + url.Values{"cursor": {strconv.FormatInt(*cursor, 10)}}.Encode()
+	}
+	c.logger().Debug("connecting to event stream", "url", u)
+	conn, err := websocket.Dial(ctx, c.HTTPClient, u, &websocket.DialOptions{Compress: c.Compress})
+	if err != nil {
+		return false, err
+	}
+	defer conn.CloseNow()
+	stop := context.AfterFunc(ctx, func() { conn.CloseNow() })
+	defer stop()
+	c.logger().Info("connected to event stream", "url", u)
+
+	received := false
+	last := cursor
+	for {
+		if c.Pause != nil {
+			if err := c.Pause(ctx); err != nil {
+				return received, &handlerError{err}
+			}
+		}
+		msgType, msg, err := conn.ReadMessage()
+		if err != nil {
+			return received, err
+		}
+		if msgType != websocket.BinaryMessage {
+			conn.Close(websocket.CloseProtocolError, "expected binary message")
+			return received, errors.New("event stream sent a text message")
+		}
+		f, err := DecodeFrame(msg)
+		if err != nil {
+			var decodeErr *DecodeError
+			if errors.As(err, &decodeErr) {
+				c.logger().Warn("undecodable event stream frame", "host", c.Host, "after_seq", logCursor(last),
+					"offset", decodeErr.Offset, "size", len(msg), "error", decodeErr.Err)
+			}
+			return received, err
+		}
+		if seq, ok := f.Seq(); ok {
+			last = &seq
+		}
+		received = true
+		rec := c.recorder()
+		rec.Count("events.messages", 1)
+		rec.Count("events.bytes", int64(len(msg)))
+		if s, ok := f.Body["time"].(string); ok {
+			if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+				rec.Gauge("events.lag.seconds", time.Since(t).Seconds())
+			}
+		}
+		if c.Limiter != nil {
+			if err := c.Limiter.Wait(ctx); err != nil {
+				return received, err
+			}
+		}
+		start := time.Now()
+		err = handle(f)
+		rec.Observe("events.handle.seconds", time.Since(start).Seconds())
+		if err != nil {
+			conn.Close(websocket.CloseNormal, "")
+			return received, &handlerError{err}
+		}
+	}
+}
+
+// Like Run, but delivers the message frames through a channel with the given buffer size, and returns a
+// function reporting the error which ended the stream, to be called once the channel is closed.
+func (c *Client) Channel(ctx context.Context, cursor *int64, size int) (<-chan Frame, func() error) {
+	ch := make(chan Frame, size)
+	var err error
+	go func() {
+		defer close(ch)
+		err = c.Run(ctx, cursor, func(f Frame) error {
+			select {
+			case ch <- f:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		})
+	}()
+	return ch, func() error { return err }
+}
@@ -0,0 +1,222 @@
+package events
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/notjuliet/grove/blockstore"
+	"github.com/notjuliet/grove/internal/sqlname"
+)
+
+// Persistent cursor of a stream consumer, so that it resumes where it left off after a restart.
+// Implementations must be safe for concurrent use.
+type CursorStore interface {
+	// Returns the saved cursor, or nil if none was saved yet.
+	Load(ctx context.Context) (*int64, error)
+	// Saves the cursor.
+	Save(ctx context.Context, cursor int64) error
+}
+
+// Implemented by cursor stores buffering saves, such as ThrottledCursorStore, to write the last saved cursor
+// out. Client.Run calls it before returning.
+type CursorFlusher interface {
+	Flush(ctx context.Context) error
+}
+
+// CursorStore persisting saves to an underlying store at most once per interval, as writing every sequence
+// number of a busy stream is wasteful. Cursors saved since the last write are lost on a crash, so consumers
+// must tolerate seeing some events again.
+type ThrottledCursorStore struct {
+	store    CursorStore
+	interval time.Duration
+
+	mtx     sync.Mutex
+	pending *int64
+	last    time.Time
+}
+
+// Wraps store to write at most once per interval.
+func NewThrottledCursorStore(store CursorStore, interval time.Duration) *ThrottledCursorStore {
+	return &ThrottledCursorStore{store: store, interval: interval}
+}
+
+// Returns the last saved cursor, even if it was not written yet.
+func (t *ThrottledCursorStore) Load(ctx context.Context) (*int64, error) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	if t.pending != nil {
+		cursor := *t.pending
+		return &cursor, nil
+	}
+	return t.store.Load(ctx)
+}
+
+// Records the cursor, writing it if the interval elapsed since the last write.
+func (t *ThrottledCursorStore) Save(ctx context.Context, cursor int64) error {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	t.pending = &cursor
+	if time.Since(t.last) < t.interval {
+		return nil
+	}
+	return t.flush(ctx)
+}
+
+// Writes the last saved cursor if it was not written yet.
+func (t *ThrottledCursorStore) Flush(ctx context.Context) error {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	return t.flush(ctx)
+}
+
+// must be called with the lock held
+func (t *ThrottledCursorStore) flush(ctx context.Context) error {
+	if t.pending == nil {
+		return nil
+	}
+	if err := t.store.Save(ctx, *t.pending); err != nil {
+		return err
+	}
+	t.pending, t.last = nil, time.Now()
+	return nil
+}
+
+// CursorStore keeping the cursor as decimal text in a file, replaced atomically and durably on every save.
+type FileCursorStore struct {
+	path string
+	mtx  sync.Mutex
+}
+
+// Creates a store saving to the file at path. The file is created on the first save.
+func NewFileCursorStore(path string) *FileCursorStore {
+	return &FileCursorStore{path: path}
+}
+
+func (f *FileCursorStore) Load(ctx context.Context) (*int64, error) {
+	b, err := os.ReadFile(f.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	cursor, err := strconv.ParseInt(strings.TrimSpace(string(b)), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor file: %w", err)
+	}
+	return &cursor, nil
+}
+
+// Writes the cursor to a temporary file, syncs it and renames it over the cursor file, then syncs the directory so
+// that the rename survives a crash.
+func (f *FileCursorStore) Save(ctx context.Context, cursor int64) error {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	dir := filepath.Dir(f.path)
+	tmp, err := os.CreateTemp(dir, ".tmp-cursor-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.WriteString(strconv.FormatInt(cursor, 10) + "\n")
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), f.path); err != nil {
+		return err
+	}
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// CursorStore backed by a database/sql table holding the cursors of several consumers by name, which can
+// live in the same database as the data they index.
+type SQLCursorStore struct {
+	name string
+
+	load *sql.Stmt
+	save *sql.Stmt
+}
+
+// Creates the cursor table if it does not exist and prepares the store's statements for the cursor of the
+// given consumer name. An empty table name defaults to "cursors".
+func OpenSQLCursorStore(ctx context.Context, db *sql.DB, dialect blockstore.SQLDialect, table, name string) (*SQLCursorStore, error) {
+	if table == "" {
+		table = "cursors"
+	}
+	if err := sqlname.CheckTable(table); err != nil {
+		return nil, err
+	}
+	s := &SQLCursorStore{name: name}
+
+	schema := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (name TEXT PRIMARY KEY, seq BIGINT NOT NULL)", table)
+	if _, err := db.ExecContext(ctx, schema); err != nil {
+		return nil, fmt.Errorf("creating cursor table: %w", err)
+	}
+
+	p := dialect.Placeholder
+	queries := []struct {
+		stmt  **sql.Stmt
+		query string
+	}{
+		{&s.load, fmt.Sprintf("SELECT seq FROM %s WHERE name = %s", table, p(1))},
+		{&s.save, fmt.Sprintf("INSERT INTO %s (name, seq) VALUES (%s, %s) "+
+			"ON CONFLICT (name) DO UPDATE SET seq = excluded.seq", table, p(1), p(2))},
+	}
+	for _, q := range queries {
+		stmt, err := db.PrepareContext(ctx, q.query)
+		if err != nil {
+			s.Close()
+			return nil, fmt.Errorf("preparing statement: %w", err)
+		}
+		*q.stmt = stmt
+	}
+	return s, nil
+}
+
+// Closes the prepared statements. The database handle is left open.
+func (s *SQLCursorStore) Close() error {
+	var errs []error
+	for _, stmt := range []*sql.Stmt{s.load, s.save} {
+		if stmt != nil {
+			errs = append(errs, stmt.Close())
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (s *SQLCursorStore) Load(ctx context.Context) (*int64, error) {
+	var cursor int64
+	err := s.load.QueryRowContext(ctx, s.name).Scan(&cursor)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &cursor, nil
+}
+
+func (s *SQLCursorStore) Save(ctx context.Context, cursor int64) error {
+	_, err := s.save.ExecContext(ctx, s.name, cursor)
+	return err
+}
@@ -0,0 +1,1208 @@
+package events
+
+import (
+	"bytes"
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/notjuliet/grove/blockstore"
+	"github.com/notjuliet/grove/cbor"
+	"github.com/notjuliet/grove/cid"
+	"github.com/notjuliet/grove/crypto"
+	"github.com/notjuliet/grove/identity"
+	"github.com/notjuliet/grove/internal/sqltest"
+	"github.com/notjuliet/grove/internal/websocket"
+	"github.com/notjuliet/grove/lexicon"
+	"github.com/notjuliet/grove/limits"
+	"github.com/notjuliet/grove/metrics"
+	"github.com/notjuliet/grove/repo"
+)
+
+func TestFrame(t *testing.T) {
+	b, err := EncodeMessage("#identity", map[string]any{"seq": int64(7), "did": "did:plc:alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := DecodeFrame(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Header.Op != OpMessage || f.Header.Type != "#identity" || f.Body["seq"] != uint64(7) || f.Body["did"] != "did:plc:alice" {
+		t.Fatalf("unexpected frame %+v", f)
+	}
+
+	b, err = EncodeError("FutureCursor", "cursor in the future")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var streamErr *StreamError
+	if _, err := DecodeFrame(b); !errors.As(err, &streamErr) || streamErr.Name != "FutureCursor" || streamErr.Message != "cursor in the future" {
+		t.Fatalf("expected stream error, got %v", err)
+	}
+
+	frame := func(header, body any) []byte {
+		h, err := cbor.Encode(header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if body == nil {
+			return h
+		}
+		b, err := cbor.Encode(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return append(h, b...)
+	}
+	// unknown header fields are ignored
+	if _, err := DecodeFrame(frame(map[string]any{"op": int64(1), "t": "#info", "x": true}, map[string]any{})); err != nil {
+		t.Fatal(err)
+	}
+	invalid := map[string][]byte{
+		"no body":     frame(map[string]any{"op": int64(1), "t": "#info"}, nil),
+		"no type":     frame(map[string]any{"op": int64(1)}, map[string]any{}),
+		"unknown op":  frame(map[string]any{"op": int64(2), "t": "#info"}, map[string]any{}),
+		"list body":   frame(map[string]any{"op": int64(1), "t": "#info"}, []any{}),
+		"list header": frame([]any{}, map[string]any{}),
+		"no name":     frame(map[string]any{"op": int64(-1)}, map[string]any{}),
+		"trailing":    append(frame(map[string]any{"op": int64(1), "t": "#info"}, map[string]any{}), 0),
+	}
+	for name, b := range invalid {
+		if _, err := DecodeFrame(b); err == nil {
+			t.Fatalf("expected error for %s", name)
+		}
+	}
+	trailing := invalid["trailing"]
+	var decodeErr *DecodeError
+	if _, err := DecodeFrame(trailing); !errors.As(err, &decodeErr) || decodeErr.Offset != len(trailing)-1 {
+		t.Fatalf("expected decode error at offset %d, got %v", len(trailing)-1, err)
+	}
+}
+
+func TestClientDiagnostics(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := websocket.Upgrade(w, r)
+		if err != nil {
+			return
+		}
+		defer conn.CloseNow()
+		b, _ := EncodeMessage(TypeIdentity, map[string]any{"seq": int64(7), "time": formatTime(time.Now().Add(-time.Minute))})
+		conn.WriteMessage(websocket.BinaryMessage, b)
+		conn.WriteMessage(websocket.BinaryMessage, append(b, 0))
+		conn.ReadMessage()
+	}))
+	defer srv.Close()
+
+	var logs strings.Builder
+	counts := map[string]int64{}
+	var lag float64
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c := &Client{
+		Host:       srv.URL,
+		MinBackoff: time.Hour,
+		Logger:     slog.New(slog.NewTextHandler(&logs, nil)),
+		Metrics: metrics.Funcs{
+			OnCount: func(name string, delta int64) { counts[name] += delta },
+			OnGauge: func(name string, value float64) { lag = value },
+		},
+		OnDisconnect: func(err error) {
+			cancel()
+		},
+	}
+	c.Run(ctx, nil, func(f Frame) error { return nil })
+	if counts["events.messages"] != 1 || counts["events.bytes"] == 0 || lag < 60 {
+		t.Errorf("unexpected counts %v and lag %f", counts, lag)
+	}
+	for _, want := range []string{
+		"msg=\"connected to event stream\"",
+		"msg=\"undecodable event stream frame\"",
+		"after_seq=7 offset=",
+		"msg=\"event stream disconnected\"",
+	} {
+		if !strings.Contains(logs.String(), want) {
+			t.Errorf("expected %s in logs:\n%s", want, logs.String())
+		}
+	}
+}
+
+func TestCompression(t *testing.T) {
+	text := strings.Repeat("compressible ", 100)
+	compressed := make(chan bool, 2)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := websocket.UpgradeWith(w, r, websocket.UpgradeOptions{Compress: true})
+		if err != nil {
+			return
+		}
+		defer conn.CloseNow()
+		compressed <- conn.Compressed()
+		for seq := range int64(3) {
+			b, _ := EncodeMessage(TypeInfo, map[string]any{"seq": seq + 1, "name": "Test", "message": text})
+			conn.WriteMessage(websocket.BinaryMessage, b)
+		}
+		conn.ReadMessage()
+	}))
+	defer srv.Close()
+
+	for _, compress := range []bool{true, false} {
+		c := &Client{Host: srv.URL, Compress: compress}
+		var messages int
+		err := c.Run(context.Background(), nil, func(f Frame) error {
+			if f.Body["message"] != text {
+				t.Fatalf("unexpected body %v", f.Body)
+			}
+			if messages++; messages == 3 {
+				return io.EOF
+			}
+			return nil
+		})
+		if err != io.EOF {
+			t.Fatal(err)
+		}
+		if <-compressed != compress {
+			t.Fatalf("expected compression to be negotiated: %v", compress)
+		}
+	}
+}
+
+func TestClient(t *testing.T) {
+	var mtx sync.Mutex
+	var cursors []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/xrpc/"+SubscribeRepos {
+			http.NotFound(w, r)
+			return
+		}
+		mtx.Lock()
+		cursors = append(cursors, r.URL.Query().Get("cursor"))
+		attempt := len(cursors)
+		mtx.Unlock()
+		conn, err := websocket.Upgrade(w, r)
+		if err != nil {
+			return
+		}
+		defer conn.CloseNow()
+
+		send := func(b []byte, err error) {
+			if err != nil {
+				t.Error(err)
+			}
+			conn.WriteMessage(websocket.BinaryMessage, b)
+		}
+		switch attempt {
+		case 1:
+			for seq := range int64(3) {
+				send(EncodeMessage("#identity", map[string]any{"seq": seq + 1}))
+			}
+			// dropped without closing handshake
+		case 2:
+			// refused before any message, the cursor must not change
+		case 3:
+			send(EncodeMessage("#identity", map[string]any{"seq": int64(4)}))
+			send(EncodeError("FutureCursor", ""))
+			conn.Close(websocket.CloseNormal, "")
+		default:
+			send(EncodeMessage("#identity", map[string]any{"seq": int64(5)}))
+			for {
+				if _, _, err := conn.ReadMessage(); err != nil {
+					return
+				}
+			}
+		}
+	}))
+	defer srv.Close()
+
+	c := &Client{Host: srv.URL, MinBackoff: time.Millisecond}
+	var seqs []int64
+	err := c.Run(context.Background(), nil, func(f Frame) error {
+		seq, _ := f.Seq()
+		seqs = append(seqs, seq)
+		return nil
+	})
+	var streamErr *StreamError
+	if !errors.As(err, &streamErr) || streamErr.Name != "FutureCursor" {
+		t.Fatalf("expected future cursor error, got %v", err)
+	}
+	if !reflect.DeepEqual(seqs, []int64{1, 2, 3, 4}) || !reflect.DeepEqual(cursors, []string{"", "3", "3"}) {
+		t.Fatalf("unexpected seqs %v and cursors %q", seqs, cursors)
+	}
+
+	cursor := int64(10)
+	ch, chErr := (&Client{Host: srv.URL + "/other", MinBackoff: time.Millisecond}).Channel(context.Background(), &cursor, 0)
+	for range ch {
+		t.Fatal("unexpected frame")
+	}
+	var handshakeErr *websocket.HandshakeError
+	if !errors.As(chErr(), &handshakeErr) || handshakeErr.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected handshake error, got %v", chErr())
+	}
+
+	stop := errors.New("stop")
+	if err := c.Run(context.Background(), nil, func(f Frame) error { return stop }); err != stop {
+		t.Fatalf("expected handler error, got %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	err = c.Run(ctx, nil, func(f Frame) error {
+		cancel()
+		return nil
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected canceled error, got %v", err)
+	}
+}
+
+func TestEvent(t *testing.T) {
+	rec, err := cid.Create(cid.CodecCbor, []byte{0xa0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	since := "3kabcdefghij2"
+	handle := "alice.test"
+	now := time.Date(2024, 5, 1, 12, 0, 0, 123e6, time.UTC)
+	events := []Event{
+		&Commit{
+			Seq: 1, Repo: "did:plc:alice", Commit: rec, Rev: "3kabcdefghik2", Since: &since, Blocks: []byte{1},
+			Ops: []repo.Op{
+				{Action: repo.ActionCreate, Path: "app.bsky.feed.post/a", Cid: &rec},
+				{Action: repo.ActionDelete, Path: "app.bsky.feed.post/b", Prev: &rec},
+			},
+			PrevData: &rec, Blobs: []cid.Cid{rec}, Time: now,
+		},
+		&Sync{Seq: 2, DID: "did:plc:alice", Rev: "3kabcdefghik2", Blocks: []byte{2}, Time: now},
+		&Identity{Seq: 3, DID: "did:plc:alice", Handle: &handle, Time: now},
+		&Account{Seq: 4, DID: "did:plc:alice", Status: "takendown", Time: now},
+		&Info{Name: "OutdatedCursor"},
+	}
+	for _, want := range events {
+		b, err := EncodeEvent(want)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f, err := DecodeFrame(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := f.Event()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("expected %+v, got %+v", want, got)
+		}
+	}
+
+	f := Frame{Header: Header{Op: OpMessage, Type: "#other"}, Body: map[string]any{}}
+	if _, err := f.Event(); !errors.Is(err, ErrUnknownType) {
+		t.Fatalf("expected unknown type error, got %v", err)
+	}
+	f = Frame{Header: Header{Op: OpMessage, Type: TypeIdentity}, Body: map[string]any{"seq": uint64(1), "did": 2}}
+	if _, err := f.Event(); err == nil {
+		t.Fatal("expected error for invalid identity")
+	}
+	Register("#other", func(body map[string]any) (Event, error) { return &Info{Name: "other"}, nil })
+	f.Header.Type = "#other"
+	if e, err := f.Event(); err != nil || e.(*Info).Name != "other" {
+		t.Fatalf("unexpected registered event %v, %v", e, err)
+	}
+}
+
+func TestValidator(t *testing.T) {
+	ctx := context.Background()
+	key, err := crypto.GenerateK256()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := repo.New("did:plc:alice", blockstore.NewMemoryBlockstore())
+	var since *string
+	commit := func(writes ...repo.Write) *Commit {
+		res, err := r.ApplyWrites(ctx, writes, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := res.EventBlocks(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		e := &Commit{Repo: "did:plc:alice", Commit: res.Cid, Rev: res.Commit.Rev, Since: since, Blocks: b, Ops: res.Ops, PrevData: res.PrevData}
+		rev := res.Commit.Rev
+		since = &rev
+		return e
+	}
+	var writes []repo.Write
+	for range 50 {
+		writes = append(writes, repo.Write{Action: repo.ActionCreate, Collection: "app.bsky.feed.like", Record: map[string]any{}})
+	}
+	first := commit(writes...)
+	second := commit(
+		repo.Write{Action: repo.ActionDelete, Collection: "app.bsky.feed.like", RKey: strings.TrimPrefix(first.Ops[10].Path, "app.bsky.feed.like/")},
+		repo.Write{Action: repo.ActionUpdate, Collection: "app.bsky.feed.like", RKey: strings.TrimPrefix(first.Ops[20].Path, "app.bsky.feed.like/"), Record: map[string]any{"x": int64(1)}},
+		repo.Write{Action: repo.ActionCreate, Collection: "app.bsky.feed.post", RKey: "a", Record: map[string]any{"text": "hi"}},
+	)
+
+	v := NewValidator("did:plc:alice", nil)
+	if err := v.ValidateCommit(ctx, first, key.PublicKey()); err != nil {
+		t.Fatal(err)
+	}
+
+	tampered := *second
+	tampered.Ops = tampered.Ops[1:]
+	if err := v.ValidateCommit(ctx, &tampered, key.PublicKey()); !errors.Is(err, ErrNonInductive) {
+		t.Fatalf("expected non-inductive error for a hidden op, got %v", err)
+	}
+	tampered = *second
+	tampered.PrevData = &first.Commit
+	if err := v.ValidateCommit(ctx, &tampered, key.PublicKey()); !errors.Is(err, ErrNonInductive) {
+		t.Fatalf("expected non-inductive error for a wrong prevData, got %v", err)
+	}
+	tampered = *second
+	tampered.TooBig = true
+	if err := v.ValidateCommit(ctx, &tampered, key.PublicKey()); !errors.Is(err, ErrTooBig) {
+		t.Fatalf("expected too big error, got %v", err)
+	}
+	for _, l := range []limits.Limits{{MaxCommitOps: len(second.Ops) - 1}, {MaxSliceSize: len(second.Blocks) - 1},
+		{MaxBlockSize: 16}} {
+		limited := NewValidator(v.did, &RepoState{Rev: first.Rev, Data: v.state.Data})
+		limited.Limits = l
+		if err := limited.ValidateCommit(ctx, second, key.PublicKey()); !errors.Is(err, limits.ErrExceeded) {
+			t.Fatalf("expected size limit error with %+v, got %v", l, err)
+		}
+	}
+	other, err := crypto.GenerateK256()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := v.ValidateCommit(ctx, second, other.PublicKey()); err == nil {
+		t.Fatal("expected signature error")
+	}
+
+	if err := v.ValidateCommit(ctx, second, key.PublicKey()); err != nil {
+		t.Fatal(err)
+	}
+	if state, ok := v.State(); !ok || state.Rev != second.Rev {
+		t.Fatalf("unexpected state %+v", state)
+	}
+	if err := v.ValidateCommit(ctx, second, key.PublicKey()); err == nil {
+		t.Fatal("expected error for a replayed commit")
+	}
+
+	// a validator which missed the second commit rejects the third
+	third := commit(repo.Write{Action: repo.ActionCreate, Collection: "app.bsky.feed.post", RKey: "b", Record: map[string]any{}})
+	state := RepoState{Rev: first.Rev, Data: *second.PrevData}
+	if err := NewValidator("did:plc:alice", &state).ValidateCommit(ctx, third, key.PublicKey()); !errors.Is(err, ErrNonInductive) {
+		t.Fatalf("expected non-inductive error after a gap, got %v", err)
+	}
+	if err := v.ValidateCommit(ctx, third, key.PublicKey()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSequencer(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryEventStore(2)
+	s, err := NewSequencer(ctx, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	emit := func() {
+		if _, err := s.Emit(ctx, &Identity{DID: "did:plc:alice"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for range 5 {
+		emit()
+	}
+	if s.Seq() != 5 {
+		t.Fatalf("expected seq 5, got %d", s.Seq())
+	}
+
+	subCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	cursor := int64(1)
+	events, errFunc := s.Subscribe(subCtx, &cursor)
+	var types []string
+	var seqs []int64
+	for e := range events {
+		f, err := DecodeFrame(e.Frame)
+		if err != nil {
+			t.Fatal(err)
+		}
+		seq, _ := f.Seq()
+		if seq != e.Seq {
+			t.Fatalf("frame seq %d does not match %d", seq, e.Seq)
+		}
+		types, seqs = append(types, f.Header.Type), append(seqs, e.Seq)
+		if e.Seq == 5 {
+			go func() {
+				if _, err := s.Emit(ctx, &Identity{DID: "did:plc:alice"}); err != nil {
+					t.Error(err)
+				}
+			}()
+		}
+		if e.Seq == 6 {
+			cancel()
+		}
+	}
+	if !errors.Is(errFunc(), context.Canceled) {
+		t.Fatalf("expected canceled error, got %v", errFunc())
+	}
+	if !reflect.DeepEqual(types, []string{TypeInfo, TypeIdentity, TypeIdentity, TypeIdentity, TypeIdentity}) ||
+		!reflect.DeepEqual(seqs, []int64{0, 3, 4, 5, 6}) {
+		t.Fatalf("unexpected events %v %v", types, seqs)
+	}
+
+	// a new sequencer continues after the stored events
+	if s, err = NewSequencer(ctx, store); err != nil || s.Seq() != 6 {
+		t.Fatalf("unexpected sequencer at %d, %v", s.Seq(), err)
+	}
+	cursor = 7
+	var streamErr *StreamError
+	events, errFunc = s.Subscribe(ctx, &cursor)
+	for range events {
+		t.Fatal("unexpected event")
+	}
+	if !errors.As(errFunc(), &streamErr) || streamErr.Name != "FutureCursor" {
+		t.Fatalf("expected future cursor error, got %v", errFunc())
+	}
+
+	s.Buffer = 1
+	events, errFunc = s.Subscribe(ctx, nil)
+	go func() {
+		for {
+			s.mtx.Lock()
+			subscribed := len(s.subs) > 0
+			s.mtx.Unlock()
+			if subscribed {
+				break
+			}
+			time.Sleep(time.Millisecond)
+		}
+		if _, err := s.Emit(ctx, &Identity{DID: "did:plc:alice"}); err != nil {
+			t.Error(err)
+		}
+	}()
+	for e := range events {
+		if e.Seq == 7 {
+			emit()
+			emit()
+		}
+	}
+	if !errors.As(errFunc(), &streamErr) || streamErr.Name != "ConsumerTooSlow" {
+		t.Fatalf("expected consumer too slow error, got %v", errFunc())
+	}
+}
+
+func TestServer(t *testing.T) {
+	ctx := context.Background()
+	seq, err := NewSequencer(ctx, NewMemoryEventStore(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range 3 {
+		if _, err := seq.Emit(ctx, &Identity{DID: "did:plc:alice"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s := NewServer(seq)
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	// backfill from the cursor, then live events
+	var seqs []int64
+	errStop := errors.New("stop")
+	c := &Client{Host: srv.URL}
+	cursor := int64(1)
+	err = c.Run(ctx, &cursor, func(f Frame) error {
+		n, _ := f.Seq()
+		seqs = append(seqs, n)
+		switch n {
+		case 3:
+			go seq.Emit(ctx, &Identity{DID: "did:plc:alice"})
+		case 4:
+			return errStop
+		}
+		return nil
+	})
+	if !errors.Is(err, errStop) || !reflect.DeepEqual(seqs, []int64{2, 3, 4}) {
+		t.Fatalf("unexpected events %v, %v", seqs, err)
+	}
+
+	cursor = 10
+	var streamErr *StreamError
+	if err := c.Run(ctx, &cursor, func(f Frame) error { return nil }); !errors.As(err, &streamErr) ||
+		streamErr.Name != "FutureCursor" {
+		t.Fatalf("expected a future cursor error, got %v", err)
+	}
+	resp, err := http.Get(srv.URL + "?cursor=x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("unexpected status %d for an invalid cursor", resp.StatusCode)
+	}
+
+	// closing the server ends live streams
+	conn, err := websocket.Dial(ctx, nil, strings.Replace(srv.URL, "http", "ws", 1), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.CloseNow()
+	s.Close()
+	var closeErr *websocket.CloseError
+	if _, _, err := conn.ReadMessage(); !errors.As(err, &closeErr) || closeErr.Code != websocket.CloseGoingAway {
+		t.Fatalf("expected the stream to close, got %v", err)
+	}
+}
+
+func TestCursorStore(t *testing.T) {
+	ctx := context.Background()
+	file := NewFileCursorStore(filepath.Join(t.TempDir(), "cursor"))
+	stores := map[string]CursorStore{"file": file}
+	for name, dialect := range map[string]blockstore.SQLDialect{"sqlite": blockstore.SQLite, "postgres": blockstore.Postgres} {
+		p := dialect.Placeholder
+		create := "CREATE TABLE IF NOT EXISTS cursors (name TEXT PRIMARY KEY, seq BIGINT NOT NULL)"
+		load := "SELECT seq FROM cursors WHERE name = " + p(1)
+		save := "INSERT INTO cursors (name, seq) VALUES (" + p(1) + ", " + p(2) + ") " +
+			"ON CONFLICT (name) DO UPDATE SET seq = excluded.seq"
+		var mtx sync.Mutex
+		cursors := map[string]int64{}
+		rec := sqltest.NewRecorder(func(s sqltest.Stmt) ([][]driver.Value, error) {
+			mtx.Lock()
+			defer mtx.Unlock()
+			switch s.Query {
+			case create:
+			case load:
+				if seq, ok := cursors[s.Args[0].(string)]; ok {
+					return [][]driver.Value{{seq}}, nil
+				}
+			case save:
+				cursors[s.Args[0].(string)] = s.Args[1].(int64)
+			default:
+				return nil, fmt.Errorf("unexpected statement %q", s.Query)
+			}
+			return nil, nil
+		})
+		db := rec.Open()
+		defer db.Close()
+		if _, err := OpenSQLCursorStore(ctx, db, dialect, "cursors; DROP TABLE x", "relay"); err == nil {
+			t.Fatal("expected invalid table name error")
+		}
+		store, err := OpenSQLCursorStore(ctx, db, dialect, "", "relay")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer store.Close()
+		stores[name] = store
+	}
+
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			if cursor, err := store.Load(ctx); err != nil || cursor != nil {
+				t.Fatalf("expected no cursor, got %v, %v", cursor, err)
+			}
+			for _, seq := range []int64{5, 7} {
+				if err := store.Save(ctx, seq); err != nil {
+					t.Fatal(err)
+				}
+				if cursor, err := store.Load(ctx); err != nil || cursor == nil || *cursor != seq {
+					t.Fatalf("expected cursor %d, got %v, %v", seq, cursor, err)
+				}
+			}
+		})
+	}
+
+	throttled := NewThrottledCursorStore(file, time.Hour)
+	for _, seq := range []int64{8, 9} {
+		if err := throttled.Save(ctx, seq); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if cursor, err := file.Load(ctx); err != nil || *cursor != 8 {
+		t.Fatalf("expected only the first save to be written, got %v, %v", cursor, err)
+	}
+	if cursor, err := throttled.Load(ctx); err != nil || *cursor != 9 {
+		t.Fatalf("expected pending cursor, got %v, %v", cursor, err)
+	}
+
+	var queried []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queried = append(queried, r.URL.Query().Get("cursor"))
+		conn, err := websocket.Upgrade(w, r)
+		if err != nil {
+			return
+		}
+		defer conn.CloseNow()
+		for seq := range int64(2) {
+			b, _ := EncodeMessage("#identity", map[string]any{"seq": seq + 10})
+			conn.WriteMessage(websocket.BinaryMessage, b)
+		}
+		b, _ := EncodeError("FutureCursor", "")
+		conn.WriteMessage(websocket.BinaryMessage, b)
+		conn.Close(websocket.CloseNormal, "")
+	}))
+	defer srv.Close()
+	c := &Client{Host: srv.URL, Cursors: throttled}
+	for range 2 {
+		if err := c.Run(ctx, nil, func(f Frame) error { return nil }); err == nil {
+			t.Fatal("expected future cursor error")
+		}
+	}
+	if cursor, err := file.Load(ctx); err != nil || *cursor != 11 {
+		t.Fatalf("expected cursor to be flushed, got %v, %v", cursor, err)
+	}
+	if !reflect.DeepEqual(queried, []string{"9", "11"}) {
+		t.Fatalf("unexpected cursors %q", queried)
+	}
+}
+
+func TestScheduler(t *testing.T) {
+	ctx := context.Background()
+	var mtx sync.Mutex
+	var running, maxRunning int
+	processed := map[string][]int64{}
+	cursors := NewFileCursorStore(filepath.Join(t.TempDir(), "cursor"))
+	s := NewScheduler(ctx, 4, 8, func(ctx context.Context, f Frame) error {
+		mtx.Lock()
+		running++
+		maxRunning = max(maxRunning, running)
+		mtx.Unlock()
+		seq, _ := f.Seq()
+		time.Sleep(time.Duration(seq%3) * time.Millisecond)
+		mtx.Lock()
+		running--
+		did := f.Body["did"].(string)
+		processed[did] = append(processed[did], seq)
+		mtx.Unlock()
+		return nil
+	})
+	s.Cursors = cursors
+	dids := []string{"did:plc:alice", "did:plc:bob", "did:plc:carol", "did:plc:dave"}
+	for seq := range int64(100) {
+		f := Frame{Header: Header{Op: OpMessage, Type: TypeIdentity}, Body: map[string]any{"seq": uint64(seq + 1), "did": dids[seq%7%4]}}
+		if err := s.Add(ctx, f); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Add(ctx, Frame{}); !errors.Is(err, ErrSchedulerClosed) {
+		t.Fatalf("expected closed error, got %v", err)
+	}
+	total := 0
+	for did, seqs := range processed {
+		if !slices.IsSorted(seqs) {
+			t.Fatalf("events of %s processed out of order: %v", did, seqs)
+		}
+		total += len(seqs)
+	}
+	if total != 100 || maxRunning < 2 {
+		t.Fatalf("processed %d events with at most %d at once", total, maxRunning)
+	}
+	if cursor, ok := s.Cursor(); !ok || cursor != 100 {
+		t.Fatalf("expected cursor 100, got %d", cursor)
+	}
+	if cursor, err := cursors.Load(ctx); err != nil || *cursor != 100 {
+		t.Fatalf("expected saved cursor 100, got %v, %v", cursor, err)
+	}
+
+	// the cursor stops before the first failed frame
+	fail := errors.New("fail")
+	s = NewScheduler(ctx, 2, 4, func(ctx context.Context, f Frame) error {
+		if seq, _ := f.Seq(); seq == 3 {
+			return fail
+		}
+		return nil
+	})
+	for seq := range uint64(10) {
+		f := Frame{Header: Header{Op: OpMessage, Type: TypeCommit}, Body: map[string]any{"seq": seq + 1, "repo": "did:plc:alice"}}
+		if err := s.Add(ctx, f); err != nil {
+			if err != fail {
+				t.Fatalf("expected handler error, got %v", err)
+			}
+			break
+		}
+	}
+	if err := s.Close(); err != fail {
+		t.Fatalf("expected handler error, got %v", err)
+	}
+	if cursor, ok := s.Cursor(); !ok || cursor != 2 {
+		t.Fatalf("expected cursor 2, got %d", cursor)
+	}
+}
+
+type testLike struct {
+	Subject string `json:"subject"`
+}
+
+func TestRecordOps(t *testing.T) {
+	ctx := context.Background()
+	key, err := crypto.GenerateK256()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := repo.New("did:plc:alice", blockstore.NewMemoryBlockstore())
+	if _, err := r.ApplyWrites(ctx, []repo.Write{{Action: repo.ActionCreate, Collection: "app.bsky.feed.post", RKey: "a", Record: map[string]any{"text": "a"}}}, key); err != nil {
+		t.Fatal(err)
+	}
+	res, err := r.ApplyWrites(ctx, []repo.Write{
+		{Action: repo.ActionDelete, Collection: "app.bsky.feed.post", RKey: "a"},
+		{Action: repo.ActionCreate, Collection: "com.example.test.like", RKey: "b",
+			Record: map[string]any{"$type": "com.example.test.like", "subject": "a"}},
+	}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := NewCommitEvent(ctx, res, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lexicon.DefaultRegistry.NewValue("com.example.test.like") == nil {
+		lexicon.RegisterType("com.example.test.like", func() any { return new(testLike) })
+	}
+	var got []string
+	for op, err := range e.RecordOps(ctx) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, fmt.Sprintf("%s %s %s/%s %v %+v", op.DID, op.Action, op.Collection, op.RKey, op.Record, op.Value))
+	}
+	want := []string{"did:plc:alice delete app.bsky.feed.post/a map[] <nil>",
+		"did:plc:alice create com.example.test.like/b map[$type:com.example.test.like subject:a] &{Subject:a}"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected ops %q", got)
+	}
+
+	e.Ops = append(e.Ops, repo.Op{Action: repo.ActionCreate, Path: "app.bsky.feed.post/c", Cid: &e.Commit})
+	e.Blocks = e.Blocks[:len(e.Blocks)-1]
+	for _, err := range e.RecordOps(ctx) {
+		if err == nil {
+			t.Fatal("expected error for truncated blocks")
+		}
+	}
+}
+
+func TestBackpressure(t *testing.T) {
+	ctx := context.Background()
+	l := NewRateLimiter(100, 2)
+	start := time.Now()
+	for range 6 {
+		if err := l.Wait(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 35*time.Millisecond {
+		t.Fatalf("rate limit not applied, took %v", elapsed)
+	}
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := NewRateLimiter(1, 1).Wait(canceled); err != nil {
+		t.Fatal("expected the burst to be available")
+	}
+	unlimited := NewRateLimiter(0, 1)
+	for range 3 {
+		if err := unlimited.Wait(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := websocket.Upgrade(w, r)
+		if err != nil {
+			return
+		}
+		defer conn.CloseNow()
+		for seq := range int64(5) {
+			b, _ := EncodeMessage("#identity", map[string]any{"seq": seq + 1})
+			conn.WriteMessage(websocket.BinaryMessage, b)
+		}
+		conn.ReadMessage()
+	}))
+	defer srv.Close()
+	full := errors.New("queue full")
+	var pauses, handled int
+	c := &Client{
+		Host: srv.URL,
+		Pause: func(ctx context.Context) error {
+			pauses++
+			if pauses > 3 {
+				return full
+			}
+			return nil
+		},
+		Limiter: NewRateLimiter(1000, 1),
+	}
+	err := c.Run(ctx, nil, func(f Frame) error {
+		if pauses != handled+1 {
+			t.Errorf("message %d read without pausing", handled)
+		}
+		handled++
+		return nil
+	})
+	if err != full || handled != 3 {
+		t.Fatalf("expected pause error after 3 messages, got %v after %d", err, handled)
+	}
+}
+
+func TestGaps(t *testing.T) {
+	attempt := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempt++
+		conn, err := websocket.Upgrade(w, r)
+		if err != nil {
+			return
+		}
+		defer conn.CloseNow()
+		send := func(typ string, body map[string]any) {
+			b, _ := EncodeMessage(typ, body)
+			conn.WriteMessage(websocket.BinaryMessage, b)
+		}
+		if attempt == 1 {
+			for _, seq := range []int64{1, 2, 4} {
+				send(TypeIdentity, map[string]any{"seq": seq})
+			}
+			return
+		}
+		send(TypeInfo, map[string]any{"name": "OutdatedCursor"})
+		send(TypeIdentity, map[string]any{"seq": int64(10)})
+		send(TypeIdentity, map[string]any{"seq": int64(9)})
+		b, _ := EncodeError("FutureCursor", "")
+		conn.WriteMessage(websocket.BinaryMessage, b)
+		conn.Close(websocket.CloseNormal, "")
+	}))
+	defer srv.Close()
+
+	var gaps []Gap
+	c := &Client{Host: srv.URL, MinBackoff: time.Millisecond, OnGap: func(g Gap) { gaps = append(gaps, g) }}
+	cursor := int64(0)
+	if err := c.Run(context.Background(), &cursor, func(f Frame) error { return nil }); err == nil {
+		t.Fatal("expected future cursor error")
+	}
+	want := []Gap{{Prev: 2, Next: 4}, {Prev: 4, Next: 10, Reconnected: true, Truncated: true}, {Prev: 10, Next: 9}}
+	if !reflect.DeepEqual(gaps, want) {
+		t.Fatalf("unexpected gaps %+v", gaps)
+	}
+	if !gaps[2].Regression() || gaps[1].Missing() != 5 {
+		t.Fatal("unexpected gap classification")
+	}
+	stats := StreamStats{Messages: 6, Reconnects: 1, Gaps: 2, Missing: 6, Truncated: 1, Regressions: 1}
+	if c.Stats() != stats {
+		t.Fatalf("unexpected stats %+v", c.Stats())
+	}
+}
+
+func TestMux(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := websocket.Upgrade(w, r)
+		if err != nil {
+			return
+		}
+		defer conn.CloseNow()
+		for seq := range int64(5) {
+			b, _ := EncodeMessage(TypeIdentity, map[string]any{"seq": seq + 1, "did": []string{"did:plc:alice", "did:plc:bob"}[seq%2]})
+			conn.WriteMessage(websocket.BinaryMessage, b)
+		}
+		conn.ReadMessage()
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	m := NewMux(&Client{Host: srv.URL}, 2)
+	collect := func(frames iter.Seq[Frame], last int64, done chan<- []string) {
+		var got []string
+		for f := range frames {
+			seq, _ := f.Seq()
+			got = append(got, fmt.Sprintf("%s %d", f.Header.Type, seq))
+			if seq == last {
+				break
+			}
+		}
+		done <- got
+	}
+
+	alice, aliceErr := m.Subscribe(ctx, FilterDIDs("did:plc:alice"), nil)
+	aliceDone := make(chan []string, 1)
+	go collect(alice, 5, aliceDone)
+	for {
+		m.mtx.Lock()
+		subscribed := len(m.subs) == 1
+		m.mtx.Unlock()
+		if subscribed {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	runErr := make(chan error, 1)
+	go func() { runErr <- m.Run(ctx, nil) }()
+	if got := <-aliceDone; !reflect.DeepEqual(got, []string{"#identity 1", "#identity 3", "#identity 5"}) {
+		t.Fatalf("unexpected frames %q", got)
+	}
+	if aliceErr() != nil {
+		t.Fatal(aliceErr())
+	}
+
+	cursor := int64(1)
+	all, _ := m.Subscribe(ctx, nil, &cursor)
+	allDone := make(chan []string, 1)
+	collect(all, 5, allDone)
+	if got := <-allDone; !reflect.DeepEqual(got, []string{"#info 0", "#identity 4", "#identity 5"}) {
+		t.Fatalf("unexpected frames %q", got)
+	}
+	bob, bobErr := m.Subscribe(context.Background(), FilterDIDs("did:plc:bob"), &cursor)
+	var got []string
+	for f := range bob {
+		seq, _ := f.Seq()
+		got = append(got, fmt.Sprintf("%s %d", f.Header.Type, seq))
+		if seq == 4 {
+			cancel()
+		}
+	}
+	if !reflect.DeepEqual(got, []string{"#info 0", "#identity 4"}) {
+		t.Fatalf("unexpected frames %q", got)
+	}
+	if !errors.Is(bobErr(), context.Canceled) {
+		t.Fatalf("expected the upstream error, got %v", bobErr())
+	}
+	if err := <-runErr; !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected canceled error, got %v", err)
+	}
+	frames, errFunc := m.Subscribe(context.Background(), nil, nil)
+	for range frames {
+		t.Fatal("unexpected frame")
+	}
+	if !errors.Is(errFunc(), context.Canceled) {
+		t.Fatalf("expected the upstream error, got %v", errFunc())
+	}
+}
+
+func TestBackfill(t *testing.T) {
+	ctx := context.Background()
+	key, err := crypto.GenerateK256()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bs := blockstore.NewMemoryBlockstore()
+	r := repo.New("did:plc:alice", bs)
+	var revs []string
+	var export bytes.Buffer
+	for i := range 4 {
+		res, err := r.CreateRecord(ctx, "app.bsky.feed.post", fmt.Sprint(i), map[string]any{"text": "hi"}, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		revs = append(revs, res.Commit.Rev)
+		if i == 1 {
+			if err := repo.ExportSince(ctx, bs, res.Cid, "", &export); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := websocket.Upgrade(w, r)
+		if err != nil {
+			return
+		}
+		defer conn.CloseNow()
+		send := func(typ string, body map[string]any) {
+			b, _ := EncodeMessage(typ, body)
+			conn.WriteMessage(websocket.BinaryMessage, b)
+		}
+		send(TypeCommit, map[string]any{"seq": int64(1), "repo": "did:plc:alice", "rev": revs[1]})
+		send(TypeIdentity, map[string]any{"seq": int64(2), "did": "did:plc:bob"})
+		send(TypeCommit, map[string]any{"seq": int64(3), "repo": "did:plc:alice", "rev": revs[2]})
+		<-release
+		send(TypeCommit, map[string]any{"seq": int64(4), "repo": "did:plc:alice", "rev": revs[3]})
+		conn.ReadMessage()
+	}))
+	defer srv.Close()
+
+	other, err := crypto.GenerateK256()
+	if err != nil {
+		t.Fatal(err)
+	}
+	directory := func(key crypto.PrivateKey) staticDirectory {
+		return staticDirectory{"did:plc:alice": {DID: "did:plc:alice", Keys: map[string]identity.VerificationMethod{
+			"atproto": {Type: "Multikey", PublicKeyMultibase: key.PublicKey().Multibase()},
+		}}}
+	}
+
+	stop := errors.New("stop")
+	var mtx sync.Mutex
+	var handled []string
+	var backfilledRev string
+	var b *Backfill
+	b = &Backfill{
+		Source:    &Client{Host: srv.URL},
+		Directory: directory(key),
+		Fetch: func(ctx context.Context, did string) (io.ReadCloser, error) {
+			// wait for the live commits to be buffered
+			for {
+				b.mtx.Lock()
+				buffered := len(b.pending[did])
+				b.mtx.Unlock()
+				if buffered == 2 {
+					return io.NopCloser(bytes.NewReader(export.Bytes())), nil
+				}
+				time.Sleep(time.Millisecond)
+			}
+		},
+		HandleRepo: func(ctx context.Context, r *repo.Repo) error {
+			_, commit, _ := r.Head()
+			backfilledRev = commit.Rev
+			return nil
+		},
+		HandleFrame: func(ctx context.Context, f Frame) error {
+			mtx.Lock()
+			defer mtx.Unlock()
+			seq, _ := f.Seq()
+			handled = append(handled, fmt.Sprintf("%s %d", f.Header.Type, seq))
+			switch seq {
+			case 3:
+				close(release)
+			case 4:
+				return stop
+			}
+			return nil
+		},
+	}
+	if err := b.Run(ctx, nil, []string{"did:plc:alice", "did:plc:alice"}); err != stop {
+		t.Fatalf("expected handler error, got %v", err)
+	}
+	if !reflect.DeepEqual(handled, []string{"#identity 2", "#commit 3", "#commit 4"}) || backfilledRev != revs[1] || b.Pending() != 0 {
+		t.Fatalf("unexpected frames %q after backfilling rev %s", handled, backfilledRev)
+	}
+
+	failed := &Backfill{
+		Source:    &Client{Host: srv.URL},
+		Directory: directory(key),
+		Fetch: func(ctx context.Context, did string) (io.ReadCloser, error) {
+			return nil, errors.New("repo not found")
+		},
+		HandleFrame: func(ctx context.Context, f Frame) error { return nil },
+	}
+	if err := failed.Run(ctx, nil, []string{"did:plc:alice"}); err == nil || !strings.Contains(err.Error(), "backfilling did:plc:alice: repo not found") {
+		t.Fatalf("expected backfill error, got %v", err)
+	}
+
+	// the export must be signed by the key of the account
+	forged := &Backfill{
+		Source:    &Client{Host: srv.URL},
+		Directory: directory(other),
+		Fetch: func(ctx context.Context, did string) (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(export.Bytes())), nil
+		},
+		HandleRepo:  func(ctx context.Context, r *repo.Repo) error { return nil },
+		HandleFrame: func(ctx context.Context, f Frame) error { return nil },
+	}
+	if err := forged.Run(ctx, nil, []string{"did:plc:alice"}); err == nil || !strings.Contains(err.Error(), "invalid commit signature") {
+		t.Fatalf("expected signature error, got %v", err)
+	}
+
+	// live frames of a repository whose backfill is stuck are buffered up to the limit
+	stuck := &Backfill{
+		Source:    &Client{Host: srv.URL},
+		Directory: directory(key),
+		Fetch: func(ctx context.Context, did string) (io.ReadCloser, error) {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		},
+		MaxBuffered: 1,
+		HandleFrame: func(ctx context.Context, f Frame) error { return nil },
+	}
+	if err := stuck.Run(ctx, nil, []string{"did:plc:alice"}); !errors.Is(err, limits.ErrExceeded) {
+		t.Fatalf("expected buffer limit error, got %v", err)
+	}
+}
+
+type staticDirectory map[string]*identity.Identity
+
+func (d staticDirectory) LookupDID(ctx context.Context, did string) (*identity.Identity, error) {
+	ident, ok := d[did]
+	if !ok {
+		return nil, errors.New("DID not found")
+	}
+	return ident, nil
+}
+
+func TestReplay(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	var paths []string
+	var truncated []byte
+	for i := range 2 {
+		var buf bytes.Buffer
+		w := NewLogWriter(&buf)
+		for seq := range int64(3) {
+			if err := w.Write(Frame{Header: Header{Op: OpMessage, Type: TypeIdentity}, Body: map[string]any{"seq": 3*int64(i) + seq + 1}}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := w.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		path := filepath.Join(dir, fmt.Sprintf("%d.log", i))
+		if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, path)
+		truncated = buf.Bytes()[:buf.Len()-1]
+	}
+
+	collect := func(src Source, cursor *int64) ([]int64, error) {
+		var seqs []int64
+		err := src.Run(ctx, cursor, func(f Frame) error {
+			seq, _ := f.Seq()
+			seqs = append(seqs, seq)
+			return nil
+		})
+		return seqs, err
+	}
+	cursor := int64(2)
+	if seqs, err := collect(NewFileReplay(paths...), &cursor); err != nil || !reflect.DeepEqual(seqs, []int64{3, 4, 5, 6}) {
+		t.Fatalf("unexpected seqs %v, %v", seqs, err)
+	}
+	var readErr error
+	for _, err := range ReadLog(bytes.NewReader(truncated)) {
+		readErr = err
+	}
+	if !errors.Is(readErr, io.ErrUnexpectedEOF) {
+		t.Fatalf("expected unexpected EOF, got %v", readErr)
+	}
+	if _, err := collect(NewFileReplay(filepath.Join(dir, "missing.log")), nil); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected missing file error, got %v", err)
+	}
+
+	s, err := NewSequencer(ctx, NewMemoryEventStore(10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range 3 {
+		if _, err := s.Emit(ctx, &Identity{DID: "did:plc:alice"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if seqs, err := collect(NewStoreReplay(s.store), nil); err != nil || !reflect.DeepEqual(seqs, []int64{1, 2, 3}) {
+		t.Fatalf("unexpected seqs %v, %v", seqs, err)
+	}
+	if seqs, err := collect(NewStoreReplay(s.store), &cursor); err != nil || !reflect.DeepEqual(seqs, []int64{3}) {
+		t.Fatalf("unexpected seqs %v, %v", seqs, err)
+	}
+}
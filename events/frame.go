@@ -0,0 +1,147 @@
+// Package events implements atproto event streams, the WebSocket subscriptions such as
+// com.atproto.sync.subscribeRepos.
+//
+// https://atproto.com/specs/event-stream
+package events
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/notjuliet/grove/cbor"
+)
+
+// Frame operations.
+const (
+	OpMessage = 1
+	OpError   = -1
+)
+
+// Header of an event stream frame.
+type Header struct {
+	Op int64
+	// Message type, such as "#commit", set for message frames only.
+	Type string
+}
+
+// Frame of an event stream: a header followed by a body, each a DAG-CBOR map, sent as one binary WebSocket
+// message.
+type Frame struct {
+	Header Header
+	Body   map[string]any
+}
+
+// Error sent by a server in an error frame, after which it closes the stream.
+type StreamError struct {
+	// Error name, such as "FutureCursor" or "ConsumerTooSlow".
+	Name    string
+	Message string
+}
+
+func (e *StreamError) Error() string {
+	if e.Message == "" {
+		return "event stream error: " + e.Name
+	}
+	return fmt.Sprintf("event stream error: %s: %s", e.Name, e.Message)
+}
+
+// Encodes a frame.
+func (f *Frame) Bytes() ([]byte, error) {
+	header := map[string]any{"op": f.Header.Op}
+	if f.Header.Type != "" {
+		header["t"] = f.Header.Type
+	}
+	b, err := cbor.Encode(header)
+	if err != nil {
+		return nil, fmt.Errorf("encoding frame header: %w", err)
+	}
+	body, err := cbor.Encode(f.Body)
+	if err != nil {
+		return nil, fmt.Errorf("encoding frame body: %w", err)
+	}
+	return append(b, body...), nil
+}
+
+// Encodes a message frame of the given type.
+func EncodeMessage(typ string, body map[string]any) ([]byte, error) {
+	f := Frame{Header: Header{Op: OpMessage, Type: typ}, Body: body}
+	return f.Bytes()
+}
+
+// Encodes an error frame. The message is optional.
+func EncodeError(name, message string) ([]byte, error) {
+	body := map[string]any{"error": name}
+	if message != "" {
+		body["message"] = message
+	}
+	f := Frame{Header: Header{Op: OpError}, Body: body}
+	return f.Bytes()
+}
+
+// Failure to decode the DAG-CBOR of a frame.
+type DecodeError struct {
+	// Offset in the frame of the byte at which decoding failed.
+	Offset int
+	Err    error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("%v at offset %d", e.Err, e.Offset)
+}
+
+func (e *DecodeError) Unwrap() error { return e.Err }
+
+// Decodes a frame. Invalid DAG-CBOR fails with a *DecodeError. Error frames are returned as a *StreamError;
+// message frames must have a type. Header fields other than op and t are ignored, as required for forward
+// compatibility.
+func DecodeFrame(b []byte) (Frame, error) {
+	v, rest, err := cbor.DecodeFirst(b)
+	if err != nil {
+		return Frame{}, &DecodeError{Offset: len(b) - len(rest), Err: fmt.Errorf("decoding frame header: %w", err)}
+	}
+	header, ok := v.(map[string]any)
+	if !ok {
+		return Frame{}, errors.New("frame header is not a map")
+	}
+	if len(rest) == 0 {
+		return Frame{}, errors.New("frame has no body")
+	}
+	v, tail, err := cbor.DecodeFirst(rest)
+	if err == nil && len(tail) > 0 {
+		err = fmt.Errorf("%d bytes after the end", len(tail))
+	}
+	if err != nil {
+		return Frame{}, &DecodeError{Offset: len(b) - len(tail), Err: fmt.Errorf("decoding frame body: %w", err)}
+	}
+	body, ok := v.(map[string]any)
+	if !ok {
+		return Frame{}, errors.New("frame body is not a map")
+	}
+
+	var f Frame
+	switch op := header["op"].(type) {
+	case uint64:
+		f.Header.Op = int64(op)
+	case int64:
+		f.Header.Op = op
+	default:
+		return Frame{}, errors.New("frame header has no op")
+	}
+	switch f.Header.Op {
+	case OpMessage:
+		if f.Header.Type, ok = header["t"].(string); !ok || f.Header.Type == "" {
+			return Frame{}, errors.New("message frame has no type")
+		}
+		f.Body = body
+		return f, nil
+	case OpError:
+		name, ok := body["error"].(string)
+		if !ok {
+			return Frame{}, errors.New("error frame has no error name")
+		}
+		message, _ := body["message"].(string)
+		return Frame{}, &StreamError{Name: name, Message: message}
+	default:
+		return Frame{}, fmt.Errorf("unknown frame op %d", f.Header.Op)
+	}
+}
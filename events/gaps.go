@@ -0,0 +1,102 @@
+package events
+
+import "sync/atomic"
+
+// Discontinuity in the sequence numbers of a stream. Servers number the events of a stream consecutively, so
+// a gap means events were missed, and a regression that events are delivered again.
+type Gap struct {
+	// Sequence number of the last message before the gap, and of the first one after it.
+	Prev int64
+	Next int64
+	// Whether the gap is at the start of a connection resuming from a cursor rather than within a connection.
+	Reconnected bool
+	// Whether the server reported with an #info OutdatedCursor message that it no longer holds the events
+	// after the cursor, so the missing events were discarded by the server rather than dropped in transit.
+	Truncated bool
+}
+
+// Returns the number of events skipped over, 0 for regressions.
+func (g Gap) Missing() int64 {
+	return max(g.Next-g.Prev-1, 0)
+}
+
+// Reports whether the sequence went backwards or repeated.
+func (g Gap) Regression() bool {
+	return g.Next <= g.Prev
+}
+
+// Counters of a Client, accumulated over all runs.
+type StreamStats struct {
+	Messages   int64
+	Reconnects int64
+	// Gaps, excluding regressions, and the total number of events they skipped.
+	Gaps    int64
+	Missing int64
+	// Gaps the server reported as cursor truncation, also counted in Gaps.
+	Truncated   int64
+	Regressions int64
+}
+
+type streamCounters struct {
+	messages, reconnects, gaps, missing, truncated, regressions atomic.Int64
+}
+
+// Returns the counters of the client.
+func (c *Client) Stats() StreamStats {
+	return StreamStats{
+		Messages:    c.counters.messages.Load(),
+		Reconnects:  c.counters.reconnects.Load(),
+		Gaps:        c.counters.gaps.Load(),
+		Missing:     c.counters.missing.Load(),
+		Truncated:   c.counters.truncated.Load(),
+		Regressions: c.counters.regressions.Load(),
+	}
+}
+
+// tracks the continuity of the sequence numbers seen by Run
+type seqTracker struct {
+	c           *Client
+	last        *int64
+	reconnected bool
+	truncated   bool
+}
+
+func (t *seqTracker) observe(f *Frame) {
+	t.c.counters.messages.Add(1)
+	if f.Header.Type == TypeInfo && f.Body["name"] == "OutdatedCursor" {
+		t.truncated = true
+		return
+	}
+	seq, ok := f.Seq()
+	if !ok {
+		return
+	}
+	if t.last != nil && seq != *t.last+1 {
+		g := Gap{Prev: *t.last, Next: seq, Reconnected: t.reconnected, Truncated: t.truncated}
+		rec := t.c.recorder()
+		if g.Regression() {
+			t.c.counters.regressions.Add(1)
+			rec.Count("events.regressions", 1)
+		} else {
+			t.c.counters.gaps.Add(1)
+			t.c.counters.missing.Add(g.Missing())
+			rec.Count("events.gaps", 1)
+			rec.Count("events.missing", g.Missing())
+			if g.Truncated {
+				t.c.counters.truncated.Add(1)
+			}
+		}
+		t.c.logger().Warn("event stream sequence gap", "host", t.c.Host, "prev", g.Prev, "next", g.Next,
+			"reconnected", g.Reconnected, "truncated", g.Truncated)
+		if t.c.OnGap != nil {
+			t.c.OnGap(g)
+		}
+	}
+	t.last, t.reconnected, t.truncated = &seq, false, false
+}
+
+func (t *seqTracker) reconnect() {
+	t.c.counters.reconnects.Add(1)
+	t.c.recorder().Count("events.reconnects", 1)
+	t.reconnected = true
+}
@@ -0,0 +1,58 @@
+package events
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Delays event delivery, such as golang.org/x/time/rate.Limiter or RateLimiter.
+type Limiter interface {
+	// Blocks until the next event may be delivered, or returns the error of ctx.
+	Wait(ctx context.Context) error
+}
+
+// Token bucket Limiter allowing rate events per second on average, and bursts of up to burst events.
+type RateLimiter struct {
+	rate  float64
+	burst float64
+
+	mtx    sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// Creates a limiter with a full bucket. A rate of zero or less means no limit.
+func NewRateLimiter(rate float64, burst int) *RateLimiter {
+	burst = max(burst, 1)
+	return &RateLimiter{rate: rate, burst: float64(burst), tokens: float64(burst), last: time.Now()}
+}
+
+func (l *RateLimiter) Wait(ctx context.Context) error {
+	if l.rate <= 0 {
+		return nil
+	}
+	l.mtx.Lock()
+	now := time.Now()
+	l.tokens = min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+	// take the token now, letting the bucket go negative, so that concurrent waiters queue up in order
+	l.tokens--
+	delay := time.Duration(-l.tokens / l.rate * float64(time.Second))
+	l.mtx.Unlock()
+	if delay <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		l.mtx.Lock()
+		l.tokens++
+		l.mtx.Unlock()
+		return ctx.Err()
+	}
+}
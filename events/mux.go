@@ -0,0 +1,201 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"iter"
+	"sync"
+)
+
+// Returned to the subscribers of a Mux whose upstream stopped without error.
+var ErrMuxClosed = errors.New("multiplexer is closed")
+
+// Selects the message frames delivered to a subscriber. Filters must not modify the frame, which is shared
+// between subscribers.
+type Filter func(f *Frame) bool
+
+// Returns a filter matching frames of the given message types.
+func FilterTypes(types ...string) Filter {
+	return func(f *Frame) bool {
+		for _, typ := range types {
+			if f.Header.Type == typ {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// Returns a filter matching frames about the given accounts, by the repo field of #commit messages and the
+// did field of other messages.
+func FilterDIDs(dids ...string) Filter {
+	set := make(map[string]bool, len(dids))
+	for _, did := range dids {
+		set[did] = true
+	}
+	return func(f *Frame) bool {
+		return set[frameAccount(*f)]
+	}
+}
+
+// Fans the frames of a single upstream connection out to several in-process subscribers, each with its own
+// filter and cursor, so the features of a service share one connection. Recent frames are retained so that
+// subscribers can resume from a cursor without reconnecting upstream.
+type Mux struct {
+	src    Source
+	retain int
+	// Number of frames buffered for each subscriber, whose stream ends with a ConsumerTooSlow error when it
+	// falls further behind. Zero means DefaultSubscriberBuffer. Set before the first Subscribe.
+	Buffer int
+
+	mtx    sync.Mutex
+	subs   map[*muxSubscriber]struct{}
+	recent []Frame
+	done   bool
+	err    error
+}
+
+type muxSubscriber struct {
+	ch     chan Frame
+	filter Filter
+	// closed when the subscriber is dropped, with err set
+	drop chan struct{}
+	err  error
+}
+
+// Creates a multiplexer over src, such as a Client, retaining the given number of recent frames for
+// subscribers resuming from a cursor.
+func NewMux(src Source, retain int) *Mux {
+	return &Mux{src: src, retain: retain, subs: map[*muxSubscriber]struct{}{}}
+}
+
+// Runs the upstream source from cursor, delivering its frames to the subscribers until it stops, then ends
+// every subscription with its error, or ErrMuxClosed. A Mux can only be run once.
+func (m *Mux) Run(ctx context.Context, cursor *int64) error {
+	err := m.src.Run(ctx, cursor, func(f Frame) error {
+		m.deliver(&f)
+		return nil
+	})
+
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	m.done, m.err = true, err
+	if m.err == nil {
+		m.err = ErrMuxClosed
+	}
+	for sub := range m.subs {
+		m.dropLocked(sub, m.err)
+	}
+	return err
+}
+
+func (m *Mux) deliver(f *Frame) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	if _, ok := f.Seq(); ok && m.retain > 0 {
+		// trimming in bulk keeps appends amortized constant time
+		if len(m.recent) >= 2*m.retain {
+			m.recent = append([]Frame(nil), m.recent[len(m.recent)-m.retain+1:]...)
+		}
+		m.recent = append(m.recent, *f)
+	}
+	for sub := range m.subs {
+		if sub.filter != nil && !sub.filter(f) {
+			continue
+		}
+		select {
+		case sub.ch <- *f:
+		default:
+			m.dropLocked(sub, &StreamError{Name: "ConsumerTooSlow", Message: "subscriber fell behind the stream"})
+		}
+	}
+}
+
+// must be called with the lock held
+func (m *Mux) dropLocked(sub *muxSubscriber, err error) {
+	sub.err = err
+	close(sub.drop)
+	delete(m.subs, sub)
+}
+
+// Streams the frames matching filter, or every frame if it is nil: with a nil cursor only live frames,
+// otherwise the retained frames after cursor followed by live frames, starting with an #info OutdatedCursor
+// message when frames after cursor are no longer retained. The returned function reports the error which
+// ended the stream once the iteration is over.
+func (m *Mux) Subscribe(ctx context.Context, filter Filter, cursor *int64) (iter.Seq[Frame], func() error) {
+	var err error
+	seq := func(yield func(Frame) bool) {
+		size := m.Buffer
+		if size <= 0 {
+			size = DefaultSubscriberBuffer
+		}
+		sub := &muxSubscriber{ch: make(chan Frame, size), filter: filter, drop: make(chan struct{})}
+
+		m.mtx.Lock()
+		if m.done {
+			err = m.err
+			m.mtx.Unlock()
+			return
+		}
+		var replay []Frame
+		if cursor != nil {
+			for i, f := range m.recent {
+				seq, _ := f.Seq()
+				if i == 0 && seq > *cursor+1 {
+					replay = append(replay, Frame{
+						Header: Header{Op: OpMessage, Type: TypeInfo},
+						Body:   (&Info{Name: "OutdatedCursor", Message: "some events are no longer retained"}).Body(),
+					})
+				}
+				if seq > *cursor && (filter == nil || filter(&f)) {
+					replay = append(replay, f)
+				}
+			}
+		}
+		m.subs[sub] = struct{}{}
+		m.mtx.Unlock()
+		defer func() {
+			m.mtx.Lock()
+			delete(m.subs, sub)
+			m.mtx.Unlock()
+		}()
+
+		for _, f := range replay {
+			if !yield(f) {
+				return
+			}
+		}
+		// live frames are newer than the replayed ones, but may be older than a cursor ahead of the upstream
+		live := func(f Frame) bool {
+			if seq, ok := f.Seq(); ok && cursor != nil && seq <= *cursor {
+				return true
+			}
+			return yield(f)
+		}
+		for {
+			select {
+			case <-ctx.Done():
+				err = ctx.Err()
+				return
+			case f := <-sub.ch:
+				if !live(f) {
+					return
+				}
+			case <-sub.drop:
+				// deliver what was buffered before the subscriber was dropped
+				for {
+					select {
+					case f := <-sub.ch:
+						if !live(f) {
+							return
+						}
+					default:
+						err = sub.err
+						return
+					}
+				}
+			}
+		}
+	}
+	return seq, func() error { return err }
+}
@@ -0,0 +1,75 @@
+package events
+
+import (
+	"context"
+	"fmt"
+	"iter"
+	"strings"
+
+	"github.com/notjuliet/grove/cid"
+	"github.com/notjuliet/grove/lexicon"
+	"github.com/notjuliet/grove/limits"
+	"github.com/notjuliet/grove/repo"
+)
+
+// Record change of a #commit event, with the record decoded from the event blocks.
+type RecordOp struct {
+	DID        string
+	Collection string
+	RKey       string
+	Action     repo.Action
+	// CID of the new record, nil for deletes.
+	Cid *cid.Cid
+	// Decoded new record, nil for deletes.
+	Record map[string]any
+	// New record decoded into the Go type registered for its $type in lexicon.DefaultRegistry, nil for deletes
+	// and records of unregistered types.
+	Value any
+}
+
+// Iterates over the operations of the commit with their decoded records, read from the CAR slice of the
+// event. Blocks are checked against their CIDs and records against the limits of the network, but the commit
+// itself is neither verified nor checked against the previous state of the repository; see Validator for that.
+// Iteration stops after the first non-nil error, such as a record missing from the blocks of an event flagged
+// tooBig.
+func (e *Commit) RecordOps(ctx context.Context) iter.Seq2[RecordOp, error] {
+	return func(yield func(RecordOp, error) bool) {
+		bs, err := readBlocks(ctx, e.Blocks, e.Commit, limits.Network)
+		if err != nil {
+			yield(RecordOp{}, err)
+			return
+		}
+		for _, op := range e.Ops {
+			collection, rkey, ok := strings.Cut(op.Path, "/")
+			if !ok {
+				yield(RecordOp{}, fmt.Errorf("invalid operation path %q", op.Path))
+				return
+			}
+			rop := RecordOp{DID: e.Repo, Collection: collection, RKey: rkey, Action: op.Action, Cid: op.Cid}
+			if op.Cid != nil {
+				b, err := bs.Get(ctx, *op.Cid)
+				if err != nil {
+					yield(RecordOp{}, fmt.Errorf("fetching record %s: %w", op.Path, err))
+					return
+				}
+				if err := limits.Check(len(b), limits.MaxRecordSize, "bytes"); err != nil {
+					yield(RecordOp{}, fmt.Errorf("record %s: %w", op.Path, err))
+					return
+				}
+				if rop.Record, err = repo.DecodeRecord(*op.Cid, b); err != nil {
+					yield(RecordOp{}, err)
+					return
+				}
+				if typ, _ := rop.Record["$type"].(string); typ != "" {
+					if rop.Value, err = lexicon.DefaultRegistry.Decode(rop.Record); err != nil {
+						yield(RecordOp{}, fmt.Errorf("record %s: %w", op.Path, err))
+						return
+					}
+				}
+			}
+			if !yield(rop, nil) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package events
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"iter"
+	"os"
+)
+
+// Stream of message frames consumed with a handler, such as a live Client or a Replay of captured frames.
+type Source interface {
+	// Calls handle for each message frame in order, starting after cursor, or from the start of the stream if
+	// cursor is nil, until ctx is done or handle returns an error.
+	Run(ctx context.Context, cursor *int64, handle func(Frame) error) error
+}
+
+// maximum size of a frame read from a log
+const maxLogFrameSize = 16 << 20
+
+// Writes captured frames to a log, each encoded frame prefixed with its length as a uvarint. Frames are
+// buffered until Flush.
+type LogWriter struct {
+	w *bufio.Writer
+}
+
+// Creates a log writer appending to w.
+func NewLogWriter(w io.Writer) *LogWriter {
+	return &LogWriter{w: bufio.NewWriter(w)}
+}
+
+// Appends a frame to the log.
+func (w *LogWriter) Write(f Frame) error {
+	b, err := f.Bytes()
+	if err != nil {
+		return err
+	}
+	if _, err := w.w.Write(binary.AppendUvarint(nil, uint64(len(b)))); err != nil {
+		return err
+	}
+	_, err = w.w.Write(b)
+	return err
+}
+
+// Writes the buffered frames to the underlying writer.
+func (w *LogWriter) Flush() error {
+	return w.w.Flush()
+}
+
+// Iterates over the frames of a log written by LogWriter. Iteration stops after the first non-nil error; a
+// log truncated in the middle of a frame fails with io.ErrUnexpectedEOF.
+func ReadLog(r io.Reader) iter.Seq2[Frame, error] {
+	return func(yield func(Frame, error) bool) {
+		br := bufio.NewReader(r)
+		for {
+			n, err := binary.ReadUvarint(br)
+			if err == io.EOF {
+				return
+			}
+			if err == nil && n > maxLogFrameSize {
+				err = fmt.Errorf("log frame of %d bytes is too large", n)
+			}
+			if err != nil {
+				yield(Frame{}, err)
+				return
+			}
+			b := make([]byte, n)
+			if _, err := io.ReadFull(br, b); err != nil {
+				if err == io.EOF {
+					err = io.ErrUnexpectedEOF
+				}
+				yield(Frame{}, err)
+				return
+			}
+			f, err := DecodeFrame(b)
+			if !yield(f, err) || err != nil {
+				return
+			}
+		}
+	}
+}
+
+// Replays previously captured frames through the same interface as the live Client, for deterministic
+// reprocessing and recovery.
+type Replay struct {
+	frames func(ctx context.Context, cursor *int64) iter.Seq2[Frame, error]
+}
+
+// Creates a replay of the frames of the given logs, written by LogWriter, in order.
+func NewFileReplay(paths ...string) *Replay {
+	return &Replay{frames: func(ctx context.Context, cursor *int64) iter.Seq2[Frame, error] {
+		return func(yield func(Frame, error) bool) {
+			for _, path := range paths {
+				f, err := os.Open(path)
+				if err != nil {
+					yield(Frame{}, err)
+					return
+				}
+				for frame, err := range ReadLog(f) {
+					if err != nil {
+						err = fmt.Errorf("reading %s: %w", path, err)
+					}
+					if !yield(frame, err) || err != nil {
+						f.Close()
+						return
+					}
+				}
+				f.Close()
+			}
+		}
+	}}
+}
+
+// Creates a replay of the events of a Sequencer's store.
+func NewStoreReplay(store EventStore) *Replay {
+	return &Replay{frames: func(ctx context.Context, cursor *int64) iter.Seq2[Frame, error] {
+		return func(yield func(Frame, error) bool) {
+			var after int64
+			if cursor != nil {
+				after = *cursor
+			}
+			for e, err := range store.Since(ctx, after) {
+				if err != nil {
+					yield(Frame{}, err)
+					return
+				}
+				f, err := DecodeFrame(e.Frame)
+				if err != nil {
+					err = fmt.Errorf("decoding event %d: %w", e.Seq, err)
+				}
+				if !yield(f, err) || err != nil {
+					return
+				}
+			}
+		}
+	}}
+}
+
+// Calls handle for each replayed frame after cursor, or for every frame if cursor is nil. Returns nil once
+// every frame was handled, the error of handle as is, or the error of ctx or of reading the frames.
+func (r *Replay) Run(ctx context.Context, cursor *int64, handle func(Frame) error) error {
+	for f, err := range r.frames(ctx, cursor) {
+		if err != nil {
+			return err
+		}
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		if seq, ok := f.Seq(); ok && cursor != nil && seq <= *cursor {
+			continue
+		}
+		if err := handle(f); err != nil {
+			return err
+		}
+	}
+	return ctx.Err()
+}
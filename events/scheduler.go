@@ -0,0 +1,226 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// Returned by Scheduler.Add once the scheduler is closed.
+var ErrSchedulerClosed = errors.New("scheduler is closed")
+
+// Fans message frames out to a pool of workers while processing the frames of each account in order, the
+// account being the repo field of #commit messages and the did field of other messages. The number of frames
+// queued or in progress is bounded, and the cursor only advances past a frame once it and every frame before
+// it were processed, so a consumer restarting from it never skips an event.
+//
+// A Scheduler is typically fed from the handler of Client.Run, with the cursor store set on the scheduler
+// rather than the client.
+type Scheduler struct {
+	handle func(ctx context.Context, f Frame) error
+	// Store saving the cursor each time it advances, if set. Set before the first Add.
+	Cursors CursorStore
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	slots  chan struct{}
+	work   chan *accountQueue
+	wg     sync.WaitGroup
+
+	mtx      sync.Mutex
+	accounts map[string]*accountQueue
+	// frames in the order they were added, trimmed once processed
+	order  []*task
+	cursor *int64
+	err    error
+	closed bool
+}
+
+type task struct {
+	frame  Frame
+	seq    int64
+	hasSeq bool
+	done   bool
+}
+
+type accountQueue struct {
+	key   string
+	tasks []*task
+}
+
+// Creates a scheduler running handle on the given number of workers, with at most maxPending frames queued
+// or in progress. The context passed to handle is canceled when a frame fails or ctx is done.
+func NewScheduler(ctx context.Context, workers, maxPending int, handle func(ctx context.Context, f Frame) error) *Scheduler {
+	workers, maxPending = max(workers, 1), max(maxPending, 1)
+	ctx, cancel := context.WithCancel(ctx)
+	s := &Scheduler{
+		handle:   handle,
+		ctx:      ctx,
+		cancel:   cancel,
+		slots:    make(chan struct{}, maxPending),
+		work:     make(chan *accountQueue, maxPending),
+		accounts: map[string]*accountQueue{},
+	}
+	s.wg.Add(workers)
+	for range workers {
+		go func() {
+			defer s.wg.Done()
+			for q := range s.work {
+				s.run(q)
+			}
+		}()
+	}
+	return s
+}
+
+// Queues a frame, blocking while the maximum number of frames is pending. Returns the error of the first
+// frame which failed, after which no more frames are processed, or the error of ctx.
+func (s *Scheduler) Add(ctx context.Context, f Frame) error {
+	s.mtx.Lock()
+	closed := s.closed
+	s.mtx.Unlock()
+	if closed {
+		return ErrSchedulerClosed
+	}
+	select {
+	case s.slots <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-s.ctx.Done():
+		if err := s.Err(); err != nil {
+			return err
+		}
+		return s.ctx.Err()
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if s.closed {
+		<-s.slots
+		return ErrSchedulerClosed
+	}
+	if s.err != nil {
+		<-s.slots
+		return s.err
+	}
+	t := &task{frame: f}
+	t.seq, t.hasSeq = f.Seq()
+	s.order = append(s.order, t)
+	key := frameAccount(f)
+	if q, ok := s.accounts[key]; ok {
+		q.tasks = append(q.tasks, t)
+		return nil
+	}
+	q := &accountQueue{key: key, tasks: []*task{t}}
+	s.accounts[key] = q
+	// never blocks, as there are at most as many queues as slots
+	s.work <- q
+	return nil
+}
+
+func frameAccount(f Frame) string {
+	if f.Header.Type == TypeCommit {
+		did, _ := f.Body["repo"].(string)
+		return did
+	}
+	did, _ := f.Body["did"].(string)
+	return did
+}
+
+// processes the frames of an account until its queue is empty
+func (s *Scheduler) run(q *accountQueue) {
+	for {
+		s.mtx.Lock()
+		if len(q.tasks) == 0 {
+			delete(s.accounts, q.key)
+			s.mtx.Unlock()
+			return
+		}
+		t := q.tasks[0]
+		q.tasks = q.tasks[1:]
+		failed := s.err != nil
+		s.mtx.Unlock()
+
+		var err error
+		if !failed {
+			err = s.handle(s.ctx, t.frame)
+		}
+		s.complete(t, err)
+		<-s.slots
+	}
+}
+
+// marks a task as processed and advances the cursor past every leading processed task
+func (s *Scheduler) complete(t *task, err error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if err != nil && s.err == nil {
+		s.err = err
+		s.cancel()
+	}
+	if s.err != nil {
+		return
+	}
+	t.done = true
+	var advanced bool
+	for len(s.order) > 0 && s.order[0].done {
+		if s.order[0].hasSeq {
+			s.cursor, advanced = &s.order[0].seq, true
+		}
+		s.order[0] = nil
+		s.order = s.order[1:]
+	}
+	if advanced && s.Cursors != nil {
+		if err := s.Cursors.Save(s.ctx, *s.cursor); err != nil {
+			s.err = fmt.Errorf("saving cursor: %w", err)
+			s.cancel()
+		}
+	}
+}
+
+// Returns the sequence number up to which every frame was processed, if any.
+func (s *Scheduler) Cursor() (int64, bool) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if s.cursor == nil {
+		return 0, false
+	}
+	return *s.cursor, true
+}
+
+// Returns the error of the first frame which failed, if any.
+func (s *Scheduler) Err() error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return s.err
+}
+
+// Stops accepting frames, waits for the queued ones to be processed, flushes the cursor store if it buffers
+// saves, and returns the error of the first frame which failed, if any. Add must not be called concurrently.
+func (s *Scheduler) Close() error {
+	s.mtx.Lock()
+	if s.closed {
+		s.mtx.Unlock()
+		return s.Err()
+	}
+	s.closed = true
+	s.mtx.Unlock()
+
+	// every slot is free once all frames are processed
+	for range cap(s.slots) {
+		s.slots <- struct{}{}
+	}
+	close(s.work)
+	s.wg.Wait()
+	s.cancel()
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if flusher, ok := s.Cursors.(CursorFlusher); ok && s.err == nil {
+		if err := flusher.Flush(context.WithoutCancel(s.ctx)); err != nil {
+			s.err = fmt.Errorf("saving cursor: %w", err)
+		}
+	}
+	return s.err
+}
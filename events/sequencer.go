@@ -0,0 +1,273 @@
+package events
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"iter"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/notjuliet/grove/metrics"
+	"github.com/notjuliet/grove/repo"
+)
+
+// Event assigned a sequence number by a Sequencer, with its encoded message frame.
+type SequencedEvent struct {
+	Seq   int64
+	Frame []byte
+}
+
+// Persistent log of sequenced events, the outbox a Sequencer replays to subscribers resuming from a cursor.
+// Implementations must be safe for concurrent use.
+type EventStore interface {
+	// Appends an event, whose seq is greater than that of every stored event.
+	Append(ctx context.Context, e SequencedEvent) error
+	// Returns the greatest stored seq, or 0 if no event was ever stored.
+	LastSeq(ctx context.Context) (int64, error)
+	// Iterates in order over the stored events with a seq greater than after. Stores keeping only recent
+	// events start with the oldest one retained. Iteration stops after the first non-nil error.
+	Since(ctx context.Context, after int64) iter.Seq2[SequencedEvent, error]
+}
+
+// Default number of live events buffered for each subscriber.
+const DefaultSubscriberBuffer = 1024
+
+// Assigns monotonic sequence numbers to the events of a server, persists them, and delivers them to
+// subscribers, replaying those resuming from a cursor before switching them to live events without gaps.
+type Sequencer struct {
+	store EventStore
+	// Number of live events buffered for each subscriber, whose stream ends with a ConsumerTooSlow error
+	// when it falls further behind. Zero means DefaultSubscriberBuffer. Set before the first Subscribe.
+	Buffer int
+	// Recorder of the sequencer measurements, if set: sequencer.events counting the emitted events,
+	// sequencer.seq, the last seq, sequencer.subscribers, the number of live subscribers, and
+	// sequencer.slow_consumers counting the subscribers dropped for falling behind. Set before the first Emit.
+	Metrics metrics.Recorder
+
+	mtx  sync.Mutex
+	seq  int64
+	subs map[*subscriber]struct{}
+}
+
+type subscriber struct {
+	ch chan SequencedEvent
+	// closed when the subscriber is dropped for falling behind
+	slow chan struct{}
+}
+
+// Creates a sequencer continuing after the last event of store.
+func NewSequencer(ctx context.Context, store EventStore) (*Sequencer, error) {
+	seq, err := store.LastSeq(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("reading last seq: %w", err)
+	}
+	return &Sequencer{store: store, seq: seq, subs: map[*subscriber]struct{}{}}, nil
+}
+
+// Returns the seq of the last emitted event.
+func (s *Sequencer) Seq() int64 {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return s.seq
+}
+
+// Assigns the next seq to an event, stamps its time, persists it, and delivers it to subscribers. The seq
+// and time fields of e itself are ignored. Returns the assigned seq.
+func (s *Sequencer) Emit(ctx context.Context, e Event) (int64, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	seq := s.seq + 1
+	body := e.Body()
+	body["seq"] = seq
+	if _, ok := body["time"]; ok {
+		body["time"] = formatTime(time.Now())
+	}
+	frame, err := EncodeMessage(e.Type(), body)
+	if err != nil {
+		return 0, err
+	}
+	se := SequencedEvent{Seq: seq, Frame: frame}
+	if err := s.store.Append(ctx, se); err != nil {
+		return 0, fmt.Errorf("persisting event: %w", err)
+	}
+	s.seq = seq
+
+	rec := s.recorder()
+	for sub := range s.subs {
+		select {
+		case sub.ch <- se:
+		default:
+			close(sub.slow)
+			delete(s.subs, sub)
+			rec.Count("sequencer.slow_consumers", 1)
+		}
+	}
+	rec.Count("sequencer.events", 1)
+	rec.Gauge("sequencer.seq", float64(seq))
+	rec.Gauge("sequencer.subscribers", float64(len(s.subs)))
+	return seq, nil
+}
+
+func (s *Sequencer) recorder() metrics.Recorder {
+	if s.Metrics != nil {
+		return s.Metrics
+	}
+	return metrics.Discard
+}
+
+// Streams events to a new subscriber: with a nil cursor only live events, otherwise every stored event after
+// cursor followed by live events. When older events were discarded by the store, the stream starts with an
+// #info OutdatedCursor message of seq 0. The returned function reports the error which ended the stream once
+// the iteration is over: a *StreamError named FutureCursor for a cursor beyond the last seq, or
+// ConsumerTooSlow when the subscriber fell behind, or the error of ctx.
+func (s *Sequencer) Subscribe(ctx context.Context, cursor *int64) (iter.Seq[SequencedEvent], func() error) {
+	var err error
+	seq := func(yield func(SequencedEvent) bool) {
+		size := s.Buffer
+		if size <= 0 {
+			size = DefaultSubscriberBuffer
+		}
+		sub := &subscriber{ch: make(chan SequencedEvent, size), slow: make(chan struct{})}
+		s.mtx.Lock()
+		last := s.seq
+		if cursor != nil && *cursor > last {
+			s.mtx.Unlock()
+			err = &StreamError{Name: "FutureCursor", Message: "cursor is ahead of the stream"}
+			return
+		}
+		s.subs[sub] = struct{}{}
+		s.recorder().Gauge("sequencer.subscribers", float64(len(s.subs)))
+		s.mtx.Unlock()
+		defer func() {
+			s.mtx.Lock()
+			delete(s.subs, sub)
+			s.recorder().Gauge("sequencer.subscribers", float64(len(s.subs)))
+			s.mtx.Unlock()
+		}()
+
+		// events up to last were emitted before subscribing, and later ones are buffered meanwhile
+		if cursor != nil && *cursor < last {
+			first := true
+			for e, storeErr := range s.store.Since(ctx, *cursor) {
+				if storeErr != nil {
+					err = storeErr
+					return
+				}
+				if e.Seq > last {
+					break
+				}
+				if first && e.Seq > *cursor+1 {
+					info, infoErr := EncodeEvent(&Info{Name: "OutdatedCursor", Message: "some events were discarded"})
+					if infoErr != nil {
+						err = infoErr
+						return
+					}
+					if !yield(SequencedEvent{Frame: info}) {
+						return
+					}
+				}
+				first = false
+				if !yield(e) {
+					return
+				}
+			}
+		}
+
+		for {
+			select {
+			case <-ctx.Done():
+				err = ctx.Err()
+				return
+			case e := <-sub.ch:
+				if e.Seq <= last {
+					continue
+				}
+				if !yield(e) {
+					return
+				}
+			case <-sub.slow:
+				err = &StreamError{Name: "ConsumerTooSlow", Message: "subscriber fell behind the stream"}
+				return
+			}
+		}
+	}
+	return seq, func() error { return err }
+}
+
+// Builds the #commit event of a commit created by repo.Repo.ApplyWrites. since is the rev of the previous
+// commit, nil for the first commit of a repository.
+func NewCommitEvent(ctx context.Context, res *repo.CommitResult, since *string) (*Commit, error) {
+	blocks, err := res.EventBlocks(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("building commit blocks: %w", err)
+	}
+	return &Commit{
+		Repo:     res.Commit.DID,
+		Commit:   res.Cid,
+		Rev:      res.Commit.Rev,
+		Since:    since,
+		Blocks:   blocks,
+		Ops:      res.Ops,
+		PrevData: res.PrevData,
+		Time:     time.Now(),
+	}, nil
+}
+
+// EventStore holding the most recent events in memory.
+type MemoryEventStore struct {
+	mtx    sync.RWMutex
+	events []SequencedEvent
+	last   int64
+	retain int
+}
+
+// Creates a store keeping at least the given number of most recent events, or all of them if retain is zero.
+func NewMemoryEventStore(retain int) *MemoryEventStore {
+	return &MemoryEventStore{retain: retain}
+}
+
+func (m *MemoryEventStore) Append(ctx context.Context, e SequencedEvent) error {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	if e.Seq <= m.last {
+		return errors.New("event seq is not increasing")
+	}
+	m.events = append(m.events, e)
+	m.last = e.Seq
+	// trimming in bulk keeps appends amortized constant time, and copying lets the old events be collected
+	if m.retain > 0 && len(m.events) >= 2*m.retain {
+		m.events = append([]SequencedEvent(nil), m.events[len(m.events)-m.retain:]...)
+	}
+	return nil
+}
+
+func (m *MemoryEventStore) LastSeq(ctx context.Context) (int64, error) {
+	m.mtx.RLock()
+	defer m.mtx.RUnlock()
+	return m.last, nil
+}
+
+func (m *MemoryEventStore) Since(ctx context.Context, after int64) iter.Seq2[SequencedEvent, error] {
+	return func(yield func(SequencedEvent, error) bool) {
+		m.mtx.RLock()
+		events := m.events
+		m.mtx.RUnlock()
+		// events is only ever appended to or replaced, so the snapshot stays valid without the lock
+		i, _ := slices.BinarySearchFunc(events, after+1, func(e SequencedEvent, seq int64) int {
+			return cmp.Compare(e.Seq, seq)
+		})
+		for _, e := range events[i:] {
+			if err := ctx.Err(); err != nil {
+				yield(SequencedEvent{}, err)
+				return
+			}
+			if !yield(e, nil) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/notjuliet/grove/internal/websocket"
+	"github.com/notjuliet/grove/xrpc"
+)
+
+// Default time allowed to write a frame to a subscriber of a Server.
+const DefaultWriteTimeout = 10 * time.Second
+
+// Serves the events of a Sequencer over WebSocket, as the server half of com.atproto.sync.subscribeRepos. It
+// is an http.Handler, to be mounted at "/xrpc/com.atproto.sync.subscribeRepos" or registered as a query of an
+// xrpc.ServeMux, whose handler calls ServeHTTP and returns a nil output.
+//
+// Subscribers resuming from a cursor get the stored events after it, then live events. Streams end with an
+// error frame when the cursor is ahead of the sequencer (FutureCursor), or when a subscriber falls behind the
+// sequencer buffer (ConsumerTooSlow). Subscribers taking longer than WriteTimeout to accept a frame are
+// disconnected without one, as their connection cannot carry it.
+type Server struct {
+	// Whether to accept the permessage-deflate extension when subscribers offer it.
+	Compress bool
+	// Time allowed to write each frame. Zero means DefaultWriteTimeout.
+	WriteTimeout time.Duration
+	// Logger for subscriptions and their end, if set.
+	Logger *slog.Logger
+
+	seq *Sequencer
+	// canceled by Close
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// Creates a server streaming the events of seq.
+func NewServer(seq *Sequencer) *Server {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Server{seq: seq, ctx: ctx, cancel: cancel}
+}
+
+// Ends every stream, closing its connection with code 1001 (going away), and rejects later subscriptions.
+// http.Server.Shutdown does not close WebSocket connections, so servers call Close when shutting down.
+func (s *Server) Close() {
+	s.cancel()
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var cursor *int64
+	if v := r.URL.Query().Get("cursor"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 0 {
+			xrpc.WriteError(w, &xrpc.Error{StatusCode: http.StatusBadRequest, Name: "InvalidRequest",
+				Message: "cursor must be a non-negative integer"})
+			return
+		}
+		cursor = &n
+	}
+	if s.ctx.Err() != nil {
+		xrpc.WriteError(w, &xrpc.Error{StatusCode: http.StatusServiceUnavailable, Name: "ServiceUnavailable",
+			Message: "server is shutting down"})
+		return
+	}
+	conn, err := websocket.UpgradeWith(w, r, websocket.UpgradeOptions{Compress: s.Compress})
+	if err != nil {
+		s.logger().Debug("event stream upgrade failed", "remote", r.RemoteAddr, "error", err)
+		return
+	}
+
+	// the connection is hijacked, so its context is not canceled when the subscriber disconnects
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	stop := context.AfterFunc(s.ctx, cancel)
+	defer stop()
+	// subscribers send nothing but pings and the closing handshake, which ReadMessage handles
+	readDone := make(chan struct{})
+	go func() {
+		defer close(readDone)
+		defer cancel()
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	s.logger().Debug("event stream subscribed", "remote", r.RemoteAddr, "cursor", cursor)
+	err = s.stream(ctx, conn, cursor)
+	s.logger().Debug("event stream ended", "remote", r.RemoteAddr, "error", err)
+
+	var streamErr *StreamError
+	code := websocket.CloseNormal
+	switch {
+	case errors.As(err, &streamErr):
+		if frame, err := EncodeError(streamErr.Name, streamErr.Message); err == nil {
+			s.write(conn, frame)
+		}
+	case s.ctx.Err() != nil:
+		code = websocket.CloseGoingAway
+	}
+	select {
+	case <-readDone:
+		// the subscriber disconnected
+	default:
+		if conn.WriteClose(code, "") == nil {
+			select {
+			case <-readDone:
+			case <-time.After(time.Second):
+			}
+		}
+	}
+	conn.CloseNow()
+	<-readDone
+}
+
+// writes the events of the sequencer to the connection until the subscription ends, returning its error
+func (s *Server) stream(ctx context.Context, conn *websocket.Conn, cursor *int64) error {
+	events, errFunc := s.seq.Subscribe(ctx, cursor)
+	for e := range events {
+		if err := s.write(conn, e.Frame); err != nil {
+			return err
+		}
+	}
+	return errFunc()
+}
+
+func (s *Server) write(conn *websocket.Conn, frame []byte) error {
+	timeout := s.WriteTimeout
+	if timeout <= 0 {
+		timeout = DefaultWriteTimeout
+	}
+	conn.SetWriteDeadline(time.Now().Add(timeout))
+	return conn.WriteMessage(websocket.BinaryMessage, frame)
+}
+
+func (s *Server) logger() *slog.Logger {
+	if s.Logger != nil {
+		return s.Logger
+	}
+	return discardLogger
+}
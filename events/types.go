@@ -0,0 +1,391 @@
+package events
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/notjuliet/grove/cid"
+	"github.com/notjuliet/grove/repo"
+)
+
+// Message types of the repository event stream.
+const (
+	TypeCommit   = "#commit"
+	TypeSync     = "#sync"
+	TypeIdentity = "#identity"
+	TypeAccount  = "#account"
+	TypeInfo     = "#info"
+)
+
+// Returned by Frame.Event for message types without a registered decoder, which consumers should skip.
+var ErrUnknownType = errors.New("unknown message type")
+
+// Typed body of a message frame.
+type Event interface {
+	// Returns the message type, such as "#commit".
+	Type() string
+	// Returns the frame body.
+	Body() map[string]any
+}
+
+// Repository commit, with the blocks needed to verify and apply its operations.
+//
+// https://atproto.com/specs/sync#firehose
+type Commit struct {
+	Seq int64
+	// DID of the repository.
+	Repo string
+	// CID of the commit block.
+	Commit cid.Cid
+	// Rev of the commit.
+	Rev string
+	// Rev of the previous commit, if any.
+	Since *string
+	// CAR slice rooted at the commit, holding the new blocks and the covering proofs of the operations.
+	Blocks []byte
+	Ops    []repo.Op
+	// MST root before the commit, nil for the first commit of a repository.
+	PrevData *cid.Cid
+	// Deprecated fields, still sent by some servers.
+	TooBig bool
+	Rebase bool
+	Blobs  []cid.Cid
+	// Time the event was sequenced.
+	Time time.Time
+}
+
+// Repository state reset, with a CAR slice holding only the signed commit.
+type Sync struct {
+	Seq int64
+	DID string
+	Rev string
+	// CAR slice rooted at the commit, holding only the commit block.
+	Blocks []byte
+	Time   time.Time
+}
+
+// Change of the identity of an account, such as its handle or signing key.
+type Identity struct {
+	Seq int64
+	DID string
+	// Current handle, if the server sent it.
+	Handle *string
+	Time   time.Time
+}
+
+// Change of the hosting status of an account.
+type Account struct {
+	Seq    int64
+	DID    string
+	Active bool
+	// Reason the account is inactive, such as "takendown", "suspended" or "deactivated". Empty when active.
+	Status string
+	Time   time.Time
+}
+
+// Informational message about the stream, such as "OutdatedCursor".
+type Info struct {
+	Name    string
+	Message string
+}
+
+// Returns the typed status of the account.
+func (e *Account) AccountStatus() repo.AccountStatus {
+	return repo.AccountStatusOf(e.Active, e.Status)
+}
+
+// Sets the active flag and status of the event from a typed status.
+func (e *Account) SetAccountStatus(s repo.AccountStatus) {
+	e.Active, e.Status = s.Fields()
+}
+
+func (e *Commit) Type() string   { return TypeCommit }
+func (e *Sync) Type() string     { return TypeSync }
+func (e *Identity) Type() string { return TypeIdentity }
+func (e *Account) Type() string  { return TypeAccount }
+func (e *Info) Type() string     { return TypeInfo }
+
+func (e *Commit) Body() map[string]any {
+	ops := make([]any, len(e.Ops))
+	for i, op := range e.Ops {
+		m := map[string]any{"action": string(op.Action), "path": op.Path, "cid": optLink(op.Cid)}
+		if op.Prev != nil {
+			m["prev"] = op.Prev.Link()
+		}
+		ops[i] = m
+	}
+	blobs := make([]any, len(e.Blobs))
+	for i, c := range e.Blobs {
+		blobs[i] = c.Link()
+	}
+	m := map[string]any{
+		"seq":    e.Seq,
+		"repo":   e.Repo,
+		"commit": e.Commit.Link(),
+		"rev":    e.Rev,
+		"since":  nil,
+		"blocks": e.Blocks,
+		"ops":    ops,
+		"tooBig": e.TooBig,
+		"rebase": e.Rebase,
+		"blobs":  blobs,
+		"time":   formatTime(e.Time),
+	}
+	if e.Since != nil {
+		m["since"] = *e.Since
+	}
+	if e.PrevData != nil {
+		m["prevData"] = e.PrevData.Link()
+	}
+	return m
+}
+
+func (e *Sync) Body() map[string]any {
+	return map[string]any{"seq": e.Seq, "did": e.DID, "rev": e.Rev, "blocks": e.Blocks, "time": formatTime(e.Time)}
+}
+
+func (e *Identity) Body() map[string]any {
+	m := map[string]any{"seq": e.Seq, "did": e.DID, "time": formatTime(e.Time)}
+	if e.Handle != nil {
+		m["handle"] = *e.Handle
+	}
+	return m
+}
+
+func (e *Account) Body() map[string]any {
+	m := map[string]any{"seq": e.Seq, "did": e.DID, "active": e.Active, "time": formatTime(e.Time)}
+	if e.Status != "" {
+		m["status"] = e.Status
+	}
+	return m
+}
+
+func (e *Info) Body() map[string]any {
+	m := map[string]any{"name": e.Name}
+	if e.Message != "" {
+		m["message"] = e.Message
+	}
+	return m
+}
+
+// Encodes an event as a message frame.
+func EncodeEvent(e Event) ([]byte, error) {
+	return EncodeMessage(e.Type(), e.Body())
+}
+
+var (
+	registryMtx sync.RWMutex
+	registry    = map[string]func(body map[string]any) (Event, error){
+		TypeCommit:   decodeCommit,
+		TypeSync:     decodeSync,
+		TypeIdentity: decodeIdentity,
+		TypeAccount:  decodeAccount,
+		TypeInfo:     decodeInfo,
+	}
+)
+
+// Registers the decoder of a message type, replacing any previous one, so that Frame.Event returns its typed
+// events. The types of the repository event stream are registered by default.
+func Register(typ string, decode func(body map[string]any) (Event, error)) {
+	registryMtx.Lock()
+	defer registryMtx.Unlock()
+	registry[typ] = decode
+}
+
+// Decodes the body of a message frame with the decoder registered for its type, or returns ErrUnknownType.
+// Body fields unknown to the decoder are ignored.
+func (f *Frame) Event() (Event, error) {
+	registryMtx.RLock()
+	decode, ok := registry[f.Header.Type]
+	registryMtx.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownType, f.Header.Type)
+	}
+	e, err := decode(f.Body)
+	if err != nil {
+		return nil, fmt.Errorf("decoding %s message: %w", f.Header.Type, err)
+	}
+	return e, nil
+}
+
+// reads typed fields of a body, keeping the first error
+type fields struct {
+	m   map[string]any
+	err error
+}
+
+func (f *fields) fail(key, want string) {
+	if f.err == nil {
+		f.err = fmt.Errorf("field %s is not %s", key, want)
+	}
+}
+
+func (f *fields) str(key string) string {
+	s, ok := f.m[key].(string)
+	if !ok {
+		f.fail(key, "a string")
+	}
+	return s
+}
+
+func (f *fields) optStr(key string) *string {
+	if f.m[key] == nil {
+		return nil
+	}
+	s := f.str(key)
+	return &s
+}
+
+func (f *fields) int(key string) int64 {
+	n, ok := f.m[key].(uint64)
+	if !ok || n > 1<<63-1 {
+		f.fail(key, "a positive integer")
+	}
+	return int64(n)
+}
+
+func (f *fields) bool(key string) bool {
+	if f.m[key] == nil {
+		return false
+	}
+	b, ok := f.m[key].(bool)
+	if !ok {
+		f.fail(key, "a boolean")
+	}
+	return b
+}
+
+func (f *fields) bytes(key string) []byte {
+	b, ok := f.m[key].([]byte)
+	if !ok {
+		f.fail(key, "bytes")
+	}
+	return b
+}
+
+func (f *fields) link(key string) cid.Cid {
+	c, err := toCid(f.m[key])
+	if err != nil {
+		f.fail(key, "a link")
+	}
+	return c
+}
+
+func (f *fields) optLink(key string) *cid.Cid {
+	if f.m[key] == nil {
+		return nil
+	}
+	c := f.link(key)
+	return &c
+}
+
+func (f *fields) list(key string) []any {
+	if f.m[key] == nil {
+		return nil
+	}
+	l, ok := f.m[key].([]any)
+	if !ok {
+		f.fail(key, "a list")
+	}
+	return l
+}
+
+func (f *fields) time(key string) time.Time {
+	t, err := time.Parse(time.RFC3339Nano, f.str(key))
+	if err != nil {
+		f.fail(key, "a datetime")
+	}
+	return t
+}
+
+func toCid(v any) (cid.Cid, error) {
+	link, ok := v.(cid.CidLink)
+	if !ok {
+		return cid.Cid{}, errors.New("not a link")
+	}
+	return link.Cid()
+}
+
+func optLink(c *cid.Cid) any {
+	if c == nil {
+		return nil
+	}
+	return c.Link()
+}
+
+func formatTime(t time.Time) string {
+	return t.UTC().Format("2006-01-02T15:04:05.000Z")
+}
+
+func decodeCommit(body map[string]any) (Event, error) {
+	f := fields{m: body}
+	e := &Commit{
+		Seq:      f.int("seq"),
+		Repo:     f.str("repo"),
+		Commit:   f.link("commit"),
+		Rev:      f.str("rev"),
+		Since:    f.optStr("since"),
+		Blocks:   f.bytes("blocks"),
+		PrevData: f.optLink("prevData"),
+		TooBig:   f.bool("tooBig"),
+		Rebase:   f.bool("rebase"),
+		Time:     f.time("time"),
+	}
+	for _, v := range f.list("ops") {
+		m, ok := v.(map[string]any)
+		if !ok {
+			return nil, errors.New("commit op is not a map")
+		}
+		opFields := fields{m: m}
+		op := repo.Op{
+			Action: repo.Action(opFields.str("action")),
+			Path:   opFields.str("path"),
+			Cid:    opFields.optLink("cid"),
+			Prev:   opFields.optLink("prev"),
+		}
+		if opFields.err != nil {
+			return nil, fmt.Errorf("invalid commit op: %w", opFields.err)
+		}
+		e.Ops = append(e.Ops, op)
+	}
+	for _, v := range f.list("blobs") {
+		c, err := toCid(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid commit blob: %w", err)
+		}
+		e.Blobs = append(e.Blobs, c)
+	}
+	return e, f.err
+}
+
+func decodeSync(body map[string]any) (Event, error) {
+	f := fields{m: body}
+	e := &Sync{Seq: f.int("seq"), DID: f.str("did"), Rev: f.str("rev"), Blocks: f.bytes("blocks"), Time: f.time("time")}
+	return e, f.err
+}
+
+func decodeIdentity(body map[string]any) (Event, error) {
+	f := fields{m: body}
+	e := &Identity{Seq: f.int("seq"), DID: f.str("did"), Handle: f.optStr("handle"), Time: f.time("time")}
+	return e, f.err
+}
+
+func decodeAccount(body map[string]any) (Event, error) {
+	f := fields{m: body}
+	e := &Account{Seq: f.int("seq"), DID: f.str("did"), Active: f.bool("active"), Time: f.time("time")}
+	if p := f.optStr("status"); p != nil {
+		e.Status = *p
+	}
+	return e, f.err
+}
+
+func decodeInfo(body map[string]any) (Event, error) {
+	f := fields{m: body}
+	e := &Info{Name: f.str("name")}
+	if p := f.optStr("message"); p != nil {
+		e.Message = *p
+	}
+	return e, f.err
+}
@@ -0,0 +1,263 @@
+package events
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/notjuliet/grove/blockstore"
+	"github.com/notjuliet/grove/car"
+	"github.com/notjuliet/grove/cid"
+	"github.com/notjuliet/grove/crypto"
+	"github.com/notjuliet/grove/limits"
+	"github.com/notjuliet/grove/mst"
+	"github.com/notjuliet/grove/repo"
+)
+
+// Limits of #commit messages set by the sync protocol.
+const (
+	MaxCommitOps    = limits.MaxCommitOps
+	MaxCommitBlocks = limits.MaxSliceSize
+)
+
+var (
+	// The event does not prove its operations relative to the previous state of the repository, so it
+	// cannot be applied without fetching the repository again.
+	ErrNonInductive = errors.New("commit is not inductive")
+	// The event exceeds the limits of the validator or is flagged tooBig. Errors exceeding limits also match
+	// limits.ErrExceeded.
+	ErrTooBig = errors.New("event is too large")
+)
+
+// Last validated state of a repository.
+type RepoState struct {
+	Rev string
+	// MST root of the commit.
+	Data cid.Cid
+}
+
+// Validates the #commit and #sync events of a single repository in sequence, as a relay does under sync v1.1:
+// each commit must be signed by the account, follow the last known rev and data root, and carry covering
+// proofs from which inverting its operations yields exactly the previous data root.
+//
+// It is safe for concurrent use, though events of one repository are meant to be validated in order.
+type Validator struct {
+	// Limits enforced on events, limits.Network by default. They must not be changed once validation started.
+	Limits limits.Limits
+
+	did string
+
+	mtx   sync.Mutex
+	state *RepoState
+}
+
+// Creates a validator for the repository of did, starting from a known state, or from nil when nothing is
+// known yet, in which case the first commit is only checked against its own prevData.
+func NewValidator(did string, state *RepoState) *Validator {
+	v := &Validator{did: did, Limits: limits.Network}
+	if state != nil {
+		s := *state
+		v.state = &s
+	}
+	return v
+}
+
+// Returns the last validated state, if any.
+func (v *Validator) State() (RepoState, bool) {
+	v.mtx.Lock()
+	defer v.mtx.Unlock()
+	if v.state == nil {
+		return RepoState{}, false
+	}
+	return *v.state, true
+}
+
+// Validates a #commit event against the account's signing key and, if it is valid, advances the state to
+// it. Events which cannot be validated inductively fail with ErrNonInductive, and oversized ones with
+// ErrTooBig; the state is left unchanged on any error.
+func (v *Validator) ValidateCommit(ctx context.Context, e *Commit, pub crypto.PublicKey) error {
+	if e.Repo != v.did {
+		return fmt.Errorf("commit event is for %s, not %s", e.Repo, v.did)
+	}
+	if e.TooBig {
+		return fmt.Errorf("%w: flagged tooBig", ErrTooBig)
+	}
+	if err := limits.Check(len(e.Ops), v.Limits.MaxCommitOps, "ops"); err != nil {
+		return fmt.Errorf("%w: %w", ErrTooBig, err)
+	}
+	if err := limits.Check(len(e.Blocks), v.Limits.MaxSliceSize, "bytes of blocks"); err != nil {
+		return fmt.Errorf("%w: %w", ErrTooBig, err)
+	}
+	bs, commit, err := v.readSlice(ctx, e.Blocks, e.Commit, e.Rev, pub)
+	if err != nil {
+		return err
+	}
+
+	v.mtx.Lock()
+	defer v.mtx.Unlock()
+	if v.state != nil {
+		if commit.Rev <= v.state.Rev {
+			return fmt.Errorf("commit rev %s is not newer than %s", commit.Rev, v.state.Rev)
+		}
+		if e.Since == nil || *e.Since != v.state.Rev {
+			return fmt.Errorf("%w: since does not match the last rev %s", ErrNonInductive, v.state.Rev)
+		}
+		if e.PrevData == nil || !bytes.Equal(e.PrevData.Bytes, v.state.Data.Bytes) {
+			return fmt.Errorf("%w: prevData does not match the last data root %s", ErrNonInductive, v.state.Data)
+		}
+	}
+	if err := invertOps(ctx, bs, commit.Data, e.Ops, e.PrevData); err != nil {
+		return err
+	}
+	v.state = &RepoState{Rev: commit.Rev, Data: commit.Data}
+	return nil
+}
+
+// Validates a #sync event, which resets the repository to a new commit without proving any operation, and
+// if it is valid, sets the state to it.
+func (v *Validator) ValidateSync(ctx context.Context, e *Sync, pub crypto.PublicKey) error {
+	if e.DID != v.did {
+		return fmt.Errorf("sync event is for %s, not %s", e.DID, v.did)
+	}
+	if err := limits.Check(len(e.Blocks), v.Limits.MaxSliceSize, "bytes of blocks"); err != nil {
+		return fmt.Errorf("%w: %w", ErrTooBig, err)
+	}
+	r, err := car.NewReader(bytes.NewReader(e.Blocks))
+	if err != nil {
+		return fmt.Errorf("reading sync blocks: %w", err)
+	}
+	if len(r.Roots) != 1 {
+		return errors.New("sync blocks must have a single root")
+	}
+	_, commit, err := v.readSlice(ctx, e.Blocks, r.Roots[0], e.Rev, pub)
+	if err != nil {
+		return err
+	}
+
+	v.mtx.Lock()
+	defer v.mtx.Unlock()
+	if v.state != nil && commit.Rev <= v.state.Rev {
+		return fmt.Errorf("sync rev %s is not newer than %s", commit.Rev, v.state.Rev)
+	}
+	v.state = &RepoState{Rev: commit.Rev, Data: commit.Data}
+	return nil
+}
+
+// loads a CAR slice rooted at a commit into memory, checking the commit and its signature
+func (v *Validator) readSlice(ctx context.Context, b []byte, root cid.Cid, rev string, pub crypto.PublicKey) (blockstore.Blockstore, repo.Commit, error) {
+	bs, err := readBlocks(ctx, b, root, v.Limits)
+	if err != nil {
+		return nil, repo.Commit{}, err
+	}
+	data, err := bs.Get(ctx, root)
+	if err != nil {
+		return nil, repo.Commit{}, fmt.Errorf("fetching commit block: %w", err)
+	}
+	commit, err := repo.DecodeCommit(data)
+	if err != nil {
+		return nil, repo.Commit{}, err
+	}
+	if commit.DID != v.did {
+		return nil, repo.Commit{}, fmt.Errorf("commit is signed for %s, not %s", commit.DID, v.did)
+	}
+	if commit.Rev != rev {
+		return nil, repo.Commit{}, fmt.Errorf("event rev %s does not match the commit rev %s", rev, commit.Rev)
+	}
+	if err := repo.VerifyCommitSignature(commit, pub); err != nil {
+		return nil, repo.Commit{}, fmt.Errorf("invalid commit signature: %w", err)
+	}
+	return bs, commit, nil
+}
+
+// loads the CAR slice of an event into memory, checking that it is rooted at root and that each block fits in
+// the block size limit and matches its CID
+func readBlocks(ctx context.Context, b []byte, root cid.Cid, l limits.Limits) (*blockstore.MemoryBlockstore, error) {
+	r, err := car.NewReaderWith(bytes.NewReader(b), car.ReaderOptions{MaxBlockSize: l.MaxBlockSize})
+	if err != nil {
+		return nil, fmt.Errorf("reading event blocks: %w", err)
+	}
+	if len(r.Roots) != 1 || !bytes.Equal(r.Roots[0].Bytes, root.Bytes) {
+		return nil, errors.New("event blocks are not rooted at the commit")
+	}
+	bs := blockstore.NewMemoryBlockstore()
+	for {
+		blk, err := r.Next()
+		if err == io.EOF {
+			return bs, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading event blocks: %w", err)
+		}
+		computed, err := cid.Create(blk.Cid.Codec, blk.Data)
+		if err != nil || !bytes.Equal(computed.Bytes, blk.Cid.Bytes) {
+			return nil, fmt.Errorf("block %s does not match its CID", blk.Cid)
+		}
+		if err := bs.Put(ctx, blk.Cid, blk.Data); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// checks that the tree at data holds the result of each operation, then undoes them and checks that the
+// resulting root is prevData, or the empty tree when prevData is nil
+func invertOps(ctx context.Context, bs blockstore.Blockstore, data cid.Cid, ops []repo.Op, prevData *cid.Cid) error {
+	tree, err := mst.Load(ctx, bs, data)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrNonInductive, err)
+	}
+	seen := make(map[string]bool, len(ops))
+	for _, op := range ops {
+		if seen[op.Path] {
+			return fmt.Errorf("duplicate operation on %s", op.Path)
+		}
+		seen[op.Path] = true
+
+		val, found, err := tree.Get(ctx, op.Path)
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrNonInductive, err)
+		}
+		switch op.Action {
+		case repo.ActionCreate, repo.ActionUpdate:
+			if op.Cid == nil || !found || !bytes.Equal(val.Bytes, op.Cid.Bytes) {
+				return fmt.Errorf("%w: %s %s is not proven by the commit", ErrNonInductive, op.Action, op.Path)
+			}
+			if (op.Action == repo.ActionCreate) != (op.Prev == nil) {
+				return fmt.Errorf("%w: %s %s has an invalid prev", ErrNonInductive, op.Action, op.Path)
+			}
+		case repo.ActionDelete:
+			if op.Cid != nil || found || op.Prev == nil {
+				return fmt.Errorf("%w: delete %s is not proven by the commit", ErrNonInductive, op.Path)
+			}
+		default:
+			return fmt.Errorf("unknown operation action %q", op.Action)
+		}
+
+		if op.Prev == nil {
+			err = tree.Delete(ctx, op.Path)
+		} else {
+			err = tree.Insert(ctx, op.Path, *op.Prev)
+		}
+		if err != nil {
+			return fmt.Errorf("%w: inverting %s %s: %w", ErrNonInductive, op.Action, op.Path, err)
+		}
+	}
+
+	root, err := tree.Root(ctx)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrNonInductive, err)
+	}
+	want, err := mst.New(bs).Root(ctx)
+	if err != nil {
+		return err
+	}
+	if prevData != nil {
+		want = *prevData
+	}
+	if !bytes.Equal(root.Bytes, want.Bytes) {
+		return fmt.Errorf("%w: inverted operations give data root %s, not %s", ErrNonInductive, root, want)
+	}
+	return nil
+}
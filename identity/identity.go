@@ -0,0 +1,124 @@
+package identity
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/notjuliet/grove/crypto"
+)
+
+// Resolves DIDs to their current identity metadata.
+type Directory interface {
+	LookupDID(ctx context.Context, did string) (*Identity, error)
+}
+
+// Identity metadata extracted from a DID document.
+type Identity struct {
+	DID string
+	// Handle declared in the DID document. It is not verified against the handle's own resolution.
+	Handle string
+	// Verification methods, keyed by fragment without the leading '#' (e.g. "atproto").
+	Keys map[string]VerificationMethod
+	// Service endpoints, keyed by fragment without the leading '#' (e.g. "atproto_pds").
+	Services map[string]Service
+}
+
+type VerificationMethod struct {
+	Type               string
+	PublicKeyMultibase string
+}
+
+type Service struct {
+	Type string
+	URL  string
+}
+
+var ErrKeyNotFound = errors.New("identity has no matching verification method")
+
+// Returns the public key for the verification method with the given fragment (e.g. "atproto_label").
+func (i *Identity) GetPublicKey(id string) (crypto.PublicKey, error) {
+	k, ok := i.Keys[id]
+	if !ok {
+		return nil, ErrKeyNotFound
+	}
+	if k.Type != "Multikey" {
+		return nil, errors.New("unsupported verification method type: " + k.Type)
+	}
+	return crypto.ParsePublicMultibase(k.PublicKeyMultibase)
+}
+
+// Returns the atproto repository signing key.
+func (i *Identity) PublicKey() (crypto.PublicKey, error) {
+	return i.GetPublicKey("atproto")
+}
+
+// Returns the URL of the service endpoint with the given fragment and type, or an empty string.
+func (i *Identity) GetServiceEndpoint(id, typ string) string {
+	s, ok := i.Services[id]
+	if !ok || s.Type != typ {
+		return ""
+	}
+	return s.URL
+}
+
+// Returns the URL of the account's PDS, or an empty string.
+func (i *Identity) PDSEndpoint() string {
+	return i.GetServiceEndpoint("atproto_pds", "AtprotoPersonalDataServer")
+}
+
+// DID document, limited to the fields used by atproto.
+type DIDDocument struct {
+	ID                 string            `json:"id"`
+	AlsoKnownAs        []string          `json:"alsoKnownAs,omitempty"`
+	VerificationMethod []DocVerification `json:"verificationMethod,omitempty"`
+	Service            []DocService      `json:"service,omitempty"`
+}
+
+type DocVerification struct {
+	ID                 string `json:"id"`
+	Type               string `json:"type"`
+	Controller         string `json:"controller"`
+	PublicKeyMultibase string `json:"publicKeyMultibase,omitempty"`
+}
+
+type DocService struct {
+	ID              string `json:"id"`
+	Type            string `json:"type"`
+	ServiceEndpoint string `json:"serviceEndpoint"`
+}
+
+// Extracts identity metadata from a DID document.
+func ParseIdentity(doc *DIDDocument) *Identity {
+	ident := &Identity{
+		DID:      doc.ID,
+		Keys:     map[string]VerificationMethod{},
+		Services: map[string]Service{},
+	}
+	for _, aka := range doc.AlsoKnownAs {
+		if h, ok := strings.CutPrefix(aka, "at://"); ok {
+			ident.Handle = strings.ToLower(h)
+			break
+		}
+	}
+	for _, vm := range doc.VerificationMethod {
+		if id, ok := fragment(doc.ID, vm.ID); ok {
+			ident.Keys[id] = VerificationMethod{vm.Type, vm.PublicKeyMultibase}
+		}
+	}
+	for _, s := range doc.Service {
+		if id, ok := fragment(doc.ID, s.ID); ok {
+			ident.Services[id] = Service{s.Type, s.ServiceEndpoint}
+		}
+	}
+	return ident
+}
+
+// ids can be relative ("#atproto") or absolute ("did:plc:abc#atproto")
+func fragment(did, id string) (string, bool) {
+	base, frag, ok := strings.Cut(id, "#")
+	if !ok || frag == "" || (base != "" && base != did) {
+		return "", false
+	}
+	return frag, true
+}
@@ -0,0 +1,147 @@
+package identity
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/notjuliet/grove/crypto"
+)
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// returns a client which serves every request with h, recording the requested URLs
+func testClient(h http.HandlerFunc, urls *[]string) *http.Client {
+	return &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		*urls = append(*urls, req.URL.String())
+		w := httptest.NewRecorder()
+		h(w, req)
+		return w.Result(), nil
+	})}
+}
+
+func TestParseIdentity(t *testing.T) {
+	key, err := crypto.GenerateK256()
+	if err != nil {
+		t.Fatal(err)
+	}
+	did := "did:plc:ewvi7nxzyoun6zhxrhs64oiz"
+	doc := &DIDDocument{
+		ID:          did,
+		AlsoKnownAs: []string{"https://example.com", "at://Alice.Example.com", "at://bob.example.com"},
+		VerificationMethod: []DocVerification{
+			{ID: did + "#atproto", Type: "Multikey", Controller: did, PublicKeyMultibase: key.PublicKey().Multibase()},
+			{ID: "#atproto_label", Type: "EcdsaSecp256k1VerificationKey2019", Controller: did},
+			{ID: "did:plc:other#foreign", Type: "Multikey", Controller: did},
+			{ID: did, Type: "Multikey", Controller: did},
+			{ID: did + "#", Type: "Multikey", Controller: did},
+		},
+		Service: []DocService{
+			{ID: "#atproto_pds", Type: "AtprotoPersonalDataServer", ServiceEndpoint: "https://pds.example.com"},
+			{ID: did + "#atproto_labeler", Type: "AtprotoLabeler", ServiceEndpoint: "https://labeler.example.com"},
+		},
+	}
+	ident := ParseIdentity(doc)
+
+	if ident.DID != did || ident.Handle != "alice.example.com" {
+		t.Fatalf("unexpected identity %s, %s", ident.DID, ident.Handle)
+	}
+	if len(ident.Keys) != 2 {
+		t.Fatalf("unexpected keys %v", ident.Keys)
+	}
+	pub, err := ident.PublicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pub.Multibase() != key.PublicKey().Multibase() {
+		t.Fatal("unexpected public key")
+	}
+	if _, err := ident.GetPublicKey("atproto_label"); err == nil {
+		t.Fatal("expected unsupported verification method error")
+	}
+	if _, err := ident.GetPublicKey("foreign"); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("expected key not found error, got %v", err)
+	}
+
+	if got := ident.PDSEndpoint(); got != "https://pds.example.com" {
+		t.Fatalf("unexpected PDS endpoint %q", got)
+	}
+	if got := ident.GetServiceEndpoint("atproto_labeler", "AtprotoLabeler"); got != "https://labeler.example.com" {
+		t.Fatalf("unexpected labeler endpoint %q", got)
+	}
+	if got := ident.GetServiceEndpoint("atproto_labeler", "AtprotoPersonalDataServer"); got != "" {
+		t.Fatalf("unexpected endpoint %q for mismatched type", got)
+	}
+}
+
+func TestBaseDirectory(t *testing.T) {
+	ctx := context.Background()
+	docs := map[string]string{
+		"https://plc.example.com/did:plc:alice":               `{"id":"did:plc:alice","alsoKnownAs":["at://alice.test"]}`,
+		"https://web.example.com/.well-known/did.json":        `{"id":"did:web:web.example.com"}`,
+		"https://plc.example.com/did:plc:impostor":            `{"id":"did:plc:alice"}`,
+		"https://plc.example.com/did:plc:broken":              `{"id":`,
+		"https://plc.example.com/did:plc:unavailable":         "",
+		"https://other.example.com/.well-known/did.json":      `{"id":"did:web:web.example.com"}`,
+		"https://tombstoned.example.com/.well-known/did.json": "",
+	}
+	var urls []string
+	d := &BaseDirectory{
+		PLCURL: "https://plc.example.com/",
+		HTTPClient: testClient(func(w http.ResponseWriter, r *http.Request) {
+			doc, ok := docs[r.URL.String()]
+			switch {
+			case !ok:
+				http.NotFound(w, r)
+			case doc == "" && r.URL.Host == "tombstoned.example.com":
+				w.WriteHeader(http.StatusGone)
+			case doc == "":
+				w.WriteHeader(http.StatusInternalServerError)
+			default:
+				w.Write([]byte(doc))
+			}
+		}, &urls),
+	}
+
+	ident, err := d.LookupDID(ctx, "did:plc:alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ident.DID != "did:plc:alice" || ident.Handle != "alice.test" {
+		t.Fatalf("unexpected identity %s, %s", ident.DID, ident.Handle)
+	}
+	if ident, err = d.LookupDID(ctx, "did:web:web.example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if ident.DID != "did:web:web.example.com" {
+		t.Fatalf("unexpected identity %s", ident.DID)
+	}
+	want := []string{"https://plc.example.com/did:plc:alice", "https://web.example.com/.well-known/did.json"}
+	if len(urls) != 2 || urls[0] != want[0] || urls[1] != want[1] {
+		t.Fatalf("unexpected requests %q", urls)
+	}
+
+	for _, did := range []string{"did:plc:missing", "did:web:missing.example.com", "did:web:tombstoned.example.com"} {
+		if _, err := d.LookupDID(ctx, did); !errors.Is(err, ErrDIDNotFound) {
+			t.Errorf("%s: expected DID not found error, got %v", did, err)
+		}
+	}
+	urls = nil
+	for _, did := range []string{"did:plc:impostor", "did:plc:broken", "did:plc:unavailable",
+		"did:web:other.example.com", "did:web:localhost%3A8080", "did:web:example.com:user:alice", "did:web:",
+		"did:key:z6MkhaXgBZDvotDkL5257faiztiGiC2QtKLGpbnnEGta2doK", "plc:alice"} {
+		if _, err := d.LookupDID(ctx, did); err == nil || errors.Is(err, ErrDIDNotFound) {
+			t.Errorf("%s: expected resolution error, got %v", did, err)
+		}
+	}
+	// unsupported identifiers are rejected without a request
+	if len(urls) != 4 {
+		t.Fatalf("unexpected requests %q", urls)
+	}
+}
@@ -0,0 +1,109 @@
+package identity
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const DefaultPLCURL = "https://plc.directory"
+
+// maximum size of a DID document response body
+const maxDocSize = 64 * 1024
+
+var ErrDIDNotFound = errors.New("DID not found")
+
+// Directory which resolves did:plc and did:web identifiers over HTTP, without caching.
+type BaseDirectory struct {
+	// HTTP client used for resolution, defaults to http.DefaultClient.
+	HTTPClient *http.Client
+	// Base URL of the PLC directory, defaults to DefaultPLCURL.
+	PLCURL string
+	// Logger for resolutions, if set.
+	Logger *slog.Logger
+}
+
+func (d *BaseDirectory) LookupDID(ctx context.Context, did string) (*Identity, error) {
+	doc, err := d.ResolveDID(ctx, did)
+	if err != nil {
+		return nil, err
+	}
+	return ParseIdentity(doc), nil
+}
+
+// Fetches the DID document for a did:plc or did:web identifier.
+func (d *BaseDirectory) ResolveDID(ctx context.Context, did string) (*DIDDocument, error) {
+	if d.Logger == nil {
+		return d.resolveDID(ctx, did)
+	}
+	start := time.Now()
+	doc, err := d.resolveDID(ctx, did)
+	switch {
+	case errors.Is(err, ErrDIDNotFound):
+		d.Logger.Debug("DID not found", "did", did, "duration", time.Since(start))
+	case err != nil:
+		d.Logger.Warn("DID resolution failed", "did", did, "duration", time.Since(start), "error", err)
+	default:
+		d.Logger.Debug("resolved DID", "did", did, "duration", time.Since(start))
+	}
+	return doc, err
+}
+
+func (d *BaseDirectory) resolveDID(ctx context.Context, did string) (*DIDDocument, error) {
+	var docURL string
+	switch {
+	case strings.HasPrefix(did, "did:plc:"):
+		base := d.PLCURL
+		if base == "" {
+			base = DefaultPLCURL
+		}
+		docURL = strings.TrimSuffix(base, "/") + "/" + did
+	case strings.HasPrefix(did, "did:web:"):
+		host, err := url.PathUnescape(did[len("did:web:"):])
+		if err != nil || host == "" || strings.Contains(host, ":") || strings.Contains(host, "/") {
+			return nil, fmt.Errorf("unsupported did:web identifier: %s", did)
+		}
+		docURL = "https://" + host + "/.well-known/did.json"
+	default:
+		return nil, fmt.Errorf("unsupported DID method: %s", did)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, docURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/did+ld+json, application/json")
+
+	client := d.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("resolving %s: %w", did, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone {
+		return nil, fmt.Errorf("resolving %s: %w", did, ErrDIDNotFound)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("resolving %s: unexpected HTTP status %d", did, resp.StatusCode)
+	}
+
+	var doc DIDDocument
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxDocSize)).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("parsing DID document for %s: %w", did, err)
+	}
+	if doc.ID != did {
+		return nil, fmt.Errorf("DID document id %q does not match %s", doc.ID, did)
+	}
+	return &doc, nil
+}
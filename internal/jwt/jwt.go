@@ -0,0 +1,88 @@
+package jwt
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/notjuliet/grove/crypto"
+)
+
+var b64 = base64.RawURLEncoding
+
+type Header struct {
+	Alg string `json:"alg"`
+	Typ string `json:"typ,omitempty"`
+	Kid string `json:"kid,omitempty"`
+	JWK any    `json:"jwk,omitempty"`
+}
+
+// Parsed but unverified token.
+type Token struct {
+	Header    Header
+	RawHeader json.RawMessage
+	Claims    json.RawMessage
+	Signature []byte
+	// header and payload segments, as covered by the signature
+	signingInput string
+}
+
+// Serializes and signs a token. The header "alg" is filled in from the signer.
+func Sign(ctx context.Context, signer crypto.Signer, header Header, claims any) (string, error) {
+	header.Alg = signer.Algorithm()
+	h, err := json.Marshal(header)
+	if err != nil {
+		return "", err
+	}
+	c, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	input := b64.EncodeToString(h) + "." + b64.EncodeToString(c)
+	sig, err := crypto.Sign(ctx, signer, []byte(input))
+	if err != nil {
+		return "", fmt.Errorf("signing token: %w", err)
+	}
+	return input + "." + b64.EncodeToString(sig), nil
+}
+
+// Splits and decodes a compact token without verifying the signature.
+func Parse(token string) (*Token, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed token")
+	}
+	h, err := b64.DecodeString(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("decoding token header: %w", err)
+	}
+	c, err := b64.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("decoding token claims: %w", err)
+	}
+	sig, err := b64.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("decoding token signature: %w", err)
+	}
+	t := &Token{RawHeader: h, Claims: c, Signature: sig, signingInput: parts[0] + "." + parts[1]}
+	if err := json.Unmarshal(h, &t.Header); err != nil {
+		return nil, fmt.Errorf("parsing token header: %w", err)
+	}
+	return t, nil
+}
+
+// Checks the token signature against a public key, including that the header "alg" matches the key type.
+func (t *Token) Verify(pub crypto.PublicKey) error {
+	if t.Header.Alg != pub.Algorithm() {
+		return fmt.Errorf("token algorithm %q does not match key type %s", t.Header.Alg, pub.Algorithm())
+	}
+	return crypto.Verify(pub, []byte(t.signingInput), t.Signature)
+}
+
+// Unmarshals the token claims.
+func (t *Token) DecodeClaims(v any) error {
+	return json.Unmarshal(t.Claims, v)
+}
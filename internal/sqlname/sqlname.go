@@ -0,0 +1,20 @@
+// Package sqlname checks the table names which the SQL-backed stores interpolate into their statements.
+package sqlname
+
+import "fmt"
+
+// Fails unless name is a plain SQL identifier: an ASCII letter or underscore followed by letters, digits and
+// underscores, which needs no quoting and cannot inject SQL.
+func CheckTable(name string) error {
+	if name == "" {
+		return fmt.Errorf("invalid table name %q", name)
+	}
+	for i := range len(name) {
+		c := name[i]
+		if c == '_' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || i > 0 && '0' <= c && c <= '9' {
+			continue
+		}
+		return fmt.Errorf("invalid table name %q", name)
+	}
+	return nil
+}
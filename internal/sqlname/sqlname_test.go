@@ -0,0 +1,17 @@
+package sqlname
+
+import "testing"
+
+func TestCheckTable(t *testing.T) {
+	for _, name := range []string{"blocks", "_cursors", "Records2", "a_b_c"} {
+		if err := CheckTable(name); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+	for _, name := range []string{"", "2blocks", "blocks; DROP TABLE x", "my-table", "schema.table", "tablé",
+		`"blocks"`} {
+		if err := CheckTable(name); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
@@ -0,0 +1,171 @@
+// Package sqltest provides a database/sql driver for testing the SQL-backed stores without a database engine. It
+// records the statements it is given and leaves answering them to the test, which checks that they are exactly the
+// statements it expects.
+package sqltest
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Statement received by a Recorder.
+type Stmt struct {
+	Query string
+	Args  []driver.Value
+	// Transaction the statement ran in, counting from 1, or 0 outside transactions.
+	Tx int
+}
+
+// Answers a statement with the rows of a query, or nil for statements returning none.
+type Handler func(s Stmt) ([][]driver.Value, error)
+
+// Minimal database/sql driver which records the statements it is given and answers them with a handler, which
+// holds the data and checks the statements it expects.
+type Recorder struct {
+	handle Handler
+	// Called when a transaction ends, with whether it was committed, so that the handler can apply or discard
+	// the statements it ran.
+	EndTx func(tx int, commit bool)
+
+	mtx   sync.Mutex
+	stmts []Stmt
+	txs   int
+}
+
+func NewRecorder(h Handler) *Recorder {
+	return &Recorder{handle: h}
+}
+
+// Returns a handle to the driver.
+func (r *Recorder) Open() *sql.DB {
+	return sql.OpenDB(recorderConnector{r})
+}
+
+// Returns the statements received so far, in order.
+func (r *Recorder) Statements() []Stmt {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	return slices.Clone(r.stmts)
+}
+
+func (r *Recorder) run(query string, args []driver.Value, tx int) ([][]driver.Value, error) {
+	s := Stmt{Query: query, Args: args, Tx: tx}
+	r.mtx.Lock()
+	r.stmts = append(r.stmts, s)
+	r.mtx.Unlock()
+	return r.handle(s)
+}
+
+// Rewrites the "?" parameters of a statement as "$1", "$2" and so on, for comparing statements in both styles.
+func Numbered(query string) string {
+	var b strings.Builder
+	n := 0
+	for _, c := range query {
+		if c != '?' {
+			b.WriteRune(c)
+			continue
+		}
+		n++
+		b.WriteString("$" + strconv.Itoa(n))
+	}
+	return b.String()
+}
+
+type recorderConnector struct{ r *Recorder }
+
+func (c recorderConnector) Connect(context.Context) (driver.Conn, error) {
+	return &recorderConn{r: c.r}, nil
+}
+
+func (c recorderConnector) Driver() driver.Driver {
+	return recorderDriver{c.r}
+}
+
+type recorderDriver struct{ r *Recorder }
+
+func (d recorderDriver) Open(string) (driver.Conn, error) { return &recorderConn{r: d.r}, nil }
+
+// connections are used by one goroutine at a time
+type recorderConn struct {
+	r *Recorder
+	// current transaction, 0 for none
+	tx int
+}
+
+func (c *recorderConn) Prepare(query string) (driver.Stmt, error) {
+	return &recorderStmt{c: c, query: query}, nil
+}
+
+func (c *recorderConn) Close() error { return nil }
+
+func (c *recorderConn) Begin() (driver.Tx, error) {
+	c.r.mtx.Lock()
+	c.r.txs++
+	c.tx = c.r.txs
+	c.r.mtx.Unlock()
+	return c, nil
+}
+
+func (c *recorderConn) Commit() error   { return c.end(true) }
+func (c *recorderConn) Rollback() error { return c.end(false) }
+
+func (c *recorderConn) end(commit bool) error {
+	tx := c.tx
+	c.tx = 0
+	if c.r.EndTx != nil {
+		c.r.EndTx(tx, commit)
+	}
+	return nil
+}
+
+type recorderStmt struct {
+	c     *recorderConn
+	query string
+}
+
+func (s *recorderStmt) Close() error  { return nil }
+func (s *recorderStmt) NumInput() int { return -1 }
+
+func (s *recorderStmt) Exec(args []driver.Value) (driver.Result, error) {
+	if _, err := s.c.r.run(s.query, args, s.c.tx); err != nil {
+		return nil, err
+	}
+	return driver.RowsAffected(1), nil
+}
+
+func (s *recorderStmt) Query(args []driver.Value) (driver.Rows, error) {
+	rows, err := s.c.r.run(s.query, args, s.c.tx)
+	if err != nil {
+		return nil, err
+	}
+	return &recorderRows{rows: rows}, nil
+}
+
+type recorderRows struct {
+	rows [][]driver.Value
+}
+
+// only the number of columns is checked by Scan
+func (r *recorderRows) Columns() []string {
+	if len(r.rows) == 0 {
+		return nil
+	}
+	return make([]string, len(r.rows[0]))
+}
+
+func (r *recorderRows) Close() error { return nil }
+
+func (r *recorderRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
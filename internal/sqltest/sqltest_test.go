@@ -0,0 +1,79 @@
+package sqltest
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRecorder(t *testing.T) {
+	ctx := context.Background()
+	var ended []string
+	rec := NewRecorder(func(s Stmt) ([][]driver.Value, error) {
+		if strings.HasPrefix(s.Query, "SELECT") {
+			return [][]driver.Value{{"a", int64(1)}, {"b", int64(2)}}, nil
+		}
+		if s.Query == "FAIL" {
+			return nil, errors.New("injected")
+		}
+		return nil, nil
+	})
+	rec.EndTx = func(tx int, commit bool) { ended = append(ended, fmt.Sprint(tx, commit)) }
+	db := rec.Open()
+	defer db.Close()
+
+	if _, err := db.ExecContext(ctx, "INSERT x", "k", 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.ExecContext(ctx, "FAIL"); err == nil {
+		t.Fatal("expected handler error")
+	}
+	for _, commit := range []bool{true, false} {
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tx.ExecContext(ctx, "DELETE x"); err != nil {
+			t.Fatal(err)
+		}
+		if commit {
+			err = tx.Commit()
+		} else {
+			err = tx.Rollback()
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	rows, err := db.QueryContext(ctx, "SELECT k, n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for rows.Next() {
+		var k string
+		var n int
+		if err := rows.Scan(&k, &n); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, fmt.Sprint(k, n))
+	}
+	rows.Close()
+
+	var stmts []string
+	for _, s := range rec.Statements() {
+		stmts = append(stmts, fmt.Sprintf("%s %v %d", s.Query, s.Args, s.Tx))
+	}
+	want := []string{"INSERT x [k 1] 0", "FAIL [] 0", "DELETE x [] 1", "DELETE x [] 2", "SELECT k, n [] 0"}
+	if !reflect.DeepEqual(stmts, want) || !reflect.DeepEqual(ended, []string{"1 true", "2 false"}) ||
+		!reflect.DeepEqual(got, []string{"a1", "b2"}) {
+		t.Fatalf("unexpected statements %q, transactions %q and rows %q", stmts, ended, got)
+	}
+	if q := Numbered("INSERT INTO t (a, b) VALUES (?, ?)"); q != "INSERT INTO t (a, b) VALUES ($1, $2)" {
+		t.Fatalf("unexpected numbered statement %q", q)
+	}
+}
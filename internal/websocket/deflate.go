@@ -0,0 +1,163 @@
+package websocket
+
+import (
+	"bytes"
+	"compress/flate"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// reserved header bit marking the first frame of a compressed message
+const rsv1 = 0x40
+
+// messages shorter than this are sent uncompressed, as compression would not pay for itself
+const minCompressSize = 128
+
+// size of the sliding window of DEFLATE, which back-references may reach into
+const deflateWindow = 32 << 10
+
+// tail of a compressed message removed by the sender, followed by an empty final block so that the
+// decompressor ends cleanly
+var deflateTail = []byte{0x00, 0x00, 0xff, 0xff, 0x01, 0x00, 0x00, 0xff, 0xff}
+
+// permessage-deflate state of a connection
+//
+// https://www.rfc-editor.org/rfc/rfc7692
+type deflateState struct {
+	// whether the peer compresses messages referring to earlier ones, whose last bytes are kept in history
+	readTakeover bool
+	history      []byte
+	fr           io.ReadCloser
+
+	// whether outgoing messages are compressed, each independently
+	write bool
+	fw    *flate.Writer
+	wbuf  bytes.Buffer
+}
+
+// inflates a message, failing if it expands beyond limit bytes
+func (d *deflateState) decompress(msg []byte, limit int64) ([]byte, error) {
+	src := io.MultiReader(bytes.NewReader(msg), bytes.NewReader(deflateTail))
+	if d.fr == nil {
+		d.fr = flate.NewReaderDict(src, d.history)
+	} else if err := d.fr.(flate.Resetter).Reset(src, d.history); err != nil {
+		return nil, err
+	}
+	out, err := io.ReadAll(io.LimitReader(d.fr, limit+1))
+	if err != nil {
+		return nil, fmt.Errorf("decompressing message: %w", err)
+	}
+	if int64(len(out)) > limit {
+		return nil, errMessageTooLarge
+	}
+	if d.readTakeover {
+		d.history = append(d.history, out...)
+		if len(d.history) > deflateWindow {
+			d.history = append(d.history[:0], d.history[len(d.history)-deflateWindow:]...)
+		}
+	}
+	return out, nil
+}
+
+// deflates a message, without the tail of the final flush; the result is only valid until the next call
+func (d *deflateState) compress(msg []byte) ([]byte, error) {
+	d.wbuf.Reset()
+	if d.fw == nil {
+		fw, err := flate.NewWriter(&d.wbuf, flate.DefaultCompression)
+		if err != nil {
+			return nil, err
+		}
+		d.fw = fw
+	} else {
+		d.fw.Reset(&d.wbuf)
+	}
+	if _, err := d.fw.Write(msg); err != nil {
+		return nil, err
+	}
+	if err := d.fw.Flush(); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(d.wbuf.Bytes(), deflateTail[:4]), nil
+}
+
+var errMessageTooLarge = errors.New("message too large")
+
+// extension negotiated in a Sec-WebSocket-Extensions header
+type extension struct {
+	name   string
+	params map[string]string
+}
+
+func parseExtensions(h http.Header) []extension {
+	var exts []extension
+	for _, v := range h.Values("Sec-WebSocket-Extensions") {
+		for offer := range strings.SplitSeq(v, ",") {
+			parts := strings.Split(offer, ";")
+			ext := extension{name: strings.ToLower(strings.TrimSpace(parts[0])), params: map[string]string{}}
+			if ext.name == "" {
+				continue
+			}
+			for _, p := range parts[1:] {
+				k, v, _ := strings.Cut(p, "=")
+				ext.params[strings.ToLower(strings.TrimSpace(k))] = strings.Trim(strings.TrimSpace(v), `"`)
+			}
+			exts = append(exts, ext)
+		}
+	}
+	return exts
+}
+
+// checks the permessage-deflate extension accepted by a server, returning the state for reading its messages
+func clientDeflate(resp http.Header, offered bool) (*deflateState, error) {
+	exts := parseExtensions(resp)
+	if len(exts) == 0 {
+		return nil, nil
+	}
+	if !offered || len(exts) != 1 || exts[0].name != "permessage-deflate" {
+		return nil, errors.New("server accepted an extension which was not offered")
+	}
+	d := &deflateState{readTakeover: true}
+	for k := range exts[0].params {
+		switch k {
+		case "server_no_context_takeover":
+			d.readTakeover = false
+		case "client_no_context_takeover", "server_max_window_bits", "client_max_window_bits":
+			// the client sends uncompressed messages, and any window fits in the history
+		default:
+			return nil, fmt.Errorf("unknown permessage-deflate parameter %q", k)
+		}
+	}
+	return d, nil
+}
+
+// picks the first permessage-deflate offer of a client that can be accepted, returning the state and the
+// response header value, or nil if there is none
+func serverDeflate(req http.Header) (*deflateState, string) {
+offers:
+	for _, ext := range parseExtensions(req) {
+		if ext.name != "permessage-deflate" {
+			continue
+		}
+		d := &deflateState{readTakeover: true, write: true}
+		for k, v := range ext.params {
+			switch k {
+			case "client_no_context_takeover":
+				d.readTakeover = false
+			case "server_no_context_takeover", "client_max_window_bits":
+			case "server_max_window_bits":
+				// the compressor always uses the full window
+				if v != "15" {
+					continue offers
+				}
+			default:
+				continue offers
+			}
+		}
+		// messages are compressed independently, so that the server keeps no compression state
+		return d, "permessage-deflate; server_no_context_takeover"
+	}
+	return nil, ""
+}
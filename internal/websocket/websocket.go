@@ -0,0 +1,488 @@
+// Package websocket implements the subset of the WebSocket protocol used by atproto event streams: binary
+// and text messages, pings, closing handshakes, and the permessage-deflate compression extension.
+//
+// https://www.rfc-editor.org/rfc/rfc6455
+package websocket
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Message types, as frame opcodes.
+const (
+	TextMessage   = 1
+	BinaryMessage = 2
+	closeMessage  = 8
+	pingMessage   = 9
+	pongMessage   = 10
+)
+
+// Close status codes.
+const (
+	CloseNormal        = 1000
+	CloseGoingAway     = 1001
+	CloseProtocolError = 1002
+	CloseTooLarge      = 1009
+)
+
+// Default limit on the size of received messages.
+const DefaultMaxMessageSize = 16 << 20
+
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// time given to the peer by Close to acknowledge the closing handshake
+const closeTimeout = time.Second
+
+// Error returned by ReadMessage once the peer closed the connection.
+type CloseError struct {
+	Code   int
+	Reason string
+}
+
+func (e *CloseError) Error() string {
+	return fmt.Sprintf("websocket closed with code %d: %s", e.Code, e.Reason)
+}
+
+// Error returned by Dial when the server does not switch protocols.
+type HandshakeError struct {
+	StatusCode int
+	// Start of the response body, for error details.
+	Body []byte
+}
+
+func (e *HandshakeError) Error() string {
+	return fmt.Sprintf("websocket handshake failed with status %d: %s", e.StatusCode, e.Body)
+}
+
+// WebSocket connection. Reads must come from a single goroutine; writes are safe for concurrent use.
+type Conn struct {
+	rwc io.ReadWriteCloser
+	// network connection under rwc, for deadlines; nil if the client's transport did not report it
+	nc     net.Conn
+	r      *bufio.Reader
+	client bool
+	// Maximum size of a received message, after decompression; zero means DefaultMaxMessageSize.
+	MaxMessageSize int64
+	// permessage-deflate state, nil when the extension was not negotiated
+	deflate *deflateState
+
+	wmtx    sync.Mutex
+	closing bool
+}
+
+// Options of Dial.
+type DialOptions struct {
+	// Extra request headers.
+	Header http.Header
+	// Whether to offer the permessage-deflate extension, letting the server compress its messages.
+	Compress bool
+}
+
+// Options of UpgradeWith.
+type UpgradeOptions struct {
+	// Whether to accept the permessage-deflate extension when the client offers it, compressing the messages
+	// sent to it.
+	Compress bool
+}
+
+// Reports whether messages may be compressed with the permessage-deflate extension.
+func (c *Conn) Compressed() bool {
+	return c.deflate != nil
+}
+
+func accept(key string) string {
+	h := sha1.Sum([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(h[:])
+}
+
+// Opens a client connection to a ws:// or wss:// URL. A nil client uses a client without HTTP/2, which cannot
+// carry WebSocket upgrades. opts may be nil.
+func Dial(ctx context.Context, client *http.Client, url string, opts *DialOptions) (*Conn, error) {
+	if opts == nil {
+		opts = &DialOptions{}
+	}
+	if client == nil {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.ForceAttemptHTTP2 = false
+		transport.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+		client = &http.Client{Transport: transport}
+	}
+	switch {
+	case strings.HasPrefix(url, "ws://"):
+		url = "http://" + url[5:]
+	case strings.HasPrefix(url, "wss://"):
+		url = "https://" + url[6:]
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range opts.Header {
+		req.Header[k] = v
+	}
+	if opts.Compress {
+		req.Header.Set("Sec-WebSocket-Extensions", "permessage-deflate")
+	}
+	var nonce [16]byte
+	if _, err := rand.Read(nonce[:]); err != nil {
+		return nil, err
+	}
+	key := base64.StdEncoding.EncodeToString(nonce[:])
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", key)
+
+	// the upgraded response body hides the connection it reads from and writes to
+	var nc net.Conn
+	req = req.WithContext(httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) { nc = info.Conn },
+	}))
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		resp.Body.Close()
+		return nil, &HandshakeError{StatusCode: resp.StatusCode, Body: body}
+	}
+	rwc, ok := resp.Body.(io.ReadWriteCloser)
+	if !ok {
+		resp.Body.Close()
+		return nil, errors.New("websocket response body is not writable")
+	}
+	if !strings.EqualFold(resp.Header.Get("Upgrade"), "websocket") || resp.Header.Get("Sec-WebSocket-Accept") != accept(key) {
+		rwc.Close()
+		return nil, errors.New("invalid websocket handshake response")
+	}
+	deflate, err := clientDeflate(resp.Header, opts.Compress)
+	if err != nil {
+		rwc.Close()
+		return nil, err
+	}
+	return &Conn{rwc: rwc, nc: nc, r: bufio.NewReader(rwc), client: true, deflate: deflate}, nil
+}
+
+// Upgrades a server request to a WebSocket connection, without extensions. On failure, an error response has
+// been written.
+func Upgrade(w http.ResponseWriter, r *http.Request) (*Conn, error) {
+	return UpgradeWith(w, r, UpgradeOptions{})
+}
+
+// Like Upgrade, with options.
+func UpgradeWith(w http.ResponseWriter, r *http.Request, opts UpgradeOptions) (*Conn, error) {
+	if r.Method != http.MethodGet || !headerContains(r.Header, "Connection", "upgrade") ||
+		!headerContains(r.Header, "Upgrade", "websocket") {
+		http.Error(w, "expected a websocket upgrade", http.StatusUpgradeRequired)
+		return nil, errors.New("not a websocket upgrade request")
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if r.Header.Get("Sec-WebSocket-Version") != "13" || key == "" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "unsupported websocket version", http.StatusBadRequest)
+		return nil, errors.New("unsupported websocket version")
+	}
+	netConn, rw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		http.Error(w, "websocket upgrade not supported", http.StatusInternalServerError)
+		return nil, err
+	}
+	var deflate *deflateState
+	resp := "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + accept(key) + "\r\n"
+	if opts.Compress {
+		var ext string
+		if deflate, ext = serverDeflate(r.Header); deflate != nil {
+			resp += "Sec-WebSocket-Extensions: " + ext + "\r\n"
+		}
+	}
+	resp += "\r\n"
+	if _, err := rw.WriteString(resp); err != nil {
+		netConn.Close()
+		return nil, err
+	}
+	if err := rw.Flush(); err != nil {
+		netConn.Close()
+		return nil, err
+	}
+	return &Conn{rwc: netConn, nc: netConn, r: rw.Reader, deflate: deflate}, nil
+}
+
+func headerContains(h http.Header, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for t := range strings.SplitSeq(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Reads the next text or binary message, answering pings on the way. Returns a *CloseError once the peer
+// closed the connection.
+func (c *Conn) ReadMessage() (int, []byte, error) {
+	limit := c.MaxMessageSize
+	if limit <= 0 {
+		limit = DefaultMaxMessageSize
+	}
+	var msgType int
+	var msg []byte
+	var compressed bool
+	for {
+		fin, opcode, rsv, payload, err := c.readFrame(limit - int64(len(msg)))
+		if err != nil {
+			return 0, nil, err
+		}
+		switch opcode {
+		case pingMessage:
+			if err := c.writeFrame(pongMessage, payload); err != nil {
+				return 0, nil, err
+			}
+			continue
+		case pongMessage:
+			continue
+		case closeMessage:
+			ce := &CloseError{Code: 1005}
+			if len(payload) >= 2 {
+				ce.Code = int(binary.BigEndian.Uint16(payload))
+				ce.Reason = string(payload[2:])
+			}
+			// 1005 means no code was given and must not be sent back
+			code := ce.Code
+			if code == 1005 {
+				code = CloseNormal
+			}
+			c.writeClose(code, "")
+			c.rwc.Close()
+			return 0, nil, ce
+		case 0:
+			if msgType == 0 {
+				return 0, nil, c.fail(CloseProtocolError, "unexpected continuation frame")
+			}
+			if rsv {
+				return 0, nil, c.fail(CloseProtocolError, "reserved bits set")
+			}
+		case TextMessage, BinaryMessage:
+			if msgType != 0 {
+				return 0, nil, c.fail(CloseProtocolError, "interleaved message")
+			}
+			msgType, compressed = opcode, rsv
+		default:
+			return 0, nil, c.fail(CloseProtocolError, fmt.Sprintf("unknown opcode %d", opcode))
+		}
+		msg = append(msg, payload...)
+		if !fin {
+			continue
+		}
+		if compressed {
+			if msg, err = c.deflate.decompress(msg, limit); err == errMessageTooLarge {
+				return 0, nil, c.fail(CloseTooLarge, "message too large")
+			} else if err != nil {
+				return 0, nil, c.fail(CloseProtocolError, "invalid compressed message")
+			}
+		}
+		return msgType, msg, nil
+	}
+}
+
+// reads a frame of at most limit payload bytes, unmasking it, and reports whether its compression bit is set
+func (c *Conn) readFrame(limit int64) (bool, int, bool, []byte, error) {
+	var head [2]byte
+	if _, err := io.ReadFull(c.r, head[:]); err != nil {
+		return false, 0, false, nil, err
+	}
+	fin, opcode, rsv := head[0]&0x80 != 0, int(head[0]&0x0f), head[0]&rsv1 != 0
+	if head[0]&0x30 != 0 || rsv && (c.deflate == nil || opcode >= closeMessage) {
+		return false, 0, false, nil, c.fail(CloseProtocolError, "reserved bits set")
+	}
+	masked := head[1]&0x80 != 0
+	if masked == c.client {
+		return false, 0, false, nil, c.fail(CloseProtocolError, "invalid frame masking")
+	}
+	length := uint64(head[1] & 0x7f)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.r, ext[:]); err != nil {
+			return false, 0, false, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.r, ext[:]); err != nil {
+			return false, 0, false, nil, err
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	if opcode >= closeMessage && (length > 125 || !fin) {
+		return false, 0, false, nil, c.fail(CloseProtocolError, "invalid control frame")
+	}
+	if opcode < closeMessage && length > uint64(max(limit, 0)) {
+		return false, 0, false, nil, c.fail(CloseTooLarge, "message too large")
+	}
+	var mask [4]byte
+	if masked {
+		if _, err := io.ReadFull(c.r, mask[:]); err != nil {
+			return false, 0, false, nil, err
+		}
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(c.r, payload); err != nil {
+		return false, 0, false, nil, err
+	}
+	if masked {
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+	}
+	return fin, opcode, rsv, payload, nil
+}
+
+// Sends a text or binary message as a single frame, compressed if the connection accepted permessage-deflate
+// as a server and the message is large enough.
+func (c *Conn) WriteMessage(msgType int, data []byte) error {
+	c.wmtx.Lock()
+	defer c.wmtx.Unlock()
+	if c.closing {
+		return net.ErrClosed
+	}
+	if c.deflate != nil && c.deflate.write && len(data) >= minCompressSize {
+		compressed, err := c.deflate.compress(data)
+		if err != nil {
+			return err
+		}
+		return c.writeFrameLocked(msgType|rsv1, compressed)
+	}
+	return c.writeFrameLocked(msgType, data)
+}
+
+// Sends a ping, which the peer answers with a pong handled by ReadMessage.
+func (c *Conn) Ping() error {
+	return c.writeFrame(pingMessage, nil)
+}
+
+func (c *Conn) writeFrame(opcode int, payload []byte) error {
+	c.wmtx.Lock()
+	defer c.wmtx.Unlock()
+	if c.closing {
+		return net.ErrClosed
+	}
+	return c.writeFrameLocked(opcode, payload)
+}
+
+// writes a frame whose first header byte, without the final bit, is given as opcode
+func (c *Conn) writeFrameLocked(opcode int, payload []byte) error {
+	frame := make([]byte, 0, 14+len(payload))
+	frame = append(frame, 0x80|byte(opcode))
+	maskBit := byte(0)
+	if c.client {
+		maskBit = 0x80
+	}
+	switch n := len(payload); {
+	case n <= 125:
+		frame = append(frame, maskBit|byte(n))
+	case n <= 0xffff:
+		frame = append(frame, maskBit|126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(n))
+	default:
+		frame = append(frame, maskBit|127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(n))
+	}
+	if c.client {
+		var mask [4]byte
+		if _, err := rand.Read(mask[:]); err != nil {
+			return err
+		}
+		frame = append(frame, mask[:]...)
+		start := len(frame)
+		frame = append(frame, payload...)
+		for i := range payload {
+			frame[start+i] ^= mask[i%4]
+		}
+	} else {
+		frame = append(frame, payload...)
+	}
+	_, err := c.rwc.Write(frame)
+	return err
+}
+
+// sends a close frame unless one was sent already; later writes fail
+func (c *Conn) writeClose(code int, reason string) error {
+	c.wmtx.Lock()
+	defer c.wmtx.Unlock()
+	if c.closing {
+		return nil
+	}
+	c.closing = true
+	payload := binary.BigEndian.AppendUint16(nil, uint16(code))
+	return c.writeFrameLocked(closeMessage, append(payload, reason...))
+}
+
+// Starts the closing handshake with the given status code without waiting for the peer, unlike Close, so that
+// it may be called while another goroutine runs ReadMessage, which closes the connection once the peer
+// acknowledges. Later writes fail.
+func (c *Conn) WriteClose(code int, reason string) error {
+	return c.writeClose(code, reason)
+}
+
+// Sets the deadline of writes, after which they fail and the connection is unusable, so that writes to a peer
+// not reading fail rather than block. It does nothing on dialed connections whose client transport does not
+// report its connections.
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	if c.nc == nil {
+		return nil
+	}
+	return c.nc.SetWriteDeadline(t)
+}
+
+// closes the connection after a protocol violation by the peer
+func (c *Conn) fail(code int, reason string) error {
+	c.writeClose(code, reason)
+	c.rwc.Close()
+	return fmt.Errorf("websocket protocol error: %s", reason)
+}
+
+// Starts the closing handshake with the given status code, waits briefly for the peer to acknowledge it, and
+// closes the connection. Must not be called concurrently with ReadMessage.
+func (c *Conn) Close(code int, reason string) error {
+	if c.nc != nil {
+		c.nc.SetDeadline(time.Now().Add(closeTimeout))
+	} else {
+		// without deadlines, closing the connection interrupts a peer that neither reads nor acknowledges
+		t := time.AfterFunc(closeTimeout, func() { c.rwc.Close() })
+		defer t.Stop()
+	}
+	err := c.writeClose(code, reason)
+	if err == nil {
+		for {
+			_, opcode, _, _, err := c.readFrame(DefaultMaxMessageSize)
+			if err != nil || opcode == closeMessage {
+				break
+			}
+		}
+	}
+	return errors.Join(err, c.rwc.Close())
+}
+
+// Closes the connection without a closing handshake. It is safe to call concurrently with ReadMessage, which
+// it interrupts.
+func (c *Conn) CloseNow() error {
+	return c.rwc.Close()
+}
@@ -0,0 +1,230 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// encodes a frame, masked with a fixed key if mask is set
+func rawFrame(fin bool, opcode int, payload []byte, mask bool) []byte {
+	b := byte(opcode)
+	if fin {
+		b |= 0x80
+	}
+	frame := []byte{b, byte(len(payload))}
+	if !mask {
+		return append(frame, payload...)
+	}
+	frame[1] |= 0x80
+	key := []byte{1, 2, 3, 4}
+	frame = append(frame, key...)
+	for i, c := range payload {
+		frame = append(frame, c^key[i%4])
+	}
+	return frame
+}
+
+// returns a server connection and the raw client end of a pipe
+func pipeConn() (*Conn, net.Conn) {
+	server, client := net.Pipe()
+	return &Conn{rwc: server, nc: server, r: bufio.NewReader(server)}, client
+}
+
+// dials a test server which runs handle on every upgraded connection
+func dialTest(t *testing.T, handle func(*Conn)) *Conn {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := Upgrade(w, r)
+		if err != nil {
+			return
+		}
+		handle(c)
+	}))
+	t.Cleanup(srv.Close)
+	c, err := Dial(context.Background(), nil, "ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.CloseNow() })
+	return c
+}
+
+func TestHandshake(t *testing.T) {
+	c := dialTest(t, func(c *Conn) {
+		for {
+			typ, msg, err := c.ReadMessage()
+			if err != nil {
+				return
+			}
+			if c.WriteMessage(typ, msg) != nil {
+				return
+			}
+		}
+	})
+	if c.nc == nil {
+		t.Fatal("dialed connection has no network connection")
+	}
+	for _, typ := range []int{TextMessage, BinaryMessage} {
+		if err := c.WriteMessage(typ, []byte("echo")); err != nil {
+			t.Fatal(err)
+		}
+		if got, msg, err := c.ReadMessage(); err != nil || got != typ || string(msg) != "echo" {
+			t.Fatalf("unexpected echo %d %q, %v", got, msg, err)
+		}
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/plain" {
+			Upgrade(w, r)
+			return
+		}
+		// a server which switches protocols with the wrong accept key
+		conn, rw, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n" +
+			"Sec-WebSocket-Accept: " + accept("other") + "\r\n\r\n")
+		rw.Flush()
+	}))
+	defer srv.Close()
+	ctx := context.Background()
+	if _, err := Dial(ctx, nil, "ws"+strings.TrimPrefix(srv.URL, "http")+"/bad", nil); err == nil {
+		t.Fatal("expected invalid handshake error")
+	}
+	resp, err := http.Get(srv.URL + "/plain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUpgradeRequired {
+		t.Fatalf("unexpected status %d for a plain request", resp.StatusCode)
+	}
+}
+
+func TestMasking(t *testing.T) {
+	// clients mask their frames
+	server, client := net.Pipe()
+	c := &Conn{rwc: client, nc: client, r: bufio.NewReader(client), client: true}
+	go c.WriteMessage(BinaryMessage, []byte("masked payload"))
+	frame := make([]byte, 2+4+len("masked payload"))
+	if _, err := io.ReadFull(server, frame); err != nil {
+		t.Fatal(err)
+	}
+	if frame[0] != 0x80|BinaryMessage || frame[1] != 0x80|byte(len("masked payload")) {
+		t.Fatalf("unexpected frame header % x", frame[:2])
+	}
+	payload := frame[6:]
+	if bytes.Contains(frame, []byte("masked payload")) {
+		t.Fatal("payload sent unmasked")
+	}
+	for i := range payload {
+		payload[i] ^= frame[2+i%4]
+	}
+	if string(payload) != "masked payload" {
+		t.Fatalf("unexpected unmasked payload %q", payload)
+	}
+	server.Close()
+
+	// servers reject unmasked frames from clients
+	s, raw := pipeConn()
+	go func() {
+		raw.Write(rawFrame(true, TextMessage, []byte("plain"), false))
+		io.Copy(io.Discard, raw)
+	}()
+	if _, _, err := s.ReadMessage(); err == nil || !strings.Contains(err.Error(), "masking") {
+		t.Fatalf("expected masking error, got %v", err)
+	}
+}
+
+func TestFragmentation(t *testing.T) {
+	s, raw := pipeConn()
+	defer s.CloseNow()
+	pongs := make(chan []byte, 1)
+	go func() {
+		raw.Write(rawFrame(false, TextMessage, []byte("hel"), true))
+		raw.Write(rawFrame(true, pingMessage, []byte("ping"), true))
+		pong := make([]byte, 2+len("ping"))
+		io.ReadFull(raw, pong)
+		pongs <- pong
+		raw.Write(rawFrame(false, 0, []byte("lo, "), true))
+		raw.Write(rawFrame(true, 0, []byte("world"), true))
+	}()
+	typ, msg, err := s.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if typ != TextMessage || string(msg) != "hello, world" {
+		t.Fatalf("unexpected message %d %q", typ, msg)
+	}
+	if pong := <-pongs; pong[0] != 0x80|pongMessage || string(pong[2:]) != "ping" {
+		t.Fatalf("unexpected pong % x", pong)
+	}
+
+	for _, frames := range [][][]byte{
+		{rawFrame(true, 0, []byte("orphan"), true)},
+		{rawFrame(false, TextMessage, []byte("a"), true), rawFrame(true, BinaryMessage, []byte("b"), true)},
+		{rawFrame(false, pingMessage, nil, true)},
+	} {
+		s, raw := pipeConn()
+		go func() {
+			for _, f := range frames {
+				raw.Write(f)
+			}
+			io.Copy(io.Discard, raw)
+		}()
+		if _, _, err := s.ReadMessage(); err == nil || !strings.Contains(err.Error(), "protocol error") {
+			t.Errorf("expected protocol error, got %v", err)
+		}
+	}
+}
+
+func TestCloseHandshake(t *testing.T) {
+	closed := make(chan error, 1)
+	c := dialTest(t, func(c *Conn) {
+		_, _, err := c.ReadMessage()
+		closed <- err
+	})
+	if err := c.Close(CloseGoingAway, "bye"); err != nil {
+		t.Fatal(err)
+	}
+	var ce *CloseError
+	if err := <-closed; !errors.As(err, &ce) || ce.Code != CloseGoingAway || ce.Reason != "bye" {
+		t.Fatalf("unexpected close error %v", err)
+	}
+	if err := c.WriteMessage(TextMessage, []byte("late")); !errors.Is(err, net.ErrClosed) {
+		t.Fatalf("expected closed error, got %v", err)
+	}
+
+	// a peer which never acknowledges does not hold Close beyond its timeout
+	release := make(chan struct{})
+	defer close(release)
+	c = dialTest(t, func(*Conn) { <-release })
+	start := time.Now()
+	c.Close(CloseNormal, "")
+	if d := time.Since(start); d > 3*closeTimeout {
+		t.Fatalf("Close took %v", d)
+	}
+}
+
+func TestWriteDeadline(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	c := dialTest(t, func(*Conn) { <-release })
+	if err := c.SetWriteDeadline(time.Now().Add(-time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.WriteMessage(BinaryMessage, []byte("late")); err == nil {
+		t.Fatal("expected deadline error")
+	}
+}
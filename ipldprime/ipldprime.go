@@ -0,0 +1,205 @@
+// Package ipldprime adapts values decoded by grove to go-ipld-prime nodes and back, so selectors and
+// traversals written against ipld-prime can run on top of grove's strict DAG-CBOR codec. It lives in its own
+// module to keep the rest of grove free of dependencies.
+//
+// Decode and Encode have the signatures of ipld-prime codecs, and may be registered in place of the default
+// DAG-CBOR codec:
+//
+//	multicodec.RegisterDecoder(0x71, ipldprime.Decode)
+//	multicodec.RegisterEncoder(0x71, ipldprime.Encode)
+package ipldprime
+
+import (
+	"fmt"
+	"io"
+	"math"
+
+	gocid "github.com/ipfs/go-cid"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+	"github.com/notjuliet/grove/cbor"
+	"github.com/notjuliet/grove/cid"
+	"github.com/notjuliet/grove/data"
+)
+
+// Decodes DAG-CBOR with grove's decoder into an ipld-prime assembler.
+func Decode(na datamodel.NodeAssembler, r io.Reader) error {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	v, err := cbor.Decode(b)
+	if err != nil {
+		return err
+	}
+	return Assemble(na, v)
+}
+
+// Encodes an ipld-prime node as DAG-CBOR with grove's encoder.
+func Encode(n datamodel.Node, w io.Writer) error {
+	v, err := FromNode(n)
+	if err != nil {
+		return err
+	}
+	b, err := cbor.Encode(v)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
+
+// Returns a grove value, as decoded by cbor.Decode, as a basic ipld-prime node.
+func ToNode(v any) (datamodel.Node, error) {
+	nb := basicnode.Prototype.Any.NewBuilder()
+	if err := Assemble(nb, v); err != nil {
+		return nil, err
+	}
+	return nb.Build(), nil
+}
+
+// Assembles a grove value into an ipld-prime assembler. Map entries are assembled in DAG-CBOR key order.
+func Assemble(na datamodel.NodeAssembler, v any) error {
+	switch v := v.(type) {
+	case nil:
+		return na.AssignNull()
+	case bool:
+		return na.AssignBool(v)
+	case string:
+		return na.AssignString(v)
+	case []byte:
+		return na.AssignBytes(v)
+	case data.Bytes:
+		return na.AssignBytes(v)
+	case int:
+		return na.AssignInt(int64(v))
+	case int64:
+		return na.AssignInt(v)
+	case uint64:
+		if v > math.MaxInt64 {
+			return fmt.Errorf("integer %d overflows int64", v)
+		}
+		return na.AssignInt(int64(v))
+	case float64:
+		return na.AssignFloat(v)
+	case cid.CidLink:
+		l, err := ToLink(v)
+		if err != nil {
+			return err
+		}
+		return na.AssignLink(l)
+	case []any:
+		la, err := na.BeginList(int64(len(v)))
+		if err != nil {
+			return err
+		}
+		for i, elem := range v {
+			if err := Assemble(la.AssembleValue(), elem); err != nil {
+				return fmt.Errorf("%d: %w", i, err)
+			}
+		}
+		return la.Finish()
+	case map[string]any:
+		ma, err := na.BeginMap(int64(len(v)))
+		if err != nil {
+			return err
+		}
+		for _, k := range cbor.SortedKeys(v) {
+			if err := ma.AssembleKey().AssignString(k); err != nil {
+				return err
+			}
+			if err := Assemble(ma.AssembleValue(), v[k]); err != nil {
+				return fmt.Errorf("%s: %w", k, err)
+			}
+		}
+		return ma.Finish()
+	default:
+		return fmt.Errorf("unsupported type %T", v)
+	}
+}
+
+// Returns an ipld-prime node as a grove value, with the types returned by cbor.Decode: non-negative integers
+// are uint64, negative integers int64 and links cid.CidLink.
+func FromNode(n datamodel.Node) (any, error) {
+	switch n.Kind() {
+	case datamodel.Kind_Null:
+		return nil, nil
+	case datamodel.Kind_Bool:
+		return n.AsBool()
+	case datamodel.Kind_String:
+		return n.AsString()
+	case datamodel.Kind_Bytes:
+		return n.AsBytes()
+	case datamodel.Kind_Int:
+		i, err := n.AsInt()
+		if err != nil || i < 0 {
+			return i, err
+		}
+		return uint64(i), nil
+	case datamodel.Kind_Float:
+		return n.AsFloat()
+	case datamodel.Kind_Link:
+		l, err := n.AsLink()
+		if err != nil {
+			return nil, err
+		}
+		return FromLink(l)
+	case datamodel.Kind_List:
+		arr := make([]any, 0, n.Length())
+		for it := n.ListIterator(); !it.Done(); {
+			i, elem, err := it.Next()
+			if err != nil {
+				return nil, err
+			}
+			v, err := FromNode(elem)
+			if err != nil {
+				return nil, fmt.Errorf("%d: %w", i, err)
+			}
+			arr = append(arr, v)
+		}
+		return arr, nil
+	case datamodel.Kind_Map:
+		m := make(map[string]any, n.Length())
+		for it := n.MapIterator(); !it.Done(); {
+			kn, vn, err := it.Next()
+			if err != nil {
+				return nil, err
+			}
+			k, err := kn.AsString()
+			if err != nil {
+				return nil, fmt.Errorf("map key must be a string: %w", err)
+			}
+			v, err := FromNode(vn)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", k, err)
+			}
+			m[k] = v
+		}
+		return m, nil
+	default:
+		return nil, fmt.Errorf("unsupported node kind %s", n.Kind())
+	}
+}
+
+// Returns a grove link as an ipld-prime CID link.
+func ToLink(l cid.CidLink) (datamodel.Link, error) {
+	c, err := gocid.Cast(l.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	return cidlink.Link{Cid: c}, nil
+}
+
+// Returns an ipld-prime CID link as a grove link, checking it is a CID grove accepts.
+func FromLink(l datamodel.Link) (cid.CidLink, error) {
+	cl, ok := l.(cidlink.Link)
+	if !ok {
+		return cid.CidLink{}, fmt.Errorf("unsupported link type %T", l)
+	}
+	link := cid.CidLink{Bytes: cl.Bytes()}
+	if _, err := link.Cid(); err != nil {
+		return cid.CidLink{}, err
+	}
+	return link, nil
+}
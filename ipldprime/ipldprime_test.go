@@ -0,0 +1,70 @@
+package ipldprime
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/ipld/go-ipld-prime/codec/dagcbor"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+	"github.com/ipld/go-ipld-prime/traversal"
+	"github.com/notjuliet/grove/cbor"
+	"github.com/notjuliet/grove/cid"
+)
+
+func TestRoundTrip(t *testing.T) {
+	c, _ := cid.Create(cid.CodecRaw, []byte("hello"))
+	value := map[string]any{
+		"$type":   "app.bsky.feed.post",
+		"text":    "hello",
+		"count":   uint64(3),
+		"offset":  int64(-2),
+		"ratio":   0.5,
+		"bytes":   []byte("raw"),
+		"missing": nil,
+		"ok":      true,
+		"embed":   map[string]any{"ref": c.Link(), "tags": []any{"a", "b"}},
+	}
+	enc, err := cbor.Encode(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	nb := basicnode.Prototype.Any.NewBuilder()
+	if err := Decode(nb, bytes.NewReader(enc)); err != nil {
+		t.Fatal(err)
+	}
+	n := nb.Build()
+	var prime bytes.Buffer
+	if err := dagcbor.Encode(n, &prime); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(prime.Bytes(), enc) {
+		t.Fatal("ipld-prime encoding differs from grove's")
+	}
+
+	tag, err := traversal.Get(n, datamodel.ParsePath("embed/tags/1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s, _ := tag.AsString(); s != "b" {
+		t.Fatalf("unexpected tag %q", s)
+	}
+
+	v, err := FromNode(n)
+	if err != nil || !reflect.DeepEqual(v, value) {
+		t.Fatalf("unexpected value %v: %v", v, err)
+	}
+	var buf bytes.Buffer
+	if err := Encode(n, &buf); err != nil || !bytes.Equal(buf.Bytes(), enc) {
+		t.Fatalf("unexpected encoding: %v", err)
+	}
+
+	if _, err := ToNode(map[string]any{"n": uint64(1 << 63)}); err == nil {
+		t.Fatal("expected error for overflowing integer")
+	}
+	if _, err := ToNode(struct{}{}); err == nil {
+		t.Fatal("expected error for unsupported type")
+	}
+}
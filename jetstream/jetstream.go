@@ -0,0 +1,296 @@
+// Package jetstream implements a client for Jetstream, a firehose of repository events encoded as JSON
+// instead of CAR slices, with server-side filtering by collection and account.
+//
+// https://github.com/bluesky-social/jetstream
+package jetstream
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/notjuliet/grove/internal/websocket"
+	"github.com/notjuliet/grove/repo"
+	"github.com/notjuliet/grove/syntax"
+)
+
+// Limits of the subscription filters accepted by Jetstream.
+const (
+	MaxWantedCollections = 100
+	MaxWantedDIDs        = 10000
+)
+
+// Event kinds.
+const (
+	KindCommit   = "commit"
+	KindIdentity = "identity"
+	KindAccount  = "account"
+)
+
+// Jetstream event. Exactly one of Commit, Identity and Account is set, matching Kind.
+type Event struct {
+	DID string `json:"did"`
+	// Time the event was received by Jetstream, in microseconds since the Unix epoch, which is also the
+	// cursor for resuming after it.
+	TimeUS   int64     `json:"time_us"`
+	Kind     string    `json:"kind"`
+	Commit   *Commit   `json:"commit,omitempty"`
+	Identity *Identity `json:"identity,omitempty"`
+	Account  *Account  `json:"account,omitempty"`
+}
+
+// Record operation of a repository commit.
+type Commit struct {
+	Rev        string      `json:"rev"`
+	Operation  repo.Action `json:"operation"`
+	Collection string      `json:"collection"`
+	RKey       string      `json:"rkey"`
+	// Record value as JSON, absent for deletes.
+	Record json.RawMessage `json:"record,omitempty"`
+	// CID of the record, absent for deletes.
+	Cid string `json:"cid,omitempty"`
+}
+
+// Change of the identity of an account.
+type Identity struct {
+	DID    string `json:"did"`
+	Handle string `json:"handle,omitempty"`
+	// Sequence number and time of the event on the upstream firehose.
+	Seq  int64  `json:"seq"`
+	Time string `json:"time"`
+}
+
+// Change of the hosting status of an account.
+type Account struct {
+	DID    string `json:"did"`
+	Active bool   `json:"active"`
+	// Reason the account is inactive, empty when it is active.
+	Status string `json:"status,omitempty"`
+	Seq    int64  `json:"seq"`
+	Time   string `json:"time"`
+}
+
+// Returns the typed status of the account.
+func (a *Account) AccountStatus() repo.AccountStatus {
+	return repo.AccountStatusOf(a.Active, a.Status)
+}
+
+// Returns the time of the event.
+func (e *Event) Time() time.Time {
+	return time.UnixMicro(e.TimeUS)
+}
+
+// Jetstream consumer. It reconnects when the connection drops, resuming after the last event received.
+type Client struct {
+	// Base URL of the instance, such as "wss://jetstream2.us-east.bsky.network". HTTP URLs are accepted too.
+	Host string
+	// Collections to receive the commits of, as NSIDs or prefixes ending in ".*" such as "app.bsky.graph.*".
+	// Identity and account events are always received. Empty means every collection.
+	WantedCollections []string
+	// Accounts to receive the events of. Empty means every account.
+	WantedDIDs []string
+	// Decompresses a message, setting this turns on the compressed mode of Jetstream. Messages are then zstd
+	// frames compressed with the dictionary published in the Jetstream repository, which must be loaded in the
+	// decoder, for instance with klauspost/compress's zstd.WithDecoderDicts. dst may be reused for the output.
+	Decompress func(dst, src []byte) ([]byte, error)
+	// Client for the WebSocket handshake, which must not use HTTP/2. Nil uses a default client.
+	HTTPClient *http.Client
+	// Whether to negotiate the permessage-deflate WebSocket extension, letting the server compress messages
+	// when it supports it. Decompress achieves better ratios with the zstd dictionary of Jetstream.
+	Compress bool
+	// Delay before reconnecting, doubled after every failed attempt up to MaxBackoff, and reset once events
+	// flow again. Zero means 1 second and 1 minute.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// Called with the error which ended each connection before reconnecting, if set.
+	OnDisconnect func(err error)
+	// Logger for connections, reconnections and undecodable events, if set.
+	Logger *slog.Logger
+}
+
+// Returns the subscription URL for the filters of the client, starting after cursor, or with live events if
+// cursor is nil.
+func (c *Client) URL(cursor *int64) (string, error) {
+	if len(c.WantedCollections) > MaxWantedCollections {
+		return "", fmt.Errorf("more than %d wanted collections", MaxWantedCollections)
+	}
+	if len(c.WantedDIDs) > MaxWantedDIDs {
+		return "", fmt.Errorf("more than %d wanted DIDs", MaxWantedDIDs)
+	}
+	q := url.Values{}
+	for _, col := range c.WantedCollections {
+		// prefixes need at least two segments before the wildcard
+		if prefix, ok := strings.CutSuffix(col, ".*"); ok {
+			col = prefix + ".x"
+		}
+		if err := syntax.ValidateNSID(col); err != nil {
+			return "", fmt.Errorf("invalid wanted collection: %w", err)
+		}
+	}
+	if len(c.WantedCollections) > 0 {
+		q["wantedCollections"] = c.WantedCollections
+	}
+	for _, did := range c.WantedDIDs {
+		if !strings.HasPrefix(did, "did:") {
+			return "", fmt.Errorf("invalid wanted DID %q", did)
+		}
+	}
+	if len(c.WantedDIDs) > 0 {
+		q["wantedDids"] = c.WantedDIDs
+	}
+	if cursor != nil {
+		// Jetstream cursors are inclusive, so the event at cursor would be sent again
+		q.Set("cursor", strconv.FormatInt(*cursor+1, 10))
+	}
+	if c.Decompress != nil {
+		q.Set("compress", "true")
+	}
+	u := strings.TrimSuffix(c.Host, "/") + "/subscribe"
+	if len(q) > 0 {
+		u += "?" + q.Encode()
+	}
+	return u, nil
+}
+
+// Consumes the stream, calling handle for each event in order, starting after cursor, a time in microseconds
+// since the Unix epoch, or with live events if cursor is nil.
+//
+// Run only returns when ctx is done, handle returns an error, which is returned as is, the filters are
+// invalid, or the handshake is rejected with a client error other than 429.
+func (c *Client) Run(ctx context.Context, cursor *int64, handle func(*Event) error) error {
+	if _, err := c.URL(cursor); err != nil {
+		return err
+	}
+	minBackoff := c.MinBackoff
+	if minBackoff <= 0 {
+		minBackoff = time.Second
+	}
+	maxBackoff := c.MaxBackoff
+	if maxBackoff <= 0 {
+		maxBackoff = time.Minute
+	}
+
+	backoff := minBackoff
+	for {
+		received, err := c.connect(ctx, cursor, func(e *Event) error {
+			cursor = &e.TimeUS
+			return handle(e)
+		})
+		var handlerErr *handlerError
+		if errors.As(err, &handlerErr) {
+			return handlerErr.err
+		}
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		var handshakeErr *websocket.HandshakeError
+		if errors.As(err, &handshakeErr) && handshakeErr.StatusCode >= 400 && handshakeErr.StatusCode < 500 &&
+			handshakeErr.StatusCode != http.StatusTooManyRequests {
+			c.logger().Error("jetstream handshake rejected", "host", c.Host, "status", handshakeErr.StatusCode)
+			return err
+		}
+		if c.OnDisconnect != nil {
+			c.OnDisconnect(err)
+		}
+
+		if received {
+			backoff = minBackoff
+		}
+		c.logger().Warn("jetstream disconnected", "host", c.Host, "cursor", logCursor(cursor), "error", err,
+			"retry_in", backoff)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, maxBackoff)
+	}
+}
+
+// wraps errors of the event handler, which end Run
+type handlerError struct{ err error }
+
+func (e *handlerError) Error() string { return e.err.Error() }
+
+var discardLogger = slog.New(slog.DiscardHandler)
+
+func (c *Client) logger() *slog.Logger {
+	if c.Logger != nil {
+		return c.Logger
+	}
+	return discardLogger
+}
+
+// formats an optional cursor for logging
+func logCursor(cursor *int64) any {
+	if cursor == nil {
+		return nil
+	}
+	return *cursor
+}
+
+// runs a single connection until it fails, reporting whether any event was received
+func (c *Client) connect(ctx context.Context, cursor *int64, handle func(*Event) error) (bool, error) {
+	u, err := c.URL(cursor)
+	if err != nil {
+		return false, err
+	}
+	c.logger().Debug("connecting to jetstream", "url", u)
+	conn, err := websocket.Dial(ctx, c.HTTPClient, u, &websocket.DialOptions{Compress: c.Compress})
+	if err != nil {
+		return false, err
+	}
+	defer conn.CloseNow()
+	stop := context.AfterFunc(ctx, func() { conn.CloseNow() })
+	defer stop()
+	c.logger().Info("connected to jetstream", "url", u)
+
+	received := false
+	last := cursor
+	var buf []byte
+	for {
+		msgType, msg, err := conn.ReadMessage()
+		if err != nil {
+			return received, err
+		}
+		if c.Decompress != nil {
+			if msgType != websocket.BinaryMessage {
+				conn.Close(websocket.CloseProtocolError, "expected binary message")
+				return received, errors.New("compressed stream sent a text message")
+			}
+			if buf, err = c.Decompress(buf[:0], msg); err != nil {
+				c.logger().Warn("undecompressable jetstream event", "host", c.Host, "after_cursor", logCursor(last),
+					"size", len(msg), "error", err)
+				return received, fmt.Errorf("decompressing event: %w", err)
+			}
+			msg = buf
+		}
+		e := new(Event)
+		if err := json.Unmarshal(msg, e); err != nil {
+			var offset int64
+			var syntaxErr *json.SyntaxError
+			var typeErr *json.UnmarshalTypeError
+			if errors.As(err, &syntaxErr) {
+				offset = syntaxErr.Offset
+			} else if errors.As(err, &typeErr) {
+				offset = typeErr.Offset
+			}
+			c.logger().Warn("undecodable jetstream event", "host", c.Host, "after_cursor", logCursor(last),
+				"offset", offset, "size", len(msg), "error", err)
+			return received, fmt.Errorf("decoding event: %w", err)
+		}
+		last = &e.TimeUS
+		received = true
+		if err := handle(e); err != nil {
+			conn.Close(websocket.CloseNormal, "")
+			return received, &handlerError{err}
+		}
+	}
+}
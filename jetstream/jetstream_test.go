@@ -0,0 +1,90 @@
+package jetstream
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/notjuliet/grove/internal/websocket"
+	"github.com/notjuliet/grove/repo"
+)
+
+func TestClient(t *testing.T) {
+	var queries []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queries = append(queries, r.URL.RawQuery)
+		conn, err := websocket.Upgrade(w, r)
+		if err != nil {
+			return
+		}
+		defer conn.CloseNow()
+		msgType := websocket.TextMessage
+		encode := func(b []byte) []byte { return b }
+		if r.URL.Query().Get("compress") == "true" {
+			msgType = websocket.BinaryMessage
+			encode = func(b []byte) []byte { return slices.Concat([]byte("z"), b) }
+		}
+		switch len(queries) {
+		case 1:
+			conn.WriteMessage(msgType, encode([]byte(`{"did":"did:plc:alice","time_us":10,"kind":"commit","commit":{"rev":"3kabcdefghij2","operation":"create","collection":"app.bsky.feed.post","rkey":"a","record":{"text":"hi"},"cid":"bafyreie5737gdxlw5i64vzichcalba3z2v5n6icifvx5xytvske7mr3hpm"},"extra":1}`)))
+			// dropped without closing handshake
+		case 2:
+			conn.WriteMessage(msgType, encode([]byte(`{"did":"did:plc:alice","time_us":11,"kind":"account","account":{"did":"did:plc:alice","active":false,"status":"deactivated","seq":5,"time":"2024-05-01T12:00:00.000Z"}}`)))
+			conn.ReadMessage()
+		}
+	}))
+	defer srv.Close()
+
+	c := &Client{
+		Host:              srv.URL,
+		WantedCollections: []string{"app.bsky.feed.post", "app.bsky.graph.*"},
+		WantedDIDs:        []string{"did:plc:alice"},
+		MinBackoff:        time.Millisecond,
+		Decompress: func(dst, src []byte) ([]byte, error) {
+			if len(src) == 0 || src[0] != 'z' {
+				return nil, errors.New("not compressed")
+			}
+			return append(dst, src[1:]...), nil
+		},
+	}
+	var events []*Event
+	stop := errors.New("stop")
+	err := c.Run(context.Background(), nil, func(e *Event) error {
+		events = append(events, e)
+		if e.Kind == KindAccount {
+			return stop
+		}
+		return nil
+	})
+	if err != stop {
+		t.Fatalf("expected handler error, got %v", err)
+	}
+	if len(events) != 2 || events[0].Commit == nil || events[0].Commit.Operation != repo.ActionCreate ||
+		string(events[0].Commit.Record) != `{"text":"hi"}` || events[1].Account == nil || events[1].Account.Status != "deactivated" ||
+		!events[1].Time().Equal(time.UnixMicro(11)) {
+		t.Fatalf("unexpected events %+v", events)
+	}
+	want := []string{
+		"compress=true&wantedCollections=app.bsky.feed.post&wantedCollections=app.bsky.graph.%2A&wantedDids=did%3Aplc%3Aalice",
+		"compress=true&cursor=11&wantedCollections=app.bsky.feed.post&wantedCollections=app.bsky.graph.%2A&wantedDids=did%3Aplc%3Aalice",
+	}
+	if !reflect.DeepEqual(queries, want) {
+		t.Fatalf("unexpected queries %q", queries)
+	}
+
+	for _, c := range []*Client{
+		{Host: srv.URL, WantedCollections: []string{"app.*"}},
+		{Host: srv.URL, WantedCollections: []string{"not an nsid"}},
+		{Host: srv.URL, WantedDIDs: []string{"alice"}},
+		{Host: srv.URL, WantedCollections: make([]string, MaxWantedCollections+1)},
+	} {
+		if err := c.Run(context.Background(), nil, func(e *Event) error { return nil }); err == nil {
+			t.Fatalf("expected error for filters %q %q", c.WantedCollections, c.WantedDIDs)
+		}
+	}
+}
@@ -0,0 +1,279 @@
+// Package label implements atproto labels: signed moderation assertions about accounts and records, and
+// the messages of the label event stream.
+//
+// https://atproto.com/specs/label
+package label
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/notjuliet/grove/cbor"
+	"github.com/notjuliet/grove/crypto"
+	"github.com/notjuliet/grove/events"
+)
+
+// NSID of the label event stream of labelers.
+const SubscribeLabels = "com.atproto.label.subscribeLabels"
+
+// Message type of the label event stream carrying labels, registered with events.Register.
+const TypeLabels = "#labels"
+
+// Version of the label format this package produces.
+const Version = 1
+
+// Maximum length in bytes of a label value.
+const MaxValueLength = 128
+
+// A label, asserted by a labeler about an account or a record.
+type Label struct {
+	// Format version, Version for labels created by this package.
+	Ver int64
+	// DID of the labeler.
+	Src string
+	// Subject of the label: a DID for an account, or an at:// URI for a record.
+	URI string
+	// CID of the labeled record version, if the label only applies to it.
+	Cid *string
+	// Value of the label, such as "porn" or "!takedown".
+	Val string
+	// Whether the label negates an earlier one with the same source, subject and value, nil if the field is
+	// absent. An explicit false is kept, as it is part of the signed encoding.
+	Neg *bool
+	// Creation time, as an RFC 3339 datetime. Kept as sent so that signatures stay verifiable.
+	Cts string
+	// Expiration time, if any.
+	Exp *string
+	// Signature of the labeler over the unsigned encoding, nil if unsigned.
+	Sig []byte
+}
+
+// Checks the fields of the label, without checking its signature.
+func (l *Label) Validate() error {
+	if l.Ver != Version {
+		return fmt.Errorf("unsupported label version %d", l.Ver)
+	}
+	if !strings.HasPrefix(l.Src, "did:") {
+		return fmt.Errorf("label source %q is not a DID", l.Src)
+	}
+	if !strings.HasPrefix(l.URI, "did:") && !strings.HasPrefix(l.URI, "at://") {
+		return fmt.Errorf("label subject %q is neither a DID nor an at:// URI", l.URI)
+	}
+	if err := ValidateValue(l.Val); err != nil {
+		return err
+	}
+	if _, err := time.Parse(time.RFC3339Nano, l.Cts); err != nil {
+		return fmt.Errorf("invalid label creation time: %w", err)
+	}
+	if l.Exp != nil {
+		if _, err := time.Parse(time.RFC3339Nano, *l.Exp); err != nil {
+			return fmt.Errorf("invalid label expiration time: %w", err)
+		}
+	}
+	return nil
+}
+
+// Reports whether the label negates an earlier one.
+func (l *Label) Negated() bool {
+	return l.Neg != nil && *l.Neg
+}
+
+// Reports whether the label is expired at the given time.
+func (l *Label) Expired(now time.Time) bool {
+	if l.Exp == nil {
+		return false
+	}
+	exp, err := time.Parse(time.RFC3339Nano, *l.Exp)
+	return err == nil && !now.Before(exp)
+}
+
+// Returns the label as a DAG-CBOR data model map, including its signature if it has one.
+func (l *Label) Map() map[string]any {
+	m := map[string]any{"ver": l.Ver, "src": l.Src, "uri": l.URI, "val": l.Val, "cts": l.Cts}
+	if l.Cid != nil {
+		m["cid"] = *l.Cid
+	}
+	if l.Neg != nil {
+		m["neg"] = *l.Neg
+	}
+	if l.Exp != nil {
+		m["exp"] = *l.Exp
+	}
+	if l.Sig != nil {
+		m["sig"] = l.Sig
+	}
+	return m
+}
+
+// Returns the canonical DAG-CBOR encoding of the label without its signature, which is what labelers sign.
+func (l *Label) UnsignedBytes() ([]byte, error) {
+	m := l.Map()
+	delete(m, "sig")
+	return cbor.Encode(m)
+}
+
+// Signs the label with the labeler key, setting Sig.
+func (l *Label) Sign(ctx context.Context, signer crypto.Signer) error {
+	b, err := l.UnsignedBytes()
+	if err != nil {
+		return err
+	}
+	sig, err := crypto.Sign(ctx, signer, b)
+	if err != nil {
+		return fmt.Errorf("signing label: %w", err)
+	}
+	l.Sig = sig
+	return nil
+}
+
+// Verifies the signature of the label against the labeler key, found in the "#atproto_label" verification
+// method of the DID document of the source.
+func (l *Label) Verify(pub crypto.PublicKey) error {
+	if l.Sig == nil {
+		return errors.New("label is not signed")
+	}
+	b, err := l.UnsignedBytes()
+	if err != nil {
+		return err
+	}
+	return crypto.Verify(pub, b, l.Sig)
+}
+
+// Decodes a label from a DAG-CBOR data model map.
+func FromMap(m map[string]any) (Label, error) {
+	var l Label
+	var err error
+	str := func(key string) string {
+		s, ok := m[key].(string)
+		if !ok && err == nil {
+			err = fmt.Errorf("label field %s is not a string", key)
+		}
+		return s
+	}
+	optStr := func(key string) *string {
+		if m[key] == nil {
+			return nil
+		}
+		s := str(key)
+		return &s
+	}
+	switch ver := m["ver"].(type) {
+	case nil:
+		// labels from before versioning are version 1
+		l.Ver = Version
+	case uint64:
+		l.Ver = int64(min(ver, 1<<63-1))
+	default:
+		return Label{}, errors.New("label field ver is not an integer")
+	}
+	l.Src, l.URI, l.Val, l.Cts = str("src"), str("uri"), str("val"), str("cts")
+	l.Cid, l.Exp = optStr("cid"), optStr("exp")
+	if v, ok := m["neg"]; ok && v != nil {
+		neg, ok := v.(bool)
+		if !ok {
+			return Label{}, errors.New("label field neg is not a boolean")
+		}
+		l.Neg = &neg
+	}
+	if v, ok := m["sig"]; ok && v != nil {
+		sig, ok := v.([]byte)
+		if !ok {
+			return Label{}, errors.New("label field sig is not bytes")
+		}
+		l.Sig = sig
+	}
+	return l, err
+}
+
+type jsonLabel struct {
+	Ver int64      `json:"ver"`
+	Src string     `json:"src"`
+	URI string     `json:"uri"`
+	Cid *string    `json:"cid,omitempty"`
+	Val string     `json:"val"`
+	Neg *bool      `json:"neg,omitempty"`
+	Cts string     `json:"cts"`
+	Exp *string    `json:"exp,omitempty"`
+	Sig *jsonBytes `json:"sig,omitempty"`
+}
+
+// bytes in the atproto JSON data model
+type jsonBytes struct {
+	Bytes string `json:"$bytes"`
+}
+
+// Encodes the label in the atproto JSON data model, as returned by com.atproto.label.queryLabels.
+func (l Label) MarshalJSON() ([]byte, error) {
+	j := jsonLabel{Ver: l.Ver, Src: l.Src, URI: l.URI, Cid: l.Cid, Val: l.Val, Neg: l.Neg, Cts: l.Cts, Exp: l.Exp}
+	if l.Sig != nil {
+		j.Sig = &jsonBytes{base64.RawStdEncoding.EncodeToString(l.Sig)}
+	}
+	return json.Marshal(j)
+}
+
+// Decodes the label from the atproto JSON data model.
+func (l *Label) UnmarshalJSON(b []byte) error {
+	j := jsonLabel{Ver: Version}
+	if err := json.Unmarshal(b, &j); err != nil {
+		return err
+	}
+	*l = Label{Ver: j.Ver, Src: j.Src, URI: j.URI, Cid: j.Cid, Val: j.Val, Neg: j.Neg, Cts: j.Cts, Exp: j.Exp}
+	if j.Sig != nil {
+		// the reference implementation omits padding, but padded input is accepted too
+		sig, err := base64.RawStdEncoding.DecodeString(strings.TrimRight(j.Sig.Bytes, "="))
+		if err != nil {
+			return fmt.Errorf("invalid label signature: %w", err)
+		}
+		l.Sig = sig
+	}
+	return nil
+}
+
+// Message of the label event stream carrying new labels.
+type Labels struct {
+	Seq    int64
+	Labels []Label
+}
+
+func (e *Labels) Type() string { return TypeLabels }
+
+func (e *Labels) Body() map[string]any {
+	labels := make([]any, len(e.Labels))
+	for i := range e.Labels {
+		labels[i] = e.Labels[i].Map()
+	}
+	return map[string]any{"seq": e.Seq, "labels": labels}
+}
+
+func init() {
+	events.Register(TypeLabels, decodeLabels)
+}
+
+func decodeLabels(body map[string]any) (events.Event, error) {
+	seq, ok := body["seq"].(uint64)
+	if !ok || seq > 1<<63-1 {
+		return nil, errors.New("field seq is not a positive integer")
+	}
+	list, ok := body["labels"].([]any)
+	if !ok {
+		return nil, errors.New("field labels is not a list")
+	}
+	e := &Labels{Seq: int64(seq), Labels: make([]Label, 0, len(list))}
+	for _, v := range list {
+		m, ok := v.(map[string]any)
+		if !ok {
+			return nil, errors.New("label is not a map")
+		}
+		l, err := FromMap(m)
+		if err != nil {
+			return nil, err
+		}
+		e.Labels = append(e.Labels, l)
+	}
+	return e, nil
+}
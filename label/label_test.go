@@ -0,0 +1,278 @@
+package label
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/notjuliet/grove/cbor"
+	"github.com/notjuliet/grove/crypto"
+	"github.com/notjuliet/grove/data"
+	"github.com/notjuliet/grove/events"
+	"github.com/notjuliet/grove/lexicon"
+	"github.com/notjuliet/grove/repo"
+)
+
+func TestVerify(t *testing.T) {
+	// signed by a production labeler
+	pub, err := crypto.ParsePublicMultibase("zQ3shcnfWLQN1bY4d2patsEAYFzy4xp1zdckEvHsV7S4ocTnC")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var l Label
+	err = json.Unmarshal([]byte(`{
+		"ver": 1,
+		"src": "did:plc:n3timvoib5nau7gvwd6cshap",
+		"uri": "did:plc:44ybard66vv44zksje25o7dz",
+		"val": "bladerunner",
+		"cts": "2024-10-23T17:51:19.128Z",
+		"sig": {"$bytes": "uCRNA5mTzh078T5xZtkvLEt/O+z0gsKM3aqRI/lVAB8ZtbMnznwS/JwHopZE40JhNNDj80z8gsDLAp/hWqG5Pg"}
+	}`), &l)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Verify(pub); err != nil {
+		t.Fatal(err)
+	}
+	l.Val = "replicant"
+	if err := l.Verify(pub); err == nil {
+		t.Fatal("expected tampered label to fail verification")
+	}
+}
+
+func TestSign(t *testing.T) {
+	ctx := context.Background()
+	key, err := crypto.GenerateK256()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cid := "bafyreie5737gdxlw5i64vzichcalba3z2v5n6icifvx5xytvske7mr3hpm"
+	exp := "2030-01-01T00:00:00.000Z"
+	neg := true
+	l := Label{
+		Ver: Version,
+		Src: "did:plc:labeler",
+		URI: "at://did:plc:alice/app.bsky.feed.post/3jzfcijpj2z2a",
+		Cid: &cid,
+		Val: "spam",
+		Neg: &neg,
+		Cts: "2024-10-23T17:51:19.128Z",
+		Exp: &exp,
+	}
+	if err := l.Verify(key.PublicKey()); err == nil {
+		t.Fatal("expected unsigned label to fail verification")
+	}
+	if err := l.Sign(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Verify(key.PublicKey()); err != nil {
+		t.Fatal(err)
+	}
+	if l.Expired(time.Date(2029, 1, 1, 0, 0, 0, 0, time.UTC)) || !l.Expired(time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Fatal("unexpected expiration")
+	}
+
+	b, err := json.Marshal(l)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded Label
+	if err := json.Unmarshal(b, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, l) {
+		t.Fatalf("JSON round trip gave %+v, want %+v", decoded, l)
+	}
+
+	for _, bad := range []func(l *Label){
+		func(l *Label) { l.Ver = 2 },
+		func(l *Label) { l.Src = "labeler" },
+		func(l *Label) { l.URI = "https://example.com" },
+		func(l *Label) { l.Val = "" },
+		func(l *Label) { l.Cts = "yesterday" },
+	} {
+		invalid := l
+		bad(&invalid)
+		if err := invalid.Validate(); err == nil {
+			t.Errorf("expected %+v to be invalid", invalid)
+		}
+	}
+}
+
+func TestExplicitNeg(t *testing.T) {
+	ctx := context.Background()
+	key, err := crypto.GenerateK256()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// signed by an implementation which always sends neg
+	m := map[string]any{"ver": int64(1), "src": "did:plc:labeler", "uri": "did:plc:alice", "val": "spam",
+		"neg": false, "cts": "2024-10-23T17:51:19.128Z"}
+	unsigned, err := cbor.Encode(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m["sig"], err = crypto.Sign(ctx, key, unsigned); err != nil {
+		t.Fatal(err)
+	}
+	b, err := cbor.Encode(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := cbor.Decode(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := FromMap(v.(map[string]any))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l.Neg == nil || *l.Neg || l.Negated() {
+		t.Fatalf("unexpected neg %v", l.Neg)
+	}
+	if err := l.Verify(key.PublicKey()); err != nil {
+		t.Fatal(err)
+	}
+
+	j, err := json.Marshal(l)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(j), `"neg":false`) {
+		t.Fatalf("explicit neg lost in %s", j)
+	}
+	var decoded Label
+	if err := json.Unmarshal(j, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if err := decoded.Verify(key.PublicKey()); err != nil {
+		t.Fatal(err)
+	}
+	decoded.Neg = nil
+	if err := decoded.Verify(key.PublicKey()); err == nil {
+		t.Fatal("expected label without neg to fail verification")
+	}
+}
+
+func TestLabelsEvent(t *testing.T) {
+	ctx := context.Background()
+	key, err := crypto.GenerateK256()
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := Label{Ver: Version, Src: "did:plc:labeler", URI: "did:plc:alice", Val: "!hide", Cts: "2024-10-23T17:51:19.128Z"}
+	if err := l.Sign(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	b, err := events.EncodeEvent(&Labels{Seq: 42, Labels: []Label{l}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := events.DecodeFrame(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := f.Event()
+	if err != nil {
+		t.Fatal(err)
+	}
+	labels, ok := e.(*Labels)
+	if !ok || labels.Seq != 42 || len(labels.Labels) != 1 || !reflect.DeepEqual(labels.Labels[0], l) {
+		t.Fatalf("unexpected event %+v", e)
+	}
+	if err := labels.Labels[0].Verify(key.PublicKey()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestValue(t *testing.T) {
+	for _, v := range []string{"porn", "graphic-media", ValueHide, ValueNoUnauthenticated} {
+		if err := ValidateValue(v); err != nil {
+			t.Errorf("%s: %v", v, err)
+		}
+	}
+	for _, v := range []string{"", "!", "Porn", "spam!", "no_thanks", "🙈", strings.Repeat("a", MaxValueLength+1)} {
+		if err := ValidateValue(v); err == nil {
+			t.Errorf("%q: expected an error", v)
+		}
+	}
+	if !IsGlobal(ValueTakedown) || IsGlobal("spam") {
+		t.Fatal("unexpected global values")
+	}
+}
+
+func TestSelfLabels(t *testing.T) {
+	post := map[string]any{"$type": "app.bsky.feed.post", "text": "hi",
+		"labels": NewSelfLabels("nudity", ValueNoUnauthenticated)}
+	j, err := json.Marshal(post)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := data.UnmarshalJSON(j)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, ok, err := SelfLabelsOf(v.(map[string]any))
+	if err != nil || !ok || !s.Has("nudity") || !s.Has(ValueNoUnauthenticated) || s.Has("porn") {
+		t.Fatalf("unexpected self-labels %+v, %v, %v", s, ok, err)
+	}
+
+	// as an open union member
+	var u lexicon.OpenUnion
+	err = json.Unmarshal([]byte(`{"$type":"com.atproto.label.defs#selfLabels","values":[{"val":"porn"}]}`), &u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s, ok := u.Value.(*SelfLabels); !ok || !s.Has("porn") {
+		t.Fatalf("unexpected union member %+v", u)
+	}
+
+	// through the record encoder
+	labels, err := NewSelfLabels("graphic-media").ToData()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, b, err := repo.EncodeRecord(map[string]any{"$type": "app.bsky.feed.post", "text": "hi", "labels": labels})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec, err := repo.DecodeRecord(c, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s, ok, err := SelfLabelsOf(rec); err != nil || !ok || !s.Has("graphic-media") || s.LexiconType != TypeSelfLabels {
+		t.Fatalf("unexpected self-labels %+v, %v, %v", s, ok, err)
+	}
+	var decoded SelfLabels
+	err = decoded.FromData(rec["labels"].(map[string]any))
+	if err != nil || !reflect.DeepEqual(&decoded, NewSelfLabels("graphic-media")) {
+		t.Fatalf("unexpected self-labels %+v, %v", decoded, err)
+	}
+	if err := decoded.FromData(map[string]any{"$type": "app.bsky.feed.post"}); err == nil {
+		t.Fatal("expected error for another $type")
+	}
+
+	if _, ok, err := SelfLabelsOf(map[string]any{"text": "hi"}); ok || err != nil {
+		t.Fatalf("unexpected self-labels on an unlabeled record: %v", err)
+	}
+	for _, labels := range []any{
+		"porn",
+		NewSelfLabels("Porn"),
+		NewSelfLabels(strings.Split(strings.Repeat("a,", MaxSelfLabels), ",")...),
+	} {
+		j, _ := json.Marshal(map[string]any{"labels": labels})
+		v, err := data.UnmarshalJSON(j)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := SelfLabelsOf(v.(map[string]any)); err == nil {
+			t.Errorf("%s: expected an error", j)
+		}
+	}
+}
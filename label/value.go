@@ -0,0 +1,146 @@
+package label
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/notjuliet/grove/lexicon"
+)
+
+// Global label values, defined by the protocol rather than by labelers, and prefixed with "!".
+const (
+	// Hides the content from every user, with no way to show it.
+	ValueHide = "!hide"
+	// Puts the content behind a warning which users may click through.
+	ValueWarn = "!warn"
+	// Asks apps not to show the content to logged-out users.
+	ValueNoUnauthenticated = "!no-unauthenticated"
+	// Removes the content from the network, applied by infrastructure providers.
+	ValueTakedown = "!takedown"
+	// Suspends an account for a time, applied by infrastructure providers.
+	ValueSuspend = "!suspend"
+)
+
+// $type of self-labels, the labels an account puts on its own records.
+const TypeSelfLabels = "com.atproto.label.defs#selfLabels"
+
+// Maximum number of self-labels of a record.
+const MaxSelfLabels = 10
+
+// Checks the syntax of a label value: at most MaxValueLength bytes of lowercase ASCII letters and hyphens,
+// optionally prefixed with "!" for global values.
+func ValidateValue(v string) error {
+	if len(v) > MaxValueLength {
+		return fmt.Errorf("label value must be at most %d bytes long", MaxValueLength)
+	}
+	name := strings.TrimPrefix(v, "!")
+	if name == "" {
+		return errors.New("empty label value")
+	}
+	for i := range len(name) {
+		if c := name[i]; (c < 'a' || c > 'z') && c != '-' {
+			return fmt.Errorf("label value %q may only contain lowercase letters and hyphens", v)
+		}
+	}
+	return nil
+}
+
+// Reports whether v is the value of a global label, which starts with "!".
+func IsGlobal(v string) bool {
+	return strings.HasPrefix(v, "!")
+}
+
+// Labels of a record set by its author, such as the labels field of app.bsky.feed.post records, an open
+// union decoded into *SelfLabels once this package is imported.
+type SelfLabels struct {
+	LexiconType string      `json:"$type,omitempty"`
+	Values      []SelfLabel `json:"values"`
+}
+
+type SelfLabel struct {
+	// Value of the label, such as "porn".
+	Val string `json:"val"`
+}
+
+// Creates self-labels with the given values.
+func NewSelfLabels(vals ...string) *SelfLabels {
+	s := &SelfLabels{LexiconType: TypeSelfLabels, Values: make([]SelfLabel, len(vals))}
+	for i, v := range vals {
+		s.Values[i].Val = v
+	}
+	return s
+}
+
+// Encodes the self-labels with their $type.
+func (s SelfLabels) MarshalJSON() ([]byte, error) {
+	type plain SelfLabels
+	s.LexiconType = TypeSelfLabels
+	return json.Marshal(plain(s))
+}
+
+// Returns the self-labels in the data model, with their $type, to set as the labels field of a record.
+func (s SelfLabels) ToData() (map[string]any, error) {
+	return lexicon.ToData(s)
+}
+
+// Sets the self-labels from the data model, failing on objects of another $type.
+func (s *SelfLabels) FromData(m map[string]any) error {
+	if typ, _ := m["$type"].(string); typ != "" && typ != TypeSelfLabels {
+		return fmt.Errorf("unexpected $type %q for self-labels", typ)
+	}
+	*s = SelfLabels{}
+	return lexicon.FromData(m, s)
+}
+
+// Checks the number and syntax of the values.
+func (s *SelfLabels) Validate() error {
+	if len(s.Values) > MaxSelfLabels {
+		return fmt.Errorf("%d self-labels, at most %d are allowed", len(s.Values), MaxSelfLabels)
+	}
+	for _, l := range s.Values {
+		if err := ValidateValue(l.Val); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Reports whether the self-labels include the value v.
+func (s *SelfLabels) Has(v string) bool {
+	for _, l := range s.Values {
+		if l.Val == v {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the self-labels in the labels field of a record in the data model, with false if the record has
+// none. Fails on a labels field which is not valid self-labels.
+func SelfLabelsOf(record map[string]any) (*SelfLabels, bool, error) {
+	m, ok := record["labels"].(map[string]any)
+	if !ok {
+		if record["labels"] != nil {
+			return nil, false, errors.New("record labels field is not an object")
+		}
+		return nil, false, nil
+	}
+	if typ, _ := m["$type"].(string); typ != TypeSelfLabels {
+		// other union members are not self-labels
+		return nil, false, nil
+	}
+	s := new(SelfLabels)
+	if err := s.FromData(m); err != nil {
+		return nil, false, err
+	}
+	if err := s.Validate(); err != nil {
+		return nil, false, err
+	}
+	return s, true, nil
+}
+
+func init() {
+	lexicon.RegisterType(TypeSelfLabels, func() any { return new(SelfLabels) })
+}
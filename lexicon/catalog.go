@@ -0,0 +1,167 @@
+package lexicon
+
+import (
+	"fmt"
+	"io/fs"
+	"maps"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Set of schemas, resolving references between them. A catalog is safe for concurrent use.
+type Catalog struct {
+	mtx     sync.RWMutex
+	schemas map[string]*Schema
+}
+
+func NewCatalog() *Catalog {
+	return &Catalog{schemas: make(map[string]*Schema)}
+}
+
+// Adds a schema to the catalog. Fails if a schema with the same id was already added.
+func (c *Catalog) Add(s *Schema) error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if _, ok := c.schemas[s.ID]; ok {
+		return fmt.Errorf("duplicate schema %s", s.ID)
+	}
+	c.schemas[s.ID] = s
+	return nil
+}
+
+// Parses and adds the schemas of the .json files in a directory and its subdirectories.
+func (c *Catalog) LoadFS(fsys fs.FS, dir string) error {
+	return fs.WalkDir(fsys, dir, func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || path.Ext(name) != ".json" {
+			return err
+		}
+		b, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return err
+		}
+		s, err := Parse(b)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		return c.Add(s)
+	})
+}
+
+// Returns the schema of an NSID, nil if it is not in the catalog.
+func (c *Catalog) Schema(id string) *Schema {
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+	return c.schemas[id]
+}
+
+// Returns the NSIDs of the schemas of the catalog, sorted.
+func (c *Catalog) IDs() []string {
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+	return slices.Sorted(maps.Keys(c.schemas))
+}
+
+// Returns the definition a fully qualified reference, such as Ref.Target, points to.
+func (c *Catalog) Resolve(target string) (Def, error) {
+	id, name, ok := strings.Cut(target, "#")
+	if !ok {
+		id, name = target, "main"
+	}
+	s := c.Schema(id)
+	if s == nil {
+		return nil, fmt.Errorf("schema %s not found", id)
+	}
+	d, ok := s.Defs[name]
+	if !ok {
+		return nil, fmt.Errorf("definition %s#%s not found", id, name)
+	}
+	return d, nil
+}
+
+// Checks that the references of every schema resolve: refs must not point to methods or permission sets, and
+// the refs of unions must point to objects, records or tokens.
+func (c *Catalog) Check() error {
+	for _, id := range c.IDs() {
+		s := c.Schema(id)
+		for _, name := range slices.Sorted(maps.Keys(s.Defs)) {
+			if err := c.check(s.Defs[name]); err != nil {
+				return fmt.Errorf("%s#%s: %w", id, name, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (c *Catalog) check(d Def) error {
+	switch d := d.(type) {
+	case *Record:
+		return c.check(d.Record)
+	case *Query:
+		return c.checkMethod(d.Parameters, nil, d.Output)
+	case *Procedure:
+		return c.checkMethod(d.Parameters, d.Input, d.Output)
+	case *Subscription:
+		if d.Message != nil && d.Message.Schema != nil {
+			if err := c.check(d.Message.Schema); err != nil {
+				return fmt.Errorf("message: %w", err)
+			}
+		}
+		return c.checkMethod(d.Parameters, nil, nil)
+	case *Object:
+		for _, name := range slices.Sorted(maps.Keys(d.Properties)) {
+			if err := c.check(d.Properties[name]); err != nil {
+				return fmt.Errorf("property %s: %w", name, err)
+			}
+		}
+	case *Params:
+		for _, name := range slices.Sorted(maps.Keys(d.Properties)) {
+			if err := c.check(d.Properties[name]); err != nil {
+				return fmt.Errorf("property %s: %w", name, err)
+			}
+		}
+	case *Array:
+		return c.check(d.Items)
+	case *Ref:
+		target, err := c.Resolve(d.Target)
+		if err != nil {
+			return err
+		}
+		if _, ok := target.(*Record); IsPrimary(target) && !ok {
+			return fmt.Errorf("ref to %s of type %s", d.Target, target.Type())
+		}
+	case *Union:
+		for _, t := range d.Targets {
+			target, err := c.Resolve(t)
+			if err != nil {
+				return err
+			}
+			switch target.(type) {
+			case *Object, *Record, *Token:
+			default:
+				return fmt.Errorf("union member %s of type %s", t, target.Type())
+			}
+		}
+	}
+	return nil
+}
+
+func (c *Catalog) checkMethod(params *Params, input, output *Body) error {
+	if params != nil {
+		if err := c.check(params); err != nil {
+			return fmt.Errorf("parameters: %w", err)
+		}
+	}
+	if input != nil && input.Schema != nil {
+		if err := c.check(input.Schema); err != nil {
+			return fmt.Errorf("input: %w", err)
+		}
+	}
+	if output != nil && output.Schema != nil {
+		if err := c.check(output.Schema); err != nil {
+			return fmt.Errorf("output: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,178 @@
+package lexicon
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/notjuliet/grove/data"
+)
+
+// Implemented by Go types which set themselves from the data model, such as generated types, OpenUnion and
+// blob.BlobRef.
+type dataDecoder interface {
+	FromData(m map[string]any) error
+}
+
+// Decodes an object of the data model, such as a record decoded by the cbor package, into v, a pointer to a Go
+// value decoding from lexicon JSON. Properties are matched to fields by their JSON names and values are set
+// directly, so that bytes and links in untyped fields, such as map[string]any, stay []byte and cid.CidLink.
+// Nested values with a FromData method are set with it, and those with only an UnmarshalJSON method from
+// their lexicon JSON.
+func FromData(m map[string]any, v any) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() {
+		return fmt.Errorf("decoding into %T, which is not a non-nil pointer", v)
+	}
+	if rv.Elem().Kind() != reflect.Struct {
+		return decodeValue(m, rv.Elem())
+	}
+	if _, ok := v.(dataDecoder); !ok {
+		if _, ok := v.(json.Unmarshaler); ok {
+			return fromJSON(m, v)
+		}
+	}
+	return decodeFields(m, rv.Elem())
+}
+
+// sets v from the lexicon JSON of a value of the data model
+func fromJSON(val any, v any) error {
+	b, err := data.MarshalJSON(val)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// sets the fields of a struct from the properties of an object
+func decodeFields(m map[string]any, rv reflect.Value) error {
+	t := rv.Type()
+	for i := range t.NumField() {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		val, ok := m[name]
+		if !ok {
+			continue
+		}
+		if err := decodeValue(val, rv.Field(i)); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// sets rv, which is addressable, from a value of the data model
+func decodeValue(val any, rv reflect.Value) error {
+	if val == nil {
+		rv.SetZero()
+		return nil
+	}
+	if rv.Kind() == reflect.Pointer {
+		if rv.IsNil() {
+			rv.Set(reflect.New(rv.Type().Elem()))
+		}
+		return decodeValue(val, rv.Elem())
+	}
+	x := reflect.ValueOf(val)
+	if x.Type().AssignableTo(rv.Type()) {
+		rv.Set(reflect.ValueOf(data.Clone(val)))
+		return nil
+	}
+	p := rv.Addr().Interface()
+	if d, ok := p.(dataDecoder); ok {
+		m, ok := val.(map[string]any)
+		if !ok {
+			return fmt.Errorf("expected an object, got %T", val)
+		}
+		return d.FromData(m)
+	}
+	if _, ok := p.(json.Unmarshaler); ok {
+		return fromJSON(val, p)
+	}
+
+	switch rv.Kind() {
+	case reflect.Struct:
+		if m, ok := val.(map[string]any); ok {
+			return decodeFields(m, rv)
+		}
+	case reflect.Slice:
+		if b, ok := val.([]byte); ok && rv.Type().Elem().Kind() == reflect.Uint8 {
+			rv.SetBytes(append([]byte(nil), b...))
+			return nil
+		}
+		if s, ok := val.([]any); ok {
+			out := reflect.MakeSlice(rv.Type(), len(s), len(s))
+			for i, e := range s {
+				if err := decodeValue(e, out.Index(i)); err != nil {
+					return fmt.Errorf("[%d]: %w", i, err)
+				}
+			}
+			rv.Set(out)
+			return nil
+		}
+	case reflect.Map:
+		if m, ok := val.(map[string]any); ok && rv.Type().Key().Kind() == reflect.String {
+			out := reflect.MakeMapWithSize(rv.Type(), len(m))
+			for k, e := range m {
+				elem := reflect.New(rv.Type().Elem()).Elem()
+				if err := decodeValue(e, elem); err != nil {
+					return fmt.Errorf("%s: %w", k, err)
+				}
+				out.SetMapIndex(reflect.ValueOf(k).Convert(rv.Type().Key()), elem)
+			}
+			rv.Set(out)
+			return nil
+		}
+	case reflect.String:
+		if s, ok := val.(string); ok {
+			rv.SetString(s)
+			return nil
+		}
+	case reflect.Bool:
+		if b, ok := val.(bool); ok {
+			rv.SetBool(b)
+			return nil
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		switch n := val.(type) {
+		case int64:
+			if !rv.OverflowInt(n) {
+				rv.SetInt(n)
+				return nil
+			}
+			return fmt.Errorf("integer %d out of range of %s", n, rv.Type())
+		case uint64:
+			if n <= 1<<63-1 && !rv.OverflowInt(int64(n)) {
+				rv.SetInt(int64(n))
+				return nil
+			}
+			return fmt.Errorf("integer %d out of range of %s", n, rv.Type())
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		switch n := val.(type) {
+		case int64:
+			if n >= 0 && !rv.OverflowUint(uint64(n)) {
+				rv.SetUint(uint64(n))
+				return nil
+			}
+			return fmt.Errorf("integer %d out of range of %s", n, rv.Type())
+		case uint64:
+			if !rv.OverflowUint(n) {
+				rv.SetUint(n)
+				return nil
+			}
+			return fmt.Errorf("integer %d out of range of %s", n, rv.Type())
+		}
+	}
+	return fmt.Errorf("cannot decode %s into %s", x.Type(), rv.Type())
+}
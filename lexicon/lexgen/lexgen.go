@@ -0,0 +1,698 @@
+// Package lexgen generates Go types and XRPC client functions from lexicon schemas.
+//
+// Each schema becomes a Go file declaring a struct per record and object definition, a wrapper struct per
+// closed union, a constant per token, and a function per query and procedure calling it with an *xrpc.Client.
+// Open unions are lexicon.OpenUnion values, and the objects which are records or union members encode their
+// $type and are registered with lexicon.RegisterType. Field types follow the lexicon types, with blob.BlobRef
+// for blobs: optional properties are pointers, or nil slices and maps.
+//
+// Types encode to lexicon JSON, and objects convert to and from the data model with their ToData and FromData
+// methods, to be written as records with repo.EncodeRecord.
+package lexgen
+
+import (
+	"bytes"
+	"cmp"
+	"fmt"
+	"go/format"
+	"maps"
+	"slices"
+	"strings"
+	"unicode"
+
+	"github.com/notjuliet/grove/lexicon"
+)
+
+// Options of Generate.
+type Config struct {
+	// Name of the generated package.
+	Package string
+	// Prefix trimmed from NSIDs before naming types, such as "com.example.", so that "com.example.feed.post"
+	// becomes FeedPost rather than ComExampleFeedPost.
+	TrimPrefix string
+}
+
+// Generates a Go file for each schema of a catalog, returning their formatted source by file name, such as
+// "com_example_feed_post.go". The catalog must be complete: references to schemas missing from it fail.
+func Generate(c *lexicon.Catalog, cfg Config) (map[string][]byte, error) {
+	if cfg.Package == "" {
+		return nil, fmt.Errorf("missing package name")
+	}
+	if err := c.Check(); err != nil {
+		return nil, err
+	}
+	g := &generator{catalog: c, cfg: cfg, names: make(map[string]string), typed: make(map[string]bool),
+		declared: make(map[string]string)}
+	ids := c.IDs()
+	for _, id := range ids {
+		s := c.Schema(id)
+		for name, d := range s.Defs {
+			g.names[id+"#"+name] = g.typeName(id, name)
+			g.walk(d)
+		}
+	}
+
+	// named definitions inlined where they are referenced, such as arrays, first declare their nested types
+	// in their own file
+	byID := make(map[string]*file, len(ids))
+	for _, phase := range []bool{true, false} {
+		for _, id := range ids {
+			f := byID[id]
+			if f == nil {
+				f = &file{generator: g, id: id, imports: make(map[string]bool)}
+				byID[id] = f
+			}
+			if err := f.schema(c.Schema(id), phase); err != nil {
+				return nil, fmt.Errorf("%s: %w", id, err)
+			}
+		}
+	}
+
+	files := make(map[string][]byte, len(ids))
+	for _, id := range ids {
+		src, err := byID[id].source()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", id, err)
+		}
+		files[strings.ReplaceAll(strings.ToLower(id), ".", "_")+".go"] = src
+	}
+	return files, nil
+}
+
+type generator struct {
+	catalog *lexicon.Catalog
+	cfg     Config
+	// Go type names of the definitions, by fully qualified reference
+	names map[string]string
+	// definitions which are union members, and must encode their $type
+	typed map[string]bool
+	// declared type names, with the definition declaring them
+	declared map[string]string
+}
+
+// records the union members under a definition
+func (g *generator) walk(d lexicon.Def) {
+	switch d := d.(type) {
+	case *lexicon.Record:
+		g.walk(d.Record)
+	case *lexicon.Object:
+		for _, p := range d.Properties {
+			g.walk(p)
+		}
+	case *lexicon.Array:
+		g.walk(d.Items)
+	case *lexicon.Query:
+		if d.Output != nil && d.Output.Schema != nil {
+			g.walk(d.Output.Schema)
+		}
+	case *lexicon.Procedure:
+		for _, b := range []*lexicon.Body{d.Input, d.Output} {
+			if b != nil && b.Schema != nil {
+				g.walk(b.Schema)
+			}
+		}
+	case *lexicon.Subscription:
+		if d.Message != nil && d.Message.Schema != nil {
+			g.walk(d.Message.Schema)
+		}
+	case *lexicon.Union:
+		for _, t := range d.Targets {
+			g.typed[t] = true
+		}
+	}
+}
+
+// Returns the Go type name of a definition of a schema.
+func (g *generator) typeName(id, name string) string {
+	base := exported(strings.TrimPrefix(id, g.cfg.TrimPrefix))
+	if name == "main" {
+		return base
+	}
+	return base + "_" + exported(name)
+}
+
+// generated file of a schema
+type file struct {
+	*generator
+	id      string
+	buf     bytes.Buffer
+	imports map[string]bool
+	// declarations of nested types, written after the current one
+	pending []func() error
+	// $type and Go type of the typed objects, registered for open unions
+	registered [][2]string
+}
+
+func (f *file) printf(format string, args ...any) {
+	fmt.Fprintf(&f.buf, format, args...)
+}
+
+func (f *file) use(pkg string) {
+	f.imports[pkg] = true
+}
+
+// declares a type name, failing if another definition already declared it
+func (f *file) declare(name, origin string) error {
+	if prev, ok := f.declared[name]; ok {
+		return fmt.Errorf("type %s of %s conflicts with %s", name, origin, prev)
+	}
+	f.declared[name] = origin
+	return nil
+}
+
+func (f *file) comment(desc string) {
+	if desc == "" {
+		return
+	}
+	for line := range strings.SplitSeq(strings.TrimSpace(desc), "\n") {
+		f.printf("// %s\n", strings.TrimSpace(line))
+	}
+}
+
+// declares the types of the definitions of a schema, either inlined or not
+func (f *file) schema(s *lexicon.Schema, inlined bool) error {
+	for _, name := range slices.Sorted(maps.Keys(s.Defs)) {
+		ref, d := s.ID+"#"+name, s.Defs[name]
+		var err error
+		switch d.(type) {
+		case *lexicon.Array:
+			if inlined {
+				_, err = f.goType(d, f.names[ref], ref)
+			}
+		default:
+			if !inlined {
+				err = f.def(ref, d)
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		for len(f.pending) > 0 {
+			next := f.pending[0]
+			f.pending = f.pending[1:]
+			if err := next(); err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (f *file) def(ref string, d lexicon.Def) error {
+	name := f.names[ref]
+	switch d := d.(type) {
+	case *lexicon.Record:
+		desc := cmp.Or(d.Description, d.Record.Description)
+		return f.object(name, ref, desc, d.Record, true)
+	case *lexicon.Object:
+		return f.object(name, ref, d.Description, d, f.typed[ref])
+	case *lexicon.Token:
+		if err := f.declare(name, ref); err != nil {
+			return err
+		}
+		f.comment(d.Description)
+		f.printf("const %s = %q\n\n", name, lexicon.TypeName(ref))
+	case *lexicon.Query:
+		return f.method(name, ref, d.Description, d.Parameters, nil, d.Output)
+	case *lexicon.Procedure:
+		return f.method(name, ref, d.Description, d.Parameters, d.Input, d.Output)
+	case *lexicon.Subscription:
+		if d.Parameters != nil {
+			if err := f.params(name+"_Params", ref, d.Parameters); err != nil {
+				return err
+			}
+		}
+		if d.Message != nil && d.Message.Schema != nil {
+			if _, err := f.goType(d.Message.Schema, name+"_Message", ref); err != nil {
+				return err
+			}
+		}
+	}
+	// other definitions are inlined where they are referenced
+	return nil
+}
+
+// declares a struct for an object, encoding its $type if typed
+func (f *file) object(name, ref, desc string, obj *lexicon.Object, typed bool) error {
+	if err := f.declare(name, ref); err != nil {
+		return err
+	}
+	type field struct {
+		name, prop, typ, tags string
+		desc                  string
+	}
+	var fields []field
+	seen := make(map[string]string)
+	for _, prop := range slices.Sorted(maps.Keys(obj.Properties)) {
+		d := obj.Properties[prop]
+		fieldName := exported(prop)
+		if other, ok := seen[fieldName]; ok {
+			return fmt.Errorf("properties %s and %s have the same field name", prop, other)
+		}
+		if fieldName == "LexiconType" {
+			return fmt.Errorf("property %s conflicts with the $type field", prop)
+		}
+		seen[fieldName] = prop
+		typ, err := f.goType(d, name+"_"+fieldName, ref)
+		if err != nil {
+			return fmt.Errorf("property %s: %w", prop, err)
+		}
+		required := slices.Contains(obj.Required, prop)
+		nullable := slices.Contains(obj.Nullable, prop)
+		if (!required || nullable) && !nilable(typ) {
+			typ = "*" + typ
+		}
+		opts := ""
+		if !required {
+			opts = ",omitempty"
+		}
+		fields = append(fields, field{name: fieldName, prop: prop, typ: typ, desc: f.description(d),
+			tags: fmt.Sprintf(`json:"%s%s"`, prop, opts)})
+	}
+
+	f.comment(desc)
+	f.printf("type %s struct {\n", name)
+	if typed {
+		f.printf("LexiconType string `json:\"$type,omitempty\"`\n")
+	}
+	for _, fd := range fields {
+		f.comment(fd.desc)
+		f.printf("%s %s `%s`\n", fd.name, fd.typ, fd.tags)
+	}
+	f.printf("}\n\n")
+	f.use("github.com/notjuliet/grove/lexicon")
+	f.printf("// Returns the object in the data model.\n")
+	f.printf("func (v %s) ToData() (map[string]any, error) {\nreturn lexicon.ToData(v)\n}\n\n", name)
+	f.printf("// Sets the object from the data model.\n")
+	f.printf("func (v *%s) FromData(m map[string]any) error {\n*v = %s{}\nreturn lexicon.FromData(m, v)\n}\n\n",
+		name, name)
+	if typed {
+		f.registered = append(f.registered, [2]string{lexicon.TypeName(ref), name})
+		f.use("encoding/json")
+		f.printf("// Encodes the object with its $type.\n")
+		f.printf("func (v %s) MarshalJSON() ([]byte, error) {\n", name)
+		f.printf("type plain %s\n", name)
+		f.printf("v.LexiconType = %q\n", lexicon.TypeName(ref))
+		f.printf("return json.Marshal(plain(v))\n}\n\n")
+	}
+	return nil
+}
+
+// Returns the Go type of a definition, declaring the types of nested objects and unions under name.
+func (f *file) goType(d lexicon.Def, name, ref string) (string, error) {
+	switch d := d.(type) {
+	case *lexicon.String, *lexicon.Token:
+		return "string", nil
+	case *lexicon.Integer:
+		return "int64", nil
+	case *lexicon.Boolean:
+		return "bool", nil
+	case *lexicon.Bytes:
+		f.use("github.com/notjuliet/grove/data")
+		return "data.Bytes", nil
+	case *lexicon.CidLink:
+		f.use("github.com/notjuliet/grove/cid")
+		return "cid.CidLink", nil
+	case *lexicon.Blob:
+		f.use("github.com/notjuliet/grove/blob")
+		return "blob.BlobRef", nil
+	case *lexicon.Unknown:
+		return "map[string]any", nil
+	case *lexicon.Array:
+		items, err := f.goType(d.Items, name+"_Elem", ref)
+		if err != nil {
+			return "", err
+		}
+		return "[]" + items, nil
+	case *lexicon.Object:
+		f.pending = append(f.pending, func() error {
+			if f.declared[name] == ref {
+				return nil
+			}
+			return f.object(name, ref, d.Description, d, false)
+		})
+		return name, nil
+	case *lexicon.Ref:
+		target, err := f.catalog.Resolve(d.Target)
+		if err != nil {
+			return "", err
+		}
+		switch target.(type) {
+		case *lexicon.Record, *lexicon.Object:
+			return f.names[d.Target], nil
+		}
+		// named definitions of other types are inlined, nesting their types under their own name
+		return f.goType(target, f.names[d.Target], d.Target)
+	case *lexicon.Union:
+		if !d.Closed {
+			f.use("github.com/notjuliet/grove/lexicon")
+			return "lexicon.OpenUnion", nil
+		}
+		f.pending = append(f.pending, func() error {
+			if f.declared[name] == ref {
+				return nil
+			}
+			return f.union(name, ref, d)
+		})
+		return name, nil
+	}
+	return "", fmt.Errorf("unsupported type %s", d.Type())
+}
+
+// declares a wrapper struct for a closed union, with a field per member
+func (f *file) union(name, ref string, u *lexicon.Union) error {
+	if err := f.declare(name, ref); err != nil {
+		return err
+	}
+	f.use("encoding/json")
+	// members of the same schema are named after their definition
+	members, types := make([]string, len(u.Targets)), make([]string, len(u.Targets))
+	for i, t := range u.Targets {
+		types[i] = f.names[t]
+		members[i] = types[i]
+		if id, def, _ := strings.Cut(t, "#"); id == f.id && def != "main" {
+			members[i] = exported(def)
+		}
+	}
+	if len(slices.Compact(slices.Sorted(slices.Values(members)))) < len(members) {
+		members = types
+	}
+	f.printf("// Union of %s. Exactly one member must be set.\n", strings.Join(types, ", "))
+	f.printf("type %s struct {\n", name)
+	for i, m := range members {
+		f.printf("%s *%s\n", m, types[i])
+	}
+	f.printf("}\n\n")
+
+	f.use("errors")
+	f.printf("func (u %s) MarshalJSON() ([]byte, error) {\nswitch {\n", name)
+	for _, m := range members {
+		f.printf("case u.%s != nil:\nreturn json.Marshal(u.%s)\n", m, m)
+	}
+	f.printf("}\nreturn nil, errors.New(\"empty union %s\")\n}\n\n", name)
+
+	f.use("fmt")
+	f.printf("func (u *%s) UnmarshalJSON(b []byte) error {\n", name)
+	f.printf("var head struct {\nType string `json:\"$type\"`\n}\n")
+	f.printf("if err := json.Unmarshal(b, &head); err != nil {\nreturn err\n}\n")
+	f.printf("*u = %s{}\nswitch head.Type {\n", name)
+	for i, t := range u.Targets {
+		f.printf("case %q:\nu.%s = new(%s)\nreturn json.Unmarshal(b, u.%s)\n", lexicon.TypeName(t), members[i],
+			types[i], members[i])
+	}
+	f.printf("}\nreturn fmt.Errorf(\"unexpected $type %%q in union %s\", head.Type)\n}\n\n", name)
+	return nil
+}
+
+// declares the parameters struct of a method, with a method converting it to xrpc.Params
+func (f *file) params(name, ref string, p *lexicon.Params) error {
+	if err := f.declare(name, ref); err != nil {
+		return err
+	}
+	f.use("github.com/notjuliet/grove/xrpc")
+	type field struct {
+		name, prop, typ string
+		required        bool
+		desc            string
+	}
+	var fields []field
+	for _, prop := range slices.Sorted(maps.Keys(p.Properties)) {
+		d := p.Properties[prop]
+		typ, err := f.goType(d, name+"_"+exported(prop), ref)
+		if err != nil {
+			return fmt.Errorf("parameter %s: %w", prop, err)
+		}
+		if typ == "map[string]any" {
+			typ = "any"
+		}
+		required := slices.Contains(p.Required, prop)
+		if !required && !nilable(typ) {
+			typ = "*" + typ
+		}
+		fields = append(fields, field{name: exported(prop), prop: prop, typ: typ, required: required,
+			desc: f.description(d)})
+	}
+
+	f.comment(cmp.Or(p.Description, "Parameters of "+strings.TrimSuffix(name, "_Params")+"."))
+	f.printf("type %s struct {\n", name)
+	for _, fd := range fields {
+		f.comment(fd.desc)
+		if fd.required {
+			f.printf("%s %s `param:\"%s,required\" json:\"%s\"`\n", fd.name, fd.typ, fd.prop, fd.prop)
+		} else {
+			f.printf("%s %s `param:\"%s\" json:\"%s,omitempty\"`\n", fd.name, fd.typ, fd.prop, fd.prop)
+		}
+	}
+	f.printf("}\n\n")
+
+	f.printf("func (p *%s) xrpcParams() xrpc.Params {\nif p == nil {\nreturn nil\n}\n", name)
+	f.printf("params := xrpc.Params{}\n")
+	for _, fd := range fields {
+		switch {
+		case strings.HasPrefix(fd.typ, "[]"):
+			f.printf("if p.%s != nil {\nvalues := make([]any, len(p.%s))\n", fd.name, fd.name)
+			f.printf("for i, v := range p.%s {\nvalues[i] = v\n}\nparams[%q] = values\n}\n", fd.name, fd.prop)
+		case strings.HasPrefix(fd.typ, "*"):
+			f.printf("if p.%s != nil {\nparams[%q] = *p.%s\n}\n", fd.name, fd.prop, fd.name)
+		default:
+			f.printf("params[%q] = p.%s\n", fd.prop, fd.name)
+		}
+	}
+	f.printf("return params\n}\n\n")
+	return nil
+}
+
+// declares the types of a query or procedure, and a function calling it
+func (f *file) method(name, ref, desc string, params *lexicon.Params, input, output *lexicon.Body) error {
+	nsid := lexicon.TypeName(ref)
+	args := []string{"ctx context.Context", "c *xrpc.Client"}
+	paramsArg := "nil"
+	if params != nil {
+		if err := f.params(name+"_Params", ref, params); err != nil {
+			return err
+		}
+		args = append(args, "params *"+name+"_Params")
+		paramsArg = "params.xrpcParams()"
+	}
+
+	var inputArg, encoding string
+	if input != nil {
+		switch {
+		case !isJSON(input.Encoding):
+			f.use("io")
+			args = append(args, "input io.Reader")
+			inputArg = "input"
+			encoding = input.Encoding
+			if strings.Contains(encoding, "*") {
+				args = append(args, "encoding string")
+				encoding = ""
+			}
+		case input.Schema == nil:
+			args = append(args, "input any")
+			inputArg = "input"
+		default:
+			typ, err := f.bodyType(input, name+"_Input", ref)
+			if err != nil {
+				return fmt.Errorf("input: %w", err)
+			}
+			args = append(args, "input "+typ)
+			inputArg = "input"
+		}
+	}
+
+	var outType string
+	if output != nil {
+		switch {
+		case !isJSON(output.Encoding):
+			outType = "[]byte"
+		case output.Schema == nil:
+			outType = "map[string]any"
+		default:
+			typ, err := f.bodyType(output, name+"_Output", ref)
+			if err != nil {
+				return fmt.Errorf("output: %w", err)
+			}
+			outType = strings.TrimPrefix(typ, "*")
+		}
+	}
+
+	if err := f.declare(name, ref); err != nil {
+		return err
+	}
+	f.use("context")
+	f.use("github.com/notjuliet/grove/xrpc")
+	f.comment(cmp.Or(desc, "Calls "+nsid+"."))
+	results := "error"
+	if outType != "" {
+		results = fmt.Sprintf("(%s, error)", ptrTo(outType))
+	}
+	f.printf("func %s(%s) %s {\n", name, strings.Join(args, ", "), results)
+	outArg, fail := "nil", "return err"
+	if outType != "" {
+		f.printf("var out %s\n", outType)
+		outArg, fail = "&out", "return nil, err"
+	}
+	switch {
+	case input == nil:
+		f.printf("if err := c.Query(ctx, %q, %s, %s); err != nil {\n", nsid, paramsArg, outArg)
+	case !isJSON(input.Encoding):
+		f.use("net/http")
+		enc := "encoding"
+		if encoding != "" {
+			enc = fmt.Sprintf("%q", encoding)
+		}
+		f.printf("req := &xrpc.Request{Method: http.MethodPost, NSID: %q, Params: %s, Input: %s, Encoding: %s, "+
+			"Output: %s}\n", nsid, paramsArg, inputArg, enc, outArg)
+		f.printf("if _, err := c.Do(ctx, req); err != nil {\n")
+	default:
+		f.printf("if err := c.Procedure(ctx, %q, %s, %s, %s); err != nil {\n", nsid, paramsArg, inputArg, outArg)
+	}
+	f.printf("%s\n}\n", fail)
+	if outType != "" {
+		if nilable(outType) {
+			f.printf("return out, nil\n}\n\n")
+		} else {
+			f.printf("return &out, nil\n}\n\n")
+		}
+	} else {
+		f.printf("return nil\n}\n\n")
+	}
+	return nil
+}
+
+// Returns the Go type of a JSON body: a pointer to a struct for objects.
+func (f *file) bodyType(b *lexicon.Body, name, ref string) (string, error) {
+	typ, err := f.goType(b.Schema, name, ref)
+	if err != nil {
+		return "", err
+	}
+	return ptrTo(typ), nil
+}
+
+// Returns the formatted source of the file.
+func (f *file) source() ([]byte, error) {
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "// Code generated by lexgen from %s. DO NOT EDIT.\n\npackage %s\n\n", f.id, f.cfg.Package)
+	if len(f.imports) > 0 {
+		out.WriteString("import (\n")
+		pkgs := slices.Sorted(maps.Keys(f.imports))
+		for _, pkg := range pkgs {
+			if !strings.Contains(pkg, ".") {
+				fmt.Fprintf(&out, "%q\n", pkg)
+			}
+		}
+		out.WriteString("\n")
+		for _, pkg := range pkgs {
+			if strings.Contains(pkg, ".") {
+				fmt.Fprintf(&out, "%q\n", pkg)
+			}
+		}
+		out.WriteString(")\n\n")
+	}
+	out.Write(f.buf.Bytes())
+	if len(f.registered) > 0 {
+		out.WriteString("func init() {\n")
+		for _, r := range f.registered {
+			fmt.Fprintf(&out, "lexicon.RegisterType(%q, func() any { return new(%s) })\n", r[0], r[1])
+		}
+		out.WriteString("}\n")
+	}
+	src, err := format.Source(out.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("formatting generated code: %w", err)
+	}
+	return src, nil
+}
+
+func isJSON(encoding string) bool {
+	return encoding == "application/json"
+}
+
+// reports whether a Go type may be nil without a pointer
+func nilable(typ string) bool {
+	return strings.HasPrefix(typ, "[]") || strings.HasPrefix(typ, "map[") || strings.HasPrefix(typ, "*") ||
+		typ == "any" || typ == "data.Bytes"
+}
+
+func ptrTo(typ string) string {
+	if nilable(typ) {
+		return typ
+	}
+	return "*" + typ
+}
+
+// Returns the description of a property, listing the member types of open unions.
+func (f *file) description(d lexicon.Def) string {
+	if u, ok := d.(*lexicon.Union); ok && !u.Closed {
+		types := make([]string, len(u.Targets))
+		for i, t := range u.Targets {
+			types[i] = f.names[t]
+		}
+		return strings.TrimSpace(u.Description + "\nOpen union of " + strings.Join(types, ", ") + ".")
+	}
+	switch d := d.(type) {
+	case *lexicon.Object:
+		return d.Description
+	case *lexicon.Array:
+		return d.Description
+	case *lexicon.String:
+		return d.Description
+	case *lexicon.Integer:
+		return d.Description
+	case *lexicon.Boolean:
+		return d.Description
+	case *lexicon.Bytes:
+		return d.Description
+	case *lexicon.CidLink:
+		return d.Description
+	case *lexicon.Blob:
+		return d.Description
+	case *lexicon.Unknown:
+		return d.Description
+	case *lexicon.Ref:
+		return d.Description
+	case *lexicon.Union:
+		return d.Description
+	}
+	return ""
+}
+
+// initialisms kept uppercase in Go names
+var initialisms = map[string]string{
+	"api": "API", "cid": "CID", "did": "DID", "http": "HTTP", "id": "ID", "json": "JSON", "jwt": "JWT",
+	"nsid": "NSID", "tid": "TID", "uri": "URI", "url": "URL",
+}
+
+// Returns an exported Go name for a lexicon name, such as "CreatedAt" for "createdAt", "FeedPost" for
+// "feed.post" or "CIDLink" for "cid-link".
+func exported(s string) string {
+	var b strings.Builder
+	var word []rune
+	flush := func() {
+		if len(word) == 0 {
+			return
+		}
+		if up, ok := initialisms[strings.ToLower(string(word))]; ok {
+			b.WriteString(up)
+		} else {
+			word[0] = unicode.ToUpper(word[0])
+			b.WriteString(string(word))
+		}
+		word = word[:0]
+	}
+	var prev rune
+	for _, r := range s {
+		switch {
+		case !unicode.IsLetter(r) && !unicode.IsDigit(r):
+			flush()
+		case unicode.IsUpper(r) && unicode.IsLower(prev):
+			flush()
+			word = append(word, r)
+		default:
+			word = append(word, r)
+		}
+		prev = r
+	}
+	flush()
+	return b.String()
+}
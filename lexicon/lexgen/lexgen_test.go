@@ -0,0 +1,193 @@
+package lexgen
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/notjuliet/grove/lexicon"
+)
+
+func testCatalog(t *testing.T) *lexicon.Catalog {
+	t.Helper()
+	c := lexicon.NewCatalog()
+	if err := c.LoadFS(os.DirFS("../testdata"), "catalog"); err != nil {
+		t.Fatal(err)
+	}
+	defs, err := lexicon.Parse([]byte(`{"lexicon": 1, "id": "app.bsky.actor.defs", "defs": {
+		"preferences": {"type": "array", "items": {"type": "union", "refs": ["#adultContentPref"]}},
+		"adultContentPref": {"type": "object", "required": ["enabled"], "properties": {"enabled": {"type": "boolean"}}}}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Add(defs); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestGenerate(t *testing.T) {
+	files, err := Generate(testCatalog(t), Config{Package: "example", TrimPrefix: "example.lexicon."})
+	if err != nil {
+		t.Fatal(err)
+	}
+	record := string(files["example_lexicon_record.go"])
+	for _, s := range []string{
+		"package example",
+		"type Record struct {",
+		"LexiconType string `json:\"$type,omitempty\"`",
+		"Integer int64 `json:\"integer\"`",
+		"Bytes data.Bytes `json:\"bytes,omitempty\"`",
+		"CIDLink *cid.CidLink `json:\"cid-link,omitempty\"`",
+		"Ref *Record_DemoObject",
+		"SizeBlob *blob.BlobRef `json:\"sizeBlob,omitempty\"`",
+		"const Record_DemoToken = \"example.lexicon.record#demoToken\"",
+		"// Open union of Record_DemoObject, Record_DemoObjectTwo.",
+		"Union *lexicon.OpenUnion `json:\"union,omitempty\"`",
+		"func (v Record) ToData() (map[string]any, error) {",
+		"func (v *Record) FromData(m map[string]any) error {",
+		"lexicon.RegisterType(\"example.lexicon.record#demoObjectTwo\", func() any { return new(Record_DemoObjectTwo) })",
+		"type Record_ClosedUnion struct {",
+		"DemoObject *Record_DemoObject",
+		"return fmt.Errorf(\"unexpected $type %q in union Record_ClosedUnion\", head.Type)",
+	} {
+		if !strings.Contains(strings.Join(strings.Fields(record), " "), strings.Join(strings.Fields(s), " ")) {
+			t.Errorf("generated record lacks %s", s)
+		}
+	}
+	query := string(files["example_lexicon_query.go"])
+	for _, s := range []string{
+		"func Query(ctx context.Context, c *xrpc.Client, params *Query_Params) (*Query_Output, error) {",
+		"StringField string `param:\"stringField,required\" json:\"stringField\"`",
+		"c.Query(ctx, \"example.lexicon.query\", params.xrpcParams(), &out)",
+	} {
+		if !strings.Contains(query, s) {
+			t.Errorf("generated query lacks %s", s)
+		}
+	}
+	if !strings.Contains(string(files["example_lexicon_procedure.go"]), "Preferences []lexicon.OpenUnion") {
+		t.Error("named array not inlined")
+	}
+
+	if _, err := Generate(testCatalog(t), Config{}); err == nil {
+		t.Fatal("expected error without a package")
+	}
+	// both schemas are named ExampleLexiconRecordDemo
+	clash := testCatalog(t)
+	for _, id := range []string{"example.lexicon.record.demo", "example.lexicon.recordDemo"} {
+		s, err := lexicon.Parse([]byte(`{"lexicon": 1, "id": "` + id + `", "defs": {"main": {"type": "token"}}}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := clash.Add(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := Generate(clash, Config{Package: "example"}); err == nil || !strings.Contains(err.Error(), "conflicts") {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	if testing.Short() {
+		t.Skip("skipping build of generated code")
+	}
+	// build the generated package inside the module, in a directory ignored by ./...
+	dir, err := os.MkdirTemp(".", "_generated")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	files["roundtrip_test.go"] = []byte(roundTripTest)
+	for name, src := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), src, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, args := range [][]string{{"vet", "./" + dir}, {"test", "-count=1", "./" + dir}} {
+		out, err := exec.Command("go", args...).CombinedOutput()
+		if err != nil {
+			t.Fatalf("go %s: %v: %s", args[0], err, out)
+		}
+	}
+}
+
+// test of the generated package, converting a record through the data model and the record encoder
+const roundTripTest = `package example
+
+import (
+	"testing"
+
+	"github.com/notjuliet/grove/blob"
+	"github.com/notjuliet/grove/cid"
+	"github.com/notjuliet/grove/data"
+	"github.com/notjuliet/grove/lexicon"
+	"github.com/notjuliet/grove/repo"
+)
+
+func TestRoundTrip(t *testing.T) {
+	c, err := cid.Create(cid.CodecRaw, []byte("blob"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	link, ref, two, text := c.Link(), blob.NewBlobRef(c, "image/png", 4), int64(2), "hello"
+	rec := Record{
+		Integer:     7,
+		String:      &text,
+		Bytes:       data.Bytes("bytes"),
+		CIDLink:     &link,
+		Blob:        &ref,
+		Array:       []int64{1, 2},
+		Ref:         &Record_DemoObject{A: &two},
+		Union:       &lexicon.OpenUnion{Value: &Record_DemoObjectTwo{C: &two}},
+		ClosedUnion: &Record_ClosedUnion{DemoObject: &Record_DemoObject{B: &two}},
+	}
+	m, err := rec.ToData()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m["$type"] != "example.lexicon.record" {
+		t.Fatalf("unexpected $type %v", m["$type"])
+	}
+	rc, b, err := repo.EncodeRecord(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := repo.DecodeRecord(rc, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got Record
+	if err := got.FromData(decoded); err != nil {
+		t.Fatal(err)
+	}
+	if got.Integer != 7 || *got.String != "hello" || string(got.Bytes) != "bytes" || got.CIDLink.String() != link.String() ||
+		got.Blob.Cid().String() != c.String() || len(got.Array) != 2 || *got.Ref.A != 2 ||
+		*got.Union.Value.(*Record_DemoObjectTwo).C != 2 || *got.ClosedUnion.DemoObject.B != 2 {
+		t.Fatalf("unexpected record %+v", got)
+	}
+	again, err := got.ToData()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ac, _, err := repo.EncodeRecord(again); err != nil || ac.String() != rc.String() {
+		t.Fatalf("record changed through the round trip: %s, %v", ac, err)
+	}
+}
+`
+
+func TestExported(t *testing.T) {
+	for s, expected := range map[string]string{
+		"createdAt":          "CreatedAt",
+		"cid-link":           "CIDLink",
+		"app.bsky.feed.post": "AppBskyFeedPost",
+		"subjectUri":         "SubjectURI",
+		"did":                "DID",
+		"image_url":          "ImageURL",
+		"v2":                 "V2",
+	} {
+		if got := exported(s); got != expected {
+			t.Errorf("exported(%q) = %s, expected %s", s, got, expected)
+		}
+	}
+}
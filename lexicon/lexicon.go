@@ -0,0 +1,254 @@
+// Package lexicon parses lexicon schemas, the JSON documents defining the records and methods of atproto
+// applications, and resolves the references between them.
+//
+// https://atproto.com/specs/lexicon
+package lexicon
+
+import (
+	"strings"
+)
+
+// Version of the lexicon language supported by the parser.
+const Version = 1
+
+// Lexicon document, defining the types of a single NSID.
+type Schema struct {
+	Lexicon     int
+	ID          string
+	Revision    int
+	Description string
+	// Named definitions. The "main" definition is named after the NSID itself, and is the only one which may
+	// be a primary type: a record, query, procedure, subscription or permission set.
+	Defs map[string]Def
+}
+
+// Definition or field type of a schema: one of *Record, *Query, *Procedure, *Subscription, *PermissionSet,
+// *Object, *Params, *Array, *Token, *String, *Integer, *Boolean, *Bytes, *CidLink, *Blob, *Unknown, *Ref and
+// *Union.
+type Def interface {
+	// Returns the lexicon type name, such as "record" or "cid-link".
+	Type() string
+}
+
+// Record type, stored in repositories under the NSID of its schema.
+type Record struct {
+	Description string
+	// Record key type: "tid", "nsid", "any", or "literal:" followed by the only allowed key.
+	Key    string
+	Record *Object
+}
+
+// Query method, called with HTTP GET.
+type Query struct {
+	Description string
+	Parameters  *Params
+	Output      *Body
+	Errors      []Error
+}
+
+// Procedure method, called with HTTP POST.
+type Procedure struct {
+	Description string
+	Parameters  *Params
+	Input       *Body
+	Output      *Body
+	Errors      []Error
+}
+
+// Event stream method, served over WebSocket.
+type Subscription struct {
+	Description string
+	Parameters  *Params
+	Message     *Message
+	Errors      []Error
+}
+
+// Named set of permissions granted together in OAuth scopes.
+type PermissionSet struct {
+	Description string
+	Title       string
+	TitleLang   map[string]string
+	Detail      string
+	DetailLang  map[string]string
+	Permissions []Permission
+}
+
+// Permission of a permission set.
+type Permission struct {
+	// Kind of resource, such as "repo" or "rpc".
+	Resource   string
+	Collection []string
+	Action     []string
+	LXM        []string
+	Aud        string
+	InheritAud bool
+}
+
+// Input or output body of a method.
+type Body struct {
+	Description string
+	// MIME type, such as "application/json" or "*/*".
+	Encoding string
+	// Schema of a JSON body: an *Object, *Ref or *Union, nil if the body is not described.
+	Schema Def
+}
+
+// Messages of a subscription.
+type Message struct {
+	Description string
+	// Usually a *Union of the message types.
+	Schema Def
+}
+
+// Error a method may fail with.
+type Error struct {
+	Name        string
+	Description string
+}
+
+type Object struct {
+	Description string
+	Required    []string
+	// Properties which may be null rather than absent.
+	Nullable   []string
+	Properties map[string]Def
+}
+
+// Query parameters of a method, limited to booleans, integers, strings, unknowns, and arrays of those.
+type Params struct {
+	Description string
+	Required    []string
+	Properties  map[string]Def
+}
+
+type Array struct {
+	Description string
+	Items       Def
+	MinLength   *int
+	MaxLength   *int
+}
+
+// Named value with no data, referenced from string knownValues.
+type Token struct {
+	Description string
+}
+
+type String struct {
+	Description string
+	// Format the string must conform to, such as "did" or "datetime".
+	Format string
+	// Bounds of the length in UTF-8 bytes.
+	MinLength *int
+	MaxLength *int
+	// Bounds of the length in grapheme clusters.
+	MinGraphemes *int
+	MaxGraphemes *int
+	// Suggested values, which do not restrict the string.
+	KnownValues []string
+	// Allowed values.
+	Enum    []string
+	Const   *string
+	Default *string
+}
+
+type Integer struct {
+	Description string
+	Minimum     *int64
+	Maximum     *int64
+	Enum        []int64
+	Const       *int64
+	Default     *int64
+}
+
+type Boolean struct {
+	Description string
+	Const       *bool
+	Default     *bool
+}
+
+type Bytes struct {
+	Description string
+	MinLength   *int
+	MaxLength   *int
+}
+
+type CidLink struct {
+	Description string
+}
+
+type Blob struct {
+	Description string
+	// Accepted MIME types, possibly with wildcards such as "image/*". Empty accepts any type.
+	Accept []string
+	// Maximum size in bytes.
+	MaxSize *int64
+}
+
+// Any object with a $type, or any data for legacy schemas.
+type Unknown struct {
+	Description string
+}
+
+// Reference to a named definition.
+type Ref struct {
+	Description string
+	// Reference as written, such as "#view" or "com.atproto.repo.strongRef".
+	Ref string
+	// Fully qualified reference, such as "app.bsky.feed.defs#view" or "com.atproto.repo.strongRef#main".
+	Target string
+}
+
+// Objects of any of several types, distinguished by their $type.
+type Union struct {
+	Description string
+	// References as written.
+	Refs []string
+	// Fully qualified references.
+	Targets []string
+	// Whether objects of types not listed are rejected, rather than allowed for future extension.
+	Closed bool
+}
+
+func (*Record) Type() string        { return "record" }
+func (*Query) Type() string         { return "query" }
+func (*Procedure) Type() string     { return "procedure" }
+func (*Subscription) Type() string  { return "subscription" }
+func (*PermissionSet) Type() string { return "permission-set" }
+func (*Object) Type() string        { return "object" }
+func (*Params) Type() string        { return "params" }
+func (*Array) Type() string         { return "array" }
+func (*Token) Type() string         { return "token" }
+func (*String) Type() string        { return "string" }
+func (*Integer) Type() string       { return "integer" }
+func (*Boolean) Type() string       { return "boolean" }
+func (*Bytes) Type() string         { return "bytes" }
+func (*CidLink) Type() string       { return "cid-link" }
+func (*Blob) Type() string          { return "blob" }
+func (*Unknown) Type() string       { return "unknown" }
+func (*Ref) Type() string           { return "ref" }
+func (*Union) Type() string         { return "union" }
+
+// Reports whether a definition is of a primary type, which only the main definition of a schema may be.
+func IsPrimary(d Def) bool {
+	switch d.(type) {
+	case *Record, *Query, *Procedure, *Subscription, *PermissionSet:
+		return true
+	}
+	return false
+}
+
+// Qualifies a reference made from the schema id: "#name" becomes "id#name", and "nsid" becomes "nsid#main".
+func QualifyRef(id, ref string) string {
+	if strings.HasPrefix(ref, "#") {
+		return id + ref
+	}
+	if !strings.Contains(ref, "#") {
+		return ref + "#main"
+	}
+	return ref
+}
+
+// Returns the $type of objects of a definition: its NSID for main definitions, "nsid#name" otherwise.
+func TypeName(target string) string {
+	return strings.TrimSuffix(target, "#main")
+}
@@ -0,0 +1,406 @@
+package lexicon
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/notjuliet/grove/cbor"
+	"github.com/notjuliet/grove/cid"
+	"github.com/notjuliet/grove/errdefs"
+)
+
+type lexiconFixture struct {
+	Name    string          `json:"name"`
+	Lexicon json.RawMessage `json:"lexicon"`
+}
+
+func loadFixtures[T any](t *testing.T, name string) []T {
+	t.Helper()
+	raw, err := os.ReadFile("testdata/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fixtures []T
+	if err := json.Unmarshal(raw, &fixtures); err != nil {
+		t.Fatal(err)
+	}
+	return fixtures
+}
+
+func TestParse(t *testing.T) {
+	for _, f := range loadFixtures[lexiconFixture](t, "lexicon-valid.json") {
+		if _, err := Parse(f.Lexicon); err != nil {
+			t.Errorf("%s: %v", f.Name, err)
+		}
+	}
+	for _, f := range loadFixtures[lexiconFixture](t, "lexicon-invalid.json") {
+		if _, err := Parse(f.Lexicon); err == nil {
+			t.Errorf("%s: expected error", f.Name)
+		}
+	}
+
+	for _, doc := range []string{
+		`{"lexicon": 1, "id": "com.example.a", "defs": {"main": {"type": "string", "format": "colour"}}}`,
+		`{"lexicon": 1, "id": "com.example.a", "defs": {"main": {"type": "record", "key": "uuid",
+			"record": {"type": "object"}}}}`,
+		`{"lexicon": 1, "id": "com.example.a", "defs": {"main": {"type": "object", "required": ["a"]}}}`,
+		`{"lexicon": 1, "id": "com.example.a", "defs": {"main": {"type": "query",
+			"parameters": {"type": "params", "properties": {"a": {"type": "blob"}}}}}}`,
+		`{"lexicon": 1, "id": "com.example.a", "defs": {"main": {"type": "query",
+			"output": {"encoding": "application/json", "schema": {"type": "string"}}}}}`,
+		`{"lexicon": 1, "id": "com.example.a", "defs": {"main": {"type": "object",
+			"properties": {"a": {"type": "ref", "ref": "#"}}}}}`,
+		`{"lexicon": 1, "id": "com.example.a", "defs": {"main": {"type": "object",
+			"properties": {"a": {"type": "record", "key": "tid", "record": {"type": "object"}}}}}}`,
+	} {
+		if _, err := Parse([]byte(doc)); err == nil {
+			t.Errorf("expected error for %s", doc)
+		}
+	}
+
+	s, err := Parse([]byte(`{"lexicon": 1, "id": "com.example.a", "defs": {
+		"main": {"type": "object", "properties": {
+			"local": {"type": "ref", "ref": "#b"},
+			"main": {"type": "ref", "ref": "com.example.c"},
+			"union": {"type": "union", "refs": ["#b", "com.example.c#d"], "closed": true}}},
+		"b": {"type": "token"}}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	props := s.Defs["main"].(*Object).Properties
+	if ref := props["local"].(*Ref); ref.Ref != "#b" || ref.Target != "com.example.a#b" {
+		t.Fatalf("unexpected ref %+v", ref)
+	}
+	if ref := props["main"].(*Ref); ref.Target != "com.example.c#main" || TypeName(ref.Target) != "com.example.c" {
+		t.Fatalf("unexpected ref %+v", ref)
+	}
+	if u := props["union"].(*Union); !u.Closed || strings.Join(u.Targets, " ") != "com.example.a#b com.example.c#d" {
+		t.Fatalf("unexpected union %+v", u)
+	}
+}
+
+func TestCatalog(t *testing.T) {
+	c := NewCatalog()
+	if err := c.LoadFS(os.DirFS("testdata"), "catalog"); err != nil {
+		t.Fatal(err)
+	}
+	rec, ok := c.Schema("example.lexicon.record").Defs["main"].(*Record)
+	if !ok || rec.Key != "literal:demo" || rec.Record.Properties["integer"].Type() != "integer" {
+		t.Fatalf("unexpected record %+v", rec)
+	}
+	d, err := c.Resolve("com.atproto.label.defs#selfLabel")
+	if err != nil || d.Type() != "object" {
+		t.Fatal(d, err)
+	}
+	if _, err := c.Resolve("com.atproto.label.defs#nothing"); err == nil {
+		t.Fatal("expected error")
+	}
+
+	// procedure.json refers to a schema missing from the catalog
+	if err := c.Check(); err == nil || !strings.Contains(err.Error(), "app.bsky.actor.defs") {
+		t.Fatalf("unexpected error %v", err)
+	}
+	defs, err := Parse([]byte(`{"lexicon": 1, "id": "app.bsky.actor.defs", "defs": {
+		"preferences": {"type": "array", "items": {"type": "unknown"}}}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Add(defs); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Add(defs); err == nil {
+		t.Fatal("expected duplicate error")
+	}
+	if err := c.Check(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, doc := range []string{
+		`{"lexicon": 1, "id": "com.example.a", "defs": {"main": {"type": "query"},
+			"b": {"type": "object", "properties": {"c": {"type": "ref", "ref": "#main"}}}}}`,
+		`{"lexicon": 1, "id": "com.example.b", "defs": {"main": {"type": "object",
+			"properties": {"c": {"type": "union", "refs": ["#d"]}}}, "d": {"type": "string"}}}`,
+	} {
+		s, err := Parse([]byte(doc))
+		if err != nil {
+			t.Fatal(err)
+		}
+		single := NewCatalog()
+		if err := single.Add(s); err != nil {
+			t.Fatal(err)
+		}
+		if err := single.Check(); err == nil {
+			t.Errorf("expected error for %s", doc)
+		}
+	}
+}
+
+type recordFixture struct {
+	Name string          `json:"name"`
+	Data json.RawMessage `json:"data"`
+}
+
+// decodes JSON fixtures into values as decoded by the cbor package
+func decodeData(t *testing.T, raw json.RawMessage) any {
+	t.Helper()
+	d := json.NewDecoder(bytes.NewReader(raw))
+	d.UseNumber()
+	var v any
+	if err := d.Decode(&v); err != nil {
+		t.Fatal(err)
+	}
+	var convert func(v any) any
+	convert = func(v any) any {
+		switch v := v.(type) {
+		case json.Number:
+			if n, err := strconv.ParseUint(v.String(), 10, 64); err == nil {
+				return n
+			}
+			n, err := v.Int64()
+			if err != nil {
+				t.Fatal(err)
+			}
+			return n
+		case []any:
+			for i := range v {
+				v[i] = convert(v[i])
+			}
+		case map[string]any:
+			if link, ok := v["$link"].(string); ok && len(v) == 1 {
+				c, err := cid.Parse(link)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return c.Link()
+			}
+			if b, ok := v["$bytes"].(string); ok && len(v) == 1 {
+				data, err := base64.RawStdEncoding.DecodeString(b)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return data
+			}
+			for k := range v {
+				v[k] = convert(v[k])
+			}
+		}
+		return v
+	}
+	return convert(v)
+}
+
+func TestValidate(t *testing.T) {
+	c := NewCatalog()
+	if err := c.LoadFS(os.DirFS("testdata"), "catalog"); err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range loadFixtures[recordFixture](t, "record-data-valid.json") {
+		if err := Validate(c, "example.lexicon.record", decodeData(t, f.Data)); err != nil {
+			t.Errorf("%s: %v", f.Name, err)
+		}
+	}
+	for _, f := range loadFixtures[recordFixture](t, "record-data-invalid.json") {
+		if err := Validate(c, "example.lexicon.record", decodeData(t, f.Data)); !errors.Is(err, errdefs.ErrInvalid) {
+			t.Errorf("%s: expected invalid error, got %v", f.Name, err)
+		}
+	}
+
+	record := map[string]any{"$type": "example.lexicon.record", "integer": uint64(1), "extra": true,
+		"blob": map[string]any{"cid": "bafyreiclp443lavogvhj3d2ob2cxbfuscni2k5jk7bebjzg7khl3esabwq",
+			"mimeType": "text/plain"}}
+	if err := Validate(c, "example.lexicon.record", record); err != nil {
+		t.Fatal(err)
+	}
+	if err := ValidateWith(c, "example.lexicon.record", record, ValidateOptions{DisallowLegacyBlobs: true}); err == nil ||
+		!strings.HasPrefix(err.Error(), "blob: ") {
+		t.Fatalf("unexpected error %v", err)
+	}
+	delete(record, "blob")
+	if err := ValidateWith(c, "example.lexicon.record", record, ValidateOptions{DisallowUnknownFields: true}); err == nil ||
+		!strings.HasPrefix(err.Error(), "extra: ") {
+		t.Fatalf("unexpected error %v", err)
+	}
+	record["$type"] = "example.lexicon.other"
+	if err := Validate(c, "example.lexicon.record", record); err == nil {
+		t.Fatal("expected $type mismatch")
+	}
+
+	err := Validate(c, "example.lexicon.record#stringFormats", map[string]any{"aturi": "at://did:plc:abc/com.example.x/"})
+	if err == nil || !strings.HasPrefix(err.Error(), "aturi: invalid AT URI") {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if err := Validate(c, "example.lexicon.query", map[string]any{}); err == nil {
+		t.Fatal("expected error for query")
+	}
+
+	for s, n := range map[string]int{"": 0, "abc": 3, "é": 1, "\r\n": 1, "👍🏽": 1, "👩‍👩‍👦‍👦": 1, "🇩🇪🇫🇷🇮": 3} {
+		if got := graphemeCount(s); got != n {
+			t.Errorf("graphemeCount(%q) = %d, expected %d", s, got, n)
+		}
+	}
+}
+
+type testImages struct {
+	Type   string   `json:"$type,omitempty"`
+	Images []string `json:"images"`
+	// untyped, so that links and bytes are kept as is
+	Extra map[string]any `json:"extra,omitempty"`
+}
+
+func TestOpenUnion(t *testing.T) {
+	if DefaultRegistry.NewValue("com.example.test.images") == nil {
+		RegisterType("com.example.test.images", func() any { return new(testImages) })
+	}
+	defer func() {
+		if recover() == nil {
+			t.Error("expected panic on duplicate registration")
+		}
+	}()
+	c, _ := cid.Parse("bafyreiclp443lavogvhj3d2ob2cxbfuscni2k5jk7bebjzg7khl3esabwq")
+
+	known := OpenUnion{Type: "com.example.test.images", Value: &testImages{Images: []string{"a"}}}
+	unknown := OpenUnion{Type: "com.example.test.video", Data: map[string]any{"video": c.Link(), "alt": []byte("x")}}
+	b, err := json.Marshal([]OpenUnion{known, unknown})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `[{"$type":"com.example.test.images","images":["a"]},{"$type":"com.example.test.video",` +
+		`"alt":{"$bytes":"eA"},"video":{"$link":"bafyreiclp443lavogvhj3d2ob2cxbfuscni2k5jk7bebjzg7khl3esabwq"}}]`
+	if string(b) != expected {
+		t.Fatalf("unexpected JSON %s", b)
+	}
+	var decoded []OpenUnion
+	if err := json.Unmarshal(b, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := decoded[0].Value.(*testImages); !ok || v.Images[0] != "a" || decoded[0].Data != nil {
+		t.Fatalf("unexpected known member %+v", decoded[0])
+	}
+	if decoded[1].Value != nil || decoded[1].Type != "com.example.test.video" || !reflect.DeepEqual(decoded[1].Data["video"], c.Link()) {
+		t.Fatalf("unexpected unknown member %+v", decoded[1])
+	}
+
+	for _, u := range []OpenUnion{known, unknown} {
+		b, err := cbor.Encode(map[string]any{"embed": u})
+		if err != nil {
+			t.Fatal(err)
+		}
+		v, err := cbor.Decode(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		embed, err := cbor.Encode(v.(map[string]any)["embed"])
+		if err != nil {
+			t.Fatal(err)
+		}
+		var again OpenUnion
+		if err := again.UnmarshalCBOR(embed); err != nil {
+			t.Fatal(err)
+		}
+		b2, err := cbor.Encode(map[string]any{"embed": again})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b, b2) || again.Type != u.Type {
+			t.Fatalf("CBOR round trip of %s changed it", u.Type)
+		}
+	}
+
+	// registered members decoded from CBOR keep the links and bytes of their untyped fields
+	extra := map[string]any{"ref": c.Link(), "alt": []byte("x"), "n": uint64(1)}
+	b, err = cbor.Encode(map[string]any{"$type": "com.example.test.images", "images": []any{"a"}, "extra": extra})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var member OpenUnion
+	if err := member.UnmarshalCBOR(b); err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := member.Value.(*testImages); !ok || !reflect.DeepEqual(v.Extra, extra) {
+		t.Fatalf("unexpected member decoded from CBOR %#v", member.Value)
+	}
+
+	if _, err := json.Marshal(OpenUnion{}); err == nil {
+		t.Fatal("expected error for empty union")
+	}
+	if err := json.Unmarshal([]byte(`{"a": 1}`), new(OpenUnion)); err == nil {
+		t.Fatal("expected error without $type")
+	}
+	RegisterType("com.example.test.images", func() any { return new(testImages) })
+}
+
+type testNote struct {
+	Type   string        `json:"$type,omitempty"`
+	Text   string        `json:"text"`
+	Digest []byte        `json:"digest,omitempty"`
+	Likes  *int64        `json:"likes,omitempty"`
+	Tags   []string      `json:"tags,omitempty"`
+	Refs   []cid.CidLink `json:"refs,omitempty"`
+	Extra  any           `json:"extra,omitempty"`
+}
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry()
+	s, err := Parse([]byte(`{"lexicon": 1, "id": "com.example.test.note", "defs": {"main": {"type": "record",
+		"key": "tid", "record": {"type": "object", "required": ["text"],
+		"properties": {"text": {"type": "string", "maxLength": 10}}}}}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.RegisterSchema(s); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.RegisterSchema(s); err == nil {
+		t.Fatal("expected error for duplicate schema")
+	}
+	r.RegisterType("com.example.test.note", func() any { return new(testNote) })
+
+	note := map[string]any{"$type": "com.example.test.note", "text": "hello"}
+	v, err := r.Decode(note)
+	if n, ok := v.(*testNote); err != nil || !ok || n.Text != "hello" {
+		t.Fatalf("unexpected value %+v: %v", v, err)
+	}
+	c, _ := cid.Parse("bafyreiclp443lavogvhj3d2ob2cxbfuscni2k5jk7bebjzg7khl3esabwq")
+	full := map[string]any{"$type": "com.example.test.note", "text": "hello", "digest": []byte{1, 2},
+		"likes": uint64(3), "tags": []any{"a", "b"}, "refs": []any{c.Link()}, "extra": []any{c.Link(), []byte("x")}}
+	v, err = r.Decode(full)
+	n, _ := v.(*testNote)
+	if err != nil || n == nil || !bytes.Equal(n.Digest, []byte{1, 2}) || n.Likes == nil || *n.Likes != 3 ||
+		!reflect.DeepEqual(n.Tags, []string{"a", "b"}) || !reflect.DeepEqual(n.Refs, []cid.CidLink{c.Link()}) ||
+		!reflect.DeepEqual(n.Extra, full["extra"]) {
+		t.Fatalf("unexpected value %+v: %v", v, err)
+	}
+	for _, bad := range []map[string]any{{"text": int64(1)}, {"likes": "3"}, {"tags": []any{int64(1)}}} {
+		bad["$type"] = "com.example.test.note"
+		if _, err := r.Decode(bad); err == nil {
+			t.Errorf("expected error decoding %v", bad)
+		}
+	}
+	if err := r.Validate(note); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Validate(map[string]any{"$type": "com.example.test.note", "text": "hello world"}); err == nil {
+		t.Fatal("expected validation error")
+	}
+
+	other := map[string]any{"$type": "com.example.test.other"}
+	if v, err := r.Decode(other); v != nil || err != nil {
+		t.Fatalf("expected no value for unregistered type, got %v: %v", v, err)
+	}
+	if err := r.Validate(other); err == nil {
+		t.Fatal("expected error for unregistered schema")
+	}
+	if _, err := r.Decode(map[string]any{"text": "hello"}); err == nil {
+		t.Fatal("expected error without $type")
+	}
+	if DefaultRegistry.NewValue("com.example.test.note") != nil {
+		t.Fatal("expected type to be registered in its registry only")
+	}
+}
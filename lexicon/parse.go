@@ -0,0 +1,477 @@
+package lexicon
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/notjuliet/grove/syntax"
+)
+
+// String formats defined by the lexicon language.
+var Formats = []string{
+	"at-identifier", "at-uri", "cid", "datetime", "did", "handle", "language", "nsid", "record-key", "tid", "uri",
+}
+
+// where a definition appears, which restricts its type
+type position int
+
+const (
+	// main definition of a schema
+	posMain position = iota
+	// other named definition
+	posNamed
+	// property of an object, or items of an array
+	posField
+	// parameters of a method
+	posParams
+	// property of params, or items of an array in params
+	posParam
+	// schema of a body or message
+	posBody
+)
+
+// Parses a lexicon document, checking its structure: the placement and attributes of definitions, and the
+// syntax of references. References to other schemas are resolved by a Catalog.
+func Parse(b []byte) (*Schema, error) {
+	var doc struct {
+		Lexicon     int                        `json:"lexicon"`
+		ID          string                     `json:"id"`
+		Revision    int                        `json:"revision"`
+		Description string                     `json:"description"`
+		Defs        map[string]json.RawMessage `json:"defs"`
+	}
+	if err := json.Unmarshal(b, &doc); err != nil {
+		return nil, fmt.Errorf("parsing lexicon: %w", err)
+	}
+	if doc.Lexicon != Version {
+		return nil, fmt.Errorf("unsupported lexicon version %d", doc.Lexicon)
+	}
+	if err := syntax.ValidateNSID(doc.ID); err != nil {
+		return nil, err
+	}
+	s := &Schema{Lexicon: doc.Lexicon, ID: doc.ID, Revision: doc.Revision, Description: doc.Description,
+		Defs: make(map[string]Def, len(doc.Defs))}
+	p := &parser{id: doc.ID}
+	for name, raw := range doc.Defs {
+		pos := posNamed
+		if name == "main" {
+			pos = posMain
+		}
+		d, err := p.parse(raw, pos)
+		if err != nil {
+			return nil, fmt.Errorf("%s#%s: %w", doc.ID, name, err)
+		}
+		s.Defs[name] = d
+	}
+	return s, nil
+}
+
+type parser struct {
+	// id of the schema, qualifying local references
+	id string
+}
+
+func (p *parser) parse(raw json.RawMessage, pos position) (Def, error) {
+	var head struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(raw, &head); err != nil {
+		return nil, err
+	}
+	if err := checkPosition(head.Type, pos); err != nil {
+		return nil, err
+	}
+
+	switch head.Type {
+	case "record":
+		var w struct {
+			Description string          `json:"description"`
+			Key         string          `json:"key"`
+			Record      json.RawMessage `json:"record"`
+		}
+		if err := json.Unmarshal(raw, &w); err != nil {
+			return nil, err
+		}
+		if err := checkRecordKey(w.Key); err != nil {
+			return nil, err
+		}
+		obj, err := p.object(w.Record)
+		if err != nil {
+			return nil, fmt.Errorf("record: %w", err)
+		}
+		return &Record{Description: w.Description, Key: w.Key, Record: obj}, nil
+
+	case "query", "procedure", "subscription":
+		var w struct {
+			Description string          `json:"description"`
+			Parameters  json.RawMessage `json:"parameters"`
+			Input       json.RawMessage `json:"input"`
+			Output      json.RawMessage `json:"output"`
+			Message     json.RawMessage `json:"message"`
+			Errors      []Error         `json:"errors"`
+		}
+		if err := json.Unmarshal(raw, &w); err != nil {
+			return nil, err
+		}
+		var params *Params
+		if w.Parameters != nil {
+			d, err := p.parse(w.Parameters, posParams)
+			if err != nil {
+				return nil, fmt.Errorf("parameters: %w", err)
+			}
+			if params, _ = d.(*Params); params == nil {
+				return nil, fmt.Errorf("parameters must be params, not %s", d.Type())
+			}
+		}
+		for _, e := range w.Errors {
+			if e.Name == "" {
+				return nil, errors.New("error without a name")
+			}
+		}
+		input, err := p.body(w.Input)
+		if err != nil {
+			return nil, fmt.Errorf("input: %w", err)
+		}
+		output, err := p.body(w.Output)
+		if err != nil {
+			return nil, fmt.Errorf("output: %w", err)
+		}
+		switch head.Type {
+		case "query":
+			if input != nil {
+				return nil, errors.New("query with an input")
+			}
+			return &Query{Description: w.Description, Parameters: params, Output: output, Errors: w.Errors}, nil
+		case "procedure":
+			return &Procedure{Description: w.Description, Parameters: params, Input: input, Output: output,
+				Errors: w.Errors}, nil
+		}
+		if input != nil || output != nil {
+			return nil, errors.New("subscription with an input or output")
+		}
+		msg, err := p.body(w.Message)
+		if err != nil {
+			return nil, fmt.Errorf("message: %w", err)
+		}
+		sub := &Subscription{Description: w.Description, Parameters: params, Errors: w.Errors}
+		if msg != nil {
+			sub.Message = &Message{Description: msg.Description, Schema: msg.Schema}
+		}
+		return sub, nil
+
+	case "permission-set":
+		var w struct {
+			Description string            `json:"description"`
+			Title       string            `json:"title"`
+			TitleLang   map[string]string `json:"title:lang"`
+			Detail      string            `json:"detail"`
+			DetailLang  map[string]string `json:"detail:lang"`
+			Permissions []struct {
+				Type       string   `json:"type"`
+				Resource   string   `json:"resource"`
+				Collection []string `json:"collection"`
+				Action     []string `json:"action"`
+				LXM        []string `json:"lxm"`
+				Aud        string   `json:"aud"`
+				InheritAud bool     `json:"inheritAud"`
+			} `json:"permissions"`
+		}
+		if err := json.Unmarshal(raw, &w); err != nil {
+			return nil, err
+		}
+		set := &PermissionSet{Description: w.Description, Title: w.Title, TitleLang: w.TitleLang,
+			Detail: w.Detail, DetailLang: w.DetailLang}
+		for _, perm := range w.Permissions {
+			if perm.Type != "permission" || perm.Resource == "" {
+				return nil, errors.New("invalid permission")
+			}
+			set.Permissions = append(set.Permissions, Permission{Resource: perm.Resource,
+				Collection: perm.Collection, Action: perm.Action, LXM: perm.LXM, Aud: perm.Aud,
+				InheritAud: perm.InheritAud})
+		}
+		return set, nil
+
+	case "object":
+		return p.object(raw)
+
+	case "params":
+		var w struct {
+			Description string                     `json:"description"`
+			Required    []string                   `json:"required"`
+			Properties  map[string]json.RawMessage `json:"properties"`
+		}
+		if err := json.Unmarshal(raw, &w); err != nil {
+			return nil, err
+		}
+		props, err := p.properties(w.Properties, posParam)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkListed("required", w.Required, props); err != nil {
+			return nil, err
+		}
+		return &Params{Description: w.Description, Required: w.Required, Properties: props}, nil
+
+	case "array":
+		var w struct {
+			Description string          `json:"description"`
+			Items       json.RawMessage `json:"items"`
+			MinLength   *int            `json:"minLength"`
+			MaxLength   *int            `json:"maxLength"`
+		}
+		if err := json.Unmarshal(raw, &w); err != nil {
+			return nil, err
+		}
+		if w.Items == nil {
+			return nil, errors.New("array without items")
+		}
+		itemPos := posField
+		if pos == posParam {
+			itemPos = posParam
+		}
+		items, err := p.parse(w.Items, itemPos)
+		if err != nil {
+			return nil, fmt.Errorf("items: %w", err)
+		}
+		if _, ok := items.(*Array); ok && pos == posParam {
+			return nil, errors.New("nested array in params")
+		}
+		if err := checkBounds(w.MinLength, w.MaxLength); err != nil {
+			return nil, err
+		}
+		return &Array{Description: w.Description, Items: items, MinLength: w.MinLength, MaxLength: w.MaxLength}, nil
+
+	case "token":
+		var t Token
+		return &t, json.Unmarshal(raw, &t)
+
+	case "string":
+		var s String
+		if err := json.Unmarshal(raw, &s); err != nil {
+			return nil, err
+		}
+		if s.Format != "" && !slices.Contains(Formats, s.Format) {
+			return nil, fmt.Errorf("unknown string format %q", s.Format)
+		}
+		if err := checkBounds(s.MinLength, s.MaxLength); err != nil {
+			return nil, err
+		}
+		if err := checkBounds(s.MinGraphemes, s.MaxGraphemes); err != nil {
+			return nil, err
+		}
+		return &s, nil
+
+	case "integer":
+		var i Integer
+		if err := json.Unmarshal(raw, &i); err != nil {
+			return nil, err
+		}
+		if i.Minimum != nil && i.Maximum != nil && *i.Minimum > *i.Maximum {
+			return nil, errors.New("minimum greater than maximum")
+		}
+		return &i, nil
+
+	case "boolean":
+		var b Boolean
+		return &b, json.Unmarshal(raw, &b)
+
+	case "bytes":
+		var b Bytes
+		if err := json.Unmarshal(raw, &b); err != nil {
+			return nil, err
+		}
+		return &b, checkBounds(b.MinLength, b.MaxLength)
+
+	case "cid-link":
+		var c CidLink
+		return &c, json.Unmarshal(raw, &c)
+
+	case "blob":
+		var b Blob
+		if err := json.Unmarshal(raw, &b); err != nil {
+			return nil, err
+		}
+		for _, accept := range b.Accept {
+			if accept != "*/*" && !strings.Contains(accept, "/") {
+				return nil, fmt.Errorf("invalid accepted MIME type %q", accept)
+			}
+		}
+		return &b, nil
+
+	case "unknown":
+		var u Unknown
+		return &u, json.Unmarshal(raw, &u)
+
+	case "ref":
+		var r Ref
+		if err := json.Unmarshal(raw, &r); err != nil {
+			return nil, err
+		}
+		target, err := p.qualify(r.Ref)
+		if err != nil {
+			return nil, err
+		}
+		r.Target = target
+		return &r, nil
+
+	case "union":
+		var u Union
+		if err := json.Unmarshal(raw, &u); err != nil {
+			return nil, err
+		}
+		if u.Refs == nil {
+			return nil, errors.New("union without refs")
+		}
+		for _, ref := range u.Refs {
+			target, err := p.qualify(ref)
+			if err != nil {
+				return nil, err
+			}
+			u.Targets = append(u.Targets, target)
+		}
+		return &u, nil
+	}
+	if head.Type == "" {
+		return nil, errors.New("definition without a type")
+	}
+	return nil, fmt.Errorf("unknown type %q", head.Type)
+}
+
+func (p *parser) object(raw json.RawMessage) (*Object, error) {
+	var w struct {
+		Type        string                     `json:"type"`
+		Description string                     `json:"description"`
+		Required    []string                   `json:"required"`
+		Nullable    []string                   `json:"nullable"`
+		Properties  map[string]json.RawMessage `json:"properties"`
+	}
+	if err := json.Unmarshal(raw, &w); err != nil {
+		return nil, err
+	}
+	if w.Type != "object" {
+		return nil, fmt.Errorf("expected an object, not %q", w.Type)
+	}
+	props, err := p.properties(w.Properties, posField)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkListed("required", w.Required, props); err != nil {
+		return nil, err
+	}
+	if err := checkListed("nullable", w.Nullable, props); err != nil {
+		return nil, err
+	}
+	return &Object{Description: w.Description, Required: w.Required, Nullable: w.Nullable, Properties: props}, nil
+}
+
+func (p *parser) properties(raw map[string]json.RawMessage, pos position) (map[string]Def, error) {
+	props := make(map[string]Def, len(raw))
+	for name, r := range raw {
+		d, err := p.parse(r, pos)
+		if err != nil {
+			return nil, fmt.Errorf("property %s: %w", name, err)
+		}
+		props[name] = d
+	}
+	return props, nil
+}
+
+func (p *parser) body(raw json.RawMessage) (*Body, error) {
+	if raw == nil {
+		return nil, nil
+	}
+	var w struct {
+		Description string          `json:"description"`
+		Encoding    string          `json:"encoding"`
+		Schema      json.RawMessage `json:"schema"`
+	}
+	if err := json.Unmarshal(raw, &w); err != nil {
+		return nil, err
+	}
+	b := &Body{Description: w.Description, Encoding: w.Encoding}
+	if w.Schema != nil {
+		d, err := p.parse(w.Schema, posBody)
+		if err != nil {
+			return nil, err
+		}
+		b.Schema = d
+	}
+	return b, nil
+}
+
+// qualifies a reference made from the schema, checking its syntax
+func (p *parser) qualify(ref string) (string, error) {
+	target := QualifyRef(p.id, ref)
+	nsid, name, _ := strings.Cut(target, "#")
+	if err := syntax.ValidateNSID(nsid); err != nil || name == "" {
+		return "", fmt.Errorf("invalid reference %q", ref)
+	}
+	return target, nil
+}
+
+// checks that a type may appear at a position
+func checkPosition(typ string, pos position) error {
+	var ok bool
+	switch typ {
+	case "record", "query", "procedure", "subscription", "permission-set":
+		if pos != posMain {
+			return fmt.Errorf("type %s is only allowed in the main definition", typ)
+		}
+		return nil
+	}
+	if pos == posMain {
+		pos = posNamed
+	}
+	switch typ {
+	case "token":
+		ok = pos == posNamed
+	case "object":
+		ok = pos == posNamed || pos == posField || pos == posBody
+	case "params":
+		ok = pos == posParams
+	case "boolean", "integer", "string", "array":
+		ok = pos == posNamed || pos == posField || pos == posParam
+	case "unknown":
+		ok = pos == posField || pos == posParam
+	case "bytes", "cid-link", "blob":
+		ok = pos == posNamed || pos == posField
+	case "ref", "union":
+		ok = pos == posField || pos == posBody
+	default:
+		return nil
+	}
+	if !ok {
+		return fmt.Errorf("type %s is not allowed here", typ)
+	}
+	return nil
+}
+
+func checkRecordKey(key string) error {
+	switch key {
+	case "tid", "nsid", "any":
+		return nil
+	}
+	if lit, ok := strings.CutPrefix(key, "literal:"); ok {
+		return syntax.ValidateRecordKey(lit)
+	}
+	return fmt.Errorf("invalid record key type %q", key)
+}
+
+func checkListed(kind string, names []string, props map[string]Def) error {
+	for _, name := range names {
+		if _, ok := props[name]; !ok {
+			return fmt.Errorf("%s property %s is not defined", kind, name)
+		}
+	}
+	return nil
+}
+
+func checkBounds(min, max *int) error {
+	if min != nil && max != nil && *min > *max {
+		return errors.New("minimum length greater than maximum")
+	}
+	return nil
+}
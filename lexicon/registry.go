@@ -0,0 +1,122 @@
+package lexicon
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/notjuliet/grove/data"
+)
+
+// Maps NSIDs to the Go types and schemas of the records and objects they name, such as "app.bsky.feed.post" or
+// "app.bsky.feed.defs#postView", so that applications register them once for every subsystem: open unions and
+// events.RecordOps decode values into their registered type, and Validate checks them against their schema. A
+// registry is safe for concurrent use.
+type Registry struct {
+	mtx     sync.RWMutex
+	types   map[string]func() any
+	catalog *Catalog
+}
+
+func NewRegistry() *Registry {
+	return &Registry{types: make(map[string]func() any), catalog: NewCatalog()}
+}
+
+// Registry consulted by open unions and events.RecordOps, and filled by RegisterType and RegisterSchema.
+var DefaultRegistry = NewRegistry()
+
+// Registers the Go type of the values with a $type. newValue returns a pointer to a new value, which is
+// decoded from the data model with its FromData method, or like FromData. Panics if the type is already
+// registered.
+func (r *Registry) RegisterType(typ string, newValue func() any) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	if _, ok := r.types[typ]; ok {
+		panic("lexicon: type " + typ + " is already registered")
+	}
+	r.types[typ] = newValue
+}
+
+// Adds a schema to the catalog of the registry. Fails if a schema with the same id was already added.
+func (r *Registry) RegisterSchema(s *Schema) error {
+	return r.catalog.Add(s)
+}
+
+// Returns the catalog of the schemas registered, to load schemas into or to validate against.
+func (r *Registry) Catalog() *Catalog {
+	return r.catalog
+}
+
+// Returns a pointer to a new value of the Go type registered for typ, nil if none is.
+func (r *Registry) NewValue(typ string) any {
+	r.mtx.RLock()
+	newValue := r.types[typ]
+	r.mtx.RUnlock()
+	if newValue == nil {
+		return nil
+	}
+	return newValue()
+}
+
+// Decodes a value of the data model, such as a record decoded by the cbor package, into the Go type registered
+// for its $type, with its FromData method if it has one. Returns nil without error if no type is registered
+// for it.
+func (r *Registry) Decode(m map[string]any) (any, error) {
+	typ, _ := m["$type"].(string)
+	if typ == "" {
+		return nil, errors.New("value without a $type")
+	}
+	v := r.NewValue(typ)
+	if v == nil {
+		return nil, nil
+	}
+	var err error
+	if d, ok := v.(dataDecoder); ok {
+		err = d.FromData(m)
+	} else {
+		err = FromData(m, v)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("decoding %s: %w", typ, err)
+	}
+	return v, nil
+}
+
+// Converts a Go value encoding to a lexicon JSON object, such as a generated record type, to the data model,
+// ready for repo.EncodeRecord.
+func ToData(v any) (map[string]any, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	d, err := data.UnmarshalJSON(b)
+	if err != nil {
+		return nil, err
+	}
+	m, ok := d.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("%T is not an object", v)
+	}
+	return m, nil
+}
+
+// Checks a value of the data model against the schema registered for its $type, like Validate. Fails if no
+// schema is registered for it.
+func (r *Registry) Validate(m map[string]any) error {
+	typ, _ := m["$type"].(string)
+	if typ == "" {
+		return errors.New("value without a $type")
+	}
+	return Validate(r.catalog, typ, m)
+}
+
+// Registers the Go type of the values with a $type in DefaultRegistry, see Registry.RegisterType.
+func RegisterType(typ string, newValue func() any) {
+	DefaultRegistry.RegisterType(typ, newValue)
+}
+
+// Adds a schema to DefaultRegistry, see Registry.RegisterSchema.
+func RegisterSchema(s *Schema) error {
+	return DefaultRegistry.RegisterSchema(s)
+}
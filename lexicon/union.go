@@ -0,0 +1,104 @@
+package lexicon
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+
+	"github.com/notjuliet/grove/cbor"
+	"github.com/notjuliet/grove/data"
+)
+
+// Member of an open union, which may be of types defined after the schema of the union. Members of a type
+// registered in DefaultRegistry are decoded into it, while others are kept as data, so that they survive being
+// decoded and encoded again. An open union encodes to and decodes from both lexicon JSON and CBOR.
+type OpenUnion struct {
+	// $type of the member. When encoding, it may be left empty if Value encodes its own $type.
+	Type string
+	// Member decoded into its registered Go type, such as *EmbedImages, nil if its type is not registered.
+	Value any
+	// Member in the data model, as decoded by the cbor package, if its type is not registered.
+	Data map[string]any
+}
+
+// Returns the member in the data model, with its $type.
+func (u OpenUnion) ToData() (map[string]any, error) {
+	var m map[string]any
+	switch {
+	case u.Value != nil:
+		var err error
+		if m, err = ToData(u.Value); err != nil {
+			return nil, fmt.Errorf("union member: %w", err)
+		}
+	case u.Data != nil:
+		m = maps.Clone(u.Data)
+	default:
+		return nil, errors.New("empty union")
+	}
+	if u.Type != "" {
+		m["$type"] = u.Type
+	}
+	if typ, _ := m["$type"].(string); typ == "" {
+		return nil, errors.New("union member without a $type")
+	}
+	return m, nil
+}
+
+// Sets the member from the data model, decoding it into its Go type if registered.
+func (u *OpenUnion) FromData(m map[string]any) error {
+	typ, _ := m["$type"].(string)
+	if typ == "" {
+		return errors.New("union member without a $type")
+	}
+	*u = OpenUnion{Type: typ}
+	v, err := DefaultRegistry.Decode(m)
+	if err != nil {
+		return err
+	}
+	if v == nil {
+		u.Data = m
+		return nil
+	}
+	u.Value = v
+	return nil
+}
+
+func (u OpenUnion) MarshalJSON() ([]byte, error) {
+	m, err := u.ToData()
+	if err != nil {
+		return nil, err
+	}
+	return data.MarshalJSON(m)
+}
+
+func (u *OpenUnion) UnmarshalJSON(b []byte) error {
+	v, err := data.UnmarshalJSON(b)
+	if err != nil {
+		return err
+	}
+	m, ok := v.(map[string]any)
+	if !ok {
+		return errors.New("union member is not an object")
+	}
+	return u.FromData(m)
+}
+
+func (u OpenUnion) MarshalCBOR() ([]byte, error) {
+	m, err := u.ToData()
+	if err != nil {
+		return nil, err
+	}
+	return cbor.Encode(m)
+}
+
+func (u *OpenUnion) UnmarshalCBOR(b []byte) error {
+	v, err := cbor.Decode(b)
+	if err != nil {
+		return err
+	}
+	m, ok := v.(map[string]any)
+	if !ok {
+		return errors.New("union member is not an object")
+	}
+	return u.FromData(m)
+}
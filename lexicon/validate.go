@@ -0,0 +1,406 @@
+package lexicon
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"unicode"
+
+	"github.com/notjuliet/grove/cid"
+	"github.com/notjuliet/grove/errdefs"
+	"github.com/notjuliet/grove/syntax"
+)
+
+// Options of ValidateWith.
+type ValidateOptions struct {
+	// Rejects the properties of objects which their schema does not define. By default they are allowed, as
+	// schemas may gain optional properties over time.
+	DisallowUnknownFields bool
+	// Rejects blobs in the legacy format, {"cid": ..., "mimeType": ...}, written by early clients.
+	DisallowLegacyBlobs bool
+}
+
+// Checks a record, as decoded by the cbor package, against the schema of its collection in the catalog: the
+// types of its properties, required and nullable properties, string formats, constraints on lengths, sizes and
+// values, and the types of union members. nsid may also name an object definition, such as
+// "app.bsky.feed.defs#postView". Properties the schema does not define are allowed.
+func Validate(c *Catalog, nsid string, value any) error {
+	return ValidateWith(c, nsid, value, ValidateOptions{})
+}
+
+// Checks a record like Validate, with options. Errors match errdefs.ErrInvalid.
+func ValidateWith(c *Catalog, nsid string, value any, opts ValidateOptions) error {
+	return errdefs.Wrap(errdefs.ErrInvalid, validate(c, nsid, value, opts))
+}
+
+func validate(c *Catalog, nsid string, value any, opts ValidateOptions) error {
+	d, err := c.Resolve(nsid)
+	if err != nil {
+		return err
+	}
+	v := &validator{catalog: c, opts: opts}
+	switch d := d.(type) {
+	case *Record:
+		m, ok := value.(map[string]any)
+		if !ok {
+			return fmt.Errorf("expected a record object, got %s", kindOf(value))
+		}
+		if typ, _ := m["$type"].(string); typ != TypeName(QualifyRef(nsid, nsid)) {
+			return fmt.Errorf("record $type %q does not match %s", typ, nsid)
+		}
+		return v.object(d.Record, m, "")
+	case *Object:
+		return v.value(d, value, "")
+	}
+	return fmt.Errorf("cannot validate data against %s of type %s", nsid, d.Type())
+}
+
+type validator struct {
+	catalog *Catalog
+	opts    ValidateOptions
+}
+
+// fails with the path of a value
+func fail(path string, format string, args ...any) error {
+	msg := fmt.Sprintf(format, args...)
+	if path == "" {
+		return errors.New(msg)
+	}
+	return fmt.Errorf("%s: %s", path, msg)
+}
+
+func (v *validator) value(d Def, value any, path string) error {
+	switch d := d.(type) {
+	case *Object:
+		m, ok := value.(map[string]any)
+		if !ok || isBlob(m) {
+			return fail(path, "expected an object, got %s", kindOf(value))
+		}
+		return v.object(d, m, path)
+	case *Array:
+		return v.array(d, value, path)
+	case *String:
+		return v.string(d, value, path)
+	case *Integer:
+		return v.integer(d, value, path)
+	case *Boolean:
+		b, ok := value.(bool)
+		if !ok {
+			return fail(path, "expected a boolean, got %s", kindOf(value))
+		}
+		if d.Const != nil && b != *d.Const {
+			return fail(path, "expected %t", *d.Const)
+		}
+	case *Bytes:
+		b, ok := value.([]byte)
+		if !ok {
+			return fail(path, "expected bytes, got %s", kindOf(value))
+		}
+		if err := checkLength(len(b), d.MinLength, d.MaxLength); err != nil {
+			return fail(path, "bytes %v", err)
+		}
+	case *CidLink:
+		if _, ok := value.(cid.CidLink); !ok {
+			return fail(path, "expected a CID link, got %s", kindOf(value))
+		}
+	case *Blob:
+		return v.blob(d, value, path)
+	case *Unknown:
+		m, ok := value.(map[string]any)
+		if !ok || isBlob(m) {
+			return fail(path, "expected an object, got %s", kindOf(value))
+		}
+	case *Ref:
+		target, err := v.catalog.Resolve(d.Target)
+		if err != nil {
+			return fail(path, "%v", err)
+		}
+		switch target := target.(type) {
+		case *Record:
+			return v.value(target.Record, value, path)
+		case *Token:
+			if s, ok := value.(string); !ok || s != TypeName(d.Target) {
+				return fail(path, "expected token %s", TypeName(d.Target))
+			}
+			return nil
+		}
+		return v.value(target, value, path)
+	case *Union:
+		return v.union(d, value, path)
+	default:
+		return fail(path, "unexpected definition of type %s", d.Type())
+	}
+	return nil
+}
+
+func (v *validator) object(d *Object, m map[string]any, path string) error {
+	for _, name := range d.Required {
+		if _, ok := m[name]; !ok {
+			return fail(join(path, name), "required property is missing")
+		}
+	}
+	for _, name := range slices.Sorted(maps.Keys(m)) {
+		value := m[name]
+		prop, ok := d.Properties[name]
+		if !ok {
+			if v.opts.DisallowUnknownFields && name != "$type" {
+				return fail(join(path, name), "unknown property")
+			}
+			continue
+		}
+		if value == nil {
+			if !slices.Contains(d.Nullable, name) {
+				return fail(join(path, name), "property is not nullable")
+			}
+			continue
+		}
+		if err := v.value(prop, value, join(path, name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (v *validator) array(d *Array, value any, path string) error {
+	items, ok := value.([]any)
+	if !ok {
+		return fail(path, "expected an array, got %s", kindOf(value))
+	}
+	if err := checkLength(len(items), d.MinLength, d.MaxLength); err != nil {
+		return fail(path, "array %v", err)
+	}
+	for i, item := range items {
+		if err := v.value(d.Items, item, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (v *validator) string(d *String, value any, path string) error {
+	s, ok := value.(string)
+	if !ok {
+		return fail(path, "expected a string, got %s", kindOf(value))
+	}
+	if d.Const != nil && s != *d.Const {
+		return fail(path, "expected %q", *d.Const)
+	}
+	if d.Enum != nil && !slices.Contains(d.Enum, s) {
+		return fail(path, "%q is not one of %q", s, d.Enum)
+	}
+	if err := checkLength(len(s), d.MinLength, d.MaxLength); err != nil {
+		return fail(path, "string %v", err)
+	}
+	if d.MinGraphemes != nil || d.MaxGraphemes != nil {
+		if err := checkLength(graphemeCount(s), d.MinGraphemes, d.MaxGraphemes); err != nil {
+			return fail(path, "string %v graphemes", err)
+		}
+	}
+	if d.Format != "" {
+		if err := syntax.ValidateFormat(d.Format, s); err != nil {
+			return fail(path, "%v", err)
+		}
+	}
+	return nil
+}
+
+func (v *validator) integer(d *Integer, value any, path string) error {
+	var i int64
+	switch n := value.(type) {
+	case int64:
+		i = n
+	case int:
+		i = int64(n)
+	case uint64:
+		if n > 1<<63-1 {
+			return fail(path, "integer %d out of range", n)
+		}
+		i = int64(n)
+	default:
+		return fail(path, "expected an integer, got %s", kindOf(value))
+	}
+	if d.Const != nil && i != *d.Const {
+		return fail(path, "expected %d", *d.Const)
+	}
+	if d.Enum != nil && !slices.Contains(d.Enum, i) {
+		return fail(path, "%d is not one of %v", i, d.Enum)
+	}
+	if d.Minimum != nil && i < *d.Minimum {
+		return fail(path, "%d is less than %d", i, *d.Minimum)
+	}
+	if d.Maximum != nil && i > *d.Maximum {
+		return fail(path, "%d is greater than %d", i, *d.Maximum)
+	}
+	return nil
+}
+
+func (v *validator) blob(d *Blob, value any, path string) error {
+	m, ok := value.(map[string]any)
+	if !ok {
+		return fail(path, "expected a blob, got %s", kindOf(value))
+	}
+	mimeType, _ := m["mimeType"].(string)
+	if mimeType == "" {
+		return fail(path, "blob without a MIME type")
+	}
+	var size int64 = -1
+	if isBlob(m) {
+		if _, ok := m["ref"].(cid.CidLink); !ok {
+			return fail(path, "blob without a ref")
+		}
+		switch n := m["size"].(type) {
+		case uint64:
+			size = int64(min(n, 1<<63-1))
+		case int64:
+			size = n
+		case int:
+			size = int64(n)
+		}
+		if size < 0 {
+			return fail(path, "blob without a valid size")
+		}
+	} else if c, ok := m["cid"].(string); ok && !v.opts.DisallowLegacyBlobs {
+		// legacy blobs have no size
+		if _, err := cid.Parse(c); err != nil {
+			return fail(path, "legacy blob with an invalid CID: %v", err)
+		}
+	} else {
+		return fail(path, "expected a blob, got %s", kindOf(value))
+	}
+	if d.MaxSize != nil && size > *d.MaxSize {
+		return fail(path, "blob of %d bytes is larger than %d", size, *d.MaxSize)
+	}
+	if d.Accept != nil && !slices.ContainsFunc(d.Accept, func(accept string) bool {
+		return matchMIME(accept, mimeType)
+	}) {
+		return fail(path, "blob of type %s is not one of %q", mimeType, d.Accept)
+	}
+	return nil
+}
+
+func (v *validator) union(d *Union, value any, path string) error {
+	m, ok := value.(map[string]any)
+	if !ok || isBlob(m) {
+		return fail(path, "expected an object, got %s", kindOf(value))
+	}
+	typ, _ := m["$type"].(string)
+	if typ == "" {
+		return fail(path, "union member without a $type")
+	}
+	target := QualifyRef(typ, typ)
+	if !slices.Contains(d.Targets, target) {
+		if d.Closed {
+			return fail(path, "$type %s is not one of %q", typ, d.Refs)
+		}
+		// open unions allow types defined later
+		return nil
+	}
+	def, err := v.catalog.Resolve(target)
+	if err != nil {
+		return fail(path, "%v", err)
+	}
+	switch def := def.(type) {
+	case *Record:
+		return v.object(def.Record, m, path)
+	case *Object:
+		return v.object(def, m, path)
+	}
+	return fail(path, "union member %s of type %s", typ, def.Type())
+}
+
+// reports whether a map is a blob in the current format
+func isBlob(m map[string]any) bool {
+	typ, _ := m["$type"].(string)
+	return typ == "blob"
+}
+
+// reports whether a MIME type matches an accepted type, possibly with a wildcard such as "image/*"
+func matchMIME(accept, mimeType string) bool {
+	if accept == "*/*" {
+		return true
+	}
+	if prefix, ok := strings.CutSuffix(accept, "*"); ok {
+		return strings.HasPrefix(mimeType, prefix)
+	}
+	return accept == mimeType
+}
+
+func checkLength(n int, min, max *int) error {
+	if min != nil && n < *min {
+		return fmt.Errorf("shorter than %d", *min)
+	}
+	if max != nil && n > *max {
+		return fmt.Errorf("longer than %d", *max)
+	}
+	return nil
+}
+
+func join(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+// describes the type of a decoded value in errors
+func kindOf(value any) string {
+	switch value := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "a boolean"
+	case int, int64, uint64:
+		return "an integer"
+	case float64:
+		return "a float"
+	case string:
+		return "a string"
+	case []byte:
+		return "bytes"
+	case cid.CidLink:
+		return "a CID link"
+	case []any:
+		return "an array"
+	case map[string]any:
+		if isBlob(value) {
+			return "a blob"
+		}
+		return "an object"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+// Counts the extended grapheme clusters of a string, approximating Unicode text segmentation: combining marks,
+// emoji modifiers and characters joined by a zero width joiner extend the previous cluster, and regional
+// indicators pair into flags.
+func graphemeCount(s string) int {
+	var n int
+	var prev rune
+	// whether prev is a regional indicator starting a flag
+	var flag bool
+	for _, r := range s {
+		switch {
+		case n > 0 && (prev == '\r' && r == '\n' || prev == zwj || r == zwj || isExtend(r)):
+			flag = false
+		case n > 0 && flag && isRegionalIndicator(r):
+			flag = false
+		default:
+			n++
+			flag = isRegionalIndicator(r)
+		}
+		prev = r
+	}
+	return n
+}
+
+const zwj = '\u200d'
+
+func isExtend(r rune) bool {
+	return unicode.In(r, unicode.Mn, unicode.Me, unicode.Mc) || r >= 0x1f3fb && r <= 0x1f3ff
+}
+
+func isRegionalIndicator(r rune) bool {
+	return r >= 0x1f1e6 && r <= 0x1f1ff
+}
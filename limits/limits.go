@@ -0,0 +1,51 @@
+// Package limits sets the size limits of atproto data, shared by the cbor, car, repo and events packages so
+// that services reject oversized data consistently, at the edge. Errors of every package enforcing them match
+// ErrExceeded.
+package limits
+
+import (
+	"fmt"
+
+	"github.com/notjuliet/grove/errdefs"
+)
+
+// Limits of the atproto network.
+const (
+	// Maximum size of an encoded record.
+	MaxRecordSize = 1 << 20
+	// Maximum size of a block, which holds a record or an MST node.
+	MaxBlockSize = MaxRecordSize
+	// Maximum size of the CAR slice of a #commit or #sync event, its blocks field.
+	MaxSliceSize = 2_000_000
+	// Maximum number of operations of a commit, in a #commit event or a batch of writes.
+	MaxCommitOps = 200
+)
+
+// Matches errdefs.ErrLimit.
+var ErrExceeded = errdefs.New(errdefs.ErrLimit, "size limit exceeded")
+
+// Size limits enforced on data, such as by car.ReaderOptions, repo.VerifyOptions and events.Validator. Zero
+// fields are not enforced.
+type Limits struct {
+	MaxRecordSize int
+	MaxBlockSize  int
+	MaxSliceSize  int
+	MaxCommitOps  int
+}
+
+// Limits of the atproto network.
+var Network = Limits{
+	MaxRecordSize: MaxRecordSize,
+	MaxBlockSize:  MaxBlockSize,
+	MaxSliceSize:  MaxSliceSize,
+	MaxCommitOps:  MaxCommitOps,
+}
+
+// Returns an error matching ErrExceeded if n exceeds limit, describing n as what, such as "bytes" or "ops". A
+// limit of 0 is not enforced.
+func Check(n, limit int, what string) error {
+	if limit > 0 && n > limit {
+		return fmt.Errorf("%w: %d %s, limit is %d", ErrExceeded, n, what, limit)
+	}
+	return nil
+}
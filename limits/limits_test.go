@@ -0,0 +1,23 @@
+package limits
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/notjuliet/grove/errdefs"
+)
+
+func TestCheck(t *testing.T) {
+	for _, tc := range []struct {
+		n, limit int
+		ok       bool
+	}{{0, 0, true}, {1 << 30, 0, true}, {5, 5, true}, {6, 5, false}} {
+		err := Check(tc.n, tc.limit, "bytes")
+		if (err == nil) != tc.ok || err != nil && (!errors.Is(err, ErrExceeded) || !errors.Is(err, errdefs.ErrLimit)) {
+			t.Errorf("unexpected result for %d with limit %d: %v", tc.n, tc.limit, err)
+		}
+	}
+	if err := Check(6, 5, "bytes"); err.Error() != "size limit exceeded: 6 bytes, limit is 5" {
+		t.Fatalf("unexpected message %q", err)
+	}
+}
@@ -0,0 +1,58 @@
+// Package metrics defines the hook through which grove components report measurements, so that they can be
+// bound to Prometheus, OpenTelemetry or any other system without grove depending on it.
+package metrics
+
+// Receives the measurements of a component. Names are dot-separated, such as "events.messages", with durations
+// in seconds and sizes in bytes. Implementations must be safe for concurrent use and cheap, as they are called
+// on hot paths.
+type Recorder interface {
+	// Adds delta to a counter.
+	Count(name string, delta int64)
+	// Sets a gauge.
+	Gauge(name string, value float64)
+	// Records a sample of a distribution, such as a latency, typically into a histogram.
+	Observe(name string, value float64)
+}
+
+// Recorder calling the given functions, any of which may be nil to ignore that kind of measurement.
+type Funcs struct {
+	OnCount   func(name string, delta int64)
+	OnGauge   func(name string, value float64)
+	OnObserve func(name string, value float64)
+}
+
+func (f Funcs) Count(name string, delta int64) {
+	if f.OnCount != nil {
+		f.OnCount(name, delta)
+	}
+}
+
+func (f Funcs) Gauge(name string, value float64) {
+	if f.OnGauge != nil {
+		f.OnGauge(name, value)
+	}
+}
+
+func (f Funcs) Observe(name string, value float64) {
+	if f.OnObserve != nil {
+		f.OnObserve(name, value)
+	}
+}
+
+// Returns a recorder prefixing the names of the measurements with prefix and a dot before passing them to r,
+// to tell apart several instances of a component.
+func WithPrefix(r Recorder, prefix string) Recorder {
+	return prefixed{r: r, prefix: prefix + "."}
+}
+
+type prefixed struct {
+	r      Recorder
+	prefix string
+}
+
+func (p prefixed) Count(name string, delta int64)     { p.r.Count(p.prefix+name, delta) }
+func (p prefixed) Gauge(name string, value float64)   { p.r.Gauge(p.prefix+name, value) }
+func (p prefixed) Observe(name string, value float64) { p.r.Observe(p.prefix+name, value) }
+
+// Recorder discarding every measurement, used by components without a recorder.
+var Discard Recorder = Funcs{}
@@ -0,0 +1,22 @@
+package metrics
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestWithPrefix(t *testing.T) {
+	var got []string
+	r := WithPrefix(Funcs{
+		OnCount: func(name string, delta int64) { got = append(got, name) },
+		OnGauge: func(name string, value float64) { got = append(got, name) },
+	}, "relay")
+	r.Count("events.messages", 1)
+	r.Gauge("events.lag.seconds", 0.5)
+	// ignored without OnObserve
+	r.Observe("events.handle.seconds", 0.1)
+	Discard.Count("events.messages", 1)
+	if !reflect.DeepEqual(got, []string{"relay.events.messages", "relay.events.lag.seconds"}) {
+		t.Fatalf("unexpected measurements %q", got)
+	}
+}
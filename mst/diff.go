@@ -0,0 +1,167 @@
+package mst
+
+import (
+	"bytes"
+	"context"
+
+	"github.com/notjuliet/grove/cid"
+)
+
+// Difference between two trees for a single key.
+type Change struct {
+	Key string
+	// Value in the first tree, nil if the key was added.
+	Old *cid.Cid
+	// Value in the second tree, nil if the key was removed.
+	New *cid.Cid
+}
+
+// Returns the keys whose values differ between two trees, sorted by key. Both trees are walked together and
+// subtrees with the same CID on both sides are skipped without being fetched, so the cost scales with the size
+// of the change rather than the size of the trees.
+func Diff(ctx context.Context, from, to *Tree) ([]Change, error) {
+	changes, _, err := diff(ctx, from, to, false)
+	return changes, err
+}
+
+// Like Diff, but also returns the CIDs of the nodes of the second tree which are not in the first, which bring a
+// copy of the first tree up to date along with the new values.
+func DiffNodes(ctx context.Context, from, to *Tree) ([]Change, []cid.Cid, error) {
+	return diff(ctx, from, to, true)
+}
+
+func diff(ctx context.Context, from, to *Tree, nodes bool) ([]Change, []cid.Cid, error) {
+	a, b := newDiffWalker(from), newDiffWalker(to)
+	b.record = nodes
+	var changes []Change
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, nil, err
+		}
+		x, y := a.peek(), b.peek()
+		var err error
+		switch {
+		case x == nil && y == nil:
+			return changes, b.visited, nil
+		case x != nil && x.sub != nil && (y == nil || y.sub == nil):
+			err = a.descend(ctx)
+		case y != nil && y.sub != nil && (x == nil || x.sub == nil):
+			err = b.descend(ctx)
+		case x != nil && y != nil && x.sub != nil:
+			err = diffSubtrees(ctx, a, b, x, y)
+		case y == nil || x != nil && x.key < y.key:
+			changes = append(changes, Change{Key: x.key, Old: &x.val})
+			a.next()
+		case x == nil || y.key < x.key:
+			changes = append(changes, Change{Key: y.key, New: &y.val})
+			b.next()
+		default:
+			if !bytes.Equal(x.val.Bytes, y.val.Bytes) {
+				changes = append(changes, Change{Key: x.key, Old: &x.val, New: &y.val})
+			}
+			a.next()
+			b.next()
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+}
+
+// skips two subtrees with the same CID, holding the same entries, or descends into the higher one, or both if
+// they are at the same layer
+func diffSubtrees(ctx context.Context, a, b *diffWalker, x, y *diffItem) error {
+	if x.sub != y.sub {
+		cx, err := x.sub.hash()
+		if err != nil {
+			return err
+		}
+		cy, err := y.sub.hash()
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(cx.Bytes, cy.Bytes) {
+			lx, ly := x.layer, y.layer
+			if lx >= ly {
+				if err := a.descend(ctx); err != nil {
+					return err
+				}
+			}
+			if ly >= lx {
+				return b.descend(ctx)
+			}
+			return nil
+		}
+	}
+	a.next()
+	b.next()
+	return nil
+}
+
+// Subtree or entry reached while walking a tree.
+type diffItem struct {
+	// subtree, nil for an entry
+	sub *node
+	// layer of the subtree's root or of the entry
+	layer int
+	key   string
+	val   cid.Cid
+}
+
+// Walks a tree in key order, fetching only the subtrees it descends into.
+type diffWalker struct {
+	t *Tree
+	// remaining items of each node on the path from the root
+	stack [][]diffItem
+	// whether to record the CIDs of the nodes descended into, in visited
+	record  bool
+	visited []cid.Cid
+}
+
+func newDiffWalker(t *Tree) *diffWalker {
+	return &diffWalker{t: t, stack: [][]diffItem{{{sub: t.root, layer: t.layer}}}}
+}
+
+// returns the next item, or nil at the end of the tree
+func (w *diffWalker) peek() *diffItem {
+	for len(w.stack) > 0 {
+		top := w.stack[len(w.stack)-1]
+		if len(top) > 0 {
+			return &top[0]
+		}
+		w.stack = w.stack[:len(w.stack)-1]
+	}
+	return nil
+}
+
+func (w *diffWalker) next() {
+	w.stack[len(w.stack)-1] = w.stack[len(w.stack)-1][1:]
+}
+
+// replaces the next item, a subtree, with its subtrees and entries
+func (w *diffWalker) descend(ctx context.Context) error {
+	it := *w.peek()
+	w.next()
+	if err := w.t.expand(ctx, it.sub); err != nil {
+		return err
+	}
+	if w.record {
+		c, err := it.sub.hash()
+		if err != nil {
+			return err
+		}
+		w.visited = append(w.visited, c)
+	}
+	items := make([]diffItem, 0, 2*len(it.sub.entries)+1)
+	if it.sub.left != nil {
+		items = append(items, diffItem{sub: it.sub.left, layer: it.layer - 1})
+	}
+	for _, e := range it.sub.entries {
+		items = append(items, diffItem{layer: it.layer, key: e.key, val: e.val})
+		if e.right != nil {
+			items = append(items, diffItem{sub: e.right, layer: it.layer - 1})
+		}
+	}
+	w.stack = append(w.stack, items)
+	return nil
+}
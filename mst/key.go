@@ -0,0 +1,89 @@
+package mst
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"math/bits"
+	"strings"
+)
+
+// Maximum length of an MST key in bytes.
+const MaxKeyLength = 1024
+
+// Error returned when inserting a key which is not a valid repository path.
+type InvalidKeyError struct {
+	Key    string
+	Reason string
+}
+
+func (e *InvalidKeyError) Error() string {
+	return fmt.Sprintf("invalid MST key %q: %s", e.Key, e.Reason)
+}
+
+// Checks that a key is a valid repository path: a collection and a record key separated by a single slash,
+// using only the characters allowed in record keys, with a record key other than "." and "..".
+//
+// https://atproto.com/specs/repository#mst-structure
+func ValidateKey(key string) error {
+	if len(key) > MaxKeyLength {
+		return &InvalidKeyError{key, fmt.Sprintf("longer than %d bytes", MaxKeyLength)}
+	}
+	collection, rkey, ok := strings.Cut(key, "/")
+	if !ok || strings.Contains(rkey, "/") {
+		return &InvalidKeyError{key, "must contain exactly one slash"}
+	}
+	if collection == "" || rkey == "" {
+		return &InvalidKeyError{key, "collection and record key must not be empty"}
+	}
+	if rkey == "." || rkey == ".." {
+		return &InvalidKeyError{key, "record key must not be . or .."}
+	}
+	for i := range len(key) {
+		if c := key[i]; c != '/' && !keyChar(c) {
+			return &InvalidKeyError{key, fmt.Sprintf("disallowed character %q", c)}
+		}
+	}
+	return nil
+}
+
+// Like ValidateKey, but accepts record keys written before the record key syntax was restricted: any
+// printable ASCII character other than a slash is allowed.
+func ValidateLegacyKey(key string) error {
+	if len(key) > MaxKeyLength {
+		return &InvalidKeyError{key, fmt.Sprintf("longer than %d bytes", MaxKeyLength)}
+	}
+	collection, rkey, ok := strings.Cut(key, "/")
+	if !ok || strings.Contains(rkey, "/") {
+		return &InvalidKeyError{key, "must contain exactly one slash"}
+	}
+	if collection == "" || rkey == "" {
+		return &InvalidKeyError{key, "collection and record key must not be empty"}
+	}
+	for i := range len(key) {
+		if c := key[i]; c <= ' ' || c > '~' {
+			return &InvalidKeyError{key, fmt.Sprintf("disallowed character %q", c)}
+		}
+	}
+	return nil
+}
+
+func keyChar(c byte) bool {
+	switch {
+	case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		return true
+	}
+	return strings.IndexByte("_~-:.", c) >= 0
+}
+
+// Returns the MST depth of a key: the number of leading zero bits of its SHA-256 hash, divided by two.
+func KeyDepth(key string) int {
+	h := sha256.Sum256([]byte(key))
+	depth := 0
+	for _, b := range h {
+		if b != 0 {
+			return depth + bits.LeadingZeros8(b)/2
+		}
+		depth += 4
+	}
+	return depth
+}
@@ -0,0 +1,432 @@
+package mst
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+
+	"github.com/notjuliet/grove/blockstore"
+	"github.com/notjuliet/grove/cid"
+)
+
+var ErrNotFound = errors.New("key not found")
+
+// Merkle Search Tree mapping string keys to CIDs, backed by a blockstore.
+//
+// https://atproto.com/specs/repository#mst-structure
+type Tree struct {
+	bs   blockstore.Blockstore
+	root *node
+	// layer of the root node, the depth of its keys
+	layer int
+}
+
+// Creates an empty tree.
+func New(bs blockstore.Blockstore) *Tree {
+	return &Tree{bs: bs, root: &node{}}
+}
+
+// Returns an independent copy of the tree. Nodes are shared, so copying is cheap, but changes to either tree
+// are not visible in the other.
+func (t *Tree) Copy() *Tree {
+	c := *t
+	return &c
+}
+
+// Opens the tree with the given root node. Only the root is fetched; other nodes are fetched from the blockstore
+// when an operation first reaches them, so only the parts of the tree in use are held in memory. Each node is
+// checked as it is fetched: it must be well-formed, its keys must be sorted and within the range of its
+// position, and each key must sit at the layer matching its depth.
+func Load(ctx context.Context, bs blockstore.Blockstore, root cid.Cid) (*Tree, error) {
+	nd, err := fetchNode(ctx, bs, root)
+	if err != nil {
+		return nil, err
+	}
+	layer, err := rootLayer(nd)
+	if err != nil {
+		return nil, err
+	}
+	n := &node{cid: &root, stored: true}
+	if err := n.fill(nd, layer, "", ""); err != nil {
+		return nil, err
+	}
+	return &Tree{bs: bs, root: n, layer: layer}, nil
+}
+
+func fetchNode(ctx context.Context, bs blockstore.Blockstore, c cid.Cid) (nodeData, error) {
+	data, err := bs.Get(ctx, c)
+	if err != nil {
+		return nodeData{}, fmt.Errorf("fetching MST node %s: %w", c, err)
+	}
+	nd, err := decodeNode(data)
+	if err != nil {
+		return nodeData{}, fmt.Errorf("decoding MST node %s: %w", c, err)
+	}
+	return nd, nil
+}
+
+// returns the layer of a root node, given by the depth of its keys
+func rootLayer(nd nodeData) (int, error) {
+	if len(nd.entries) > 0 {
+		return KeyDepth(nd.entries[0].key), nil
+	}
+	if nd.left != nil {
+		return 0, errors.New("MST root node has no entries")
+	}
+	return 0, nil
+}
+
+// checks that the keys of a node at the given layer fall strictly between lo and hi (empty for unbounded),
+// in order and at the right depth
+func checkNode(c cid.Cid, nd nodeData, layer int, lo, hi string) error {
+	if layer == 0 && nd.left != nil {
+		return fmt.Errorf("MST node %s has a subtree below layer 0", c)
+	}
+	prev := lo
+	for _, e := range nd.entries {
+		if (prev != "" && e.key <= prev) || (hi != "" && e.key >= hi) {
+			return fmt.Errorf("MST key %q is out of order", e.key)
+		}
+		if d := KeyDepth(e.key); d != layer {
+			return fmt.Errorf("MST key %q has depth %d but is at layer %d", e.key, d, layer)
+		}
+		if layer == 0 && e.right != nil {
+			return fmt.Errorf("MST node %s has a subtree below layer 0", c)
+		}
+		prev = e.key
+	}
+	return nil
+}
+
+// sets the contents of a node at the given layer, whose keys must fall strictly between lo and hi (empty for
+// unbounded), with its subtrees left to be fetched on demand
+func (n *node) fill(nd nodeData, layer int, lo, hi string) error {
+	if err := checkNode(*n.cid, nd, layer, lo, hi); err != nil {
+		return err
+	}
+	child := func(c *cid.Cid, lo, hi string) *node {
+		if c == nil {
+			return nil
+		}
+		return &node{cid: c, stored: true, lazy: &lazyNode{layer: layer - 1, lo: lo, hi: hi}}
+	}
+
+	left := child(nd.left, lo, firstKey(nd, hi))
+	entries := make([]entry, len(nd.entries))
+	for i, e := range nd.entries {
+		next := hi
+		if i+1 < len(nd.entries) {
+			next = nd.entries[i+1].key
+		}
+		entries[i] = entry{key: e.key, val: e.val, right: child(e.right, e.key, next)}
+	}
+	n.left, n.entries = left, entries
+	return nil
+}
+
+func firstKey(nd nodeData, fallback string) string {
+	if len(nd.entries) == 0 {
+		return fallback
+	}
+	return nd.entries[0].key
+}
+
+// Fetches the contents of a node loaded on demand, if not done yet. Must be called before reading the
+// entries or subtrees of any node; it is safe to call concurrently, and does nothing for nil nodes.
+func (t *Tree) expand(ctx context.Context, n *node) error {
+	if n == nil || n.lazy == nil {
+		return nil
+	}
+	l := n.lazy
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	if l.loaded {
+		return nil
+	}
+	nd, err := fetchNode(ctx, t.bs, *n.cid)
+	if err != nil {
+		return err
+	}
+	if len(nd.entries) == 0 && nd.left == nil {
+		return fmt.Errorf("MST node %s is empty", n.cid)
+	}
+	if err := n.fill(nd, l.layer, l.lo, l.hi); err != nil {
+		return err
+	}
+	l.loaded = true
+	return nil
+}
+
+// Returns the value stored under key, and whether it is present.
+func (t *Tree) Get(ctx context.Context, key string) (cid.Cid, bool, error) {
+	n := t.root
+	for n != nil {
+		if err := t.expand(ctx, n); err != nil {
+			return cid.Cid{}, false, err
+		}
+		i, found := n.search(key)
+		if found {
+			return n.entries[i].val, true, nil
+		}
+		n = n.child(i)
+	}
+	return cid.Cid{}, false, nil
+}
+
+// Stores val under key, replacing any existing value. Returns an *InvalidKeyError if the key is not a valid
+// repository path.
+func (t *Tree) Insert(ctx context.Context, key string, val cid.Cid) error {
+	if err := ValidateKey(key); err != nil {
+		return err
+	}
+	if err := t.expand(ctx, t.root); err != nil {
+		return err
+	}
+	depth := KeyDepth(key)
+	if t.root.empty() {
+		t.layer = depth
+	}
+	root := t.root
+	for layer := t.layer; layer < depth; layer++ {
+		root = &node{left: root}
+	}
+	root, err := t.insert(ctx, root, max(t.layer, depth), key, val, depth)
+	if err != nil {
+		return err
+	}
+	t.root, t.layer = root, max(t.layer, depth)
+	return nil
+}
+
+func (t *Tree) insert(ctx context.Context, n *node, layer int, key string, val cid.Cid, depth int) (*node, error) {
+	if n == nil {
+		n = &node{}
+	}
+	if err := t.expand(ctx, n); err != nil {
+		return nil, err
+	}
+	i, found := n.search(key)
+	if depth < layer {
+		c, err := t.insert(ctx, n.child(i), layer-1, key, val, depth)
+		if err != nil {
+			return nil, err
+		}
+		return n.withChild(i, c), nil
+	}
+
+	m := &node{left: n.left, entries: make([]entry, 0, len(n.entries)+1)}
+	if found {
+		m.entries = append(m.entries, n.entries...)
+		m.entries[i].val = val
+		return m, nil
+	}
+	lo, hi, err := t.split(ctx, n.child(i), key)
+	if err != nil {
+		return nil, err
+	}
+	m.entries = append(m.entries, n.entries[:i]...)
+	m.entries = append(m.entries, entry{key: key, val: val, right: hi})
+	m.entries = append(m.entries, n.entries[i:]...)
+	m.setChild(i, lo)
+	return m, nil
+}
+
+// splits a subtree around a key it does not contain
+func (t *Tree) split(ctx context.Context, n *node, key string) (*node, *node, error) {
+	if n == nil {
+		return nil, nil, nil
+	}
+	if err := t.expand(ctx, n); err != nil {
+		return nil, nil, err
+	}
+	i, _ := n.search(key)
+	lo, hi, err := t.split(ctx, n.child(i), key)
+	if err != nil {
+		return nil, nil, err
+	}
+	left := &node{left: n.left, entries: append([]entry(nil), n.entries[:i]...)}
+	left.setChild(i, lo)
+	right := &node{left: hi, entries: append([]entry(nil), n.entries[i:]...)}
+	return normalize(left), normalize(right), nil
+}
+
+// joins two adjacent subtrees of the same layer, where every key of a is lower than every key of b
+func (t *Tree) merge(ctx context.Context, a, b *node) (*node, error) {
+	if a == nil {
+		return b, nil
+	}
+	if b == nil {
+		return a, nil
+	}
+	if err := t.expand(ctx, a); err != nil {
+		return nil, err
+	}
+	if err := t.expand(ctx, b); err != nil {
+		return nil, err
+	}
+	last := len(a.entries)
+	c, err := t.merge(ctx, a.child(last), b.left)
+	if err != nil {
+		return nil, err
+	}
+	m := &node{left: a.left, entries: append(append([]entry(nil), a.entries...), b.entries...)}
+	m.setChild(last, c)
+	return m, nil
+}
+
+// Removes key from the tree, returning ErrNotFound if it is not present.
+func (t *Tree) Delete(ctx context.Context, key string) error {
+	root, ok, err := t.remove(ctx, t.root, key)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrNotFound
+	}
+	// trim empty layers from the top of the tree
+	layer := t.layer
+	for root != nil && len(root.entries) == 0 && root.left != nil {
+		root = root.left
+		layer--
+		if err := t.expand(ctx, root); err != nil {
+			return err
+		}
+	}
+	if root == nil {
+		root, layer = &node{}, 0
+	}
+	t.root, t.layer = root, layer
+	return nil
+}
+
+func (t *Tree) remove(ctx context.Context, n *node, key string) (*node, bool, error) {
+	if n == nil {
+		return nil, false, nil
+	}
+	if err := t.expand(ctx, n); err != nil {
+		return nil, false, err
+	}
+	i, found := n.search(key)
+	if !found {
+		c, ok, err := t.remove(ctx, n.child(i), key)
+		if err != nil || !ok {
+			return n, false, err
+		}
+		return normalize(n.withChild(i, c)), true, nil
+	}
+
+	c, err := t.merge(ctx, n.child(i), n.entries[i].right)
+	if err != nil {
+		return nil, false, err
+	}
+	m := &node{left: n.left, entries: make([]entry, 0, len(n.entries)-1)}
+	m.entries = append(m.entries, n.entries[:i]...)
+	m.entries = append(m.entries, n.entries[i+1:]...)
+	m.setChild(i, c)
+	return normalize(m), true, nil
+}
+
+// Returns the CID of the root node, without writing anything to the blockstore. Subtrees changed since they
+// were last hashed are hashed in parallel.
+func (t *Tree) Root(ctx context.Context) (cid.Cid, error) {
+	return t.root.hashConcurrent(make(chan struct{}, runtime.GOMAXPROCS(0)-1))
+}
+
+// Writes every node which is not yet in the blockstore, returning the root CID and the newly written blocks.
+func (t *Tree) Write(ctx context.Context) (cid.Cid, []blockstore.Block, error) {
+	root, err := t.Root(ctx)
+	if err != nil {
+		return cid.Cid{}, nil, err
+	}
+	var nodes []*node
+	collectUnstored(t.root, &nodes)
+	blocks := make([]blockstore.Block, len(nodes))
+	for i, n := range nodes {
+		blocks[i] = blockstore.Block{Cid: *n.cid, Data: n.data}
+	}
+	if err := t.bs.PutMany(ctx, blocks); err != nil {
+		return cid.Cid{}, nil, err
+	}
+	for _, n := range nodes {
+		n.stored, n.data = true, nil
+	}
+	return root, blocks, nil
+}
+
+// appends n and its descendants which are not yet stored; descendants of stored nodes are always stored, and
+// unstored nodes are never loaded on demand
+func collectUnstored(n *node, nodes *[]*node) {
+	if n == nil || n.stored {
+		return
+	}
+	*nodes = append(*nodes, n)
+	collectUnstored(n.left, nodes)
+	for _, e := range n.entries {
+		collectUnstored(e.right, nodes)
+	}
+}
+
+// Calls fn for every key not lower than from, with its value, in key order. Stops at the first error, which
+// is returned. Subtrees holding only lower keys are skipped.
+func (t *Tree) ForEach(ctx context.Context, from string, fn func(key string, val cid.Cid) error) error {
+	return t.forEach(ctx, t.root, from, fn)
+}
+
+func (t *Tree) forEach(ctx context.Context, n *node, from string, fn func(key string, val cid.Cid) error) error {
+	if n == nil {
+		return nil
+	}
+	// loaded nodes are walked without fetching anything
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := t.expand(ctx, n); err != nil {
+		return err
+	}
+	i, _ := n.search(from)
+	if err := t.forEach(ctx, n.child(i), from, fn); err != nil {
+		return err
+	}
+	for _, e := range n.entries[i:] {
+		if err := fn(e.key, e.val); err != nil {
+			return err
+		}
+		if err := t.forEach(ctx, e.right, from, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Returns the CIDs of every node in the tree, fetching any node not loaded yet.
+func (t *Tree) NodeCids(ctx context.Context) ([]cid.Cid, error) {
+	var cids []cid.Cid
+	var visit func(n *node) error
+	visit = func(n *node) error {
+		if n == nil {
+			return nil
+		}
+		if err := t.expand(ctx, n); err != nil {
+			return err
+		}
+		c, err := n.hash()
+		if err != nil {
+			return err
+		}
+		cids = append(cids, c)
+		if err := visit(n.left); err != nil {
+			return err
+		}
+		for _, e := range n.entries {
+			if err := visit(e.right); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := visit(t.root); err != nil {
+		return nil, err
+	}
+	return cids, nil
+}
@@ -0,0 +1,624 @@
+package mst
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/notjuliet/grove/blockstore"
+	"github.com/notjuliet/grove/cid"
+)
+
+var cid1, _ = cid.Parse("bafyreie5cvv4h45feadgeuwhbcutmh6t2ceseocckahdoe6uat64zmz454")
+
+func build(t *testing.T, keys ...string) *Tree {
+	tree := New(blockstore.NewMemoryBlockstore())
+	for _, k := range keys {
+		if err := tree.Insert(context.Background(), k, cid1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return tree
+}
+
+func checkRoot(t *testing.T, tree *Tree, layer int, expected string) {
+	t.Helper()
+	root, err := tree.Root(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if root.String() != expected {
+		t.Fatalf("expected root %s, got %s", expected, root)
+	}
+	if tree.layer != layer {
+		t.Fatalf("expected root layer %d, got %d", layer, tree.layer)
+	}
+}
+
+type countingBlockstore struct {
+	blockstore.Blockstore
+	gets int
+}
+
+func (bs *countingBlockstore) Get(ctx context.Context, c cid.Cid) ([]byte, error) {
+	bs.gets++
+	return bs.Blockstore.Get(ctx, c)
+}
+
+// compares every entry of two trees
+func fullDiff(t *testing.T, a, b *Tree) []Change {
+	t.Helper()
+	entries := func(tree *Tree) map[string]cid.Cid {
+		m := map[string]cid.Cid{}
+		err := tree.ForEach(context.Background(), "", func(key string, val cid.Cid) error {
+			m[key] = val
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return m
+	}
+	old, cur := entries(a), entries(b)
+	var changes []Change
+	for k, v := range cur {
+		if prev, ok := old[k]; !ok {
+			changes = append(changes, Change{Key: k, New: &v})
+		} else if prev.String() != v.String() {
+			changes = append(changes, Change{Key: k, Old: &prev, New: &v})
+		}
+	}
+	for k, v := range old {
+		if _, ok := cur[k]; !ok {
+			changes = append(changes, Change{Key: k, Old: &v})
+		}
+	}
+	slices.SortFunc(changes, func(x, y Change) int { return strings.Compare(x.Key, y.Key) })
+	return changes
+}
+
+func key(rkey string) string {
+	return "com.example.record/" + rkey
+}
+
+// vectors shared with the reference implementations
+func TestInterop(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("known maps", func(t *testing.T) {
+		checkRoot(t, build(t), 0, "bafyreie5737gdxlw5i64vzichcalba3z2v5n6icifvx5xytvske7mr3hpm")
+		checkRoot(t, build(t, key("3jqfcqzm3fo2j")), 0, "bafyreibj4lsc3aqnrvphp5xmrnfoorvru4wynt6lwidqbm2623a6tatzdu")
+		checkRoot(t, build(t, key("3jqfcqzm3fx2j")), 2, "bafyreih7wfei65pxzhauoibu3ls7jgmkju4bspy4t2ha2qdjnzqvoy33ai")
+		checkRoot(t, build(t, key("3jqfcqzm3fp2j"), key("3jqfcqzm3fr2j"), key("3jqfcqzm3fs2j"), key("3jqfcqzm3ft2j"), key("3jqfcqzm4fc2j")),
+			1, "bafyreicmahysq4n6wfuxo522m6dpiy7z7qzym3dzs756t5n7nfdgccwq7m")
+	})
+
+	t.Run("trim top", func(t *testing.T) {
+		tree := build(t, key("3jqfcqzm3fn2j"), key("3jqfcqzm3fo2j"), key("3jqfcqzm3fp2j"), key("3jqfcqzm3fs2j"), key("3jqfcqzm3ft2j"), key("3jqfcqzm3fu2j"))
+		checkRoot(t, tree, 1, "bafyreifnqrwbk6ffmyaz5qtujqrzf5qmxf7cbxvgzktl4e3gabuxbtatv4")
+		if err := tree.Delete(ctx, key("3jqfcqzm3fs2j")); err != nil {
+			t.Fatal(err)
+		}
+		checkRoot(t, tree, 0, "bafyreie4kjuxbwkhzg2i5dljaswcroeih4dgiqq6pazcmunwt2byd725vi")
+	})
+
+	t.Run("insertion", func(t *testing.T) {
+		tree := build(t, key("3jqfcqzm3fo2j"), key("3jqfcqzm3fp2j"), key("3jqfcqzm3fr2j"), key("3jqfcqzm3fs2j"), key("3jqfcqzm3ft2j"),
+			key("3jqfcqzm3fz2j"), key("3jqfcqzm4fc2j"), key("3jqfcqzm4fd2j"), key("3jqfcqzm4ff2j"), key("3jqfcqzm4fg2j"), key("3jqfcqzm4fh2j"))
+		checkRoot(t, tree, 1, "bafyreiettyludka6fpgp33stwxfuwhkzlur6chs4d2v4nkmq2j3ogpdjem")
+		if err := tree.Insert(ctx, key("3jqfcqzm3fx2j"), cid1); err != nil {
+			t.Fatal(err)
+		}
+		checkRoot(t, tree, 2, "bafyreid2x5eqs4w4qxvc5jiwda4cien3gw2q6cshofxwnvv7iucrmfohpm")
+		if err := tree.Delete(ctx, key("3jqfcqzm3fx2j")); err != nil {
+			t.Fatal(err)
+		}
+		checkRoot(t, tree, 1, "bafyreiettyludka6fpgp33stwxfuwhkzlur6chs4d2v4nkmq2j3ogpdjem")
+	})
+
+	t.Run("higher", func(t *testing.T) {
+		l0root := "bafyreidfcktqnfmykz2ps3dbul35pepleq7kvv526g47xahuz3rqtptmky"
+		l2root := "bafyreiavxaxdz7o7rbvr3zg2liox2yww46t7g6hkehx4i4h3lwudly7dhy"
+		tree := build(t, key("3jqfcqzm3ft2j"), key("3jqfcqzm3fz2j"))
+		checkRoot(t, tree, 0, l0root)
+		if err := tree.Insert(ctx, key("3jqfcqzm3fx2j"), cid1); err != nil {
+			t.Fatal(err)
+		}
+		checkRoot(t, tree, 2, l2root)
+		if err := tree.Delete(ctx, key("3jqfcqzm3fx2j")); err != nil {
+			t.Fatal(err)
+		}
+		checkRoot(t, tree, 0, l0root)
+		for _, k := range []string{key("3jqfcqzm3fx2j"), key("3jqfcqzm4fd2j")} {
+			if err := tree.Insert(ctx, k, cid1); err != nil {
+				t.Fatal(err)
+			}
+		}
+		checkRoot(t, tree, 2, "bafyreig4jv3vuajbsybhyvb7gggvpwh2zszwfyttjrj6qwvcsp24h6popu")
+		if err := tree.Delete(ctx, key("3jqfcqzm4fd2j")); err != nil {
+			t.Fatal(err)
+		}
+		checkRoot(t, tree, 2, l2root)
+	})
+}
+
+func TestTree(t *testing.T) {
+	ctx := context.Background()
+	var keys []string
+	for i := range 500 {
+		keys = append(keys, fmt.Sprintf("com.example.record/%04d", i))
+	}
+
+	bs := blockstore.NewMemoryBlockstore()
+	tree := New(bs)
+	for _, i := range rand.Perm(len(keys)) {
+		c, _ := cid.Create(cid.CodecRaw, []byte(keys[i]))
+		if err := tree.Insert(ctx, keys[i], c); err != nil {
+			t.Fatal(err)
+		}
+	}
+	root, blocks, err := tree.Write(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(blocks) == 0 || bs.Len() != len(blocks) {
+		t.Fatal("expected every node to be written")
+	}
+
+	t.Run("order independent", func(t *testing.T) {
+		other := New(blockstore.NewMemoryBlockstore())
+		for _, k := range keys {
+			c, _ := cid.Create(cid.CodecRaw, []byte(k))
+			if err := other.Insert(ctx, k, c); err != nil {
+				t.Fatal(err)
+			}
+		}
+		checkRoot(t, other, tree.layer, root.String())
+	})
+
+	t.Run("load", func(t *testing.T) {
+		loaded, err := Load(ctx, bs, root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, k := range keys {
+			val, ok, err := loaded.Get(ctx, k)
+			if err != nil || !ok {
+				t.Fatalf("missing key %s", k)
+			}
+			expected, _ := cid.Create(cid.CodecRaw, []byte(k))
+			if val.String() != expected.String() {
+				t.Fatalf("invalid value for key %s", k)
+			}
+		}
+		if _, ok, _ := loaded.Get(ctx, "com.example.record/missing"); ok {
+			t.Fatal("unexpected key")
+		}
+
+		// nothing changed, so there is nothing new to write
+		if _, blocks, err := loaded.Write(ctx); err != nil || len(blocks) != 0 {
+			t.Fatal("expected no new blocks")
+		}
+	})
+
+	t.Run("walk", func(t *testing.T) {
+		seq, walkErr := Walk(ctx, bs, root)
+		i := 0
+		for k, val := range seq {
+			expected, _ := cid.Create(cid.CodecRaw, []byte(k))
+			if k != keys[i] || val.String() != expected.String() {
+				t.Fatalf("unexpected key %s at %d", k, i)
+			}
+			i++
+		}
+		if err := walkErr(); err != nil || i != len(keys) {
+			t.Fatalf("walked %d keys: %v", i, err)
+		}
+
+		for range seq {
+			break
+		}
+		if err := walkErr(); err != nil {
+			t.Fatal(err)
+		}
+
+		seq, walkErr = Walk(ctx, blockstore.NewMemoryBlockstore(), root)
+		for range seq {
+			t.Fatal("unexpected key")
+		}
+		if !errors.Is(walkErr(), blockstore.ErrNotFound) {
+			t.Fatal("expected not found error")
+		}
+	})
+
+	t.Run("stats", func(t *testing.T) {
+		stats, err := Stats(ctx, bs, root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		size := int64(0)
+		for _, blk := range blocks {
+			size += int64(len(blk.Data))
+		}
+		if stats.Leaves != len(keys) || stats.Nodes != len(blocks) || stats.Bytes != size || len(stats.Depths) != tree.layer+1 {
+			t.Fatalf("unexpected stats %+v", stats)
+		}
+		total := 0
+		for depth, n := range stats.Depths {
+			total += n
+			if depth > 0 && n > stats.Depths[depth-1] {
+				t.Fatalf("more keys at depth %d than below", depth)
+			}
+		}
+		if total != len(keys) {
+			t.Fatal("depth histogram does not add up")
+		}
+
+		// the keys are listed during the same traversal
+		counting := &countingBlockstore{Blockstore: bs}
+		var listed []string
+		again, err := StatsForEach(ctx, counting, root, func(key string, val cid.Cid) error {
+			listed = append(listed, key)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if again.Leaves != stats.Leaves || again.Nodes != stats.Nodes || again.Bytes != stats.Bytes ||
+			!slices.Equal(again.Depths, stats.Depths) || !slices.Equal(listed, slices.Sorted(slices.Values(keys))) {
+			t.Fatal("unexpected stats or keys")
+		}
+		if counting.gets != len(blocks) {
+			t.Fatalf("fetched %d nodes for a tree of %d", counting.gets, len(blocks))
+		}
+	})
+
+	t.Run("lazy", func(t *testing.T) {
+		counting := &countingBlockstore{Blockstore: bs}
+		loaded, err := Load(ctx, counting, root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok, err := loaded.Get(ctx, keys[123]); err != nil || !ok {
+			t.Fatal("missing key")
+		}
+		if counting.gets > tree.layer+1 {
+			t.Fatalf("fetched %d nodes for a single lookup", counting.gets)
+		}
+		if err := loaded.Insert(ctx, key("new"), cid1); err != nil {
+			t.Fatal(err)
+		}
+		if err := loaded.Delete(ctx, keys[321]); err != nil {
+			t.Fatal(err)
+		}
+		if counting.gets >= len(blocks) {
+			t.Fatal("expected most nodes to stay unloaded")
+		}
+
+		expected := tree.Copy()
+		if err := expected.Insert(ctx, key("new"), cid1); err != nil {
+			t.Fatal(err)
+		}
+		if err := expected.Delete(ctx, keys[321]); err != nil {
+			t.Fatal(err)
+		}
+		want, _ := expected.Root(ctx)
+		checkRoot(t, loaded, expected.layer, want.String())
+	})
+
+	t.Run("diff", func(t *testing.T) {
+		other := tree.Copy()
+		if err := other.Delete(ctx, keys[10]); err != nil {
+			t.Fatal(err)
+		}
+		if err := other.Insert(ctx, keys[20], cid1); err != nil {
+			t.Fatal(err)
+		}
+		if err := other.Insert(ctx, key("new"), cid1); err != nil {
+			t.Fatal(err)
+		}
+		changes, err := Diff(ctx, tree, other)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(changes) != 3 ||
+			changes[0].Key != keys[10] || changes[0].Old == nil || changes[0].New != nil ||
+			changes[1].Key != keys[20] || changes[1].Old == nil || changes[1].New.String() != cid1.String() ||
+			changes[2].Key != key("new") || changes[2].Old != nil || changes[2].New == nil {
+			t.Fatalf("unexpected changes %+v", changes)
+		}
+		if changes, err := Diff(ctx, tree, tree); err != nil || len(changes) != 0 {
+			t.Fatal("expected no changes")
+		}
+
+		// shared subtrees are not fetched
+		changed := tree.Copy()
+		if err := changed.Insert(ctx, keys[250], cid1); err != nil {
+			t.Fatal(err)
+		}
+		changedRoot, written, err := changed.Write(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		counting := &countingBlockstore{Blockstore: bs}
+		from, err := Load(ctx, counting, root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		to, err := Load(ctx, counting, changedRoot)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if changes, err := Diff(ctx, from, to); err != nil || len(changes) != 1 || changes[0].Key != keys[250] {
+			t.Fatalf("unexpected changes %+v, %v", changes, err)
+		}
+		if counting.gets > 2*(tree.layer+1) {
+			t.Fatalf("fetched %d of %d nodes for a single change", counting.gets, len(blocks))
+		}
+		_, nodes, err := DiffNodes(ctx, from, to)
+		if err != nil {
+			t.Fatal(err)
+		}
+		newNodes := cid.NewSet(nodes...)
+		if newNodes.Len() != len(written) {
+			t.Fatalf("expected %d new nodes, got %d", len(written), newNodes.Len())
+		}
+		for _, blk := range written {
+			if !newNodes.Has(blk.Cid) {
+				t.Fatalf("missing new node %s", blk.Cid)
+			}
+		}
+
+		// random edits, including ones changing the root layer, against a full comparison
+		for range 20 {
+			a, b := tree.Copy(), tree.Copy()
+			for range rand.IntN(20) {
+				k := keys[rand.IntN(len(keys))]
+				if rand.IntN(2) == 0 {
+					k = key(fmt.Sprintf("extra%03d", rand.IntN(1000)))
+				}
+				for _, t2 := range []*Tree{a, b}[:1+rand.IntN(2)] {
+					if rand.IntN(3) == 0 {
+						t2.Delete(ctx, k)
+					} else if err := t2.Insert(ctx, k, cid1); err != nil {
+						t.Fatal(err)
+					}
+				}
+				a, b = b, a
+			}
+			changes, err := Diff(ctx, a, b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := fmt.Sprint(changes), fmt.Sprint(fullDiff(t, a, b)); got != want {
+				t.Fatalf("expected changes %s, got %s", want, got)
+			}
+		}
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		for _, i := range rand.Perm(len(keys)) {
+			if err := tree.Delete(ctx, keys[i]); err != nil {
+				t.Fatal(err)
+			}
+		}
+		checkRoot(t, tree, 0, "bafyreie5737gdxlw5i64vzichcalba3z2v5n6icifvx5xytvske7mr3hpm")
+		if err := tree.Delete(ctx, keys[0]); err != ErrNotFound {
+			t.Fatal("expected not found error")
+		}
+	})
+}
+
+func TestKeys(t *testing.T) {
+	depths := map[string]int{
+		"":                                0,
+		"asdf":                            0,
+		"blue":                            1,
+		"2653ae71":                        0,
+		"88bfafc7":                        2,
+		"2a92d355":                        4,
+		"884976f5":                        6,
+		"app.bsky.feed.post/454397e440ec": 4,
+		"app.bsky.feed.post/9adeb165882c": 8,
+	}
+	for k, depth := range depths {
+		if d := KeyDepth(k); d != depth {
+			t.Fatalf("expected depth %d for %q, got %d", depth, k, d)
+		}
+	}
+
+	valid := []string{
+		"com.example.record/3jqfcqzm3fo2j",
+		"coll/self",
+		"coll/lang:en",
+		"com.example.record/a_b-c.d~e",
+		"coll/...",
+		"coll/.a",
+	}
+	for _, k := range valid {
+		if err := ValidateKey(k); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	invalid := []string{
+		"",
+		"nocollection",
+		"/rkey",
+		"coll/",
+		"a/b/c",
+		"coll/bad key",
+		"coll/jalapeño",
+		"coll/.",
+		"coll/..",
+		"coll/" + strings.Repeat("a", MaxKeyLength),
+	}
+	tree := New(blockstore.NewMemoryBlockstore())
+	for _, k := range invalid {
+		var keyErr *InvalidKeyError
+		if err := tree.Insert(context.Background(), k, cid1); !errors.As(err, &keyErr) {
+			t.Fatalf("expected invalid key error for %q", k)
+		}
+	}
+
+	if err := ValidateLegacyKey("coll/key#with!chars"); err != nil {
+		t.Fatal(err)
+	}
+	for _, k := range []string{"coll/bad key", "a/b/c", "coll/jalapeño"} {
+		if err := ValidateLegacyKey(k); err == nil {
+			t.Fatalf("expected invalid legacy key error for %q", k)
+		}
+	}
+}
+
+func TestProof(t *testing.T) {
+	ctx := context.Background()
+	bs := blockstore.NewMemoryBlockstore()
+	tree := New(bs)
+	for i := range 200 {
+		if err := tree.Insert(ctx, fmt.Sprintf("com.example.record/%04d", i*2), cid1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	root, _, err := tree.Write(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// prove from a freshly loaded tree, so node blocks come from the blockstore
+	tree, err = Load(ctx, bs, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, k := range []string{"com.example.record/0100", "com.example.record/0101", "com.example.record/9999", "a/a"} {
+		proof, err := tree.Prove(ctx, k)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, present, _ := tree.Get(ctx, k)
+		val, ok, err := VerifyProof(root, k, proof)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok != present || (ok && val.String() != cid1.String()) {
+			t.Fatalf("invalid proof result for %s", k)
+		}
+
+		if len(proof) > 1 {
+			if _, _, err := VerifyProof(root, k, proof[:len(proof)-1]); !errors.Is(err, ErrIncompleteProof) {
+				t.Fatal("expected incomplete proof error")
+			}
+		}
+		tampered := append([]blockstore.Block(nil), proof...)
+		tampered[0].Data = append([]byte{}, tampered[0].Data...)
+		tampered[0].Data[len(tampered[0].Data)-1] ^= 1
+		if _, _, err := VerifyProof(root, k, tampered); err == nil {
+			t.Fatal("expected tampered proof to fail")
+		}
+	}
+}
+
+// commit proof fixtures shared with the reference implementations
+func TestCoveringProof(t *testing.T) {
+	ctx := context.Background()
+	raw, err := os.ReadFile("testdata/commit-proof-fixtures.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fixtures []struct {
+		Comment          string   `json:"comment"`
+		LeafValue        string   `json:"leafValue"`
+		Keys             []string `json:"keys"`
+		Adds             []string `json:"adds"`
+		Dels             []string `json:"dels"`
+		RootBeforeCommit string   `json:"rootBeforeCommit"`
+		RootAfterCommit  string   `json:"rootAfterCommit"`
+		BlocksInProof    []string `json:"blocksInProof"`
+	}
+	if err := json.Unmarshal(raw, &fixtures); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, f := range fixtures {
+		t.Run(f.Comment, func(t *testing.T) {
+			tree := build(t, f.Keys...)
+			before, err := tree.Root(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if before.String() != f.RootBeforeCommit {
+				t.Fatal("invalid root before commit")
+			}
+			if _, _, err := tree.Write(ctx); err != nil {
+				t.Fatal(err)
+			}
+
+			for _, k := range f.Adds {
+				if err := tree.Insert(ctx, k, cid1); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for _, k := range f.Dels {
+				if err := tree.Delete(ctx, k); err != nil {
+					t.Fatal(err)
+				}
+			}
+			after, created, err := tree.Write(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if after.String() != f.RootAfterCommit {
+				t.Fatal("invalid root after commit")
+			}
+
+			blocks, err := tree.CoveringProof(ctx, append(f.Adds, f.Dels...))
+			if err != nil {
+				t.Fatal(err)
+			}
+			included := map[string]bool{}
+			for _, blk := range blocks {
+				included[blk.Cid.String()] = true
+			}
+			for _, c := range f.BlocksInProof {
+				if !included[c] {
+					t.Fatalf("proof is missing block %s", c)
+				}
+			}
+			for _, blk := range created {
+				if !included[blk.Cid.String()] {
+					t.Fatalf("proof is missing new node %s", blk.Cid)
+				}
+			}
+			for _, k := range append(f.Adds, f.Dels...) {
+				if _, _, err := VerifyProof(after, k, blocks); err != nil {
+					t.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkWrite(b *testing.B) {
+	ctx := context.Background()
+	var keys []string
+	for i := range 50000 {
+		keys = append(keys, fmt.Sprintf("com.example.record/%06d", i))
+	}
+	for b.Loop() {
+		tree := New(blockstore.NewMemoryBlockstore())
+		for _, k := range keys {
+			if err := tree.Insert(ctx, k, cid1); err != nil {
+				b.Fatal(err)
+			}
+		}
+		if _, _, err := tree.Write(ctx); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,284 @@
+package mst
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/notjuliet/grove/cbor"
+	"github.com/notjuliet/grove/cid"
+)
+
+// In-memory MST node. Nodes are never modified once created, so unchanged subtrees are shared between
+// successive versions of a tree and keep their cached CIDs.
+type node struct {
+	// subtree with keys lower than the first entry
+	left    *node
+	entries []entry
+	// cached CID and encoding, computed on demand
+	cid  *cid.Cid
+	data []byte
+	// whether the node's block is known to be in the blockstore
+	stored bool
+	// set for nodes whose contents are fetched from the blockstore on first use, see Tree.expand
+	lazy *lazyNode
+}
+
+// Position of a node which has not been fetched yet, used to validate it once it is.
+type lazyNode struct {
+	mtx    sync.Mutex
+	loaded bool
+	layer  int
+	// bounds of the node's keys, exclusive, empty for unbounded
+	lo, hi string
+}
+
+type entry struct {
+	key string
+	val cid.Cid
+	// subtree with keys between this entry and the next
+	right *node
+}
+
+// returns the index of the first entry not lower than key, and whether that entry matches key
+func (n *node) search(key string) (int, bool) {
+	lo, hi := 0, len(n.entries)
+	for lo < hi {
+		mid := (lo + hi) / 2
+		if n.entries[mid].key < key {
+			lo = mid + 1
+		} else {
+			hi = mid
+		}
+	}
+	return lo, lo < len(n.entries) && n.entries[lo].key == key
+}
+
+// returns the subtree before entry i, or after the last entry when i is len(entries)
+func (n *node) child(i int) *node {
+	if i == 0 {
+		return n.left
+	}
+	return n.entries[i-1].right
+}
+
+// sets the subtree before entry i on a node which has not been shared yet
+func (n *node) setChild(i int, c *node) {
+	if i == 0 {
+		n.left = c
+	} else {
+		n.entries[i-1].right = c
+	}
+}
+
+// returns a copy of n with the subtree before entry i replaced
+func (n *node) withChild(i int, c *node) *node {
+	m := &node{left: n.left, entries: append([]entry(nil), n.entries...)}
+	m.setChild(i, c)
+	return m
+}
+
+func (n *node) empty() bool {
+	return len(n.entries) == 0 && n.left == nil
+}
+
+// drops nodes left without entries or subtrees
+func normalize(n *node) *node {
+	if n == nil || n.empty() {
+		return nil
+	}
+	return n
+}
+
+// Returns the CID of the node, encoding it and any uncached descendants.
+func (n *node) hash() (cid.Cid, error) {
+	if n.cid != nil {
+		return *n.cid, nil
+	}
+	data, err := n.encode()
+	if err != nil {
+		return cid.Cid{}, err
+	}
+	c, err := cid.Create(cid.CodecCbor, data)
+	if err != nil {
+		return cid.Cid{}, err
+	}
+	n.cid, n.data = &c, data
+	return c, nil
+}
+
+// Like hash, but hashes independent subtrees in parallel, running at most cap(sem) extra goroutines.
+func (n *node) hashConcurrent(sem chan struct{}) (cid.Cid, error) {
+	if n.cid != nil {
+		return *n.cid, nil
+	}
+	var wg sync.WaitGroup
+	var mtx sync.Mutex
+	var firstErr error
+	run := func(c *node) {
+		if _, err := c.hashConcurrent(sem); err != nil {
+			mtx.Lock()
+			firstErr = cmp.Or(firstErr, err)
+			mtx.Unlock()
+		}
+	}
+	visit := func(c *node) {
+		if c == nil || c.cid != nil {
+			return
+		}
+		select {
+		case sem <- struct{}{}:
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer func() { <-sem }()
+				run(c)
+			}()
+		default:
+			run(c)
+		}
+	}
+	visit(n.left)
+	for _, e := range n.entries {
+		visit(e.right)
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return cid.Cid{}, firstErr
+	}
+	return n.hash()
+}
+
+func (n *node) encode() ([]byte, error) {
+	var left any
+	if n.left != nil {
+		c, err := n.left.hash()
+		if err != nil {
+			return nil, err
+		}
+		left = c.Link()
+	}
+
+	entries := make([]any, 0, len(n.entries))
+	prev := ""
+	for _, e := range n.entries {
+		var right any
+		if e.right != nil {
+			c, err := e.right.hash()
+			if err != nil {
+				return nil, err
+			}
+			right = c.Link()
+		}
+		p := commonPrefix(prev, e.key)
+		entries = append(entries, map[string]any{
+			"p": uint64(p),
+			"k": []byte(e.key[p:]),
+			"v": e.val.Link(),
+			"t": right,
+		})
+		prev = e.key
+	}
+	return cbor.Encode(map[string]any{"e": entries, "l": left})
+}
+
+func commonPrefix(a, b string) int {
+	n := min(len(a), len(b))
+	for i := range n {
+		if a[i] != b[i] {
+			return i
+		}
+	}
+	return n
+}
+
+// Node as stored in a block, with child pointers left as CIDs.
+type nodeData struct {
+	left    *cid.Cid
+	entries []entryData
+}
+
+type entryData struct {
+	key   string
+	val   cid.Cid
+	right *cid.Cid
+}
+
+// Parses an MST node block, checking its structure and that keys are strictly increasing.
+func decodeNode(data []byte) (nodeData, error) {
+	v, err := cbor.Decode(data)
+	if err != nil {
+		return nodeData{}, err
+	}
+	m, ok := v.(map[string]any)
+	if !ok || len(m) != 2 {
+		return nodeData{}, errors.New("MST node is not a map of e and l")
+	}
+
+	var nd nodeData
+	if nd.left, err = optionalLink(m["l"]); err != nil {
+		return nodeData{}, fmt.Errorf("invalid MST node l: %w", err)
+	}
+	list, ok := m["e"].([]any)
+	if !ok {
+		return nodeData{}, errors.New("MST node has no entry list")
+	}
+
+	prev := ""
+	for i, raw := range list {
+		em, ok := raw.(map[string]any)
+		if !ok || len(em) != 4 {
+			return nodeData{}, fmt.Errorf("MST entry %d is not a map of p, k, v and t", i)
+		}
+		p, ok := em["p"].(uint64)
+		if !ok || p > uint64(len(prev)) || (i == 0 && p != 0) {
+			return nodeData{}, fmt.Errorf("invalid MST entry %d prefix length", i)
+		}
+		suffix, ok := em["k"].([]byte)
+		if !ok {
+			return nodeData{}, fmt.Errorf("invalid MST entry %d key suffix", i)
+		}
+		link, ok := em["v"].(cid.CidLink)
+		if !ok {
+			return nodeData{}, fmt.Errorf("invalid MST entry %d value", i)
+		}
+		val, err := link.Cid()
+		if err != nil {
+			return nodeData{}, fmt.Errorf("invalid MST entry %d value: %w", i, err)
+		}
+		right, err := optionalLink(em["t"])
+		if err != nil {
+			return nodeData{}, fmt.Errorf("invalid MST entry %d subtree: %w", i, err)
+		}
+
+		key := prev[:p] + string(suffix)
+		if i > 0 && key <= prev {
+			return nodeData{}, errors.New("MST node keys are not sorted")
+		}
+		nd.entries = append(nd.entries, entryData{key: key, val: val, right: right})
+		prev = key
+	}
+	return nd, nil
+}
+
+// Checks that a block is a well-formed MST node, with valid entries and strictly increasing keys.
+func ValidateNode(data []byte) error {
+	_, err := decodeNode(data)
+	return err
+}
+
+func optionalLink(v any) (*cid.Cid, error) {
+	switch v := v.(type) {
+	case nil:
+		return nil, nil
+	case cid.CidLink:
+		c, err := v.Cid()
+		if err != nil {
+			return nil, err
+		}
+		return &c, nil
+	default:
+		return nil, errors.New("not a link")
+	}
+}
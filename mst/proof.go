@@ -0,0 +1,135 @@
+package mst
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/notjuliet/grove/blockstore"
+	"github.com/notjuliet/grove/cid"
+)
+
+var ErrIncompleteProof = errors.New("proof is missing an MST node")
+
+// returns the encoded block of a node, fetching it from the blockstore if the encoding is not cached
+func (t *Tree) block(ctx context.Context, n *node) (blockstore.Block, error) {
+	c, err := n.hash()
+	if err != nil {
+		return blockstore.Block{}, err
+	}
+	if n.data != nil {
+		return blockstore.Block{Cid: c, Data: n.data}, nil
+	}
+	data, err := t.bs.Get(ctx, c)
+	if err != nil {
+		return blockstore.Block{}, fmt.Errorf("fetching MST node %s: %w", c, err)
+	}
+	return blockstore.Block{Cid: c, Data: data}, nil
+}
+
+// Returns a proof that key is present or absent: the blocks of every node on its search path from the root.
+// The same proof covers both cases, VerifyProof tells them apart.
+func (t *Tree) Prove(ctx context.Context, key string) ([]blockstore.Block, error) {
+	var blocks []blockstore.Block
+	n := t.root
+	for n != nil {
+		if err := t.expand(ctx, n); err != nil {
+			return nil, err
+		}
+		blk, err := t.block(ctx, n)
+		if err != nil {
+			return nil, err
+		}
+		blocks = append(blocks, blk)
+		i, found := n.search(key)
+		if found {
+			break
+		}
+		n = n.child(i)
+	}
+	return blocks, nil
+}
+
+// Checks a proof for key under a trusted root, returning the value it maps to and whether it is present.
+// Every block must hash to its CID; returns ErrIncompleteProof if a node on the search path is missing.
+func VerifyProof(root cid.Cid, key string, blocks []blockstore.Block) (cid.Cid, bool, error) {
+	byCid := make(map[string][]byte, len(blocks))
+	for _, blk := range blocks {
+		byCid[string(blk.Cid.Bytes)] = blk.Data
+	}
+
+	next := &root
+	for next != nil {
+		data, ok := byCid[string(next.Bytes)]
+		if !ok {
+			return cid.Cid{}, false, fmt.Errorf("%w: %s", ErrIncompleteProof, next)
+		}
+		computed, err := cid.Create(next.Codec, data)
+		if err != nil {
+			return cid.Cid{}, false, err
+		}
+		if !bytes.Equal(computed.Bytes, next.Bytes) {
+			return cid.Cid{}, false, fmt.Errorf("proof block does not match CID %s", next)
+		}
+		nd, err := decodeNode(data)
+		if err != nil {
+			return cid.Cid{}, false, fmt.Errorf("decoding MST node %s: %w", next, err)
+		}
+
+		next = nd.left
+		for _, e := range nd.entries {
+			if e.key == key {
+				return e.val, true, nil
+			}
+			if e.key > key {
+				break
+			}
+			next = e.right
+		}
+	}
+	return cid.Cid{}, false, nil
+}
+
+// Returns the blocks which must accompany a commit changing the given keys (the covering proof of sync v1.1),
+// so that consumers holding only the previous root can check and invert each operation.
+//
+// For every key this is the set of nodes in the current tree whose range contains it: its search path,
+// forking into both neighbouring subtrees where the key is present. Every node created by the commit lies on
+// one of these paths, so the previous tree is not needed.
+func (t *Tree) CoveringProof(ctx context.Context, keys []string) ([]blockstore.Block, error) {
+	seen := map[*node]bool{}
+	var blocks []blockstore.Block
+	var visit func(n *node, key string) error
+	visit = func(n *node, key string) error {
+		for n != nil {
+			if err := t.expand(ctx, n); err != nil {
+				return err
+			}
+			if !seen[n] {
+				seen[n] = true
+				blk, err := t.block(ctx, n)
+				if err != nil {
+					return err
+				}
+				blocks = append(blocks, blk)
+			}
+			i, found := n.search(key)
+			if found {
+				if err := visit(n.child(i), key); err != nil {
+					return err
+				}
+				n = n.entries[i].right
+			} else {
+				n = n.child(i)
+			}
+		}
+		return nil
+	}
+	for _, k := range keys {
+		if err := visit(t.root, k); err != nil {
+			return nil, err
+		}
+	}
+	return blocks, nil
+}
@@ -0,0 +1,103 @@
+package mst
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/notjuliet/grove/blockstore"
+	"github.com/notjuliet/grove/cid"
+)
+
+// Shape and size of a tree, as reported by Stats.
+type TreeStats struct {
+	// Number of keys.
+	Leaves int
+	// Number of nodes and their total encoded size.
+	Nodes int
+	Bytes int64
+	// Number of keys at each depth, from depth 0 up to the root layer.
+	Depths []int
+}
+
+// Computes the statistics of the tree with the given root, fetching every node from the blockstore. Nodes are
+// checked like with Load; like Walk, only the nodes on the current path are held in memory.
+func Stats(ctx context.Context, bs blockstore.Blockstore, root cid.Cid) (*TreeStats, error) {
+	return StatsForEach(ctx, bs, root, nil)
+}
+
+// Like Stats, also calling fn, if not nil, with every key and value in key order during the same traversal. An
+// error returned by fn stops the traversal and is returned.
+func StatsForEach(ctx context.Context, bs blockstore.Blockstore, root cid.Cid, fn func(key string, val cid.Cid) error) (*TreeStats, error) {
+	stats := &TreeStats{}
+	fetch := func(c cid.Cid) (nodeData, error) {
+		if err := ctx.Err(); err != nil {
+			return nodeData{}, err
+		}
+		data, err := bs.Get(ctx, c)
+		if err != nil {
+			return nodeData{}, fmt.Errorf("fetching MST node %s: %w", c, err)
+		}
+		nd, err := decodeNode(data)
+		if err != nil {
+			return nodeData{}, fmt.Errorf("decoding MST node %s: %w", c, err)
+		}
+		stats.Nodes++
+		stats.Bytes += int64(len(data))
+		return nd, nil
+	}
+
+	var visit func(c cid.Cid, nd nodeData, layer int, lo, hi string) error
+	visit = func(c cid.Cid, nd nodeData, layer int, lo, hi string) error {
+		if err := checkNode(c, nd, layer, lo, hi); err != nil {
+			return err
+		}
+		stats.Leaves += len(nd.entries)
+		stats.Depths[layer] += len(nd.entries)
+		child := func(c *cid.Cid, lo, hi string) error {
+			if c == nil {
+				return nil
+			}
+			nd, err := fetch(*c)
+			if err != nil {
+				return err
+			}
+			if len(nd.entries) == 0 && nd.left == nil {
+				return fmt.Errorf("MST node %s is empty", c)
+			}
+			return visit(*c, nd, layer-1, lo, hi)
+		}
+
+		if err := child(nd.left, lo, firstKey(nd, hi)); err != nil {
+			return err
+		}
+		for i, e := range nd.entries {
+			if fn != nil {
+				if err := fn(e.key, e.val); err != nil {
+					return err
+				}
+			}
+			next := hi
+			if i+1 < len(nd.entries) {
+				next = nd.entries[i+1].key
+			}
+			if err := child(e.right, e.key, next); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	nd, err := fetch(root)
+	if err != nil {
+		return nil, err
+	}
+	layer, err := rootLayer(nd)
+	if err != nil {
+		return nil, err
+	}
+	stats.Depths = make([]int, layer+1)
+	if err := visit(root, nd, layer, "", ""); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
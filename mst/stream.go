@@ -0,0 +1,83 @@
+package mst
+
+import (
+	"fmt"
+
+	"github.com/notjuliet/grove/cid"
+)
+
+// Checks the nodes of a tree received one at a time, such as from a CAR stream, without a blockstore. Nodes
+// must arrive after the node linking to them, starting with the root, and are checked like with Load. Only
+// the CIDs and key ranges of the nodes still awaited are kept.
+type StreamChecker struct {
+	want map[string]position
+}
+
+// place of an awaited node in the tree
+type position struct {
+	cid    cid.Cid
+	root   bool
+	layer  int
+	lo, hi string
+}
+
+// Creates a checker for the tree with the given root, awaiting the root node first.
+func NewStreamChecker(root cid.Cid) *StreamChecker {
+	return &StreamChecker{want: map[string]position{string(root.Bytes): {cid: root, root: true}}}
+}
+
+// Returns whether c is the CID of a node linked from the nodes checked so far and not received yet.
+func (sc *StreamChecker) Wants(c cid.Cid) bool {
+	_, ok := sc.want[string(c.Bytes)]
+	return ok
+}
+
+// Checks an awaited node, calling fn for each of its keys and values in order, and then awaits its subtrees.
+// The subtrees of a node failing the check are not awaited.
+func (sc *StreamChecker) Check(c cid.Cid, data []byte, fn func(key string, val cid.Cid)) error {
+	pos, ok := sc.want[string(c.Bytes)]
+	if !ok {
+		return fmt.Errorf("MST node %s is not linked from the tree", c)
+	}
+	delete(sc.want, string(c.Bytes))
+
+	nd, err := decodeNode(data)
+	if err != nil {
+		return fmt.Errorf("decoding MST node %s: %w", c, err)
+	}
+	if pos.root {
+		if pos.layer, err = rootLayer(nd); err != nil {
+			return err
+		}
+	} else if len(nd.entries) == 0 && nd.left == nil {
+		return fmt.Errorf("MST node %s is empty", c)
+	}
+	if err := checkNode(c, nd, pos.layer, pos.lo, pos.hi); err != nil {
+		return err
+	}
+
+	await := func(c *cid.Cid, lo, hi string) {
+		if c != nil {
+			sc.want[string(c.Bytes)] = position{cid: *c, layer: pos.layer - 1, lo: lo, hi: hi}
+		}
+	}
+	await(nd.left, pos.lo, firstKey(nd, pos.hi))
+	for i, e := range nd.entries {
+		fn(e.key, e.val)
+		next := pos.hi
+		if i+1 < len(nd.entries) {
+			next = nd.entries[i+1].key
+		}
+		await(e.right, e.key, next)
+	}
+	return nil
+}
+
+// Returns the CIDs of the nodes linked from the tree but not received yet.
+func (sc *StreamChecker) Missing() []cid.Cid {
+	var missing []cid.Cid
+	for _, pos := range sc.want {
+		missing = append(missing, pos.cid)
+	}
+	return missing
+}
@@ -0,0 +1,74 @@
+package mst
+
+import (
+	"context"
+	"fmt"
+	"iter"
+
+	"github.com/notjuliet/grove/blockstore"
+	"github.com/notjuliet/grove/cid"
+)
+
+// Returns an iterator over the keys and values of the tree with the given root, in key order, and a function
+// reporting the error which ended the iteration early, if any. Nodes are checked like with Load but only the
+// nodes on the path to the current key are held in memory, unlike with a Tree, which keeps every node it
+// fetched.
+func Walk(ctx context.Context, bs blockstore.Blockstore, root cid.Cid) (iter.Seq2[string, cid.Cid], func() error) {
+	var err error
+	seq := func(yield func(string, cid.Cid) bool) {
+		err = nil
+		var visit func(c cid.Cid, nd nodeData, layer int, lo, hi string) bool
+		visit = func(c cid.Cid, nd nodeData, layer int, lo, hi string) bool {
+			if err = checkNode(c, nd, layer, lo, hi); err != nil {
+				return false
+			}
+			child := func(c *cid.Cid, lo, hi string) bool {
+				if c == nil {
+					return true
+				}
+				if err = ctx.Err(); err != nil {
+					return false
+				}
+				var nd nodeData
+				if nd, err = fetchNode(ctx, bs, *c); err != nil {
+					return false
+				}
+				if len(nd.entries) == 0 && nd.left == nil {
+					err = fmt.Errorf("MST node %s is empty", c)
+					return false
+				}
+				return visit(*c, nd, layer-1, lo, hi)
+			}
+
+			if !child(nd.left, lo, firstKey(nd, hi)) {
+				return false
+			}
+			for i, e := range nd.entries {
+				if !yield(e.key, e.val) {
+					return false
+				}
+				next := hi
+				if i+1 < len(nd.entries) {
+					next = nd.entries[i+1].key
+				}
+				if !child(e.right, e.key, next) {
+					return false
+				}
+			}
+			return true
+		}
+
+		nd, fetchErr := fetchNode(ctx, bs, root)
+		if fetchErr != nil {
+			err = fetchErr
+			return
+		}
+		layer, layerErr := rootLayer(nd)
+		if layerErr != nil {
+			err = layerErr
+			return
+		}
+		visit(root, nd, layer, "", "")
+	}
+	return seq, func() error { return err }
+}
@@ -0,0 +1,388 @@
+package oauth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/notjuliet/grove/crypto"
+	"github.com/notjuliet/grove/identity"
+	"github.com/notjuliet/grove/internal/jwt"
+)
+
+const clientAssertionType = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+
+// maximum size of a token endpoint response body
+const maxTokenResponseSize = 64 * 1024
+
+type ClientConfig struct {
+	// URL of the client metadata document.
+	ClientID string
+	// Redirect URI registered in the client metadata.
+	RedirectURI string
+	// Requested scopes, defaults to "atproto".
+	Scope string
+	// Signing key for private_key_jwt client authentication. Public clients leave this unset.
+	ClientKey crypto.Signer
+	// Key ID of ClientKey in the client's published JWKS.
+	ClientKeyID string
+	// Directory used to confirm that the authorization server is authoritative for the account, defaults to an
+	// identity.BaseDirectory using HTTPClient.
+	Dir        identity.Directory
+	HTTPClient *http.Client
+}
+
+// OAuth client for atproto authorization servers.
+type Client struct {
+	cfg ClientConfig
+}
+
+func NewClient(cfg ClientConfig) *Client {
+	if cfg.Scope == "" {
+		cfg.Scope = "atproto"
+	}
+	if cfg.HTTPClient == nil {
+		cfg.HTTPClient = http.DefaultClient
+	}
+	if cfg.Dir == nil {
+		cfg.Dir = &identity.BaseDirectory{HTTPClient: cfg.HTTPClient}
+	}
+	return &Client{cfg}
+}
+
+// State of an in-progress authorization, which the application must persist between StartAuth and Callback.
+type AuthRequest struct {
+	State        string
+	PKCEVerifier string
+	AuthServer   *AuthServerMetadata
+	DPoPKey      *crypto.P256PrivateKey
+	// DID of the account, if known when the flow started.
+	DID string
+}
+
+// Authorized session, an xrpc.Authorizer which records the DPoP nonces of the services it is sent to. The DPoP
+// key must be persisted along with the tokens. Refresh updates the tokens in place, so while the session is in
+// use they must be read with Tokens.
+type Session struct {
+	DID          string
+	Scope        string
+	AccessToken  string
+	RefreshToken string
+	ExpiresAt    time.Time
+	AuthServer   *AuthServerMetadata
+	DPoPKey      *crypto.P256PrivateKey
+
+	// guards the tokens and dpop
+	mtx  sync.Mutex
+	dpop *DPoP
+}
+
+// Error response from an authorization server endpoint.
+type TokenError struct {
+	StatusCode  int
+	Code        string `json:"error"`
+	Description string `json:"error_description"`
+}
+
+func (e *TokenError) Error() string {
+	if e.Description != "" {
+		return fmt.Sprintf("oauth error %s (HTTP %d): %s", e.Code, e.StatusCode, e.Description)
+	}
+	return fmt.Sprintf("oauth error %s (HTTP %d)", e.Code, e.StatusCode)
+}
+
+type tokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int64  `json:"expires_in"`
+	Scope        string `json:"scope"`
+	Sub          string `json:"sub"`
+}
+
+// Starts authorization against the authorization server of a PDS using a pushed authorization request,
+// returning the URL to send the user to. loginHint (handle or DID) and did are optional.
+func (c *Client) StartAuth(ctx context.Context, pdsURL, loginHint, did string) (string, *AuthRequest, error) {
+	as, err := DiscoverAuthServer(ctx, c.cfg.HTTPClient, pdsURL)
+	if err != nil {
+		return "", nil, err
+	}
+	key, err := crypto.GenerateP256()
+	if err != nil {
+		return "", nil, err
+	}
+	dpop, err := NewDPoP(key)
+	if err != nil {
+		return "", nil, err
+	}
+
+	req := &AuthRequest{
+		State:        rand.Text(),
+		PKCEVerifier: rand.Text() + rand.Text(),
+		AuthServer:   as,
+		DPoPKey:      key,
+		DID:          did,
+	}
+	challenge := sha256.Sum256([]byte(req.PKCEVerifier))
+
+	form := url.Values{
+		"response_type":         {"code"},
+		"redirect_uri":          {c.cfg.RedirectURI},
+		"scope":                 {c.cfg.Scope},
+		"state":                 {req.State},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+	if loginHint != "" {
+		form.Set("login_hint", loginHint)
+	}
+
+	var par struct {
+		RequestURI string `json:"request_uri"`
+		ExpiresIn  int64  `json:"expires_in"`
+	}
+	if err := c.post(ctx, dpop, as, as.PushedAuthorizationRequestEndpoint, form, &par); err != nil {
+		return "", nil, fmt.Errorf("pushed authorization request: %w", err)
+	}
+	if par.RequestURI == "" {
+		return "", nil, errors.New("pushed authorization request: missing request_uri")
+	}
+
+	q := url.Values{"client_id": {c.cfg.ClientID}, "request_uri": {par.RequestURI}}
+	return as.AuthorizationEndpoint + "?" + q.Encode(), req, nil
+}
+
+// Completes authorization using the query parameters of the redirect back to the client.
+func (c *Client) Callback(ctx context.Context, req *AuthRequest, params url.Values) (*Session, error) {
+	if e := params.Get("error"); e != "" {
+		return nil, &TokenError{Code: e, Description: params.Get("error_description")}
+	}
+	if params.Get("state") != req.State {
+		return nil, errors.New("authorization state mismatch")
+	}
+	if iss := params.Get("iss"); iss != req.AuthServer.Issuer && (iss != "" || req.AuthServer.AuthorizationResponseIssParameter) {
+		return nil, errors.New("authorization response issuer mismatch")
+	}
+	code := params.Get("code")
+	if code == "" {
+		return nil, errors.New("authorization response is missing code")
+	}
+
+	s := &Session{AuthServer: req.AuthServer, DPoPKey: req.DPoPKey}
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {c.cfg.RedirectURI},
+		"code_verifier": {req.PKCEVerifier},
+	}
+	if err := c.tokenRequest(ctx, s, form, ""); err != nil {
+		return nil, err
+	}
+	if req.DID != "" && s.DID != req.DID {
+		return nil, fmt.Errorf("token subject %s does not match requested account %s", s.DID, req.DID)
+	}
+	if err := c.verifyIssuer(ctx, s.DID, req.AuthServer.Issuer); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Exchanges the session's refresh token for new tokens, updating the session in place.
+func (c *Client) Refresh(ctx context.Context, s *Session) error {
+	s.mtx.Lock()
+	did, refreshToken := s.DID, s.RefreshToken
+	s.mtx.Unlock()
+	if refreshToken == "" {
+		return errors.New("session has no refresh token")
+	}
+	form := url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {refreshToken},
+	}
+	return c.tokenRequest(ctx, s, form, did)
+}
+
+// requests tokens and sets them on the session, whose subject must be did unless it is empty
+func (c *Client) tokenRequest(ctx context.Context, s *Session, form url.Values, did string) error {
+	dpop, err := s.DPoP()
+	if err != nil {
+		return err
+	}
+	var tr tokenResponse
+	if err := c.post(ctx, dpop, s.AuthServer, s.AuthServer.TokenEndpoint, form, &tr); err != nil {
+		return fmt.Errorf("token request: %w", err)
+	}
+	if !strings.EqualFold(tr.TokenType, "DPoP") {
+		return fmt.Errorf("token request: unexpected token type %q", tr.TokenType)
+	}
+	if !strings.HasPrefix(tr.Sub, "did:") {
+		return fmt.Errorf("token request: invalid subject %q", tr.Sub)
+	}
+	if did != "" && tr.Sub != did {
+		return fmt.Errorf("refreshed token subject %s does not match session %s", tr.Sub, did)
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.DID = tr.Sub
+	s.Scope = tr.Scope
+	s.AccessToken = tr.AccessToken
+	if tr.RefreshToken != "" {
+		s.RefreshToken = tr.RefreshToken
+	}
+	s.ExpiresAt = time.Time{}
+	if tr.ExpiresIn > 0 {
+		s.ExpiresAt = time.Now().Add(time.Duration(tr.ExpiresIn) * time.Second)
+	}
+	return nil
+}
+
+// the authorization server must be the one designated by the account's PDS
+func (c *Client) verifyIssuer(ctx context.Context, did, issuer string) error {
+	ident, err := c.cfg.Dir.LookupDID(ctx, did)
+	if err != nil {
+		return fmt.Errorf("resolving token subject: %w", err)
+	}
+	pds := ident.PDSEndpoint()
+	if pds == "" {
+		return fmt.Errorf("token subject %s has no PDS", did)
+	}
+	rm, err := FetchResourceMetadata(ctx, c.cfg.HTTPClient, pds)
+	if err != nil {
+		return err
+	}
+	if rm.AuthorizationServers[0] != issuer {
+		return fmt.Errorf("issuer %s is not authoritative for %s", issuer, did)
+	}
+	return nil
+}
+
+func (c *Client) post(ctx context.Context, dpop *DPoP, as *AuthServerMetadata, endpoint string, form url.Values, v any) error {
+	form.Set("client_id", c.cfg.ClientID)
+	if c.cfg.ClientKey != nil {
+		assertion, err := c.clientAssertion(ctx, as.Issuer)
+		if err != nil {
+			return err
+		}
+		form.Set("client_assertion_type", clientAssertionType)
+		form.Set("client_assertion", assertion)
+	}
+
+	// retry once if the server asks for a fresh DPoP nonce
+	for attempt := 0; ; attempt++ {
+		proof, err := dpop.Proof(ctx, http.MethodPost, endpoint, "")
+		if err != nil {
+			return err
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Accept", "application/json")
+		req.Header.Set("DPoP", proof)
+
+		resp, err := c.cfg.HTTPClient.Do(req)
+		if err != nil {
+			return err
+		}
+		body, err := io.ReadAll(io.LimitReader(resp.Body, maxTokenResponseSize))
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+		dpop.UpdateNonce(endpoint, resp)
+
+		if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+			return json.Unmarshal(body, v)
+		}
+		if attempt == 0 && IsUseDPoPNonceError(resp, body) {
+			continue
+		}
+		te := &TokenError{StatusCode: resp.StatusCode}
+		if err := json.Unmarshal(body, te); err != nil || te.Code == "" {
+			te.Code = "unknown"
+		}
+		return te
+	}
+}
+
+func (c *Client) clientAssertion(ctx context.Context, issuer string) (string, error) {
+	now := time.Now()
+	claims := map[string]any{
+		"iss": c.cfg.ClientID,
+		"sub": c.cfg.ClientID,
+		"aud": issuer,
+		"jti": rand.Text(),
+		"iat": now.Unix(),
+		"exp": now.Add(time.Minute).Unix(),
+	}
+	return jwt.Sign(ctx, c.cfg.ClientKey, jwt.Header{Kid: c.cfg.ClientKeyID}, claims)
+}
+
+// Returns the DPoP state bound to the session key.
+func (s *Session) DPoP() (*DPoP, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if s.dpop == nil {
+		if s.DPoPKey == nil {
+			return nil, errors.New("session has no DPoP key")
+		}
+		d, err := NewDPoP(s.DPoPKey)
+		if err != nil {
+			return nil, err
+		}
+		s.dpop = d
+	}
+	return s.dpop, nil
+}
+
+// Returns the current tokens of the session and the expiry of the access token.
+func (s *Session) Tokens() (accessToken, refreshToken string, expiresAt time.Time) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return s.AccessToken, s.RefreshToken, s.ExpiresAt
+}
+
+// Reports whether the access token has expired, or will within the given margin.
+func (s *Session) Expired(margin time.Duration) bool {
+	_, _, expiresAt := s.Tokens()
+	return !expiresAt.IsZero() && time.Now().Add(margin).After(expiresAt)
+}
+
+// Sets the DPoP-bound Authorization and DPoP proof headers on a resource request.
+func (s *Session) Authorize(ctx context.Context, req *http.Request) error {
+	dpop, err := s.DPoP()
+	if err != nil {
+		return err
+	}
+	accessToken, _, _ := s.Tokens()
+	proof, err := dpop.Proof(ctx, req.Method, req.URL.String(), accessToken)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "DPoP "+accessToken)
+	req.Header.Set("DPoP", proof)
+	return nil
+}
+
+// Records the DPoP nonce sent by a resource server, and reports whether the request was rejected for lack of
+// it, in which case it must be sent again with a proof for the new nonce.
+func (s *Session) InspectResponse(req *http.Request, resp *http.Response) bool {
+	dpop, err := s.DPoP()
+	if err != nil {
+		return false
+	}
+	dpop.UpdateNonce(req.URL.String(), resp)
+	return resp.StatusCode == http.StatusUnauthorized && IsUseDPoPNonceError(resp, nil)
+}
@@ -0,0 +1,159 @@
+package oauth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/notjuliet/grove/crypto"
+	"github.com/notjuliet/grove/internal/jwt"
+)
+
+// Claims of a DPoP proof token.
+//
+// https://datatracker.ietf.org/doc/html/rfc9449
+type DPoPClaims struct {
+	Jti   string `json:"jti"`
+	Htm   string `json:"htm"`
+	Htu   string `json:"htu"`
+	Iat   int64  `json:"iat"`
+	Nonce string `json:"nonce,omitempty"`
+	// Access token hash, set when the proof accompanies a resource request.
+	Ath string `json:"ath,omitempty"`
+}
+
+// Creates a DPoP proof for a single HTTP request. The accessToken and nonce are optional.
+// atproto requires ES256 key binding, so the signer must be a P-256 key.
+func CreateDPoPProof(ctx context.Context, key crypto.Signer, method, target, nonce, accessToken string) (string, error) {
+	if key.Algorithm() != crypto.AlgES256 {
+		return "", errors.New("DPoP keys must use ES256")
+	}
+	pub, err := crypto.SignerPublicKey(key)
+	if err != nil {
+		return "", err
+	}
+	jwk, err := crypto.PublicJWK(pub)
+	if err != nil {
+		return "", err
+	}
+	htu, err := dpopTarget(target)
+	if err != nil {
+		return "", err
+	}
+
+	jti := make([]byte, 16)
+	if _, err := rand.Read(jti); err != nil {
+		return "", err
+	}
+	claims := DPoPClaims{
+		Jti:   hex.EncodeToString(jti),
+		Htm:   method,
+		Htu:   htu,
+		Iat:   time.Now().Unix(),
+		Nonce: nonce,
+	}
+	if accessToken != "" {
+		claims.Ath = AccessTokenHash(accessToken)
+	}
+	return jwt.Sign(ctx, key, jwt.Header{Typ: "dpop+jwt", JWK: jwk}, claims)
+}
+
+// Returns the "ath" claim value for an access token.
+func AccessTokenHash(accessToken string) string {
+	sum := sha256.Sum256([]byte(accessToken))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// the htu claim is the request URL without query and fragment
+func dpopTarget(target string) (string, error) {
+	u, err := url.Parse(target)
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return "", errors.New("DPoP target must be an absolute URL")
+	}
+	u.RawQuery = ""
+	u.Fragment = ""
+	return u.String(), nil
+}
+
+// DPoP key binding state, tracking the most recent server-provided nonce for each origin.
+// Safe for concurrent use.
+type DPoP struct {
+	key        crypto.Signer
+	thumbprint string
+
+	mtx    sync.Mutex
+	nonces map[string]string
+}
+
+// Returns DPoP state for an ES256 signing key.
+func NewDPoP(key crypto.Signer) (*DPoP, error) {
+	if key.Algorithm() != crypto.AlgES256 {
+		return nil, errors.New("DPoP keys must use ES256")
+	}
+	pub, err := crypto.SignerPublicKey(key)
+	if err != nil {
+		return nil, err
+	}
+	jwk, err := crypto.PublicJWK(pub)
+	if err != nil {
+		return nil, err
+	}
+	return &DPoP{key: key, thumbprint: jwk.Thumbprint(), nonces: map[string]string{}}, nil
+}
+
+// Returns the JWK thumbprint of the bound key, as used for the "jkt" confirmation claim.
+func (d *DPoP) Thumbprint() string {
+	return d.thumbprint
+}
+
+// Creates a proof for a request, using the last known nonce for the target's origin.
+func (d *DPoP) Proof(ctx context.Context, method, target, accessToken string) (string, error) {
+	d.mtx.Lock()
+	nonce := d.nonces[origin(target)]
+	d.mtx.Unlock()
+	return CreateDPoPProof(ctx, d.key, method, target, nonce, accessToken)
+}
+
+// Records the "DPoP-Nonce" header of a response, if present.
+func (d *DPoP) UpdateNonce(target string, resp *http.Response) {
+	nonce := resp.Header.Get("DPoP-Nonce")
+	if nonce == "" {
+		return
+	}
+	d.mtx.Lock()
+	d.nonces[origin(target)] = nonce
+	d.mtx.Unlock()
+}
+
+// Reports whether a response rejected the proof because a fresh nonce is required, in which case the
+// request should be retried once with the nonce recorded by UpdateNonce.
+func IsUseDPoPNonceError(resp *http.Response, body []byte) bool {
+	switch resp.StatusCode {
+	case http.StatusBadRequest:
+		// authorization servers return a JSON error body
+		return strings.Contains(string(body), `"use_dpop_nonce"`)
+	case http.StatusUnauthorized:
+		// resource servers use the WWW-Authenticate header
+		return strings.Contains(resp.Header.Get("WWW-Authenticate"), `error="use_dpop_nonce"`)
+	}
+	return false
+}
+
+func origin(target string) string {
+	u, err := url.Parse(target)
+	if err != nil {
+		return target
+	}
+	return u.Scheme + "://" + u.Host
+}
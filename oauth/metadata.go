@@ -0,0 +1,113 @@
+package oauth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// maximum size of a metadata document response body
+const maxMetadataSize = 64 * 1024
+
+// OAuth protected resource metadata (RFC 9728), as served by a PDS.
+type ResourceMetadata struct {
+	Resource             string   `json:"resource"`
+	AuthorizationServers []string `json:"authorization_servers"`
+}
+
+// OAuth authorization server metadata (RFC 8414), limited to the fields atproto relies on.
+type AuthServerMetadata struct {
+	Issuer                             string   `json:"issuer"`
+	AuthorizationEndpoint              string   `json:"authorization_endpoint"`
+	TokenEndpoint                      string   `json:"token_endpoint"`
+	PushedAuthorizationRequestEndpoint string   `json:"pushed_authorization_request_endpoint"`
+	RevocationEndpoint                 string   `json:"revocation_endpoint,omitempty"`
+	ScopesSupported                    []string `json:"scopes_supported,omitempty"`
+	ResponseTypesSupported             []string `json:"response_types_supported,omitempty"`
+	GrantTypesSupported                []string `json:"grant_types_supported,omitempty"`
+	CodeChallengeMethodsSupported      []string `json:"code_challenge_methods_supported,omitempty"`
+	DPoPSigningAlgValuesSupported      []string `json:"dpop_signing_alg_values_supported,omitempty"`
+	RequirePushedAuthorizationRequests bool     `json:"require_pushed_authorization_requests"`
+	AuthorizationResponseIssParameter  bool     `json:"authorization_response_iss_parameter_supported"`
+	ClientIDMetadataDocumentSupported  bool     `json:"client_id_metadata_document_supported"`
+}
+
+// Checks the metadata against the atproto OAuth profile requirements.
+func (m *AuthServerMetadata) Validate(issuer string) error {
+	if m.Issuer != issuer {
+		return fmt.Errorf("authorization server issuer %q does not match %q", m.Issuer, issuer)
+	}
+	if m.AuthorizationEndpoint == "" || m.TokenEndpoint == "" || m.PushedAuthorizationRequestEndpoint == "" {
+		return fmt.Errorf("authorization server metadata is missing required endpoints")
+	}
+	if !slices.Contains(m.CodeChallengeMethodsSupported, "S256") {
+		return fmt.Errorf("authorization server does not support S256 PKCE")
+	}
+	if !slices.Contains(m.DPoPSigningAlgValuesSupported, "ES256") {
+		return fmt.Errorf("authorization server does not support ES256 DPoP")
+	}
+	if len(m.ScopesSupported) > 0 && !slices.Contains(m.ScopesSupported, "atproto") {
+		return fmt.Errorf("authorization server does not support the atproto scope")
+	}
+	return nil
+}
+
+// Fetches the protected resource metadata of a PDS.
+func FetchResourceMetadata(ctx context.Context, client *http.Client, pdsURL string) (*ResourceMetadata, error) {
+	var m ResourceMetadata
+	if err := fetchJSON(ctx, client, strings.TrimSuffix(pdsURL, "/")+"/.well-known/oauth-protected-resource", &m); err != nil {
+		return nil, err
+	}
+	if len(m.AuthorizationServers) == 0 {
+		return nil, fmt.Errorf("resource %s lists no authorization servers", pdsURL)
+	}
+	return &m, nil
+}
+
+// Fetches and validates the metadata of an authorization server.
+func FetchAuthServerMetadata(ctx context.Context, client *http.Client, issuer string) (*AuthServerMetadata, error) {
+	var m AuthServerMetadata
+	if err := fetchJSON(ctx, client, strings.TrimSuffix(issuer, "/")+"/.well-known/oauth-authorization-server", &m); err != nil {
+		return nil, err
+	}
+	if err := m.Validate(issuer); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// Discovers and validates the authorization server responsible for a PDS.
+func DiscoverAuthServer(ctx context.Context, client *http.Client, pdsURL string) (*AuthServerMetadata, error) {
+	rm, err := FetchResourceMetadata(ctx, client, pdsURL)
+	if err != nil {
+		return nil, err
+	}
+	return FetchAuthServerMetadata(ctx, client, rm.AuthorizationServers[0])
+}
+
+func fetchJSON(ctx context.Context, client *http.Client, target string, v any) error {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("fetching %s: %w", target, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("fetching %s: unexpected HTTP status %d", target, resp.StatusCode)
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxMetadataSize)).Decode(v); err != nil {
+		return fmt.Errorf("parsing %s: %w", target, err)
+	}
+	return nil
+}
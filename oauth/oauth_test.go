@@ -0,0 +1,244 @@
+package oauth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/notjuliet/grove/crypto"
+	"github.com/notjuliet/grove/identity"
+	"github.com/notjuliet/grove/internal/jwt"
+	"github.com/notjuliet/grove/xrpc"
+)
+
+func TestDPoP(t *testing.T) {
+	key, err := crypto.GenerateP256()
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := NewDPoP(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp := &http.Response{Header: http.Header{"Dpop-Nonce": {"abc"}}}
+	d.UpdateNonce("https://pds.example/xrpc/com.atproto.server.getSession", resp)
+
+	proof, err := d.Proof(context.Background(), "GET", "https://pds.example/xrpc/app.bsky.actor.getProfile?actor=alice#x", "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tok, err := jwt.Parse(proof)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tok.Header.Typ != "dpop+jwt" {
+		t.Fatal("invalid typ")
+	}
+	raw, _ := json.Marshal(tok.Header.JWK)
+	var jwk crypto.JWK
+	if err := json.Unmarshal(raw, &jwk); err != nil {
+		t.Fatal(err)
+	}
+	if jwk.Thumbprint() != d.Thumbprint() {
+		t.Fatal("invalid jwk thumbprint")
+	}
+	pub, err := crypto.ParsePublicJWK(jwk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tok.Verify(pub); err != nil {
+		t.Fatal(err)
+	}
+
+	var claims DPoPClaims
+	if err := tok.DecodeClaims(&claims); err != nil {
+		t.Fatal(err)
+	}
+	if claims.Htu != "https://pds.example/xrpc/app.bsky.actor.getProfile" {
+		t.Fatalf("invalid htu %s", claims.Htu)
+	}
+	if claims.Nonce != "abc" {
+		t.Fatal("invalid nonce")
+	}
+	if claims.Ath != AccessTokenHash("token") {
+		t.Fatal("invalid ath")
+	}
+
+	if _, err := NewDPoP(must(crypto.GenerateK256())); err == nil {
+		t.Fatal("expected error for K-256 key")
+	}
+}
+
+func must[T any](v T, err error) T {
+	if err != nil {
+		panic(err)
+	}
+	return v
+}
+
+type fakeDirectory map[string]string
+
+func (d fakeDirectory) LookupDID(ctx context.Context, did string) (*identity.Identity, error) {
+	pds, ok := d[did]
+	if !ok {
+		return nil, identity.ErrDIDNotFound
+	}
+	return &identity.Identity{DID: did, Services: map[string]identity.Service{
+		"atproto_pds": {Type: "AtprotoPersonalDataServer", URL: pds},
+	}}, nil
+}
+
+func TestClient(t *testing.T) {
+	var srv *httptest.Server
+	sub := "did:plc:alice"
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/oauth-protected-resource", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(ResourceMetadata{Resource: srv.URL, AuthorizationServers: []string{srv.URL}})
+	})
+	mux.HandleFunc("/bob/.well-known/oauth-protected-resource", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(ResourceMetadata{Resource: srv.URL + "/bob", AuthorizationServers: []string{"https://other.example"}})
+	})
+	mux.HandleFunc("/.well-known/oauth-authorization-server", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(AuthServerMetadata{
+			Issuer:                             srv.URL,
+			AuthorizationEndpoint:              srv.URL + "/authorize",
+			TokenEndpoint:                      srv.URL + "/token",
+			PushedAuthorizationRequestEndpoint: srv.URL + "/par",
+			CodeChallengeMethodsSupported:      []string{"S256"},
+			DPoPSigningAlgValuesSupported:      []string{"ES256"},
+		})
+	})
+	mux.HandleFunc("/par", func(w http.ResponseWriter, r *http.Request) {
+		tok, err := jwt.Parse(r.Header.Get("DPoP"))
+		if err != nil {
+			t.Error(err)
+		}
+		var claims DPoPClaims
+		tok.DecodeClaims(&claims)
+		if claims.Nonce != "n1" {
+			w.Header().Set("DPoP-Nonce", "n1")
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"use_dpop_nonce"}`))
+			return
+		}
+		if r.FormValue("code_challenge_method") != "S256" || r.FormValue("login_hint") != "alice.test" {
+			t.Error("invalid PAR parameters")
+		}
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"request_uri":"urn:ietf:params:oauth:request_uri:abc","expires_in":60}`))
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("DPoP") == "" || r.FormValue("client_id") != "https://app.example/client-metadata.json" {
+			t.Error("invalid token request")
+		}
+		w.Write([]byte(`{"access_token":"at","token_type":"DPoP","refresh_token":"rt-` + r.FormValue("grant_type") + `","expires_in":3600,"scope":"atproto","sub":"` + sub + `"}`))
+	})
+	var resourceCalls int
+	mux.HandleFunc("/xrpc/com.example.ping", func(w http.ResponseWriter, r *http.Request) {
+		resourceCalls++
+		tok, err := jwt.Parse(r.Header.Get("DPoP"))
+		if err != nil {
+			t.Error(err)
+		}
+		var claims DPoPClaims
+		tok.DecodeClaims(&claims)
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "DPoP ") || claims.Ath == "" {
+			t.Error("invalid resource request")
+		}
+		// the resource server rotates its nonce on every response
+		w.Header().Set("DPoP-Nonce", fmt.Sprintf("r%d", resourceCalls))
+		if claims.Nonce != fmt.Sprintf("r%d", resourceCalls-1) {
+			w.Header().Set("WWW-Authenticate", `DPoP error="use_dpop_nonce"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"error":"use_dpop_nonce"}`))
+			return
+		}
+		w.Write([]byte(`{}`))
+	})
+	srv = httptest.NewServer(mux)
+	defer srv.Close()
+
+	ctx := context.Background()
+	dir := fakeDirectory{"did:plc:alice": srv.URL, "did:plc:bob": srv.URL + "/bob"}
+	c := NewClient(ClientConfig{
+		ClientID:    "https://app.example/client-metadata.json",
+		RedirectURI: "https://app.example/callback",
+		Dir:         dir,
+	})
+
+	authURL, req, err := c.StartAuth(ctx, srv.URL, "alice.test", "did:plc:alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(authURL, srv.URL+"/authorize?") || !strings.Contains(authURL, "request_uri=") {
+		t.Fatalf("invalid authorization URL %s", authURL)
+	}
+
+	if _, err := c.Callback(ctx, req, url.Values{"state": {"wrong"}, "code": {"c"}}); err == nil {
+		t.Fatal("expected state mismatch error")
+	}
+	s, err := c.Callback(ctx, req, url.Values{"state": {req.State}, "code": {"c"}, "iss": {srv.URL}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.DID != "did:plc:alice" || s.RefreshToken != "rt-authorization_code" || s.Expired(0) {
+		t.Fatal("invalid session")
+	}
+
+	if err := c.Refresh(ctx, s); err != nil {
+		t.Fatal(err)
+	}
+	if s.RefreshToken != "rt-refresh_token" {
+		t.Fatal("session was not refreshed")
+	}
+
+	// the first request learns the nonce of the resource server and is sent again, later ones reuse the latest
+	xc := &xrpc.Client{Host: srv.URL, Auth: s}
+	for i := range 3 {
+		if err := xc.Query(ctx, "com.example.ping", nil, nil); err != nil {
+			t.Fatalf("request %d: %v", i, err)
+		}
+	}
+	if resourceCalls != 4 {
+		t.Fatalf("expected a single retry, got %d resource calls", resourceCalls)
+	}
+
+	// refreshes do not race with requests
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		if err := c.Refresh(ctx, s); err != nil {
+			t.Error(err)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		req := httptest.NewRequest(http.MethodGet, srv.URL+"/xrpc/com.example.ping", nil)
+		if err := s.Authorize(ctx, req); err != nil {
+			t.Error(err)
+		}
+	}()
+	wg.Wait()
+
+	// the server issues a token for an account whose PDS designates another authorization server
+	sub = "did:plc:bob"
+	_, req, err = c.StartAuth(ctx, srv.URL, "alice.test", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Callback(ctx, req, url.Values{"state": {req.State}, "code": {"c"}, "iss": {srv.URL}}); err == nil {
+		t.Fatal("expected error for a non-authoritative issuer")
+	}
+	if c := NewClient(ClientConfig{}); c.cfg.Dir == nil {
+		t.Fatal("expected a default directory")
+	}
+}
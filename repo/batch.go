@@ -0,0 +1,78 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/notjuliet/grove/cid"
+	"github.com/notjuliet/grove/crypto"
+	"github.com/notjuliet/grove/limits"
+	"github.com/notjuliet/grove/syntax"
+)
+
+// Maximum number of writes in a batch accepted by ApplyBatch.
+const MaxBatchWrites = limits.MaxCommitOps
+
+var ErrInvalidWrite = errors.New("invalid write")
+
+// Writes to apply as a single commit, modeled on the input of com.atproto.repo.applyWrites, for services
+// accepting writes from clients.
+type WriteBatch struct {
+	Writes []Write
+	// CID of the commit the writes were prepared against, which must still be the latest commit when they are
+	// applied. Nil skips the check.
+	SwapCommit *cid.Cid
+}
+
+// Checks the writes without looking at the repository: the batch size, the action, collection NSID and record
+// key syntax of each write, and that records are present, fit in MaxRecordSize, and have a $type matching
+// their collection if they have one.
+func (b *WriteBatch) Validate() error {
+	if err := limits.Check(len(b.Writes), MaxBatchWrites, "writes in batch"); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidWrite, err)
+	}
+	for i, w := range b.Writes {
+		if err := w.validate(); err != nil {
+			return fmt.Errorf("%w: write %d: %w", ErrInvalidWrite, i, err)
+		}
+	}
+	return nil
+}
+
+func (w *Write) validate() error {
+	switch w.Action {
+	case ActionCreate, ActionUpdate, ActionDelete:
+	default:
+		return fmt.Errorf("unknown action %q", w.Action)
+	}
+	if err := syntax.ValidateNSID(w.Collection); err != nil {
+		return err
+	}
+	if w.RKey != "" || w.Action != ActionCreate {
+		if err := syntax.ValidateRecordKey(w.RKey); err != nil {
+			return err
+		}
+	}
+	if w.Action == ActionDelete {
+		return nil
+	}
+	if w.Record == nil {
+		return errors.New("missing record")
+	}
+	if typ, ok := w.Record["$type"]; ok && typ != w.Collection {
+		return fmt.Errorf("record $type %v does not match collection %s", typ, w.Collection)
+	}
+	_, _, err := EncodeRecordLimit(w.Record, MaxRecordSize)
+	return err
+}
+
+// Validates a batch and applies it like ApplyWrites, all or nothing. Failed swap checks return
+// ErrSwapMismatch, and invalid writes ErrInvalidWrite. The operations of the result follow the order of the
+// writes, one per write.
+func (r *Repo) ApplyBatch(ctx context.Context, batch WriteBatch, signer crypto.Signer) (*CommitResult, error) {
+	if err := batch.Validate(); err != nil {
+		return nil, err
+	}
+	return r.applyWrites(ctx, batch.Writes, batch.SwapCommit, signer)
+}
@@ -0,0 +1,278 @@
+package repo
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+
+	"github.com/notjuliet/grove/blockstore"
+	"github.com/notjuliet/grove/car"
+	"github.com/notjuliet/grove/cid"
+	"github.com/notjuliet/grove/mst"
+)
+
+// number of blocks imported per blockstore batch
+const importBatchSize = 256
+
+// Imports a repository export (getRepo output) into the blockstore and opens it. Every block must match its
+// CID, the MST must be well-formed, and every record must be present. The commit signature is not checked,
+// see VerifyCommit. Blocks are written as they are read, so a failed import may leave some in the store.
+func LoadFromCAR(ctx context.Context, r io.Reader, bs blockstore.Blockstore) (*Repo, error) {
+	return loadFromCAR(ctx, r, bs, false)
+}
+
+// Like LoadFromCAR, but additionally rejects CAR files holding blocks which are not part of the repository.
+// The blocks are staged in memory and only written to the store once the whole repository is verified, so a
+// failed import leaves the store untouched.
+func LoadFromCARStrict(ctx context.Context, r io.Reader, bs blockstore.Blockstore) (*Repo, error) {
+	return loadFromCAR(ctx, r, bs, true)
+}
+
+func loadFromCAR(ctx context.Context, r io.Reader, bs blockstore.Blockstore, strict bool) (*Repo, error) {
+	cr, err := car.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(cr.Roots) != 1 {
+		return nil, fmt.Errorf("repository CAR has %d roots, expected 1", len(cr.Roots))
+	}
+	dst := bs
+	var staged []blockstore.Block
+	if strict {
+		bs = blockstore.NewMemoryBlockstore()
+	}
+
+	imported := map[string]bool{}
+	batch := make([]blockstore.Block, 0, importBatchSize)
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		blk, err := cr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading CAR: %w", err)
+		}
+		if err := verifyBlock(blk.Cid, blk.Data); err != nil {
+			return nil, err
+		}
+		imported[string(blk.Cid.Bytes)] = true
+		if strict {
+			staged = append(staged, blockstore.Block{Cid: blk.Cid, Data: blk.Data})
+		}
+		batch = append(batch, blockstore.Block{Cid: blk.Cid, Data: blk.Data})
+		if len(batch) == importBatchSize {
+			if err := bs.PutMany(ctx, batch); err != nil {
+				return nil, err
+			}
+			batch = batch[:0]
+		}
+	}
+	if err := bs.PutMany(ctx, batch); err != nil {
+		return nil, err
+	}
+
+	repo, err := Open(ctx, bs, cr.Roots[0])
+	if err != nil {
+		return nil, err
+	}
+
+	reachable := map[string]bool{string(cr.Roots[0].Bytes): true}
+	nodes, err := repo.tree.NodeCids(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range nodes {
+		reachable[string(c.Bytes)] = true
+	}
+	err = repo.tree.ForEach(ctx, "", func(key string, val cid.Cid) error {
+		reachable[string(val.Bytes)] = true
+		ok, err := bs.Has(ctx, val)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("record %s is missing", key)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if strict {
+		for c := range reachable {
+			if !imported[c] {
+				return nil, errors.New("repository block is missing from CAR")
+			}
+		}
+		if len(imported) != len(reachable) {
+			return nil, fmt.Errorf("CAR holds %d blocks outside the repository", len(imported)-len(reachable))
+		}
+		for blocks := range slices.Chunk(staged, importBatchSize) {
+			if err := dst.PutMany(ctx, blocks); err != nil {
+				return nil, err
+			}
+		}
+		return Open(ctx, dst, cr.Roots[0])
+	}
+	return repo, nil
+}
+
+// checks that data hashes to the CID it is stored under
+func verifyBlock(c cid.Cid, data []byte) error {
+	computed, err := cid.Create(c.Codec, data)
+	if err != nil {
+		return fmt.Errorf("block %s: %w", c, err)
+	}
+	if !bytes.Equal(computed.Bytes, c.Bytes) {
+		return fmt.Errorf("block data does not match CID %s", c)
+	}
+	return nil
+}
+
+// Returns the blocks field of the firehose #commit message for a commit: a CAR rooted at the commit holding
+// every block it created, plus the covering proof of each operation so that consumers knowing only the
+// previous MST root (PrevData) can verify and invert the operations.
+func (res *CommitResult) EventBlocks(ctx context.Context) ([]byte, error) {
+	paths := make([]string, len(res.Ops))
+	for i, op := range res.Ops {
+		paths[i] = op.Path
+	}
+	proof, err := res.tree.CoveringProof(ctx, paths)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	w, err := car.NewWriter(&buf, []cid.Cid{res.Cid})
+	if err != nil {
+		return nil, err
+	}
+	w.Dedupe = true
+	for _, blocks := range [][]blockstore.Block{res.Blocks, proof} {
+		for _, blk := range blocks {
+			if err := w.Put(blk.Cid, blk.Data); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// Returned by ExportSince when the blockstore holds no commit of the repository at the since rev.
+var ErrRevNotFound = errors.New("no commit found at rev")
+
+// Writes a repository export rooted at the head commit holding only the blocks introduced after the commit made at
+// the since rev, like getRepo with since: the head commit, and the MST nodes and records added since. An empty
+// since exports the whole repository, and when the head is not newer than since, only the head commit is written.
+//
+// Commits do not link to their predecessors, so the commit at since is found by scanning the blockstore, and
+// ErrRevNotFound is returned if it is not there. The trees of both commits are then walked together, skipping
+// the subtrees they share.
+func ExportSince(ctx context.Context, bs blockstore.Blockstore, root cid.Cid, since string, w io.Writer) error {
+	b, err := bs.Get(ctx, root)
+	if err != nil {
+		return fmt.Errorf("fetching commit: %w", err)
+	}
+	head, err := decodeCommit(b, true)
+	if err != nil {
+		return err
+	}
+	var base *Commit
+	// commits without a rev predate revs, so are older than any other
+	if since != "" && since < head.Rev {
+		c, err := findCommit(ctx, bs, head.DID, since)
+		if err != nil {
+			return err
+		}
+		base = &c
+	}
+
+	cw, err := car.NewWriter(w, []cid.Cid{root})
+	if err != nil {
+		return err
+	}
+	cw.Dedupe = true
+	if err := cw.Put(root, b); err != nil {
+		return err
+	}
+	if since != "" && base == nil {
+		return nil
+	}
+	put := func(c cid.Cid) error {
+		data, err := bs.Get(ctx, c)
+		if err != nil {
+			return fmt.Errorf("fetching block %s: %w", c, err)
+		}
+		return cw.Put(c, data)
+	}
+
+	tree, err := mst.Load(ctx, bs, head.Data)
+	if err != nil {
+		return err
+	}
+	if base == nil {
+		nodes, err := tree.NodeCids(ctx)
+		if err != nil {
+			return err
+		}
+		for _, c := range nodes {
+			if err := put(c); err != nil {
+				return err
+			}
+		}
+		return tree.ForEach(ctx, "", func(key string, val cid.Cid) error {
+			return put(val)
+		})
+	}
+
+	baseTree, err := mst.Load(ctx, bs, base.Data)
+	if err != nil {
+		return err
+	}
+	changes, nodes, err := mst.DiffNodes(ctx, baseTree, tree)
+	if err != nil {
+		return err
+	}
+	for _, c := range nodes {
+		if err := put(c); err != nil {
+			return err
+		}
+	}
+	for _, ch := range changes {
+		if ch.New == nil {
+			continue
+		}
+		if err := put(*ch.New); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// returns the commit of a repository at a rev, scanning the blockstore for it
+func findCommit(ctx context.Context, bs blockstore.Blockstore, did, rev string) (Commit, error) {
+	for c, err := range bs.Keys(ctx) {
+		if err != nil {
+			return Commit{}, err
+		}
+		if c.Codec != cid.CodecCbor {
+			continue
+		}
+		b, err := bs.Get(ctx, c)
+		if err != nil {
+			return Commit{}, fmt.Errorf("fetching block %s: %w", c, err)
+		}
+		// records and MST nodes fail to decode as commits
+		if commit, err := decodeCommit(b, true); err == nil && commit.DID == did && commit.Rev == rev {
+			return commit, nil
+		}
+	}
+	return Commit{}, fmt.Errorf("%w %s of %s", ErrRevNotFound, rev, did)
+}
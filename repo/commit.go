@@ -0,0 +1,238 @@
+package repo
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/notjuliet/grove/blockstore"
+	"github.com/notjuliet/grove/cbor"
+	"github.com/notjuliet/grove/cid"
+	"github.com/notjuliet/grove/crypto"
+	"github.com/notjuliet/grove/mst"
+	"github.com/notjuliet/grove/tid"
+)
+
+// Repository format version of commits created and accepted by this package.
+const CommitVersion = 3
+
+// Previous repository format version, only accepted by the legacy variants of decoding and verification. Its
+// commits may lack a rev and link to the previous commit through prev.
+const LegacyCommitVersion = 2
+
+// Repository commit object.
+//
+// https://atproto.com/specs/repository#commit-objects
+type Commit struct {
+	// DID of the repository account.
+	DID string
+	// Repository format version.
+	Version int64
+	// Root CID of the MST holding the repository records.
+	Data cid.Cid
+	// Revision, a TID which increases with every commit.
+	Rev string
+	// Previous commit CID, if any. Always empty for new commits.
+	Prev *cid.Cid
+	// Signature over the CBOR encoding of the unsigned commit.
+	Sig []byte
+}
+
+func (c *Commit) unsignedMap() map[string]any {
+	var prev any
+	if c.Prev != nil {
+		prev = c.Prev.Link()
+	}
+	m := map[string]any{
+		"did":     c.DID,
+		"version": c.Version,
+		"data":    c.Data.Link(),
+		"rev":     c.Rev,
+		"prev":    prev,
+	}
+	if c.Version == LegacyCommitVersion && c.Rev == "" {
+		delete(m, "rev")
+	}
+	return m
+}
+
+// Returns the canonical DAG-CBOR encoding of the commit without its signature, which is the content that
+// gets signed.
+func (c *Commit) UnsignedBytes() ([]byte, error) {
+	return cbor.Encode(c.unsignedMap())
+}
+
+// Returns the canonical DAG-CBOR encoding of the signed commit.
+func (c *Commit) Bytes() ([]byte, error) {
+	if c.Sig == nil {
+		return nil, errors.New("commit is not signed")
+	}
+	m := c.unsignedMap()
+	m["sig"] = c.Sig
+	return cbor.Encode(m)
+}
+
+// Returns the CID of the signed commit block.
+func (c *Commit) Cid() (cid.Cid, error) {
+	b, err := c.Bytes()
+	if err != nil {
+		return cid.Cid{}, err
+	}
+	return cid.Create(cid.CodecCbor, b)
+}
+
+// Checks the commit fields: the DID syntax, the version, that rev is a TID, and that data points at a
+// DAG-CBOR block. The signature is not checked.
+func (c *Commit) Validate() error {
+	return c.validate(false)
+}
+
+// Like Validate, but also accepts commits of the legacy format version, which may have no rev.
+func (c *Commit) ValidateLegacy() error {
+	return c.validate(true)
+}
+
+func (c *Commit) validate(legacy bool) error {
+	if !strings.HasPrefix(c.DID, "did:") {
+		return fmt.Errorf("invalid commit DID %q", c.DID)
+	}
+	isLegacy := legacy && c.Version == LegacyCommitVersion
+	if c.Version != CommitVersion && !isLegacy {
+		return fmt.Errorf("unsupported commit version %d", c.Version)
+	}
+	// legacy commits without a rev are ordered by their prev chain
+	if !isLegacy || c.Rev != "" {
+		if err := tid.Validate(c.Rev); err != nil {
+			return fmt.Errorf("invalid commit rev: %w", err)
+		}
+	}
+	if c.Data.Codec != cid.CodecCbor {
+		return errors.New("commit data is not a DAG-CBOR CID")
+	}
+	return nil
+}
+
+// Parses a signed commit block. The block must be in canonical DAG-CBOR form, have no fields besides those
+// of a commit, and pass Validate.
+func DecodeCommit(b []byte) (Commit, error) {
+	return decodeCommit(b, false)
+}
+
+// Like DecodeCommit, but also accepts commits of the legacy format version, for reading historical archives.
+// The commit must pass ValidateLegacy.
+func DecodeCommitLegacy(b []byte) (Commit, error) {
+	return decodeCommit(b, true)
+}
+
+func decodeCommit(b []byte, legacy bool) (Commit, error) {
+	v, err := cbor.Decode(b)
+	if err != nil {
+		return Commit{}, fmt.Errorf("decoding commit: %w", err)
+	}
+	m, ok := v.(map[string]any)
+	if !ok {
+		return Commit{}, errors.New("commit is not a map")
+	}
+	for k := range m {
+		switch k {
+		case "did", "version", "data", "rev", "prev", "sig":
+		default:
+			return Commit{}, fmt.Errorf("unexpected commit field %q", k)
+		}
+	}
+
+	var c Commit
+	if c.DID, ok = m["did"].(string); !ok {
+		return Commit{}, errors.New("commit did is not a string")
+	}
+	version, ok := m["version"].(uint64)
+	if !ok || version > CommitVersion {
+		return Commit{}, fmt.Errorf("unsupported commit version %v", m["version"])
+	}
+	c.Version = int64(version)
+	link, ok := m["data"].(cid.CidLink)
+	if !ok {
+		return Commit{}, errors.New("commit data is not a link")
+	}
+	if c.Data, err = link.Cid(); err != nil {
+		return Commit{}, fmt.Errorf("invalid commit data: %w", err)
+	}
+	if rev, present := m["rev"]; present || c.Version != LegacyCommitVersion {
+		if c.Rev, ok = rev.(string); !ok {
+			return Commit{}, errors.New("commit rev is not a string")
+		}
+	}
+	switch prev := m["prev"].(type) {
+	case nil:
+	case cid.CidLink:
+		p, err := prev.Cid()
+		if err != nil {
+			return Commit{}, fmt.Errorf("invalid commit prev: %w", err)
+		}
+		c.Prev = &p
+	default:
+		return Commit{}, errors.New("commit prev is not a link")
+	}
+	if c.Sig, ok = m["sig"].([]byte); !ok {
+		return Commit{}, errors.New("commit sig is not bytes")
+	}
+
+	if err := c.validate(legacy); err != nil {
+		return Commit{}, err
+	}
+	canonical, err := c.Bytes()
+	if err != nil {
+		return Commit{}, err
+	}
+	if !bytes.Equal(canonical, b) {
+		return Commit{}, errors.New("commit is not in canonical form")
+	}
+	return c, nil
+}
+
+// Signs an unsigned commit, returning a copy with the signature set.
+func SignCommit(ctx context.Context, unsigned Commit, signer crypto.Signer) (Commit, error) {
+	b, err := unsigned.UnsignedBytes()
+	if err != nil {
+		return Commit{}, fmt.Errorf("encoding unsigned commit: %w", err)
+	}
+	sig, err := crypto.Sign(ctx, signer, b)
+	if err != nil {
+		return Commit{}, fmt.Errorf("signing commit: %w", err)
+	}
+	unsigned.Sig = sig
+	return unsigned, nil
+}
+
+// Verifies the signature of a signed commit against the account's signing key.
+func VerifyCommitSignature(signed Commit, pub crypto.PublicKey) error {
+	if signed.Sig == nil {
+		return errors.New("commit is not signed")
+	}
+	b, err := signed.UnsignedBytes()
+	if err != nil {
+		return fmt.Errorf("encoding unsigned commit: %w", err)
+	}
+	return crypto.Verify(pub, b, signed.Sig)
+}
+
+// Performs the standard trust check on a commit: its fields are valid, it is signed by the account's key,
+// and its data root is present in the blockstore as a well-formed MST node.
+func VerifyCommit(ctx context.Context, commit Commit, pub crypto.PublicKey, bs blockstore.Blockstore) error {
+	if err := commit.Validate(); err != nil {
+		return err
+	}
+	if err := VerifyCommitSignature(commit, pub); err != nil {
+		return fmt.Errorf("invalid commit signature: %w", err)
+	}
+	root, err := bs.Get(ctx, commit.Data)
+	if err != nil {
+		return fmt.Errorf("fetching commit data root: %w", err)
+	}
+	if err := mst.ValidateNode(root); err != nil {
+		return fmt.Errorf("invalid commit data root: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,39 @@
+package repo
+
+import (
+	"context"
+
+	"github.com/notjuliet/grove/blockstore"
+	"github.com/notjuliet/grove/cid"
+	"github.com/notjuliet/grove/mst"
+)
+
+// Returns the record operations turning the repository at one commit into the repository at another, sorted
+// by path. Both commits and their MSTs must be in the blockstore; to diff two getRepo exports, load both with
+// LoadFromCAR into the same blockstore first.
+func Diff(ctx context.Context, bs blockstore.Blockstore, from, to cid.Cid) ([]Op, error) {
+	_, fromTree, err := loadCommit(ctx, bs, from)
+	if err != nil {
+		return nil, err
+	}
+	_, toTree, err := loadCommit(ctx, bs, to)
+	if err != nil {
+		return nil, err
+	}
+	changes, err := mst.Diff(ctx, fromTree, toTree)
+	if err != nil {
+		return nil, err
+	}
+
+	ops := make([]Op, len(changes))
+	for i, ch := range changes {
+		op := Op{Action: ActionUpdate, Path: ch.Key, Cid: ch.New, Prev: ch.Old}
+		if ch.Old == nil {
+			op.Action = ActionCreate
+		} else if ch.New == nil {
+			op.Action = ActionDelete
+		}
+		ops[i] = op
+	}
+	return ops, nil
+}
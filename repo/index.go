@@ -0,0 +1,255 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/notjuliet/grove/blockstore"
+	"github.com/notjuliet/grove/cid"
+	"github.com/notjuliet/grove/internal/sqlname"
+)
+
+// Secondary index of record keys by collection, maintained by the repositories it is attached to with
+// Repo.SetIndex, so that listing a collection does not walk the MST. An index may be shared by several
+// repositories, whose entries are kept apart by DID. Implementations must be safe for concurrent use.
+type CollectionIndex interface {
+	// Updates the entries of a repository with the operations of a commit, all or nothing.
+	Apply(ctx context.Context, did string, ops []Op) error
+	// Returns up to limit entries of a collection with record keys greater than cursor, sorted by record key.
+	// A limit of 0 means no limit.
+	List(ctx context.Context, did, collection, cursor string, limit int) ([]IndexEntry, error)
+	// Replaces every entry of a repository with the entries created by the operations, all or nothing.
+	Replace(ctx context.Context, did string, ops []Op) error
+}
+
+// Record listed by a CollectionIndex.
+type IndexEntry struct {
+	RKey string
+	Cid  cid.Cid
+}
+
+// number of entries fetched from the index at once while listing records
+const indexPageSize = 100
+
+// Attaches a collection index, used by ListRecords and updated by every later commit. The index must already
+// reflect the latest commit, see RebuildIndex; nil detaches the current index.
+func (r *Repo) SetIndex(idx CollectionIndex) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.index = idx
+}
+
+// Replaces the entries of the repository in the attached index with the records of the latest commit, in a
+// single update so that a failure leaves the previous entries in place.
+func (r *Repo) RebuildIndex(ctx context.Context) error {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	if r.index == nil {
+		return errors.New("repository has no index")
+	}
+	var ops []Op
+	err := r.tree.ForEach(ctx, "", func(key string, val cid.Cid) error {
+		ops = append(ops, Op{Action: ActionCreate, Path: key, Cid: &val})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return r.index.Replace(ctx, r.did, ops)
+}
+
+// In-memory CollectionIndex.
+type MemoryCollectionIndex struct {
+	mtx sync.RWMutex
+	// sorted entries by DID and collection
+	entries map[[2]string][]IndexEntry
+}
+
+func NewMemoryCollectionIndex() *MemoryCollectionIndex {
+	return &MemoryCollectionIndex{entries: map[[2]string][]IndexEntry{}}
+}
+
+func (m *MemoryCollectionIndex) Apply(ctx context.Context, did string, ops []Op) error {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	m.apply(did, ops)
+	return nil
+}
+
+func (m *MemoryCollectionIndex) apply(did string, ops []Op) {
+	for _, op := range ops {
+		collection, rkey, _ := strings.Cut(op.Path, "/")
+		k := [2]string{did, collection}
+		list := m.entries[k]
+		i, found := slices.BinarySearchFunc(list, rkey, func(e IndexEntry, rkey string) int { return strings.Compare(e.RKey, rkey) })
+		switch {
+		case op.Cid == nil && found:
+			list = slices.Delete(list, i, i+1)
+		case op.Cid != nil && found:
+			list[i].Cid = *op.Cid
+		case op.Cid != nil:
+			list = slices.Insert(list, i, IndexEntry{RKey: rkey, Cid: *op.Cid})
+		}
+		if len(list) == 0 {
+			delete(m.entries, k)
+		} else {
+			m.entries[k] = list
+		}
+	}
+}
+
+func (m *MemoryCollectionIndex) List(ctx context.Context, did, collection, cursor string, limit int) ([]IndexEntry, error) {
+	m.mtx.RLock()
+	defer m.mtx.RUnlock()
+	list := m.entries[[2]string{did, collection}]
+	i, found := slices.BinarySearchFunc(list, cursor, func(e IndexEntry, rkey string) int { return strings.Compare(e.RKey, rkey) })
+	if found {
+		i++
+	}
+	list = list[i:]
+	if limit > 0 && len(list) > limit {
+		list = list[:limit]
+	}
+	return slices.Clone(list), nil
+}
+
+func (m *MemoryCollectionIndex) Replace(ctx context.Context, did string, ops []Op) error {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	for k := range m.entries {
+		if k[0] == did {
+			delete(m.entries, k)
+		}
+	}
+	m.apply(did, ops)
+	return nil
+}
+
+// CollectionIndex backed by a database/sql table, which can live in the same database as a
+// blockstore.SQLBlockstore.
+type SQLCollectionIndex struct {
+	db *sql.DB
+
+	upsert *sql.Stmt
+	del    *sql.Stmt
+	list   *sql.Stmt
+	clear  *sql.Stmt
+}
+
+// Creates the index table if it does not exist and prepares the index's statements. An empty table name
+// defaults to "records".
+func OpenSQLCollectionIndex(ctx context.Context, db *sql.DB, dialect blockstore.SQLDialect, table string) (*SQLCollectionIndex, error) {
+	if table == "" {
+		table = "records"
+	}
+	if err := sqlname.CheckTable(table); err != nil {
+		return nil, err
+	}
+	s := &SQLCollectionIndex{db: db}
+
+	schema := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (did TEXT NOT NULL, collection TEXT NOT NULL, rkey TEXT NOT NULL, "+
+		"cid %s NOT NULL, PRIMARY KEY (did, collection, rkey))", table, dialect.BlobType())
+	if _, err := db.ExecContext(ctx, schema); err != nil {
+		return nil, fmt.Errorf("creating index table: %w", err)
+	}
+
+	p := dialect.Placeholder
+	queries := []struct {
+		stmt  **sql.Stmt
+		query string
+	}{
+		{&s.upsert, fmt.Sprintf("INSERT INTO %s (did, collection, rkey, cid) VALUES (%s, %s, %s, %s) "+
+			"ON CONFLICT (did, collection, rkey) DO UPDATE SET cid = excluded.cid", table, p(1), p(2), p(3), p(4))},
+		{&s.del, fmt.Sprintf("DELETE FROM %s WHERE did = %s AND collection = %s AND rkey = %s", table, p(1), p(2), p(3))},
+		{&s.list, fmt.Sprintf("SELECT rkey, cid FROM %s WHERE did = %s AND collection = %s AND rkey > %s ORDER BY rkey LIMIT %s",
+			table, p(1), p(2), p(3), p(4))},
+		{&s.clear, fmt.Sprintf("DELETE FROM %s WHERE did = %s", table, p(1))},
+	}
+	for _, q := range queries {
+		stmt, err := db.PrepareContext(ctx, q.query)
+		if err != nil {
+			s.Close()
+			return nil, fmt.Errorf("preparing statement: %w", err)
+		}
+		*q.stmt = stmt
+	}
+	return s, nil
+}
+
+// Closes the prepared statements. The database handle is left open.
+func (s *SQLCollectionIndex) Close() error {
+	var errs []error
+	for _, stmt := range []*sql.Stmt{s.upsert, s.del, s.list, s.clear} {
+		if stmt != nil {
+			errs = append(errs, stmt.Close())
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Applies the operations in a single transaction.
+func (s *SQLCollectionIndex) Apply(ctx context.Context, did string, ops []Op) error {
+	return s.update(ctx, did, false, ops)
+}
+
+func (s *SQLCollectionIndex) List(ctx context.Context, did, collection, cursor string, limit int) ([]IndexEntry, error) {
+	// LIMIT -1 is not portable, and no collection holds this many records
+	if limit <= 0 {
+		limit = 1 << 40
+	}
+	rows, err := s.list.QueryContext(ctx, did, collection, cursor, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var entries []IndexEntry
+	for rows.Next() {
+		var e IndexEntry
+		var raw []byte
+		if err := rows.Scan(&e.RKey, &raw); err != nil {
+			return nil, err
+		}
+		if e.Cid, err = cid.FromBytes(append([]byte{0}, raw...)); err != nil {
+			return nil, fmt.Errorf("invalid stored CID: %w", err)
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// Deletes the entries of the repository and applies the operations in a single transaction.
+func (s *SQLCollectionIndex) Replace(ctx context.Context, did string, ops []Op) error {
+	return s.update(ctx, did, true, ops)
+}
+
+func (s *SQLCollectionIndex) update(ctx context.Context, did string, clear bool, ops []Op) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if clear {
+		if _, err := tx.StmtContext(ctx, s.clear).ExecContext(ctx, did); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	upsert, del := tx.StmtContext(ctx, s.upsert), tx.StmtContext(ctx, s.del)
+	for _, op := range ops {
+		collection, rkey, _ := strings.Cut(op.Path, "/")
+		if op.Cid == nil {
+			_, err = del.ExecContext(ctx, did, collection, rkey)
+		} else {
+			_, err = upsert.ExecContext(ctx, did, collection, rkey, op.Cid.Bytes)
+		}
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,138 @@
+package repo
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/notjuliet/grove/blockstore"
+	"github.com/notjuliet/grove/car"
+	"github.com/notjuliet/grove/cid"
+	"github.com/notjuliet/grove/crypto"
+	"github.com/notjuliet/grove/mst"
+)
+
+// Record whose inclusion in a signed commit was proven by VerifyRecordProof.
+type VerifiedRecord struct {
+	Commit    Commit
+	CommitCid cid.Cid
+	Cid       cid.Cid
+	Value     map[string]any
+}
+
+// Returns a CAR proving the presence or absence of a record as of the latest commit, as served by
+// com.atproto.sync.getRecord: the commit, the MST nodes on the record's search path, and the record itself.
+func (r *Repo) ProveRecord(ctx context.Context, collection, rkey string) ([]byte, error) {
+	r.mtx.RLock()
+	tree, head := r.tree, r.head
+	r.mtx.RUnlock()
+	if head == nil {
+		return nil, errors.New("repository has no commit")
+	}
+
+	path := collection + "/" + rkey
+	blocks, err := tree.Prove(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	val, ok, err := tree.Get(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	w, err := car.NewWriter(&buf, []cid.Cid{*head})
+	if err != nil {
+		return nil, err
+	}
+	commit, err := r.bs.Get(ctx, *head)
+	if err != nil {
+		return nil, fmt.Errorf("fetching commit: %w", err)
+	}
+	if err := w.Put(*head, commit); err != nil {
+		return nil, err
+	}
+	for _, blk := range blocks {
+		if err := w.Put(blk.Cid, blk.Data); err != nil {
+			return nil, err
+		}
+	}
+	if ok {
+		record, err := r.bs.Get(ctx, val)
+		if err != nil {
+			return nil, fmt.Errorf("fetching record %s: %w", val, err)
+		}
+		if err := w.Put(val, record); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// Checks a com.atproto.sync.getRecord response: the CAR root must be a valid commit of the account signed by
+// pub, and its blocks must prove the record from the commit's data root down to the record block. Returns
+// ErrRecordNotFound if the proof shows that the record does not exist.
+func VerifyRecordProof(r io.Reader, did, collection, rkey string, pub crypto.PublicKey) (*VerifiedRecord, error) {
+	cr, err := car.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(cr.Roots) != 1 {
+		return nil, fmt.Errorf("record proof has %d roots, expected 1", len(cr.Roots))
+	}
+	head := cr.Roots[0]
+
+	byCid := map[string][]byte{}
+	var blocks []blockstore.Block
+	for {
+		blk, err := cr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading CAR: %w", err)
+		}
+		if err := verifyBlock(blk.Cid, blk.Data); err != nil {
+			return nil, err
+		}
+		byCid[string(blk.Cid.Bytes)] = blk.Data
+		blocks = append(blocks, blockstore.Block{Cid: blk.Cid, Data: blk.Data})
+	}
+
+	b, ok := byCid[string(head.Bytes)]
+	if !ok {
+		return nil, errors.New("record proof is missing the commit")
+	}
+	commit, err := DecodeCommit(b)
+	if err != nil {
+		return nil, err
+	}
+	if commit.DID != did {
+		return nil, fmt.Errorf("commit is for %s, expected %s", commit.DID, did)
+	}
+	if err := commit.Validate(); err != nil {
+		return nil, err
+	}
+	if err := VerifyCommitSignature(commit, pub); err != nil {
+		return nil, fmt.Errorf("invalid commit signature: %w", err)
+	}
+
+	path := collection + "/" + rkey
+	val, found, err := mst.VerifyProof(commit.Data, path, blocks)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, fmt.Errorf("%w: %s", ErrRecordNotFound, path)
+	}
+	data, ok := byCid[string(val.Bytes)]
+	if !ok {
+		return nil, fmt.Errorf("record proof is missing record block %s", val)
+	}
+	m, err := DecodeRecord(val, data)
+	if err != nil {
+		return nil, err
+	}
+	return &VerifiedRecord{Commit: commit, CommitCid: head, Cid: val, Value: m}, nil
+}
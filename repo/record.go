@@ -0,0 +1,54 @@
+package repo
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/notjuliet/grove/cbor"
+	"github.com/notjuliet/grove/cid"
+	"github.com/notjuliet/grove/limits"
+)
+
+// Maximum size of an encoded record accepted by ApplyWrites.
+const MaxRecordSize = limits.MaxRecordSize
+
+var ErrRecordTooLarge = errors.New("record is too large")
+
+// Encodes a record as canonical DAG-CBOR, returning its CID and bytes. The record must be a map.
+func EncodeRecord(v any) (cid.Cid, []byte, error) {
+	return EncodeRecordLimit(v, 0)
+}
+
+// Like EncodeRecord, but fails with ErrRecordTooLarge, which also matches limits.ErrExceeded, if the encoding
+// exceeds limit bytes. A limit of 0 means no limit.
+func EncodeRecordLimit(v any, limit int) (cid.Cid, []byte, error) {
+	if _, ok := v.(map[string]any); !ok {
+		return cid.Cid{}, nil, errors.New("record is not a map")
+	}
+	b, err := cbor.Encode(v)
+	if err != nil {
+		return cid.Cid{}, nil, err
+	}
+	if err := limits.Check(len(b), limit, "bytes"); err != nil {
+		return cid.Cid{}, nil, fmt.Errorf("%w: %w", ErrRecordTooLarge, err)
+	}
+	c, err := cid.Create(cid.CodecCbor, b)
+	if err != nil {
+		return cid.Cid{}, nil, err
+	}
+	return c, b, nil
+}
+
+// Decodes the block of the record with CID c, which must be a DAG-CBOR map. The block is not checked against
+// the CID, which only names the record in errors.
+func DecodeRecord(c cid.Cid, b []byte) (map[string]any, error) {
+	v, err := cbor.Decode(b)
+	if err != nil {
+		return nil, fmt.Errorf("decoding record %s: %w", c, err)
+	}
+	m, ok := v.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("record %s is not a map", c)
+	}
+	return m, nil
+}
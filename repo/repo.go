@@ -0,0 +1,402 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"iter"
+	"strings"
+	"sync"
+
+	"github.com/notjuliet/grove/blockstore"
+	"github.com/notjuliet/grove/cid"
+	"github.com/notjuliet/grove/crypto"
+	"github.com/notjuliet/grove/mst"
+	"github.com/notjuliet/grove/tid"
+)
+
+var (
+	ErrRecordExists   = errors.New("record already exists")
+	ErrRecordNotFound = errors.New("record not found")
+	ErrSwapMismatch   = errors.New("swap CID does not match")
+)
+
+// Kind of record change, using the names of firehose commit operations.
+type Action string
+
+const (
+	ActionCreate Action = "create"
+	ActionUpdate Action = "update"
+	ActionDelete Action = "delete"
+)
+
+// Record change requested from ApplyWrites.
+type Write struct {
+	Action     Action
+	Collection string
+	// Record key. Creates with an empty key get a fresh TID.
+	RKey string
+	// Record value, ignored for deletes.
+	Record map[string]any
+	// CID the record is expected to have before the write, which fails with ErrSwapMismatch otherwise. Nil
+	// skips the check.
+	SwapRecord *cid.Cid
+}
+
+// Record change made by a commit.
+type Op struct {
+	Action Action
+	// Repository path of the record, "collection/rkey".
+	Path string
+	// CID of the new record, nil for deletes.
+	Cid *cid.Cid
+	// CID of the replaced or deleted record, nil for creates.
+	Prev *cid.Cid
+}
+
+// Outcome of a commit created by ApplyWrites.
+type CommitResult struct {
+	// Signed commit, holding the new MST root and rev.
+	Commit Commit
+	// CID of the commit block.
+	Cid cid.Cid
+	// MST root before the commit, nil for the first commit of a repository.
+	PrevData *cid.Cid
+	Ops      []Op
+	// Every block created by the commit: records, MST nodes, and the commit itself.
+	Blocks []blockstore.Block
+
+	// tree as of the commit, for covering proofs
+	tree *mst.Tree
+}
+
+// Repository of a single account, stored in a blockstore.
+type Repo struct {
+	did   string
+	bs    blockstore.Blockstore
+	clock tid.Clock
+
+	mtx    sync.RWMutex
+	tree   *mst.Tree
+	head   *cid.Cid
+	commit *Commit
+	index  CollectionIndex
+}
+
+// Creates an empty repository, without any commit until the first write.
+func New(did string, bs blockstore.Blockstore) *Repo {
+	return &Repo{did: did, bs: bs, clock: tid.NewClock(0), tree: mst.New(bs)}
+}
+
+// Opens the repository whose latest commit is head.
+func Open(ctx context.Context, bs blockstore.Blockstore, head cid.Cid) (*Repo, error) {
+	commit, tree, err := loadCommit(ctx, bs, head)
+	if err != nil {
+		return nil, err
+	}
+	return &Repo{did: commit.DID, bs: bs, clock: tid.NewClock(0), tree: tree, head: &head, commit: &commit}, nil
+}
+
+// fetches a commit and loads its MST
+func loadCommit(ctx context.Context, bs blockstore.Blockstore, head cid.Cid) (Commit, *mst.Tree, error) {
+	b, err := bs.Get(ctx, head)
+	if err != nil {
+		return Commit{}, nil, fmt.Errorf("fetching commit: %w", err)
+	}
+	commit, err := DecodeCommit(b)
+	if err != nil {
+		return Commit{}, nil, err
+	}
+	tree, err := mst.Load(ctx, bs, commit.Data)
+	if err != nil {
+		return Commit{}, nil, err
+	}
+	return commit, tree, nil
+}
+
+func (r *Repo) DID() string {
+	return r.did
+}
+
+// Returns the CID and contents of the latest commit, or false if nothing was committed yet.
+func (r *Repo) Head() (cid.Cid, Commit, bool) {
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+	if r.head == nil {
+		return cid.Cid{}, Commit{}, false
+	}
+	return *r.head, *r.commit, true
+}
+
+// Returns the CID and decoded value of a record, or ErrRecordNotFound.
+func (r *Repo) GetRecord(ctx context.Context, collection, rkey string) (cid.Cid, map[string]any, error) {
+	r.mtx.RLock()
+	tree := r.tree
+	r.mtx.RUnlock()
+
+	c, ok, err := tree.Get(ctx, collection+"/"+rkey)
+	if err != nil {
+		return cid.Cid{}, nil, err
+	}
+	if !ok {
+		return cid.Cid{}, nil, ErrRecordNotFound
+	}
+	m, err := r.readRecord(ctx, c)
+	if err != nil {
+		return cid.Cid{}, nil, err
+	}
+	return c, m, nil
+}
+
+func (r *Repo) readRecord(ctx context.Context, c cid.Cid) (map[string]any, error) {
+	b, err := r.bs.Get(ctx, c)
+	if err != nil {
+		return nil, fmt.Errorf("fetching record %s: %w", c, err)
+	}
+	return DecodeRecord(c, b)
+}
+
+// Record returned by ListRecords.
+type Record struct {
+	RKey  string
+	Cid   cid.Cid
+	Value map[string]any
+}
+
+type ListOptions struct {
+	// Only list records with a key greater than this one.
+	Cursor string
+	// Maximum number of records to list, 0 for no limit.
+	Limit int
+}
+
+// stops a tree walk once the caller has seen enough
+var errStopWalk = errors.New("stop walk")
+
+// Iterates over the records of a collection in key order, as of the latest commit when iteration starts.
+// Iteration stops after the first non-nil error.
+//
+// With a collection index attached, records are listed from the index a page at a time, so later pages may
+// reflect commits made during iteration.
+func (r *Repo) ListRecords(ctx context.Context, collection string, opts ListOptions) iter.Seq2[Record, error] {
+	return func(yield func(Record, error) bool) {
+		r.mtx.RLock()
+		tree, idx := r.tree, r.index
+		r.mtx.RUnlock()
+		if idx != nil {
+			r.listIndexed(ctx, idx, collection, opts, yield)
+			return
+		}
+
+		prefix := collection + "/"
+		count := 0
+		err := tree.ForEach(ctx, prefix+opts.Cursor, func(key string, val cid.Cid) error {
+			rkey, ok := strings.CutPrefix(key, prefix)
+			if !ok || (opts.Limit > 0 && count >= opts.Limit) {
+				return errStopWalk
+			}
+			if rkey == opts.Cursor {
+				return nil
+			}
+			value, err := r.readRecord(ctx, val)
+			if err != nil {
+				return err
+			}
+			count++
+			if !yield(Record{RKey: rkey, Cid: val, Value: value}, nil) {
+				return errStopWalk
+			}
+			return nil
+		})
+		if err != nil && err != errStopWalk {
+			yield(Record{}, err)
+		}
+	}
+}
+
+// lists records through the collection index, a page at a time
+func (r *Repo) listIndexed(ctx context.Context, idx CollectionIndex, collection string, opts ListOptions, yield func(Record, error) bool) {
+	cursor, count := opts.Cursor, 0
+	for opts.Limit <= 0 || count < opts.Limit {
+		n := indexPageSize
+		if opts.Limit > 0 {
+			n = min(n, opts.Limit-count)
+		}
+		entries, err := idx.List(ctx, r.did, collection, cursor, n)
+		if err != nil {
+			yield(Record{}, err)
+			return
+		}
+		for _, e := range entries {
+			value, err := r.readRecord(ctx, e.Cid)
+			if err != nil {
+				yield(Record{}, err)
+				return
+			}
+			if !yield(Record{RKey: e.RKey, Cid: e.Cid, Value: value}, nil) {
+				return
+			}
+		}
+		count += len(entries)
+		if len(entries) < n {
+			return
+		}
+		cursor = entries[len(entries)-1].RKey
+	}
+}
+
+// Creates a record, failing with ErrRecordExists if the key is taken. An empty rkey gets a fresh TID.
+func (r *Repo) CreateRecord(ctx context.Context, collection, rkey string, record map[string]any, signer crypto.Signer) (*CommitResult, error) {
+	return r.ApplyWrites(ctx, []Write{{Action: ActionCreate, Collection: collection, RKey: rkey, Record: record}}, signer)
+}
+
+// Creates or replaces a record.
+func (r *Repo) PutRecord(ctx context.Context, collection, rkey string, record map[string]any, signer crypto.Signer) (*CommitResult, error) {
+	return r.ApplyWrites(ctx, []Write{{Action: ActionUpdate, Collection: collection, RKey: rkey, Record: record}}, signer)
+}
+
+// Deletes a record, failing with ErrRecordNotFound if it does not exist.
+func (r *Repo) DeleteRecord(ctx context.Context, collection, rkey string, signer crypto.Signer) (*CommitResult, error) {
+	return r.ApplyWrites(ctx, []Write{{Action: ActionDelete, Collection: collection, RKey: rkey}}, signer)
+}
+
+// Applies writes as a single signed commit. Writes are staged against a copy of the tree, so the repository
+// is left untouched if any of them fails. Updates of records which do not exist are reported as creates.
+//
+// Blocks are written to the blockstore before the commit is signed; if signing fails they are left behind as
+// garbage. The attached index is updated before the commit block is stored, and reverted if storing it fails,
+// so that the repository only advances along with its index. If the revert fails too, the index must be
+// rebuilt with RebuildIndex.
+func (r *Repo) ApplyWrites(ctx context.Context, writes []Write, signer crypto.Signer) (*CommitResult, error) {
+	return r.applyWrites(ctx, writes, nil, signer)
+}
+
+func (r *Repo) applyWrites(ctx context.Context, writes []Write, swapCommit *cid.Cid, signer crypto.Signer) (*CommitResult, error) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	if swapCommit != nil && (r.head == nil || r.head.String() != swapCommit.String()) {
+		return nil, fmt.Errorf("%w: commit is %v, expected %s", ErrSwapMismatch, r.head, swapCommit)
+	}
+	tree := r.tree.Copy()
+	res := &CommitResult{}
+	var records []blockstore.Block
+	for _, w := range writes {
+		rkey := w.RKey
+		if rkey == "" && w.Action == ActionCreate {
+			rkey = r.clock.Now()
+		}
+		path := w.Collection + "/" + rkey
+		prev, exists, err := tree.Get(ctx, path)
+		if err != nil {
+			return nil, err
+		}
+
+		if w.SwapRecord != nil && (!exists || prev.String() != w.SwapRecord.String()) {
+			return nil, fmt.Errorf("%w: record %s", ErrSwapMismatch, path)
+		}
+
+		op := Op{Action: w.Action, Path: path}
+		if exists {
+			op.Prev = &prev
+		}
+		switch w.Action {
+		case ActionCreate, ActionUpdate:
+			if w.Action == ActionCreate && exists {
+				return nil, fmt.Errorf("%w: %s", ErrRecordExists, path)
+			}
+			if !exists {
+				op.Action = ActionCreate
+			}
+			c, b, err := EncodeRecordLimit(w.Record, MaxRecordSize)
+			if err != nil {
+				return nil, fmt.Errorf("encoding record %s: %w", path, err)
+			}
+			if err := tree.Insert(ctx, path, c); err != nil {
+				return nil, err
+			}
+			op.Cid = &c
+			records = append(records, blockstore.Block{Cid: c, Data: b})
+		case ActionDelete:
+			if !exists {
+				return nil, fmt.Errorf("%w: %s", ErrRecordNotFound, path)
+			}
+			if err := tree.Delete(ctx, path); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, fmt.Errorf("unknown write action %q", w.Action)
+		}
+		res.Ops = append(res.Ops, op)
+	}
+
+	if err := r.bs.PutMany(ctx, records); err != nil {
+		return nil, err
+	}
+	root, nodes, err := tree.Write(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	unsigned := Commit{DID: r.did, Version: CommitVersion, Data: root, Rev: r.nextRev()}
+	commit, err := SignCommit(ctx, unsigned, signer)
+	if err != nil {
+		return nil, err
+	}
+	b, err := commit.Bytes()
+	if err != nil {
+		return nil, err
+	}
+	head, err := cid.Create(cid.CodecCbor, b)
+	if err != nil {
+		return nil, err
+	}
+	if r.index != nil {
+		if err := r.index.Apply(ctx, r.did, res.Ops); err != nil {
+			return nil, fmt.Errorf("updating collection index: %w", err)
+		}
+	}
+	if err := r.bs.Put(ctx, head, b); err != nil {
+		if r.index != nil {
+			if rerr := r.index.Apply(ctx, r.did, invertOps(res.Ops)); rerr != nil {
+				err = errors.Join(err, fmt.Errorf("reverting collection index: %w", rerr))
+			}
+		}
+		return nil, err
+	}
+	if r.commit != nil {
+		res.PrevData = &r.commit.Data
+	}
+	res.Commit, res.Cid, res.tree = commit, head, tree
+	res.Blocks = append(append(records, nodes...), blockstore.Block{Cid: head, Data: b})
+	r.tree, r.head, r.commit = tree, &head, &commit
+	return res, nil
+}
+
+// returns the operations undoing ops, in reverse order
+func invertOps(ops []Op) []Op {
+	inv := make([]Op, len(ops))
+	for i, op := range ops {
+		op.Cid, op.Prev = op.Prev, op.Cid
+		switch {
+		case op.Cid == nil:
+			op.Action = ActionDelete
+		case op.Prev == nil:
+			op.Action = ActionCreate
+		default:
+			op.Action = ActionUpdate
+		}
+		inv[len(ops)-1-i] = op
+	}
+	return inv
+}
+
+// returns a TID for a new commit, strictly greater than the current rev even if the clock is behind it
+func (r *Repo) nextRev() string {
+	rev := r.clock.Now()
+	if r.commit == nil || rev > r.commit.Rev {
+		return rev
+	}
+	ts, clockID, _ := tid.Parse(r.commit.Rev)
+	return tid.Create(int64(ts)+1, clockID)
+}
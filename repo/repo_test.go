@@ -0,0 +1,1376 @@
+package repo
+
+import (
+	"bytes"
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/notjuliet/grove/blockstore"
+	"github.com/notjuliet/grove/car"
+	"github.com/notjuliet/grove/cbor"
+	"github.com/notjuliet/grove/cid"
+	"github.com/notjuliet/grove/crypto"
+	"github.com/notjuliet/grove/internal/sqltest"
+	"github.com/notjuliet/grove/limits"
+	"github.com/notjuliet/grove/mst"
+	"github.com/notjuliet/grove/tid"
+)
+
+func TestSignCommit(t *testing.T) {
+	key, err := crypto.GenerateK256()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := cid.Create(cid.CodecCbor, []byte("data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	unsigned := Commit{DID: "did:plc:alice", Version: 3, Data: data, Rev: "3jzfcijpj2z2a"}
+
+	signed, err := SignCommit(context.Background(), unsigned, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if unsigned.Sig != nil {
+		t.Fatal("unsigned commit was modified")
+	}
+	if err := VerifyCommitSignature(signed, key.PublicKey()); err != nil {
+		t.Fatal(err)
+	}
+
+	signed.Rev = "3jzfcijpj2z2b"
+	if err := VerifyCommitSignature(signed, key.PublicKey()); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestDecodeCommit(t *testing.T) {
+	key, err := crypto.GenerateK256()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := cid.Create(cid.CodecCbor, []byte("data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed, err := SignCommit(context.Background(), Commit{DID: "did:plc:alice", Version: 3, Data: data, Rev: "3jzfcijpj2z2a"}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := signed.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoded, err := DecodeCommit(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, signed) {
+		t.Fatal("decoded commit does not match")
+	}
+	c, err := decoded.Cid()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected, _ := cid.Create(cid.CodecCbor, b); c.String() != expected.String() {
+		t.Fatal("invalid commit CID")
+	}
+
+	invalid := map[string]func(m map[string]any){
+		"bad rev":     func(m map[string]any) { m["rev"] = "not-a-tid" },
+		"old version": func(m map[string]any) { m["version"] = uint64(2) },
+		"bad did":     func(m map[string]any) { m["did"] = "alice" },
+		"extra field": func(m map[string]any) { m["extra"] = true },
+		"no sig":      func(m map[string]any) { delete(m, "sig") },
+	}
+	for name, mutate := range invalid {
+		m := signed.unsignedMap()
+		m["sig"] = signed.Sig
+		mutate(m)
+		b, err := cbor.Encode(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := DecodeCommit(b); err == nil {
+			t.Fatalf("expected error for %s", name)
+		}
+	}
+}
+
+func TestVerifyCommit(t *testing.T) {
+	ctx := context.Background()
+	key, err := crypto.GenerateP256()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bs := blockstore.NewMemoryBlockstore()
+	tree := mst.New(bs)
+	record, _ := cid.Create(cid.CodecCbor, []byte("record"))
+	if err := tree.Insert(ctx, "app.bsky.feed.post/3jzfcijpj2z2a", record); err != nil {
+		t.Fatal(err)
+	}
+	root, _, err := tree.Write(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signed, err := SignCommit(ctx, Commit{DID: "did:plc:alice", Version: 3, Data: root, Rev: "3jzfcijpj2z2a"}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyCommit(ctx, signed, key.PublicKey(), bs); err != nil {
+		t.Fatal(err)
+	}
+
+	other, _ := crypto.GenerateP256()
+	if err := VerifyCommit(ctx, signed, other.PublicKey(), bs); err == nil {
+		t.Fatal("expected signature error")
+	}
+	if err := VerifyCommit(ctx, signed, key.PublicKey(), blockstore.NewMemoryBlockstore()); !errors.Is(err, blockstore.ErrNotFound) {
+		t.Fatal("expected missing data root error")
+	}
+
+	// a data root which is not an MST node
+	bad, err := SignCommit(ctx, Commit{DID: "did:plc:alice", Version: 3, Data: record, Rev: "3jzfcijpj2z2a"}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bs.Put(ctx, record, []byte{0xa0}); err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyCommit(ctx, bad, key.PublicKey(), bs); err == nil {
+		t.Fatal("expected invalid data root error")
+	}
+}
+
+func TestApplyWrites(t *testing.T) {
+	ctx := context.Background()
+	key, err := crypto.GenerateK256()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bs := blockstore.NewMemoryBlockstore()
+	r := New("did:plc:alice", bs)
+	post := func(text string) map[string]any {
+		return map[string]any{"$type": "app.bsky.feed.post", "text": text, "createdAt": "2024-01-01T00:00:00Z"}
+	}
+
+	first, err := r.CreateRecord(ctx, "app.bsky.feed.post", "", post("hello"), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.PrevData != nil || len(first.Ops) != 1 || first.Ops[0].Action != ActionCreate {
+		t.Fatal("invalid first commit")
+	}
+	if err := VerifyCommit(ctx, first.Commit, key.PublicKey(), bs); err != nil {
+		t.Fatal(err)
+	}
+	rkey := strings.TrimPrefix(first.Ops[0].Path, "app.bsky.feed.post/")
+	if err := tid.Validate(rkey); err != nil {
+		t.Fatal("expected TID record key")
+	}
+
+	if _, err := r.CreateRecord(ctx, "app.bsky.feed.post", rkey, post("again"), key); !errors.Is(err, ErrRecordExists) {
+		t.Fatal("expected record exists error")
+	}
+	if _, err := r.DeleteRecord(ctx, "app.bsky.feed.post", "missing", key); !errors.Is(err, ErrRecordNotFound) {
+		t.Fatal("expected record not found error")
+	}
+
+	second, err := r.ApplyWrites(ctx, []Write{
+		{Action: ActionUpdate, Collection: "app.bsky.feed.post", RKey: rkey, Record: post("edited")},
+		{Action: ActionUpdate, Collection: "app.bsky.actor.profile", RKey: "self", Record: map[string]any{"displayName": "Alice"}},
+	}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second.Commit.Rev <= first.Commit.Rev {
+		t.Fatal("rev did not increase")
+	}
+	if second.PrevData == nil || second.PrevData.String() != first.Commit.Data.String() {
+		t.Fatal("invalid prev data")
+	}
+	if second.Ops[0].Action != ActionUpdate || second.Ops[0].Prev == nil || second.Ops[1].Action != ActionCreate {
+		t.Fatal("invalid ops")
+	}
+	for _, blk := range second.Blocks {
+		if ok, _ := bs.Has(ctx, blk.Cid); !ok {
+			t.Fatal("new block was not stored")
+		}
+	}
+
+	_, record, err := r.GetRecord(ctx, "app.bsky.feed.post", rkey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record["text"] != "edited" {
+		t.Fatal("record was not updated")
+	}
+
+	t.Run("stats", func(t *testing.T) {
+		stats, err := r.Stats(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(stats.Collections, map[string]int{"app.bsky.feed.post": 1, "app.bsky.actor.profile": 1}) {
+			t.Fatalf("unexpected collections %v", stats.Collections)
+		}
+		if stats.Tree.Leaves != 2 || stats.Tree.Nodes == 0 || stats.Tree.Bytes == 0 {
+			t.Fatalf("unexpected tree stats %+v", stats.Tree)
+		}
+		if _, err := New("did:plc:bob", bs).Stats(ctx); err == nil {
+			t.Fatal("expected error for a repository without commit")
+		}
+	})
+
+	t.Run("open", func(t *testing.T) {
+		opened, err := Open(ctx, bs, second.Cid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := opened.GetRecord(ctx, "app.bsky.actor.profile", "self"); err != nil {
+			t.Fatal(err)
+		}
+		third, err := opened.DeleteRecord(ctx, "app.bsky.feed.post", rkey, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if third.Commit.Rev <= second.Commit.Rev || third.Ops[0].Prev == nil {
+			t.Fatal("invalid delete commit")
+		}
+	})
+}
+
+func TestApplyBatch(t *testing.T) {
+	ctx := context.Background()
+	key, err := crypto.GenerateK256()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := New("did:plc:alice", blockstore.NewMemoryBlockstore())
+	post := map[string]any{"$type": "app.bsky.feed.post", "text": "hello"}
+	first, err := r.ApplyBatch(ctx, WriteBatch{Writes: []Write{
+		{Action: ActionCreate, Collection: "app.bsky.feed.post", RKey: "a", Record: post},
+		{Action: ActionCreate, Collection: "app.bsky.feed.post", Record: post},
+	}}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(first.Ops) != 2 || first.Ops[0].Path != "app.bsky.feed.post/a" {
+		t.Fatalf("unexpected ops %+v", first.Ops)
+	}
+
+	invalid := map[string]Write{
+		"bad collection": {Action: ActionCreate, Collection: "post", Record: post},
+		"bad rkey":       {Action: ActionUpdate, Collection: "app.bsky.feed.post", RKey: "..", Record: post},
+		"no rkey":        {Action: ActionDelete, Collection: "app.bsky.feed.post"},
+		"no record":      {Action: ActionCreate, Collection: "app.bsky.feed.post"},
+		"wrong type":     {Action: ActionCreate, Collection: "app.bsky.feed.like", Record: post},
+		"too large":      {Action: ActionCreate, Collection: "app.bsky.feed.post", Record: map[string]any{"text": strings.Repeat("a", MaxRecordSize)}},
+		"bad action":     {Action: "upsert", Collection: "app.bsky.feed.post", RKey: "a"},
+	}
+	for name, w := range invalid {
+		if _, err := r.ApplyBatch(ctx, WriteBatch{Writes: []Write{w}}, key); !errors.Is(err, ErrInvalidWrite) {
+			t.Fatalf("expected invalid write error for %s, got %v", name, err)
+		}
+	}
+	if _, err := r.ApplyBatch(ctx, WriteBatch{Writes: make([]Write, MaxBatchWrites+1)}, key); !errors.Is(err, ErrInvalidWrite) {
+		t.Fatal("expected batch size error")
+	}
+
+	stale := first.Ops[1].Cid
+	second, err := r.ApplyBatch(ctx, WriteBatch{SwapCommit: &first.Cid, Writes: []Write{
+		{Action: ActionUpdate, Collection: "app.bsky.feed.post", RKey: "a", Record: map[string]any{"text": "edited"}, SwapRecord: first.Ops[0].Cid},
+	}}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.ApplyBatch(ctx, WriteBatch{SwapCommit: &first.Cid, Writes: []Write{
+		{Action: ActionDelete, Collection: "app.bsky.feed.post", RKey: "a"},
+	}}, key); !errors.Is(err, ErrSwapMismatch) {
+		t.Fatal("expected commit swap error")
+	}
+	if _, err := r.ApplyBatch(ctx, WriteBatch{SwapCommit: &second.Cid, Writes: []Write{
+		{Action: ActionDelete, Collection: "app.bsky.feed.post", RKey: "a", SwapRecord: stale},
+	}}, key); !errors.Is(err, ErrSwapMismatch) {
+		t.Fatal("expected record swap error")
+	}
+	if head, _, _ := r.Head(); head.String() != second.Cid.String() {
+		t.Fatal("failed batch changed the repository")
+	}
+}
+
+func TestListRecords(t *testing.T) {
+	ctx := context.Background()
+	key, err := crypto.GenerateK256()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := New("did:plc:alice", blockstore.NewMemoryBlockstore())
+	var writes []Write
+	for _, rkey := range []string{"a", "b", "c", "d", "e"} {
+		writes = append(writes, Write{Action: ActionCreate, Collection: "app.bsky.feed.like", RKey: rkey, Record: map[string]any{"n": rkey}})
+	}
+	writes = append(writes,
+		Write{Action: ActionCreate, Collection: "app.bsky.feed.post", RKey: "a", Record: map[string]any{}},
+		Write{Action: ActionCreate, Collection: "app.bsky.actor.profile", RKey: "self", Record: map[string]any{}},
+	)
+	if _, err := r.ApplyWrites(ctx, writes, key); err != nil {
+		t.Fatal(err)
+	}
+
+	list := func(collection string, opts ListOptions) []string {
+		var rkeys []string
+		for rec, err := range r.ListRecords(ctx, collection, opts) {
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rec.Value["n"] != nil && rec.Value["n"] != rec.RKey {
+				t.Fatal("record value does not match key")
+			}
+			rkeys = append(rkeys, rec.RKey)
+		}
+		return rkeys
+	}
+	tests := []struct {
+		collection string
+		opts       ListOptions
+		want       []string
+	}{
+		{"app.bsky.feed.like", ListOptions{}, []string{"a", "b", "c", "d", "e"}},
+		{"app.bsky.feed.like", ListOptions{Limit: 2}, []string{"a", "b"}},
+		{"app.bsky.feed.like", ListOptions{Cursor: "b", Limit: 2}, []string{"c", "d"}},
+		{"app.bsky.feed.like", ListOptions{Cursor: "bb"}, []string{"c", "d", "e"}},
+		{"app.bsky.feed.like", ListOptions{Cursor: "e"}, nil},
+		{"app.bsky.feed.post", ListOptions{}, []string{"a"}},
+		{"app.bsky.feed", ListOptions{}, nil},
+		{"app.bsky.graph.follow", ListOptions{}, nil},
+	}
+	for _, test := range tests {
+		if got := list(test.collection, test.opts); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s %+v: got %v, want %v", test.collection, test.opts, got, test.want)
+		}
+	}
+
+	n := 0
+	for range r.ListRecords(ctx, "app.bsky.feed.like", ListOptions{}) {
+		n++
+		break
+	}
+	if n != 1 {
+		t.Fatal("iteration did not stop")
+	}
+}
+
+func TestEventBlocks(t *testing.T) {
+	ctx := context.Background()
+	key, err := crypto.GenerateK256()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := New("did:plc:alice", blockstore.NewMemoryBlockstore())
+	var writes []Write
+	for range 200 {
+		writes = append(writes, Write{Action: ActionCreate, Collection: "app.bsky.feed.like", Record: map[string]any{}})
+	}
+	first, err := r.ApplyWrites(ctx, writes, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deleted := strings.TrimPrefix(first.Ops[50].Path, "app.bsky.feed.like/")
+	res, err := r.ApplyWrites(ctx, []Write{
+		{Action: ActionDelete, Collection: "app.bsky.feed.like", RKey: deleted},
+		{Action: ActionCreate, Collection: "app.bsky.feed.post", RKey: "a", Record: map[string]any{"text": "hi"}},
+	}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := res.EventBlocks(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cr, err := car.NewReader(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cr.Roots) != 1 || cr.Roots[0].String() != res.Cid.String() {
+		t.Fatal("CAR is not rooted at the commit")
+	}
+	var blocks []blockstore.Block
+	for {
+		blk, err := cr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		blocks = append(blocks, blockstore.Block{Cid: blk.Cid, Data: blk.Data})
+	}
+	if len(blocks) < len(res.Blocks) {
+		t.Fatal("CAR is missing commit blocks")
+	}
+	for _, op := range res.Ops {
+		val, ok, err := mst.VerifyProof(res.Commit.Data, op.Path, blocks)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok != (op.Cid != nil) || (ok && val.String() != op.Cid.String()) {
+			t.Fatalf("invalid proof for %s", op.Path)
+		}
+	}
+}
+
+func TestDiff(t *testing.T) {
+	ctx := context.Background()
+	key, err := crypto.GenerateK256()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bs := blockstore.NewMemoryBlockstore()
+	r := New("did:plc:alice", bs)
+	var writes []Write
+	for _, rkey := range []string{"a", "b", "c"} {
+		writes = append(writes, Write{Action: ActionCreate, Collection: "app.bsky.feed.post", RKey: rkey, Record: map[string]any{"text": rkey}})
+	}
+	first, err := r.ApplyWrites(ctx, writes, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := r.ApplyWrites(ctx, []Write{
+		{Action: ActionDelete, Collection: "app.bsky.feed.post", RKey: "a"},
+		{Action: ActionUpdate, Collection: "app.bsky.feed.post", RKey: "b", Record: map[string]any{"text": "edited"}},
+		{Action: ActionCreate, Collection: "app.bsky.feed.post", RKey: "d", Record: map[string]any{}},
+	}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ops, err := Diff(ctx, bs, first.Cid, second.Cid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, op := range ops {
+		got = append(got, string(op.Action)+" "+op.Path)
+	}
+	want := []string{"delete app.bsky.feed.post/a", "update app.bsky.feed.post/b", "create app.bsky.feed.post/d"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if ops[1].Cid.String() != second.Ops[1].Cid.String() || ops[1].Prev.String() != first.Ops[1].Cid.String() {
+		t.Fatal("invalid update CIDs")
+	}
+}
+
+func TestValidateRev(t *testing.T) {
+	now := time.Now()
+	rev := func(t time.Time) string { return tid.Create(t.UnixMicro(), 0) }
+	prev := rev(now.Add(-time.Hour))
+
+	if err := ValidateRev(rev(now), prev, now); err != nil {
+		t.Fatal(err)
+	}
+	if err := ValidateRev(rev(now), "", now); err != nil {
+		t.Fatal(err)
+	}
+	if err := ValidateRev(prev, prev, now); !errors.Is(err, ErrRevNotIncreasing) {
+		t.Fatal("expected not increasing error")
+	}
+	if err := ValidateRev(rev(now.Add(-2*time.Hour)), prev, now); !errors.Is(err, ErrRevNotIncreasing) {
+		t.Fatal("expected not increasing error")
+	}
+	if err := ValidateRev(rev(now.Add(time.Minute)), prev, now); err != nil {
+		t.Fatal(err)
+	}
+	if err := ValidateRev(rev(now.Add(time.Hour)), prev, now); !errors.Is(err, ErrRevInFuture) {
+		t.Fatal("expected future rev error")
+	}
+	if err := ValidateRev("invalid", prev, now); err == nil {
+		t.Fatal("expected invalid rev error")
+	}
+}
+
+func TestVerifyFull(t *testing.T) {
+	ctx := context.Background()
+	key, err := crypto.GenerateK256()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bs := blockstore.NewMemoryBlockstore()
+	r := New("did:plc:alice", bs)
+	var writes []Write
+	for i := range 50 {
+		writes = append(writes, Write{Action: ActionCreate, Collection: "app.bsky.feed.post", Record: map[string]any{"text": fmt.Sprint(i)}})
+	}
+	res, err := r.ApplyWrites(ctx, writes, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := VerifyFull(ctx, bs, res.Cid, "did:plc:alice", key.PublicKey(), VerifyOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.OK() || report.Records != 50 || report.Nodes == 0 || report.Rev != res.Commit.Rev {
+		t.Fatalf("unexpected report %+v", report)
+	}
+
+	var buf bytes.Buffer
+	if err := car.ExportFrom(ctx, bs, []cid.Cid{res.Cid}, &buf, car.TransferOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	other, err := crypto.GenerateK256()
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err = VerifyFullCAR(ctx, &buf, "did:plc:bob", other.PublicKey(), VerifyOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Problems) != 2 {
+		t.Fatalf("expected DID and signature problems, got %v", report.Problems)
+	}
+
+	missing := *res.Ops[7].Cid
+	if err := bs.Delete(ctx, missing); err != nil {
+		t.Fatal(err)
+	}
+	report, err = VerifyFull(ctx, bs, res.Cid, "did:plc:alice", key.PublicKey(), VerifyOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Problems) != 1 || report.Problems[0].Path != res.Ops[7].Path {
+		t.Fatalf("expected missing record problem, got %v", report.Problems)
+	}
+}
+
+func TestVerifyStream(t *testing.T) {
+	ctx := context.Background()
+	key, err := crypto.GenerateK256()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bs := blockstore.NewMemoryBlockstore()
+	r := New("did:plc:alice", bs)
+	var writes []Write
+	for i := range 200 {
+		writes = append(writes, Write{Action: ActionCreate, Collection: "app.bsky.feed.post", Record: map[string]any{"text": fmt.Sprint(i)}})
+	}
+	res, err := r.ApplyWrites(ctx, writes, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := car.ExportFrom(ctx, bs, []cid.Cid{res.Cid}, &buf, car.TransferOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	full, err := VerifyFullCAR(ctx, bytes.NewReader(buf.Bytes()), "did:plc:alice", key.PublicKey(), VerifyOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := VerifyStream(ctx, bytes.NewReader(buf.Bytes()), "did:plc:alice", key.PublicKey(), VerifyOptions{MaxPending: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.OK() || report.Records != 200 || report.Nodes != full.Nodes {
+		t.Fatalf("unexpected report %+v", report)
+	}
+
+	// records of a single digit encode to 8 bytes, and the others to more
+	for _, verify := range []func(opts VerifyOptions) (*VerifyReport, error){
+		func(opts VerifyOptions) (*VerifyReport, error) {
+			return VerifyFullCAR(ctx, bytes.NewReader(buf.Bytes()), "did:plc:alice", key.PublicKey(), opts)
+		},
+		func(opts VerifyOptions) (*VerifyReport, error) {
+			return VerifyStream(ctx, bytes.NewReader(buf.Bytes()), "did:plc:alice", key.PublicKey(), opts)
+		},
+	} {
+		report, err := verify(VerifyOptions{Limits: limits.Limits{MaxRecordSize: 8}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(report.Problems) != 190 || !errors.Is(report.Problems[0], limits.ErrExceeded) {
+			t.Fatalf("expected oversized records to be reported, got %d problems", len(report.Problems))
+		}
+		if report, err := verify(VerifyOptions{Limits: limits.Network}); err != nil || !report.OK() {
+			t.Fatalf("unexpected report %+v: %v", report, err)
+		}
+	}
+
+	cr, err := car.NewReader(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var blocks []car.Block
+	for {
+		blk, err := cr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		blocks = append(blocks, blk)
+	}
+	write := func(blocks []car.Block) []byte {
+		var out bytes.Buffer
+		w, err := car.NewWriter(&out, []cid.Cid{res.Cid})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, blk := range blocks {
+			if err := w.Put(blk.Cid, blk.Data); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return out.Bytes()
+	}
+
+	backward := slices.Clone(blocks)
+	slices.Reverse(backward)
+	reversed := write(backward)
+	report, err = VerifyStream(ctx, bytes.NewReader(reversed), "did:plc:alice", key.PublicKey(), VerifyOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.OK() || report.Records != 200 || report.Nodes != full.Nodes {
+		t.Fatalf("unexpected report %+v", report)
+	}
+	_, err = VerifyStream(ctx, bytes.NewReader(reversed), "did:plc:alice", key.PublicKey(), VerifyOptions{MaxPending: 1024})
+	if !errors.Is(err, ErrPendingLimit) {
+		t.Fatalf("expected pending limit error, got %v", err)
+	}
+
+	// blocks not matching their CID are reported without stopping the checks
+	tampered := map[string]string{res.Ops[3].Cid.String(): res.Ops[3].Path, res.Ops[9].Cid.String(): res.Ops[9].Path}
+	var corrupt []car.Block
+	for _, blk := range blocks {
+		if _, ok := tampered[blk.Cid.String()]; ok {
+			blk.Data = bytes.Clone(blk.Data)
+			blk.Data[len(blk.Data)-1] ^= 1
+		}
+		corrupt = append(corrupt, blk)
+	}
+	for _, verify := range []func(io.Reader, string, crypto.PublicKey, VerifyOptions) (*VerifyReport, error){
+		func(r io.Reader, did string, pub crypto.PublicKey, opts VerifyOptions) (*VerifyReport, error) {
+			return VerifyFullCAR(ctx, r, did, pub, opts)
+		},
+		func(r io.Reader, did string, pub crypto.PublicKey, opts VerifyOptions) (*VerifyReport, error) {
+			return VerifyStream(ctx, r, did, pub, opts)
+		},
+	} {
+		report, err := verify(bytes.NewReader(write(corrupt)), "did:plc:alice", key.PublicKey(), VerifyOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(report.Problems) != 2 || report.Records != 200 {
+			t.Fatalf("expected mismatched record problems, got %d records and %v", report.Records, report.Problems)
+		}
+		for _, p := range report.Problems {
+			if tampered[p.Cid.String()] != p.Path {
+				t.Fatalf("unexpected problem %v", p)
+			}
+		}
+	}
+
+	// copies of blocks already checked are not held
+	repeated := write(append(slices.Clone(blocks), blocks...))
+	report, err = VerifyStream(ctx, bytes.NewReader(repeated), "did:plc:alice", key.PublicKey(), VerifyOptions{MaxPending: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.OK() || report.Records != 200 || report.Nodes != full.Nodes {
+		t.Fatalf("unexpected report %+v", report)
+	}
+
+	missing := *res.Ops[7].Cid
+	truncated := write(slices.DeleteFunc(blocks, func(blk car.Block) bool { return blk.Cid.String() == missing.String() }))
+	report, err = VerifyStream(ctx, bytes.NewReader(truncated), "did:plc:alice", key.PublicKey(), VerifyOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Problems) != 1 || report.Problems[0].Path != res.Ops[7].Path {
+		t.Fatalf("expected missing record problem, got %v", report.Problems)
+	}
+}
+
+func TestExportSince(t *testing.T) {
+	ctx := context.Background()
+	key, err := crypto.GenerateK256()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bs := blockstore.NewMemoryBlockstore()
+	tree := mst.New(bs)
+	record := func(text string) cid.Cid {
+		c, b, err := EncodeRecord(map[string]any{"text": text})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := bs.Put(ctx, c, b); err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+	commit := func(did, rev string) cid.Cid {
+		root, _, err := tree.Write(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		signed, err := SignCommit(ctx, Commit{DID: did, Version: CommitVersion, Data: root, Rev: rev}, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := signed.Bytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		c, _ := cid.Create(cid.CodecCbor, b)
+		if err := bs.Put(ctx, c, b); err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+	export := func(head cid.Cid, since string) *cid.Set {
+		var buf bytes.Buffer
+		if err := ExportSince(ctx, bs, head, since, &buf); err != nil {
+			t.Fatal(err)
+		}
+		cr, err := car.NewReader(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var set cid.Set
+		for {
+			blk, err := cr.Next()
+			if err == io.EOF {
+				return &set
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			set.Add(blk.Cid)
+		}
+	}
+
+	var old []cid.Cid
+	for i := range 100 {
+		c := record(fmt.Sprint("old ", i))
+		old = append(old, c)
+		if err := tree.Insert(ctx, fmt.Sprintf("app.bsky.feed.post/%04d", i), c); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// v3 commits, without prev links
+	first := commit("did:plc:alice", "3jzfcijpj2z2a")
+	added := record("new")
+	if err := tree.Insert(ctx, "app.bsky.feed.post/0050a", added); err != nil {
+		t.Fatal(err)
+	}
+	second := commit("did:plc:alice", "3jzfcijpj2z2b")
+
+	full := export(second, "")
+	if !full.Has(old[0]) || !full.Has(added) || full.Has(first) {
+		t.Fatal("expected full export")
+	}
+	since := export(second, "3jzfcijpj2z2a")
+	if !since.Has(second) || !since.Has(added) || since.Has(old[0]) || since.Has(first) || since.Len() >= full.Len() {
+		t.Fatalf("unexpected incremental export of %d blocks", since.Len())
+	}
+	// the new root and the nodes on the path to the new key, out of a multi-layer tree
+	if since.Len() > 2+3 {
+		t.Fatalf("incremental export of %d blocks for a single record", since.Len())
+	}
+	if current := export(second, "3jzfcijpj2z2b"); current.Len() != 1 || !current.Has(second) {
+		t.Fatal("expected only the head commit")
+	}
+	if current := export(first, "3jzfcijpj2z2b"); current.Len() != 1 || !current.Has(first) {
+		t.Fatal("expected only the head commit")
+	}
+
+	// the exported blocks turn the older copy into the newer one
+	older := blockstore.NewMemoryBlockstore()
+	var buf bytes.Buffer
+	if err := ExportSince(ctx, bs, first, "", &buf); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadFromCAR(ctx, &buf, older); err != nil {
+		t.Fatal(err)
+	}
+	buf.Reset()
+	if err := ExportSince(ctx, bs, second, "3jzfcijpj2z2a", &buf); err != nil {
+		t.Fatal(err)
+	}
+	merged, err := LoadFromCAR(ctx, &buf, older)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := merged.GetRecord(ctx, "app.bsky.feed.post", "0050a"); err != nil {
+		t.Fatal(err)
+	}
+
+	// revs without a commit of the repository in the blockstore are rejected
+	commit("did:plc:bob", "3jzfcijpj2z2a2")
+	for _, rev := range []string{"3jzfcijpj2z22", "3jzfcijpj2z2a2"} {
+		var buf bytes.Buffer
+		if err := ExportSince(ctx, bs, second, rev, &buf); !errors.Is(err, ErrRevNotFound) || buf.Len() != 0 {
+			t.Fatalf("%s: expected rev not found error, got %v", rev, err)
+		}
+	}
+}
+
+// serves the statements of a SQLCollectionIndex from a map, staging the writes of transactions until they commit
+type sqlRecords struct {
+	// expected statements, by query
+	stmts map[string]string
+	// fails the statements for which it returns an error, if set
+	fault func(s sqltest.Stmt) error
+
+	mtx     sync.Mutex
+	records map[[3]string][]byte
+	staged  map[int][]func()
+}
+
+func newSQLRecords(dialect blockstore.SQLDialect) *sqlRecords {
+	stmts := map[string]string{
+		"CREATE TABLE IF NOT EXISTS records (did TEXT NOT NULL, collection TEXT NOT NULL, rkey TEXT NOT NULL, " +
+			"cid BLOB NOT NULL, PRIMARY KEY (did, collection, rkey))": "create",
+		"INSERT INTO records (did, collection, rkey, cid) VALUES (?, ?, ?, ?) " +
+			"ON CONFLICT (did, collection, rkey) DO UPDATE SET cid = excluded.cid": "upsert",
+		"DELETE FROM records WHERE did = ? AND collection = ? AND rkey = ?":                                 "delete",
+		"SELECT rkey, cid FROM records WHERE did = ? AND collection = ? AND rkey > ? ORDER BY rkey LIMIT ?": "list",
+		"DELETE FROM records WHERE did = ?":                                                                 "clear",
+	}
+	if dialect == blockstore.Postgres {
+		pg := map[string]string{}
+		for q, name := range stmts {
+			pg[sqltest.Numbered(strings.ReplaceAll(q, "BLOB", "BYTEA"))] = name
+		}
+		stmts = pg
+	}
+	return &sqlRecords{stmts: stmts, records: map[[3]string][]byte{}, staged: map[int][]func(){}}
+}
+
+func (r *sqlRecords) handle(s sqltest.Stmt) ([][]driver.Value, error) {
+	name, ok := r.stmts[s.Query]
+	if !ok {
+		return nil, fmt.Errorf("unexpected statement %q", s.Query)
+	}
+	if r.fault != nil {
+		if err := r.fault(s); err != nil {
+			return nil, err
+		}
+	}
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	write := func(fn func()) {
+		if s.Tx == 0 {
+			fn()
+		} else {
+			r.staged[s.Tx] = append(r.staged[s.Tx], fn)
+		}
+	}
+	arg := func(i int) string { return s.Args[i].(string) }
+	switch name {
+	case "upsert":
+		k, c := [3]string{arg(0), arg(1), arg(2)}, s.Args[3].([]byte)
+		write(func() { r.records[k] = c })
+	case "delete":
+		k := [3]string{arg(0), arg(1), arg(2)}
+		write(func() { delete(r.records, k) })
+	case "clear":
+		did := arg(0)
+		write(func() {
+			for k := range r.records {
+				if k[0] == did {
+					delete(r.records, k)
+				}
+			}
+		})
+	case "list":
+		var rows [][]driver.Value
+		for k, c := range r.records {
+			if k[0] == arg(0) && k[1] == arg(1) && k[2] > arg(2) {
+				rows = append(rows, []driver.Value{k[2], c})
+			}
+		}
+		slices.SortFunc(rows, func(a, b []driver.Value) int { return strings.Compare(a[0].(string), b[0].(string)) })
+		if limit := int(s.Args[3].(int64)); len(rows) > limit {
+			rows = rows[:limit]
+		}
+		return rows, nil
+	}
+	return nil, nil
+}
+
+func (r *sqlRecords) endTx(tx int, commit bool) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	if commit {
+		for _, fn := range r.staged[tx] {
+			fn()
+		}
+	}
+	delete(r.staged, tx)
+}
+
+func TestCollectionIndex(t *testing.T) {
+	ctx := context.Background()
+	key, err := crypto.GenerateK256()
+	if err != nil {
+		t.Fatal(err)
+	}
+	indexes := map[string]CollectionIndex{"memory": NewMemoryCollectionIndex()}
+	handlers := map[string]*sqlRecords{}
+	for name, dialect := range map[string]blockstore.SQLDialect{"sqlite": blockstore.SQLite, "postgres": blockstore.Postgres} {
+		records := newSQLRecords(dialect)
+		rec := sqltest.NewRecorder(records.handle)
+		rec.EndTx = records.endTx
+		db := rec.Open()
+		defer db.Close()
+		if _, err := OpenSQLCollectionIndex(ctx, db, dialect, "records; DROP TABLE x"); err == nil {
+			t.Fatal("expected invalid table name error")
+		}
+		idx, err := OpenSQLCollectionIndex(ctx, db, dialect, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer idx.Close()
+		indexes[name], handlers[name] = idx, records
+	}
+
+	for name, idx := range indexes {
+		t.Run(name, func(t *testing.T) {
+			r := New("did:plc:alice", blockstore.NewMemoryBlockstore())
+			var writes []Write
+			for i := range 250 {
+				writes = append(writes, Write{Action: ActionCreate, Collection: "app.bsky.feed.like", RKey: fmt.Sprintf("%03d", i), Record: map[string]any{"n": int64(i)}})
+			}
+			writes = append(writes, Write{Action: ActionCreate, Collection: "app.bsky.actor.profile", RKey: "self", Record: map[string]any{}})
+			if _, err := r.ApplyWrites(ctx, writes, key); err != nil {
+				t.Fatal(err)
+			}
+
+			// entries of another repository are left alone
+			head, _, _ := r.Head()
+			other := Op{Action: ActionCreate, Path: "app.bsky.feed.like/000", Cid: &head}
+			if err := idx.Apply(ctx, "did:plc:bob", []Op{other}); err != nil {
+				t.Fatal(err)
+			}
+			r.SetIndex(idx)
+			if err := r.RebuildIndex(ctx); err != nil {
+				t.Fatal(err)
+			}
+			_, err := r.ApplyWrites(ctx, []Write{
+				{Action: ActionDelete, Collection: "app.bsky.feed.like", RKey: "007"},
+				{Action: ActionUpdate, Collection: "app.bsky.feed.like", RKey: "008", Record: map[string]any{"n": "eight"}},
+			}, key)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			list := func(opts ListOptions) []Record {
+				var records []Record
+				for rec, err := range r.ListRecords(ctx, "app.bsky.feed.like", opts) {
+					if err != nil {
+						t.Fatal(err)
+					}
+					records = append(records, rec)
+				}
+				return records
+			}
+			all := list(ListOptions{})
+			if len(all) != 249 || all[7].RKey != "008" || all[7].Value["n"] != "eight" || all[248].RKey != "249" {
+				t.Fatalf("unexpected records %d", len(all))
+			}
+			page := list(ListOptions{Cursor: "100", Limit: 120})
+			if len(page) != 120 || page[0].RKey != "101" || page[119].RKey != "220" {
+				t.Fatalf("unexpected page of %d records", len(page))
+			}
+			if entries, err := idx.List(ctx, "did:plc:bob", "app.bsky.feed.like", "", 0); err != nil || len(entries) != 1 {
+				t.Fatalf("unexpected entries of another repository %v, %v", entries, err)
+			}
+
+			r.SetIndex(nil)
+			if walked := list(ListOptions{}); !reflect.DeepEqual(walked, all) {
+				t.Fatal("index listing differs from MST listing")
+			}
+
+			records := handlers[name]
+			if records == nil {
+				return
+			}
+			// a rebuild failing partway leaves the previous entries in place
+			before := maps.Clone(records.records)
+			upserts := 0
+			records.fault = func(s sqltest.Stmt) error {
+				if records.stmts[s.Query] != "upsert" {
+					return nil
+				}
+				if upserts++; upserts > 100 {
+					return errInjected
+				}
+				return nil
+			}
+			defer func() { records.fault = nil }()
+			r.SetIndex(idx)
+			if err := r.RebuildIndex(ctx); !errors.Is(err, errInjected) {
+				t.Fatalf("expected rebuild failure, got %v", err)
+			}
+			if !maps.EqualFunc(records.records, before, bytes.Equal) {
+				t.Fatal("failed rebuild changed the index")
+			}
+		})
+	}
+}
+
+var errInjected = errors.New("injected failure")
+
+// CollectionIndex failing every Apply while fail is set
+type failingIndex struct {
+	CollectionIndex
+	fail bool
+}
+
+func (f *failingIndex) Apply(ctx context.Context, did string, ops []Op) error {
+	if f.fail {
+		return errInjected
+	}
+	return f.CollectionIndex.Apply(ctx, did, ops)
+}
+
+// Blockstore counting single block puts, which only commit blocks use, and failing them while fail is set
+type failingPutStore struct {
+	blockstore.Blockstore
+	fail bool
+	puts int
+}
+
+func (f *failingPutStore) Put(ctx context.Context, c cid.Cid, data []byte) error {
+	f.puts++
+	if f.fail {
+		return errInjected
+	}
+	return f.Blockstore.Put(ctx, c, data)
+}
+
+func TestCollectionIndexFailure(t *testing.T) {
+	ctx := context.Background()
+	key, err := crypto.GenerateK256()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bs := &failingPutStore{Blockstore: blockstore.NewMemoryBlockstore()}
+	idx := &failingIndex{CollectionIndex: NewMemoryCollectionIndex()}
+	r := New("did:plc:alice", bs)
+	r.SetIndex(idx)
+	if _, err := r.CreateRecord(ctx, "app.bsky.feed.post", "a", map[string]any{"n": int64(1)}, key); err != nil {
+		t.Fatal(err)
+	}
+	head, _, _ := r.Head()
+
+	check := func() {
+		t.Helper()
+		if h, _, _ := r.Head(); h.String() != head.String() {
+			t.Fatal("repository advanced despite the failure")
+		}
+		var indexed []string
+		for _, coll := range []string{"app.bsky.feed.like", "app.bsky.feed.post"} {
+			entries, err := idx.List(ctx, "did:plc:alice", coll, "", 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, e := range entries {
+				indexed = append(indexed, coll+"/"+e.RKey+" "+e.Cid.String())
+			}
+		}
+		var walked []string
+		err := r.tree.ForEach(ctx, "", func(key string, val cid.Cid) error {
+			walked = append(walked, key+" "+val.String())
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(indexed, walked) {
+			t.Fatalf("index %v differs from tree %v", indexed, walked)
+		}
+	}
+	writes := []Write{
+		{Action: ActionUpdate, Collection: "app.bsky.feed.post", RKey: "a", Record: map[string]any{"n": int64(2)}},
+		{Action: ActionCreate, Collection: "app.bsky.feed.like", RKey: "b", Record: map[string]any{}},
+	}
+
+	idx.fail = true
+	puts := bs.puts
+	if _, err := r.ApplyWrites(ctx, writes, key); !errors.Is(err, errInjected) {
+		t.Fatalf("expected index failure, got %v", err)
+	}
+	if bs.puts != puts {
+		t.Fatal("commit block stored despite the index failure")
+	}
+	check()
+	idx.fail = false
+
+	bs.fail = true
+	if _, err := r.ApplyWrites(ctx, writes, key); !errors.Is(err, errInjected) {
+		t.Fatalf("expected commit storage failure, got %v", err)
+	}
+	check()
+	bs.fail = false
+
+	if _, err := r.ApplyWrites(ctx, writes, key); err != nil {
+		t.Fatal(err)
+	}
+	if entries, _ := idx.List(ctx, "did:plc:alice", "app.bsky.feed.like", "", 0); len(entries) != 1 {
+		t.Fatal("index was not updated")
+	}
+}
+
+func TestRecordProof(t *testing.T) {
+	ctx := context.Background()
+	key, err := crypto.GenerateK256()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := New("did:plc:alice", blockstore.NewMemoryBlockstore())
+	var writes []Write
+	for i := range 100 {
+		writes = append(writes, Write{Action: ActionCreate, Collection: "app.bsky.feed.post", RKey: fmt.Sprint(i), Record: map[string]any{"text": fmt.Sprint(i)}})
+	}
+	if _, err := r.ApplyWrites(ctx, writes, key); err != nil {
+		t.Fatal(err)
+	}
+
+	proof, err := r.ProveRecord(ctx, "app.bsky.feed.post", "42")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec, err := VerifyRecordProof(bytes.NewReader(proof), "did:plc:alice", "app.bsky.feed.post", "42", key.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.Value["text"] != "42" {
+		t.Fatal("unexpected record value")
+	}
+	if _, err := VerifyRecordProof(bytes.NewReader(proof), "did:plc:alice", "app.bsky.feed.post", "43", key.PublicKey()); err == nil {
+		t.Fatal("proof should not cover another record")
+	}
+	other, _ := crypto.GenerateK256()
+	if _, err := VerifyRecordProof(bytes.NewReader(proof), "did:plc:alice", "app.bsky.feed.post", "42", other.PublicKey()); err == nil {
+		t.Fatal("expected signature error")
+	}
+
+	absent, err := r.ProveRecord(ctx, "app.bsky.feed.post", "missing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := VerifyRecordProof(bytes.NewReader(absent), "did:plc:alice", "app.bsky.feed.post", "missing", key.PublicKey()); !errors.Is(err, ErrRecordNotFound) {
+		t.Fatalf("expected record not found, got %v", err)
+	}
+}
+
+func TestEncodeRecord(t *testing.T) {
+	record := map[string]any{"$type": "app.bsky.feed.like", "count": 3, "score": -1, "ratio": float32(0.5)}
+	c, b, err := EncodeRecord(record)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, err := cid.Create(cid.CodecCbor, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.String() != expected.String() {
+		t.Fatal("CID does not match encoding")
+	}
+	v, err := cbor.Decode(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m := v.(map[string]any); m["count"] != uint64(3) || m["score"] != int64(-1) || m["ratio"] != 0.5 {
+		t.Fatalf("unexpected decoded record %v", m)
+	}
+
+	if _, _, err := EncodeRecordLimit(record, 10); !errors.Is(err, ErrRecordTooLarge) || !errors.Is(err, limits.ErrExceeded) {
+		t.Fatal("expected record too large error")
+	}
+	if _, _, err := EncodeRecord("text"); err == nil {
+		t.Fatal("expected error for non-map record")
+	}
+}
+
+func TestLegacyCommit(t *testing.T) {
+	ctx := context.Background()
+	key, err := crypto.GenerateK256()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bs := blockstore.NewMemoryBlockstore()
+	tree := mst.New(bs)
+	record, _ := cid.Create(cid.CodecCbor, []byte{0xa0})
+	if err := bs.Put(ctx, record, []byte{0xa0}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.Insert(ctx, "app.bsky.feed.post/3jqfcqzm3fo2j", record); err != nil {
+		t.Fatal(err)
+	}
+	root, _, err := tree.Write(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	prev, _ := cid.Create(cid.CodecCbor, []byte("previous commit"))
+	commit, err := SignCommit(ctx, Commit{DID: "did:plc:alice", Version: LegacyCommitVersion, Data: root, Prev: &prev}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := commit.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := cbor.Decode(b); v.(map[string]any)["rev"] != nil {
+		t.Fatal("legacy commit without rev should not encode one")
+	}
+	if _, err := DecodeCommit(b); err == nil {
+		t.Fatal("expected legacy commit to be rejected")
+	}
+	decoded, err := DecodeCommitLegacy(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Prev == nil || decoded.Prev.String() != prev.String() || decoded.Rev != "" {
+		t.Fatal("unexpected legacy commit fields")
+	}
+
+	head, _ := cid.Create(cid.CodecCbor, b)
+	if err := bs.Put(ctx, head, b); err != nil {
+		t.Fatal(err)
+	}
+	report, err := VerifyFull(ctx, bs, head, "did:plc:alice", key.PublicKey(), VerifyOptions{Legacy: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.OK() || report.Records != 1 {
+		t.Fatalf("unexpected report %v", report.Problems)
+	}
+	report, err = VerifyFull(ctx, bs, head, "did:plc:alice", key.PublicKey(), VerifyOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.OK() {
+		t.Fatal("expected legacy commit to fail strict verification")
+	}
+}
+
+func TestLoadFromCAR(t *testing.T) {
+	ctx := context.Background()
+	data, err := os.ReadFile("testdata/greenground.repo.car")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := LoadFromCARStrict(ctx, bytes.NewReader(data), blockstore.NewMemoryBlockstore())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.DID() != "did:plc:kzcqyc3unb33eh5sxzsfs25z" {
+		t.Fatalf("unexpected DID %s", r.DID())
+	}
+	if _, _, err := r.GetRecord(ctx, "app.bsky.graph.follow", "3k5rgfiifs42u"); err != nil {
+		t.Fatal(err)
+	}
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := LoadFromCAR(canceled, bytes.NewReader(data), blockstore.NewMemoryBlockstore()); !errors.Is(err,
+		context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+
+	// append a block which is not part of the repository
+	var buf bytes.Buffer
+	head, _, _ := r.Head()
+	w, err := car.NewWriter(&buf, []cid.Cid{head})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cr, err := car.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for {
+		blk, err := cr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Put(blk.Cid, blk.Data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	extra, _ := cid.Create(cid.CodecRaw, []byte("extra"))
+	if err := w.Put(extra, []byte("extra")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadFromCAR(ctx, bytes.NewReader(buf.Bytes()), blockstore.NewMemoryBlockstore()); err != nil {
+		t.Fatal(err)
+	}
+	untouched := blockstore.NewMemoryBlockstore()
+	if _, err := LoadFromCARStrict(ctx, bytes.NewReader(buf.Bytes()), untouched); err == nil {
+		t.Fatal("expected extraneous block error")
+	}
+	for range untouched.Keys(ctx) {
+		t.Fatal("failed strict import wrote to the store")
+	}
+
+	// corrupt the last byte of the last record
+	corrupt := append([]byte(nil), data...)
+	corrupt[len(corrupt)-1] ^= 1
+	if _, err := LoadFromCAR(ctx, bytes.NewReader(corrupt), blockstore.NewMemoryBlockstore()); err == nil {
+		t.Fatal("expected block mismatch error")
+	}
+}
+
+func TestAccountStatus(t *testing.T) {
+	for _, tc := range []struct {
+		active       bool
+		status       string
+		expected     AccountStatus
+		serve, purge bool
+	}{
+		{true, "", StatusActive, true, false},
+		{true, "deactivated", StatusActive, true, false},
+		{false, "", StatusDeactivated, false, false},
+		{false, "takendown", StatusTakendown, false, false},
+		{false, "suspended", StatusSuspended, false, false},
+		{false, "deleted", StatusDeleted, false, true},
+		{false, "throttled", StatusThrottled, true, false},
+		{false, "desynchronized", StatusDesynchronized, true, false},
+		{false, "unknown", AccountStatus("unknown"), false, false},
+	} {
+		s := AccountStatusOf(tc.active, tc.status)
+		if s != tc.expected || s.Serve() != tc.serve || s.Purge() != tc.purge {
+			t.Errorf("%t %q: unexpected status %s", tc.active, tc.status, s)
+		}
+		if active, status := s.Fields(); AccountStatusOf(active, status) != s {
+			t.Errorf("%s: fields %t %q do not round trip", s, active, status)
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package repo
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/notjuliet/grove/tid"
+)
+
+// How far in the future a rev may be before ValidateRev rejects it.
+const MaxRevClockSkew = 5 * time.Minute
+
+var (
+	ErrRevNotIncreasing = errors.New("rev is not greater than the previous rev")
+	ErrRevInFuture      = errors.New("rev is too far in the future")
+)
+
+// Checks the rev of a commit applied on top of a commit with rev prev, which is empty for the first known
+// commit of a repository. The rev must be a valid TID, strictly greater than prev, and no further than
+// MaxRevClockSkew ahead of now.
+func ValidateRev(rev, prev string, now time.Time) error {
+	ts, _, err := tid.Parse(rev)
+	if err != nil {
+		return fmt.Errorf("invalid rev %q: %w", rev, err)
+	}
+	if prev != "" && rev <= prev {
+		return fmt.Errorf("%w: %s <= %s", ErrRevNotIncreasing, rev, prev)
+	}
+	// TID timestamps are in microseconds
+	if t := time.UnixMicro(int64(ts)); t.After(now.Add(MaxRevClockSkew)) {
+		return fmt.Errorf("%w: %s", ErrRevInFuture, t.UTC().Format(time.RFC3339))
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/notjuliet/grove/cid"
+	"github.com/notjuliet/grove/mst"
+)
+
+// Size and contents of a repository, as reported by Repo.Stats.
+type Stats struct {
+	Tree mst.TreeStats
+	// Number of records in each collection.
+	Collections map[string]int
+}
+
+// Computes the statistics of the repository as of the latest commit.
+func (r *Repo) Stats(ctx context.Context) (*Stats, error) {
+	r.mtx.RLock()
+	commit := r.commit
+	r.mtx.RUnlock()
+	if commit == nil {
+		return nil, errors.New("repository has no commit")
+	}
+
+	collections := map[string]int{}
+	tree, err := mst.StatsForEach(ctx, r.bs, commit.Data, func(key string, _ cid.Cid) error {
+		collection, _, _ := strings.Cut(key, "/")
+		collections[collection]++
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Stats{Tree: *tree, Collections: collections}, nil
+}
@@ -0,0 +1,63 @@
+package repo
+
+// Hosting status of an account and its repository, as reported by getRepoStatus, listRepos and #account
+// events. Those carry an active flag and, for inactive accounts, a status string, see AccountStatusOf and
+// AccountStatus.Fields. Hosts may report statuses other than the constants.
+type AccountStatus string
+
+const (
+	// The account is active, with its repository hosted and served.
+	StatusActive AccountStatus = "active"
+	// The account was taken down by its host or a moderation service, which may be reversed.
+	StatusTakendown AccountStatus = "takendown"
+	// The account was suspended for a time.
+	StatusSuspended AccountStatus = "suspended"
+	// The account was deactivated by its owner, who may reactivate it.
+	StatusDeactivated AccountStatus = "deactivated"
+	// The account was deleted for good.
+	StatusDeleted AccountStatus = "deleted"
+	// The repository failed sync validation, and is waiting for a resync.
+	StatusDesynchronized AccountStatus = "desynchronized"
+	// The account exceeded a rate limit of the host.
+	StatusThrottled AccountStatus = "throttled"
+)
+
+// Returns the status of an account from the active flag and status string of getRepoStatus, listRepos and
+// #account events. Inactive accounts without a status are reported as deactivated.
+func AccountStatusOf(active bool, status string) AccountStatus {
+	switch {
+	case active:
+		return StatusActive
+	case status == "":
+		return StatusDeactivated
+	}
+	return AccountStatus(status)
+}
+
+// Returns the active flag and status string of the status, as set in getRepoStatus and listRepos outputs and
+// #account events.
+func (s AccountStatus) Fields() (active bool, status string) {
+	if s == StatusActive {
+		return true, ""
+	}
+	return false, string(s)
+}
+
+// Reports whether mirrors of the account, such as relays and AppViews, should serve its content. Content of
+// active accounts is served, as well as that of desynchronized and throttled ones, whose status reflects the
+// state of the host rather than a decision about the account. Content of other accounts, including those with
+// unknown statuses, is withheld.
+func (s AccountStatus) Serve() bool {
+	switch s {
+	case StatusActive, StatusDesynchronized, StatusThrottled:
+		return true
+	}
+	return false
+}
+
+// Reports whether mirrors should delete the content of the account, rather than only withhold it, as
+// happens for deleted accounts. Content withheld for other statuses may be served again once the account is
+// active.
+func (s AccountStatus) Purge() bool {
+	return s == StatusDeleted
+}
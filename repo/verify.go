@@ -0,0 +1,388 @@
+package repo
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/notjuliet/grove/blockstore"
+	"github.com/notjuliet/grove/car"
+	"github.com/notjuliet/grove/cbor"
+	"github.com/notjuliet/grove/cid"
+	"github.com/notjuliet/grove/crypto"
+	"github.com/notjuliet/grove/limits"
+	"github.com/notjuliet/grove/mst"
+)
+
+// Outcome of VerifyFull.
+type VerifyReport struct {
+	Commit cid.Cid
+	Rev    string
+	// Number of MST nodes and records checked.
+	Nodes   int
+	Records int
+	// Everything found wrong with the repository, empty if it is valid.
+	Problems []Problem
+}
+
+// Returns whether no problem was found.
+func (r *VerifyReport) OK() bool {
+	return len(r.Problems) == 0
+}
+
+// Defect found by VerifyFull.
+type Problem struct {
+	// Offending block, nil for problems not tied to a block.
+	Cid *cid.Cid
+	// Record path, for problems with records.
+	Path string
+	Err  error
+}
+
+func (p Problem) Error() string {
+	switch {
+	case p.Path != "":
+		return fmt.Sprintf("record %s: %v", p.Path, p.Err)
+	case p.Cid != nil:
+		return fmt.Sprintf("block %s: %v", p.Cid, p.Err)
+	default:
+		return p.Err.Error()
+	}
+}
+
+func (p Problem) Unwrap() error {
+	return p.Err
+}
+
+type VerifyOptions struct {
+	// Accept repositories written under older formats: commits of the legacy format version, see
+	// DecodeCommitLegacy, and record keys predating the current syntax, see mst.ValidateLegacyKey.
+	Legacy bool
+	// Maximum total size of the blocks VerifyStream holds because they arrived before the block linking to
+	// them. Zero means DefaultMaxPending.
+	MaxPending int64
+	// Size limits of records and other blocks, such as limits.Network; oversized ones are reported as problems
+	// matching limits.ErrExceeded.
+	Limits limits.Limits
+}
+
+// Default limit on the blocks held by VerifyStream, see VerifyOptions.MaxPending.
+const DefaultMaxPending = 16 << 20
+
+// Returned by VerifyStream when too many blocks arrive ahead of the blocks linking to them.
+var ErrPendingLimit = errors.New("too many blocks received out of order")
+
+// Checks a whole repository for the given account: the commit is valid and signed by pub, the MST is
+// well-formed with sorted keys at the right depths, every key is a valid record path, and every MST node and
+// record is present, matches its CID, and is canonical DAG-CBOR.
+//
+// Defects are collected in the report; an error is only returned if the commit cannot be read or the
+// blockstore fails.
+func VerifyFull(ctx context.Context, bs blockstore.Blockstore, head cid.Cid, did string, pub crypto.PublicKey, opts VerifyOptions) (*VerifyReport, error) {
+	report := &VerifyReport{Commit: head}
+	problem := func(c *cid.Cid, path string, err error) {
+		report.Problems = append(report.Problems, Problem{Cid: c, Path: path, Err: err})
+	}
+
+	b, err := bs.Get(ctx, head)
+	if err != nil {
+		return nil, fmt.Errorf("fetching commit: %w", err)
+	}
+	if err := checkBlock(head, b, opts.Limits.MaxBlockSize); err != nil {
+		problem(&head, "", err)
+	}
+	commit, err := decodeCommit(b, opts.Legacy)
+	if err != nil {
+		problem(&head, "", err)
+		return report, nil
+	}
+	report.Rev = commit.Rev
+	if commit.DID != did {
+		problem(&head, "", fmt.Errorf("commit is for %s, expected %s", commit.DID, did))
+	}
+	if err := VerifyCommitSignature(commit, pub); err != nil {
+		problem(&head, "", fmt.Errorf("invalid commit signature: %w", err))
+	}
+
+	tree, err := mst.Load(ctx, bs, commit.Data)
+	if err != nil {
+		problem(&commit.Data, "", err)
+		return report, nil
+	}
+	// nodes are fetched and checked while listing them
+	nodes, err := tree.NodeCids(ctx)
+	if err != nil {
+		problem(&commit.Data, "", err)
+		return report, nil
+	}
+	for _, c := range nodes {
+		data, err := bs.Get(ctx, c)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkBlock(c, data, opts.Limits.MaxBlockSize); err != nil {
+			problem(&c, "", err)
+		}
+		report.Nodes++
+	}
+
+	validateKey := mst.ValidateKey
+	if opts.Legacy {
+		validateKey = mst.ValidateLegacyKey
+	}
+	err = tree.ForEach(ctx, "", func(key string, val cid.Cid) error {
+		report.Records++
+		if err := validateKey(key); err != nil {
+			problem(nil, key, err)
+		}
+		data, err := bs.Get(ctx, val)
+		if errors.Is(err, blockstore.ErrNotFound) {
+			problem(&val, key, errors.New("record block is missing"))
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := checkBlock(val, data, opts.Limits.MaxRecordSize); err != nil {
+			problem(&val, key, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// Like VerifyFull, for a repository export (getRepo output) read into memory. Blocks are read without being
+// checked, so that those not matching their CID are reported as problems like any other defect.
+func VerifyFullCAR(ctx context.Context, r io.Reader, did string, pub crypto.PublicKey, opts VerifyOptions) (*VerifyReport, error) {
+	cr, err := car.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(cr.Roots) != 1 {
+		return nil, fmt.Errorf("repository CAR has %d roots, expected 1", len(cr.Roots))
+	}
+	bs := blockstore.NewMemoryBlockstore()
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		blk, err := cr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading CAR: %w", err)
+		}
+		if err := bs.Put(ctx, blk.Cid, blk.Data); err != nil {
+			return nil, err
+		}
+	}
+	return VerifyFull(ctx, bs, cr.Roots[0], did, pub, opts)
+}
+
+// checks that a block fits in maxSize bytes, matches its CID and, for DAG-CBOR blocks, is canonically encoded
+func checkBlock(c cid.Cid, data []byte, maxSize int) error {
+	if err := limits.Check(len(data), maxSize, "bytes"); err != nil {
+		return err
+	}
+	if err := verifyBlock(c, data); err != nil {
+		return err
+	}
+	if c.Codec != cid.CodecCbor {
+		return nil
+	}
+	v, err := cbor.Decode(data)
+	if err != nil {
+		return err
+	}
+	canonical, err := cbor.Encode(v)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(canonical, data) {
+		return errors.New("block is not canonical DAG-CBOR")
+	}
+	return nil
+}
+
+// Like VerifyFullCAR, but checks the blocks in a single pass as they are read instead of loading the whole
+// repository into memory. Blocks are expected roughly in traversal order, as written by getRepo: a block
+// arriving before the block linking to it is held until that one arrives, up to opts.MaxPending bytes, beyond
+// which ErrPendingLimit is returned. Blocks not linked from the commit, and copies of blocks already checked,
+// are ignored.
+func VerifyStream(ctx context.Context, r io.Reader, did string, pub crypto.PublicKey, opts VerifyOptions) (*VerifyReport, error) {
+	cr, err := car.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(cr.Roots) != 1 {
+		return nil, fmt.Errorf("repository CAR has %d roots, expected 1", len(cr.Roots))
+	}
+	head := cr.Roots[0]
+	maxPending := cmp.Or(opts.MaxPending, DefaultMaxPending)
+	validateKey := mst.ValidateKey
+	if opts.Legacy {
+		validateKey = mst.ValidateLegacyKey
+	}
+
+	report := &VerifyReport{Commit: head}
+	problem := func(c *cid.Cid, path string, err error) {
+		report.Problems = append(report.Problems, Problem{Cid: c, Path: path, Err: err})
+	}
+	var (
+		commitSeen bool
+		tree       *mst.StreamChecker
+		// records awaited, by CID
+		records = map[string]*awaitedRecord{}
+		// outcome of the checks of the records received, by CID
+		checked = map[string]error{}
+		// blocks checked so far, so that copies of them are ignored
+		seen = map[string]bool{}
+		// blocks received before the block linking to them
+		pending      = map[string][]byte{}
+		pendingBytes int64
+	)
+
+	// counts a record of the tree, awaiting its block unless it was already received
+	addRecord := func(path string, val cid.Cid) {
+		report.Records++
+		if err := validateKey(path); err != nil {
+			problem(nil, path, err)
+		}
+		if err, ok := checked[string(val.Bytes)]; ok {
+			if err != nil {
+				problem(&val, path, err)
+			}
+			return
+		}
+		rec := records[string(val.Bytes)]
+		if rec == nil {
+			rec = &awaitedRecord{cid: val}
+			records[string(val.Bytes)] = rec
+		}
+		rec.paths = append(rec.paths, path)
+	}
+	// checks a block if it is linked from the blocks checked so far, returning whether it was
+	process := func(c cid.Cid, data []byte) bool {
+		switch {
+		case !commitSeen && bytes.Equal(c.Bytes, head.Bytes):
+			commitSeen = true
+			if err := checkBlock(c, data, opts.Limits.MaxBlockSize); err != nil {
+				problem(&c, "", err)
+			}
+			commit, err := decodeCommit(data, opts.Legacy)
+			if err != nil {
+				problem(&c, "", err)
+				return true
+			}
+			report.Rev = commit.Rev
+			if commit.DID != did {
+				problem(&c, "", fmt.Errorf("commit is for %s, expected %s", commit.DID, did))
+			}
+			if err := VerifyCommitSignature(commit, pub); err != nil {
+				problem(&c, "", fmt.Errorf("invalid commit signature: %w", err))
+			}
+			tree = mst.NewStreamChecker(commit.Data)
+		case tree != nil && tree.Wants(c):
+			report.Nodes++
+			if err := checkBlock(c, data, opts.Limits.MaxBlockSize); err != nil {
+				problem(&c, "", err)
+			}
+			if err := tree.Check(c, data, addRecord); err != nil {
+				problem(&c, "", err)
+			}
+		case records[string(c.Bytes)] != nil:
+			err := checkBlock(c, data, opts.Limits.MaxRecordSize)
+			checked[string(c.Bytes)] = err
+			if err != nil {
+				for _, path := range records[string(c.Bytes)].paths {
+					problem(&c, path, err)
+				}
+			}
+			delete(records, string(c.Bytes))
+		default:
+			return false
+		}
+		return true
+	}
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		blk, err := cr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading CAR: %w", err)
+		}
+		if seen[string(blk.Cid.Bytes)] {
+			continue
+		}
+		if !process(blk.Cid, blk.Data) {
+			if _, dup := pending[string(blk.Cid.Bytes)]; !dup {
+				pending[string(blk.Cid.Bytes)] = blk.Data
+				pendingBytes += int64(len(blk.Data))
+				if pendingBytes > maxPending {
+					return nil, fmt.Errorf("%w: more than %d bytes held", ErrPendingLimit, maxPending)
+				}
+			}
+			continue
+		}
+		seen[string(blk.Cid.Bytes)] = true
+
+		// the block may link to blocks which arrived earlier
+		stack := []blockstore.Block{{Cid: blk.Cid, Data: blk.Data}}
+		for len(stack) > 0 {
+			b := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			if b.Cid.Codec != cid.CodecCbor || len(pending) == 0 {
+				continue
+			}
+			links, err := cbor.Links(b.Data)
+			if err != nil {
+				continue
+			}
+			for _, l := range links {
+				data, held := pending[string(l.Bytes)]
+				if !held {
+					continue
+				}
+				if process(l, data) {
+					seen[string(l.Bytes)] = true
+					delete(pending, string(l.Bytes))
+					pendingBytes -= int64(len(data))
+					stack = append(stack, blockstore.Block{Cid: l, Data: data})
+				}
+			}
+		}
+	}
+
+	if !commitSeen {
+		return nil, errors.New("repository CAR is missing the commit block")
+	}
+	if tree != nil {
+		for _, c := range tree.Missing() {
+			problem(&c, "", errors.New("MST node is missing"))
+		}
+	}
+	for _, rec := range records {
+		for _, path := range rec.paths {
+			problem(&rec.cid, path, errors.New("record block is missing"))
+		}
+	}
+	return report, nil
+}
+
+// record block awaited by VerifyStream, with the paths it was found at
+type awaitedRecord struct {
+	cid   cid.Cid
+	paths []string
+}
@@ -0,0 +1,257 @@
+package syntax
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/notjuliet/grove/cid"
+	"github.com/notjuliet/grove/tid"
+)
+
+// Maximum lengths of string formats.
+const (
+	MaxDIDLength      = 2048
+	MaxHandleLength   = 253
+	MaxATURILength    = 8192
+	MaxURILength      = 8192
+	MaxDatetimeLength = 64
+	MaxLanguageLength = 128
+)
+
+// Checks that s is a DID of any method, such as "did:plc:ewvi7nxzyoun6zhxrhs64oiz".
+//
+// https://atproto.com/specs/did
+func ValidateDID(s string) error {
+	if len(s) > MaxDIDLength || !isDID(s) {
+		return fmt.Errorf("invalid DID %q", s)
+	}
+	return nil
+}
+
+// reports whether s is "did:", a lowercase method, ":" and an identifier of ASCII letters, digits and "._:%-",
+// not ending with ":" or "%"
+func isDID(s string) bool {
+	rest, ok := strings.CutPrefix(s, "did:")
+	if !ok {
+		return false
+	}
+	method, id, ok := strings.Cut(rest, ":")
+	if !ok || method == "" || id == "" {
+		return false
+	}
+	for i := range len(method) {
+		if c := method[i]; c < 'a' || c > 'z' {
+			return false
+		}
+	}
+	for i := range len(id) {
+		if c := id[i]; !isLetter(c) && !isDigit(c) && strings.IndexByte("._:%-", c) < 0 {
+			return false
+		}
+	}
+	last := id[len(id)-1]
+	return last != ':' && last != '%'
+}
+
+// Checks that s is a handle: a domain name of at least two labels, the last one not starting with a digit.
+// Handles are case-insensitive.
+//
+// https://atproto.com/specs/handle
+func ValidateHandle(s string) error {
+	if len(s) > MaxHandleLength {
+		return fmt.Errorf("invalid handle %q", s)
+	}
+	labels := strings.Split(s, ".")
+	if len(labels) < 2 {
+		return fmt.Errorf("invalid handle %q", s)
+	}
+	for _, label := range labels {
+		if validateSegment(label, false, true) != nil {
+			return fmt.Errorf("invalid handle %q", s)
+		}
+	}
+	if isDigit(labels[len(labels)-1][0]) {
+		return fmt.Errorf("invalid handle %q", s)
+	}
+	return nil
+}
+
+// Checks that s is either a DID or a handle.
+func ValidateATIdentifier(s string) error {
+	if strings.HasPrefix(s, "did:") {
+		return ValidateDID(s)
+	}
+	return ValidateHandle(s)
+}
+
+// Checks that s is an AT URI: "at://" followed by a DID or handle, and optionally a collection NSID, a record
+// key, and a fragment starting with "/".
+//
+// https://atproto.com/specs/at-uri-scheme
+func ValidateATURI(s string) error {
+	if len(s) > MaxATURILength {
+		return fmt.Errorf("invalid AT URI: longer than %d characters", MaxATURILength)
+	}
+	rest, ok := strings.CutPrefix(s, "at://")
+	if !ok {
+		return fmt.Errorf("invalid AT URI %q: must start with at://", s)
+	}
+	rest, fragment, hasFragment := strings.Cut(rest, "#")
+	if hasFragment && !strings.HasPrefix(fragment, "/") {
+		return fmt.Errorf("invalid AT URI %q: fragment must start with /", s)
+	}
+	parts := strings.Split(rest, "/")
+	if len(parts) > 3 {
+		return fmt.Errorf("invalid AT URI %q: too many path segments", s)
+	}
+	if err := ValidateATIdentifier(parts[0]); err != nil {
+		return fmt.Errorf("invalid AT URI %q: %w", s, err)
+	}
+	if len(parts) > 1 {
+		if err := ValidateNSID(parts[1]); err != nil {
+			return fmt.Errorf("invalid AT URI %q: %w", s, err)
+		}
+	}
+	if len(parts) > 2 {
+		if err := ValidateRecordKey(parts[2]); err != nil {
+			return fmt.Errorf("invalid AT URI %q: %w", s, err)
+		}
+	}
+	return nil
+}
+
+// Checks that s is a datetime as required by lexicons: RFC 3339 with an uppercase "T", seconds, and an explicit
+// timezone other than "-00:00".
+//
+// https://atproto.com/specs/lexicon#datetime
+func ValidateDatetime(s string) error {
+	if len(s) > MaxDatetimeLength || !isDatetime(s) || strings.HasSuffix(s, "-00:00") {
+		return fmt.Errorf("invalid datetime %q", s)
+	}
+	if _, err := time.Parse(time.RFC3339Nano, s); err != nil {
+		return fmt.Errorf("invalid datetime %q: %w", s, err)
+	}
+	return nil
+}
+
+// reports whether s has the shape of a lexicon datetime, leaving the ranges of its fields to time.Parse
+func isDatetime(s string) bool {
+	const layout = "0000-00-00T00:00:00"
+	if len(s) < len(layout) || !matchDigits(s[:len(layout)], layout) {
+		return false
+	}
+	rest := s[len(layout):]
+	if frac, ok := strings.CutPrefix(rest, "."); ok {
+		n := 0
+		for n < len(frac) && isDigit(frac[n]) {
+			n++
+		}
+		if n == 0 || n > 20 {
+			return false
+		}
+		rest = frac[n:]
+	}
+	if rest == "Z" {
+		return true
+	}
+	return len(rest) == 6 && (rest[0] == '+' || rest[0] == '-') && matchDigits(rest[1:], "00:00")
+}
+
+// reports whether s matches a layout where "0" stands for any digit and other characters for themselves
+func matchDigits(s, layout string) bool {
+	if len(s) != len(layout) {
+		return false
+	}
+	for i := range len(s) {
+		if layout[i] == '0' && !isDigit(s[i]) || layout[i] != '0' && s[i] != layout[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Checks that s looks like a BCP 47 language tag, such as "en" or "pt-BR". The subtags are not checked against
+// the registry.
+func ValidateLanguage(s string) error {
+	if len(s) > MaxLanguageLength {
+		return fmt.Errorf("invalid language %q", s)
+	}
+	// a primary subtag of 2 or 3 lowercase letters, or "i" for grandfathered tags, then alphanumeric subtags
+	primary, subtags, hasSubtags := strings.Cut(s, "-")
+	if primary != "i" && (len(primary) < 2 || len(primary) > 3 || strings.IndexFunc(primary, func(r rune) bool {
+		return r > 0x7f || !isLower(byte(r))
+	}) >= 0) {
+		return fmt.Errorf("invalid language %q", s)
+	}
+	if !hasSubtags {
+		return nil
+	}
+	for subtag := range strings.SplitSeq(subtags, "-") {
+		if subtag == "" || strings.IndexFunc(subtag, func(r rune) bool {
+			return r > 0x7f || !isLetter(byte(r)) && !isDigit(byte(r))
+		}) >= 0 {
+			return fmt.Errorf("invalid language %q", s)
+		}
+	}
+	return nil
+}
+
+// Checks that s looks like a URI: a lowercase scheme followed by printable ASCII characters.
+func ValidateURI(s string) error {
+	scheme, rest, ok := strings.Cut(s, ":")
+	if len(s) > MaxURILength || !ok || scheme == "" || len(scheme) > 81 || rest == "" || !isLower(scheme[0]) {
+		return fmt.Errorf("invalid URI %q", s)
+	}
+	for i := 1; i < len(scheme); i++ {
+		if c := scheme[i]; !isLower(c) && c != '.' && c != '-' {
+			return fmt.Errorf("invalid URI %q", s)
+		}
+	}
+	for i := range len(rest) {
+		// printable ASCII other than space
+		if c := rest[i]; c <= ' ' || c > '~' {
+			return fmt.Errorf("invalid URI %q", s)
+		}
+	}
+	return nil
+}
+
+func isLower(c byte) bool {
+	return 'a' <= c && c <= 'z'
+}
+
+// Checks that s conforms to a lexicon string format, such as "did" or "record-key". Fails on unknown formats.
+func ValidateFormat(format, s string) error {
+	switch format {
+	case "at-identifier":
+		return ValidateATIdentifier(s)
+	case "at-uri":
+		return ValidateATURI(s)
+	case "cid":
+		if _, err := cid.Parse(s); err != nil {
+			return fmt.Errorf("invalid CID %q: %w", s, err)
+		}
+		return nil
+	case "datetime":
+		return ValidateDatetime(s)
+	case "did":
+		return ValidateDID(s)
+	case "handle":
+		return ValidateHandle(s)
+	case "language":
+		return ValidateLanguage(s)
+	case "nsid":
+		return ValidateNSID(s)
+	case "record-key":
+		return ValidateRecordKey(s)
+	case "tid":
+		if err := tid.Validate(s); err != nil {
+			return fmt.Errorf("invalid TID %q: %w", s, err)
+		}
+		return nil
+	case "uri":
+		return ValidateURI(s)
+	}
+	return fmt.Errorf("unknown string format %q", format)
+}
@@ -0,0 +1,90 @@
+// Package syntax validates the string formats of atproto identifiers.
+//
+// https://atproto.com/specs/lexicon#string-formats
+package syntax
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Maximum length of an NSID.
+const MaxNSIDLength = 317
+
+// Maximum length of a record key.
+const MaxRecordKeyLength = 512
+
+// Checks that s is a namespaced identifier, such as "app.bsky.feed.post": a reversed domain name of at least
+// two segments followed by a name.
+//
+// https://atproto.com/specs/nsid
+func ValidateNSID(s string) error {
+	if len(s) > MaxNSIDLength {
+		return fmt.Errorf("invalid NSID %q: longer than %d characters", s, MaxNSIDLength)
+	}
+	segments := strings.Split(s, ".")
+	if len(segments) < 3 {
+		return fmt.Errorf("invalid NSID %q: needs at least 3 segments", s)
+	}
+	authority, name := segments[:len(segments)-1], segments[len(segments)-1]
+	if len(s)-len(name)-1 > 253 {
+		return fmt.Errorf("invalid NSID %q: domain authority longer than 253 characters", s)
+	}
+	for i, seg := range authority {
+		if err := validateSegment(seg, i == 0, true); err != nil {
+			return fmt.Errorf("invalid NSID %q: %w", s, err)
+		}
+	}
+	if err := validateSegment(name, true, false); err != nil {
+		return fmt.Errorf("invalid NSID %q: name %w", s, err)
+	}
+	return nil
+}
+
+// checks an NSID segment of ASCII letters and digits, and hyphens in the middle of authority segments
+func validateSegment(seg string, letterFirst, hyphens bool) error {
+	if len(seg) == 0 || len(seg) > 63 {
+		return errors.New("segment must be 1 to 63 characters")
+	}
+	if letterFirst && isDigit(seg[0]) {
+		return fmt.Errorf("segment %q must not start with a digit", seg)
+	}
+	for i := range len(seg) {
+		c := seg[i]
+		switch {
+		case isLetter(c), isDigit(c):
+		case c == '-' && hyphens && i > 0 && i < len(seg)-1:
+		default:
+			return fmt.Errorf("segment %q has disallowed character %q", seg, c)
+		}
+	}
+	return nil
+}
+
+// Checks that s is a valid record key: 1 to 512 characters among ASCII letters, digits and "._:~-", other
+// than "." and "..".
+//
+// https://atproto.com/specs/record-key
+func ValidateRecordKey(s string) error {
+	if len(s) == 0 || len(s) > MaxRecordKeyLength {
+		return fmt.Errorf("invalid record key %q: must be 1 to %d characters", s, MaxRecordKeyLength)
+	}
+	if s == "." || s == ".." {
+		return fmt.Errorf("invalid record key %q", s)
+	}
+	for i := range len(s) {
+		if c := s[i]; !isLetter(c) && !isDigit(c) && strings.IndexByte("._:~-", c) < 0 {
+			return fmt.Errorf("invalid record key %q: disallowed character %q", s, c)
+		}
+	}
+	return nil
+}
+
+func isLetter(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
@@ -0,0 +1,75 @@
+package syntax
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNSID(t *testing.T) {
+	for _, s := range []string{"app.bsky.feed.post", "com.example.fooBar", "net.users.bob.ping", "a-0.b-1.c", "com.example.f00"} {
+		if err := ValidateNSID(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, s := range []string{"", "com.example", "com.example.", "com..example.foo", "com.example.3", "com.exa💩ple.thing", "com.example.foo-bar", "1com.example.foo", "com.-example.foo", "com.example-.foo", "com." + strings.Repeat("a", 64) + ".foo"} {
+		if err := ValidateNSID(s); err == nil {
+			t.Fatalf("expected error for %q", s)
+		}
+	}
+}
+
+func TestRecordKey(t *testing.T) {
+	for _, s := range []string{"3jzfcijpj2z2a", "self", "example.com", "~1.2-3_", "dHJ1ZQ", "pre:fix", "_", strings.Repeat("a", 512)} {
+		if err := ValidateRecordKey(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, s := range []string{"", ".", "..", "alpha/beta", "@handle", "any space", "#extra", "dHJ1ZQ==", strings.Repeat("a", 513)} {
+		if err := ValidateRecordKey(s); err == nil {
+			t.Fatalf("expected error for %q", s)
+		}
+	}
+}
+
+func TestFormats(t *testing.T) {
+	for format, values := range map[string][]string{
+		"did":           {"did:plc:ewvi7nxzyoun6zhxrhs64oiz", "did:web:example.com", "did:web:localhost%3A1234", "did:a:b"},
+		"handle":        {"alice.bsky.social", "xn--ls8h.test", "a.b", "john.test-site.com", "ALICE.example.COM"},
+		"at-identifier": {"alice.bsky.social", "did:plc:ewvi7nxzyoun6zhxrhs64oiz"},
+		"at-uri": {"at://did:plc:abc", "at://alice.bsky.social/app.bsky.feed.post/3jzfcijpj2z2a",
+			"at://did:plc:abc/app.bsky.feed.post", "at://did:plc:abc/app.bsky.feed.post/self#/text"},
+		"datetime": {"1985-04-12T23:20:50.123Z", "1985-04-12T23:20:50Z", "1985-04-12T23:20:50.123+00:00",
+			"1985-04-12T23:20:50.123456789-07:00"},
+		"language": {"en", "pt-BR", "i-default", "zh-Hant-TW"},
+		"uri":      {"https://example.com/path?q=1", "at://did:plc:abc", "did:web:example.com", "mailto:a@b.c"},
+		"tid":      {"3jzfcijpj2z2a"},
+		"cid":      {"bafyreiclp443lavogvhj3d2ob2cxbfuscni2k5jk7bebjzg7khl3esabwq"},
+	} {
+		for _, s := range values {
+			if err := ValidateFormat(format, s); err != nil {
+				t.Errorf("%s: %v", format, err)
+			}
+		}
+	}
+	for format, values := range map[string][]string{
+		"did":           {"did:plc:", "DID:plc:abc", "did:PLC:abc", "did:plc:abc:", "did:plc:a b", "did:plc:abc%"},
+		"handle":        {"alice", "alice.", ".alice.com", "alice.123", "-alice.com", "al ice.com", strings.Repeat("a.", 127) + "com"},
+		"at-identifier": {"123", "did:plc:"},
+		"at-uri": {"123", "at://", "at://did:plc:abc/", "at://did:plc:abc/app.bsky.feed.post/", "https://example.com",
+			"at://did:plc:abc/app.bsky.feed.post/a/b", "at://did:plc:abc#text"},
+		"datetime": {"123", "1985-04-12", "1985-04-12t23:20:50Z", "1985-04-12T23:20Z", "1985-04-12T23:20:50",
+			"1985-04-12T23:20:50.123-00:00", "1985-13-12T23:20:50Z", "1985-04-12T23:20:50.Z", "1985-04-12T23:20:50+0000",
+			"1985-04-12T23:20:50." + strings.Repeat("1", 21) + "Z"},
+		"language": {"123", "english", "en_US", "-en", "en-", "EN", "e1"},
+		"uri":      {"123", "example.com", "https://exa mple.com", "Https://example.com", "https:"},
+		"tid":      {"000", "3jzfcijpj2z2", "zjzfcijpj2z2a", "3jzfcijpj2z2A"},
+		"cid":      {"123"},
+		"colour":   {"red"},
+	} {
+		for _, s := range values {
+			if err := ValidateFormat(format, s); err == nil {
+				t.Errorf("%s: expected error for %q", format, s)
+			}
+		}
+	}
+}
@@ -1,14 +1,16 @@
 package tid
 
 import (
-	"errors"
-	"regexp"
+	"fmt"
 	"strings"
 	"sync"
 	"time"
+
+	"github.com/notjuliet/grove/errdefs"
 )
 
-var tidRegex = regexp.MustCompile(`^[234567abcdefghij][234567abcdefghijklmnopqrstuvwxyz]{12}$`)
+// Returned for malformed TIDs, matching errdefs.ErrMalformed.
+var ErrInvalid = errdefs.New(errdefs.ErrMalformed, "invalid tid")
 
 const b32Sorted = "234567abcdefghijklmnopqrstuvwxyz"
 
@@ -53,10 +55,16 @@ func Parse(s string) (timestamp, clockId uint, err error) {
 // Validates a TID string.
 func Validate(s string) error {
 	if len(s) != 13 {
-		return errors.New("invalid tid length")
+		return fmt.Errorf("%w: %d characters, expected 13", ErrInvalid, len(s))
+	}
+	// the first character only holds 4 bits, as the top bit is always zero
+	if strings.IndexByte(b32Sorted[:16], s[0]) < 0 {
+		return fmt.Errorf("%w: unexpected first character %q", ErrInvalid, s[0])
 	}
-	if !tidRegex.MatchString(s) {
-		return errors.New("invalid tid format")
+	for i := 1; i < len(s); i++ {
+		if strings.IndexByte(b32Sorted, s[i]) < 0 {
+			return fmt.Errorf("%w: unexpected character %q", ErrInvalid, s[i])
+		}
 	}
 	return nil
 }
@@ -1,7 +1,10 @@
 package tid
 
 import (
+	"errors"
 	"testing"
+
+	"github.com/notjuliet/grove/errdefs"
 )
 
 func TestCreate(t *testing.T) {
@@ -26,4 +29,13 @@ func TestParse(t *testing.T) {
 			t.Fatal("invalid clockId")
 		}
 	})
+
+	t.Run("invalid", func(t *testing.T) {
+		for _, s := range []string{"", "222236tg2qm2", "z22236tg2qm22", "222236tg2qm2!"} {
+			_, _, err := Parse(s)
+			if !errors.Is(err, ErrInvalid) || !errors.Is(err, errdefs.ErrMalformed) {
+				t.Fatalf("expected invalid tid error for %q, got %v", s, err)
+			}
+		}
+	})
 }
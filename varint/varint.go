@@ -0,0 +1,81 @@
+package varint
+
+import (
+	"errors"
+	"io"
+)
+
+// Maximum encoded length of an unsigned varint, per the multiformats specification (63 bits of payload).
+//
+// https://github.com/multiformats/unsigned-varint
+const MaxLen = 9
+
+var (
+	ErrTruncated  = errors.New("varint truncated")
+	ErrOverflow   = errors.New("varint exceeds 9 bytes")
+	ErrNotMinimal = errors.New("varint is not minimally encoded")
+)
+
+// Maximum value which can be encoded in MaxLen bytes.
+const MaxValue = 1<<63 - 1
+
+// Appends the varint encoding of v to b. Values above MaxValue are encoded anyway, but will not be
+// accepted by Read.
+func Append(b []byte, v uint64) []byte {
+	for v >= 0x80 {
+		b = append(b, byte(v)|0x80)
+		v >>= 7
+	}
+	return append(b, byte(v))
+}
+
+// Returns the encoded length of v.
+func Len(v uint64) int {
+	n := 1
+	for v >= 0x80 {
+		v >>= 7
+		n++
+	}
+	return n
+}
+
+// Decodes a varint from the start of b, returning the value and the number of bytes read.
+func Read(b []byte) (uint64, int, error) {
+	var v uint64
+	for i := range MaxLen {
+		if i >= len(b) {
+			return 0, 0, ErrTruncated
+		}
+		c := b[i]
+		v |= uint64(c&0x7f) << (7 * i)
+		if c < 0x80 {
+			if c == 0 && i > 0 {
+				return 0, 0, ErrNotMinimal
+			}
+			return v, i + 1, nil
+		}
+	}
+	return 0, 0, ErrOverflow
+}
+
+// Decodes a varint from a byte stream. Returns io.EOF only if the stream ends before the first byte.
+func ReadFrom(r io.ByteReader) (uint64, error) {
+	var v uint64
+	for i := range MaxLen {
+		c, err := r.ReadByte()
+		if err != nil {
+			if err == io.EOF && i > 0 {
+				return 0, ErrTruncated
+			}
+			return 0, err
+		}
+		v |= uint64(c&0x7f) << (7 * i)
+		if c < 0x80 {
+			if c == 0 && i > 0 {
+				return 0, ErrNotMinimal
+			}
+			return v, nil
+		}
+	}
+	return 0, ErrOverflow
+}
@@ -0,0 +1,69 @@
+package varint
+
+import (
+	"bytes"
+	"testing"
+)
+
+var vectors = []struct {
+	value   uint64
+	encoded []byte
+}{
+	{0, []byte{0x00}},
+	{1, []byte{0x01}},
+	{127, []byte{0x7f}},
+	{128, []byte{0x80, 0x01}},
+	{255, []byte{0xff, 0x01}},
+	{300, []byte{0xac, 0x02}},
+	{16384, []byte{0x80, 0x80, 0x01}},
+	{MaxValue, []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x7f}},
+}
+
+func TestVarint(t *testing.T) {
+	for _, v := range vectors {
+		enc := Append(nil, v.value)
+		if !bytes.Equal(enc, v.encoded) {
+			t.Fatalf("invalid encoding of %d", v.value)
+		}
+		if Len(v.value) != len(enc) {
+			t.Fatalf("invalid length of %d", v.value)
+		}
+		dec, n, err := Read(enc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if dec != v.value || n != len(enc) {
+			t.Fatalf("invalid decoding of %d", v.value)
+		}
+		dec, err = ReadFrom(bytes.NewReader(enc))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if dec != v.value {
+			t.Fatalf("invalid stream decoding of %d", v.value)
+		}
+	}
+}
+
+func TestInvalid(t *testing.T) {
+	t.Run("not minimal", func(t *testing.T) {
+		if _, _, err := Read([]byte{0x81, 0x00}); err != ErrNotMinimal {
+			t.Fatal("expected not minimal error")
+		}
+	})
+
+	t.Run("truncated", func(t *testing.T) {
+		if _, _, err := Read([]byte{0x81}); err != ErrTruncated {
+			t.Fatal("expected truncated error")
+		}
+		if _, err := ReadFrom(bytes.NewReader([]byte{0x81})); err != ErrTruncated {
+			t.Fatal("expected truncated error")
+		}
+	})
+
+	t.Run("overflow", func(t *testing.T) {
+		if _, _, err := Read(bytes.Repeat([]byte{0xff}, 10)); err != ErrOverflow {
+			t.Fatal("expected overflow error")
+		}
+	})
+}
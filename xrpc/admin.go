@@ -0,0 +1,60 @@
+package xrpc
+
+import (
+	"context"
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// Namespace of the methods reserved to the operator of a service.
+const AdminNamespace = "com.atproto.admin."
+
+// User name of admin credentials, sent with HTTP basic authentication.
+const AdminUser = "admin"
+
+// Authorizer sending the admin credentials of a service, for operator tooling calling admin methods.
+type AdminAuth struct {
+	Password string
+}
+
+func (a AdminAuth) Authorize(ctx context.Context, req *http.Request) error {
+	req.SetBasicAuth(AdminUser, a.Password)
+	return nil
+}
+
+// Reports whether a method is reserved to admins: the methods of the com.atproto.admin namespace.
+func IsAdminMethod(nsid string) bool {
+	return strings.HasPrefix(nsid, AdminNamespace)
+}
+
+type adminKey struct{}
+
+// Reports whether the request being handled was authenticated with admin credentials, for methods which are
+// not admin-only but reveal more to admins, such as taken down records.
+func IsAdmin(ctx context.Context) bool {
+	admin, _ := ctx.Value(adminKey{}).(bool)
+	return admin
+}
+
+// Wraps a handler so that it requires admin credentials, failing with status 401 and AuthRequired otherwise.
+func RequireAdmin(password string, h HandlerFunc) HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) (any, error) {
+		r, ok := authenticateAdmin(password, r)
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Basic realm="admin"`)
+			return nil, &Error{StatusCode: http.StatusUnauthorized, Name: "AuthRequired",
+				Message: "admin authentication required"}
+		}
+		return h(w, r)
+	}
+}
+
+// checks the admin credentials of a request, returning it with a context marked as admin if they are valid
+func authenticateAdmin(password string, r *http.Request) (*http.Request, bool) {
+	user, pass, ok := r.BasicAuth()
+	if !ok || password == "" || user != AdminUser || subtle.ConstantTimeCompare([]byte(pass), []byte(password)) != 1 {
+		return r, false
+	}
+	return r.WithContext(context.WithValue(r.Context(), adminKey{}, true)), true
+}
@@ -0,0 +1,88 @@
+package xrpc
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/notjuliet/grove/repo"
+)
+
+// Categories of XRPC errors, matched with errors.Is against an *Error.
+var (
+	// The request lacks valid credentials or is not permitted with them: status 401 or 403, or an
+	// ExpiredToken or InvalidToken error.
+	ErrAuth = errors.New("xrpc authentication error")
+	// The rate limit of the service was exceeded: status 429.
+	ErrRateLimited = errors.New("xrpc rate limit exceeded")
+	// The service or a service it depends on failed, which retrying may fix: status 5xx.
+	ErrUpstream = errors.New("xrpc upstream error")
+	// The request is invalid, such as a missing record or a malformed parameter: any other 4xx status.
+	ErrInvalidRequest = errors.New("xrpc invalid request")
+)
+
+// Error response of an XRPC method.
+type Error struct {
+	NSID       string
+	StatusCode int
+	// Error name, such as "InvalidRequest" or "RecordNotFound", empty if the response body had none.
+	Name    string
+	Message string
+	// Headers of the response, such as Retry-After.
+	Header http.Header
+
+	// request which failed, for renewing its credentials
+	request *http.Request
+	// whether the authorizer asked for the request to be sent again
+	resend bool
+}
+
+func (e *Error) Error() string {
+	msg := fmt.Sprintf("status %d", e.StatusCode)
+	if e.NSID != "" {
+		msg = fmt.Sprintf("%s failed with status %d", e.NSID, e.StatusCode)
+	}
+	if e.Name != "" {
+		msg += ": " + e.Name
+	}
+	if e.Message != "" {
+		msg += ": " + e.Message
+	}
+	return msg
+}
+
+// Returns the category of the error: ErrAuth, ErrRateLimited, ErrUpstream or ErrInvalidRequest.
+func (e *Error) Category() error {
+	switch {
+	case e.StatusCode == http.StatusUnauthorized || e.StatusCode == http.StatusForbidden ||
+		e.Name == "ExpiredToken" || e.Name == "InvalidToken" || e.Name == "AuthRequired":
+		return ErrAuth
+	case e.StatusCode == http.StatusTooManyRequests || e.Name == "RateLimitExceeded":
+		return ErrRateLimited
+	case e.StatusCode >= 500:
+		return ErrUpstream
+	default:
+		return ErrInvalidRequest
+	}
+}
+
+// Reports whether target is the category of the error, or repo.ErrSwapMismatch for an InvalidSwap error.
+func (e *Error) Is(target error) bool {
+	return target == e.Category() || target == repo.ErrSwapMismatch && e.Name == "InvalidSwap"
+}
+
+// reads the error of a failed response
+func readError(nsid string, resp *http.Response) *Error {
+	e := &Error{NSID: nsid, StatusCode: resp.StatusCode, Header: resp.Header, request: resp.Request}
+	b, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBodySize))
+	var body struct {
+		Error   string `json:"error"`
+		Message string `json:"message"`
+	}
+	if json.Unmarshal(b, &body) == nil {
+		e.Name, e.Message = body.Error, body.Message
+	}
+	return e
+}
@@ -0,0 +1,85 @@
+package xrpc
+
+import (
+	"context"
+	"fmt"
+	"iter"
+)
+
+// Options of Paginate.
+type PageOptions struct {
+	// Cursor to start from, empty to start from the first page.
+	Cursor string
+	// Number of items requested per page. Zero uses the default of the service.
+	PageSize int
+	// Maximum number of items yielded. Zero means no limit.
+	Limit int
+}
+
+// Fetches a page of a cursored query of at most limit items, zero meaning the default of the service, starting
+// after cursor. Returns the items and the cursor of the next page, empty after the last page.
+type PageFunc[T any] func(ctx context.Context, cursor string, limit int) ([]T, string, error)
+
+// Iterates over the items of a cursored query, fetching pages as needed, each with the cursor returned with the
+// previous one. Iteration ends after the last page, which has no cursor, or once opts.Limit items were yielded,
+// and stops after the first non-nil error. A service returning the same cursor twice fails instead of looping.
+func Paginate[T any](ctx context.Context, opts PageOptions, fetch PageFunc[T]) iter.Seq2[T, error] {
+	return func(yield func(T, error) bool) {
+		var zero T
+		cursor, yielded := opts.Cursor, 0
+		for {
+			limit := opts.PageSize
+			if opts.Limit > 0 {
+				left := opts.Limit - yielded
+				if limit <= 0 || left < limit {
+					limit = left
+				}
+			}
+			items, next, err := fetch(ctx, cursor, limit)
+			if err != nil {
+				yield(zero, err)
+				return
+			}
+			for _, item := range items {
+				if !yield(item, nil) {
+					return
+				}
+				if yielded++; opts.Limit > 0 && yielded >= opts.Limit {
+					return
+				}
+			}
+			if next == "" {
+				return
+			}
+			if next == cursor {
+				yield(zero, fmt.Errorf("cursor %q did not advance", cursor))
+				return
+			}
+			cursor = next
+		}
+	}
+}
+
+// Iterates over the records of a collection, in reverse order of their keys unless reverse is set, see
+// Paginate.
+func (c *Client) ListAllRecords(ctx context.Context, did, collection string, reverse bool,
+	opts PageOptions) iter.Seq2[Record, error] {
+	return Paginate(ctx, opts, func(ctx context.Context, cursor string, limit int) ([]Record, string, error) {
+		out, err := c.ListRecords(ctx, did, collection, limit, cursor, reverse)
+		if err != nil {
+			return nil, "", err
+		}
+		return out.Records, out.Cursor, nil
+	})
+}
+
+// Iterates over the repositories hosted by the service, see Paginate.
+func (c *Client) ListAllRepos(ctx context.Context, opts PageOptions) iter.Seq2[ListedRepo, error] {
+	return Paginate(ctx, opts, func(ctx context.Context, cursor string, limit int) ([]ListedRepo, string, error) {
+		out, err := c.ListRepos(ctx, limit, cursor)
+		if err != nil {
+			return nil, "", err
+		}
+		return out.Repos, out.Cursor, nil
+	})
+}
@@ -0,0 +1,48 @@
+package xrpc
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/notjuliet/grove/identity"
+)
+
+// Header asking a PDS to forward a request to another service on behalf of the user.
+//
+// https://atproto.com/specs/xrpc#service-proxying
+const ProxyHeader = "atproto-proxy"
+
+// Service of an account, the value of the atproto-proxy header, such as "did:web:api.bsky.app#bsky_appview".
+type ServiceRef struct {
+	DID string
+	// Fragment of the service entry in the DID document, without the leading '#'.
+	Fragment string
+}
+
+// Parses a service reference of the form "<did>#<fragment>".
+func ParseServiceRef(s string) (ServiceRef, error) {
+	did, fragment, ok := strings.Cut(s, "#")
+	if !ok || !strings.HasPrefix(did, "did:") || fragment == "" || strings.ContainsAny(fragment, "# ") {
+		return ServiceRef{}, fmt.Errorf("invalid service reference %q", s)
+	}
+	return ServiceRef{DID: did, Fragment: fragment}, nil
+}
+
+func (s ServiceRef) String() string {
+	return s.DID + "#" + s.Fragment
+}
+
+// Resolves the endpoint URL of a service through the DID document of its account, as a PDS does to forward
+// proxied requests.
+func (s ServiceRef) Resolve(ctx context.Context, dir identity.Directory) (string, error) {
+	ident, err := dir.LookupDID(ctx, s.DID)
+	if err != nil {
+		return "", err
+	}
+	svc, ok := ident.Services[s.Fragment]
+	if !ok || svc.URL == "" {
+		return "", fmt.Errorf("%s has no service #%s", s.DID, s.Fragment)
+	}
+	return svc.URL, nil
+}
@@ -0,0 +1,116 @@
+package xrpc
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Rate limit of a service, from the ratelimit-* headers of its responses.
+type RateLimit struct {
+	// Number of requests allowed in a window.
+	Limit int
+	// Number of requests left in the current window.
+	Remaining int
+	// When the current window ends.
+	Reset time.Time
+	// Policy as sent by the service, such as "3000;w=300" for 3000 requests per 5 minutes. May be empty.
+	Policy string
+}
+
+// values of ratelimit-reset above this are unix timestamps rather than a number of seconds
+const resetTimestampThreshold = 1e9
+
+// Parses the ratelimit-limit, ratelimit-remaining, ratelimit-reset and ratelimit-policy headers of a response.
+// The reset may be either a unix timestamp, as sent by Bluesky services, or a number of seconds. Returns false
+// if the headers are missing or invalid.
+func ParseRateLimit(h http.Header) (*RateLimit, bool) {
+	limit, err := strconv.Atoi(h.Get("RateLimit-Limit"))
+	if err != nil {
+		return nil, false
+	}
+	remaining, err := strconv.Atoi(h.Get("RateLimit-Remaining"))
+	if err != nil {
+		return nil, false
+	}
+	reset, err := strconv.ParseInt(h.Get("RateLimit-Reset"), 10, 64)
+	if err != nil || reset < 0 {
+		return nil, false
+	}
+	rl := &RateLimit{Limit: limit, Remaining: remaining, Policy: h.Get("RateLimit-Policy")}
+	if reset > resetTimestampThreshold {
+		rl.Reset = time.Unix(reset, 0)
+	} else {
+		rl.Reset = time.Now().Add(time.Duration(reset) * time.Second)
+	}
+	return rl, true
+}
+
+// Returns the rate limit reported by the response, if any.
+func (r *Response) RateLimit() (*RateLimit, bool) {
+	return ParseRateLimit(r.Header)
+}
+
+// Returns the rate limit reported by the error response, if any.
+func (e *Error) RateLimit() (*RateLimit, bool) {
+	return ParseRateLimit(e.Header)
+}
+
+// Returns how long to wait before the next request to stay under a rate limit: until the reset once no
+// request is left, or an even share of the time left once fewer than a tenth of the requests are left, so that
+// a backfill slows down instead of hitting the limit.
+func (rl *RateLimit) delay(now time.Time) time.Duration {
+	left := rl.Reset.Sub(now)
+	switch {
+	case left <= 0:
+		return 0
+	case rl.Remaining <= 0:
+		return left
+	case rl.Remaining*10 < rl.Limit:
+		return left / time.Duration(rl.Remaining+1)
+	default:
+		return 0
+	}
+}
+
+// remembers the latest rate limit reported by the service
+func (c *Client) observeRateLimit(h http.Header) {
+	if !c.Throttle {
+		return
+	}
+	rl, ok := ParseRateLimit(h)
+	if !ok {
+		return
+	}
+	c.mtx.Lock()
+	c.rateLimit = rl
+	c.mtx.Unlock()
+}
+
+// waits as required by the latest rate limit, when throttling
+func (c *Client) throttle(ctx context.Context) error {
+	if !c.Throttle {
+		return nil
+	}
+	c.mtx.Lock()
+	var delay time.Duration
+	if c.rateLimit != nil {
+		delay = c.rateLimit.delay(time.Now())
+		// the request is about to use one of the remaining requests
+		c.rateLimit.Remaining--
+	}
+	c.mtx.Unlock()
+	if delay <= 0 {
+		return nil
+	}
+	if c.Logger != nil {
+		c.Logger.Debug("throttling xrpc request", "host", c.Host, "delay", delay)
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(delay):
+		return nil
+	}
+}
@@ -0,0 +1,353 @@
+package xrpc
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+
+	"github.com/notjuliet/grove/blob"
+	"github.com/notjuliet/grove/cid"
+	"github.com/notjuliet/grove/data"
+	"github.com/notjuliet/grove/repo"
+)
+
+// NSIDs of the com.atproto.repo methods.
+const (
+	RepoCreateRecord = "com.atproto.repo.createRecord"
+	RepoPutRecord    = "com.atproto.repo.putRecord"
+	RepoDeleteRecord = "com.atproto.repo.deleteRecord"
+	RepoApplyWrites  = "com.atproto.repo.applyWrites"
+	RepoGetRecord    = "com.atproto.repo.getRecord"
+	RepoListRecords  = "com.atproto.repo.listRecords"
+	RepoUploadBlob   = "com.atproto.repo.uploadBlob"
+)
+
+// Options of the write methods.
+type WriteOptions struct {
+	// CID of the commit the write was prepared against, which must still be the latest commit of the
+	// repository. A mismatch fails with an error matching repo.ErrSwapMismatch.
+	SwapCommit *cid.Cid
+	// Whether the service validates records against their lexicon: nil lets the service decide, false skips
+	// validation, and true requires a known lexicon.
+	Validate *bool
+}
+
+// Commit created by a write.
+type CommitMeta struct {
+	Cid cid.Cid
+	Rev string
+}
+
+// Result of a record write.
+type WriteResult struct {
+	// URI and CID of the written record, empty for deletes.
+	URI string
+	Cid cid.Cid
+	// Commit of the write, nil if the service did not report it or the write was a no-op.
+	Commit *CommitMeta
+	// "valid" if the record was validated against its lexicon, "unknown" if the lexicon is unknown.
+	ValidationStatus string
+}
+
+// Record of a repository, with its value in the data model: links are cid.CidLink, bytes are []byte and
+// integers are int64.
+type Record struct {
+	URI   string
+	Cid   cid.Cid
+	Value map[string]any
+}
+
+// Page of the records of a collection, as returned by ListRecords.
+type ListRecordsOutput struct {
+	Cursor  string
+	Records []Record
+}
+
+// Creates a record, with a fresh TID as its key if rkey is empty. opts may be nil.
+func (c *Client) CreateRecord(ctx context.Context, did, collection, rkey string, record map[string]any,
+	opts *WriteOptions) (*WriteResult, error) {
+	in := writeInput(opts)
+	in["repo"], in["collection"], in["record"] = did, collection, data.ToJSON(record)
+	if rkey != "" {
+		in["rkey"] = rkey
+	}
+	return c.writeRecord(ctx, RepoCreateRecord, in)
+}
+
+// Creates or replaces a record. swapRecord is the CID the record must have before the write, nil skipping the
+// check; a mismatch fails with an error matching repo.ErrSwapMismatch. opts may be nil.
+func (c *Client) PutRecord(ctx context.Context, did, collection, rkey string, record map[string]any,
+	swapRecord *cid.Cid, opts *WriteOptions) (*WriteResult, error) {
+	in := writeInput(opts)
+	in["repo"], in["collection"], in["rkey"], in["record"] = did, collection, rkey, data.ToJSON(record)
+	if swapRecord != nil {
+		in["swapRecord"] = swapRecord.String()
+	}
+	return c.writeRecord(ctx, RepoPutRecord, in)
+}
+
+// Deletes a record, like PutRecord for swapRecord and opts. Deleting a missing record succeeds without a
+// commit.
+func (c *Client) DeleteRecord(ctx context.Context, did, collection, rkey string, swapRecord *cid.Cid,
+	opts *WriteOptions) (*CommitMeta, error) {
+	in := writeInput(opts)
+	delete(in, "validate")
+	in["repo"], in["collection"], in["rkey"] = did, collection, rkey
+	if swapRecord != nil {
+		in["swapRecord"] = swapRecord.String()
+	}
+	var out struct {
+		Commit *wireCommit `json:"commit"`
+	}
+	if err := c.Procedure(ctx, RepoDeleteRecord, nil, in, &out); err != nil {
+		return nil, err
+	}
+	return out.Commit.meta()
+}
+
+// Applies writes as a single commit, all or nothing, returning one result per write. The batch may have a
+// SwapCommit, but its writes must not have a SwapRecord, which applyWrites does not support. opts may be nil;
+// its SwapCommit, if set, overrides that of the batch.
+func (c *Client) ApplyWrites(ctx context.Context, did string, batch repo.WriteBatch,
+	opts *WriteOptions) ([]WriteResult, *CommitMeta, error) {
+	in := writeInput(opts)
+	in["repo"] = did
+	if _, ok := in["swapCommit"]; !ok && batch.SwapCommit != nil {
+		in["swapCommit"] = batch.SwapCommit.String()
+	}
+	writes := make([]map[string]any, len(batch.Writes))
+	for i, w := range batch.Writes {
+		if w.SwapRecord != nil {
+			return nil, nil, fmt.Errorf("write %d has a swap record, which applyWrites does not support", i)
+		}
+		write := map[string]any{"$type": RepoApplyWrites + "#" + string(w.Action), "collection": w.Collection}
+		if w.RKey != "" {
+			write["rkey"] = w.RKey
+		}
+		if w.Action != repo.ActionDelete {
+			write["value"] = data.ToJSON(w.Record)
+		}
+		writes[i] = write
+	}
+	in["writes"] = writes
+
+	var out struct {
+		Commit  *wireCommit  `json:"commit"`
+		Results []wireRecord `json:"results"`
+	}
+	if err := c.Procedure(ctx, RepoApplyWrites, nil, in, &out); err != nil {
+		return nil, nil, err
+	}
+	commit, err := out.Commit.meta()
+	if err != nil {
+		return nil, nil, err
+	}
+	results := make([]WriteResult, len(out.Results))
+	for i, r := range out.Results {
+		results[i] = WriteResult{URI: r.URI, Commit: commit, ValidationStatus: r.ValidationStatus}
+		if r.Cid != "" {
+			if results[i].Cid, err = cid.Parse(r.Cid); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+	return results, commit, nil
+}
+
+// Returns the latest version of a record. A missing record fails with an error named RecordNotFound.
+func (c *Client) GetRecord(ctx context.Context, did, collection, rkey string) (*Record, error) {
+	var out wireRecord
+	params := Params{"repo": did, "collection": collection, "rkey": rkey}
+	if err := c.Query(ctx, RepoGetRecord, params, &out); err != nil {
+		return nil, err
+	}
+	return out.record()
+}
+
+// Returns a page of at most limit records of a collection, starting after cursor, in reverse order of their
+// keys unless reverse is set. A zero limit and an empty cursor use the defaults of the service.
+func (c *Client) ListRecords(ctx context.Context, did, collection string, limit int, cursor string,
+	reverse bool) (*ListRecordsOutput, error) {
+	params := Params{"repo": did, "collection": collection}
+	if limit > 0 {
+		params["limit"] = limit
+	}
+	if cursor != "" {
+		params["cursor"] = cursor
+	}
+	if reverse {
+		params["reverse"] = true
+	}
+	var out struct {
+		Cursor  string       `json:"cursor"`
+		Records []wireRecord `json:"records"`
+	}
+	if err := c.Query(ctx, RepoListRecords, params, &out); err != nil {
+		return nil, err
+	}
+	records := make([]Record, len(out.Records))
+	for i, r := range out.Records {
+		rec, err := r.record()
+		if err != nil {
+			return nil, err
+		}
+		records[i] = *rec
+	}
+	return &ListRecordsOutput{Cursor: out.Cursor, Records: records}, nil
+}
+
+// Options of UploadBlob.
+type UploadOptions struct {
+	// Maximum size in bytes of the blob, beyond which the upload is aborted with an error matching
+	// blob.ErrTooLarge. Zero means no limit.
+	MaxSize int64
+	// Hashes the blob as it is sent, and checks that the CID in the returned reference matches it.
+	VerifyCid bool
+}
+
+// Uploads a blob, streaming it from r, and returns the reference to it to be set in a record. opts may be nil.
+func (c *Client) UploadBlob(ctx context.Context, r io.Reader, mimeType string, opts *UploadOptions) (blob.BlobRef,
+	error) {
+	if opts == nil {
+		opts = &UploadOptions{}
+	}
+	body := &uploadReader{r: r, limit: opts.MaxSize}
+	if opts.VerifyCid {
+		body.h = sha256.New()
+	}
+	var b []byte
+	_, err := c.Do(ctx, &Request{Method: http.MethodPost, NSID: RepoUploadBlob, Input: body, Encoding: mimeType,
+		Output: &b})
+	if body.tooLarge() {
+		return blob.BlobRef{}, fmt.Errorf("%w: more than %d bytes", blob.ErrTooLarge, opts.MaxSize)
+	}
+	if err != nil {
+		return blob.BlobRef{}, err
+	}
+	var out struct {
+		Blob *blob.BlobRef `json:"blob"`
+	}
+	if err := json.Unmarshal(b, &out); err != nil {
+		return blob.BlobRef{}, fmt.Errorf("decoding %s output: %w", RepoUploadBlob, err)
+	}
+	if out.Blob == nil {
+		return blob.BlobRef{}, fmt.Errorf("%s output has no blob", RepoUploadBlob)
+	}
+	if body.h != nil {
+		want, err := cid.FromDigest(cid.CodecRaw, body.h.Sum(nil))
+		if err != nil {
+			return blob.BlobRef{}, err
+		}
+		if got := out.Blob.Cid(); !bytes.Equal(got.Bytes, want.Bytes) {
+			return blob.BlobRef{}, fmt.Errorf("uploaded blob has CID %s, service returned %s", want, got)
+		}
+	}
+	return *out.Blob, nil
+}
+
+// counts and optionally hashes the bytes of an upload, failing once it exceeds its maximum size
+type uploadReader struct {
+	r     io.Reader
+	h     hash.Hash
+	n     int64
+	limit int64
+}
+
+func (u *uploadReader) Read(p []byte) (int, error) {
+	n, err := u.r.Read(p)
+	u.n += int64(n)
+	if u.h != nil {
+		u.h.Write(p[:n])
+	}
+	if u.tooLarge() {
+		return n, blob.ErrTooLarge
+	}
+	return n, err
+}
+
+func (u *uploadReader) tooLarge() bool {
+	return u.limit > 0 && u.n > u.limit
+}
+
+func writeInput(opts *WriteOptions) map[string]any {
+	in := map[string]any{}
+	if opts == nil {
+		return in
+	}
+	if opts.SwapCommit != nil {
+		in["swapCommit"] = opts.SwapCommit.String()
+	}
+	if opts.Validate != nil {
+		in["validate"] = *opts.Validate
+	}
+	return in
+}
+
+func (c *Client) writeRecord(ctx context.Context, nsid string, in map[string]any) (*WriteResult, error) {
+	var out struct {
+		wireRecord
+		Commit *wireCommit `json:"commit"`
+	}
+	if err := c.Procedure(ctx, nsid, nil, in, &out); err != nil {
+		return nil, err
+	}
+	rc, err := cid.Parse(out.Cid)
+	if err != nil {
+		return nil, err
+	}
+	commit, err := out.Commit.meta()
+	if err != nil {
+		return nil, err
+	}
+	return &WriteResult{URI: out.URI, Cid: rc, Commit: commit, ValidationStatus: out.ValidationStatus}, nil
+}
+
+// commit metadata as encoded in JSON
+type wireCommit struct {
+	Cid string `json:"cid"`
+	Rev string `json:"rev"`
+}
+
+func (w *wireCommit) meta() (*CommitMeta, error) {
+	if w == nil {
+		return nil, nil
+	}
+	c, err := cid.Parse(w.Cid)
+	if err != nil {
+		return nil, err
+	}
+	return &CommitMeta{Cid: c, Rev: w.Rev}, nil
+}
+
+// record reference or record, as encoded in JSON
+type wireRecord struct {
+	URI              string          `json:"uri"`
+	Cid              string          `json:"cid"`
+	ValidationStatus string          `json:"validationStatus"`
+	Value            json.RawMessage `json:"value"`
+}
+
+func (w *wireRecord) record() (*Record, error) {
+	rec := &Record{URI: w.URI}
+	if w.Cid != "" {
+		c, err := cid.Parse(w.Cid)
+		if err != nil {
+			return nil, err
+		}
+		rec.Cid = c
+	}
+	v, err := data.UnmarshalJSON(w.Value)
+	if err != nil {
+		return nil, fmt.Errorf("decoding record %s: %w", w.URI, err)
+	}
+	if rec.Value, _ = v.(map[string]any); rec.Value == nil {
+		return nil, errors.New("record value is not an object")
+	}
+	return rec, nil
+}
@@ -0,0 +1,94 @@
+package xrpc
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Retry policy of a client. Idempotent requests are retried after transport failures, such as refused
+// connections and timeouts, and after errors matching ErrRateLimited or ErrUpstream, except status 501. The
+// delay before each retry doubles from MinBackoff up to MaxBackoff, with random jitter, but is at least the
+// Retry-After delay requested by the service.
+//
+// Queries are idempotent, while procedures are only retried if their request is marked Idempotent. Requests
+// whose input is an io.Reader are never retried, as their input cannot be sent again.
+type RetryPolicy struct {
+	// Maximum number of attempts, including the first. Zero means 3.
+	MaxAttempts int
+	// Zero means 500ms and 30s.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// Longest Retry-After delay honored; requests asked to wait longer fail with the error of the last attempt.
+	// Zero means 1 minute.
+	MaxRetryAfter time.Duration
+}
+
+// Returns the delay before the next attempt of a request which failed with err, or false if it must not be
+// retried. A nil policy never retries.
+func (p *RetryPolicy) backoff(ctx context.Context, req *Request, attempt int, err error) (time.Duration, bool) {
+	if p == nil || err == nil || ctx.Err() != nil || attempt >= cmp.Or(p.MaxAttempts, 3) {
+		return 0, false
+	}
+	if _, ok := req.Input.(io.Reader); ok {
+		return 0, false
+	}
+	if req.Method != "" && req.Method != http.MethodGet && !req.Idempotent {
+		return 0, false
+	}
+
+	var delay time.Duration
+	var xerr *Error
+	var uerr *url.Error
+	switch {
+	case errors.As(err, &xerr):
+		if (!errors.Is(xerr, ErrRateLimited) && !errors.Is(xerr, ErrUpstream)) ||
+			xerr.StatusCode == http.StatusNotImplemented {
+			return 0, false
+		}
+		if after, ok := xerr.RetryAfter(); ok {
+			if after > cmp.Or(p.MaxRetryAfter, time.Minute) {
+				return 0, false
+			}
+			delay = after
+		}
+	case errors.As(err, &uerr):
+		// the request did not get a response
+	default:
+		return 0, false
+	}
+
+	backoff, maxBackoff := cmp.Or(p.MinBackoff, 500*time.Millisecond), cmp.Or(p.MaxBackoff, 30*time.Second)
+	for i := 1; i < attempt && backoff < maxBackoff; i++ {
+		backoff *= 2
+	}
+	backoff = min(backoff, maxBackoff)
+	// half of the backoff is randomized so that clients failing together do not retry together
+	backoff = backoff/2 + rand.N(backoff/2+1)
+	return max(delay, backoff), true
+}
+
+// Returns the delay the service asked to wait before retrying, from the Retry-After header of the response, or
+// from the reset of its rate limit once exceeded.
+func (e *Error) RetryAfter() (time.Duration, bool) {
+	v := e.Header.Get("Retry-After")
+	if v == "" {
+		if rl, ok := e.RateLimit(); ok && e.StatusCode == http.StatusTooManyRequests {
+			return max(time.Until(rl.Reset), 0), true
+		}
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(v); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second, true
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return max(time.Until(t), 0), true
+	}
+	return 0, false
+}
@@ -0,0 +1,285 @@
+package xrpc
+
+import (
+	"encoding"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"mime"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/notjuliet/grove/syntax"
+)
+
+// Default maximum size of a JSON input decoded by DecodeInput.
+const DefaultMaxInputSize = 1 << 20
+
+// Handles a call of an XRPC method. A non-nil output is written as JSON with status 200; a handler writing its
+// own response, such as a streamed CAR file, returns a nil output. An *Error is written as the error response
+// of the call, and any other error as an InternalServerError.
+type HandlerFunc func(w http.ResponseWriter, r *http.Request) (any, error)
+
+// Routes XRPC requests, whose path is "/xrpc/" followed by the NSID of the method, to the handler of the method.
+// Unknown methods fail with status 501 and MethodNotImplemented, as do requests outside of "/xrpc/".
+type ServeMux struct {
+	// Logger for handler errors which are not an *Error, if set.
+	Logger *slog.Logger
+	// Admin credentials of the service. Admin methods, see IsAdminMethod, require them and fail with status
+	// 401 otherwise, or always if empty. Handlers of other methods can check IsAdmin.
+	AdminPassword string
+
+	mtx     sync.RWMutex
+	methods map[string]method
+}
+
+type method struct {
+	httpMethod string
+	handler    HandlerFunc
+}
+
+// Registers the handler of a query, called with GET requests.
+func (m *ServeMux) HandleQuery(nsid string, h HandlerFunc) {
+	m.handle(nsid, http.MethodGet, h)
+}
+
+// Registers the handler of a procedure, called with POST requests.
+func (m *ServeMux) HandleProcedure(nsid string, h HandlerFunc) {
+	m.handle(nsid, http.MethodPost, h)
+}
+
+func (m *ServeMux) handle(nsid, httpMethod string, h HandlerFunc) {
+	if err := syntax.ValidateNSID(nsid); err != nil {
+		panic(err)
+	}
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	if _, ok := m.methods[nsid]; ok {
+		panic("xrpc: method " + nsid + " registered twice")
+	}
+	if m.methods == nil {
+		m.methods = map[string]method{}
+	}
+	m.methods[nsid] = method{httpMethod: httpMethod, handler: h}
+}
+
+func (m *ServeMux) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	nsid, _ := strings.CutPrefix(r.URL.Path, "/xrpc/")
+	m.mtx.RLock()
+	meth, ok := m.methods[nsid]
+	m.mtx.RUnlock()
+	if !ok {
+		WriteError(w, &Error{StatusCode: http.StatusNotImplemented, Name: "MethodNotImplemented",
+			Message: "method not implemented"})
+		return
+	}
+	if r.Method != meth.httpMethod && (meth.httpMethod != http.MethodGet || r.Method != http.MethodHead) {
+		w.Header().Set("Allow", meth.httpMethod)
+		WriteError(w, &Error{StatusCode: http.StatusMethodNotAllowed, Name: "InvalidRequest",
+			Message: fmt.Sprintf("incorrect HTTP method %s, expected %s", r.Method, meth.httpMethod)})
+		return
+	}
+
+	handler := meth.handler
+	if IsAdminMethod(nsid) {
+		handler = RequireAdmin(m.AdminPassword, handler)
+	} else if admin, ok := authenticateAdmin(m.AdminPassword, r); ok {
+		r = admin
+	}
+
+	rw := &responseWriter{ResponseWriter: w}
+	out, err := handler(rw, r)
+	if err != nil {
+		var xerr *Error
+		if !errors.As(err, &xerr) && m.Logger != nil {
+			m.Logger.Error("xrpc handler failed", "nsid", nsid, "error", err)
+		}
+		if rw.written {
+			// the response is already on its way, so the error cannot be reported anymore
+			return
+		}
+		WriteError(w, err)
+		return
+	}
+	if out != nil && !rw.written {
+		if err := WriteJSON(w, http.StatusOK, out); err != nil && m.Logger != nil {
+			m.Logger.Warn("xrpc output encoding failed", "nsid", nsid, "error", err)
+		}
+	}
+}
+
+// response writer remembering whether the handler started the response
+type responseWriter struct {
+	http.ResponseWriter
+	written bool
+}
+
+func (w *responseWriter) WriteHeader(status int) {
+	w.written = true
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *responseWriter) Write(b []byte) (int, error) {
+	w.written = true
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *responseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Writes a value as a JSON response with the given status.
+func WriteJSON(w http.ResponseWriter, status int, v any) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		WriteError(w, err)
+		return err
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	_, err = w.Write(b)
+	return err
+}
+
+// Writes the error response of a call. An *Error is written with its status, name and message, and any other
+// error as an InternalServerError without its details.
+func WriteError(w http.ResponseWriter, err error) {
+	var xerr *Error
+	if !errors.As(err, &xerr) {
+		xerr = &Error{StatusCode: http.StatusInternalServerError, Name: "InternalServerError",
+			Message: "internal server error"}
+	}
+	status := xerr.StatusCode
+	if status < 400 || status > 599 {
+		status = http.StatusInternalServerError
+	}
+	name := xerr.Name
+	if name == "" {
+		name = strings.ReplaceAll(http.StatusText(status), " ", "")
+	}
+	body, _ := json.Marshal(struct {
+		Error   string `json:"error"`
+		Message string `json:"message,omitempty"`
+	}{name, xerr.Message})
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+// Creates an error with status 400 and the name InvalidRequest, the generic error of a bad call.
+func InvalidRequest(format string, args ...any) *Error {
+	return &Error{StatusCode: http.StatusBadRequest, Name: "InvalidRequest", Message: fmt.Sprintf(format, args...)}
+}
+
+// Decodes the JSON input of a procedure into v, failing with an InvalidRequest error if the input is not JSON,
+// is larger than DefaultMaxInputSize, or does not match v.
+func DecodeInput(r *http.Request, v any) error {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "application/json" {
+		return InvalidRequest("expected application/json input")
+	}
+	body := http.MaxBytesReader(nil, r.Body, DefaultMaxInputSize)
+	if err := json.NewDecoder(body).Decode(v); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return &Error{StatusCode: http.StatusRequestEntityTooLarge, Name: "PayloadTooLarge",
+				Message: "input too large"}
+		}
+		return InvalidRequest("invalid input: %v", err)
+	}
+	return nil
+}
+
+// Binds the query parameters of a request to the fields of the struct pointed to by v which have a param tag,
+// such as `param:"limit"`, or `param:"did,required"` for a parameter which must be present. Fields may be
+// strings, booleans, integers, encoding.TextUnmarshalers, slices of those receiving repeated parameters, or
+// pointers to those, left nil when the parameter is absent. Fails with an InvalidRequest error.
+func BindParams(r *http.Request, v any) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("cannot bind parameters to %T", v)
+	}
+	rv = rv.Elem()
+	query := r.URL.Query()
+	for i := range rv.NumField() {
+		field := rv.Type().Field(i)
+		tag, ok := field.Tag.Lookup("param")
+		if !ok || !field.IsExported() {
+			continue
+		}
+		name, opt, _ := strings.Cut(tag, ",")
+		values, ok := query[name]
+		if !ok {
+			if opt == "required" {
+				return InvalidRequest("missing parameter %s", name)
+			}
+			continue
+		}
+		if err := bindParam(rv.Field(i), values); err != nil {
+			return InvalidRequest("invalid parameter %s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+var textUnmarshalerType = reflect.TypeFor[encoding.TextUnmarshaler]()
+
+func bindParam(f reflect.Value, values []string) error {
+	if f.Kind() == reflect.Pointer {
+		p := reflect.New(f.Type().Elem())
+		if err := bindParam(p.Elem(), values); err != nil {
+			return err
+		}
+		f.Set(p)
+		return nil
+	}
+	if f.Kind() == reflect.Slice && !f.Addr().Type().Implements(textUnmarshalerType) {
+		s := reflect.MakeSlice(f.Type(), len(values), len(values))
+		for i, v := range values {
+			if err := parseParam(s.Index(i), v); err != nil {
+				return err
+			}
+		}
+		f.Set(s)
+		return nil
+	}
+	if len(values) != 1 {
+		return errors.New("repeated")
+	}
+	return parseParam(f, values[0])
+}
+
+func parseParam(f reflect.Value, s string) error {
+	if u, ok := f.Addr().Interface().(encoding.TextUnmarshaler); ok {
+		return u.UnmarshalText([]byte(s))
+	}
+	switch f.Kind() {
+	case reflect.String:
+		f.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		f.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, f.Type().Bits())
+		if err != nil {
+			return err
+		}
+		f.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(s, 10, f.Type().Bits())
+		if err != nil {
+			return err
+		}
+		f.SetUint(n)
+	default:
+		return fmt.Errorf("unsupported type %s", f.Type())
+	}
+	return nil
+}
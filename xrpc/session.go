@@ -0,0 +1,245 @@
+package xrpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/notjuliet/grove/internal/jwt"
+)
+
+// NSIDs of the com.atproto.server session methods.
+const (
+	ServerCreateSession  = "com.atproto.server.createSession"
+	ServerRefreshSession = "com.atproto.server.refreshSession"
+	ServerDeleteSession  = "com.atproto.server.deleteSession"
+)
+
+// Access tokens expiring within this delay are refreshed before being used.
+const sessionRefreshMargin = time.Minute
+
+// Tokens and account of a legacy session, as returned by createSession and refreshSession.
+type SessionData struct {
+	DID        string `json:"did"`
+	Handle     string `json:"handle"`
+	AccessJwt  string `json:"accessJwt"`
+	RefreshJwt string `json:"refreshJwt"`
+	// Whether the account is active; nil if the PDS did not say.
+	Active *bool `json:"active,omitempty"`
+	// Reason the account is inactive, such as "takendown" or "deactivated".
+	Status string `json:"status,omitempty"`
+}
+
+// Persists the tokens of a session, so that it can be resumed with ResumeSession. Refresh tokens are single-use,
+// so the tokens must be saved after every refresh.
+type SessionStore interface {
+	// Saves the tokens of a session after it was created or refreshed, or nil once it is deleted.
+	SaveSession(ctx context.Context, data *SessionData) error
+}
+
+// Legacy session of an account on its PDS, created with a password or an app password. A session is an
+// Authorizer sending its access token, which it refreshes before it expires, or once a request is rejected with
+// ExpiredToken. Concurrent requests share a single refresh.
+//
+// The session methods are called on the host of the client the session was created with, without its Auth, so
+// that the session can be the Auth of that client.
+type Session struct {
+	client *Client
+	store  SessionStore
+
+	mtx  sync.Mutex
+	data *SessionData
+	// expiry of the access token, zero if unknown
+	expires time.Time
+	// refresh in progress, if any
+	refreshing *refreshCall
+}
+
+type refreshCall struct {
+	done chan struct{}
+	err  error
+}
+
+// Creates a session with the handle or email of an account and its password, or an app password. authFactor is
+// the code sent by email when the PDS requires it, and may be empty. store may be nil.
+func CreateSession(ctx context.Context, c *Client, identifier, password, authFactor string,
+	store SessionStore) (*Session, error) {
+	in := map[string]string{"identifier": identifier, "password": password}
+	if authFactor != "" {
+		in["authFactorToken"] = authFactor
+	}
+	var data SessionData
+	if err := c.sessionClient("").Procedure(ctx, ServerCreateSession, nil, in, &data); err != nil {
+		return nil, err
+	}
+	s := &Session{client: c, store: store}
+	s.set(&data)
+	if err := s.save(ctx, &data); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Resumes a session from saved tokens. store may be nil.
+func ResumeSession(c *Client, data *SessionData, store SessionStore) *Session {
+	s := &Session{client: c, store: store}
+	copied := *data
+	s.set(&copied)
+	return s
+}
+
+// Returns a copy of the current tokens of the session, or nil once it is deleted.
+func (s *Session) Data() *SessionData {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if s.data == nil {
+		return nil
+	}
+	data := *s.data
+	return &data
+}
+
+// Sets the access token of the session on a request, refreshing it first if it is about to expire.
+func (s *Session) Authorize(ctx context.Context, req *http.Request) error {
+	s.mtx.Lock()
+	if s.data == nil {
+		s.mtx.Unlock()
+		return errors.New("session was deleted")
+	}
+	token, expires := s.data.AccessJwt, s.expires
+	s.mtx.Unlock()
+	if !expires.IsZero() && time.Until(expires) < sessionRefreshMargin {
+		if err := s.refresh(ctx, token); err != nil {
+			return err
+		}
+		s.mtx.Lock()
+		if s.data == nil {
+			s.mtx.Unlock()
+			return errors.New("session was deleted")
+		}
+		token = s.data.AccessJwt
+		s.mtx.Unlock()
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	return nil
+}
+
+func (s *Session) RefreshRejected(ctx context.Context, req *http.Request) error {
+	token, _ := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+	return s.refresh(ctx, token)
+}
+
+// Exchanges the refresh token of the session for new tokens.
+func (s *Session) Refresh(ctx context.Context) error {
+	s.mtx.Lock()
+	if s.data == nil {
+		s.mtx.Unlock()
+		return errors.New("session was deleted")
+	}
+	token := s.data.AccessJwt
+	s.mtx.Unlock()
+	return s.refresh(ctx, token)
+}
+
+// refreshes the session unless its access token is no longer stale, joining a refresh in progress if any
+func (s *Session) refresh(ctx context.Context, stale string) error {
+	s.mtx.Lock()
+	if call := s.refreshing; call != nil {
+		s.mtx.Unlock()
+		select {
+		case <-call.done:
+			return call.err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	if s.data == nil {
+		s.mtx.Unlock()
+		return errors.New("session was deleted")
+	}
+	if s.data.AccessJwt != stale {
+		s.mtx.Unlock()
+		return nil
+	}
+	call := &refreshCall{done: make(chan struct{})}
+	s.refreshing = call
+	did, refreshJwt := s.data.DID, s.data.RefreshJwt
+	s.mtx.Unlock()
+
+	var data SessionData
+	call.err = s.client.sessionClient(refreshJwt).Procedure(ctx, ServerRefreshSession, nil, nil, &data)
+	if call.err == nil && data.DID != did {
+		call.err = fmt.Errorf("refreshed session is for %s, expected %s", data.DID, did)
+	}
+	if call.err == nil {
+		call.err = s.save(ctx, &data)
+	}
+	s.mtx.Lock()
+	if call.err == nil {
+		s.set(&data)
+	}
+	s.refreshing = nil
+	s.mtx.Unlock()
+	close(call.done)
+	return call.err
+}
+
+// Revokes the tokens of the session, which can no longer be used.
+func (s *Session) Delete(ctx context.Context) error {
+	s.mtx.Lock()
+	if s.data == nil {
+		s.mtx.Unlock()
+		return nil
+	}
+	refreshJwt := s.data.RefreshJwt
+	s.mtx.Unlock()
+	if err := s.client.sessionClient(refreshJwt).Procedure(ctx, ServerDeleteSession, nil, nil, nil); err != nil {
+		return err
+	}
+	s.mtx.Lock()
+	s.data = nil
+	s.mtx.Unlock()
+	return s.save(ctx, nil)
+}
+
+// sets the tokens of the session, with s.mtx held unless the session is new
+func (s *Session) set(data *SessionData) {
+	s.data = data
+	s.expires = time.Time{}
+	var claims struct {
+		Exp int64 `json:"exp"`
+	}
+	if t, err := jwt.Parse(data.AccessJwt); err == nil && t.DecodeClaims(&claims) == nil && claims.Exp > 0 {
+		s.expires = time.Unix(claims.Exp, 0)
+	}
+}
+
+func (s *Session) save(ctx context.Context, data *SessionData) error {
+	if s.store == nil {
+		return nil
+	}
+	if err := s.store.SaveSession(ctx, data); err != nil {
+		return fmt.Errorf("saving session: %w", err)
+	}
+	return nil
+}
+
+// Returns a client of the same host authorized with a session token, empty for none.
+func (c *Client) sessionClient(token string) *Client {
+	sc := &Client{Host: c.Host, HTTPClient: c.HTTPClient, Header: c.Header, Timeout: c.Timeout, Logger: c.Logger}
+	if token != "" {
+		sc.Auth = bearerToken(token)
+	}
+	return sc
+}
+
+type bearerToken string
+
+func (t bearerToken) Authorize(ctx context.Context, req *http.Request) error {
+	req.Header.Set("Authorization", "Bearer "+string(t))
+	return nil
+}
@@ -0,0 +1,159 @@
+package xrpc
+
+import (
+	"context"
+	"io"
+
+	"github.com/notjuliet/grove/blockstore"
+	"github.com/notjuliet/grove/car"
+	"github.com/notjuliet/grove/cid"
+	"github.com/notjuliet/grove/crypto"
+	"github.com/notjuliet/grove/repo"
+)
+
+// NSIDs of the com.atproto.sync methods.
+const (
+	SyncGetRepo         = "com.atproto.sync.getRepo"
+	SyncGetRepoStatus   = "com.atproto.sync.getRepoStatus"
+	SyncGetLatestCommit = "com.atproto.sync.getLatestCommit"
+	SyncGetBlocks       = "com.atproto.sync.getBlocks"
+	SyncGetRecord       = "com.atproto.sync.getRecord"
+	SyncListRepos       = "com.atproto.sync.listRepos"
+)
+
+// Hosting status of a repository, as returned by getRepoStatus.
+type RepoStatus struct {
+	DID    string `json:"did"`
+	Active bool   `json:"active"`
+	// Reason the account is inactive, such as "takendown", "suspended" or "deactivated".
+	Status string `json:"status,omitempty"`
+	// Rev of the latest commit, if the repository is active.
+	Rev string `json:"rev,omitempty"`
+}
+
+// Returns the typed status of the account.
+func (s *RepoStatus) AccountStatus() repo.AccountStatus {
+	return repo.AccountStatusOf(s.Active, s.Status)
+}
+
+// Latest commit of a repository, as returned by getLatestCommit.
+type LatestCommit struct {
+	Cid cid.Cid
+	Rev string
+}
+
+// Page of the repositories hosted by a service, as returned by listRepos.
+type ListReposOutput struct {
+	Cursor string       `json:"cursor,omitempty"`
+	Repos  []ListedRepo `json:"repos"`
+}
+
+// Repository listed by listRepos.
+type ListedRepo struct {
+	DID string `json:"did"`
+	// CID of the latest commit.
+	Head   string `json:"head"`
+	Rev    string `json:"rev"`
+	Active *bool  `json:"active,omitempty"`
+	Status string `json:"status,omitempty"`
+}
+
+// Returns the typed status of the account, active if the service did not report one.
+func (r *ListedRepo) AccountStatus() repo.AccountStatus {
+	return repo.AccountStatusOf(r.Active == nil || *r.Active, r.Status)
+}
+
+// Opens the CAR export of a repository, or only the blocks created since the commit of rev since, if not empty.
+// The caller must close the returned body.
+func (c *Client) GetRepo(ctx context.Context, did, since string) (io.ReadCloser, error) {
+	params := Params{"did": did}
+	if since != "" {
+		params["since"] = since
+	}
+	var body io.ReadCloser
+	if err := c.Query(ctx, SyncGetRepo, params, &body); err != nil {
+		return nil, err
+	}
+	return body, nil
+}
+
+// Fetches the full export of a repository into a blockstore and opens it, see repo.LoadFromCAR. The export is
+// streamed, not buffered in memory.
+func (c *Client) LoadRepo(ctx context.Context, did string, bs blockstore.Blockstore) (*repo.Repo, error) {
+	body, err := c.GetRepo(ctx, did, "")
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	return repo.LoadFromCAR(ctx, body, bs)
+}
+
+// Returns the hosting status of a repository.
+func (c *Client) GetRepoStatus(ctx context.Context, did string) (*RepoStatus, error) {
+	var out RepoStatus
+	if err := c.Query(ctx, SyncGetRepoStatus, Params{"did": did}, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// Returns the CID and rev of the latest commit of a repository.
+func (c *Client) GetLatestCommit(ctx context.Context, did string) (*LatestCommit, error) {
+	var out struct {
+		Cid string `json:"cid"`
+		Rev string `json:"rev"`
+	}
+	if err := c.Query(ctx, SyncGetLatestCommit, Params{"did": did}, &out); err != nil {
+		return nil, err
+	}
+	commit, err := cid.Parse(out.Cid)
+	if err != nil {
+		return nil, err
+	}
+	return &LatestCommit{Cid: commit, Rev: out.Rev}, nil
+}
+
+// Fetches blocks of a repository by CID into a blockstore, see car.ImportInto.
+func (c *Client) GetBlocks(ctx context.Context, did string, cids []cid.Cid, bs blockstore.Blockstore) error {
+	strs := make([]string, len(cids))
+	for i, k := range cids {
+		strs[i] = k.String()
+	}
+	var body io.ReadCloser
+	if err := c.Query(ctx, SyncGetBlocks, Params{"did": did, "cids": strs}, &body); err != nil {
+		return err
+	}
+	defer body.Close()
+	_, err := car.ImportInto(ctx, bs, body, car.TransferOptions{})
+	return err
+}
+
+// Fetches a record with the proof of its inclusion in the latest commit of the repository, verified against
+// the signing key of the account, see repo.VerifyRecordProof.
+func (c *Client) GetRecordProof(ctx context.Context, did, collection, rkey string,
+	pub crypto.PublicKey) (*repo.VerifiedRecord, error) {
+	var body io.ReadCloser
+	params := Params{"did": did, "collection": collection, "rkey": rkey}
+	if err := c.Query(ctx, SyncGetRecord, params, &body); err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	return repo.VerifyRecordProof(body, did, collection, rkey, pub)
+}
+
+// Returns a page of at most limit repositories hosted by the service, starting after cursor. A zero limit and
+// an empty cursor use the defaults of the service.
+func (c *Client) ListRepos(ctx context.Context, limit int, cursor string) (*ListReposOutput, error) {
+	params := Params{}
+	if limit > 0 {
+		params["limit"] = limit
+	}
+	if cursor != "" {
+		params["cursor"] = cursor
+	}
+	var out ListReposOutput
+	if err := c.Query(ctx, SyncListRepos, params, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
@@ -0,0 +1,359 @@
+// Package xrpc implements clients and servers of XRPC, the HTTP API convention of atproto services.
+//
+// https://atproto.com/specs/xrpc
+package xrpc
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/notjuliet/grove/syntax"
+)
+
+// maximum size of an error response body read for its details
+const maxErrorBodySize = 64 * 1024
+
+// Adds credentials to requests, such as an OAuth session.
+type Authorizer interface {
+	Authorize(ctx context.Context, req *http.Request) error
+}
+
+// Authorizer whose credentials can be renewed, such as a Session. Requests rejected with an ExpiredToken error
+// are sent again once, after renewing the credentials, unless their input is an io.Reader.
+type Refresher interface {
+	Authorizer
+	// Renews the credentials rejected for req, unless they were renewed since req was authorized.
+	RefreshRejected(ctx context.Context, req *http.Request) error
+}
+
+// Authorizer which inspects the responses to the requests it authorized, such as an OAuth session recording the
+// DPoP nonces of the service. Requests it rejects are sent again once, unless their input is an io.Reader.
+type ResponseInspector interface {
+	Authorizer
+	// Inspects the response to req before its body is read, and reports whether req must be sent again with
+	// new credentials.
+	InspectResponse(req *http.Request, resp *http.Response) bool
+}
+
+// XRPC client of a single service.
+type Client struct {
+	// Base URL of the service, such as "https://bsky.social".
+	Host string
+	// Nil uses http.DefaultClient.
+	HTTPClient *http.Client
+	// Headers sent with every request, such as User-Agent.
+	Header http.Header
+	// Timeout of each request, including reading its response body, unless overridden by the request. Zero
+	// means no timeout besides the deadline of the context.
+	Timeout time.Duration
+	// Adds credentials to every request, if set.
+	Auth Authorizer
+	// Service the host forwards requests to, sent in the atproto-proxy header, such as an AppView reached
+	// through the PDS of the user. Nil calls the host itself.
+	Proxy *ServiceRef
+	// Logger for requests and their failures, if set.
+	Logger *slog.Logger
+	// Retries failed requests, if set.
+	Retry *RetryPolicy
+	// Delays requests to stay under the rate limit reported by the service, for long-running jobs such as
+	// backfills.
+	Throttle bool
+
+	mtx sync.Mutex
+	// latest rate limit reported by the service, when throttling
+	rateLimit *RateLimit
+}
+
+// Parameters of a request, encoded in the query string. Values may be strings, booleans, integers,
+// fmt.Stringers, or slices of those, encoded as repeated parameters; nil values are omitted.
+type Params map[string]any
+
+// XRPC request.
+type Request struct {
+	// http.MethodGet for queries, http.MethodPost for procedures.
+	Method string
+	NSID   string
+	Params Params
+	// Body of a procedure, if any: an io.Reader or []byte sent as is, or a value encoded as JSON.
+	Input any
+	// Content type of Input, "application/json" if empty and Input is encoded as JSON.
+	Encoding string
+	// Headers of this request, overriding those of the client.
+	Header http.Header
+	// Service the host forwards this request to, overriding that of the client.
+	Proxy *ServiceRef
+	// Timeout of this request, overriding that of the client.
+	Timeout time.Duration
+	// Whether the procedure may be retried, such as a write guarded by a swap. Queries are always idempotent.
+	Idempotent bool
+	// Destination of the response body, if any: a *[]byte receives it as is, an io.Writer is copied to, an
+	// *io.ReadCloser receives the open body, which the caller must close, and other values are decoded from
+	// JSON.
+	Output any
+}
+
+// Metadata of a successful response.
+type Response struct {
+	StatusCode int
+	Header     http.Header
+}
+
+// Calls a query, decoding the JSON output into out, if not nil. out may be any destination accepted by
+// Request.Output.
+func (c *Client) Query(ctx context.Context, nsid string, params Params, out any) error {
+	_, err := c.Do(ctx, &Request{Method: http.MethodGet, NSID: nsid, Params: params, Output: out})
+	return err
+}
+
+// Calls a procedure with an input, which may be nil, decoding the JSON output into out, if not nil. in and
+// out may be any value accepted by Request.Input and Request.Output.
+func (c *Client) Procedure(ctx context.Context, nsid string, params Params, in, out any) error {
+	_, err := c.Do(ctx, &Request{Method: http.MethodPost, NSID: nsid, Params: params, Input: in, Output: out})
+	return err
+}
+
+// Sends a request, writing the response body to req.Output. Failed attempts are retried according to the
+// retry policy of the client.
+func (c *Client) Do(ctx context.Context, req *Request) (*Response, error) {
+	if c.Host == "" {
+		return nil, errors.New("client has no host")
+	}
+	if err := syntax.ValidateNSID(req.NSID); err != nil {
+		return nil, err
+	}
+	refreshed, resent := false, false
+	for attempt := 1; ; attempt++ {
+		resp, err := c.attempt(ctx, req)
+		var xerr *Error
+		if _, ok := req.Input.(io.Reader); !ok && errors.As(err, &xerr) && xerr.request != nil {
+			if xerr.resend && !resent {
+				resent = true
+				attempt--
+				continue
+			}
+			if refresher, ok := c.Auth.(Refresher); ok && !refreshed && xerr.Name == "ExpiredToken" {
+				refreshed = true
+				if err := refresher.RefreshRejected(ctx, xerr.request); err != nil {
+					return nil, fmt.Errorf("refreshing credentials: %w", err)
+				}
+				attempt--
+				continue
+			}
+		}
+		delay, retry := c.Retry.backoff(ctx, req, attempt, err)
+		if !retry {
+			return resp, err
+		}
+		if c.Logger != nil {
+			c.Logger.Debug("retrying xrpc request", "host", c.Host, "nsid", req.NSID, "attempt", attempt,
+				"retry_in", delay, "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return nil, err
+		case <-time.After(delay):
+		}
+	}
+}
+
+func (c *Client) attempt(ctx context.Context, req *Request) (*Response, error) {
+	if err := c.throttle(ctx); err != nil {
+		return nil, err
+	}
+	timeout := c.Timeout
+	if req.Timeout > 0 {
+		timeout = req.Timeout
+	}
+	cancel := context.CancelFunc(func() {})
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+	}
+	start := time.Now()
+	resp, err := c.do(ctx, req)
+	// the context must outlive a body handed over to the caller
+	if rc, ok := req.Output.(*io.ReadCloser); ok && err == nil {
+		*rc = &cancelBody{ReadCloser: *rc, cancel: cancel}
+	} else {
+		cancel()
+	}
+	if c.Logger != nil {
+		if err != nil {
+			c.Logger.Warn("xrpc request failed", "host", c.Host, "nsid", req.NSID, "duration", time.Since(start),
+				"error", err)
+		} else {
+			c.Logger.Debug("xrpc request", "host", c.Host, "nsid", req.NSID, "duration", time.Since(start),
+				"status", resp.StatusCode)
+		}
+	}
+	return resp, err
+}
+
+func (c *Client) do(ctx context.Context, req *Request) (*Response, error) {
+	u := strings.TrimSuffix(c.Host, "/") + "/xrpc/" + req.NSID
+	if len(req.Params) > 0 {
+		q, err := encodeParams(req.Params)
+		if err != nil {
+			return nil, err
+		}
+		u += "?" + q
+	}
+
+	var body io.Reader
+	encoding := req.Encoding
+	switch in := req.Input.(type) {
+	case nil:
+	case io.Reader:
+		body = in
+	case []byte:
+		body = bytes.NewReader(in)
+	default:
+		b, err := json.Marshal(in)
+		if err != nil {
+			return nil, fmt.Errorf("encoding %s input: %w", req.NSID, err)
+		}
+		body = bytes.NewReader(b)
+		if encoding == "" {
+			encoding = "application/json"
+		}
+	}
+	method := req.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+	hreq, err := http.NewRequestWithContext(ctx, method, u, body)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range c.Header {
+		hreq.Header[k] = v
+	}
+	if proxy := cmp.Or(req.Proxy, c.Proxy); proxy != nil {
+		hreq.Header.Set(ProxyHeader, proxy.String())
+	}
+	for k, v := range req.Header {
+		hreq.Header[k] = v
+	}
+	if encoding != "" {
+		hreq.Header.Set("Content-Type", encoding)
+	}
+	if c.Auth != nil {
+		if err := c.Auth.Authorize(ctx, hreq); err != nil {
+			return nil, err
+		}
+	}
+
+	client := c.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	hresp, err := client.Do(hreq)
+	if err != nil {
+		return nil, err
+	}
+	c.observeRateLimit(hresp.Header)
+	resend := false
+	if inspector, ok := c.Auth.(ResponseInspector); ok {
+		resend = inspector.InspectResponse(hreq, hresp)
+	}
+	if hresp.StatusCode < 200 || hresp.StatusCode >= 300 {
+		defer hresp.Body.Close()
+		err := readError(req.NSID, hresp)
+		err.resend = resend
+		return nil, err
+	}
+	resp := &Response{StatusCode: hresp.StatusCode, Header: hresp.Header}
+
+	switch out := req.Output.(type) {
+	case *io.ReadCloser:
+		*out = hresp.Body
+		return resp, nil
+	case nil:
+		_, err = io.Copy(io.Discard, hresp.Body)
+	case *[]byte:
+		*out, err = io.ReadAll(hresp.Body)
+	case io.Writer:
+		_, err = io.Copy(out, hresp.Body)
+	default:
+		contentType := hresp.Header.Get("Content-Type")
+		if mediaType, _, _ := mime.ParseMediaType(contentType); mediaType != "application/json" {
+			err = fmt.Errorf("expected JSON output, got %q", contentType)
+		} else if err = json.NewDecoder(hresp.Body).Decode(out); err != nil {
+			err = fmt.Errorf("decoding %s output: %w", req.NSID, err)
+		}
+	}
+	hresp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func encodeParams(params Params) (string, error) {
+	q := url.Values{}
+	for k, v := range params {
+		switch v := v.(type) {
+		case nil:
+		case []string:
+			q[k] = v
+		case []any:
+			for _, e := range v {
+				s, err := formatParam(k, e)
+				if err != nil {
+					return "", err
+				}
+				q.Add(k, s)
+			}
+		default:
+			s, err := formatParam(k, v)
+			if err != nil {
+				return "", err
+			}
+			q.Set(k, s)
+		}
+	}
+	return q.Encode(), nil
+}
+
+func formatParam(k string, v any) (string, error) {
+	switch v := v.(type) {
+	case string:
+		return v, nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case int:
+		return strconv.Itoa(v), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case uint64:
+		return strconv.FormatUint(v, 10), nil
+	case fmt.Stringer:
+		return v.String(), nil
+	default:
+		return "", fmt.Errorf("unsupported type %T of parameter %s", v, k)
+	}
+}
+
+// response body releasing the timeout of its request when closed
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
@@ -0,0 +1,946 @@
+package xrpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/notjuliet/grove/blob"
+	"github.com/notjuliet/grove/blockstore"
+	"github.com/notjuliet/grove/car"
+	"github.com/notjuliet/grove/cid"
+	"github.com/notjuliet/grove/crypto"
+	"github.com/notjuliet/grove/data"
+	"github.com/notjuliet/grove/identity"
+	"github.com/notjuliet/grove/repo"
+)
+
+type bearer string
+
+func (b bearer) Authorize(ctx context.Context, req *http.Request) error {
+	req.Header.Set("Authorization", "Bearer "+string(b))
+	return nil
+}
+
+func TestClient(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/xrpc/com.example.query":
+			json.NewEncoder(w).Encode(map[string]any{
+				"query":         r.URL.Query(),
+				"authorization": r.Header.Get("Authorization"),
+				"agent":         r.Header.Get("User-Agent"),
+			})
+		case "/xrpc/com.example.procedure":
+			var in map[string]any
+			if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" {
+				http.Error(w, "bad request", http.StatusBadRequest)
+				return
+			}
+			json.NewDecoder(r.Body).Decode(&in)
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(in)
+		case "/xrpc/com.example.raw":
+			w.Header().Set("Content-Type", "application/vnd.ipld.car")
+			io.Copy(w, r.Body)
+		case "/xrpc/com.example.slow":
+			<-r.Context().Done()
+		default:
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"InvalidRequest","message":"unknown method"}`))
+		}
+	}))
+	defer srv.Close()
+	ctx := context.Background()
+	c := &Client{Host: srv.URL, Header: http.Header{"User-Agent": {"grove-test"}}, Auth: bearer("token")}
+
+	var query struct {
+		Query         map[string][]string
+		Authorization string
+		Agent         string
+	}
+	// the server omits the JSON content type
+	if err := c.Query(ctx, "com.example.query", Params{"limit": 10, "reverse": true, "tags": []string{"a", "b"}, "cursor": nil}, &query); err == nil {
+		t.Fatal("expected content type error")
+	}
+	var raw []byte
+	if err := c.Query(ctx, "com.example.query", Params{"limit": 10, "reverse": true, "tags": []string{"a", "b"}, "cursor": nil}, &raw); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(raw, &query); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][]string{"limit": {"10"}, "reverse": {"true"}, "tags": {"a", "b"}}
+	if !reflect.DeepEqual(query.Query, want) || query.Authorization != "Bearer token" || query.Agent != "grove-test" {
+		t.Fatalf("unexpected request %+v", query)
+	}
+
+	var out map[string]any
+	if err := c.Procedure(ctx, "com.example.procedure", nil, map[string]any{"text": "hi"}, &out); err != nil {
+		t.Fatal(err)
+	}
+	if out["text"] != "hi" {
+		t.Fatalf("unexpected output %v", out)
+	}
+
+	var body io.ReadCloser
+	resp, err := c.Do(ctx, &Request{Method: http.MethodPost, NSID: "com.example.raw", Input: []byte("car bytes"), Encoding: "application/vnd.ipld.car", Output: &body})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := io.ReadAll(body)
+	body.Close()
+	if err != nil || string(b) != "car bytes" || resp.Header.Get("Content-Type") != "application/vnd.ipld.car" {
+		t.Fatalf("unexpected body %q, %v", b, err)
+	}
+	var sb strings.Builder
+	if err := c.Procedure(ctx, "com.example.raw", nil, strings.NewReader("streamed"), &sb); err != nil || sb.String() != "streamed" {
+		t.Fatalf("unexpected body %q, %v", sb.String(), err)
+	}
+
+	err = c.Query(ctx, "com.example.unknown", nil, nil)
+	var xerr *Error
+	if !errors.As(err, &xerr) || xerr.StatusCode != http.StatusBadRequest || xerr.Name != "InvalidRequest" ||
+		xerr.Message != "unknown method" || !errors.Is(err, ErrInvalidRequest) {
+		t.Fatalf("expected error response, got %v", err)
+	}
+	_, err = c.Do(ctx, &Request{NSID: "com.example.slow", Timeout: 10 * time.Millisecond})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected timeout, got %v", err)
+	}
+	if err := c.Query(ctx, "not an nsid", nil, nil); err == nil {
+		t.Fatal("expected invalid NSID error")
+	}
+}
+
+func TestError(t *testing.T) {
+	for _, tc := range []struct {
+		status int
+		body   string
+		want   error
+	}{
+		{http.StatusUnauthorized, `{"error":"AuthRequired"}`, ErrAuth},
+		{http.StatusBadRequest, `{"error":"ExpiredToken","message":"token has expired"}`, ErrAuth},
+		{http.StatusForbidden, ``, ErrAuth},
+		{http.StatusTooManyRequests, `{"error":"RateLimitExceeded"}`, ErrRateLimited},
+		{http.StatusBadGateway, `{"error":"UpstreamFailure"}`, ErrUpstream},
+		{http.StatusInternalServerError, `not json`, ErrUpstream},
+		{http.StatusBadRequest, `{"error":"RecordNotFound","message":"could not locate record"}`, ErrInvalidRequest},
+		{http.StatusNotFound, ``, ErrInvalidRequest},
+	} {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(tc.status)
+			w.Write([]byte(tc.body))
+		}))
+		c := &Client{Host: srv.URL}
+		err := c.Query(context.Background(), "com.example.fail", nil, nil)
+		srv.Close()
+		var xerr *Error
+		if !errors.As(err, &xerr) || xerr.StatusCode != tc.status || xerr.NSID != "com.example.fail" {
+			t.Fatalf("expected error of status %d, got %v", tc.status, err)
+		}
+		if !errors.Is(err, tc.want) || xerr.Category() != tc.want {
+			t.Errorf("%d %s: expected %v, got %v", tc.status, tc.body, tc.want, xerr.Category())
+		}
+	}
+}
+
+func TestServeMux(t *testing.T) {
+	mux := &ServeMux{}
+	mux.HandleQuery("com.example.echo", func(w http.ResponseWriter, r *http.Request) (any, error) {
+		var params struct {
+			DID     string   `param:"did,required"`
+			Limit   *int64   `param:"limit"`
+			Reverse bool     `param:"reverse"`
+			Tags    []string `param:"tags"`
+		}
+		if err := BindParams(r, &params); err != nil {
+			return nil, err
+		}
+		if params.Limit == nil {
+			return nil, errors.New("no limit")
+		}
+		return params, nil
+	})
+	mux.HandleProcedure("com.example.create", func(w http.ResponseWriter, r *http.Request) (any, error) {
+		var in struct {
+			Text string `json:"text"`
+		}
+		if err := DecodeInput(r, &in); err != nil {
+			return nil, err
+		}
+		if in.Text == "" {
+			return nil, InvalidRequest("empty text")
+		}
+		return map[string]string{"text": in.Text}, nil
+	})
+	mux.HandleQuery("com.example.raw", func(w http.ResponseWriter, r *http.Request) (any, error) {
+		w.Header().Set("Content-Type", "application/vnd.ipld.car")
+		w.Write([]byte("car bytes"))
+		return nil, nil
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	ctx := context.Background()
+	c := &Client{Host: srv.URL}
+
+	var out struct {
+		DID     string
+		Limit   int64
+		Reverse bool
+		Tags    []string
+	}
+	if err := c.Query(ctx, "com.example.echo", Params{"did": "did:plc:abc", "limit": 5, "reverse": true, "tags": []string{"a", "b"}}, &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.DID != "did:plc:abc" || out.Limit != 5 || !out.Reverse || !reflect.DeepEqual(out.Tags, []string{"a", "b"}) {
+		t.Fatalf("unexpected output %+v", out)
+	}
+	var created map[string]string
+	if err := c.Procedure(ctx, "com.example.create", nil, map[string]string{"text": "hi"}, &created); err != nil || created["text"] != "hi" {
+		t.Fatalf("unexpected output %v, %v", created, err)
+	}
+	var raw []byte
+	if err := c.Query(ctx, "com.example.raw", nil, &raw); err != nil || string(raw) != "car bytes" {
+		t.Fatalf("unexpected output %q, %v", raw, err)
+	}
+
+	for _, tc := range []struct {
+		req    *Request
+		status int
+		name   string
+	}{
+		{&Request{NSID: "com.example.echo", Params: Params{"limit": 5}}, http.StatusBadRequest, "InvalidRequest"},
+		{&Request{NSID: "com.example.echo", Params: Params{"did": "x", "limit": "many"}}, http.StatusBadRequest, "InvalidRequest"},
+		{&Request{NSID: "com.example.echo", Params: Params{"did": "x"}}, http.StatusInternalServerError, "InternalServerError"},
+		{&Request{Method: http.MethodPost, NSID: "com.example.create", Input: map[string]string{}}, http.StatusBadRequest, "InvalidRequest"},
+		{&Request{Method: http.MethodPost, NSID: "com.example.create", Input: []byte("text")}, http.StatusBadRequest, "InvalidRequest"},
+		{&Request{NSID: "com.example.create"}, http.StatusMethodNotAllowed, "InvalidRequest"},
+		{&Request{NSID: "com.example.unknown"}, http.StatusNotImplemented, "MethodNotImplemented"},
+	} {
+		_, err := c.Do(ctx, tc.req)
+		var xerr *Error
+		if !errors.As(err, &xerr) || xerr.StatusCode != tc.status || xerr.Name != tc.name {
+			t.Errorf("%s: expected %d %s, got %v", tc.req.NSID, tc.status, tc.name, err)
+		}
+	}
+}
+
+func TestSync(t *testing.T) {
+	ctx := context.Background()
+	key, err := crypto.GenerateK256()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bs := blockstore.NewMemoryBlockstore()
+	r := repo.New("did:plc:abc", bs)
+	res, err := r.CreateRecord(ctx, "app.bsky.feed.post", "3jzfcijpj2z2a", map[string]any{"text": "hello"}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mux := &ServeMux{}
+	mux.HandleQuery(SyncGetRepo, func(w http.ResponseWriter, req *http.Request) (any, error) {
+		head, _, _ := r.Head()
+		w.Header().Set("Content-Type", "application/vnd.ipld.car")
+		return nil, repo.ExportSince(req.Context(), bs, head, "", w)
+	})
+	mux.HandleQuery(SyncGetLatestCommit, func(w http.ResponseWriter, req *http.Request) (any, error) {
+		head, commit, _ := r.Head()
+		return map[string]string{"cid": head.String(), "rev": commit.Rev}, nil
+	})
+	mux.HandleQuery(SyncGetRecord, func(w http.ResponseWriter, req *http.Request) (any, error) {
+		q := req.URL.Query()
+		b, err := r.ProveRecord(req.Context(), q.Get("collection"), q.Get("rkey"))
+		if err != nil {
+			return nil, err
+		}
+		w.Header().Set("Content-Type", "application/vnd.ipld.car")
+		w.Write(b)
+		return nil, nil
+	})
+	mux.HandleQuery(SyncGetBlocks, func(w http.ResponseWriter, req *http.Request) (any, error) {
+		cw, err := car.NewWriter(w, nil)
+		if err != nil {
+			return nil, err
+		}
+		for _, s := range req.URL.Query()["cids"] {
+			c, err := cid.Parse(s)
+			if err != nil {
+				return nil, InvalidRequest("invalid CID %s", s)
+			}
+			b, err := bs.Get(req.Context(), c)
+			if err != nil {
+				return nil, err
+			}
+			if err := cw.Put(c, b); err != nil {
+				return nil, err
+			}
+		}
+		return nil, nil
+	})
+	mux.HandleQuery(SyncGetRepoStatus, func(w http.ResponseWriter, req *http.Request) (any, error) {
+		return &RepoStatus{DID: req.URL.Query().Get("did"), Active: true, Rev: res.Commit.Rev}, nil
+	})
+	mux.HandleQuery(SyncListRepos, func(w http.ResponseWriter, req *http.Request) (any, error) {
+		return &ListReposOutput{Repos: []ListedRepo{{DID: "did:plc:abc", Head: res.Cid.String(), Rev: res.Commit.Rev}}}, nil
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	c := &Client{Host: srv.URL}
+
+	loaded, err := c.LoadRepo(ctx, "did:plc:abc", blockstore.NewMemoryBlockstore())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if head, _, _ := loaded.Head(); !bytes.Equal(head.Bytes, res.Cid.Bytes) {
+		t.Fatalf("loaded head %s, expected %s", head, res.Cid)
+	}
+	latest, err := c.GetLatestCommit(ctx, "did:plc:abc")
+	if err != nil || !bytes.Equal(latest.Cid.Bytes, res.Cid.Bytes) || latest.Rev != res.Commit.Rev {
+		t.Fatalf("unexpected latest commit %+v, %v", latest, err)
+	}
+	rec, err := c.GetRecordProof(ctx, "did:plc:abc", "app.bsky.feed.post", "3jzfcijpj2z2a", key.PublicKey())
+	if err != nil || rec.Value["text"] != "hello" {
+		t.Fatalf("unexpected record %+v, %v", rec, err)
+	}
+	fetched := blockstore.NewMemoryBlockstore()
+	if err := c.GetBlocks(ctx, "did:plc:abc", []cid.Cid{res.Cid}, fetched); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := fetched.Has(ctx, res.Cid); !ok {
+		t.Fatal("commit block was not fetched")
+	}
+	status, err := c.GetRepoStatus(ctx, "did:plc:abc")
+	if err != nil || !status.Active || status.Rev != res.Commit.Rev {
+		t.Fatalf("unexpected status %+v, %v", status, err)
+	}
+	list, err := c.ListRepos(ctx, 10, "")
+	if err != nil || len(list.Repos) != 1 || list.Repos[0].Head != res.Cid.String() {
+		t.Fatalf("unexpected repos %+v, %v", list, err)
+	}
+}
+
+// serves the com.atproto.repo methods of a single repository
+func repoServer(r *repo.Repo, key crypto.PrivateKey) *ServeMux {
+	ctx := context.Background()
+	commitOut := func(res *repo.CommitResult) map[string]any {
+		return map[string]any{"cid": res.Cid.String(), "rev": res.Commit.Rev}
+	}
+	recordRef := func(collection, rkey string, c cid.Cid) map[string]any {
+		return map[string]any{"uri": "at://" + r.DID() + "/" + collection + "/" + rkey, "cid": c.String()}
+	}
+	write := func(req *http.Request) (map[string]any, *repo.CommitResult, error) {
+		b, err := io.ReadAll(req.Body)
+		if err != nil {
+			return nil, nil, err
+		}
+		v, err := data.UnmarshalJSON(b)
+		if err != nil {
+			return nil, nil, InvalidRequest("%v", err)
+		}
+		in := v.(map[string]any)
+		batch := repo.WriteBatch{}
+		if s, ok := in["swapCommit"].(string); ok {
+			c, _ := cid.Parse(s)
+			batch.SwapCommit = &c
+		}
+		w := repo.Write{Collection: fmt.Sprint(in["collection"]), RKey: fmt.Sprint(in["rkey"])}
+		w.Record, _ = in["record"].(map[string]any)
+		if s, ok := in["swapRecord"].(string); ok {
+			c, _ := cid.Parse(s)
+			w.SwapRecord = &c
+		}
+		switch path.Base(req.URL.Path) {
+		case RepoCreateRecord:
+			w.Action = repo.ActionCreate
+			batch.Writes = []repo.Write{w}
+		case RepoPutRecord:
+			w.Action = repo.ActionUpdate
+			batch.Writes = []repo.Write{w}
+		case RepoDeleteRecord:
+			w.Action = repo.ActionDelete
+			batch.Writes = []repo.Write{w}
+		case RepoApplyWrites:
+			for _, e := range in["writes"].([]any) {
+				e := e.(map[string]any)
+				_, action, _ := strings.Cut(e["$type"].(string), "#")
+				w := repo.Write{Action: repo.Action(action), Collection: e["collection"].(string)}
+				w.RKey, _ = e["rkey"].(string)
+				w.Record, _ = e["value"].(map[string]any)
+				batch.Writes = append(batch.Writes, w)
+			}
+		}
+		res, err := r.ApplyBatch(ctx, batch, key)
+		if errors.Is(err, repo.ErrSwapMismatch) {
+			return nil, nil, &Error{StatusCode: http.StatusBadRequest, Name: "InvalidSwap", Message: err.Error()}
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		out := map[string]any{"commit": commitOut(res)}
+		var results []any
+		for _, op := range res.Ops {
+			collection, rkey, _ := strings.Cut(op.Path, "/")
+			if op.Cid != nil {
+				results = append(results, recordRef(collection, rkey, *op.Cid))
+			} else {
+				results = append(results, map[string]any{})
+			}
+		}
+		if len(results) == 1 {
+			maps.Copy(out, results[0].(map[string]any))
+		}
+		out["results"] = results
+		return out, res, nil
+	}
+
+	mux := &ServeMux{}
+	for _, nsid := range []string{RepoCreateRecord, RepoPutRecord, RepoDeleteRecord, RepoApplyWrites} {
+		mux.HandleProcedure(nsid, func(w http.ResponseWriter, req *http.Request) (any, error) {
+			out, _, err := write(req)
+			return out, err
+		})
+	}
+	mux.HandleQuery(RepoGetRecord, func(w http.ResponseWriter, req *http.Request) (any, error) {
+		q := req.URL.Query()
+		c, rec, err := r.GetRecord(ctx, q.Get("collection"), q.Get("rkey"))
+		if errors.Is(err, repo.ErrRecordNotFound) {
+			return nil, &Error{StatusCode: http.StatusBadRequest, Name: "RecordNotFound"}
+		}
+		if err != nil {
+			return nil, err
+		}
+		out := recordRef(q.Get("collection"), q.Get("rkey"), c)
+		out["value"] = data.ToJSON(rec)
+		return out, nil
+	})
+	mux.HandleQuery(RepoListRecords, func(w http.ResponseWriter, req *http.Request) (any, error) {
+		q := req.URL.Query()
+		var records []any
+		for rec, err := range r.ListRecords(ctx, q.Get("collection"), repo.ListOptions{}) {
+			if err != nil {
+				return nil, err
+			}
+			out := recordRef(q.Get("collection"), rec.RKey, rec.Cid)
+			out["value"] = data.ToJSON(rec.Value)
+			records = append(records, out)
+		}
+		return map[string]any{"records": records}, nil
+	})
+	mux.HandleProcedure(RepoUploadBlob, func(w http.ResponseWriter, req *http.Request) (any, error) {
+		b, err := io.ReadAll(req.Body)
+		if err != nil {
+			return nil, err
+		}
+		if req.Header.Get("Content-Type") == "image/x-corrupt" {
+			b = append(b, '!')
+		}
+		c, err := cid.Create(0x55, b)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]any{"blob": map[string]any{"$type": "blob", "ref": c.Link(),
+			"mimeType": req.Header.Get("Content-Type"), "size": len(b)}}, nil
+	})
+	return mux
+}
+
+func TestRepoMethods(t *testing.T) {
+	ctx := context.Background()
+	key, err := crypto.GenerateK256()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := repo.New("did:plc:abc", blockstore.NewMemoryBlockstore())
+	srv := httptest.NewServer(repoServer(r, key))
+	defer srv.Close()
+	c := &Client{Host: srv.URL}
+
+	ref, err := c.UploadBlob(ctx, strings.NewReader("image bytes"), "image/png", &UploadOptions{MaxSize: 11,
+		VerifyCid: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size, ok := ref.Size(); !ok || size != 11 || ref.MimeType() != "image/png" || ref.IsLegacy() {
+		t.Fatalf("unexpected blob %+v", ref)
+	}
+	if _, err := c.UploadBlob(ctx, strings.NewReader("image bytes"), "image/png",
+		&UploadOptions{MaxSize: 10}); !errors.Is(err, blob.ErrTooLarge) {
+		t.Fatalf("expected blob.ErrTooLarge, got %v", err)
+	}
+	if _, err := c.UploadBlob(ctx, strings.NewReader("image bytes"), "image/x-corrupt",
+		&UploadOptions{VerifyCid: true}); err == nil || !strings.Contains(err.Error(), "service returned") {
+		t.Fatalf("expected a CID mismatch, got %v", err)
+	}
+	if _, err := c.UploadBlob(ctx, strings.NewReader("image bytes"), "image/x-corrupt", nil); err != nil {
+		t.Fatal(err)
+	}
+	image, err := ref.ToData()
+	if err != nil {
+		t.Fatal(err)
+	}
+	post := map[string]any{"$type": "app.bsky.feed.post", "text": "hello", "data": []byte{1, 2, 3}, "image": image}
+	created, err := c.CreateRecord(ctx, "did:plc:abc", "app.bsky.feed.post", "3jzfcijpj2z2a", post, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created.URI != "at://did:plc:abc/app.bsky.feed.post/3jzfcijpj2z2a" || created.Commit == nil {
+		t.Fatalf("unexpected result %+v", created)
+	}
+	rec, err := c.GetRecord(ctx, "did:plc:abc", "app.bsky.feed.post", "3jzfcijpj2z2a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(rec.Cid.Bytes, created.Cid.Bytes) || !reflect.DeepEqual(rec.Value, post) {
+		t.Fatalf("unexpected record %+v", rec)
+	}
+
+	// stale swaps are reported as repo.ErrSwapMismatch
+	stale := created.Cid
+	post["text"] = "edited"
+	updated, err := c.PutRecord(ctx, "did:plc:abc", "app.bsky.feed.post", "3jzfcijpj2z2a", post, &stale,
+		&WriteOptions{SwapCommit: &created.Commit.Cid})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.PutRecord(ctx, "did:plc:abc", "app.bsky.feed.post", "3jzfcijpj2z2a", post, &stale, nil)
+	if !errors.Is(err, repo.ErrSwapMismatch) {
+		t.Fatalf("expected swap mismatch, got %v", err)
+	}
+	_, err = c.DeleteRecord(ctx, "did:plc:abc", "app.bsky.feed.post", "3jzfcijpj2z2a", nil,
+		&WriteOptions{SwapCommit: &created.Commit.Cid})
+	if !errors.Is(err, repo.ErrSwapMismatch) {
+		t.Fatalf("expected swap mismatch, got %v", err)
+	}
+
+	results, commit, err := c.ApplyWrites(ctx, "did:plc:abc", repo.WriteBatch{
+		Writes: []repo.Write{
+			{Action: repo.ActionCreate, Collection: "app.bsky.feed.post", RKey: "3jzfcijpj2z2b", Record: map[string]any{"text": "second"}},
+			{Action: repo.ActionDelete, Collection: "app.bsky.feed.post", RKey: "3jzfcijpj2z2a"},
+		},
+		SwapCommit: &updated.Commit.Cid,
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 || results[0].URI != "at://did:plc:abc/app.bsky.feed.post/3jzfcijpj2z2b" || results[1].URI != "" || commit == nil {
+		t.Fatalf("unexpected results %+v", results)
+	}
+	list, err := c.ListRecords(ctx, "did:plc:abc", "app.bsky.feed.post", 0, "", false)
+	if err != nil || len(list.Records) != 1 || list.Records[0].Value["text"] != "second" {
+		t.Fatalf("unexpected records %+v, %v", list, err)
+	}
+	_, err = c.GetRecord(ctx, "did:plc:abc", "app.bsky.feed.post", "3jzfcijpj2z2a")
+	var xerr *Error
+	if !errors.As(err, &xerr) || xerr.Name != "RecordNotFound" {
+		t.Fatalf("expected RecordNotFound, got %v", err)
+	}
+}
+
+func TestRetry(t *testing.T) {
+	var mtx sync.Mutex
+	attempts := map[string]int{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mtx.Lock()
+		attempts[r.URL.Path]++
+		n := attempts[r.URL.Path]
+		mtx.Unlock()
+		switch {
+		case strings.HasSuffix(r.URL.Path, ".invalid"):
+			WriteError(w, InvalidRequest("bad"))
+		case strings.HasSuffix(r.URL.Path, ".wait"):
+			w.Header().Set("Retry-After", "3600")
+			WriteError(w, &Error{StatusCode: http.StatusTooManyRequests, Name: "RateLimitExceeded"})
+		case n < 3:
+			w.Header().Set("Retry-After", "0")
+			WriteError(w, &Error{StatusCode: http.StatusServiceUnavailable})
+		default:
+			WriteJSON(w, http.StatusOK, map[string]int{"attempts": n})
+		}
+	}))
+	defer srv.Close()
+	ctx := context.Background()
+	c := &Client{Host: srv.URL, Retry: &RetryPolicy{MinBackoff: time.Millisecond}}
+
+	var out struct{ Attempts int }
+	if err := c.Query(ctx, "com.example.flaky", nil, &out); err != nil || out.Attempts != 3 {
+		t.Fatalf("expected success after 3 attempts, got %d, %v", out.Attempts, err)
+	}
+	// procedures are not retried unless marked idempotent
+	if err := c.Procedure(ctx, "com.example.write", nil, map[string]int{}, nil); !errors.Is(err, ErrUpstream) {
+		t.Fatalf("expected upstream error, got %v", err)
+	}
+	_, err := c.Do(ctx, &Request{Method: http.MethodPost, NSID: "com.example.put", Input: []byte("{}"), Idempotent: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// too many attempts
+	c.Retry.MaxAttempts = 2
+	if err := c.Query(ctx, "com.example.unlucky", nil, nil); !errors.Is(err, ErrUpstream) {
+		t.Fatalf("expected upstream error, got %v", err)
+	}
+	c.Query(ctx, "com.example.invalid", nil, nil)
+	c.Query(ctx, "com.example.wait", nil, nil)
+
+	want := map[string]int{
+		"/xrpc/com.example.flaky":   3,
+		"/xrpc/com.example.write":   1,
+		"/xrpc/com.example.put":     3,
+		"/xrpc/com.example.unlucky": 2,
+		"/xrpc/com.example.invalid": 1,
+		"/xrpc/com.example.wait":    1,
+	}
+	if !reflect.DeepEqual(attempts, want) {
+		t.Fatalf("unexpected attempts %v", attempts)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	now := time.Now()
+	h := http.Header{}
+	h.Set("RateLimit-Limit", "3000")
+	h.Set("RateLimit-Remaining", "100")
+	h.Set("RateLimit-Reset", strconv.FormatInt(now.Add(time.Minute).Unix(), 10))
+	h.Set("RateLimit-Policy", "3000;w=300")
+	rl, ok := ParseRateLimit(h)
+	if !ok || rl.Limit != 3000 || rl.Remaining != 100 || rl.Reset.Unix() != now.Add(time.Minute).Unix() || rl.Policy != "3000;w=300" {
+		t.Fatalf("unexpected rate limit %+v", rl)
+	}
+	h.Set("RateLimit-Reset", "60")
+	if rl, ok := ParseRateLimit(h); !ok || rl.Reset.Sub(now).Round(time.Second) != time.Minute {
+		t.Fatalf("unexpected rate limit %+v", rl)
+	}
+	if _, ok := ParseRateLimit(http.Header{}); ok {
+		t.Fatal("expected no rate limit")
+	}
+
+	reset := now.Add(100 * time.Second)
+	for _, tc := range []struct {
+		remaining int
+		want      time.Duration
+	}{
+		{1000, 0},
+		{300, 0},
+		{99, time.Second},
+		{0, 100 * time.Second},
+	} {
+		rl := &RateLimit{Limit: 3000, Remaining: tc.remaining, Reset: reset}
+		if d := rl.delay(now); d != tc.want {
+			t.Errorf("%d remaining: expected delay %s, got %s", tc.remaining, tc.want, d)
+		}
+	}
+
+	var requests int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("RateLimit-Limit", "10")
+		w.Header().Set("RateLimit-Remaining", "0")
+		w.Header().Set("RateLimit-Reset", "3600")
+		WriteJSON(w, http.StatusOK, map[string]any{})
+	}))
+	defer srv.Close()
+	c := &Client{Host: srv.URL, Throttle: true}
+	resp, err := c.Do(context.Background(), &Request{NSID: "com.example.query"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rl, ok := resp.RateLimit(); !ok || rl.Remaining != 0 {
+		t.Fatalf("unexpected rate limit %+v", rl)
+	}
+	// the next request waits for the reset
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := c.Do(ctx, &Request{NSID: "com.example.query"}); !errors.Is(err, context.DeadlineExceeded) || requests != 1 {
+		t.Fatalf("expected throttled request, got %v after %d requests", err, requests)
+	}
+}
+
+func TestPaginate(t *testing.T) {
+	ctx := context.Background()
+	var limits []int
+	// pages over the numbers 0 to 24, with the next number as cursor
+	fetch := func(ctx context.Context, cursor string, limit int) ([]int, string, error) {
+		limits = append(limits, limit)
+		start := 0
+		if cursor != "" {
+			start, _ = strconv.Atoi(cursor)
+		}
+		var items []int
+		for i := start; i < 25 && (limit == 0 || len(items) < limit); i++ {
+			items = append(items, i)
+		}
+		next := start + len(items)
+		if next == 25 {
+			return items, "", nil
+		}
+		return items, strconv.Itoa(next), nil
+	}
+	collect := func(opts PageOptions) []int {
+		var out []int
+		for i, err := range Paginate(ctx, opts, fetch) {
+			if err != nil {
+				t.Fatal(err)
+			}
+			out = append(out, i)
+		}
+		return out
+	}
+
+	if out := collect(PageOptions{PageSize: 10}); len(out) != 25 || out[24] != 24 || !reflect.DeepEqual(limits, []int{10, 10, 10}) {
+		t.Fatalf("unexpected items %v, limits %v", out, limits)
+	}
+	limits = nil
+	if out := collect(PageOptions{Cursor: "5", PageSize: 10, Limit: 12}); !reflect.DeepEqual(out, []int{5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}) || !reflect.DeepEqual(limits, []int{10, 2}) {
+		t.Fatalf("unexpected items %v, limits %v", out, limits)
+	}
+	for range Paginate(ctx, PageOptions{}, fetch) {
+		break
+	}
+
+	stuck := func(ctx context.Context, cursor string, limit int) ([]int, string, error) {
+		return []int{1}, "same", nil
+	}
+	var n int
+	var err error
+	for _, err = range Paginate(ctx, PageOptions{}, stuck) {
+		n++
+	}
+	if err == nil || n != 3 {
+		t.Fatalf("expected error after two pages, got %v after %d", err, n)
+	}
+}
+
+type staticDirectory map[string]*identity.Identity
+
+func (d staticDirectory) LookupDID(ctx context.Context, did string) (*identity.Identity, error) {
+	ident, ok := d[did]
+	if !ok {
+		return nil, errors.New("DID not found")
+	}
+	return ident, nil
+}
+
+func TestProxy(t *testing.T) {
+	ctx := context.Background()
+	ref, err := ParseServiceRef("did:web:api.bsky.app#bsky_appview")
+	if err != nil || ref.DID != "did:web:api.bsky.app" || ref.Fragment != "bsky_appview" {
+		t.Fatalf("unexpected reference %+v, %v", ref, err)
+	}
+	for _, s := range []string{"did:web:api.bsky.app", "did:web:api.bsky.app#", "api.bsky.app#bsky_appview"} {
+		if _, err := ParseServiceRef(s); err == nil {
+			t.Errorf("expected %q to be invalid", s)
+		}
+	}
+	dir := staticDirectory{"did:web:api.bsky.app": {
+		DID:      "did:web:api.bsky.app",
+		Services: map[string]identity.Service{"bsky_appview": {Type: "BskyAppView", URL: "https://api.bsky.app"}},
+	}}
+	if u, err := ref.Resolve(ctx, dir); err != nil || u != "https://api.bsky.app" {
+		t.Fatalf("unexpected endpoint %q, %v", u, err)
+	}
+	if _, err := (ServiceRef{DID: "did:web:api.bsky.app", Fragment: "atproto_labeler"}).Resolve(ctx, dir); err == nil {
+		t.Fatal("expected missing service error")
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		WriteJSON(w, http.StatusOK, map[string]string{"proxy": r.Header.Get(ProxyHeader)})
+	}))
+	defer srv.Close()
+	c := &Client{Host: srv.URL, Proxy: &ref}
+	var out struct{ Proxy string }
+	if err := c.Query(ctx, "app.bsky.feed.getTimeline", nil, &out); err != nil || out.Proxy != "did:web:api.bsky.app#bsky_appview" {
+		t.Fatalf("unexpected proxy %q, %v", out.Proxy, err)
+	}
+	labeler := &ServiceRef{DID: "did:plc:labeler", Fragment: "atproto_labeler"}
+	if _, err := c.Do(ctx, &Request{NSID: "com.atproto.label.queryLabels", Proxy: labeler, Output: &out}); err != nil || out.Proxy != "did:plc:labeler#atproto_labeler" {
+		t.Fatalf("unexpected proxy %q, %v", out.Proxy, err)
+	}
+}
+
+func TestAdminAuth(t *testing.T) {
+	mux := &ServeMux{AdminPassword: "hunter2"}
+	mux.HandleProcedure("com.atproto.admin.updateSubjectStatus", func(w http.ResponseWriter, r *http.Request) (any, error) {
+		return map[string]bool{"admin": IsAdmin(r.Context())}, nil
+	})
+	mux.HandleQuery("com.atproto.repo.getRecord", func(w http.ResponseWriter, r *http.Request) (any, error) {
+		return map[string]bool{"admin": IsAdmin(r.Context())}, nil
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	ctx := context.Background()
+
+	var out struct{ Admin bool }
+	admin := &Client{Host: srv.URL, Auth: AdminAuth{Password: "hunter2"}}
+	if err := admin.Procedure(ctx, "com.atproto.admin.updateSubjectStatus", nil, map[string]any{}, &out); err != nil || !out.Admin {
+		t.Fatalf("expected admin call, got %+v, %v", out, err)
+	}
+	if err := admin.Query(ctx, "com.atproto.repo.getRecord", nil, &out); err != nil || !out.Admin {
+		t.Fatalf("expected admin call, got %+v, %v", out, err)
+	}
+	for _, c := range []*Client{
+		{Host: srv.URL},
+		{Host: srv.URL, Auth: AdminAuth{Password: "wrong"}},
+		{Host: srv.URL, Auth: bearer("hunter2")},
+	} {
+		err := c.Procedure(ctx, "com.atproto.admin.updateSubjectStatus", nil, map[string]any{}, nil)
+		if !errors.Is(err, ErrAuth) {
+			t.Errorf("expected auth error, got %v", err)
+		}
+		if err := c.Query(ctx, "com.atproto.repo.getRecord", nil, &out); err != nil || out.Admin {
+			t.Errorf("expected user call, got %+v, %v", out, err)
+		}
+	}
+
+	// admin methods are closed without admin credentials
+	mux.AdminPassword = ""
+	if err := admin.Procedure(ctx, "com.atproto.admin.updateSubjectStatus", nil, map[string]any{}, nil); !errors.Is(err, ErrAuth) {
+		t.Fatalf("expected auth error, got %v", err)
+	}
+}
+
+type sessionStore struct {
+	mtx   sync.Mutex
+	saved []*SessionData
+}
+
+func (s *sessionStore) SaveSession(ctx context.Context, data *SessionData) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.saved = append(s.saved, data)
+	return nil
+}
+
+// returns an unsigned JWT expiring at exp, unique thanks to its id
+func testJWT(id int, exp time.Time) string {
+	b64 := base64.RawURLEncoding
+	claims := fmt.Sprintf(`{"jti":"%d","exp":%d}`, id, exp.Unix())
+	return b64.EncodeToString([]byte(`{"alg":"ES256K"}`)) + "." + b64.EncodeToString([]byte(claims)) + "." + b64.EncodeToString([]byte("sig"))
+}
+
+func TestSession(t *testing.T) {
+	var mtx sync.Mutex
+	var access, refresh string
+	var issued, refreshes int
+	lifetime := time.Hour
+	issue := func(lifetime time.Duration) map[string]any {
+		issued++
+		access, refresh = testJWT(issued, time.Now().Add(lifetime)), fmt.Sprintf("refresh-%d", issued)
+		return map[string]any{"did": "did:plc:abc", "handle": "alice.test", "accessJwt": access, "refreshJwt": refresh}
+	}
+	mux := &ServeMux{}
+	mux.HandleProcedure(ServerCreateSession, func(w http.ResponseWriter, r *http.Request) (any, error) {
+		var in struct{ Identifier, Password string }
+		if err := DecodeInput(r, &in); err != nil {
+			return nil, err
+		}
+		if in.Identifier != "alice.test" || in.Password != "app-password" {
+			return nil, &Error{StatusCode: http.StatusUnauthorized, Name: "AuthenticationRequired"}
+		}
+		mtx.Lock()
+		defer mtx.Unlock()
+		return issue(lifetime), nil
+	})
+	mux.HandleProcedure(ServerRefreshSession, func(w http.ResponseWriter, r *http.Request) (any, error) {
+		mtx.Lock()
+		defer mtx.Unlock()
+		if r.Header.Get("Authorization") != "Bearer "+refresh {
+			return nil, &Error{StatusCode: http.StatusBadRequest, Name: "ExpiredToken"}
+		}
+		refreshes++
+		// let concurrent requests pile up on the refresh
+		time.Sleep(10 * time.Millisecond)
+		return issue(lifetime), nil
+	})
+	mux.HandleProcedure(ServerDeleteSession, func(w http.ResponseWriter, r *http.Request) (any, error) {
+		mtx.Lock()
+		defer mtx.Unlock()
+		access, refresh = "", ""
+		return nil, nil
+	})
+	mux.HandleQuery("com.example.whoami", func(w http.ResponseWriter, r *http.Request) (any, error) {
+		mtx.Lock()
+		defer mtx.Unlock()
+		if access == "" || r.Header.Get("Authorization") != "Bearer "+access {
+			return nil, &Error{StatusCode: http.StatusBadRequest, Name: "ExpiredToken", Message: "token has expired"}
+		}
+		return map[string]string{"did": "did:plc:abc"}, nil
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	ctx := context.Background()
+
+	c := &Client{Host: srv.URL}
+	if _, err := CreateSession(ctx, c, "alice.test", "wrong", "", nil); !errors.Is(err, ErrAuth) {
+		t.Fatalf("expected auth error, got %v", err)
+	}
+	store := &sessionStore{}
+	s, err := CreateSession(ctx, c, "alice.test", "app-password", "", store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Auth = s
+	if err := c.Query(ctx, "com.example.whoami", nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// the PDS revokes the access token: concurrent requests share one refresh
+	mtx.Lock()
+	access = "revoked"
+	mtx.Unlock()
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- c.Query(ctx, "com.example.whoami", nil, nil)
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if refreshes != 1 {
+		t.Fatalf("expected 1 refresh, got %d", refreshes)
+	}
+	// access tokens expiring within the refresh margin are refreshed before being used
+	mtx.Lock()
+	lifetime = 30 * time.Second
+	mtx.Unlock()
+	if err := s.Refresh(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Query(ctx, "com.example.whoami", nil, nil); err != nil || refreshes != 3 {
+		t.Fatalf("expected a proactive refresh, got %d refreshes, %v", refreshes, err)
+	}
+
+	resumed := ResumeSession(c, s.Data(), store)
+	if err := resumed.Delete(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Query(ctx, "com.example.whoami", nil, nil); err == nil {
+		t.Fatal("expected deleted session to fail")
+	}
+	if len(store.saved) != 5 || store.saved[0].Handle != "alice.test" || store.saved[4] != nil {
+		t.Fatalf("unexpected saved sessions %v", store.saved)
+	}
+}